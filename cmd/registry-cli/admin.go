@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// gcResult mirrors the server's garbage collection response.
+type gcResult struct {
+	DeletedBlobs int      `json:"deleted_blobs"`
+	FreedBytes   int64    `json:"freed_bytes"`
+	DryRun       bool     `json:"dry_run"`
+	Candidates   []string `json:"candidates"`
+	Partial      bool     `json:"partial"`
+	MissingFiles int      `json:"missing_files"`
+	Tombstoned   int      `json:"tombstoned"`
+	Missing      []struct {
+		Package    string `json:"package"`
+		Version    string `json:"version"`
+		File       string `json:"file"`
+		Hash       string `json:"hash"`
+		Tombstoned bool   `json:"tombstoned"`
+	} `json:"missing"`
+}
+
+// registryStats mirrors GET /api/v1/admin/stats.
+type registryStats struct {
+	Packages        int64 `json:"packages"`
+	Artifacts       int64 `json:"artifacts"`
+	ArtifactBytes   int64 `json:"artifact_bytes"`
+	UniqueBlobs     int64 `json:"unique_blobs"`
+	UniqueBlobBytes int64 `json:"unique_blob_bytes"`
+	StoredBlobs     int   `json:"stored_blobs"`
+	StoredBytes     int64 `json:"stored_bytes"`
+	ActiveTokens    int64 `json:"active_tokens"`
+}
+
+// apiToken mirrors an issued token as listed by the server. Token is only
+// set in the response to a create.
+type apiToken struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Admin     bool       `json:"admin"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Token     string     `json:"token,omitempty"`
+}
+
+// tokenUsage mirrors the body of GET /api/v1/admin/tokens/{id}/usage.
+type tokenUsage struct {
+	TokenID         int64      `json:"token_id"`
+	Requests        int64      `json:"requests"`
+	BytesUploaded   int64      `json:"bytes_uploaded"`
+	BytesDownloaded int64      `json:"bytes_downloaded"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+}
+
+func cmdGC(args []string) {
+	_, flags := parseFlags(args)
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	dryRun := hasFlag(flags, "dry-run")
+	tombstone := hasFlag(flags, "tombstone")
+
+	prompt := fmt.Sprintf("Delete all unreferenced blobs on %s?", server)
+	if tombstone {
+		prompt = fmt.Sprintf("Delete all unreferenced blobs on %s and remove versions and files whose blob is missing?", server)
+	}
+	if !dryRun && !confirm(flags, prompt) {
+		os.Exit(exitFailure)
+	}
+
+	query := url.Values{}
+	if dryRun {
+		query.Set("dry_run", "true")
+	}
+	if tombstone {
+		query.Set("tombstone", "true")
+	}
+	background := hasFlag(flags, "background")
+	if background {
+		query.Set("async", "true")
+	}
+	endpoint := adminURL(server, "/api/v1/gc")
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	if background {
+		var job adminJob
+		if err := adminRequest("POST", endpoint, token, nil, http.StatusAccepted, &job); err != nil {
+			exitAdminError(err)
+		}
+		if hasFlag(flags, "json") {
+			printJSON(job)
+			return
+		}
+		report(os.Stdout, func() {
+			fmt.Printf("Started garbage collection job %s\n", job.ID)
+			fmt.Printf("Follow it with: registry job status %s --follow\n", job.ID)
+		}, "gc", "job", job.ID)
+		return
+	}
+
+	var result gcResult
+	if err := adminRequest("POST", endpoint, token, nil, http.StatusOK, &result); err != nil {
+		exitAdminError(err)
+	}
+
+	if hasFlag(flags, "json") {
+		printJSON(result)
+		return
+	}
+	reportGCResult(result, tombstone)
+}
+
+// reportGCResult prints the outcome of a collection.
+func reportGCResult(result gcResult, tombstone bool) {
+	report(os.Stdout, func() {
+		if result.DryRun {
+			for _, hash := range result.Candidates {
+				fmt.Println(hash)
+			}
+			fmt.Printf("Would delete %d blobs, freeing %s\n", result.DeletedBlobs, formatBytes(result.FreedBytes))
+		} else {
+			fmt.Printf("Deleted %d blobs, freed %s\n", result.DeletedBlobs, formatBytes(result.FreedBytes))
+		}
+		if result.Partial {
+			fmt.Println("Stopped before scanning every blob; the next run continues where this one left off.")
+		}
+		if result.MissingFiles == 0 {
+			return
+		}
+		fmt.Printf("\n%d files have no blob in storage:\n", result.MissingFiles)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, m := range result.Missing {
+			name := m.Package + "@" + m.Version
+			if m.File != "" {
+				name += " " + m.File
+			}
+			status := ""
+			if m.Tombstoned {
+				status = "  (removed)"
+			}
+			fmt.Fprintf(tw, "  %s\t%s%s\n", name, shortHash(m.Hash), status)
+		}
+		tw.Flush()
+		if !tombstone {
+			fmt.Println("Run gc --tombstone to remove their metadata.")
+		}
+	}, "gc", "dry_run", result.DryRun, "blobs", result.DeletedBlobs, "freed", result.FreedBytes,
+		"partial", result.Partial, "missing", result.MissingFiles, "tombstoned", result.Tombstoned)
+}
+
+func cmdStats(args []string) {
+	_, flags := parseFlags(args)
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	var stats registryStats
+	if err := adminRequest("GET", adminURL(server, "/api/v1/admin/stats"), token, nil, http.StatusOK, &stats); err != nil {
+		exitAdminError(err)
+	}
+
+	if hasFlag(flags, "json") {
+		printJSON(stats)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Packages:\t%d\n", stats.Packages)
+	fmt.Fprintf(tw, "Versions:\t%d (%s)\n", stats.Artifacts, formatBytes(stats.ArtifactBytes))
+	fmt.Fprintf(tw, "Referenced blobs:\t%d (%s)\n", stats.UniqueBlobs, formatBytes(stats.UniqueBlobBytes))
+	fmt.Fprintf(tw, "Stored blobs:\t%d (%s)\n", stats.StoredBlobs, formatBytes(stats.StoredBytes))
+	fmt.Fprintf(tw, "Active tokens:\t%d\n", stats.ActiveTokens)
+	tw.Flush()
+}
+
+// quarantinedArtifact mirrors an entry of GET /api/v1/admin/quarantine.
+type quarantinedArtifact struct {
+	Package    string    `json:"package"`
+	Version    string    `json:"version"`
+	Hash       string    `json:"hash"`
+	Size       int64     `json:"size"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+func cmdQuarantine(args []string) {
+	_, flags := parseFlags(args)
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	var pending []quarantinedArtifact
+	if err := adminRequest("GET", adminURL(server, "/api/v1/admin/quarantine"), token, nil, http.StatusOK, &pending); err != nil {
+		exitAdminError(err)
+	}
+
+	if hasFlag(flags, "json") {
+		printJSON(pending)
+		return
+	}
+	if len(pending) == 0 {
+		fmt.Println("No versions awaiting approval")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tVERSION\tHASH\tSIZE\tUPLOADED")
+	for _, a := range pending {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", a.Package, a.Version, shortHash(a.Hash), formatBytes(a.Size), a.UploadedAt.Format(time.RFC3339))
+	}
+	tw.Flush()
+}
+
+// namingViolation mirrors an entry of GET /api/v1/admin/naming/violations.
+type namingViolation struct {
+	Package  string   `json:"package"`
+	Version  string   `json:"version,omitempty"`
+	Problems []string `json:"problems"`
+}
+
+// cmdCheckNames lists the stored packages and versions whose names break
+// the server's naming rules, exiting with status 1 if there are any so it
+// can gate a migration.
+func cmdCheckNames(args []string) {
+	_, flags := parseFlags(args)
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	var violations []namingViolation
+	if err := adminRequest("GET", adminURL(server, "/api/v1/admin/naming/violations"), token, nil, http.StatusOK, &violations); err != nil {
+		exitAdminError(err)
+	}
+
+	if hasFlag(flags, "json") {
+		printJSON(violations)
+	} else if len(violations) == 0 {
+		fmt.Println("All package names and versions follow the naming rules")
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PACKAGE\tVERSION\tPROBLEMS")
+		for _, v := range violations {
+			// Quoted, so spaces and invisible characters show.
+			version := "-"
+			if v.Version != "" {
+				version = strconv.Quote(v.Version)
+			}
+			fmt.Fprintf(tw, "%q\t%s\t%s\n", v.Package, version, strings.Join(v.Problems, "; "))
+		}
+		tw.Flush()
+	}
+	if len(violations) > 0 {
+		os.Exit(exitFailure)
+	}
+}
+
+func cmdApprove(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: registry approve <package> <version>")
+		os.Exit(exitUsage)
+	}
+
+	pkg, version := pos[0], pos[1]
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	if err := adminRequest("POST", artifactURL(server, pkg, version)+"/approve", token, nil, http.StatusOK, nil); err != nil {
+		exitAdminError(err)
+	}
+	report(os.Stdout, func() {
+		fmt.Printf("Approved %s@%s\n", pkg, version)
+	}, "approve", "package", pkg, "version", version)
+}
+
+func cmdPromote(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: registry promote <package> <version> [--stage staging|release]")
+		os.Exit(exitUsage)
+	}
+
+	pkg, version := pos[0], pos[1]
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	body, _ := json.Marshal(map[string]string{"stage": getFlag(flags, "stage", "release")})
+	var promoted artifactDetail
+	if err := adminRequest("POST", artifactURL(server, pkg, version)+"/promote", token, body, http.StatusOK, &promoted); err != nil {
+		exitAdminError(err)
+	}
+	report(os.Stdout, func() {
+		fmt.Printf("Promoted %s@%s to %s\n", pkg, version, promoted.Stage)
+	}, "promote", "package", pkg, "version", version, "stage", promoted.Stage, "promoted_by", promoted.PromotedBy)
+}
+
+func cmdToken(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: registry token <create|list|revoke|usage> ...")
+		os.Exit(exitUsage)
+	}
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	endpoint := adminURL(server, "/api/v1/admin/tokens")
+
+	switch pos[0] {
+	case "create":
+		if len(pos) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: registry token create <name> [--admin]")
+			os.Exit(exitUsage)
+		}
+		body, _ := json.Marshal(map[string]any{"name": pos[1], "admin": hasFlag(flags, "admin")})
+		var created apiToken
+		if err := adminRequest("POST", endpoint, token, body, http.StatusCreated, &created); err != nil {
+			exitAdminError(err)
+		}
+		if hasFlag(flags, "json") {
+			printJSON(created)
+			return
+		}
+		// The secret goes to stdout alone so it can be captured with $(...).
+		fmt.Fprintf(os.Stderr, "Created token %d (%s). It will not be shown again:\n", created.ID, created.Name)
+		fmt.Println(created.Token)
+
+	case "list":
+		var tokens []apiToken
+		if err := adminRequest("GET", endpoint, token, nil, http.StatusOK, &tokens); err != nil {
+			exitAdminError(err)
+		}
+		if hasFlag(flags, "json") {
+			printJSON(tokens)
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tADMIN\tCREATED\tREVOKED")
+		for _, t := range tokens {
+			revoked := "-"
+			if t.RevokedAt != nil {
+				revoked = t.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%t\t%s\t%s\n", t.ID, t.Name, t.Admin, t.CreatedAt.Format(time.RFC3339), revoked)
+		}
+		tw.Flush()
+
+	case "revoke":
+		if len(pos) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: registry token revoke <id> [--yes]")
+			os.Exit(exitUsage)
+		}
+		id := pos[1]
+		if !confirm(flags, fmt.Sprintf("Revoke token %s on %s?", id, server)) {
+			os.Exit(exitFailure)
+		}
+		if err := adminRequest("DELETE", endpoint+"/"+id, token, nil, http.StatusOK, nil); err != nil {
+			exitAdminError(err)
+		}
+		report(os.Stdout, func() {
+			fmt.Printf("Revoked token %s\n", id)
+		}, "token-revoke", "id", id)
+
+	case "usage":
+		if len(pos) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: registry token usage <id>")
+			os.Exit(exitUsage)
+		}
+		var usage tokenUsage
+		if err := adminRequest("GET", endpoint+"/"+pos[1]+"/usage", token, nil, http.StatusOK, &usage); err != nil {
+			exitAdminError(err)
+		}
+		if hasFlag(flags, "json") {
+			printJSON(usage)
+			return
+		}
+		lastUsed := "never"
+		if usage.LastUsedAt != nil {
+			lastUsed = usage.LastUsedAt.Format(time.RFC3339)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Requests:\t%d\n", usage.Requests)
+		fmt.Fprintf(tw, "Uploaded:\t%s\n", formatBytes(usage.BytesUploaded))
+		fmt.Fprintf(tw, "Downloaded:\t%s\n", formatBytes(usage.BytesDownloaded))
+		fmt.Fprintf(tw, "Last used:\t%s\n", lastUsed)
+		tw.Flush()
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown token command: %s\n", pos[0])
+		os.Exit(exitUsage)
+	}
+}
+
+// confirm asks before a destructive operation. --yes skips the prompt; without
+// it a non-interactive stdin is refused rather than assumed to agree.
+func confirm(flags map[string]string, prompt string) bool {
+	if hasFlag(flags, "yes") {
+		return true
+	}
+	if !isTerminal(os.Stdin) {
+		fmt.Fprintln(os.Stderr, "error: refusing to continue without confirmation; pass --yes")
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	fmt.Fprintln(os.Stderr, "Aborted")
+	return false
+}
+
+// adminRequest sends an optional JSON body and decodes the response into out
+// when the server answers with want.
+func adminRequest(method, url, token string, body []byte, want int, out any) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		return newHTTPError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// exitAdminError prints err, without an extra prefix for server errors, and
+// exits.
+func exitAdminError(err error) {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		fmt.Fprintln(os.Stderr, httpErr.Error())
+	} else {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	}
+	os.Exit(errorExitCode(err))
+}
+
+func adminURL(server, path string) string {
+	return strings.TrimRight(server, "/") + path
+}
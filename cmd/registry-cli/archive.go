@@ -0,0 +1,233 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveEntry is one file in a POST /api/v1/archive request or in the
+// manifest.json that leads the archive.
+type archiveEntry struct {
+	Package string `json:"package"`
+	Version string `json:"version"`
+	File    string `json:"file,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+}
+
+type archiveRequest struct {
+	Format    string         `json:"format,omitempty"`
+	Artifacts []archiveEntry `json:"artifacts"`
+}
+
+// cmdPullAll downloads several artifacts in one request. By default the
+// archive is unpacked into --output as <package>/<version>/<file>, checking
+// every file against the hashes in its manifest; --archive saves the tar or
+// zip as is instead.
+func cmdPullAll(args []string) {
+	pos, flags := parseFlags(args)
+
+	var entries []archiveEntry
+	if hasFlag(flags, "manifest") {
+		bulk, err := loadBulkManifest(flags["manifest"], false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		for _, e := range bulk {
+			entries = append(entries, archiveEntry{Package: e.Package, Version: e.Version, File: e.Asset})
+		}
+	}
+	for _, arg := range pos {
+		i := strings.LastIndex(arg, "@")
+		if i <= 0 || i == len(arg)-1 {
+			fmt.Fprintf(os.Stderr, "error: %q is not <package>@<version>\n", arg)
+			os.Exit(exitUsage)
+		}
+		entries = append(entries, archiveEntry{Package: arg[:i], Version: arg[i+1:]})
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: registry pull-all <package>@<version>... [--manifest FILE] [--output DIR] [--archive FILE|-] [--format tar|zip]")
+		os.Exit(exitUsage)
+	}
+
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	archivePath := getFlag(flags, "archive", "")
+	format := "tar"
+	if archivePath != "" {
+		if strings.EqualFold(filepath.Ext(archivePath), ".zip") {
+			format = "zip"
+		}
+		format = getFlag(flags, "format", format)
+	} else if hasFlag(flags, "format") {
+		fmt.Fprintln(os.Stderr, "error: --format applies only with --archive")
+		os.Exit(exitUsage)
+	}
+
+	body, err := json.Marshal(archiveRequest{Format: format, Artifacts: entries})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	req, err := http.NewRequest("POST", adminURL(server, "/api/v1/archive"), bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating request: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitNetwork)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		exitHTTPError(resp)
+	}
+
+	if archivePath != "" {
+		n, err := saveArchive(resp.Body, archivePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error downloading archive: %v\n", err)
+			os.Exit(errorExitCode(err))
+		}
+		elapsed := time.Since(start)
+		w := os.Stdout
+		if archivePath == "-" {
+			w = os.Stderr
+		}
+		report(w, func() {
+			fmt.Fprintf(w, "Pulled %d artifacts -> %s (%s) in %v\n", len(entries), archivePath, formatBytes(n), elapsed.Round(time.Millisecond))
+		}, "pull-all", "artifacts", len(entries), "archive", archivePath, "size", n, "duration", elapsed)
+		return
+	}
+
+	dir := getFlag(flags, "output", ".")
+	files, err := extractArchive(resp.Body, dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(errorExitCode(err))
+	}
+	elapsed := time.Since(start)
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	report(os.Stdout, func() {
+		for _, f := range files {
+			fmt.Printf("  %s@%s -> %s\n", f.Package, f.Version, filepath.Join(dir, filepath.FromSlash(f.Path)))
+		}
+		fmt.Printf("Pulled %d files (%s) in %v\n", len(files), formatBytes(total), elapsed.Round(time.Millisecond))
+	}, "pull-all", "files", len(files), "output", dir, "size", total, "duration", elapsed)
+}
+
+// saveArchive writes a download to path, or to stdout for "-". Files are
+// written beside the target and renamed once complete.
+func saveArchive(body io.Reader, path string) (int64, error) {
+	if path == "-" {
+		return io.Copy(os.Stdout, body)
+	}
+	tmpPath := path + ".part"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return n, err
+	}
+	return n, replaceFile(tmpPath, path)
+}
+
+// extractArchive unpacks a tar archive into dir, verifying each file against
+// the leading manifest.json. A truncated archive is an error, since the
+// server drops the connection if it fails partway.
+func extractArchive(body io.Reader, dir string) ([]archiveEntry, error) {
+	tr := tar.NewReader(body)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != "manifest.json" {
+		return nil, errors.New("archive does not start with manifest.json")
+	}
+	var manifest struct {
+		Artifacts []archiveEntry `json:"artifacts"`
+	}
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("reading archive manifest: %w", err)
+	}
+	pending := make(map[string]archiveEntry, len(manifest.Artifacts))
+	for _, e := range manifest.Artifacts {
+		pending[e.Path] = e
+	}
+
+	var extracted []archiveEntry
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return extracted, fmt.Errorf("reading archive: %w", err)
+		}
+		entry, ok := pending[hdr.Name]
+		if !ok || !filepath.IsLocal(filepath.FromSlash(hdr.Name)) {
+			return extracted, fmt.Errorf("unexpected archive entry %q", hdr.Name)
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if err := extractFile(tr, dest, entry); err != nil {
+			return extracted, fmt.Errorf("%s@%s: %w", entry.Package, entry.Version, err)
+		}
+		delete(pending, hdr.Name)
+		extracted = append(extracted, entry)
+	}
+	if len(pending) > 0 {
+		return extracted, fmt.Errorf("archive is missing %d of %d files", len(pending), len(manifest.Artifacts))
+	}
+	return extracted, nil
+}
+
+// extractFile writes one archive member to dest and checks its hash.
+func extractFile(r io.Reader, dest string, entry archiveEntry) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	tmpPath := dest + ".part"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, hasher), r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if hash := hex.EncodeToString(hasher.Sum(nil)); hash != entry.Hash || n != entry.Size {
+			err = fmt.Errorf("%w (got %s, want %s)", errHashMismatch, hash, entry.Hash)
+		}
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return replaceFile(tmpPath, dest)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// artifactFilterFlags maps CLI flags to the artifact listing's filters.
+var artifactFilterFlags = map[string]string{
+	"package":         "package",
+	"uploaded-after":  "uploaded_after",
+	"uploaded-before": "uploaded_before",
+	"min-size":        "min_size",
+	"max-size":        "max_size",
+	"hash":            "hash",
+	"license":         "license",
+	"format":          "format",
+	"channel":         "channel",
+}
+
+// cmdArtifacts lists versions across packages, newest first, filtered on
+// the server.
+func cmdArtifacts(args []string) {
+	_, flags := parseFlags(args)
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	query := url.Values{}
+	for flag, param := range artifactFilterFlags {
+		if v := getFlag(flags, flag, ""); v != "" {
+			query.Set(param, v)
+		}
+	}
+
+	// Follow every page so the listing is complete.
+	type listedArtifact struct {
+		Package string `json:"package"`
+		artifactDetail
+	}
+	all := []listedArtifact{}
+	for {
+		var page struct {
+			Artifacts  []listedArtifact `json:"artifacts"`
+			NextCursor string           `json:"next_cursor"`
+		}
+		endpoint := adminURL(server, "/api/v1/artifacts")
+		if len(query) > 0 {
+			endpoint += "?" + query.Encode()
+		}
+		if err := adminRequest("GET", endpoint, token, nil, http.StatusOK, &page); err != nil {
+			exitAdminError(err)
+		}
+		all = append(all, page.Artifacts...)
+		if page.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+
+	if hasFlag(flags, "json") {
+		printJSON(all)
+		return
+	}
+	if len(all) == 0 {
+		fmt.Println("No artifacts match.")
+		return
+	}
+	var total int64
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tVERSION\tHASH\tSIZE\tUPLOADED")
+	for _, a := range all {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", a.Package, a.Version, shortHash(a.Hash), formatBytes(a.Size), a.UploadedAt.Format(time.RFC3339))
+		total += a.Size
+	}
+	tw.Flush()
+	fmt.Printf("%d versions, %s\n", len(all), formatBytes(total))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// batchDeleteFilter mirrors the filter of a batch delete.
+type batchDeleteFilter struct {
+	Prefix    string `json:"prefix,omitempty"`
+	OlderThan string `json:"older_than,omitempty"`
+	Channel   string `json:"channel,omitempty"`
+}
+
+// batchDeleteRef names one version to delete.
+type batchDeleteRef struct {
+	Package string `json:"package"`
+	Version string `json:"version"`
+}
+
+// batchDeleteResult mirrors the outcome for one selected version.
+type batchDeleteResult struct {
+	Package  string `json:"package"`
+	Version  string `json:"version"`
+	Size     int64  `json:"size,omitempty"`
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+	Approval int64  `json:"approval,omitempty"`
+}
+
+// batchDeleteResponse mirrors the answer to a batch delete.
+type batchDeleteResponse struct {
+	DryRun    bool                `json:"dry_run"`
+	Confirm   string              `json:"confirm,omitempty"`
+	Artifacts []batchDeleteResult `json:"artifacts"`
+	Deleted   int                 `json:"deleted"`
+}
+
+// cmdBatchDelete deletes the listed versions, or those matching the
+// filter flags, in one request. It previews the selection first and only
+// deletes once that is confirmed; --dry-run stops after the preview.
+func cmdBatchDelete(args []string) {
+	pos, flags := parseFlags(args)
+	filter := batchDeleteFilter{
+		Prefix:    getFlag(flags, "prefix", ""),
+		OlderThan: getFlag(flags, "older-than", ""),
+		Channel:   getFlag(flags, "channel", ""),
+	}
+	hasFilter := filter != batchDeleteFilter{}
+	if (len(pos) > 0) == hasFilter {
+		fmt.Fprintln(os.Stderr, "usage: registry batch-delete <package>@<version>... [--dry-run] [--yes]")
+		fmt.Fprintln(os.Stderr, "       registry batch-delete [--prefix <p>] [--older-than <age>] [--channel <level>] [--dry-run] [--yes]")
+		os.Exit(exitUsage)
+	}
+
+	req := map[string]any{}
+	if hasFilter {
+		req["filter"] = filter
+	} else {
+		refs := make([]batchDeleteRef, 0, len(pos))
+		for _, arg := range pos {
+			pkg, version, ok := strings.Cut(arg, "@")
+			if !ok || pkg == "" || version == "" {
+				fmt.Fprintf(os.Stderr, "error: invalid version %q: expected <package>@<version>\n", arg)
+				os.Exit(exitUsage)
+			}
+			refs = append(refs, batchDeleteRef{Package: pkg, Version: version})
+		}
+		req["artifacts"] = refs
+	}
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	endpoint := adminURL(server, "/api/v1/artifacts/batch-delete")
+
+	body, _ := json.Marshal(req)
+	var preview batchDeleteResponse
+	if err := adminRequest("POST", endpoint, token, body, http.StatusOK, &preview); err != nil {
+		exitAdminError(err)
+	}
+	pending := 0
+	for _, res := range preview.Artifacts {
+		if res.Status == "pending" {
+			pending++
+		}
+	}
+	if hasFlag(flags, "dry-run") || pending == 0 {
+		if hasFlag(flags, "json") {
+			printJSON(preview)
+			return
+		}
+		report(os.Stdout, func() {
+			printBatchDelete(preview.Artifacts)
+			fmt.Printf("Would delete %d of %d selected versions\n", pending, len(preview.Artifacts))
+		}, "batch-delete", "dry_run", true, "selected", len(preview.Artifacts), "pending", pending)
+		return
+	}
+
+	if !hasFlag(flags, "json") && !hasFlag(flags, "quiet") {
+		printBatchDelete(preview.Artifacts)
+	}
+	if !confirm(flags, fmt.Sprintf("Delete %d versions on %s?", pending, server)) {
+		os.Exit(exitFailure)
+	}
+	req["confirm"] = preview.Confirm
+	body, _ = json.Marshal(req)
+	var result batchDeleteResponse
+	if err := adminRequest("POST", endpoint, token, body, http.StatusOK, &result); err != nil {
+		exitAdminError(err)
+	}
+
+	failed := 0
+	for _, res := range result.Artifacts {
+		if res.Status == "failed" {
+			failed++
+		}
+	}
+	if hasFlag(flags, "json") {
+		printJSON(result)
+	} else {
+		report(os.Stdout, func() {
+			printBatchDelete(result.Artifacts)
+			fmt.Printf("Deleted %d of %d selected versions\n", result.Deleted, len(result.Artifacts))
+		}, "batch-delete", "selected", len(result.Artifacts), "deleted", result.Deleted, "failed", failed)
+	}
+	if failed > 0 {
+		os.Exit(exitFailure)
+	}
+}
+
+// printBatchDelete lists the outcome for each selected version.
+func printBatchDelete(results []batchDeleteResult) {
+	if len(results) == 0 {
+		fmt.Println("No versions selected")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tVERSION\tSIZE\tSTATUS\tNOTE")
+	for _, res := range results {
+		note := res.Message
+		if res.Approval != 0 {
+			note = fmt.Sprintf("awaiting approval %d", res.Approval)
+		}
+		if note == "" {
+			note = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", res.Package, res.Version, formatBytes(res.Size), res.Status, note)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,688 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// registry browse is a full-screen view over the endpoints list, search,
+// info and pull use: the package listing a page at a time, then a package's
+// versions and a version's details, from which a version can be pulled into
+// the current directory.
+
+// browsePageSize is how many packages are fetched at a time; the next page
+// is fetched when the selection nears the end of those loaded.
+const browsePageSize = 100
+
+type browseView int
+
+const (
+	viewPackages browseView = iota
+	viewVersions
+	viewDetail
+)
+
+// browseScreen is one level of the browser. Screens stack as they are
+// opened and are popped going back.
+type browseScreen struct {
+	view  browseView
+	title string
+	// header is drawn above rows and does not scroll.
+	header string
+	rows   []string
+	// cursor is the selected row; detail screens have none and top alone
+	// scrolls them.
+	cursor, top int
+
+	// search and cursor of the next page, if any, for a package list.
+	search, next string
+	packages     []packageSummary
+	// pkg and versions for a package's versions and a version's details.
+	pkg      string
+	versions []artifactDetail
+}
+
+type packageSummary struct {
+	Name       string       `json:"name"`
+	Deprecated *deprecation `json:"deprecated,omitempty"`
+}
+
+// browsePull is a pull running while the browser stays responsive.
+type browsePull struct {
+	label, output string
+	size          int64
+	counter       *atomic.Int64
+	start         time.Time
+	result        *pullResult
+	done          chan error
+}
+
+type browser struct {
+	server, token string
+	flags         map[string]string
+	out           *bufio.Writer
+	screens       []*browseScreen
+	// status is the message line; input, when set, is the search being
+	// typed in its place.
+	status string
+	input  *string
+	pull   *browsePull
+	// quitting is set once q was pressed during a pull, which a second q
+	// abandons.
+	quitting      bool
+	width, height int
+}
+
+func cmdBrowse(args []string) {
+	_, flags := parseFlags(args)
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		fmt.Fprintln(os.Stderr, "error: registry browse needs an interactive terminal")
+		os.Exit(exitUsage)
+	}
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	b := &browser{server: server, token: token, flags: flags, out: bufio.NewWriter(os.Stdout)}
+	// Load the first page before taking over the screen, so a wrong server
+	// or token fails the way other commands do.
+	first, err := b.packageList("")
+	if err != nil {
+		exitBrowseError(err)
+	}
+	b.screens = []*browseScreen{first}
+	// Pulls report in the status line instead.
+	showProgress = false
+
+	if err := b.run(); err != nil {
+		exitBrowseError(err)
+	}
+}
+
+func exitBrowseError(err error) {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		fmt.Fprintln(os.Stderr, httpErr.Error())
+	} else {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	}
+	os.Exit(errorExitCode(err))
+}
+
+// run switches the terminal to raw mode on the alternate screen, handles
+// keys until the user quits, and puts the terminal back.
+func (b *browser) run() error {
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	fmt.Fprint(b.out, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(b.out, "\x1b[?25h\x1b[?1049l")
+		b.out.Flush()
+	}()
+
+	keys := make(chan string)
+	go readKeys(os.Stdin, keys)
+	// The tick redraws pull progress and notices a resized terminal.
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	b.draw()
+	for {
+		var pullDone chan error
+		if b.pull != nil {
+			pullDone = b.pull.done
+		}
+		select {
+		case key, ok := <-keys:
+			if !ok || b.handleKey(key) {
+				return nil
+			}
+		case err := <-pullDone:
+			b.finishPull(err)
+		case <-ticker.C:
+			if w, h := screenSize(); b.pull == nil && w == b.width && h == b.height {
+				continue
+			}
+		}
+		b.draw()
+	}
+}
+
+// handleKey acts on one key and reports whether to quit.
+func (b *browser) handleKey(key string) bool {
+	if b.input != nil {
+		b.editSearch(key)
+		return false
+	}
+	if key != "q" {
+		b.quitting = false
+	}
+	s := b.screen()
+	switch key {
+	case "q", "ctrl-c":
+		if b.pull == nil || b.quitting || key == "ctrl-c" {
+			return true
+		}
+		b.quitting = true
+		b.status = "A pull is running; press q again to quit and keep the partial download"
+	case "up", "k":
+		b.move(-1)
+	case "down", "j":
+		b.move(1)
+	case "pgup":
+		b.move(-b.bodyHeight(s))
+	case "pgdn", " ":
+		b.move(b.bodyHeight(s))
+	case "home", "g":
+		b.move(-len(s.rows))
+	case "end", "G":
+		b.move(len(s.rows))
+	case "enter", "right", "l":
+		b.open()
+	case "esc", "left", "h", "backspace":
+		if len(b.screens) > 1 {
+			b.screens = b.screens[:len(b.screens)-1]
+			b.status = ""
+		}
+	case "/":
+		input := ""
+		b.input = &input
+	case "p":
+		b.startPull()
+	case "r":
+		b.reload()
+	}
+	return false
+}
+
+// editSearch handles a key while a search is typed: Enter runs it, Esc
+// drops it.
+func (b *browser) editSearch(key string) {
+	switch key {
+	case "enter":
+		query := strings.TrimSpace(*b.input)
+		b.input = nil
+		if query == "" {
+			return
+		}
+		s, err := b.packageList(query)
+		if err != nil {
+			b.status = errorStatus(err)
+			return
+		}
+		b.push(s)
+	case "esc", "ctrl-c":
+		b.input = nil
+	case "backspace":
+		if text := *b.input; text != "" {
+			_, size := utf8.DecodeLastRuneInString(text)
+			*b.input = text[:len(text)-size]
+		}
+	default:
+		if utf8.RuneCountInString(key) == 1 && key >= " " {
+			*b.input += key
+		}
+	}
+}
+
+func (b *browser) screen() *browseScreen { return b.screens[len(b.screens)-1] }
+
+func (b *browser) push(s *browseScreen) {
+	b.screens = append(b.screens, s)
+	b.status = ""
+}
+
+// move shifts the selection by delta rows, or scrolls a detail screen, and
+// fetches the next page of packages when the selection nears the end.
+func (b *browser) move(delta int) {
+	s := b.screen()
+	if s.view == viewDetail {
+		s.top = max(0, min(s.top+delta, len(s.rows)-b.bodyHeight(s)))
+		return
+	}
+	s.cursor = max(0, min(s.cursor+delta, len(s.rows)-1))
+	if s.view == viewPackages && s.next != "" && s.cursor >= len(s.rows)-b.bodyHeight(s) {
+		if err := b.morePackages(s); err != nil {
+			b.status = errorStatus(err)
+		}
+	}
+}
+
+// open descends into the selected package or version.
+func (b *browser) open() {
+	s := b.screen()
+	if len(s.rows) == 0 {
+		return
+	}
+	switch s.view {
+	case viewPackages:
+		next, err := b.versionList(s.packages[s.cursor].Name)
+		if err != nil {
+			b.status = errorStatus(err)
+			return
+		}
+		b.push(next)
+	case viewVersions:
+		b.push(b.versionDetail(s.pkg, s.versions, s.cursor))
+	}
+}
+
+// reload fetches the current screen again, keeping the selection where it
+// can.
+func (b *browser) reload() {
+	s := b.screen()
+	var fresh *browseScreen
+	var err error
+	switch s.view {
+	case viewPackages:
+		fresh, err = b.packageList(s.search)
+	case viewVersions:
+		fresh, err = b.versionList(s.pkg)
+	case viewDetail:
+		var versions *browseScreen
+		if versions, err = b.versionList(s.pkg); err == nil {
+			i := indexVersion(versions.versions, s.versions[0].Version)
+			if i < 0 {
+				err = fmt.Errorf("%s@%s no longer exists", s.pkg, s.versions[0].Version)
+				break
+			}
+			fresh = b.versionDetail(s.pkg, versions.versions, i)
+		}
+	}
+	if err != nil {
+		b.status = errorStatus(err)
+		return
+	}
+	fresh.cursor = min(s.cursor, max(len(fresh.rows)-1, 0))
+	fresh.top = s.top
+	b.screens[len(b.screens)-1] = fresh
+	b.status = "Reloaded"
+}
+
+func indexVersion(versions []artifactDetail, version string) int {
+	for i, v := range versions {
+		if v.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// packageList loads the first page of packages, or of those matching
+// search.
+func (b *browser) packageList(search string) (*browseScreen, error) {
+	s := &browseScreen{view: viewPackages, title: "Packages", search: search}
+	if search != "" {
+		s.title = fmt.Sprintf("Packages matching %q", search)
+	}
+	if err := b.morePackages(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// morePackages appends the next page of packages to s.
+func (b *browser) morePackages(s *browseScreen) error {
+	query := url.Values{"limit": {strconv.Itoa(browsePageSize)}}
+	if s.search != "" {
+		query.Set("search", s.search)
+	}
+	if s.next != "" {
+		query.Set("cursor", s.next)
+	}
+	req, _ := http.NewRequest("GET", packagesURL(b.server)+"?"+query.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	resp, err := getCached(b.flags, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newHTTPError(resp)
+	}
+	var page []packageSummary
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+
+	s.next = nextCursor(resp)
+	s.packages = append(s.packages, page...)
+	for _, p := range page {
+		row := p.Name
+		if p.Deprecated != nil {
+			row += "  (deprecated: " + p.Deprecated.String() + ")"
+		}
+		s.rows = append(s.rows, row)
+	}
+	return nil
+}
+
+// nextCursor takes the cursor from a rel="next" Link header. Only the
+// cursor is kept, so later pages go to --server even if the server links
+// its public URL.
+func nextCursor(resp *http.Response) string {
+	for _, link := range strings.Split(resp.Header.Get("Link"), ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		next, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err == nil {
+			return next.Query().Get("cursor")
+		}
+	}
+	return ""
+}
+
+// versionList loads a package's versions, staged ones included, as info
+// lists them.
+func (b *browser) versionList(pkg string) (*browseScreen, error) {
+	req, _ := http.NewRequest("GET", packageURL(b.server, pkg)+"?stage=all", nil)
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	resp, err := getCached(b.flags, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(resp)
+	}
+	var info struct {
+		Name       string           `json:"name"`
+		Latest     string           `json:"latest,omitempty"`
+		Versions   []artifactDetail `json:"versions"`
+		Deprecated *deprecation     `json:"deprecated,omitempty"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	s := &browseScreen{view: viewVersions, pkg: info.Name, versions: info.Versions}
+	s.title = fmt.Sprintf("%s (%d versions", info.Name, len(info.Versions))
+	if info.Latest != "" {
+		s.title += ", latest " + info.Latest
+	}
+	s.title += ")"
+	if info.Deprecated != nil {
+		s.title += " DEPRECATED: " + info.Deprecated.String()
+	}
+	var table bytes.Buffer
+	printVersionTable(&table, info.Versions)
+	if lines := splitLines(table.String()); len(lines) > 0 {
+		s.header, s.rows = lines[0], lines[1:]
+	}
+	return s, nil
+}
+
+// versionDetail shows versions[i] as info does: its details, files and
+// release notes.
+func (b *browser) versionDetail(pkg string, versions []artifactDetail, i int) *browseScreen {
+	d := versions[i]
+	var text bytes.Buffer
+	printArtifactDetail(&text, pkg, d)
+	printFiles(&text, listFiles(b.server, b.token, pkg, d.Version))
+	if d.ReleaseNotes != "" {
+		fmt.Fprintln(&text)
+		printReleaseNotes(&text, d.ReleaseNotes)
+	}
+	return &browseScreen{
+		view:     viewDetail,
+		title:    pkg + "@" + d.Version,
+		rows:     splitLines(text.String()),
+		pkg:      pkg,
+		versions: []artifactDetail{d},
+	}
+}
+
+func splitLines(text string) []string {
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// startPull pulls the selected version into the current directory, named
+// as pull names it, in the background.
+func (b *browser) startPull() {
+	s := b.screen()
+	if s.view == viewPackages {
+		b.status = "Open a package to pull one of its versions"
+		return
+	}
+	if b.pull != nil {
+		b.status = "A pull is already running"
+		return
+	}
+	if len(s.versions) == 0 {
+		return
+	}
+	d := s.versions[0]
+	if s.view == viewVersions {
+		d = s.versions[s.cursor]
+	}
+
+	p := &browsePull{
+		label:   s.pkg + "@" + d.Version,
+		output:  pullOutputName(s.pkg, d.Version, d.Filename),
+		size:    d.Size,
+		counter: new(atomic.Int64),
+		start:   time.Now(),
+		done:    make(chan error, 1),
+	}
+	url := artifactURL(b.server, s.pkg, d.Version)
+	go func() {
+		result, err := pullToFile(url, b.token, p.output, true, p.counter)
+		p.result = result
+		p.done <- err
+	}()
+	b.pull = p
+}
+
+func (b *browser) finishPull(err error) {
+	p := b.pull
+	b.pull = nil
+	b.quitting = false
+	if err != nil {
+		b.status = fmt.Sprintf("Pulling %s failed: %s", p.label, errorStatus(err))
+		return
+	}
+	b.status = fmt.Sprintf("Pulled %s -> %s (%s in %v)", p.label, p.output,
+		formatBytes(p.result.size), time.Since(p.start).Round(time.Millisecond))
+}
+
+// errorStatus is err for the status line, which has no room for the
+// "error" prefix of an HTTP error.
+func errorStatus(err error) string {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		if _, msg, ok := strings.Cut(httpErr.msg, ": "); ok {
+			return msg
+		}
+	}
+	return err.Error()
+}
+
+// screenSize is the terminal's size, or 80x24 if it cannot be read.
+func screenSize() (width, height int) {
+	width, height, err := terminalSize(os.Stdout)
+	if err != nil || width < 20 || height < 5 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// bodyHeight is how many rows of s fit between the title, header, status
+// and key help lines.
+func (b *browser) bodyHeight(s *browseScreen) int {
+	h := b.height - 3
+	if s.header != "" {
+		h--
+	}
+	return max(h, 1)
+}
+
+// draw repaints the whole screen: title, rows, status line and key help.
+func (b *browser) draw() {
+	b.width, b.height = screenSize()
+	s := b.screen()
+	body := b.bodyHeight(s)
+	if s.view != viewDetail {
+		if s.cursor < s.top {
+			s.top = s.cursor
+		}
+		if s.cursor >= s.top+body {
+			s.top = s.cursor - body + 1
+		}
+	}
+
+	fmt.Fprint(b.out, "\x1b[H")
+	title := strings.TrimRight(b.server, "/") + "  " + s.title
+	if s.view == viewPackages {
+		count := strconv.Itoa(len(s.rows))
+		if s.next != "" {
+			count += "+"
+		}
+		title += " (" + count + ")"
+	}
+	b.line("\x1b[7m", title, true)
+	if s.header != "" {
+		b.line("\x1b[1m", s.header, false)
+	}
+	for i := s.top; i < s.top+body; i++ {
+		switch {
+		case i >= len(s.rows):
+			b.line("", "", false)
+		case i == s.cursor && s.view != viewDetail:
+			b.line("\x1b[7m", s.rows[i], true)
+		default:
+			b.line("", s.rows[i], false)
+		}
+	}
+
+	switch {
+	case b.input != nil:
+		b.line("", "Search: "+*b.input, false)
+	case b.pull != nil && !b.quitting:
+		b.line("", b.pullStatus(), false)
+	case len(s.rows) == 0 && b.status == "":
+		b.line("", "Nothing here", false)
+	default:
+		b.line("", b.status, false)
+	}
+	help := "↑↓ move  enter open  esc back  / search  p pull  r reload  q quit"
+	if b.input != nil {
+		help = "enter search  esc cancel"
+	}
+	fmt.Fprint(b.out, "\x1b[2m"+clip(help, b.width)+"\x1b[0m\x1b[K")
+	b.out.Flush()
+}
+
+// line draws one line clipped to the screen, padded to its width when
+// style is to show across it, such as the selection bar.
+func (b *browser) line(style, text string, pad bool) {
+	text = clip(text, b.width)
+	if pad {
+		text += strings.Repeat(" ", b.width-utf8.RuneCountInString(text))
+	}
+	if style != "" {
+		text = style + text + "\x1b[0m"
+	}
+	fmt.Fprint(b.out, text+"\x1b[K\r\n")
+}
+
+func clip(text string, width int) string {
+	if utf8.RuneCountInString(text) <= width {
+		return text
+	}
+	return string([]rune(text)[:width-1]) + "…"
+}
+
+func (b *browser) pullStatus() string {
+	p := b.pull
+	done := p.counter.Load()
+	if p.size <= 0 {
+		return fmt.Sprintf("Pulling %s -> %s  %s", p.label, p.output, formatBytes(done))
+	}
+	return fmt.Sprintf("Pulling %s -> %s  %d%% (%s of %s)", p.label, p.output,
+		done*100/p.size, formatBytes(done), formatBytes(p.size))
+}
+
+// readKeys sends the keys typed on r, named as handleKey expects, until r
+// fails.
+func readKeys(r *os.File, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, key := range splitKeys(buf[:n]) {
+			keys <- key
+		}
+	}
+}
+
+// csiKeys names the final bytes of the escape sequences sent for cursor
+// and paging keys, as ESC [ or ESC O followed by the byte, or by a number
+// and "~".
+var csiKeys = map[string]string{
+	"A": "up", "B": "down", "C": "right", "D": "left", "H": "home", "F": "end",
+	"1~": "home", "4~": "end", "5~": "pgup", "6~": "pgdn", "7~": "home", "8~": "end",
+}
+
+// splitKeys names the keys in one read from the terminal, which holds
+// whole escape sequences as terminals send them in one write. A lone ESC
+// is the Escape key.
+func splitKeys(in []byte) []string {
+	var keys []string
+	for len(in) > 0 {
+		switch c := in[0]; {
+		case c == 0x1b && len(in) > 2 && (in[1] == '[' || in[1] == 'O'):
+			// Parameters run up to a final byte in @ to ~.
+			end := 2
+			for end < len(in) && (in[end] < 0x40 || in[end] > 0x7e) {
+				end++
+			}
+			if end == len(in) {
+				return keys
+			}
+			if key, ok := csiKeys[string(in[2:end+1])]; ok {
+				keys = append(keys, key)
+			}
+			in = in[end+1:]
+			continue
+		case c == 0x1b:
+			keys = append(keys, "esc")
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, "backspace")
+		case c == 0x03:
+			keys = append(keys, "ctrl-c")
+		case c >= ' ':
+			r, size := utf8.DecodeRune(in)
+			if r != utf8.RuneError {
+				keys = append(keys, string(r))
+			}
+			in = in[size:]
+			continue
+		}
+		in = in[1:]
+	}
+	return keys
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/foundry/registry/internal/util/dsse"
+)
+
+// A bundle is a tar archive for carrying versions to a registry that cannot
+// reach this one. It starts with bundleManifestName, a DSSE envelope of the
+// bundleManifest signed by whoever created it, followed by each blob the
+// versions use, once, as blobs/<sha256>.
+const (
+	bundleManifestName = "manifest.dsse.json"
+	bundlePayloadType  = "application/vnd.foundry.bundle.v1+json"
+)
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// bundleManifest lists the versions in a bundle.
+type bundleManifest struct {
+	CreatedAt time.Time       `json:"created_at"`
+	Source    string          `json:"source"`
+	Versions  []bundleVersion `json:"versions"`
+}
+
+// bundleVersion is a version with its default file, named files and
+// dependencies.
+type bundleVersion struct {
+	Package      string       `json:"package"`
+	Version      string       `json:"version"`
+	Hash         string       `json:"hash"`
+	Size         int64        `json:"size"`
+	Filename     string       `json:"filename,omitempty"`
+	ContentType  string       `json:"content_type,omitempty"`
+	Stage        string       `json:"stage,omitempty"`
+	Files        []fileDetail `json:"files,omitempty"`
+	Dependencies []dependency `json:"dependencies,omitempty"`
+}
+
+// bundleBlob is where a blob's bytes can be fetched from when creating a
+// bundle, or found in the bundle file when importing one.
+type bundleBlob struct {
+	hash   string
+	size   int64
+	url    string
+	offset int64
+}
+
+func cmdBundle(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: registry bundle <create|import> ...")
+		os.Exit(exitUsage)
+	}
+	switch pos[0] {
+	case "create":
+		bundleCreate(flags)
+	case "import":
+		if len(pos) < 2 || !hasFlag(flags, "verify-key") {
+			fmt.Fprintln(os.Stderr, "usage: registry bundle import <bundle> --verify-key <public key PEM>")
+			os.Exit(exitUsage)
+		}
+		bundleImport(pos[1], flags)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown bundle command: %s\n", pos[0])
+		os.Exit(exitUsage)
+	}
+}
+
+func bundleCreate(flags map[string]string) {
+	selections := strings.Split(getFlag(flags, "packages", ""), ",")
+	output := getFlag(flags, "output", "")
+	if selections[0] == "" || output == "" || !hasFlag(flags, "signing-key") {
+		fmt.Fprintln(os.Stderr, "usage: registry bundle create --packages <package>[@<version>],... --output <file> --signing-key <private key PEM>")
+		os.Exit(exitUsage)
+	}
+	keyPEM, err := os.ReadFile(flags["signing-key"])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading signing key: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	key, err := dsse.ParsePrivateKey(keyPEM)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: signing key: %v\n", err)
+		os.Exit(exitFailure)
+	}
+
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	start := time.Now()
+
+	manifest := bundleManifest{CreatedAt: time.Now().UTC(), Source: server}
+	for _, sel := range selections {
+		versions, err := bundleVersions(server, token, strings.TrimSpace(sel))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(errorExitCode(err))
+		}
+		manifest.Versions = append(manifest.Versions, versions...)
+	}
+	blobs := blobsToBundle(server, manifest.Versions)
+
+	payload, err := json.Marshal(manifest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	env, err := dsse.Sign(bundlePayloadType, payload, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	envelope, _ := json.Marshal(env)
+
+	tmpPath := output + ".part"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	err = writeBundle(out, envelope, blobs, token)
+	endProgress()
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = replaceFile(tmpPath, output)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		fmt.Fprintf(os.Stderr, "error writing bundle: %v\n", err)
+		os.Exit(exitFailure)
+	}
+
+	var total int64
+	for _, b := range blobs {
+		total += b.size
+	}
+	elapsed := time.Since(start)
+	report(os.Stdout, func() {
+		for _, v := range manifest.Versions {
+			fmt.Printf("  %s@%s\n", v.Package, v.Version)
+		}
+		fmt.Printf("Bundled %d versions, %d blobs (%s) -> %s in %v\n",
+			len(manifest.Versions), len(blobs), formatBytes(total), output, elapsed.Round(time.Millisecond))
+	}, "bundle-create", "versions", len(manifest.Versions), "blobs", len(blobs), "size", total,
+		"output", output, "duration", elapsed)
+}
+
+// bundleVersions looks up the versions a --packages entry selects: one
+// version, or every version of the package the token can read.
+func bundleVersions(server, token, sel string) ([]bundleVersion, error) {
+	pkg, version := sel, ""
+	if i := strings.LastIndex(sel, "@"); i > 0 {
+		pkg, version = sel[:i], sel[i+1:]
+	}
+
+	var info struct {
+		Versions []artifactDetail `json:"versions"`
+	}
+	query := url.Values{"stage": {"all"}}
+	if err := adminRequest("GET", packageURL(server, pkg)+"?"+query.Encode(), token, nil, http.StatusOK, &info); err != nil {
+		return nil, fmt.Errorf("looking up %s: %w", pkg, err)
+	}
+
+	var versions []bundleVersion
+	for _, a := range info.Versions {
+		if version != "" && a.Version != version || a.Quarantined {
+			continue
+		}
+		v := bundleVersion{
+			Package: pkg, Version: a.Version, Hash: a.Hash, Size: a.Size,
+			Filename: a.Filename, ContentType: a.ContentType, Stage: a.Stage,
+		}
+		for _, f := range listFiles(server, token, pkg, a.Version) {
+			if !f.Default {
+				v.Files = append(v.Files, f)
+			}
+		}
+		var deps dependenciesResponse
+		if err := adminRequest("GET", dependenciesURL(server, pkg, a.Version), token, nil, http.StatusOK, &deps); err != nil {
+			return nil, fmt.Errorf("reading the dependencies of %s@%s: %w", pkg, a.Version, err)
+		}
+		v.Dependencies = deps.Dependencies
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no versions of %s match %q", pkg, sel)
+	}
+	return versions, nil
+}
+
+// blobsToBundle lists each blob the versions use once, in the order they
+// are first used, with a URL to download it from.
+func blobsToBundle(server string, versions []bundleVersion) []bundleBlob {
+	var blobs []bundleBlob
+	seen := make(map[string]bool)
+	add := func(hash string, size int64, url string) {
+		if !seen[hash] {
+			seen[hash] = true
+			blobs = append(blobs, bundleBlob{hash: hash, size: size, url: url})
+		}
+	}
+	for _, v := range versions {
+		add(v.Hash, v.Size, artifactURL(server, v.Package, v.Version))
+		for _, f := range v.Files {
+			add(f.Hash, f.Size, fileURL(server, v.Package, v.Version, f.Name))
+		}
+	}
+	return blobs
+}
+
+// writeBundle writes the signed manifest and then downloads each blob into
+// the bundle, checking it against the manifest.
+func writeBundle(w io.Writer, envelope []byte, blobs []bundleBlob, token string) error {
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	hdr := &tar.Header{Name: bundleManifestName, Mode: 0o644, Size: int64(len(envelope)), ModTime: now}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(envelope); err != nil {
+		return err
+	}
+
+	var total int64
+	for _, b := range blobs {
+		total += b.size
+	}
+	counter := new(atomic.Int64)
+	for _, b := range blobs {
+		hdr := &tar.Header{Name: "blobs/" + b.hash, Mode: 0o644, Size: b.size, ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err := downloadBlob(tw, b, token, counter, total); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func downloadBlob(w io.Writer, b bundleBlob, token string, counter *atomic.Int64, total int64) error {
+	req, err := http.NewRequest("GET", b.url, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newHTTPError(resp)
+	}
+
+	hasher := sha256.New()
+	body := &progressReader{reader: resp.Body, total: total, current: counter.Load(), label: "Bundling"}
+	n, err := io.Copy(io.MultiWriter(w, hasher), io.LimitReader(body, b.size+1))
+	counter.Add(n)
+	if err != nil {
+		return err
+	}
+	if n != b.size || hex.EncodeToString(hasher.Sum(nil)) != b.hash {
+		return fmt.Errorf("%s: %w", b.url, errHashMismatch)
+	}
+	return nil
+}
+
+func bundleImport(path string, flags map[string]string) {
+	keyPEM, err := os.ReadFile(flags["verify-key"])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading verify key: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	key, err := dsse.ParsePublicKey(keyPEM)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: verify key: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	defer f.Close()
+
+	// Everything is checked before anything is uploaded.
+	manifest, blobs, err := readBundle(f, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s: %v\n", path, err)
+		os.Exit(exitFailure)
+	}
+
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	start := time.Now()
+	var imported, unchanged, failed int
+	for _, v := range manifest.Versions {
+		created, err := importVersion(server, token, f, blobs, v)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(os.Stderr, "error importing %s@%s: %v\n", v.Package, v.Version, err)
+		case created:
+			imported++
+			if !quiet {
+				fmt.Printf("  %s@%s\n", v.Package, v.Version)
+			}
+		default:
+			unchanged++
+		}
+	}
+
+	elapsed := time.Since(start)
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "Imported %d versions, %d already present, %d failed\n", imported, unchanged, failed)
+		os.Exit(exitFailure)
+	}
+	report(os.Stdout, func() {
+		fmt.Printf("Imported %d versions, %d already present, from %s (created %s on %s) in %v\n",
+			imported, unchanged, path, manifest.CreatedAt.Format(time.RFC3339), manifest.Source, elapsed.Round(time.Millisecond))
+	}, "bundle-import", "imported", imported, "unchanged", unchanged, "bundle", path, "duration", elapsed)
+}
+
+// readBundle checks the manifest's signature and every blob's hash, and
+// returns the manifest with where each blob lies in the file.
+func readBundle(f *os.File, key crypto.PublicKey) (*bundleManifest, map[string]bundleBlob, error) {
+	cr := &countingReader{reader: f, counter: new(atomic.Int64)}
+	tr := tar.NewReader(cr)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != bundleManifestName {
+		return nil, nil, fmt.Errorf("not a bundle: it does not start with %s", bundleManifestName)
+	}
+	data, err := io.ReadAll(io.LimitReader(tr, 64<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	env, payload, err := dsse.Parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if env.PayloadType != bundlePayloadType || !env.Verify(key) {
+		return nil, nil, errors.New("the manifest is not signed by the verify key")
+	}
+	var manifest bundleManifest
+	if err := json.Unmarshal(payload, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("decoding manifest: %w", err)
+	}
+
+	want := make(map[string]int64)
+	for _, v := range manifest.Versions {
+		want[v.Hash] = v.Size
+		for _, file := range v.Files {
+			want[file.Hash] = file.Size
+		}
+	}
+	blobs := make(map[string]bundleBlob)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading bundle: %w", err)
+		}
+		hash, ok := strings.CutPrefix(hdr.Name, "blobs/")
+		size, wanted := want[hash]
+		if !ok || !sha256Pattern.MatchString(hash) || !wanted || hdr.Size != size {
+			return nil, nil, fmt.Errorf("unexpected entry %s", hdr.Name)
+		}
+		offset := cr.counter.Load()
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, tr); err != nil {
+			return nil, nil, fmt.Errorf("reading %s: %w", hdr.Name, err)
+		}
+		if hex.EncodeToString(hasher.Sum(nil)) != hash {
+			return nil, nil, fmt.Errorf("%s: %w", hdr.Name, errHashMismatch)
+		}
+		blobs[hash] = bundleBlob{hash: hash, size: size, offset: offset}
+	}
+	for hash := range want {
+		if _, ok := blobs[hash]; !ok {
+			return nil, nil, fmt.Errorf("blob %s is missing", hash)
+		}
+	}
+	return &manifest, blobs, nil
+}
+
+// importVersion publishes a version from the bundle with its files and
+// dependencies. A version already present with the same default file is
+// left as it is, reporting created=false.
+func importVersion(server, token string, f *os.File, blobs map[string]bundleBlob, v bundleVersion) (created bool, err error) {
+	blob := blobs[v.Hash]
+	file := artifactFile{Name: v.Filename, ContentType: v.ContentType, Stage: v.Stage}
+	body := io.NewSectionReader(f, blob.offset, blob.size)
+	if _, err := pushArtifact(artifactURL(server, v.Package, v.Version), token, body, blob.size, v.Hash, file); err != nil {
+		existing, lookupErr := lookupArtifact(server, token, v.Package, v.Version)
+		if lookupErr != nil || existing.Hash != v.Hash {
+			return false, err
+		}
+		return false, nil
+	}
+
+	for _, named := range v.Files {
+		blob := blobs[named.Hash]
+		body := io.NewSectionReader(f, blob.offset, blob.size)
+		url := fileURL(server, v.Package, v.Version, named.Name)
+		if _, err := pushArtifact(url, token, body, blob.size, named.Hash, localArtifactFile(named.Name)); err != nil {
+			return true, fmt.Errorf("file %s: %w", named.Name, err)
+		}
+	}
+	if len(v.Dependencies) > 0 {
+		if err := setDependencies(server, token, v.Package, v.Version, v.Dependencies); err != nil {
+			return true, fmt.Errorf("dependencies: %w", err)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cacheMaxIdle is how long a cached response is kept without being used.
+const cacheMaxIdle = 30 * 24 * time.Hour
+
+// responseCacheDir is where responses are cached, by default
+// <user cache dir>/foundry/responses, or "" if there is no cache directory.
+func responseCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "foundry", "responses")
+}
+
+// getCached sends req, a GET for a package listing, search or package
+// detail, revalidating a copy cached from an earlier run. The copy is sent
+// as If-None-Match, and when the server answers 304 Not Modified the
+// returned response is a 200 carrying the cached body, so callers need not
+// tell the two apart. A changed response replaces the copy; any other
+// answer, or one without an ETag, drops it. Copies are keyed by URL and
+// token, since what a token may see differs. --no-cache skips the cache.
+func getCached(flags map[string]string, req *http.Request) (*http.Response, error) {
+	dir := responseCacheDir()
+	if dir == "" || hasFlag(flags, "no-cache") {
+		return httpClient.Do(req)
+	}
+	key := sha256.Sum256([]byte(req.URL.String() + "\x00" + req.Header.Get("Authorization")))
+	path := filepath.Join(dir, hex.EncodeToString(key[:]))
+
+	etag, body, ok := readCacheEntry(path)
+	if ok {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok:
+		resp.Body.Close()
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		return resp, nil
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		writeCacheEntry(dir, path, resp.Header.Get("ETag"), data)
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		return resp, nil
+	default:
+		os.Remove(path)
+		return resp, nil
+	}
+}
+
+// readCacheEntry reads a cached response: its ETag on the first line, then
+// the body.
+func readCacheEntry(path string) (etag string, body []byte, ok bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, false
+	}
+	etag, rest, found := strings.Cut(string(data), "\n")
+	if !found || etag == "" {
+		os.Remove(path)
+		return "", nil, false
+	}
+	return etag, []byte(rest), true
+}
+
+// writeCacheEntry stores a response, replacing the file atomically so a
+// concurrent run never reads half of it, and drops entries unused for
+// cacheMaxIdle. Failures only cost the next run a full response, so they
+// are ignored.
+func writeCacheEntry(dir, path, etag string, body []byte) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(tmp)
+	w.WriteString(etag + "\n")
+	w.Write(body)
+	if err := w.Flush(); err != nil || tmp.Close() != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > cacheMaxIdle {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+}
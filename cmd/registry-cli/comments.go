@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// comment mirrors a comment on a package or version.
+type comment struct {
+	ID        int64     `json:"id"`
+	Version   string    `json:"version,omitempty"`
+	Body      string    `json:"body"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// cmdComment adds, lists and deletes the comments on a package and its
+// versions, such as "known bad, use 1.2.4".
+func cmdComment(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: registry comment <add|list|delete> <package> ...")
+		os.Exit(exitUsage)
+	}
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	pkg := pos[1]
+	endpoint := packageURL(server, pkg) + "/comments"
+
+	switch pos[0] {
+	case "add":
+		message := getFlag(flags, "message", "")
+		if message == "" {
+			fmt.Fprintln(os.Stderr, "usage: registry comment add <package> [version] --message <text>")
+			os.Exit(exitUsage)
+		}
+		req := map[string]string{"body": message}
+		if len(pos) > 2 {
+			req["version"] = pos[2]
+		}
+		body, _ := json.Marshal(req)
+		var created comment
+		if err := adminRequest("POST", endpoint, token, body, http.StatusCreated, &created); err != nil {
+			exitAdminError(err)
+		}
+		if hasFlag(flags, "json") {
+			printJSON(created)
+			return
+		}
+		fmt.Printf("Added comment %d to %s\n", created.ID, commentTarget(pkg, created.Version))
+
+	case "list":
+		if len(pos) > 2 {
+			endpoint += "?" + url.Values{"version": {pos[2]}}.Encode()
+		}
+		var comments []comment
+		if err := adminRequest("GET", endpoint, token, nil, http.StatusOK, &comments); err != nil {
+			exitAdminError(err)
+		}
+		if hasFlag(flags, "json") {
+			printJSON(comments)
+			return
+		}
+		if len(comments) == 0 {
+			fmt.Printf("No comments on %s\n", commentTarget(pkg, strings.Join(pos[2:], "")))
+			return
+		}
+		printComments(comments)
+
+	case "delete":
+		if len(pos) < 3 {
+			fmt.Fprintln(os.Stderr, "usage: registry comment delete <package> <id>")
+			os.Exit(exitUsage)
+		}
+		if err := adminRequest("DELETE", endpoint+"/"+url.PathEscape(pos[2]), token, nil, http.StatusOK, nil); err != nil {
+			exitAdminError(err)
+		}
+		fmt.Printf("Deleted comment %s from %s\n", pos[2], pkg)
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown comment command: %s\n", pos[0])
+		os.Exit(exitUsage)
+	}
+}
+
+func commentTarget(pkg, version string) string {
+	if version == "" {
+		return pkg
+	}
+	return pkg + "@" + version
+}
+
+// printComments lists comments oldest first, one line each.
+func printComments(comments []comment) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tVERSION\tBY\tCOMMENT")
+	for _, c := range comments {
+		version := c.Version
+		if version == "" {
+			version = "-"
+		}
+		author := c.Author
+		if author == "" {
+			author = "-"
+		}
+		body := strings.Join(strings.Fields(c.Body), " ")
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", c.ID, c.CreatedAt.Format(time.RFC3339), version, author, body)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// archiveContents mirrors the server's listing of a tar or zip artifact.
+type archiveContents struct {
+	Hash    string `json:"hash"`
+	Format  string `json:"format"`
+	Entries []struct {
+		Path string `json:"path"`
+		Size int64  `json:"size"`
+		Mode string `json:"mode"`
+	} `json:"entries"`
+	Truncated bool      `json:"truncated,omitempty"`
+	IndexedAt time.Time `json:"indexed_at"`
+}
+
+// cmdContents lists the files inside an archive artifact without
+// downloading it. Given a path inside the archive, it fetches just that
+// file, to stdout or --output.
+func cmdContents(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: registry contents <package> <version> [path] [--asset NAME] [--output FILE|-] [--json]")
+		os.Exit(exitUsage)
+	}
+
+	pkg, version := pos[0], pos[1]
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	contentsURL := artifactURL(server, pkg, version) + "/contents"
+	if asset := getFlag(flags, "asset", ""); asset != "" {
+		contentsURL = fileURL(server, pkg, version, asset) + "/contents"
+	}
+	if len(pos) > 2 {
+		extractContent(contentsURL, token, pos[2], getFlag(flags, "output", "-"))
+		return
+	}
+
+	var contents archiveContents
+	if err := adminRequest("GET", contentsURL, token, nil, http.StatusOK, &contents); err != nil {
+		exitAdminError(err)
+	}
+	if hasFlag(flags, "json") {
+		printJSON(contents)
+		return
+	}
+
+	var total int64
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODE\tSIZE\tPATH")
+	for _, e := range contents.Entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", e.Mode, formatBytes(e.Size), e.Path)
+		total += e.Size
+	}
+	tw.Flush()
+	fmt.Printf("%d entries, %s unpacked (%s)\n", len(contents.Entries), formatBytes(total), contents.Format)
+	if contents.Truncated {
+		fmt.Println("The archive holds more entries than the server recorded.")
+	}
+}
+
+// extractContent downloads the file at name inside the archive to output.
+func extractContent(contentsURL, token, name, output string) {
+	segments := strings.Split(strings.Trim(name, "/"), "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	req, err := http.NewRequest("GET", contentsURL+"/"+strings.Join(segments, "/"), nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating request: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitNetwork)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		exitHTTPError(resp)
+	}
+	n, err := saveArchive(resp.Body, output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error downloading %s: %v\n", name, err)
+		os.Exit(errorExitCode(err))
+	}
+	if output != "-" {
+		report(os.Stdout, func() {
+			fmt.Printf("Extracted %s -> %s (%s)\n", name, output, formatBytes(n))
+		}, "contents", "path", name, "output", output, "size", n)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type artifactInfo struct {
+	Version     string `json:"version"`
+	Hash        string `json:"hash"`
+	Size        int64  `json:"size"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+}
+
+func cmdCopy(args []string) {
+	pos, flags := parseFlags(args)
+	from, to := getFlag(flags, "from", ""), getFlag(flags, "to", "")
+	if len(pos) >= 2 && hasFlag(flags, "as") && from == "" && to == "" {
+		copyAs(pos[0], pos[1], flags)
+		return
+	}
+	if len(pos) < 2 || from == "" || to == "" {
+		fmt.Fprintln(os.Stderr, "usage: registry copy <package> <version> --from URL --to URL [--token TOKEN] [--from-token TOKEN] [--to-token TOKEN]")
+		fmt.Fprintln(os.Stderr, "       registry copy <package> <version> --as [<package>@]<version> [--server URL] [--token TOKEN]")
+		os.Exit(exitUsage)
+	}
+
+	pkg, version := pos[0], pos[1]
+	fromToken := getFlag(flags, "from-token", "")
+	if fromToken == "" {
+		fromToken = requireToken(flags, from)
+	}
+	toToken := getFlag(flags, "to-token", "")
+	if toToken == "" {
+		toToken = requireToken(flags, to)
+	}
+
+	start := time.Now()
+	src, err := lookupArtifact(from, fromToken, pkg, version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading source: %v\n", err)
+		os.Exit(errorExitCode(err))
+	}
+
+	// Ask the target to publish from a blob it already holds; servers that
+	// lack the blob or the endpoint fall back to streaming the bytes.
+	mode := "server-side"
+	result, status, err := linkArtifact(to, toToken, pkg, version, src)
+	switch {
+	case err == nil:
+	case status == http.StatusConflict:
+		existing, lookupErr := lookupArtifact(to, toToken, pkg, version)
+		if lookupErr == nil && existing.Hash == src.Hash {
+			report(os.Stdout, func() {
+				fmt.Printf("%s@%s already present on %s\n", pkg, version, to)
+			}, "copy", "package", pkg, "version", version, "from", from, "to", to, "mode", "unchanged", "hash", src.Hash)
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitConflict)
+	case status == http.StatusNotFound || status == http.StatusMethodNotAllowed:
+		mode = "streamed"
+		result, err = streamCopy(from, fromToken, to, toToken, pkg, version, src)
+		endProgress()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error copying: %v\n", err)
+			os.Exit(errorExitCode(err))
+		}
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(errorExitCode(err))
+	}
+
+	if result.Hash != src.Hash {
+		fmt.Fprintf(os.Stderr, "error: target stored hash %s, source has %s\n", result.Hash, src.Hash)
+		os.Exit(exitFailure)
+	}
+
+	elapsed := time.Since(start)
+	report(os.Stdout, func() {
+		fmt.Printf("Copied %s@%s (%s)\n", pkg, version, mode)
+		fmt.Printf("  From:     %s\n", from)
+		fmt.Printf("  To:       %s\n", to)
+		fmt.Printf("  Hash:     %s\n", result.Hash)
+		fmt.Printf("  Size:     %s\n", formatBytes(result.Size))
+		fmt.Printf("  Duration: %v\n", elapsed.Round(time.Millisecond))
+	}, "copy", "package", pkg, "version", version, "from", from, "to", to, "mode", mode,
+		"hash", result.Hash, "size", result.Size, "duration", elapsed)
+}
+
+// copyAs publishes pkg@version again on one server under the package and
+// version named by --as, which share its stored bytes.
+func copyAs(pkg, version string, flags map[string]string) {
+	target := getFlag(flags, "as", "")
+	targetPkg, targetVersion, ok := strings.Cut(target, "@")
+	if !ok {
+		targetPkg, targetVersion = pkg, target
+	}
+	if targetPkg == "" || targetVersion == "" {
+		fmt.Fprintf(os.Stderr, "error: invalid --as %q: expected [<package>@]<version>\n", target)
+		os.Exit(exitUsage)
+	}
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	body, _ := json.Marshal(map[string]string{"package": targetPkg, "version": targetVersion})
+	req, err := http.NewRequest("POST", artifactURL(server, pkg, version)+"/copy", bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	for header, flag := range map[string]string{
+		"X-Artifact-Stage":     "stage",
+		"X-Artifact-Stability": "stability",
+		"X-Artifact-Expires":   "expires",
+	} {
+		if v := getFlag(flags, flag, ""); v != "" {
+			req.Header.Set(header, v)
+		}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitNetwork)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		exitHTTPError(resp)
+	}
+	var result pushResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		fmt.Fprintf(os.Stderr, "error: decoding response: %v\n", err)
+		os.Exit(exitFailure)
+	}
+
+	report(os.Stdout, func() {
+		fmt.Printf("Copied %s@%s to %s@%s\n", pkg, version, targetPkg, targetVersion)
+		fmt.Printf("  Hash:     %s\n", result.Hash)
+		fmt.Printf("  Size:     %s\n", formatBytes(result.Size))
+		if result.Stage == "staging" {
+			fmt.Printf("  Stage:    staging\n")
+		}
+		if result.Quarantined {
+			fmt.Printf("  Status:   quarantined until an admin approves it\n")
+		}
+	}, "copy", "package", pkg, "version", version, "to_package", targetPkg, "to_version", targetVersion,
+		"mode", "alias", "hash", result.Hash, "size", result.Size)
+}
+
+// lookupArtifact finds pkg@version in the package detail endpoint.
+func lookupArtifact(server, token, pkg, version string) (*artifactInfo, error) {
+	req, err := http.NewRequest("GET", packageURL(server, pkg), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(resp)
+	}
+
+	var info struct {
+		Versions []artifactInfo `json:"versions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	for _, v := range info.Versions {
+		if v.Version == version {
+			return &v, nil
+		}
+	}
+	return nil, &httpError{status: http.StatusNotFound, msg: fmt.Sprintf("artifact %s@%s not found", pkg, version)}
+}
+
+// linkArtifact asks server to publish pkg@version from an existing blob. On
+// failure it also returns the HTTP status so callers can decide to fall back.
+func linkArtifact(server, token, pkg, version string, src *artifactInfo) (*pushResult, int, error) {
+	body, _ := json.Marshal(map[string]string{
+		"hash":         src.Hash,
+		"filename":     src.Filename,
+		"content_type": src.ContentType,
+	})
+	req, err := http.NewRequest("POST", artifactURL(server, pkg, version)+"/link", bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, newHTTPError(resp)
+	}
+
+	var result pushResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("decoding response: %w", err)
+	}
+	return &result, resp.StatusCode, nil
+}
+
+// streamCopy pipes the artifact from the source download straight into the
+// target upload, asking the target to verify the source hash.
+func streamCopy(from, fromToken, to, toToken, pkg, version string, src *artifactInfo) (*pushResult, error) {
+	req, err := http.NewRequest("GET", artifactURL(from, pkg, version), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+fromToken)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(resp)
+	}
+	if got := resp.Header.Get("X-Artifact-Hash"); got != "" && got != src.Hash {
+		return nil, errors.New("source artifact changed during copy")
+	}
+
+	body := &progressReader{reader: resp.Body, total: src.Size, label: "Copying"}
+	file := artifactFile{Name: src.Filename, ContentType: src.ContentType}
+	return pushArtifact(artifactURL(to, pkg, version), toToken, body, src.Size, src.Hash, file)
+}
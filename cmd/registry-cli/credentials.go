@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables consulted when the matching flag is not given.
+const (
+	envServer = "FOUNDRY_SERVER"
+	envToken  = "FOUNDRY_TOKEN"
+	envConfig = "FOUNDRY_CONFIG"
+)
+
+const keyringService = "foundry-registry"
+
+var (
+	errKeyringNotFound    = errors.New("no token in keyring")
+	errKeyringUnavailable = errors.New("OS keyring is not available")
+)
+
+// cliConfig is the optional CLI config file, by default
+// <user config dir>/foundry/cli.yaml.
+type cliConfig struct {
+	Server             string `yaml:"server"`
+	Token              string `yaml:"token"`
+	CACert             string `yaml:"caCert"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+var (
+	loadConfigOnce sync.Once
+	loadedConfig   cliConfig
+)
+
+func cliConfigPath(flags map[string]string) string {
+	if p := getFlag(flags, "config", os.Getenv(envConfig)); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "foundry", "cli.yaml")
+}
+
+// loadCLIConfig reads the config file once. A missing default file is not an
+// error; an explicitly named one that cannot be read is.
+func loadCLIConfig(flags map[string]string) cliConfig {
+	loadConfigOnce.Do(func() {
+		path := cliConfigPath(flags)
+		if path == "" {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) && !hasFlag(flags, "config") && os.Getenv(envConfig) == "" {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "error reading config: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		if err := yaml.Unmarshal(data, &loadedConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing config %s: %v\n", path, err)
+			os.Exit(exitFailure)
+		}
+	})
+	return loadedConfig
+}
+
+// resolveServer picks the server URL: --server, then FOUNDRY_SERVER, then
+// the config file, then the default.
+func resolveServer(flags map[string]string) string {
+	if v := getFlag(flags, "server", ""); v != "" {
+		return v
+	}
+	if v := os.Getenv(envServer); v != "" {
+		return v
+	}
+	if v := loadCLIConfig(flags).Server; v != "" {
+		return v
+	}
+	return defaultServer
+}
+
+// resolveToken picks the token for server: --token, then FOUNDRY_TOKEN, then
+// the config file, then the OS keyring entry saved by `registry login`.
+func resolveToken(flags map[string]string, server string) string {
+	if v := getFlag(flags, "token", ""); v != "" {
+		return v
+	}
+	if v := os.Getenv(envToken); v != "" {
+		return v
+	}
+	if v := loadCLIConfig(flags).Token; v != "" {
+		return v
+	}
+	if v, err := keyringGet(keyringAccount(server)); err == nil {
+		return v
+	}
+	return ""
+}
+
+// keyringAccount normalizes a server URL so trailing slashes do not create
+// separate keyring entries.
+func keyringAccount(server string) string {
+	return strings.TrimRight(server, "/")
+}
+
+func cmdLogin(args []string) {
+	_, flags := parseFlags(args)
+	server := resolveServer(flags)
+
+	// Prefer stdin over argv so the token stays out of shell history and ps.
+	token := getFlag(flags, "token", "")
+	if token == "" {
+		fmt.Fprintf(os.Stderr, "Token for %s: ", server)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintf(os.Stderr, "\nerror reading token: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		token = strings.TrimSpace(line)
+	}
+	if token == "" {
+		fmt.Fprintln(os.Stderr, "error: empty token")
+		os.Exit(exitUsage)
+	}
+
+	if err := keyringSet(keyringAccount(server), token); err != nil {
+		fmt.Fprintf(os.Stderr, "error saving token to keyring: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	fmt.Printf("Saved token for %s to the OS keyring\n", server)
+}
+
+func cmdLogout(args []string) {
+	_, flags := parseFlags(args)
+	server := resolveServer(flags)
+
+	if err := keyringDelete(keyringAccount(server)); err != nil {
+		if errors.Is(err, errKeyringNotFound) {
+			fmt.Printf("No saved token for %s\n", server)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "error removing token from keyring: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	fmt.Printf("Removed token for %s from the OS keyring\n", server)
+}
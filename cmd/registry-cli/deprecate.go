@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// deprecation mirrors the deprecation of a package or version.
+type deprecation struct {
+	Message   string `json:"message"`
+	InFavorOf string `json:"deprecated_in_favor_of,omitempty"`
+}
+
+// String describes d as "<message> (use <replacement>)".
+func (d deprecation) String() string {
+	if d.InFavorOf == "" {
+		return d.Message
+	}
+	return fmt.Sprintf("%s (use %s)", d.Message, d.InFavorOf)
+}
+
+// cmdDeprecate deprecates a package, or one version when one is given, or
+// with deprecated false lifts the deprecation. Deprecated versions can
+// still be pulled, with a warning.
+func cmdDeprecate(args []string, deprecated bool) {
+	op, method := "deprecate", "PUT"
+	if !deprecated {
+		op, method = "undeprecate", "DELETE"
+	}
+	pos, flags := parseFlags(args)
+	message := getFlag(flags, "message", "")
+	if len(pos) < 1 || deprecated && message == "" {
+		if deprecated {
+			fmt.Fprintln(os.Stderr, "usage: registry deprecate <package> [version] --message <text> [--in-favor-of <ref>] [--server URL] [--token TOKEN]")
+		} else {
+			fmt.Fprintln(os.Stderr, "usage: registry undeprecate <package> [version] [--server URL] [--token TOKEN]")
+		}
+		os.Exit(exitUsage)
+	}
+
+	pkg, version := pos[0], ""
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	endpoint, target := packageURL(server, pkg)+"/deprecation", pkg
+	if len(pos) > 1 {
+		version = pos[1]
+		endpoint, target = artifactURL(server, pkg, version)+"/deprecation", pkg+"@"+version
+	}
+	var body []byte
+	if deprecated {
+		body, _ = json.Marshal(deprecation{Message: message, InFavorOf: getFlag(flags, "in-favor-of", "")})
+	}
+
+	if err := adminRequest(method, endpoint, token, body, http.StatusOK, nil); err != nil {
+		exitAdminError(err)
+	}
+	report(os.Stdout, func() {
+		if deprecated {
+			fmt.Printf("Deprecated %s\n", target)
+		} else {
+			fmt.Printf("Lifted the deprecation of %s\n", target)
+		}
+	}, op, "package", pkg, "version", version)
+}
+
+// deprecationNotice returns the warning a download response carries for a
+// deprecated package or version, or "" if there is none.
+func deprecationNotice(h http.Header) string {
+	if warning, ok := strings.CutPrefix(h.Get("Warning"), "299 - "); ok {
+		if text, err := strconv.Unquote(warning); err == nil {
+			return text
+		}
+	}
+	if message := h.Get("X-Artifact-Deprecated"); message != "" {
+		return deprecation{Message: message, InFavorOf: h.Get("X-Artifact-Deprecated-In-Favor-Of")}.String()
+	}
+	return ""
+}
+
+// printDeprecationNotice prints notice to stderr so it stands out from the
+// command's output, even when that is piped.
+func printDeprecationNotice(notice string) {
+	if notice == "" {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: %s\n", notice)
+}
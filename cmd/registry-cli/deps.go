@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// dependency mirrors a package@constraint requirement.
+type dependency struct {
+	Package    string `json:"package"`
+	Constraint string `json:"constraint"`
+}
+
+// resolvedDependency mirrors a version selected by server-side resolution.
+type resolvedDependency struct {
+	Package    string   `json:"package"`
+	Version    string   `json:"version"`
+	Hash       string   `json:"hash"`
+	Size       int64    `json:"size"`
+	RequiredBy []string `json:"required_by"`
+}
+
+// dependenciesResponse mirrors GET .../dependencies.
+type dependenciesResponse struct {
+	Package      string               `json:"package"`
+	Version      string               `json:"version"`
+	Dependencies []dependency         `json:"dependencies"`
+	Resolved     []resolvedDependency `json:"resolved,omitempty"`
+}
+
+func cmdDeps(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: registry deps <package> <version> [--set <file|->] [--resolve [--channel <stability>]] [--json]")
+		os.Exit(exitUsage)
+	}
+
+	pkg, version := pos[0], pos[1]
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	if path := getFlag(flags, "set", ""); path != "" {
+		deps, err := readDependencyManifest(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		if err := setDependencies(server, token, pkg, version, deps); err != nil {
+			exitAdminError(err)
+		}
+		report(os.Stdout, func() {
+			fmt.Printf("Set %d dependencies of %s@%s\n", len(deps), pkg, version)
+		}, "deps", "package", pkg, "version", version, "dependencies", len(deps))
+		return
+	}
+
+	endpoint := dependenciesURL(server, pkg, version)
+	if hasFlag(flags, "resolve") {
+		query := url.Values{"resolve": {"true"}}
+		if channel := getFlag(flags, "channel", ""); channel != "" {
+			query.Set("channel", channel)
+		}
+		endpoint += "?" + query.Encode()
+	}
+	var resp dependenciesResponse
+	if err := adminRequest("GET", endpoint, token, nil, http.StatusOK, &resp); err != nil {
+		exitAdminError(err)
+	}
+
+	if hasFlag(flags, "json") {
+		printJSON(resp)
+		return
+	}
+	if !hasFlag(flags, "resolve") {
+		if len(resp.Dependencies) == 0 {
+			fmt.Printf("%s@%s has no dependencies\n", pkg, version)
+			return
+		}
+		for _, d := range resp.Dependencies {
+			fmt.Printf("%s@%s\n", d.Package, d.Constraint)
+		}
+		return
+	}
+
+	if len(resp.Resolved) == 0 {
+		fmt.Printf("%s@%s has no dependencies\n", pkg, version)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tVERSION\tSIZE\tREQUIRED BY")
+	for _, d := range resp.Resolved {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.Package, d.Version, formatBytes(d.Size), strings.Join(d.RequiredBy, ", "))
+	}
+	tw.Flush()
+}
+
+// readDependencyManifest reads one package@constraint per line from path, or
+// stdin for "-". A line without a constraint accepts any version; blank
+// lines and # comments are skipped.
+func readDependencyManifest(path string) ([]dependency, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening dependency manifest: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	deps := []dependency{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		d := dependency{Package: text}
+		if i := strings.LastIndexByte(text, '@'); i > 0 {
+			d.Package, d.Constraint = strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:])
+		}
+		if d.Package == "" {
+			return nil, fmt.Errorf("%s:%d: missing package name", path, line)
+		}
+		deps = append(deps, d)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading dependency manifest: %w", err)
+	}
+	return deps, nil
+}
+
+func setDependencies(server, token, pkg, version string, deps []dependency) error {
+	body, err := json.Marshal(map[string][]dependency{"dependencies": deps})
+	if err != nil {
+		return err
+	}
+	return adminRequest("PUT", dependenciesURL(server, pkg, version), token, body, http.StatusOK, nil)
+}
+
+func dependenciesURL(server, pkg, version string) string {
+	return artifactURL(server, pkg, version) + "/dependencies"
+}
+
+// dependent mirrors an entry of GET /api/v1/packages/{package}/dependents.
+type dependent struct {
+	Package    string `json:"package"`
+	Version    string `json:"version"`
+	Constraint string `json:"constraint"`
+}
+
+func cmdDependents(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: registry dependents <package> [--json]")
+		os.Exit(exitUsage)
+	}
+
+	pkg := pos[0]
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	// Follow every page so the listing is complete.
+	all := []dependent{}
+	cursor := ""
+	for {
+		endpoint := dependentsURL(server, pkg)
+		if cursor != "" {
+			endpoint += "?cursor=" + url.QueryEscape(cursor)
+		}
+		var page struct {
+			Dependents []dependent `json:"dependents"`
+			NextCursor string      `json:"next_cursor"`
+		}
+		if err := adminRequest("GET", endpoint, token, nil, http.StatusOK, &page); err != nil {
+			exitAdminError(err)
+		}
+		all = append(all, page.Dependents...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if hasFlag(flags, "json") {
+		printJSON(all)
+		return
+	}
+	if len(all) == 0 {
+		fmt.Printf("No versions depend on %s\n", pkg)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tVERSION\tCONSTRAINT")
+	for _, d := range all {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", d.Package, d.Version, d.Constraint)
+	}
+	tw.Flush()
+}
+
+func dependentsURL(server, pkg string) string {
+	return packageURL(server, pkg) + "/dependents"
+}
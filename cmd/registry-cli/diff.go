@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// diffEntry mirrors one difference between two versions.
+type diffEntry struct {
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// diffSection mirrors the added, removed and changed entries of one part
+// of a version diff.
+type diffSection struct {
+	Added     []diffEntry `json:"added"`
+	Removed   []diffEntry `json:"removed"`
+	Changed   []diffEntry `json:"changed"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// versionDiff mirrors the comparison of two versions.
+type versionDiff struct {
+	Package      string       `json:"package"`
+	From         string       `json:"from"`
+	To           string       `json:"to"`
+	Properties   []diffEntry  `json:"properties"`
+	Files        diffSection  `json:"files"`
+	Dependencies diffSection  `json:"dependencies"`
+	Contents     *diffSection `json:"contents,omitempty"`
+}
+
+// cmdDiff shows what changed between two versions of a package: their
+// fields, named files, dependencies and archive contents.
+func cmdDiff(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 3 {
+		fmt.Fprintln(os.Stderr, "usage: registry diff <package> <from-version> <to-version> [--json]")
+		os.Exit(exitUsage)
+	}
+
+	pkg := pos[0]
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	query := url.Values{"from": {pos[1]}, "to": {pos[2]}}
+
+	var diff versionDiff
+	if err := adminRequest("GET", packageURL(server, pkg)+"/diff?"+query.Encode(), token, nil, http.StatusOK, &diff); err != nil {
+		exitAdminError(err)
+	}
+	if hasFlag(flags, "json") {
+		printJSON(diff)
+		return
+	}
+
+	fmt.Printf("%s %s -> %s\n", diff.Package, diff.From, diff.To)
+	same := true
+	if len(diff.Properties) > 0 {
+		same = false
+		fmt.Println("\nProperties:")
+		for _, p := range diff.Properties {
+			fmt.Printf("  ~ %s: %s -> %s\n", p.Name, orDash(p.From), orDash(p.To))
+		}
+	}
+	for _, part := range []struct {
+		title   string
+		section *diffSection
+	}{
+		{"Files", &diff.Files},
+		{"Dependencies", &diff.Dependencies},
+		{"Contents", diff.Contents},
+	} {
+		if part.section == nil || len(part.section.Added)+len(part.section.Removed)+len(part.section.Changed) == 0 {
+			continue
+		}
+		same = false
+		fmt.Printf("\n%s:\n", part.title)
+		for _, e := range part.section.Added {
+			fmt.Printf("  + %s (%s)\n", e.Name, e.To)
+		}
+		for _, e := range part.section.Removed {
+			fmt.Printf("  - %s (%s)\n", e.Name, e.From)
+		}
+		for _, e := range part.section.Changed {
+			fmt.Printf("  ~ %s: %s -> %s\n", e.Name, e.From, e.To)
+		}
+		if part.section.Truncated {
+			fmt.Println("  (listing truncated; later entries were not compared)")
+		}
+	}
+	if same {
+		fmt.Println("\nNo differences")
+	}
+}
+
+// orDash returns s, or "-" if it is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
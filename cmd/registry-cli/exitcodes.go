@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Exit codes, so scripts can branch on the kind of failure without parsing
+// stderr. A command exits with the first failure it stops at.
+const (
+	// exitFailure covers what the codes below do not: server errors, local
+	// files that cannot be read or written, verification failures and
+	// declined confirmations.
+	exitFailure = 1
+	// exitNetwork means the server could not be reached or the connection
+	// broke off.
+	exitNetwork = 2
+	// exitAuth means there was no token, or the server refused it (401) or
+	// what it asked for (403).
+	exitAuth = 3
+	// exitNotFound means the package, version or other resource does not
+	// exist (404).
+	exitNotFound = 4
+	// exitConflict means the request clashed with the registry's state: a
+	// taken version, a pinned one, a stale revision (409, 412).
+	exitConflict = 5
+	// exitUsage means the command line was wrong, or the server rejected
+	// the request as invalid (400, 413, 422).
+	exitUsage = 6
+	// exitUnavailable means the server is busy or down and the command may
+	// succeed later (429, 502, 503, 504).
+	exitUnavailable = 7
+)
+
+// statusExitCode maps an HTTP status the command did not expect to an exit
+// code.
+func statusExitCode(status int) int {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return exitAuth
+	case http.StatusNotFound, http.StatusGone:
+		return exitNotFound
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return exitConflict
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return exitUsage
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return exitUnavailable
+	}
+	return exitFailure
+}
+
+// errorExitCode maps an error from a request to an exit code: the status
+// of a server's answer, or exitNetwork if there was none.
+func errorExitCode(err error) int {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		return statusExitCode(httpErr.status)
+	}
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return exitNetwork
+	}
+	return exitFailure
+}
+
+// exitHTTPError prints the server's error from resp and exits with the
+// code for its status.
+func exitHTTPError(resp *http.Response) {
+	fmt.Fprintln(os.Stderr, formatHTTPError(resp))
+	os.Exit(statusExitCode(resp.StatusCode))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// historyEvent mirrors one entry of a package's history.
+type historyEvent struct {
+	Version string    `json:"version"`
+	File    string    `json:"file,omitempty"`
+	Action  string    `json:"action"`
+	OldHash string    `json:"old_hash,omitempty"`
+	NewHash string    `json:"new_hash,omitempty"`
+	Actor   string    `json:"actor,omitempty"`
+	At      time.Time `json:"at"`
+	Source  string    `json:"source,omitempty"`
+}
+
+// cmdHistory lists the creations, overwrites and deletions of a package's
+// versions, newest first, so a missing version can be told apart from one
+// that never existed.
+func cmdHistory(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: registry history <package> [version] [--json]")
+		os.Exit(exitUsage)
+	}
+
+	pkg := pos[0]
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	query := url.Values{}
+	if len(pos) > 1 {
+		query.Set("version", pos[1])
+	}
+
+	// Follow every page so the listing is complete.
+	all := []historyEvent{}
+	for {
+		endpoint := packageURL(server, pkg) + "/history"
+		if len(query) > 0 {
+			endpoint += "?" + query.Encode()
+		}
+		var page struct {
+			Events     []historyEvent `json:"events"`
+			NextCursor string         `json:"next_cursor"`
+		}
+		if err := adminRequest("GET", endpoint, token, nil, http.StatusOK, &page); err != nil {
+			exitAdminError(err)
+		}
+		all = append(all, page.Events...)
+		if page.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+
+	if hasFlag(flags, "json") {
+		printJSON(all)
+		return
+	}
+	if len(all) == 0 {
+		if len(pos) > 1 {
+			fmt.Printf("%s@%s has no recorded history\n", pkg, pos[1])
+		} else {
+			fmt.Printf("%s has no recorded history\n", pkg)
+		}
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tVERSION\tFILE\tACTION\tHASH\tBY")
+	for _, e := range all {
+		file := e.File
+		if file == "" {
+			file = "-"
+		}
+		hash := shortHash(e.NewHash)
+		switch {
+		case e.OldHash != "" && e.NewHash != "":
+			hash = shortHash(e.OldHash) + " -> " + shortHash(e.NewHash)
+		case e.OldHash != "":
+			hash = shortHash(e.OldHash)
+		}
+		action := e.Action
+		if e.Source != "" {
+			action += " from " + e.Source
+		}
+		actor := e.Actor
+		if actor == "" {
+			actor = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.At.Format(time.RFC3339), e.Version, file, action, hash, actor)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// httpClient is shared by all commands. It has no overall timeout since
+// artifact transfers can legitimately run for hours; the transport bounds
+// each stage of connection setup instead.
+var httpClient = http.DefaultClient
+
+// newHTTPClient builds the CLI's client, honoring HTTP(S)_PROXY / NO_PROXY,
+// an extra CA bundle from --ca-cert (or caCert in the config file), and
+// --insecure-skip-verify.
+func newHTTPClient(flags map[string]string) (*http.Client, error) {
+	cfg := loadCLIConfig(flags)
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile := getFlag(flags, "ca-cert", cfg.CACert); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no PEM certificates found in " + caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if hasFlag(flags, "insecure-skip-verify") || cfg.InsecureSkipVerify {
+		fmt.Fprintln(os.Stderr, "warning: TLS certificate verification is disabled")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 2 * time.Minute,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &http.Client{Transport: transport}, nil
+}
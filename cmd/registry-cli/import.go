@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// importResult is the server's answer to an import.
+type importResult struct {
+	pushResult
+	Import string `json:"import"`
+}
+
+// cmdImport publishes the versions a manifest lists from files the server
+// can read itself, so a file share on the server's filesystem is migrated
+// without sending the files over HTTP. Relative paths in the manifest are
+// resolved against --dir, a directory as the server sees it, or else the
+// manifest's own directory when the CLI runs on the server. --link lets
+// the server hard-link files it cannot clone instead of copying them.
+func cmdImport(args []string) {
+	_, flags := parseFlags(args)
+	if !hasFlag(flags, "manifest") {
+		fmt.Fprintln(os.Stderr, "usage: registry import --manifest <file> [--dir <dir>] [--link]")
+		os.Exit(exitUsage)
+	}
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	base := getFlag(flags, "dir", "")
+	if base == "" {
+		abs, err := filepath.Abs(filepath.Dir(flags["manifest"]))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		base = abs
+	}
+	if !filepath.IsAbs(base) {
+		fmt.Fprintln(os.Stderr, "error: --dir must be an absolute path on the server")
+		os.Exit(exitUsage)
+	}
+	entries, err := loadManifestIn(flags["manifest"], base, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	for _, e := range entries {
+		if e.Asset != "" {
+			fmt.Fprintf(os.Stderr, "error: %s: named files cannot be imported\n", e)
+			os.Exit(exitUsage)
+		}
+	}
+
+	runBulk("import", "Importing", entries, concurrencyFlag(flags), func(e bulkEntry, counter *atomic.Int64) bulkResult {
+		res, err := importArtifact(server, token, e, hasFlag(flags, "link"))
+		if err != nil {
+			return bulkResult{entry: e, err: err}
+		}
+		counter.Add(res.Size)
+		return bulkResult{entry: e, hash: res.Hash, size: res.Size, detail: res.Import}
+	})
+}
+
+func importArtifact(server, token string, e bulkEntry, link bool) (*importResult, error) {
+	body, _ := json.Marshal(map[string]any{"path": e.File, "link": link})
+	u := adminURL(server, fmt.Sprintf("/api/v1/admin/import/%s/%s", url.PathEscape(e.Package), url.PathEscape(e.Version)))
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(resp)
+	}
+	var result importResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	if result.Import == "" {
+		return nil, errors.New("the server answered without importing")
+	}
+	return &result, nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// artifactDetail is the artifact shape returned by the package detail
+// endpoint. Labels, tags, download counts and vulnerability summaries are
+// shown when the server reports them.
+type artifactDetail struct {
+	Version       string            `json:"version"`
+	Hash          string            `json:"hash"`
+	Size          int64             `json:"size"`
+	Filename      string            `json:"filename,omitempty"`
+	ContentType   string            `json:"content_type,omitempty"`
+	UploadedAt    time.Time         `json:"uploaded_at"`
+	Quarantined   bool              `json:"quarantined,omitempty"`
+	Stage         string            `json:"stage,omitempty"`
+	Stability     string            `json:"stability,omitempty"`
+	PromotedBy    string            `json:"promoted_by,omitempty"`
+	PromotedAt    *time.Time        `json:"promoted_at,omitempty"`
+	ExpiresAt     *time.Time        `json:"expires_at,omitempty"`
+	Pinned        bool              `json:"pinned,omitempty"`
+	Deprecated    *deprecation      `json:"deprecated,omitempty"`
+	ReleaseNotes  string            `json:"release_notes,omitempty"`
+	Description   string            `json:"description,omitempty"`
+	Revision      int64             `json:"revision,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	DownloadCount *int64            `json:"download_count,omitempty"`
+	// Vulnerabilities is set for versions with a scan report.
+	Vulnerabilities *vulnerabilitySummary `json:"vulnerabilities,omitempty"`
+}
+
+func cmdInfo(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: registry info <package> [version] [--sort uploaded|semver] [--channel <stability>] [--json] [--server URL] [--token TOKEN]")
+		os.Exit(exitUsage)
+	}
+
+	pkg := pos[0]
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	asJSON := hasFlag(flags, "json")
+
+	// Ask for every stage so staging versions are listed too.
+	query := url.Values{"stage": {"all"}}
+	if sortBy := getFlag(flags, "sort", ""); sortBy != "" {
+		query.Set("sort", sortBy)
+	}
+	if channel := getFlag(flags, "channel", ""); channel != "" {
+		query.Set("channel", channel)
+	}
+	req, _ := http.NewRequest("GET", packageURL(server, pkg)+"?"+query.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := getCached(flags, req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitNetwork)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		exitHTTPError(resp)
+	}
+
+	// Decode versions twice: raw so --json passes through every field the
+	// server sends, and typed for the table.
+	var raw struct {
+		Name       string            `json:"name"`
+		Latest     string            `json:"latest,omitempty"`
+		Versions   []json.RawMessage `json:"versions"`
+		Deprecated *deprecation      `json:"deprecated,omitempty"`
+		Comments   []comment         `json:"comments,omitempty"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		fmt.Fprintf(os.Stderr, "error decoding response: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	details := make([]artifactDetail, len(raw.Versions))
+	for i, v := range raw.Versions {
+		if err := json.Unmarshal(v, &details[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "error decoding response: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+
+	if len(pos) < 2 {
+		if asJSON {
+			printJSON(raw)
+			return
+		}
+		printPackageTable(raw.Name, raw.Latest, details)
+		if raw.Deprecated != nil {
+			printDeprecationNotice(raw.Name + " is deprecated: " + raw.Deprecated.String())
+		}
+		if len(raw.Comments) > 0 {
+			fmt.Println()
+			printComments(raw.Comments)
+		}
+		return
+	}
+
+	version := pos[1]
+	for i, d := range details {
+		if d.Version != version {
+			continue
+		}
+		if asJSON {
+			printJSON(raw.Versions[i])
+			return
+		}
+		printArtifactDetail(os.Stdout, raw.Name, d)
+		printFiles(os.Stdout, listFiles(server, token, pkg, version))
+		if d.ReleaseNotes != "" {
+			fmt.Println()
+			printReleaseNotes(os.Stdout, d.ReleaseNotes)
+		}
+		switch {
+		case d.Deprecated != nil:
+			printDeprecationNotice(raw.Name + "@" + version + " is deprecated: " + d.Deprecated.String())
+		case raw.Deprecated != nil:
+			printDeprecationNotice(raw.Name + " is deprecated: " + raw.Deprecated.String())
+		}
+		// Comments on the package concern every version.
+		var comments []comment
+		for _, c := range raw.Comments {
+			if c.Version == "" || c.Version == version {
+				comments = append(comments, c)
+			}
+		}
+		if len(comments) > 0 {
+			fmt.Println()
+			printComments(comments)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "error: artifact %s@%s not found\n", pkg, version)
+	os.Exit(exitNotFound)
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "error encoding output: %v\n", err)
+		os.Exit(exitFailure)
+	}
+}
+
+func printPackageTable(name, latest string, details []artifactDetail) {
+	fmt.Printf("Package: %s (%d versions)\n", name, len(details))
+	if latest != "" {
+		fmt.Printf("Latest:  %s\n", latest)
+	}
+	fmt.Println()
+	printVersionTable(os.Stdout, details)
+}
+
+// printVersionTable writes a row per version to w under a header row.
+func printVersionTable(w io.Writer, details []artifactDetail) {
+	if len(details) == 0 {
+		return
+	}
+	// Only show the stage column when some version is not released, the
+	// stability column when some version is not stable, the pinned column
+	// when some version is pinned, the deprecated column when some version
+	// is deprecated, and the vulnerabilities column when some version was
+	// scanned.
+	staged, unstable, pinned, deprecated, scanned := false, false, false, false, false
+	for _, d := range details {
+		staged = staged || (d.Stage != "" && d.Stage != "release")
+		unstable = unstable || (d.Stability != "" && d.Stability != "stable")
+		pinned = pinned || d.Pinned
+		deprecated = deprecated || d.Deprecated != nil
+		scanned = scanned || d.Vulnerabilities != nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "VERSION\tHASH\tSIZE\tUPLOADED"
+	if staged {
+		header += "\tSTAGE"
+	}
+	if unstable {
+		header += "\tSTABILITY"
+	}
+	if pinned {
+		header += "\tPINNED"
+	}
+	if deprecated {
+		header += "\tDEPRECATED"
+	}
+	if scanned {
+		header += "\tVULNERABILITIES"
+	}
+	fmt.Fprintln(tw, header)
+	for _, d := range details {
+		row := fmt.Sprintf("%s\t%s\t%s\t%s", d.Version, shortHash(d.Hash), formatBytes(d.Size), d.UploadedAt.Format(time.RFC3339))
+		if staged {
+			row += "\t" + d.Stage
+		}
+		if unstable {
+			row += "\t" + d.Stability
+		}
+		if pinned {
+			mark := "-"
+			if d.Pinned {
+				mark = "yes"
+			}
+			row += "\t" + mark
+		}
+		if deprecated {
+			mark := "-"
+			if d.Deprecated != nil {
+				mark = "yes"
+				if d.Deprecated.InFavorOf != "" {
+					mark = "use " + d.Deprecated.InFavorOf
+				}
+			}
+			row += "\t" + mark
+		}
+		if scanned {
+			vulns := "-"
+			if d.Vulnerabilities != nil {
+				vulns = formatVulnerabilities(*d.Vulnerabilities)
+			}
+			row += "\t" + vulns
+		}
+		fmt.Fprintln(tw, row)
+	}
+	tw.Flush()
+}
+
+func printArtifactDetail(w io.Writer, name string, d artifactDetail) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Package:\t%s\n", name)
+	fmt.Fprintf(tw, "Version:\t%s\n", d.Version)
+	if d.Description != "" {
+		fmt.Fprintf(tw, "Description:\t%s\n", d.Description)
+	}
+	fmt.Fprintf(tw, "Hash:\t%s\n", d.Hash)
+	fmt.Fprintf(tw, "Size:\t%s (%d bytes)\n", formatBytes(d.Size), d.Size)
+	if d.Filename != "" {
+		fmt.Fprintf(tw, "Filename:\t%s\n", d.Filename)
+	}
+	if d.ContentType != "" {
+		fmt.Fprintf(tw, "Type:\t%s\n", d.ContentType)
+	}
+	fmt.Fprintf(tw, "Uploaded:\t%s\n", d.UploadedAt.Format(time.RFC3339))
+	if d.Revision != 0 {
+		fmt.Fprintf(tw, "Revision:\t%d\n", d.Revision)
+	}
+	if d.Stage != "" {
+		fmt.Fprintf(tw, "Stage:\t%s\n", d.Stage)
+	}
+	if d.Stability != "" {
+		fmt.Fprintf(tw, "Stability:\t%s\n", d.Stability)
+	}
+	if d.PromotedAt != nil {
+		fmt.Fprintf(tw, "Promoted:\t%s by %s\n", d.PromotedAt.Format(time.RFC3339), d.PromotedBy)
+	}
+	if d.ExpiresAt != nil {
+		fmt.Fprintf(tw, "Expires:\t%s\n", d.ExpiresAt.Format(time.RFC3339))
+	}
+	if d.Pinned {
+		fmt.Fprintf(tw, "Pinned:\tyes, protected from deletion\n")
+	}
+	if d.Deprecated != nil {
+		fmt.Fprintf(tw, "Deprecated:\t%s\n", d.Deprecated)
+	}
+	if d.Quarantined {
+		fmt.Fprintf(tw, "Status:\tquarantined, awaiting approval\n")
+	}
+	if len(d.Tags) > 0 {
+		fmt.Fprintf(tw, "Tags:\t%s\n", strings.Join(d.Tags, ", "))
+	}
+	if len(d.Labels) > 0 {
+		keys := make([]string, 0, len(d.Labels))
+		for k := range d.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, len(keys))
+		for i, k := range keys {
+			pairs[i] = k + "=" + d.Labels[k]
+		}
+		fmt.Fprintf(tw, "Labels:\t%s\n", strings.Join(pairs, ", "))
+	}
+	if d.DownloadCount != nil {
+		fmt.Fprintf(tw, "Downloads:\t%d\n", *d.DownloadCount)
+	}
+	if d.Vulnerabilities != nil {
+		fmt.Fprintf(tw, "Vulnerabilities:\t%s\n", formatVulnerabilities(*d.Vulnerabilities))
+	}
+	tw.Flush()
+}
+
+// fileDetail is one entry of a version's file list.
+type fileDetail struct {
+	Name    string `json:"name"`
+	Hash    string `json:"hash"`
+	Size    int64  `json:"size"`
+	Default bool   `json:"default"`
+}
+
+// listFiles returns a version's files, or nil if the server does not list
+// them.
+func listFiles(server, token, pkg, version string) []fileDetail {
+	req, _ := http.NewRequest("GET", filesURL(server, pkg, version), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	var files []fileDetail
+	json.NewDecoder(resp.Body).Decode(&files)
+	return files
+}
+
+// printFiles lists the files of a version that has assets besides its
+// default file.
+func printFiles(w io.Writer, files []fileDetail) {
+	if len(files) < 2 {
+		return
+	}
+	fmt.Fprintln(w, "\nFiles:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, f := range files {
+		marker := ""
+		if f.Default {
+			marker = "  (default)"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s%s\n", f.Name, shortHash(f.Hash), formatBytes(f.Size), marker)
+	}
+	tw.Flush()
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
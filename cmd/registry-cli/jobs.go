@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// adminJob mirrors a background job as reported by the server.
+type adminJob struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Progress   struct {
+		TotalBlobs   int   `json:"total_blobs"`
+		ScannedBlobs int   `json:"scanned_blobs"`
+		DeletedBlobs int   `json:"deleted_blobs"`
+		FreedBytes   int64 `json:"freed_bytes"`
+	} `json:"progress"`
+	Result *gcResult `json:"result,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+func cmdJob(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: registry job <list|status|cancel> ...")
+		os.Exit(exitUsage)
+	}
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	endpoint := adminURL(server, "/api/v1/admin/jobs")
+
+	switch pos[0] {
+	case "list":
+		var jobs []adminJob
+		if err := adminRequest("GET", endpoint, token, nil, http.StatusOK, &jobs); err != nil {
+			exitAdminError(err)
+		}
+		if hasFlag(flags, "json") {
+			printJSON(jobs)
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tKIND\tSTATUS\tSTARTED\tPROGRESS")
+		for _, j := range jobs {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", j.ID, j.Kind, j.Status, j.StartedAt.Format(time.RFC3339), jobProgress(j))
+		}
+		tw.Flush()
+
+	case "status":
+		if len(pos) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: registry job status <id> [--follow]")
+			os.Exit(exitUsage)
+		}
+		jobURL := endpoint + "/" + pos[1]
+		var job adminJob
+		if hasFlag(flags, "follow") {
+			job = followJob(jobURL, token, hasFlag(flags, "json"))
+		} else if err := adminRequest("GET", jobURL, token, nil, http.StatusOK, &job); err != nil {
+			exitAdminError(err)
+		}
+		if hasFlag(flags, "json") {
+			if !hasFlag(flags, "follow") {
+				printJSON(job)
+			}
+			return
+		}
+		printJob(job)
+
+	case "cancel":
+		if len(pos) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: registry job cancel <id>")
+			os.Exit(exitUsage)
+		}
+		var job adminJob
+		if err := adminRequest("DELETE", endpoint+"/"+pos[1], token, nil, http.StatusOK, &job); err != nil {
+			exitAdminError(err)
+		}
+		if hasFlag(flags, "json") {
+			printJSON(job)
+			return
+		}
+		printJob(job)
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown job command: %s\n", pos[0])
+		os.Exit(exitUsage)
+	}
+}
+
+// followJob streams a job's progress until it finishes and returns its
+// final state. Progress goes to stderr, or every update to stdout as a JSON
+// line with asJSON.
+func followJob(jobURL, token string, asJSON bool) adminJob {
+	req, err := http.NewRequest("GET", jobURL+"?follow=true", nil)
+	if err != nil {
+		exitAdminError(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		exitAdminError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		exitAdminError(newHTTPError(resp))
+	}
+
+	var job adminJob
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if err := json.Unmarshal(scanner.Bytes(), &job); err != nil {
+			exitAdminError(fmt.Errorf("decoding job: %w", err))
+		}
+		if asJSON {
+			fmt.Println(scanner.Text())
+		} else if !quiet && job.Status == "running" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", job.ID, jobProgress(job))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		exitAdminError(err)
+	}
+	if job.Status == "running" {
+		exitAdminError(fmt.Errorf("job %s: stream ended while the job was still running", job.ID))
+	}
+	return job
+}
+
+// jobProgress describes how far a job has got.
+func jobProgress(j adminJob) string {
+	p := j.Progress
+	return fmt.Sprintf("%d/%d blobs scanned, %d deleted (%s)", p.ScannedBlobs, p.TotalBlobs, p.DeletedBlobs, formatBytes(p.FreedBytes))
+}
+
+// printJob prints a job's state, and its result once it has finished.
+func printJob(j adminJob) {
+	if !quiet {
+		fmt.Printf("Job %s (%s): %s\n", j.ID, j.Kind, j.Status)
+		if j.Status == "running" {
+			fmt.Println("Progress: " + jobProgress(j))
+		}
+		if j.Error != "" {
+			fmt.Println("Error: " + j.Error)
+		}
+	}
+	if j.Result != nil {
+		reportGCResult(*j.Result, false)
+	} else if quiet {
+		fmt.Println(summaryLine("job", j.Status, "id", j.ID))
+	}
+	if j.Status == "failed" {
+		os.Exit(exitFailure)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The macOS keychain is driven through the security(1) tool.
+
+func keyringGet(account string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w").Output()
+	if err != nil {
+		return "", keychainError(err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func keyringSet(account, token string) error {
+	cmd := exec.Command("security", "add-generic-password", "-U", "-s", keyringService, "-a", account, "-w", token)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", keychainError(err), strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func keyringDelete(account string) error {
+	if err := exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", account).Run(); err != nil {
+		return keychainError(err)
+	}
+	return nil
+}
+
+// keychainError maps security(1) failures; exit status 44 means the item
+// does not exist.
+func keychainError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 44 {
+		return errKeyringNotFound
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return errKeyringUnavailable
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Elsewhere the freedesktop Secret Service is driven through secret-tool(1)
+// from libsecret, which GNOME Keyring and KWallet both serve.
+
+func keyringGet(account string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", keyringService, "server", account).Output()
+	if err != nil {
+		return "", secretToolError(err)
+	}
+	if len(out) == 0 {
+		return "", errKeyringNotFound
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func keyringSet(account, token string) error {
+	cmd := exec.Command("secret-tool", "store", "--label=Foundry registry token for "+account,
+		"service", keyringService, "server", account)
+	cmd.Stdin = strings.NewReader(token)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", secretToolError(err), strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func keyringDelete(account string) error {
+	if _, err := keyringGet(account); err != nil {
+		return err
+	}
+	if err := exec.Command("secret-tool", "clear", "service", keyringService, "server", account).Run(); err != nil {
+		return secretToolError(err)
+	}
+	return nil
+}
+
+// secretToolError maps secret-tool failures; it exits 1 without output when
+// nothing matches.
+func secretToolError(err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return errKeyringUnavailable
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(exitErr.Stderr) == 0 {
+		return errKeyringNotFound
+	}
+	return err
+}
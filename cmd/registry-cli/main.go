@@ -1,14 +1,20 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,29 +23,100 @@ const defaultServer = "http://localhost:8080"
 func main() {
 	if len(os.Args) < 2 {
 		printUsage()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	cmd := os.Args[1]
 	args := os.Args[2:]
 
+	_, flags := parseFlags(args)
+	client, err := newHTTPClient(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	httpClient = client
+	configureOutput(flags)
+
 	switch cmd {
 	case "push":
 		cmdPush(args)
 	case "pull":
 		cmdPull(args)
+	case "pull-all":
+		cmdPullAll(args)
 	case "list":
 		cmdList(args)
 	case "search":
 		cmdSearch(args)
+	case "browse":
+		cmdBrowse(args)
 	case "delete":
 		cmdDelete(args)
+	case "batch-delete":
+		cmdBatchDelete(args)
+	case "pin":
+		cmdPin(args, true)
+	case "unpin":
+		cmdPin(args, false)
+	case "deprecate":
+		cmdDeprecate(args, true)
+	case "undeprecate":
+		cmdDeprecate(args, false)
+	case "login":
+		cmdLogin(args)
+	case "logout":
+		cmdLogout(args)
+	case "copy":
+		cmdCopy(args)
+	case "deps":
+		cmdDeps(args)
+	case "sbom":
+		cmdSBOM(args)
+	case "scan":
+		cmdScan(args)
+	case "dependents":
+		cmdDependents(args)
+	case "history":
+		cmdHistory(args)
+	case "diff":
+		cmdDiff(args)
+	case "contents":
+		cmdContents(args)
+	case "artifacts":
+		cmdArtifacts(args)
+	case "info":
+		cmdInfo(args)
+	case "gc":
+		cmdGC(args)
+	case "stats":
+		cmdStats(args)
+	case "quarantine":
+		cmdQuarantine(args)
+	case "check-names":
+		cmdCheckNames(args)
+	case "approve":
+		cmdApprove(args)
+	case "promote":
+		cmdPromote(args)
+	case "token":
+		cmdToken(args)
+	case "job":
+		cmdJob(args)
+	case "notes":
+		cmdNotes(args)
+	case "comment":
+		cmdComment(args)
+	case "bundle":
+		cmdBundle(args)
+	case "import":
+		cmdImport(args)
 	case "help", "--help", "-h":
 		printUsage()
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n", cmd)
 		printUsage()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 }
 
@@ -47,23 +124,204 @@ func printUsage() {
 	fmt.Println(`Foundry Registry CLI
 
 Usage:
-  registry push <package> <version> <file> [options]
+  registry push <package> <version> <file|-> [options]
   registry pull <package> <version> [options]
-  registry list [options]
+  registry push --manifest <file> [options]
+  registry pull --manifest <file> [options]
+  registry pull-all <package>@<version>... [--manifest <file>] [options]
+                                      (one archive; unpacks into --output)
+  registry list [--sort name|updated|size] [--order asc|desc] [options]
   registry search <query> [options]
+  registry search [query] --component <name> [--component-version <v>]
+  registry browse [options]           (explore packages, versions and pull
+                                      them in a full-screen view)
   registry delete <package> <version> [options]
+  registry batch-delete <package>@<version>... [--dry-run] [--yes]
+  registry batch-delete [--prefix <p>] [--older-than <age>] [--channel <level>]
+                    [--dry-run] [--yes]
+                                      (previews, then deletes once confirmed)
+  registry pin <package> <version>     (protects it from deletion)
+  registry unpin <package> <version>
+  registry deprecate <package> [version] --message <text> [--in-favor-of <ref>]
+                                      (warns whoever pulls it)
+  registry undeprecate <package> [version]
+  registry copy <package> <version> --from <url> --to <url> [options]
+  registry copy <package> <version> --as [<package>@]<version>
+                                      (same server, no bytes moved)
+  registry artifacts [--package <name>] [--uploaded-after <time>] [--uploaded-before <time>]
+                    [--min-size <bytes>] [--max-size <bytes>] [--hash <sha256>]
+                    [--license <spdx-id>] [--format <format>] [--channel <stability>]
+                                      (lists versions across packages)
+  registry info <package> [version] [options]
+  registry contents <package> <version> [--asset <name>]
+                                      (lists the files in a tar or zip)
+  registry contents <package> <version> <path> [--output <file|->]
+                                      (fetches one file from the archive)
+  registry deps <package> <version> [--set <file|->] [--resolve]
+  registry dependents <package>
+  registry history <package> [version] (creations, overwrites, copies and deletions)
+  registry diff <package> <from-version> <to-version>
+                                      (fields, files, dependencies and archive
+                                      contents that changed)
+  registry notes <package> <version> [--set <file|->] [--clear]
+                                      (the version's Markdown release notes)
+  registry comment add <package> [version] --message <text>
+  registry comment list <package> [version]
+  registry comment delete <package> <id>
+  registry sbom <package> <version> [--set <file|->] [--output <file|->]
+  registry scan <package> <version> [--set <file|->] [--output <file|->]
+  registry login [--server <url>]     (reads the token from stdin)
+  registry logout [--server <url>]
+  registry gc [--dry-run] [--tombstone] [--background] [--yes]
+  registry stats
+  registry quarantine                 (lists versions awaiting approval)
+  registry check-names                (lists stored names breaking the naming
+                                      rules; exits 1 if there are any)
+  registry approve <package> <version>
+  registry promote <package> <version> [--stage <stage>]
+  registry token create <name> [--admin]
+  registry token list
+  registry token revoke <id> [--yes]
+  registry token usage <id>
+  registry job list                   (running and recent background jobs)
+  registry job status <id> [--follow]
+  registry job cancel <id>
+  registry bundle create --packages <package>[@<version>],... --output <file>
+                    --signing-key <key>
+                                      (signed offline archive for an air-gapped registry)
+  registry bundle import <file> --verify-key <key>
+  registry import --manifest <file> [--dir <dir>] [--link]
+                                      (publishes files already on the server's
+                                      filesystem without uploading them)
 
 Options:
   --server <url>    Server URL (default: http://localhost:8080)
   --token <token>   Authentication token
-  --output <file>   Output file path (for pull)`)
+  --config <file>   CLI config file (default: <user config dir>/foundry/cli.yaml)
+  --ca-cert <file>  Extra PEM CA bundle to trust for the server's certificate
+  --insecure-skip-verify
+                    Skip TLS certificate verification (testing only)
+  --quiet           Print only errors and a one-line summary
+  --no-progress     Never draw progress bars (automatic when stderr is not a terminal)
+  --output <file>   Output file path, or - for stdout (for pull, sbom and scan; pull
+                    defaults to the artifact's original filename, or
+                    <package>-<version>), or the directory pull-all unpacks
+                    into as <package>/<version>/<file> (default: .)
+  --archive <file|->
+                    Save the pull-all archive as is instead of unpacking it
+  --format <fmt>    tar or zip: the --archive format (default: from the
+                    file extension, else tar)
+  --asset <name>    Push or pull a named file of the version instead of its
+                    default file (also for contents)
+  --filename <name> Original filename to record (for push; default: the file's name)
+  --content-type <type>
+                    MIME type to record (for push; default: from the filename)
+  --expires <ttl|time>
+                    Remove the pushed version after a duration (72h, 7d) or at
+                    an RFC 3339 time
+  --stage <stage>   staging or release: the stage a pushed version starts in
+                    (default: the server's), or the target of promote
+                    (default: release)
+  --stability <level>
+                    alpha, beta, rc or stable: the stability of a pushed version
+                    (default: from its pre-release tag, else stable)
+  --channel <level> Only versions of that stability or more stable (for info,
+                    artifacts and deps --resolve; info's latest follows it), or
+                    of exactly that stability (for batch-delete)
+  --prefix <p>      Only packages whose names start with p (for batch-delete)
+  --older-than <age>
+                    Only versions uploaded longer ago than a duration such as
+                    720h or 30d (for batch-delete)
+  --verify          After push, re-read the stored artifact and compare its hash
+  --deps <file>     Dependency manifest to record after push: one
+                    package@constraint per line, e.g. libfoo@^1.2
+  --notes-file <file|->
+                    Markdown release notes to attach after push
+  --set <file|->    Replace a version's dependencies from a manifest (for deps),
+                    or attach an SPDX or CycloneDX JSON SBOM (for sbom), a
+                    Trivy or Grype JSON report (for scan) or Markdown release
+                    notes (for notes)
+  --clear           Remove a version's release notes (for notes)
+  --resolve         List the transitively resolved versions (for deps)
+  --component <name>
+                    Match packages whose SBOMs list a component, by name or
+                    package URL (for search)
+  --component-version <v>
+                    Only match that component version (for search)
+  --no-resume       Discard any partial download instead of resuming (for pull)
+  --no-cache        Fetch list, search and info output in full instead of
+                    revalidating the copy cached from an earlier run
+  --manifest <file> YAML list of package/version/file entries (for push, pull,
+                    pull-all, import)
+  --dir <dir>       Server-side directory the manifest's relative paths are in
+                    (for import; default: the manifest's directory)
+  --concurrency <n> Parallel transfers for --manifest (default: 4)
+  --json            Print info, contents, deps, diff, dependents, sbom, scan,
+                    stats, gc, batch-delete, quarantine, check-names, token and
+                    comment output as JSON
+  --dry-run         Report what gc or batch-delete would delete without
+                    deleting it
+  --yes             Skip confirmation prompts (required when stdin is not a terminal)
+  --admin           Issue an admin token (for token create)
+  --message <text>  The comment to leave (for comment add), or why a package
+                    or version is deprecated (for deprecate)
+  --in-favor-of <ref>
+                    What to use instead of a deprecated package or version
+  --packages <list> Comma-separated packages to bundle, each with @<version> for
+                    one version or without for all of them
+  --signing-key <file>
+                    PEM private key (ECDSA, Ed25519 or RSA) to sign a bundle with
+  --verify-key <file>
+                    PEM public key a bundle must be signed by to import
+  --from-token, --to-token <token>
+                    Per-registry tokens for copy (default: resolved per server)
+  --as [<package>@]<version>
+                    Publish the version again on the same server under that
+                    version (and package), sharing its stored bytes (for copy;
+                    takes --stage, --stability and --expires)
+
+The server and token may also come from FOUNDRY_SERVER and FOUNDRY_TOKEN, the
+config file, or (for the token) the OS keyring, in that order of precedence
+after flags.
+
+Exit codes:
+  0  success
+  1  any other failure (server error, local file, verification, declined)
+  2  the server could not be reached
+  3  no token, or the server refused it (401, 403)
+  4  not found (404, 410)
+  5  conflict with the registry's state (409, 412)
+  6  bad command line, or a request the server rejected (400, 413, 422)
+  7  server busy or unavailable; try again later (429, 502, 503, 504)`)
+}
+
+// boolFlags are flags that take no value.
+var boolFlags = map[string]bool{
+	"no-resume":            true,
+	"json":                 true,
+	"insecure-skip-verify": true,
+	"quiet":                true,
+	"no-progress":          true,
+	"dry-run":              true,
+	"yes":                  true,
+	"admin":                true,
+	"verify":               true,
+	"resolve":              true,
+	"tombstone":            true,
+	"background":           true,
+	"follow":               true,
+	"clear":                true,
+	"no-cache":             true,
+	"link":                 true,
 }
 
-// parseFlags extracts --key value pairs from args.
+// parseFlags extracts --key value pairs and bare boolean flags from args.
 func parseFlags(args []string) (positional []string, flags map[string]string) {
 	flags = make(map[string]string)
 	for i := 0; i < len(args); i++ {
-		if strings.HasPrefix(args[i], "--") && i+1 < len(args) {
+		if key := strings.TrimPrefix(args[i], "--"); key != args[i] && boolFlags[key] {
+			flags[key] = "true"
+		} else if strings.HasPrefix(args[i], "--") && i+1 < len(args) {
 			flags[strings.TrimPrefix(args[i], "--")] = args[i+1]
 			i++
 		} else {
@@ -80,193 +338,626 @@ func getFlag(flags map[string]string, key, def string) string {
 	return def
 }
 
-func requireToken(flags map[string]string) string {
-	token := getFlag(flags, "token", "")
+func hasFlag(flags map[string]string, key string) bool {
+	_, ok := flags[key]
+	return ok
+}
+
+func requireToken(flags map[string]string, server string) string {
+	token := resolveToken(flags, server)
 	if token == "" {
-		fmt.Fprintln(os.Stderr, "error: --token is required")
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "error: no token for %s: pass --token, set %s, add it to the config file, or run registry login\n", server, envToken)
+		os.Exit(exitAuth)
 	}
 	return token
 }
 
 func cmdPush(args []string) {
 	pos, flags := parseFlags(args)
+	if hasFlag(flags, "manifest") {
+		bulkPush(flags)
+		return
+	}
 	if len(pos) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: registry push <package> <version> <file> [--server URL] [--token TOKEN]")
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "usage: registry push <package> <version> <file|-> [--server URL] [--token TOKEN]")
+		os.Exit(exitUsage)
 	}
 
 	pkg, version, filePath := pos[0], pos[1], pos[2]
-	server := getFlag(flags, "server", defaultServer)
-	token := requireToken(flags)
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	// "-" streams the artifact from stdin; its size is unknown up front.
+	var body io.Reader
+	var file artifactFile
+	size := int64(-1)
+	if filePath == "-" {
+		body = os.Stdin
+	} else {
+		file = localArtifactFile(filePath)
+		file, err := os.Open(filePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error opening file: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		defer file.Close()
 
-	file, err := os.Open(filePath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error opening file: %v\n", err)
-		os.Exit(1)
+		info, err := file.Stat()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading file info: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		size = info.Size()
+
+		// Create a progress reader.
+		body = &progressReader{
+			reader: file,
+			total:  size,
+			label:  "Uploading",
+		}
 	}
-	defer file.Close()
 
-	info, err := file.Stat()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error reading file info: %v\n", err)
-		os.Exit(1)
+	if name := getFlag(flags, "filename", ""); name != "" {
+		file = localArtifactFile(name)
+	}
+	// --asset adds the file to the version under that name instead of
+	// publishing it as the version's default file.
+	url := artifactURL(server, pkg, version)
+	if asset := getFlag(flags, "asset", ""); asset != "" {
+		url = fileURL(server, pkg, version, asset)
+		if file.Name == "" || !hasFlag(flags, "filename") {
+			file = localArtifactFile(asset)
+		}
+	}
+	if ct := getFlag(flags, "content-type", ""); ct != "" {
+		file.ContentType = ct
+	}
+	file.Stage = getFlag(flags, "stage", "")
+	file.Stability = getFlag(flags, "stability", "")
+	file.Expires = getFlag(flags, "expires", "")
+
+	// --deps is read before uploading so a bad manifest fails early.
+	var deps []dependency
+	if path := getFlag(flags, "deps", ""); path != "" {
+		var err error
+		if deps, err = readDependencyManifest(path); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+
+	// --notes-file is read before uploading too.
+	var notes []byte
+	if path := getFlag(flags, "notes-file", ""); path != "" {
+		var err error
+		if notes, err = readInput(path); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFailure)
+		}
 	}
 
-	// Create a progress reader.
-	pr := &progressReader{
-		reader: file,
-		total:  info.Size(),
-		label:  "Uploading",
+	// --verify hashes the bytes as they are sent so the stored copy can be
+	// checked against them afterwards, which also covers stdin.
+	verify := hasFlag(flags, "verify")
+	hasher := sha256.New()
+	local := &countingReader{reader: body, counter: new(atomic.Int64)}
+	if verify {
+		body = io.TeeReader(local, hasher)
 	}
 
-	req, err := http.NewRequest("POST", artifactURL(server, pkg, version), pr)
+	// Large uploads can take a while after the last byte is sent, so the
+	// progress line goes on to show what the server is doing.
+	stopWatching := func() {}
+	if showProgress && filePath != "-" {
+		file.UploadID = newUploadID()
+		body, stopWatching = watchUpload(server, token, file.UploadID, body)
+	}
+
+	start := time.Now()
+	result, err := pushArtifact(url, token, body, size, "", file)
+	stopWatching()
+	if filePath != "-" {
+		endProgress()
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error creating request: %v\n", err)
-		os.Exit(1)
+		var httpErr *httpError
+		if errors.As(err, &httpErr) {
+			fmt.Fprintln(os.Stderr, httpErr.Error())
+		} else {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+		os.Exit(errorExitCode(err))
+	}
+	// A quarantined version cannot be read back until it is approved.
+	if verify && result.Quarantined {
+		fmt.Fprintf(os.Stderr, "warning: %s@%s is quarantined; skipping verification\n", pkg, version)
+		verify = false
+	}
+	if verify {
+		localHash := hex.EncodeToString(hasher.Sum(nil))
+		if err := verifyPush(url, token, localHash, local.counter.Load(), result, true); err != nil {
+			fmt.Fprintf(os.Stderr, "error: verification of %s@%s failed: %v\n", pkg, version, err)
+			os.Exit(errorExitCode(err))
+		}
+	}
+	if deps != nil {
+		if err := setDependencies(server, token, pkg, version, deps); err != nil {
+			fmt.Fprintf(os.Stderr, "error: pushed %s@%s but setting its dependencies failed: %v\n", pkg, version, err)
+			os.Exit(errorExitCode(err))
+		}
+	}
+	if notes != nil {
+		if err := setReleaseNotes(server, token, pkg, version, notes); err != nil {
+			fmt.Fprintf(os.Stderr, "error: pushed %s@%s but attaching its release notes failed: %v\n", pkg, version, err)
+			os.Exit(errorExitCode(err))
+		}
+	}
+	elapsed := time.Since(start)
+
+	report(os.Stdout, func() {
+		fmt.Printf("Pushed %s@%s\n", pkg, version)
+		fmt.Printf("  Hash:     %s\n", result.Hash)
+		fmt.Printf("  Size:     %s\n", formatBytes(result.Size))
+		if verify {
+			fmt.Printf("  Verified: stored bytes match local hash\n")
+		}
+		if result.Stage == "staging" {
+			fmt.Printf("  Stage:    staging\n")
+		}
+		if result.Stability != "" && result.Stability != "stable" {
+			fmt.Printf("  Stability: %s\n", result.Stability)
+		}
+		if result.ExpiresAt != nil {
+			fmt.Printf("  Expires:  %s\n", result.ExpiresAt.Format(time.RFC3339))
+		}
+		if result.Quarantined {
+			fmt.Printf("  Status:   quarantined until an admin approves it\n")
+		}
+		fmt.Printf("  Duration: %v\n", elapsed.Round(time.Millisecond))
+	}, "push", "package", pkg, "version", version, "hash", result.Hash, "size", result.Size, "verified", verify,
+		"quarantined", result.Quarantined, "stage", result.Stage, "stability", result.Stability, "duration", elapsed)
+}
+
+type pushResult struct {
+	Hash        string     `json:"hash"`
+	Size        int64      `json:"size"`
+	Quarantined bool       `json:"quarantined"`
+	Stage       string     `json:"stage"`
+	Stability   string     `json:"stability"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// artifactFile describes the uploaded file so the server can offer it back
+// under its original name and type. Stage, Stability and Expires, if set,
+// pick the stage a new version starts in, its stability and when it is
+// removed.
+type artifactFile struct {
+	Name        string
+	ContentType string
+	Stage       string
+	Stability   string
+	Expires     string
+	// UploadID names the upload for polling its progress.
+	UploadID string
+}
+
+// localArtifactFile describes the file at path, with the type inferred from
+// its extension.
+func localArtifactFile(path string) artifactFile {
+	name := filepath.Base(path)
+	return artifactFile{Name: name, ContentType: mime.TypeByExtension(filepath.Ext(name))}
+}
+
+// pushArtifact uploads body to url, an artifact or file URL. A negative size
+// sends the body chunked. A non-empty expectedHash asks the server to reject
+// the upload if the bytes it receives hash differently.
+func pushArtifact(url, token string, body io.Reader, size int64, expectedHash string, file artifactFile) (*pushResult, error) {
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/octet-stream")
-	req.ContentLength = info.Size()
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	if file.Name != "" {
+		req.Header.Set("X-Artifact-Filename", file.Name)
+	}
+	if file.Stage != "" {
+		req.Header.Set("X-Artifact-Stage", file.Stage)
+	}
+	if file.Stability != "" {
+		req.Header.Set("X-Artifact-Stability", file.Stability)
+	}
+	if file.Expires != "" {
+		req.Header.Set("X-Artifact-Expires", file.Expires)
+	}
+	if expectedHash != "" {
+		req.Header.Set("X-Artifact-Hash", expectedHash)
+	}
+	if file.UploadID != "" {
+		req.Header.Set("X-Upload-ID", file.UploadID)
+	}
+	req.ContentLength = size
 
-	start := time.Now()
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "\nerror: %v\n", err)
-		os.Exit(1)
+		return nil, err
 	}
 	defer resp.Body.Close()
-	fmt.Println() // newline after progress
 
-	if resp.StatusCode != http.StatusCreated {
-		fmt.Fprintln(os.Stderr, formatHTTPError(resp))
-		os.Exit(1)
+	// Servers with idempotent uploads answer a repeat of the stored
+	// content with 200.
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(resp)
 	}
 
-	var result struct {
-		Hash string `json:"hash"`
-	}
+	var result pushResult
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		fmt.Fprintf(os.Stderr, "error decoding response: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("decoding response: %w", err)
 	}
-	elapsed := time.Since(start)
-
-	fmt.Printf("Pushed %s@%s\n", pkg, version)
-	fmt.Printf("  Hash:     %s\n", result.Hash)
-	fmt.Printf("  Size:     %s\n", formatBytes(info.Size()))
-	fmt.Printf("  Duration: %v\n", elapsed.Round(time.Millisecond))
+	return &result, nil
 }
 
 func cmdPull(args []string) {
 	pos, flags := parseFlags(args)
+	if hasFlag(flags, "manifest") {
+		bulkPull(flags)
+		return
+	}
 	if len(pos) < 2 {
-		fmt.Fprintln(os.Stderr, "usage: registry pull <package> <version> [--server URL] [--token TOKEN] [--output FILE]")
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "usage: registry pull <package> <version> [--server URL] [--token TOKEN] [--output FILE] [--no-resume]")
+		os.Exit(exitUsage)
 	}
 
 	pkg, version := pos[0], pos[1]
-	server := getFlag(flags, "server", defaultServer)
-	token := requireToken(flags)
-	output := getFlag(flags, "output", fmt.Sprintf("%s-%s", pkg, version))
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	output := getFlag(flags, "output", "")
+	resume := !hasFlag(flags, "no-resume")
+	url := artifactURL(server, pkg, version)
+	if asset := getFlag(flags, "asset", ""); asset != "" {
+		url = fileURL(server, pkg, version, asset)
+		if output == "" {
+			output = filepath.Base(asset)
+		}
+	}
+	if output == "" {
+		output = defaultPullOutput(server, token, pkg, version)
+	}
+
+	if output == "-" {
+		pullToStdout(url, token, pkg, version)
+		return
+	}
 
-	req, err := http.NewRequest("GET", artifactURL(server, pkg, version), nil)
+	start := time.Now()
+	result, err := pullToFile(url, token, output, resume, nil)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error creating request: %v\n", err)
-		os.Exit(1)
+		var httpErr *httpError
+		if errors.As(err, &httpErr) {
+			fmt.Fprintln(os.Stderr, httpErr.Error())
+		} else {
+			fmt.Fprintf(os.Stderr, "error downloading: %v\n", err)
+			if tmpOutput := output + ".part"; fileExists(tmpOutput) {
+				fmt.Fprintf(os.Stderr, "partial download kept at %s; run pull again to resume\n", tmpOutput)
+			}
+		}
+		os.Exit(errorExitCode(err))
+	}
+
+	elapsed := time.Since(start)
+	printDeprecationNotice(result.deprecation)
+	report(os.Stdout, func() {
+		fmt.Printf("Pulled %s@%s -> %s\n", pkg, version, output)
+		fmt.Printf("  Hash:     %s\n", result.hash)
+		fmt.Printf("  Size:     %s\n", formatBytes(result.size))
+		if result.resumedFrom > 0 {
+			fmt.Printf("  Resumed:  from %s\n", formatBytes(result.resumedFrom))
+		}
+		fmt.Printf("  Duration: %v\n", elapsed.Round(time.Millisecond))
+	}, "pull", "package", pkg, "version", version, "output", output, "hash", result.hash,
+		"size", result.size, "resumed_from", result.resumedFrom, "duration", elapsed)
+}
+
+// defaultPullOutput names the downloaded file after the artifact's original
+// filename when the server recorded one, else <package>-<version>.
+func defaultPullOutput(server, token, pkg, version string) string {
+	var filename string
+	if info, err := lookupArtifact(server, token, pkg, version); err == nil {
+		filename = info.Filename
+	}
+	return pullOutputName(pkg, version, filename)
+}
+
+// pullOutputName is the base of filename, or <package>-<version> if it has
+// none.
+func pullOutputName(pkg, version, filename string) string {
+	if filename != "" {
+		if name := filepath.Base(filename); name != "." && name != string(filepath.Separator) {
+			return name
+		}
+	}
+	return fmt.Sprintf("%s-%s", pkg, version)
+}
+
+// pullToFile downloads url to output via a .part file, restarting once from
+// scratch if a resumed download fails hash verification.
+func pullToFile(url, token, output string, resume bool, counter *atomic.Int64) (*pullResult, error) {
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return nil, fmt.Errorf("creating output directory: %w", err)
+	}
+
+	tmpOutput := output + ".part"
+	result, err := fetchArtifact(url, token, tmpOutput, resume, counter)
+	if errors.Is(err, errHashMismatch) && result != nil && result.resumedFrom > 0 {
+		if counter == nil {
+			fmt.Fprintln(os.Stderr, "partial download did not match the artifact, restarting")
+		}
+		result, err = fetchArtifact(url, token, tmpOutput, false, counter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := replaceFile(tmpOutput, output); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+var errHashMismatch = errors.New("downloaded content does not match artifact hash")
+
+// httpError carries a non-success response from the server.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+// newHTTPError reads a non-success response into an httpError.
+func newHTTPError(resp *http.Response) *httpError {
+	return &httpError{status: resp.StatusCode, msg: formatHTTPError(resp)}
+}
+
+type pullResult struct {
+	hash        string
+	size        int64
+	resumedFrom int64
+	// deprecation is the server's warning if the version is deprecated.
+	deprecation string
+}
+
+// fetchArtifact downloads url into partPath. With resume set, an existing
+// partial file is continued with a Range request guarded by If-Range on the
+// ETag recorded when it was started, so a changed artifact restarts from
+// scratch. The finished file is verified against X-Artifact-Hash; a partial
+// file is kept on transfer errors so the next pull can pick it up. When
+// counter is set, received bytes are added to it instead of drawing a
+// progress bar.
+func fetchArtifact(url, token, partPath string, resume bool, counter *atomic.Int64) (*pullResult, error) {
+	etagPath := partPath + ".etag"
+
+	var offset int64
+	if resume {
+		if info, err := os.Stat(partPath); err == nil {
+			offset = info.Size()
+		}
+	} else {
+		_ = os.Remove(partPath)
+		_ = os.Remove(etagPath)
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+token)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if etag, err := os.ReadFile(etagPath); err == nil {
+			req.Header.Set("If-Range", strings.TrimSpace(string(etag)))
+		}
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintln(os.Stderr, formatHTTPError(resp))
-		os.Exit(1)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Either no range was asked for or the server chose to send it all.
+		offset = 0
+	case http.StatusPartialContent:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			return fetchArtifact(url, token, partPath, false, counter)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is already as long as the artifact, or longer.
+		if offset > 0 {
+			return fetchArtifact(url, token, partPath, false, counter)
+		}
+		return nil, newHTTPError(resp)
+	default:
+		return nil, newHTTPError(resp)
 	}
 
-	outputDir := filepath.Dir(output)
-	if err := os.MkdirAll(outputDir, 0o755); err != nil {
-		fmt.Fprintf(os.Stderr, "error creating output directory: %v\n", err)
-		os.Exit(1)
+	hasher := sha256.New()
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flag = os.O_CREATE | os.O_RDWR | os.O_APPEND
 	}
-
-	tmpOutput := output + ".part"
-	file, err := os.Create(tmpOutput)
+	file, err := os.OpenFile(partPath, flag, 0o644)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error creating output file: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("creating output file: %w", err)
 	}
-	success := false
-	defer func() {
-		file.Close()
-		if !success {
-			_ = os.Remove(tmpOutput)
+	defer file.Close()
+
+	if offset > 0 {
+		if _, err := io.Copy(hasher, io.NewSectionReader(file, 0, offset)); err != nil {
+			return nil, fmt.Errorf("reading partial download: %w", err)
 		}
-	}()
+	} else if etag := resp.Header.Get("ETag"); etag != "" {
+		if err := os.WriteFile(etagPath, []byte(etag), 0o644); err != nil {
+			return nil, fmt.Errorf("recording download etag: %w", err)
+		}
+	}
 
-	pr := &progressWriter{
-		writer: file,
-		total:  resp.ContentLength,
-		label:  "Downloading",
+	var dst io.Writer
+	if counter != nil {
+		dst = &countingWriter{writer: io.MultiWriter(file, hasher), counter: counter}
+	} else {
+		total := resp.ContentLength
+		if total >= 0 {
+			total += offset
+		}
+		dst = &progressWriter{
+			writer:  io.MultiWriter(file, hasher),
+			total:   total,
+			current: offset,
+			label:   "Downloading",
+		}
 	}
 
-	start := time.Now()
-	n, err := io.Copy(pr, resp.Body)
-	fmt.Println() // newline after progress
+	n, err := io.Copy(dst, resp.Body)
+	if counter == nil {
+		endProgress()
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error downloading: %v\n", err)
-		os.Exit(1)
+		return nil, err
 	}
 	if err := file.Close(); err != nil {
-		fmt.Fprintf(os.Stderr, "error closing downloaded file: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("closing downloaded file: %w", err)
+	}
+
+	result := &pullResult{
+		hash:        hex.EncodeToString(hasher.Sum(nil)),
+		size:        offset + n,
+		resumedFrom: offset,
+		deprecation: deprecationNotice(resp.Header),
+	}
+	if want := resp.Header.Get("X-Artifact-Hash"); want != "" && want != result.hash {
+		_ = os.Remove(partPath)
+		_ = os.Remove(etagPath)
+		return result, errHashMismatch
+	}
+	_ = os.Remove(etagPath)
+	return result, nil
+}
+
+// pullToStdout streams an artifact to stdout. Progress is suppressed and the
+// summary goes to stderr so the output can be piped. The hash is verified
+// once the stream ends; a mismatch exits non-zero after the bytes are gone.
+func pullToStdout(url, token, pkg, version string) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating request: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitNetwork)
 	}
-	if err := os.Remove(output); err != nil && !os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "error replacing output file: %v\n", err)
-		os.Exit(1)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		exitHTTPError(resp)
+	}
+
+	printDeprecationNotice(deprecationNotice(resp.Header))
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(os.Stdout, hasher), resp.Body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error downloading: %v\n", err)
+		os.Exit(errorExitCode(err))
 	}
-	if err := os.Rename(tmpOutput, output); err != nil {
-		fmt.Fprintf(os.Stderr, "error finalizing output file: %v\n", err)
-		os.Exit(1)
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if want := resp.Header.Get("X-Artifact-Hash"); want != "" && want != hash {
+		fmt.Fprintf(os.Stderr, "error: %v (got %s, want %s)\n", errHashMismatch, hash, want)
+		os.Exit(exitFailure)
 	}
-	success = true
 
 	elapsed := time.Since(start)
-	fmt.Printf("Pulled %s@%s -> %s\n", pkg, version, output)
-	fmt.Printf("  Hash:     %s\n", resp.Header.Get("X-Artifact-Hash"))
-	fmt.Printf("  Size:     %s\n", formatBytes(n))
-	fmt.Printf("  Duration: %v\n", elapsed.Round(time.Millisecond))
+	report(os.Stderr, func() {
+		fmt.Fprintf(os.Stderr, "Pulled %s@%s (%s, %s) in %v\n", pkg, version, hash, formatBytes(n), elapsed.Round(time.Millisecond))
+	}, "pull", "package", pkg, "version", version, "output", "-", "hash", hash, "size", n, "duration", elapsed)
+}
+
+// replaceFile moves a finished download into place over any existing file.
+func replaceFile(tmpPath, path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("replacing output file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("finalizing output file: %w", err)
+	}
+	return nil
+}
+
+// contentRangeStart parses the first byte position from a Content-Range
+// header of the form "bytes start-end/size".
+func contentRangeStart(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	first, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil
 }
 
 func cmdList(args []string) {
 	_, flags := parseFlags(args)
-	server := getFlag(flags, "server", defaultServer)
-	token := requireToken(flags)
-
-	req, _ := http.NewRequest("GET", packagesURL(server), nil)
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	endpoint := packagesURL(server)
+	query := url.Values{}
+	for _, name := range []string{"sort", "order"} {
+		if v := getFlag(flags, name, ""); v != "" {
+			query.Set(name, v)
+		}
+	}
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, _ := http.NewRequest("GET", endpoint, nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := getCached(flags, req)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitNetwork)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintln(os.Stderr, formatHTTPError(resp))
-		os.Exit(1)
+		exitHTTPError(resp)
 	}
 
-	var packages []map[string]interface{}
+	var packages []struct {
+		Name       string       `json:"name"`
+		Deprecated *deprecation `json:"deprecated,omitempty"`
+	}
 	if err := json.NewDecoder(resp.Body).Decode(&packages); err != nil {
 		fmt.Fprintf(os.Stderr, "error decoding response: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFailure)
 	}
 
 	if len(packages) == 0 {
@@ -276,40 +967,57 @@ func cmdList(args []string) {
 
 	fmt.Println("Packages:")
 	for _, p := range packages {
-		fmt.Printf("  - %v\n", p["name"])
+		if p.Deprecated != nil {
+			fmt.Printf("  - %s (DEPRECATED: %s)\n", p.Name, p.Deprecated)
+			continue
+		}
+		fmt.Printf("  - %s\n", p.Name)
 	}
 }
 
 func cmdSearch(args []string) {
 	pos, flags := parseFlags(args)
-	if len(pos) < 1 {
-		fmt.Fprintln(os.Stderr, "usage: registry search <query> [--server URL] [--token TOKEN]")
-		os.Exit(1)
+	component := getFlag(flags, "component", "")
+	if len(pos) < 1 && component == "" {
+		fmt.Fprintln(os.Stderr, "usage: registry search <query> [--component NAME [--component-version V]] [--server URL] [--token TOKEN]")
+		os.Exit(exitUsage)
 	}
 
-	query := pos[0]
-	server := getFlag(flags, "server", defaultServer)
-	token := requireToken(flags)
+	var query string
+	if len(pos) > 0 {
+		query = pos[0]
+	}
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	endpoint := searchURL(server, query)
+	// --component finds packages whose SBOMs list the component.
+	if component != "" {
+		endpoint += "&component=" + url.QueryEscape(component)
+		if v := getFlag(flags, "component-version", ""); v != "" {
+			endpoint += "&component_version=" + url.QueryEscape(v)
+		}
+		query = strings.TrimSpace(query + " with component " + component)
+	}
 
-	req, _ := http.NewRequest("GET", searchURL(server, query), nil)
+	req, _ := http.NewRequest("GET", endpoint, nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := getCached(flags, req)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitNetwork)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintln(os.Stderr, formatHTTPError(resp))
-		os.Exit(1)
+		exitHTTPError(resp)
 	}
 
 	var packages []map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&packages); err != nil {
 		fmt.Fprintf(os.Stderr, "error decoding response: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFailure)
 	}
 
 	if len(packages) == 0 {
@@ -327,29 +1035,59 @@ func cmdDelete(args []string) {
 	pos, flags := parseFlags(args)
 	if len(pos) < 2 {
 		fmt.Fprintln(os.Stderr, "usage: registry delete <package> <version> [--server URL] [--token TOKEN]")
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	pkg, version := pos[0], pos[1]
-	server := getFlag(flags, "server", defaultServer)
-	token := requireToken(flags)
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
 
 	req, _ := http.NewRequest("DELETE", artifactURL(server, pkg, version), nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitNetwork)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintln(os.Stderr, formatHTTPError(resp))
-		os.Exit(1)
+		exitHTTPError(resp)
 	}
 
-	fmt.Printf("Deleted %s@%s\n", pkg, version)
+	report(os.Stdout, func() {
+		fmt.Printf("Deleted %s@%s\n", pkg, version)
+	}, "delete", "package", pkg, "version", version)
+}
+
+// cmdPin pins or, with pinned false, unpins a version. Pinned versions
+// cannot be deleted; unpinning needs an admin token.
+func cmdPin(args []string, pinned bool) {
+	op, method := "pin", "PUT"
+	if !pinned {
+		op, method = "unpin", "DELETE"
+	}
+	pos, flags := parseFlags(args)
+	if len(pos) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: registry %s <package> <version> [--server URL] [--token TOKEN]\n", op)
+		os.Exit(exitUsage)
+	}
+
+	pkg, version := pos[0], pos[1]
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	if err := adminRequest(method, artifactURL(server, pkg, version)+"/pin", token, nil, http.StatusOK, nil); err != nil {
+		exitAdminError(err)
+	}
+	report(os.Stdout, func() {
+		if pinned {
+			fmt.Printf("Pinned %s@%s\n", pkg, version)
+		} else {
+			fmt.Printf("Unpinned %s@%s\n", pkg, version)
+		}
+	}, op, "package", pkg, "version", version)
 }
 
 // progressReader wraps a reader and prints progress.
@@ -368,6 +1106,9 @@ func (pr *progressReader) Read(p []byte) (int, error) {
 }
 
 func (pr *progressReader) printProgress() {
+	if !showProgress {
+		return
+	}
 	if pr.total <= 0 {
 		fmt.Fprintf(os.Stderr, "\r%s: %s", pr.label, formatBytes(pr.current))
 		return
@@ -395,6 +1136,9 @@ func (pw *progressWriter) Write(p []byte) (int, error) {
 }
 
 func (pw *progressWriter) printProgress() {
+	if !showProgress {
+		return
+	}
 	if pw.total <= 0 {
 		fmt.Fprintf(os.Stderr, "\r%s: %s", pw.label, formatBytes(pw.current))
 		return
@@ -406,6 +1150,30 @@ func (pw *progressWriter) printProgress() {
 	fmt.Fprintf(os.Stderr, "\r%s: [%s] %.1f%% %s/%s", pw.label, bar, pct, formatBytes(pw.current), formatBytes(pw.total))
 }
 
+// countingReader adds bytes read to a shared counter.
+type countingReader struct {
+	reader  io.Reader
+	counter *atomic.Int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.counter.Add(int64(n))
+	return n, err
+}
+
+// countingWriter adds bytes written to a shared counter.
+type countingWriter struct {
+	writer  io.Writer
+	counter *atomic.Int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.writer.Write(p)
+	cw.counter.Add(int64(n))
+	return n, err
+}
+
 func formatBytes(b int64) string {
 	const unit = 1024
 	if b < unit {
@@ -423,10 +1191,22 @@ func artifactURL(server, pkg, version string) string {
 	return fmt.Sprintf("%s/api/v1/artifacts/%s/%s", strings.TrimRight(server, "/"), url.PathEscape(pkg), url.PathEscape(version))
 }
 
+func fileURL(server, pkg, version, name string) string {
+	return artifactURL(server, pkg, version) + "/files/" + url.PathEscape(name)
+}
+
+func filesURL(server, pkg, version string) string {
+	return artifactURL(server, pkg, version) + "/files"
+}
+
 func packagesURL(server string) string {
 	return fmt.Sprintf("%s/api/v1/packages", strings.TrimRight(server, "/"))
 }
 
+func packageURL(server, pkg string) string {
+	return fmt.Sprintf("%s/api/v1/packages/%s", strings.TrimRight(server, "/"), url.PathEscape(pkg))
+}
+
 func searchURL(server, query string) string {
 	return fmt.Sprintf("%s/api/v1/packages?search=%s", strings.TrimRight(server, "/"), url.QueryEscape(query))
 }
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultConcurrency = 4
+
+// bulkManifest lists artifacts for push/pull --manifest. Relative file paths
+// are resolved against the manifest's directory.
+//
+//	artifacts:
+//	  - package: mylib
+//	    version: 1.0.0
+//	    file: dist/mylib.tar.gz
+//	    asset: mylib-linux.tar.gz   # optional: a named file of the version
+type bulkManifest struct {
+	Artifacts []bulkEntry `yaml:"artifacts"`
+}
+
+type bulkEntry struct {
+	Package string `yaml:"package"`
+	Version string `yaml:"version"`
+	File    string `yaml:"file"`
+	Asset   string `yaml:"asset"`
+}
+
+func (e bulkEntry) String() string {
+	if e.Asset != "" {
+		return fmt.Sprintf("%s@%s/%s", e.Package, e.Version, e.Asset)
+	}
+	return e.Package + "@" + e.Version
+}
+
+// url is the entry's artifact URL, or its named file's when Asset is set.
+func (e bulkEntry) url(server string) string {
+	if e.Asset != "" {
+		return fileURL(server, e.Package, e.Version, e.Asset)
+	}
+	return artifactURL(server, e.Package, e.Version)
+}
+
+type bulkResult struct {
+	entry bulkEntry
+	hash  string
+	size  int64
+	err   error
+	// notice warns that a pulled version is deprecated.
+	notice string
+	// detail follows the size in the summary, such as how a file was
+	// imported.
+	detail string
+}
+
+func loadBulkManifest(path string, requireFile bool) ([]bulkEntry, error) {
+	return loadManifestIn(path, filepath.Dir(path), requireFile)
+}
+
+// loadManifestIn loads a manifest like loadBulkManifest, resolving relative
+// file paths against base.
+func loadManifestIn(path, base string, requireFile bool) ([]bulkEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	var m bulkManifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing manifest: %w", err)
+	}
+	if len(m.Artifacts) == 0 {
+		return nil, errors.New("manifest lists no artifacts")
+	}
+
+	for i, e := range m.Artifacts {
+		if e.Package == "" || e.Version == "" {
+			return nil, fmt.Errorf("manifest entry %d: package and version are required", i+1)
+		}
+		if e.File == "" {
+			if requireFile {
+				return nil, fmt.Errorf("manifest entry %d (%s@%s): file is required", i+1, e.Package, e.Version)
+			}
+			e.File = fmt.Sprintf("%s-%s", e.Package, e.Version)
+			if e.Asset != "" {
+				e.File = filepath.Base(e.Asset)
+			}
+		}
+		if !filepath.IsAbs(e.File) {
+			e.File = filepath.Join(base, e.File)
+		}
+		m.Artifacts[i] = e
+	}
+	return m.Artifacts, nil
+}
+
+func concurrencyFlag(flags map[string]string) int {
+	v := getFlag(flags, "concurrency", "")
+	if v == "" {
+		return defaultConcurrency
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		fmt.Fprintf(os.Stderr, "error: invalid --concurrency %q\n", v)
+		os.Exit(exitUsage)
+	}
+	return n
+}
+
+func bulkPush(flags map[string]string) {
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	entries, err := loadBulkManifest(flags["manifest"], true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+
+	verify := hasFlag(flags, "verify")
+	runBulk("push", "Pushing", entries, concurrencyFlag(flags), func(e bulkEntry, counter *atomic.Int64) bulkResult {
+		file, err := os.Open(e.File)
+		if err != nil {
+			return bulkResult{entry: e, err: err}
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return bulkResult{entry: e, err: err}
+		}
+		hasher := sha256.New()
+		body := io.TeeReader(&countingReader{reader: file, counter: counter}, hasher)
+		desc := localArtifactFile(e.File)
+		if e.Asset != "" {
+			desc = localArtifactFile(e.Asset)
+		}
+		res, err := pushArtifact(e.url(server), token, body, info.Size(), "", desc)
+		if err != nil {
+			return bulkResult{entry: e, err: err}
+		}
+		if verify {
+			localHash := hex.EncodeToString(hasher.Sum(nil))
+			if err := verifyPush(e.url(server), token, localHash, info.Size(), res, false); err != nil {
+				return bulkResult{entry: e, err: fmt.Errorf("verification failed: %w", err)}
+			}
+		}
+		return bulkResult{entry: e, hash: res.Hash, size: res.Size}
+	})
+}
+
+func bulkPull(flags map[string]string) {
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+	resume := !hasFlag(flags, "no-resume")
+	entries, err := loadBulkManifest(flags["manifest"], false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+
+	runBulk("pull", "Pulling", entries, concurrencyFlag(flags), func(e bulkEntry, counter *atomic.Int64) bulkResult {
+		res, err := pullToFile(e.url(server), token, e.File, resume, counter)
+		if err != nil {
+			return bulkResult{entry: e, err: err}
+		}
+		return bulkResult{entry: e, hash: res.hash, size: res.size, notice: res.deprecation}
+	})
+}
+
+// runBulk processes entries with a pool of workers, drawing one aggregated
+// progress line, then prints a per-artifact summary. It exits non-zero if
+// any entry failed.
+func runBulk(op, label string, entries []bulkEntry, workers int, do func(bulkEntry, *atomic.Int64) bulkResult) {
+	var (
+		transferred atomic.Int64
+		done        atomic.Int64
+		failed      atomic.Int64
+	)
+	results := make([]bulkResult, len(entries))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(workers, len(entries)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = do(entries[i], &transferred)
+				if results[i].err != nil {
+					failed.Add(1)
+				}
+				done.Add(1)
+			}
+		}()
+	}
+
+	printProgress := func() {
+		if !showProgress {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "\r%s: %d/%d done, %d failed, %s transferred",
+			label, done.Load(), len(entries), failed.Load(), formatBytes(transferred.Load()))
+	}
+	stop := make(chan struct{})
+	ticked := make(chan struct{})
+	go func() {
+		defer close(ticked)
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				printProgress()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	for i := range entries {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	close(stop)
+	<-ticked
+	printProgress()
+	endProgress()
+
+	elapsed := time.Since(start)
+	succeeded := len(entries) - int(failed.Load())
+	if quiet {
+		for _, r := range results {
+			if r.err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s: %v\n", r.entry, r.err)
+			}
+			printDeprecationNotice(r.notice)
+		}
+		status := "ok"
+		if failed.Load() > 0 {
+			status = "failed"
+		}
+		fmt.Println(summaryLine(op+"-manifest", status, "succeeded", succeeded, "failed", failed.Load(),
+			"bytes", transferred.Load(), "duration", elapsed))
+	} else {
+		for _, r := range results {
+			if r.err != nil {
+				fmt.Printf("  FAIL  %s: %v\n", r.entry, r.err)
+				continue
+			}
+			if r.detail != "" {
+				fmt.Printf("  ok    %s  %s  %s  (%s)\n", r.entry, r.hash, formatBytes(r.size), r.detail)
+			} else {
+				fmt.Printf("  ok    %s  %s  %s\n", r.entry, r.hash, formatBytes(r.size))
+			}
+			printDeprecationNotice(r.notice)
+		}
+		fmt.Printf("%d succeeded, %d failed in %v\n", succeeded, failed.Load(), elapsed.Round(time.Millisecond))
+	}
+	if failed.Load() > 0 {
+		os.Exit(exitFailure)
+	}
+}
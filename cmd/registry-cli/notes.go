@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// cmdNotes prints, attaches or removes the Markdown release notes of a
+// version.
+func cmdNotes(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: registry notes <package> <version> [--set <file|->] [--clear] [--server URL] [--token TOKEN]")
+		os.Exit(exitUsage)
+	}
+
+	pkg, version := pos[0], pos[1]
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	if path := getFlag(flags, "set", ""); path != "" {
+		notes, err := readInput(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		if err := setReleaseNotes(server, token, pkg, version, notes); err != nil {
+			exitAdminError(err)
+		}
+		report(os.Stdout, func() {
+			fmt.Printf("Attached release notes to %s@%s\n", pkg, version)
+		}, "notes", "package", pkg, "version", version, "bytes", len(notes))
+		return
+	}
+	if hasFlag(flags, "clear") {
+		if err := adminRequest("DELETE", notesURL(server, pkg, version), token, nil, http.StatusOK, nil); err != nil {
+			exitAdminError(err)
+		}
+		report(os.Stdout, func() {
+			fmt.Printf("Removed the release notes of %s@%s\n", pkg, version)
+		}, "notes", "package", pkg, "version", version, "cleared", true)
+		return
+	}
+
+	req, _ := http.NewRequest("GET", notesURL(server, pkg, version), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		exitAdminError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		exitAdminError(newHTTPError(resp))
+	}
+	io.Copy(os.Stdout, resp.Body)
+}
+
+// setReleaseNotes attaches notes to a version, replacing any it has.
+func setReleaseNotes(server, token, pkg, version string, notes []byte) error {
+	return adminRequest("PUT", notesURL(server, pkg, version), token, notes, http.StatusOK, nil)
+}
+
+// printReleaseNotes prints a version's notes under a heading, indented to
+// set them apart from the details above.
+func printReleaseNotes(w io.Writer, notes string) {
+	fmt.Fprintln(w, "Release notes:")
+	for _, line := range strings.Split(strings.TrimRight(notes, "\n"), "\n") {
+		fmt.Fprintln(w, strings.TrimRight("  "+line, " "))
+	}
+}
+
+func notesURL(server, pkg, version string) string {
+	return artifactURL(server, pkg, version) + "/notes"
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// quiet replaces human-readable results with a single summary line.
+	quiet bool
+	// showProgress enables the carriage-return progress bar on stderr.
+	showProgress = true
+)
+
+// configureOutput applies --quiet and --no-progress. The progress bar is
+// also dropped when stderr is not a terminal, so CI logs are not filled with
+// thousands of partial lines.
+func configureOutput(flags map[string]string) {
+	quiet = hasFlag(flags, "quiet")
+	showProgress = !quiet && !hasFlag(flags, "no-progress") && isTerminal(os.Stderr)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// endProgress finishes the progress line, if one was drawn.
+func endProgress() {
+	if showProgress {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// report prints a command's result: the human form normally, or with
+// --quiet a single logfmt line for log scraping, e.g.
+//
+//	op=push status=ok package=mylib version=1.0.0 size=1024 duration=1.2s
+func report(w io.Writer, human func(), op string, kv ...any) {
+	if !quiet {
+		human()
+		return
+	}
+	fmt.Fprintln(w, summaryLine(op, "ok", kv...))
+}
+
+func summaryLine(op, status string, kv ...any) string {
+	var b strings.Builder
+	b.WriteString("op=" + op + " status=" + status)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&b, " %v=%s", kv[i], logfmtValue(kv[i+1]))
+	}
+	return b.String()
+}
+
+func logfmtValue(v any) string {
+	var s string
+	switch v := v.(type) {
+	case time.Duration:
+		s = v.Round(time.Millisecond).String()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " \"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// sbomInfo mirrors the SBOM summary returned by the server.
+type sbomInfo struct {
+	Hash        string    `json:"hash"`
+	Size        int64     `json:"size"`
+	Format      string    `json:"format"`
+	SpecVersion string    `json:"spec_version"`
+	Components  int       `json:"components"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+func cmdSBOM(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: registry sbom <package> <version> [--set <file|->] [--output <file|->] [--json]")
+		os.Exit(exitUsage)
+	}
+
+	pkg, version := pos[0], pos[1]
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	if path := getFlag(flags, "set", ""); path != "" {
+		data, err := readInput(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		var info sbomInfo
+		if err := adminRequest("PUT", sbomURL(server, pkg, version), token, data, http.StatusOK, &info); err != nil {
+			exitAdminError(err)
+		}
+		report(os.Stdout, func() {
+			fmt.Printf("Attached %s %s SBOM to %s@%s (%d components)\n", info.Format, info.SpecVersion, pkg, version, info.Components)
+		}, "sbom", "package", pkg, "version", version, "hash", info.Hash, "format", info.Format, "components", info.Components)
+		return
+	}
+
+	if output := getFlag(flags, "output", ""); output != "" {
+		if output == "-" {
+			pullToStdout(sbomURL(server, pkg, version), token, pkg, version)
+			return
+		}
+		result, err := pullToFile(sbomURL(server, pkg, version), token, output, false, nil)
+		endProgress()
+		if err != nil {
+			exitAdminError(err)
+		}
+		report(os.Stdout, func() {
+			fmt.Printf("Saved SBOM of %s@%s -> %s (%s)\n", pkg, version, output, formatBytes(result.size))
+		}, "sbom", "package", pkg, "version", version, "output", output, "hash", result.hash, "size", result.size)
+		return
+	}
+
+	var info sbomInfo
+	if err := adminRequest("GET", sbomURL(server, pkg, version)+"?metadata=true", token, nil, http.StatusOK, &info); err != nil {
+		exitAdminError(err)
+	}
+	if hasFlag(flags, "json") {
+		printJSON(info)
+		return
+	}
+	fmt.Printf("SBOM of %s@%s\n", pkg, version)
+	fmt.Printf("  Format:     %s %s\n", info.Format, info.SpecVersion)
+	fmt.Printf("  Components: %d\n", info.Components)
+	fmt.Printf("  Hash:       %s\n", info.Hash)
+	fmt.Printf("  Size:       %s\n", formatBytes(info.Size))
+	fmt.Printf("  Uploaded:   %s\n", info.UploadedAt.Format(time.RFC3339))
+}
+
+// readInput reads a whole file, or stdin for "-".
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("reading stdin: %w", err)
+		}
+		return data, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return data, nil
+}
+
+func sbomURL(server, pkg, version string) string {
+	return artifactURL(server, pkg, version) + "/sbom"
+}
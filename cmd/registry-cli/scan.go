@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// vulnerabilitySummary mirrors a scan report's findings by severity.
+type vulnerabilitySummary struct {
+	Critical int `json:"critical"`
+	High     int `json:"high"`
+	Medium   int `json:"medium"`
+	Low      int `json:"low"`
+	Unknown  int `json:"unknown"`
+}
+
+// scanReportInfo mirrors the scan report summary returned by the server.
+type scanReportInfo struct {
+	Hash      string               `json:"hash"`
+	Size      int64                `json:"size"`
+	Scanner   string               `json:"scanner"`
+	Summary   vulnerabilitySummary `json:"summary"`
+	ScannedAt time.Time            `json:"scanned_at"`
+}
+
+func cmdScan(args []string) {
+	pos, flags := parseFlags(args)
+	if len(pos) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: registry scan <package> <version> [--set <file|->] [--output <file|->] [--json]")
+		os.Exit(exitUsage)
+	}
+
+	pkg, version := pos[0], pos[1]
+	server := resolveServer(flags)
+	token := requireToken(flags, server)
+
+	if path := getFlag(flags, "set", ""); path != "" {
+		data, err := readInput(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		var info scanReportInfo
+		if err := adminRequest("PUT", scanURL(server, pkg, version), token, data, http.StatusOK, &info); err != nil {
+			exitAdminError(err)
+		}
+		report(os.Stdout, func() {
+			fmt.Printf("Attached %s scan report to %s@%s (%s)\n", info.Scanner, pkg, version, formatVulnerabilities(info.Summary))
+		}, "scan", "package", pkg, "version", version, "hash", info.Hash, "scanner", info.Scanner,
+			"critical", info.Summary.Critical, "high", info.Summary.High)
+		return
+	}
+
+	if output := getFlag(flags, "output", ""); output != "" {
+		if output == "-" {
+			pullToStdout(scanURL(server, pkg, version), token, pkg, version)
+			return
+		}
+		result, err := pullToFile(scanURL(server, pkg, version), token, output, false, nil)
+		endProgress()
+		if err != nil {
+			exitAdminError(err)
+		}
+		report(os.Stdout, func() {
+			fmt.Printf("Saved scan report of %s@%s -> %s (%s)\n", pkg, version, output, formatBytes(result.size))
+		}, "scan", "package", pkg, "version", version, "output", output, "hash", result.hash, "size", result.size)
+		return
+	}
+
+	var info scanReportInfo
+	if err := adminRequest("GET", scanURL(server, pkg, version)+"?metadata=true", token, nil, http.StatusOK, &info); err != nil {
+		exitAdminError(err)
+	}
+	if hasFlag(flags, "json") {
+		printJSON(info)
+		return
+	}
+	fmt.Printf("Scan report of %s@%s\n", pkg, version)
+	fmt.Printf("  Scanner:         %s\n", info.Scanner)
+	fmt.Printf("  Vulnerabilities: %s\n", formatVulnerabilities(info.Summary))
+	fmt.Printf("  Hash:            %s\n", info.Hash)
+	fmt.Printf("  Size:            %s\n", formatBytes(info.Size))
+	fmt.Printf("  Scanned:         %s\n", info.ScannedAt.Format(time.RFC3339))
+}
+
+// formatVulnerabilities lists the non-zero severity counts, most severe
+// first, e.g. "1 critical, 3 low".
+func formatVulnerabilities(v vulnerabilitySummary) string {
+	var parts []string
+	for _, c := range []struct {
+		n     int
+		label string
+	}{{v.Critical, "critical"}, {v.High, "high"}, {v.Medium, "medium"}, {v.Low, "low"}, {v.Unknown, "unknown"}} {
+		if c.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", c.n, c.label))
+		}
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func scanURL(server, pkg, version string) string {
+	return artifactURL(server, pkg, version) + "/scan"
+}
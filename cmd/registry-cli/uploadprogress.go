@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadPollInterval is how often push asks the server how far it has got
+// with an upload whose bytes have all been sent.
+const uploadPollInterval = 500 * time.Millisecond
+
+// uploadProgress mirrors the server's report on an upload.
+type uploadProgress struct {
+	ID            string     `json:"id"`
+	State         string     `json:"state"`
+	ReceivedBytes int64      `json:"received_bytes"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+func uploadURL(server, id string) string {
+	return fmt.Sprintf("%s/api/v1/uploads/%s", strings.TrimRight(server, "/"), url.PathEscape(id))
+}
+
+// newUploadID names an upload so its progress can be polled.
+func newUploadID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sentReader closes sent once its reader is exhausted.
+type sentReader struct {
+	reader io.Reader
+	sent   chan struct{}
+	once   sync.Once
+}
+
+func (s *sentReader) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p)
+	if err == io.EOF {
+		s.once.Do(func() { close(s.sent) })
+	}
+	return n, err
+}
+
+// watchUpload wraps the body of upload id so that, once it has all been
+// sent, the server's progress with it is shown on the progress line until
+// the returned stop func is called. Servers that do not report upload
+// progress are not asked again.
+func watchUpload(server, token, id string, body io.Reader) (io.Reader, func()) {
+	sent := &sentReader{reader: body, sent: make(chan struct{})}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-sent.sent:
+		case <-done:
+			return
+		}
+		start := time.Now()
+		ticker := time.NewTicker(uploadPollInterval)
+		defer ticker.Stop()
+		first := true
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			p, err := getUploadProgress(server, token, id)
+			if err != nil || p.FinishedAt != nil {
+				return
+			}
+			if first {
+				fmt.Fprintln(os.Stderr)
+				first = false
+			}
+			fmt.Fprintf(os.Stderr, "\rServer: %s (%s)\033[K", p.State, time.Since(start).Round(time.Second))
+		}
+	}()
+	return sent, func() {
+		close(done)
+		<-finished
+	}
+}
+
+func getUploadProgress(server, token, id string) (*uploadProgress, error) {
+	req, err := http.NewRequest("GET", uploadURL(server, id), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(resp)
+	}
+	var p uploadProgress
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return &p, nil
+}
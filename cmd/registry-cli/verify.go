@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// verifyPush confirms that the server stores exactly the bytes that were
+// uploaded: the upload response and a HEAD must report the local hash and
+// size, and re-reading the stored blob must hash to the same digest. bar
+// draws a progress line for the re-read.
+func verifyPush(url, token, localHash string, localSize int64, result *pushResult, bar bool) error {
+	if result.Hash != localHash || result.Size != localSize {
+		return fmt.Errorf("server recorded %s (%d bytes), local file is %s (%d bytes)",
+			result.Hash, result.Size, localHash, localSize)
+	}
+
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HEAD %s: %s", url, resp.Status)
+	}
+	if got := resp.Header.Get("X-Artifact-Hash"); got != localHash {
+		return fmt.Errorf("server reports hash %s, local file is %s", got, localHash)
+	}
+	if got, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); got != localSize {
+		return fmt.Errorf("server reports %d bytes, local file is %d", got, localSize)
+	}
+
+	// Re-read the stored bytes; the metadata alone cannot show that the blob
+	// on disk is intact.
+	req, err = http.NewRequest("GET", url+"?redirect=false", nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err = httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newHTTPError(resp)
+	}
+
+	hasher := sha256.New()
+	var sink io.Writer = hasher
+	if bar {
+		sink = &progressWriter{writer: hasher, total: localSize, label: "Verifying"}
+	}
+	n, err := io.Copy(sink, resp.Body)
+	if bar {
+		endProgress()
+	}
+	if err != nil {
+		return fmt.Errorf("re-reading artifact: %w", err)
+	}
+	if got := hex.EncodeToString(hasher.Sum(nil)); got != localHash || n != localSize {
+		return fmt.Errorf("stored bytes hash to %s (%d bytes), local file is %s (%d bytes)", got, n, localHash, localSize)
+	}
+	return nil
+}
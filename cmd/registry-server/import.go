@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/foundry/registry/internal/util/logging"
+	"github.com/foundry/registry/pkg/server"
+)
+
+// importManifest lists the versions to import and the files they come
+// from, in the layout of the CLI's bulk manifests. Relative file paths are
+// resolved against --dir.
+//
+//	artifacts:
+//	  - package: mylib
+//	    version: 1.0.0
+//	    file: mylib/mylib-1.0.0.tar.gz
+//	    hash: 9f86d0...               # optional: the expected SHA-256
+//	    description: Core library     # optional
+//	    labels: {team: platform}      # optional
+type importManifest struct {
+	Artifacts []importEntry `yaml:"artifacts"`
+}
+
+type importEntry struct {
+	Package     string            `yaml:"package"`
+	Version     string            `yaml:"version"`
+	File        string            `yaml:"file"`
+	Asset       string            `yaml:"asset"`
+	Hash        string            `yaml:"hash"`
+	Description string            `yaml:"description"`
+	Labels      map[string]string `yaml:"labels"`
+}
+
+// cmdImport publishes the versions a manifest lists from files under a
+// directory on this host, such as the mount of an old file share, straight
+// into the registry's storage without going through HTTP. It opens the
+// data directory like the server does, so the server must not be running.
+// Files are cloned where the filesystem allows and copied otherwise;
+// --link allows hard links instead of copies.
+func cmdImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	configPath := fs.String("config", "config.yaml", "path to config file")
+	dir := fs.String("dir", "", "directory holding the files to import")
+	manifestPath := fs.String("manifest", "", "manifest listing the versions to import (default: <dir>/manifest.yaml)")
+	link := fs.Bool("link", false, "hard-link files that cannot be cloned instead of copying them; the originals must then never change")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "usage: registry-server import --dir <dir> [--manifest <file>] [--link] [--config <file>]")
+		return 2
+	}
+	abs, err := filepath.Abs(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if *manifestPath == "" {
+		*manifestPath = filepath.Join(abs, "manifest.yaml")
+	}
+	entries, err := loadImportManifest(*manifestPath, abs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	cfg, err := server.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+		return 1
+	}
+	cfg.Storage.ImportDirs = append(cfg.Storage.ImportDirs, abs)
+	logger := logging.New(os.Stderr).With().Str("service", "foundry-registry").Logger()
+	srv, err := server.New(*cfg, server.WithLogger(logger))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	defer srv.Shutdown(context.Background())
+
+	failed := 0
+	for _, e := range entries {
+		res, err := srv.Import(context.Background(), e.Package, e.Version, server.ImportRequest{
+			Path:        e.File,
+			Hash:        e.Hash,
+			Description: e.Description,
+			Labels:      e.Labels,
+			Link:        *link,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s@%s: %v\n", e.Package, e.Version, err)
+			failed++
+			continue
+		}
+		how := res.Import
+		if how == "" {
+			how = "already present"
+		}
+		fmt.Printf("%s@%s  %s  %d bytes (%s)\n", e.Package, e.Version, res.Hash, res.Size, how)
+	}
+	fmt.Printf("imported %d of %d versions\n", len(entries)-failed, len(entries))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// loadImportManifest reads the manifest at path, resolving relative file
+// paths against dir.
+func loadImportManifest(path, dir string) ([]importEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	var m importManifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing manifest: %w", err)
+	}
+	if len(m.Artifacts) == 0 {
+		return nil, errors.New("manifest lists no artifacts")
+	}
+	for i := range m.Artifacts {
+		e := &m.Artifacts[i]
+		if e.Package == "" || e.Version == "" || e.File == "" {
+			return nil, fmt.Errorf("manifest entry %d: package, version and file are required", i+1)
+		}
+		if e.Asset != "" {
+			return nil, fmt.Errorf("manifest entry %d: named files cannot be imported", i+1)
+		}
+		if !filepath.IsAbs(e.File) {
+			e.File = filepath.Join(dir, e.File)
+		}
+	}
+	return m.Artifacts, nil
+}
@@ -1,69 +1,84 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/rs/zerolog"
 
-	"github.com/foundry/registry/internal/adapters/auth"
-	"github.com/foundry/registry/internal/adapters/metadata"
-	"github.com/foundry/registry/internal/adapters/storage"
-	"github.com/foundry/registry/internal/api/handlers"
 	"github.com/foundry/registry/internal/config"
+	"github.com/foundry/registry/internal/util/logging"
+	"github.com/foundry/registry/pkg/server"
 )
 
+// shutdownTimeout bounds how long in-flight requests may run after a stop
+// signal before connections are closed.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "unit":
+			os.Exit(cmdUnit(os.Args[2:]))
+		case "service":
+			os.Exit(cmdService(os.Args[2:]))
+		case "import":
+			os.Exit(cmdImport(os.Args[2:]))
+		}
+	}
+
 	configPath := flag.String("config", "config.yaml", "path to config file")
 	flag.Parse()
 
-	logger := zerolog.New(os.Stdout).With().Timestamp().Str("service", "foundry-registry").Logger()
+	logger := logging.New(os.Stdout).With().Str("service", "foundry-registry").Logger()
 
-	cfg, err := config.Load(*configPath)
-	if err != nil {
-		logger.Fatal().Err(err).Msg("failed to load config")
+	if isWindowsService() {
+		if err := runWindowsService(*configPath, logger); err != nil {
+			logger.Fatal().Err(err).Msg("service error")
+		}
+		return
 	}
 
-	// Initialize blob storage.
-	blobs, err := storage.NewDiskBlobStorage(cfg.Storage.DataDir)
-	if err != nil {
-		logger.Fatal().Err(err).Msg("failed to initialize blob storage")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := serve(ctx, *configPath, logger); err != nil {
+		logger.Fatal().Err(err).Msg("server error")
 	}
+}
 
-	// Initialize metadata store.
-	meta, err := metadata.NewSQLiteStore(cfg.Storage.DataDir)
+// serve runs the registry until ctx is canceled, then shuts down gracefully.
+func serve(ctx context.Context, configPath string, logger zerolog.Logger) error {
+	cfg, err := config.Load(configPath)
 	if err != nil {
-		logger.Fatal().Err(err).Msg("failed to initialize metadata store")
+		return fmt.Errorf("loading config: %w", err)
 	}
-	defer meta.Close()
-
-	// Initialize authenticator.
-	authenticator := auth.NewTokenAuth(cfg.Auth.Tokens)
-
-	// Initialize HTTP handlers.
-	handler := handlers.New(blobs, meta, authenticator, logger)
-
-	addr := fmt.Sprintf(":%d", cfg.Server.Port)
-	srv := &http.Server{
-		Addr:    addr,
-		Handler: handler.Router(),
+	srv, err := server.New(*cfg, server.WithLogger(logger))
+	if err != nil {
+		return err
+	}
+	if err := srv.Start(); err != nil {
+		srv.Shutdown(context.Background())
+		return err
 	}
 
-	// Graceful shutdown.
-	go func() {
-		sigCh := make(chan os.Signal, 1)
-		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-		<-sigCh
-		logger.Info().Msg("shutting down server")
-		srv.Close()
-	}()
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Wait() }()
+	var serveErr error
+	select {
+	case serveErr = <-errCh:
+	case <-ctx.Done():
+	}
 
-	logger.Info().Str("addr", addr).Msg("starting Foundry Registry server")
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		logger.Fatal().Err(err).Msg("server error")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && serveErr == nil {
+		serveErr = err
 	}
+	return serveErr
 }
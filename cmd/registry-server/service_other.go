@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+)
+
+func isWindowsService() bool {
+	return false
+}
+
+func runWindowsService(string, zerolog.Logger) error {
+	return errors.New("windows services are not supported on this platform")
+}
+
+func cmdService([]string) int {
+	fmt.Fprintln(os.Stderr, "service management is only available on Windows; use `registry-server unit` to generate a systemd or launchd unit")
+	return 1
+}
@@ -0,0 +1,157 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+
+	"github.com/foundry/registry/internal/config"
+)
+
+const serviceName = "FoundryRegistry"
+
+func isWindowsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// runWindowsService runs the server under the Service Control Manager. The
+// SCM starts services in the system directory, so the process moves to the
+// platform data directory first and relative config paths resolve there.
+func runWindowsService(configPath string, logger zerolog.Logger) error {
+	dataDir := config.DefaultDataDir()
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return fmt.Errorf("creating data directory: %w", err)
+	}
+	if err := os.Chdir(dataDir); err != nil {
+		return fmt.Errorf("changing to data directory: %w", err)
+	}
+	return svc.Run(serviceName, &registryService{configPath: configPath, logger: logger})
+}
+
+type registryService struct {
+	configPath string
+	logger     zerolog.Logger
+}
+
+func (s *registryService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- serve(ctx, s.configPath, s.logger) }()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				s.logger.Error().Err(err).Msg("server exited")
+				return true, 1
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32((shutdownTimeout + 5*time.Second).Milliseconds())}
+				cancel()
+				if err := <-done; err != nil {
+					s.logger.Error().Err(err).Msg("server shutdown")
+				}
+				return false, 0
+			}
+		}
+	}
+}
+
+// cmdService manages the Windows service registration:
+//
+//	registry-server service install [-config path]
+//	registry-server service uninstall|start|stop
+func cmdService(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: registry-server service <install|uninstall|start|stop> [-config path]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("service", flag.ContinueOnError)
+	configPath := fs.String("config", config.DefaultConfigPath(), "config file path used by the service")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error connecting to service manager: %v\n", err)
+		return 1
+	}
+	defer m.Disconnect()
+
+	switch args[0] {
+	case "install":
+		err = installService(m, *configPath)
+	case "uninstall":
+		err = withService(m, func(s *mgr.Service) error { return s.Delete() })
+	case "start":
+		err = withService(m, func(s *mgr.Service) error { return s.Start() })
+	case "stop":
+		err = withService(m, func(s *mgr.Service) error {
+			_, err := s.Control(svc.Stop)
+			return err
+		})
+	default:
+		fmt.Fprintf(os.Stderr, "unknown service command: %s\n", args[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("service %s: %s ok\n", serviceName, args[0])
+	return 0
+}
+
+func installService(m *mgr.Mgr, configPath string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("resolving executable path: %w", err)
+	}
+	if abs, err := filepath.Abs(configPath); err == nil {
+		configPath = abs
+	}
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Foundry Artifact Registry",
+		Description: "Self-hosted artifact registry for versioned binary files.",
+		StartType:   mgr.StartAutomatic,
+	}, "-config", configPath)
+	if err != nil {
+		return fmt.Errorf("creating service: %w", err)
+	}
+	defer s.Close()
+
+	return s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+	}, 86400)
+}
+
+func withService(m *mgr.Mgr, fn func(*mgr.Service) error) error {
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("opening service: %w", err)
+	}
+	defer s.Close()
+	return fn(s)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"text/template"
+
+	"github.com/foundry/registry/internal/config"
+)
+
+type unitParams struct {
+	Binary     string
+	ConfigPath string
+	DataDir    string
+	User       string
+}
+
+var systemdUnit = template.Must(template.New("systemd").Parse(`[Unit]
+Description=Foundry Artifact Registry
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+{{- if .User}}
+User={{.User}}
+Group={{.User}}
+{{- end}}
+ExecStart={{.Binary}} -config {{.ConfigPath}}
+WorkingDirectory={{.DataDir}}
+Restart=on-failure
+RestartSec=5
+LimitNOFILE=65536
+NoNewPrivileges=true
+ProtectSystem=full
+ReadWritePaths={{.DataDir}}
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+var launchdPlist = template.Must(template.New("launchd").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>com.foundry.registry</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{.Binary}}</string>
+		<string>-config</string>
+		<string>{{.ConfigPath}}</string>
+	</array>
+	<key>WorkingDirectory</key>
+	<string>{{.DataDir}}</string>
+{{- if .User}}
+	<key>UserName</key>
+	<string>{{.User}}</string>
+{{- end}}
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>{{.DataDir}}/registry.log</string>
+	<key>StandardErrorPath</key>
+	<string>{{.DataDir}}/registry.log</string>
+</dict>
+</plist>
+`))
+
+// cmdUnit prints a service definition for the host's init system. The unit
+// runs from the platform data directory, so relative paths in the config
+// (such as the default ./data) resolve under it.
+func cmdUnit(args []string) int {
+	fs := flag.NewFlagSet("unit", flag.ContinueOnError)
+	defaultType := "systemd"
+	if runtime.GOOS == "darwin" {
+		defaultType = "launchd"
+	}
+	unitType := fs.String("type", defaultType, "unit format: systemd or launchd")
+	configPath := fs.String("config", config.DefaultConfigPath(), "config file path used by the unit")
+	dataDir := fs.String("data-dir", config.DefaultDataDir(), "working directory for the service")
+	binary := fs.String("binary", "", "path to the registry-server binary (default: this executable)")
+	user := fs.String("user", "", "account to run the service as")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	params := unitParams{
+		Binary:     *binary,
+		ConfigPath: *configPath,
+		DataDir:    *dataDir,
+		User:       *user,
+	}
+	if params.Binary == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error resolving executable path: %v\n", err)
+			return 1
+		}
+		params.Binary = exe
+	}
+	if abs, err := filepath.Abs(params.Binary); err == nil {
+		params.Binary = abs
+	}
+
+	var tmpl *template.Template
+	switch *unitType {
+	case "systemd":
+		tmpl = systemdUnit
+	case "launchd":
+		tmpl = launchdPlist
+	default:
+		fmt.Fprintf(os.Stderr, "unknown unit type %q (want systemd or launchd)\n", *unitType)
+		return 2
+	}
+
+	if err := tmpl.Execute(os.Stdout, params); err != nil {
+		fmt.Fprintf(os.Stderr, "error rendering unit: %v\n", err)
+		return 1
+	}
+	return 0
+}
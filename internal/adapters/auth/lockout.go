@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/util/clock"
+)
+
+// Lockouts counts failed authentication attempts by key, such as a client
+// address, and locks a key out for a while once it fails too often, so
+// credentials cannot be guessed at request rate.
+type Lockouts struct {
+	maxFailures int
+	window      time.Duration
+	duration    time.Duration
+	clock       clock.Clock
+
+	mu        sync.Mutex
+	keys      map[string]*lockout
+	lastPrune time.Time
+	failures  int64
+	lockouts  int64
+	refused   int64
+}
+
+// lockout is the failures of one key within the window, and until when it
+// is locked out.
+type lockout struct {
+	failures []time.Time
+	until    time.Time
+}
+
+// NewLockouts locks a key out for duration once it has failed maxFailures
+// times within window of the time c tells.
+func NewLockouts(maxFailures int, window, duration time.Duration, c clock.Clock) *Lockouts {
+	return &Lockouts{maxFailures: maxFailures, window: window, duration: duration, clock: c, keys: make(map[string]*lockout)}
+}
+
+// Locked returns how much longer key is locked out, and whether it is. A
+// locked out key's request counts as refused.
+func (l *Lockouts) Locked(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k, ok := l.keys[key]
+	now := l.clock.Now()
+	if !ok || !now.Before(k.until) {
+		return 0, false
+	}
+	l.refused++
+	return k.until.Sub(now), true
+}
+
+// Fail records a failed attempt against each of keys, such as the
+// client's address and the key it signed with, and returns the keys it
+// locked out.
+func (l *Lockouts) Fail(keys ...string) []models.Lockout {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	l.prune(now)
+	l.failures++
+
+	var locked []models.Lockout
+	for _, key := range keys {
+		k, ok := l.keys[key]
+		if !ok {
+			k = &lockout{}
+			l.keys[key] = k
+		}
+		k.failures = append(recent(k.failures, now.Add(-l.window)), now)
+		if len(k.failures) < l.maxFailures || now.Before(k.until) {
+			continue
+		}
+		k.failures = nil
+		k.until = now.Add(l.duration)
+		l.lockouts++
+		locked = append(locked, models.Lockout{Key: key, LockedUntil: k.until.UTC()})
+	}
+	return locked
+}
+
+// Stats reports the failures, lockouts and refused requests counted since
+// the registry started, and the keys locked out now.
+func (l *Lockouts) Stats() models.AuthMetrics {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	stats := models.AuthMetrics{Failures: l.failures, Lockouts: l.lockouts, Refused: l.refused, Locked: []models.Lockout{}}
+	for key, k := range l.keys {
+		if now.Before(k.until) {
+			stats.Locked = append(stats.Locked, models.Lockout{Key: key, LockedUntil: k.until.UTC()})
+		}
+	}
+	slices.SortFunc(stats.Locked, func(a, b models.Lockout) int {
+		return cmp.Or(a.LockedUntil.Compare(b.LockedUntil), cmp.Compare(a.Key, b.Key))
+	})
+	return stats
+}
+
+// prune forgets keys without recent failures that are not locked out, at
+// most once a window. The caller holds mu.
+func (l *Lockouts) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.window {
+		return
+	}
+	for key, k := range l.keys {
+		k.failures = recent(k.failures, now.Add(-l.window))
+		if len(k.failures) == 0 && !now.Before(k.until) {
+			delete(l.keys, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+// recent drops the times in ts, which are in order, before since.
+func recent(ts []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(ts) && ts[i].Before(since) {
+		i++
+	}
+	return ts[i:]
+}
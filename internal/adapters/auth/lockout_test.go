@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/foundry/registry/internal/util/clock"
+)
+
+func TestLockouts(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(now)
+	l := NewLockouts(3, 10*time.Minute, 15*time.Minute, fake)
+
+	// Failures spread wider than the window never add up to a lockout.
+	for range 4 {
+		if locked := l.Fail("ip:10.0.0.1"); len(locked) != 0 {
+			t.Fatal("locked out by failures outside the window")
+		}
+		fake.Advance(6 * time.Minute)
+	}
+
+	l.Fail("ip:10.0.0.2", "key:ci")
+	l.Fail("ip:10.0.0.2")
+	if _, locked := l.Locked("ip:10.0.0.2"); locked {
+		t.Fatal("locked out before reaching the threshold")
+	}
+	locked := l.Fail("ip:10.0.0.2", "key:ci")
+	if len(locked) != 1 || locked[0].Key != "ip:10.0.0.2" || !locked[0].LockedUntil.Equal(fake.Now().Add(15*time.Minute)) {
+		t.Fatalf("Fail = %+v; expected a 15 minute lockout of the address only", locked)
+	}
+	if left, locked := l.Locked("ip:10.0.0.2"); !locked || left != 15*time.Minute {
+		t.Errorf("Locked = %s, %t", left, locked)
+	}
+	for _, key := range []string{"ip:10.0.0.1", "key:ci"} {
+		if _, locked := l.Locked(key); locked {
+			t.Errorf("%s should not be locked out", key)
+		}
+	}
+
+	stats := l.Stats()
+	if stats.Failures != 7 || stats.Lockouts != 1 || stats.Refused != 1 ||
+		len(stats.Locked) != 1 || stats.Locked[0].Key != "ip:10.0.0.2" {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// The lockout lifts after its duration, with the failures that caused
+	// it forgotten.
+	fake.Advance(15 * time.Minute)
+	if _, locked := l.Locked("ip:10.0.0.2"); locked {
+		t.Error("lockout should have expired")
+	}
+	if locked := l.Fail("ip:10.0.0.2"); len(locked) != 0 {
+		t.Error("a failure after the lockout should start a new count")
+	}
+	if stats := l.Stats(); len(stats.Locked) != 0 {
+		t.Errorf("expected no lockouts, got %+v", stats.Locked)
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foundry/registry/internal/util/clock"
+)
+
+func TestSignedRequests(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(now)
+	promoter := SigningKey{ID: "promoter", Secret: []byte("0123456789abcdef0123456789abcdef")}
+	admin := SigningKey{ID: "ops", Secret: []byte("fedcba9876543210fedcba9876543210"), Admin: true}
+	s := NewSignedRequests([]SigningKey{promoter, admin}, 5*time.Minute, fake)
+	target := "/api/v1/artifacts/app/1.0.0/stage"
+
+	header := Sign(promoter, now.Unix(), "PUT", target, EmptyContentHash)
+	p, err := s.Verify(header, "PUT", target, EmptyContentHash)
+	if err != nil || p.Name != "promoter" || p.Admin {
+		t.Fatalf("Verify = %+v, %v", p, err)
+	}
+	if _, err := s.Verify(header, "PUT", target, EmptyContentHash); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Errorf("a replayed signature should be refused: %v", err)
+	}
+	if p, err := s.Verify(Sign(admin, now.Unix(), "PUT", target, EmptyContentHash), "PUT", target, EmptyContentHash); err != nil || !p.Admin {
+		t.Errorf("admin key: %+v, %v", p, err)
+	}
+
+	otherHash := strings.Repeat("a", 64)
+	for name, tc := range map[string]struct {
+		header, method, target, hash string
+	}{
+		"method":       {Sign(promoter, now.Unix()+1, "PUT", target, EmptyContentHash), "DELETE", target, EmptyContentHash},
+		"target":       {Sign(promoter, now.Unix()+2, "PUT", target, EmptyContentHash), "PUT", target + "?force=1", EmptyContentHash},
+		"body":         {Sign(promoter, now.Unix()+3, "PUT", target, EmptyContentHash), "PUT", target, otherHash},
+		"no body hash": {Sign(promoter, now.Unix()+4, "PUT", target, ""), "PUT", target, ""},
+		"unknown key":  {Sign(SigningKey{ID: "who", Secret: promoter.Secret}, now.Unix(), "PUT", target, EmptyContentHash), "PUT", target, EmptyContentHash},
+		"wrong secret": {Sign(SigningKey{ID: "promoter", Secret: admin.Secret}, now.Unix(), "PUT", target, EmptyContentHash), "PUT", target, EmptyContentHash},
+		"stale":        {Sign(promoter, now.Add(-6*time.Minute).Unix(), "PUT", target, EmptyContentHash), "PUT", target, EmptyContentHash},
+		"future":       {Sign(promoter, now.Add(6*time.Minute).Unix(), "PUT", target, EmptyContentHash), "PUT", target, EmptyContentHash},
+		"malformed":    {SignatureScheme + " KeyId=promoter", "PUT", target, EmptyContentHash},
+	} {
+		if _, err := s.Verify(tc.header, tc.method, tc.target, tc.hash); err == nil {
+			t.Errorf("%s: a mismatched request was accepted", name)
+		}
+	}
+
+	// Within the skew either way is fine, and forgotten signatures are
+	// refused by their timestamp instead.
+	if _, err := s.Verify(Sign(promoter, now.Add(-4*time.Minute).Unix(), "GET", "/", EmptyContentHash), "GET", "/", EmptyContentHash); err != nil {
+		t.Errorf("signature within the skew: %v", err)
+	}
+	fake.Advance(10 * time.Minute)
+	if _, err := s.Verify(header, "PUT", target, EmptyContentHash); err == nil {
+		t.Error("an old signature should be refused")
+	}
+
+	// Replaced keys take effect for the next request.
+	rotated := SigningKey{ID: "promoter", Secret: []byte("abcdef0123456789abcdef0123456789")}
+	s.SetKeys([]SigningKey{rotated})
+	now = fake.Now()
+	if _, err := s.Verify(Sign(promoter, now.Unix(), "GET", "/", EmptyContentHash), "GET", "/", EmptyContentHash); err == nil {
+		t.Error("a rotated out secret should be refused")
+	}
+	if _, err := s.Verify(Sign(rotated, now.Unix(), "GET", "/", EmptyContentHash), "GET", "/", EmptyContentHash); err != nil {
+		t.Errorf("rotated secret: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// TokenPrefix marks issued tokens so they are recognizable in config files
+// and secret scanners.
+const TokenPrefix = "fdy_"
+
+// StoreAuth validates tokens issued through the admin API and persisted in a
+// services.TokenStore.
+type StoreAuth struct {
+	store services.TokenStore
+}
+
+// NewStoreAuth creates a StoreAuth backed by store.
+func NewStoreAuth(store services.TokenStore) *StoreAuth {
+	return &StoreAuth{store: store}
+}
+
+// Authenticate looks up the token by its hash. Lookup errors fail closed.
+func (a *StoreAuth) Authenticate(token string) (*models.Principal, bool) {
+	if token == "" {
+		return nil, false
+	}
+	t, err := a.store.LookupToken(HashToken(token))
+	if err != nil || t == nil {
+		return nil, false
+	}
+	return &models.Principal{TokenID: t.ID, Name: t.Name, Admin: t.Admin, User: t.User}, true
+}
+
+// HashToken returns the hex SHA256 under which a token secret is stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateToken returns a new random token secret.
+func GenerateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating token: %w", err)
+	}
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Chain tries each authenticator in order and accepts the first match.
+type Chain []services.Authenticator
+
+// Authenticate implements services.Authenticator.
+func (c Chain) Authenticate(token string) (*models.Principal, bool) {
+	for _, a := range c {
+		if p, ok := a.Authenticate(token); ok {
+			return p, true
+		}
+	}
+	return nil, false
+}
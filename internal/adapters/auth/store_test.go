@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+type memTokenStore struct {
+	tokens map[string]*models.APIToken
+}
+
+func (m *memTokenStore) CreateToken(name, secretHash string, admin bool) (*models.APIToken, error) {
+	t := &models.APIToken{ID: int64(len(m.tokens) + 1), Name: name, Admin: admin}
+	m.tokens[secretHash] = t
+	return t, nil
+}
+
+func (m *memTokenStore) LookupToken(secretHash string) (*models.APIToken, error) {
+	t := m.tokens[secretHash]
+	if t == nil || t.RevokedAt != nil {
+		return nil, nil
+	}
+	return t, nil
+}
+
+func (m *memTokenStore) ListTokens() ([]models.APIToken, error) { return nil, nil }
+
+func (m *memTokenStore) RevokeToken(id int64) error {
+	for _, t := range m.tokens {
+		if t.ID == id {
+			now := t.CreatedAt
+			t.RevokedAt = &now
+			return nil
+		}
+	}
+	return services.ErrNotFound
+}
+
+func TestStoreAuth(t *testing.T) {
+	store := &memTokenStore{tokens: make(map[string]*models.APIToken)}
+	secret, err := GenerateToken()
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	if !strings.HasPrefix(secret, TokenPrefix) {
+		t.Errorf("token %q lacks prefix %q", secret, TokenPrefix)
+	}
+	created, _ := store.CreateToken("ci", HashToken(secret), false)
+
+	a := NewStoreAuth(store)
+	p, ok := a.Authenticate(secret)
+	if !ok || p.TokenID != created.ID || p.Name != "ci" || p.Admin {
+		t.Fatalf("Authenticate = %+v, %v; want non-admin principal for token %d", p, ok, created.ID)
+	}
+	if _, ok := a.Authenticate(secret + "x"); ok {
+		t.Error("unknown token should not authenticate")
+	}
+
+	store.RevokeToken(created.ID)
+	if _, ok := a.Authenticate(secret); ok {
+		t.Error("revoked token should not authenticate")
+	}
+}
+
+func TestChain(t *testing.T) {
+	store := &memTokenStore{tokens: make(map[string]*models.APIToken)}
+	store.CreateToken("issued", HashToken("issued-secret"), false)
+	chain := Chain{NewTokenAuth([]string{"static"}), NewStoreAuth(store)}
+
+	if p, ok := chain.Authenticate("static"); !ok || !p.Admin {
+		t.Errorf("static token: got %+v, %v; want admin principal", p, ok)
+	}
+	if p, ok := chain.Authenticate("issued-secret"); !ok || p.Name != "issued" {
+		t.Errorf("issued token: got %+v, %v", p, ok)
+	}
+	if _, ok := chain.Authenticate("nope"); ok {
+		t.Error("unknown token should not authenticate")
+	}
+}
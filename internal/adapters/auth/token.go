@@ -1,20 +1,48 @@
 package auth
 
-// TokenAuth validates tokens against a static list.
+import (
+	"sync"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// TokenAuth validates tokens against a static list. Static tokens come from
+// the server config and carry admin rights.
 type TokenAuth struct {
+	mu     sync.RWMutex
 	tokens map[string]bool
 }
 
 // NewTokenAuth creates a new TokenAuth from a list of valid tokens.
 func NewTokenAuth(tokens []string) *TokenAuth {
+	a := &TokenAuth{}
+	a.SetTokens(tokens)
+	return a
+}
+
+// SetTokens replaces the list of valid tokens, as when the secrets they are
+// read from change.
+func (a *TokenAuth) SetTokens(tokens []string) {
 	m := make(map[string]bool, len(tokens))
 	for _, t := range tokens {
 		m[t] = true
 	}
-	return &TokenAuth{tokens: m}
+	a.mu.Lock()
+	a.tokens = m
+	a.mu.Unlock()
 }
 
 // ValidateToken returns true if the token is in the allowed list.
 func (a *TokenAuth) ValidateToken(token string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	return a.tokens[token]
 }
+
+// Authenticate returns an admin principal for tokens in the allowed list.
+func (a *TokenAuth) Authenticate(token string) (*models.Principal, bool) {
+	if !a.ValidateToken(token) {
+		return nil, false
+	}
+	return &models.Principal{Name: "config", Admin: true}, true
+}
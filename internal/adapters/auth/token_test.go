@@ -25,3 +25,14 @@ func TestTokenAuth_EmptyTokenList(t *testing.T) {
 		t.Error("no tokens configured, nothing should validate")
 	}
 }
+
+func TestTokenAuth_SetTokens(t *testing.T) {
+	auth := NewTokenAuth([]string{"old"})
+	auth.SetTokens([]string{"new"})
+	if auth.ValidateToken("old") {
+		t.Error("replaced token should be invalid")
+	}
+	if !auth.ValidateToken("new") {
+		t.Error("new token should be valid")
+	}
+}
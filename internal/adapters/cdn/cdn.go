@@ -0,0 +1,76 @@
+// Package cdn signs download URLs served through a CDN in front of the
+// registry.
+package cdn
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foundry/registry/internal/util/clock"
+)
+
+// BlobPath is the path of a blob under the CDN base URL and the registry
+// alike. The CDN forwards misses to the registry at the same path.
+func BlobPath(hash string) string {
+	return "/cdn/blobs/" + hash
+}
+
+// Signer hands out CDN URLs for blobs, signed so that only the registry
+// can mint them, and checks those signatures when the CDN fetches a blob.
+//
+// A URL is BaseURL + BlobPath(hash) + "?expires=<unix>&signature=<hex>",
+// where the signature is the HMAC-SHA256 of the path and expires query,
+// "/cdn/blobs/<hash>?expires=<unix>", keyed by the signing key. Blobs are
+// named by their content hash, so a version overwritten with new content
+// gets a new URL and never a stale edge copy.
+type Signer struct {
+	baseURL string
+	key     []byte
+	clock   clock.Clock
+}
+
+// NewSigner creates a Signer for URLs under baseURL.
+func NewSigner(baseURL string, key []byte, c clock.Clock) *Signer {
+	return &Signer{baseURL: strings.TrimSuffix(baseURL, "/"), key: key, clock: c}
+}
+
+// SignedURL returns a URL for the blob valid for at least ttl. The expiry
+// is rounded up to a multiple of ttl, so every download in the same window
+// gets the same URL and the edge caches the blob once.
+func (s *Signer) SignedURL(hash string, ttl time.Duration) (string, error) {
+	window := int64(ttl / time.Second)
+	if window <= 0 {
+		return "", fmt.Errorf("signed URL lifetime %s is under a second", ttl)
+	}
+	now := s.clock.Now().Unix()
+	expires := (now + window + window - 1) / window * window
+	path := BlobPath(hash)
+	return s.baseURL + path + "?expires=" + strconv.FormatInt(expires, 10) +
+		"&signature=" + s.sign(path, expires), nil
+}
+
+// Verify reports whether query holds an unexpired signature for path.
+func (s *Signer) Verify(path string, query url.Values) bool {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || s.clock.Now().Unix() > expires {
+		return false
+	}
+	got, err := hex.DecodeString(query.Get("signature"))
+	if err != nil {
+		return false
+	}
+	want, _ := hex.DecodeString(s.sign(path, expires))
+	return hmac.Equal(got, want)
+}
+
+func (s *Signer) sign(path string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(path + "?expires=" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,55 @@
+package cdn
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foundry/registry/internal/util/clock"
+)
+
+func TestSignedURL(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	s := NewSigner("https://cdn.example.com/foundry/", []byte("key"), clk)
+
+	raw, err := s.SignedURL("abc", time.Minute)
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+	if !strings.HasPrefix(raw, "https://cdn.example.com/foundry/cdn/blobs/abc?expires=1080&signature=") {
+		t.Fatalf("unexpected URL %s", raw)
+	}
+	u, _ := url.Parse(raw)
+	if !s.Verify("/cdn/blobs/abc", u.Query()) {
+		t.Error("own signature did not verify")
+	}
+
+	// Downloads in the same window share a URL.
+	clk.Advance(10 * time.Second)
+	if again, _ := s.SignedURL("abc", time.Minute); again != raw {
+		t.Errorf("URL changed within the window: %s", again)
+	}
+
+	if s.Verify("/cdn/blobs/abd", u.Query()) {
+		t.Error("signature verified for another blob")
+	}
+	other := NewSigner("https://cdn.example.com", []byte("other"), clk)
+	if other.Verify("/cdn/blobs/abc", u.Query()) {
+		t.Error("signature verified with another key")
+	}
+	tampered := u.Query()
+	tampered.Set("expires", "99999")
+	if s.Verify("/cdn/blobs/abc", tampered) {
+		t.Error("signature verified with a changed expiry")
+	}
+
+	clk.Set(time.Unix(1081, 0))
+	if s.Verify("/cdn/blobs/abc", u.Query()) {
+		t.Error("expired signature verified")
+	}
+
+	if _, err := s.SignedURL("abc", time.Millisecond); err == nil {
+		t.Error("expected an error for a sub-second lifetime")
+	}
+}
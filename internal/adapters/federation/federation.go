@@ -0,0 +1,70 @@
+// Package federation searches other Foundry registries through their
+// package API.
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// maxResponse bounds the package list read from a peer.
+const maxResponse = 16 << 20
+
+// Peer is another registry, reached at its base URL with a token of its
+// own. Searches see what that token may see there.
+type Peer struct {
+	name    string
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewPeer creates a client for the registry at baseURL, such as
+// https://eu.registry.example.com or https://example.com/foundry, named
+// name in search results. Requests are bounded by the caller's context.
+func NewPeer(name, baseURL, token string) *Peer {
+	return &Peer{name: name, baseURL: strings.TrimSuffix(baseURL, "/"), token: token, client: &http.Client{}}
+}
+
+// Name implements services.PeerRegistry.
+func (p *Peer) Name() string {
+	return p.name
+}
+
+// SearchPackages implements services.PeerRegistry with the peer's
+// GET /api/v1/packages, which searches only that registry, so peers that
+// federate back to this one do not loop.
+func (p *Peer) SearchPackages(ctx context.Context, query string) ([]models.Package, error) {
+	u := p.baseURL + "/api/v1/packages"
+	if query != "" {
+		u += "?" + url.Values{"search": {query}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var pkgs []models.Package
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponse)).Decode(&pkgs); err != nil {
+		return nil, fmt.Errorf("decoding packages: %w", err)
+	}
+	return pkgs, nil
+}
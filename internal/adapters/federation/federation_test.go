@@ -0,0 +1,47 @@
+package federation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPeerSearchPackages(t *testing.T) {
+	var gotQuery, gotAuth string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/foundry/api/v1/packages" {
+			http.NotFound(w, r)
+			return
+		}
+		gotQuery, gotAuth = r.URL.RawQuery, r.Header.Get("Authorization")
+		w.WriteHeader(status)
+		w.Write([]byte(`[{"id":7,"name":"mylib"},{"id":9,"name":"mylib-extra"}]`))
+	}))
+	defer srv.Close()
+
+	peer := NewPeer("eu", srv.URL+"/foundry/", "peer-token")
+	if peer.Name() != "eu" {
+		t.Errorf("Name() = %q", peer.Name())
+	}
+	pkgs, err := peer.SearchPackages(context.Background(), "my lib")
+	if err != nil {
+		t.Fatalf("SearchPackages: %v", err)
+	}
+	if len(pkgs) != 2 || pkgs[0].Name != "mylib" || pkgs[1].Name != "mylib-extra" {
+		t.Errorf("unexpected packages %+v", pkgs)
+	}
+	if gotQuery != "search=my+lib" || gotAuth != "Bearer peer-token" {
+		t.Errorf("request query %q, authorization %q", gotQuery, gotAuth)
+	}
+
+	if _, err := peer.SearchPackages(context.Background(), ""); err != nil || gotQuery != "" {
+		t.Errorf("listing: err %v, query %q", err, gotQuery)
+	}
+
+	status = http.StatusUnauthorized
+	if _, err := peer.SearchPackages(context.Background(), "mylib"); err == nil {
+		t.Error("expected an error for a 401")
+	}
+}
@@ -0,0 +1,135 @@
+// Package hooks runs hooks outside the server: commands run for each event
+// and web services called with it.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// maxReason bounds how much of a hook's output becomes the reason a
+// request was rejected.
+const maxReason = 1 << 10
+
+// Command runs a program for each event, writing the event as JSON to its
+// standard input. Exiting 0 lets the request continue; any other exit
+// status rejects it, with the program's standard error as the reason.
+type Command struct {
+	args    []string
+	hooks   []string
+	timeout time.Duration
+}
+
+// NewCommand creates a hook running args at the hook points named in
+// hooks, or at every hook point if hooks is empty. Each run is bounded by
+// timeout.
+func NewCommand(args []string, hooks []string, timeout time.Duration) *Command {
+	return &Command{args: args, hooks: hooks, timeout: timeout}
+}
+
+// Run implements services.Hook.
+func (c *Command) Run(ctx context.Context, event models.HookEvent) error {
+	if !handles(c.hooks, event.Hook) {
+		return nil
+	}
+	input, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encoding hook event: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.args[0], c.args[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return fmt.Errorf("running %s: %w", c.args[0], ctx.Err())
+	case errors.As(err, &exitErr):
+		return rejection(stderr.Bytes(), fmt.Sprintf("%s exited with status %d", c.args[0], exitErr.ExitCode()))
+	default:
+		return fmt.Errorf("running %s: %w", c.args[0], err)
+	}
+}
+
+// Webhook posts each event as JSON to a URL. A 2xx response lets the
+// request continue; a 4xx rejects it, with the response body as the
+// reason. Other responses and failed calls are errors.
+type Webhook struct {
+	url    string
+	hooks  []string
+	client *http.Client
+}
+
+// NewWebhook creates a hook calling url at the hook points named in hooks,
+// or at every hook point if hooks is empty. Each call is bounded by
+// timeout.
+func NewWebhook(url string, hooks []string, timeout time.Duration) *Webhook {
+	return &Webhook{url: url, hooks: hooks, client: &http.Client{Timeout: timeout}}
+}
+
+// Run implements services.Hook.
+func (wh *Webhook) Run(ctx context.Context, event models.HookEvent) error {
+	if !handles(wh.hooks, event.Hook) {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encoding hook event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building hook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling hook: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, maxReason))
+		return rejection(reason, "hook responded "+resp.Status)
+	default:
+		return fmt.Errorf("calling hook: unexpected status %s", resp.Status)
+	}
+}
+
+// handles reports whether a hook registered for hooks runs at hook.
+func handles(hooks []string, hook string) bool {
+	return len(hooks) == 0 || slices.Contains(hooks, hook)
+}
+
+// rejection returns an ErrHookRejected error giving the hook's output as
+// the reason, or fallback if it wrote nothing.
+func rejection(output []byte, fallback string) error {
+	if len(output) > maxReason {
+		output = output[:maxReason]
+	}
+	reason := strings.TrimSpace(string(output))
+	if reason == "" {
+		reason = fallback
+	}
+	return fmt.Errorf("%w: %s", services.ErrHookRejected, reason)
+}
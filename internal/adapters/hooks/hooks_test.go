@@ -0,0 +1,78 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+var event = models.HookEvent{Hook: models.HookPreUpload, Package: "app", Version: "1.0.0", Hash: "abc", Size: 4}
+
+func TestCommand(t *testing.T) {
+	for _, tc := range []struct {
+		script string
+		hooks  []string
+		reason string // "" allows
+	}{
+		{script: `grep -q '"package":"app"'`},
+		{script: `echo "license not approved" >&2; exit 1`, reason: "license not approved"},
+		{script: `exit 3`, reason: "exited with status 3"},
+		{script: `exit 1`, hooks: []string{models.HookPreDelete}},
+	} {
+		err := NewCommand([]string{"sh", "-c", tc.script}, tc.hooks, 5*time.Second).Run(context.Background(), event)
+		switch {
+		case tc.reason == "" && err != nil:
+			t.Errorf("%s: %v, want allowed", tc.script, err)
+		case tc.reason != "" && (!errors.Is(err, services.ErrHookRejected) || !strings.Contains(err.Error(), tc.reason)):
+			t.Errorf("%s: %v, want rejected with %q", tc.script, err, tc.reason)
+		}
+	}
+
+	err := NewCommand([]string{"sleep", "5"}, nil, 50*time.Millisecond).Run(context.Background(), event)
+	if err == nil || errors.Is(err, services.ErrHookRejected) {
+		t.Errorf("timed out command: %v, want a failure rather than a rejection", err)
+	}
+	err = NewCommand([]string{"/nonexistent/hook"}, nil, time.Second).Run(context.Background(), event)
+	if err == nil || errors.Is(err, services.ErrHookRejected) {
+		t.Errorf("missing command: %v, want a failure rather than a rejection", err)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var status int
+	var got models.HookEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+		if status == http.StatusForbidden {
+			w.Write([]byte("export controlled\n"))
+		}
+	}))
+	defer srv.Close()
+	hook := NewWebhook(srv.URL, []string{models.HookPreUpload}, time.Second)
+
+	status = http.StatusNoContent
+	if err := hook.Run(context.Background(), event); err != nil || got != event {
+		t.Errorf("allowed: %v, server got %+v", err, got)
+	}
+	status = http.StatusForbidden
+	if err := hook.Run(context.Background(), event); !errors.Is(err, services.ErrHookRejected) || !strings.HasSuffix(err.Error(), ": export controlled") {
+		t.Errorf("rejected: %v", err)
+	}
+	status = http.StatusBadGateway
+	if err := hook.Run(context.Background(), event); err == nil || errors.Is(err, services.ErrHookRejected) {
+		t.Errorf("server error: %v, want a failure rather than a rejection", err)
+	}
+	got = models.HookEvent{}
+	if err := hook.Run(context.Background(), models.HookEvent{Hook: models.HookPreDownload}); err != nil || got.Hook != "" {
+		t.Error("a webhook should not be called at hook points it was not configured for")
+	}
+}
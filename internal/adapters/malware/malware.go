@@ -0,0 +1,143 @@
+// Package malware scans blobs for malware with ClamAV or an external
+// command.
+package malware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// chunkSize is how much of a blob is sent to clamd per INSTREAM chunk.
+const chunkSize = 64 << 10
+
+// ClamAV streams blobs to a clamd daemon with its INSTREAM command.
+type ClamAV struct {
+	network, address string
+	timeout          time.Duration
+}
+
+// NewClamAV creates a scanner for the clamd listening on address, either
+// host:port or unix:/path for its local socket. Each scan is bounded by
+// timeout.
+func NewClamAV(address string, timeout time.Duration) *ClamAV {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return &ClamAV{network: "unix", address: path, timeout: timeout}
+	}
+	return &ClamAV{network: "tcp", address: address, timeout: timeout}
+}
+
+// Scan implements services.MalwareScanner.
+func (c *ClamAV) Scan(ctx context.Context, r io.Reader) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", fmt.Errorf("connecting to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", fmt.Errorf("sending to clamd: %w", err)
+	}
+	// clamd answers early, and closes the stream, when the data exceeds
+	// its StreamMaxLength; the reply below says so.
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, werr := conn.Write(buf[:4+n]); werr != nil {
+				break
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading blob: %w", err)
+		}
+	}
+	conn.Write([]byte{0, 0, 0, 0})
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("reading clamd reply: %w", err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply reads a reply such as "stream: OK" or
+// "stream: Eicar-Test-Signature FOUND".
+func parseClamdReply(reply string) (string, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// Command scans a blob by running a program with the blob on its standard
+// input, as clamdscan - does. Exit status 0 means clean and 1 infected,
+// with the signature on standard output; anything else is an error.
+type Command struct {
+	args    []string
+	timeout time.Duration
+}
+
+// NewCommand creates a scanner running args. Each scan is bounded by
+// timeout.
+func NewCommand(args []string, timeout time.Duration) *Command {
+	return &Command{args: args, timeout: timeout}
+}
+
+// Scan implements services.MalwareScanner.
+func (c *Command) Scan(ctx context.Context, r io.Reader) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.args[0], c.args[1:]...)
+	cmd.Stdin = r
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "", nil
+	case ctx.Err() != nil:
+		return "", fmt.Errorf("running %s: %w", c.args[0], ctx.Err())
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		signature, _, _ := strings.Cut(strings.TrimSpace(stdout.String()), "\n")
+		// clamdscan and clamscan print "stream: <signature> FOUND".
+		if found, ok := strings.CutSuffix(signature, " FOUND"); ok {
+			if i := strings.LastIndex(found, ": "); i >= 0 {
+				found = found[i+2:]
+			}
+			signature = found
+		}
+		if signature == "" {
+			signature = "malware found"
+		}
+		return signature, nil
+	default:
+		return "", fmt.Errorf("running %s: %w: %s", c.args[0], err, strings.TrimSpace(stderr.String()))
+	}
+}
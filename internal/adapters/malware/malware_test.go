@@ -0,0 +1,101 @@
+package malware
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd answers INSTREAM scans on a local port, finding "EICAR" in
+// any stream that contains it.
+func fakeClamd(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				cmd := make([]byte, len("zINSTREAM\x00"))
+				if _, err := io.ReadFull(conn, cmd); err != nil || string(cmd) != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+				var data bytes.Buffer
+				for {
+					var n uint32
+					if err := binary.Read(conn, binary.BigEndian, &n); err != nil {
+						return
+					}
+					if n == 0 {
+						break
+					}
+					io.CopyN(&data, conn, int64(n))
+				}
+				if bytes.Contains(data.Bytes(), []byte("EICAR")) {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamAV(t *testing.T) {
+	clam := NewClamAV(fakeClamd(t), time.Second)
+
+	// More than one chunk, with the marker in the second.
+	clean := strings.Repeat("a", chunkSize+10)
+	if sig, err := clam.Scan(context.Background(), strings.NewReader(clean)); err != nil || sig != "" {
+		t.Errorf("clean: %q, %v", sig, err)
+	}
+	if sig, err := clam.Scan(context.Background(), strings.NewReader(clean+"EICAR")); err != nil || sig != "Eicar-Test-Signature" {
+		t.Errorf("infected: %q, %v", sig, err)
+	}
+	if _, err := NewClamAV("127.0.0.1:1", time.Second).Scan(context.Background(), strings.NewReader("x")); err == nil {
+		t.Error("expected an error when clamd is unreachable")
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	for reply, want := range map[string]string{
+		"stream: OK":                         "",
+		"stream: Win.Test.EICAR_HDB-1 FOUND": "Win.Test.EICAR_HDB-1",
+	} {
+		if got, err := parseClamdReply(reply); err != nil || got != want {
+			t.Errorf("parseClamdReply(%q) = %q, %v; want %q", reply, got, err, want)
+		}
+	}
+	if _, err := parseClamdReply("INSTREAM size limit exceeded. ERROR"); err == nil {
+		t.Error("expected an error for a clamd error reply")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	scanner := NewCommand([]string{"sh", "-c",
+		`if grep -q EICAR; then echo "stream: Eicar-Test-Signature FOUND"; exit 1; fi`}, 5*time.Second)
+
+	if sig, err := scanner.Scan(context.Background(), strings.NewReader("clean")); err != nil || sig != "" {
+		t.Errorf("clean: %q, %v", sig, err)
+	}
+	if sig, err := scanner.Scan(context.Background(), strings.NewReader("xEICARx")); err != nil || sig != "Eicar-Test-Signature" {
+		t.Errorf("infected: %q, %v", sig, err)
+	}
+	broken := NewCommand([]string{"sh", "-c", "echo cannot reach daemon >&2; exit 2"}, 5*time.Second)
+	if _, err := broken.Scan(context.Background(), strings.NewReader("x")); err == nil || !strings.Contains(err.Error(), "cannot reach daemon") {
+		t.Errorf("exit 2: %v, want an error with the scanner's output", err)
+	}
+}
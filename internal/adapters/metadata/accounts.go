@@ -0,0 +1,274 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// SQLiteStore also implements services.AccountStore.
+
+func (s *SQLiteStore) CreateUser(name string) (*models.User, error) {
+	now := s.clock.Now().UTC()
+	result, err := s.db.Exec("INSERT INTO users (name, created_at) VALUES (?, ?)", name, now)
+	if err != nil {
+		if isUniqueConstraint(err) {
+			return nil, fmt.Errorf("%w: user %s already exists", services.ErrConflict, name)
+		}
+		return nil, fmt.Errorf("creating user: %w", err)
+	}
+	id, _ := result.LastInsertId()
+	return &models.User{ID: id, Name: name, CreatedAt: now}, nil
+}
+
+func (s *SQLiteStore) GetUser(name string) (*models.User, error) {
+	var u models.User
+	err := s.db.QueryRow("SELECT id, name, created_at FROM users WHERE name = ?", name).Scan(&u.ID, &u.Name, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting user: %w", err)
+	}
+	u.CreatedAt = u.CreatedAt.UTC()
+	return &u, nil
+}
+
+func (s *SQLiteStore) ListUsers() ([]models.User, error) {
+	rows, err := s.db.Query("SELECT id, name, created_at FROM users ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("listing users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Name, &u.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning user: %w", err)
+		}
+		u.CreatedAt = u.CreatedAt.UTC()
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteUser(name string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("deleting user: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow("SELECT id FROM users WHERE name = ?", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: user %s", services.ErrNotFound, name)
+	}
+	if err != nil {
+		return fmt.Errorf("deleting user: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM team_members WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("deleting user memberships: %w", err)
+	}
+	if _, err := tx.Exec(
+		"UPDATE api_tokens SET revoked_at = ? WHERE user_name = ? AND revoked_at IS NULL",
+		s.clock.Now().UTC(), name,
+	); err != nil {
+		return fmt.Errorf("revoking user tokens: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE id = ?", id); err != nil {
+		return fmt.Errorf("deleting user: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) SetTokenUser(tokenID int64, user string) error {
+	result, err := s.db.Exec(`
+		UPDATE api_tokens SET user_name = ?
+		WHERE id = ? AND EXISTS (SELECT 1 FROM users WHERE name = ?)
+	`, user, tokenID, user)
+	if err != nil {
+		return fmt.Errorf("setting token user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: token %d or user %s", services.ErrNotFound, tokenID, user)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) CreateTeam(name, ssoGroup string) (*models.Team, error) {
+	now := s.clock.Now().UTC()
+	result, err := s.db.Exec("INSERT INTO teams (name, sso_group, created_at) VALUES (?, ?, ?)", name, ssoGroup, now)
+	if err != nil {
+		if isUniqueConstraint(err) {
+			return nil, fmt.Errorf("%w: team %s already exists", services.ErrConflict, name)
+		}
+		return nil, fmt.Errorf("creating team: %w", err)
+	}
+	id, _ := result.LastInsertId()
+	return &models.Team{ID: id, Name: name, SSOGroup: ssoGroup, Members: []string{}, CreatedAt: now}, nil
+}
+
+func (s *SQLiteStore) GetTeam(name string) (*models.Team, error) {
+	teams, err := s.queryTeams("WHERE t.name = ?", name)
+	if err != nil || len(teams) == 0 {
+		return nil, err
+	}
+	return &teams[0], nil
+}
+
+func (s *SQLiteStore) ListTeams() ([]models.Team, error) {
+	return s.queryTeams("")
+}
+
+func (s *SQLiteStore) PackageOwners(packageName string) ([]models.Team, error) {
+	return s.queryTeams("WHERE t.id IN (SELECT team_id FROM package_owners WHERE package = ?)", packageName)
+}
+
+// queryTeams returns the teams matching where, with their members, by
+// name.
+func (s *SQLiteStore) queryTeams(where string, args ...any) ([]models.Team, error) {
+	rows, err := s.db.Query(`
+		SELECT t.id, t.name, t.sso_group, t.created_at, COALESCE(u.name, '')
+		FROM teams t
+		LEFT JOIN team_members m ON m.team_id = t.id
+		LEFT JOIN users u ON u.id = m.user_id
+		`+where+`
+		ORDER BY t.name, u.name
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing teams: %w", err)
+	}
+	defer rows.Close()
+
+	var teams []models.Team
+	for rows.Next() {
+		var t models.Team
+		var member string
+		if err := rows.Scan(&t.ID, &t.Name, &t.SSOGroup, &t.CreatedAt, &member); err != nil {
+			return nil, fmt.Errorf("scanning team: %w", err)
+		}
+		if n := len(teams); n == 0 || teams[n-1].ID != t.ID {
+			t.CreatedAt = t.CreatedAt.UTC()
+			t.Members = []string{}
+			teams = append(teams, t)
+		}
+		if member != "" {
+			last := &teams[len(teams)-1]
+			last.Members = append(last.Members, member)
+		}
+	}
+	return teams, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteTeam(name string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("deleting team: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow("SELECT id FROM teams WHERE name = ?", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: team %s", services.ErrNotFound, name)
+	}
+	if err != nil {
+		return fmt.Errorf("deleting team: %w", err)
+	}
+	for _, query := range []string{
+		"DELETE FROM team_members WHERE team_id = ?",
+		"DELETE FROM package_owners WHERE team_id = ?",
+		"DELETE FROM teams WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return fmt.Errorf("deleting team: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) AddTeamMember(team, user string) error {
+	result, err := s.db.Exec(`
+		INSERT OR IGNORE INTO team_members (team_id, user_id)
+		SELECT t.id, u.id FROM teams t, users u WHERE t.name = ? AND u.name = ?
+	`, team, user)
+	if err != nil {
+		return fmt.Errorf("adding team member: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return s.checkTeamAndUser(team, user)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) RemoveTeamMember(team, user string) error {
+	result, err := s.db.Exec(`
+		DELETE FROM team_members
+		WHERE team_id = (SELECT id FROM teams WHERE name = ?)
+		AND user_id = (SELECT id FROM users WHERE name = ?)
+	`, team, user)
+	if err != nil {
+		return fmt.Errorf("removing team member: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s is not a member of team %s", services.ErrNotFound, user, team)
+	}
+	return nil
+}
+
+// checkTeamAndUser explains why a team and user pair matched no rows:
+// ErrNotFound if either is missing, else nil, since the row exists.
+func (s *SQLiteStore) checkTeamAndUser(team, user string) error {
+	var teams, users int
+	err := s.db.QueryRow(
+		"SELECT (SELECT COUNT(*) FROM teams WHERE name = ?), (SELECT COUNT(*) FROM users WHERE name = ?)",
+		team, user,
+	).Scan(&teams, &users)
+	switch {
+	case err != nil:
+		return fmt.Errorf("adding team member: %w", err)
+	case teams == 0:
+		return fmt.Errorf("%w: team %s", services.ErrNotFound, team)
+	case users == 0:
+		return fmt.Errorf("%w: user %s", services.ErrNotFound, user)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) AddPackageOwner(packageName, team string) error {
+	result, err := s.db.Exec(`
+		INSERT OR IGNORE INTO package_owners (package, team_id)
+		SELECT ?, id FROM teams WHERE name = ?
+	`, packageName, team)
+	if err != nil {
+		return fmt.Errorf("adding package owner: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var exists bool
+		if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM teams WHERE name = ?)", team).Scan(&exists); err != nil {
+			return fmt.Errorf("adding package owner: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("%w: team %s", services.ErrNotFound, team)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) RemovePackageOwner(packageName, team string) error {
+	result, err := s.db.Exec(`
+		DELETE FROM package_owners
+		WHERE package = ? AND team_id = (SELECT id FROM teams WHERE name = ?)
+	`, packageName, team)
+	if err != nil {
+		return fmt.Errorf("removing package owner: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: team %s does not own %s", services.ErrNotFound, team, packageName)
+	}
+	return nil
+}
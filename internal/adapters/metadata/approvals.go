@@ -0,0 +1,97 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// SQLiteStore also implements services.ApprovalStore.
+
+const approvalColumns = `id, action, package, version, tombstone, reason, status,
+	requested_by, requested_at, decided_by, decided_at, result`
+
+func (s *SQLiteStore) CreateApproval(a models.Approval) (*models.Approval, error) {
+	a.Status = models.ApprovalPending
+	a.RequestedAt = s.clock.Now().UTC()
+	result, err := s.db.Exec(`
+		INSERT INTO approvals (action, package, version, tombstone, reason, status, requested_by, requested_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, a.Action, a.Package, a.Version, a.Tombstone, a.Reason, a.Status, a.RequestedBy, a.RequestedAt)
+	if err != nil {
+		return nil, fmt.Errorf("creating approval: %w", err)
+	}
+	a.ID, _ = result.LastInsertId()
+	return &a, nil
+}
+
+func (s *SQLiteStore) GetApproval(id int64) (*models.Approval, error) {
+	a, err := scanApproval(s.db.QueryRow("SELECT "+approvalColumns+" FROM approvals WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting approval: %w", err)
+	}
+	return a, nil
+}
+
+func (s *SQLiteStore) ListApprovals(status string) ([]models.Approval, error) {
+	rows, err := s.db.Query(
+		"SELECT "+approvalColumns+" FROM approvals WHERE ? = '' OR status = ? ORDER BY id DESC",
+		status, status,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing approvals: %w", err)
+	}
+	defer rows.Close()
+
+	var approvals []models.Approval
+	for rows.Next() {
+		a, err := scanApproval(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning approval: %w", err)
+		}
+		approvals = append(approvals, *a)
+	}
+	return approvals, rows.Err()
+}
+
+func (s *SQLiteStore) DecideApproval(id int64, status, by, result string) error {
+	res, err := s.db.Exec(`
+		UPDATE approvals SET status = ?, decided_by = ?, decided_at = ?, result = ?
+		WHERE id = ? AND status = ?
+	`, status, by, s.clock.Now().UTC(), result, id, models.ApprovalPending)
+	if err != nil {
+		return fmt.Errorf("deciding approval: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var current string
+		err := s.db.QueryRow("SELECT status FROM approvals WHERE id = ?", id).Scan(&current)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: approval %d", services.ErrNotFound, id)
+		}
+		if err != nil {
+			return fmt.Errorf("deciding approval: %w", err)
+		}
+		return fmt.Errorf("%w: approval %d is already %s", services.ErrConflict, id, current)
+	}
+	return nil
+}
+
+func scanApproval(row interface{ Scan(...any) error }) (*models.Approval, error) {
+	var a models.Approval
+	var decided sql.NullTime
+	if err := row.Scan(&a.ID, &a.Action, &a.Package, &a.Version, &a.Tombstone, &a.Reason, &a.Status,
+		&a.RequestedBy, &a.RequestedAt, &a.DecidedBy, &decided, &a.Result); err != nil {
+		return nil, err
+	}
+	a.RequestedAt = a.RequestedAt.UTC()
+	if decided.Valid {
+		at := decided.Time.UTC()
+		a.DecidedAt = &at
+	}
+	return &a, nil
+}
@@ -0,0 +1,100 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+func (s *SQLiteStore) CreateAsset(artifactID int64, in models.AssetInput) (*models.Asset, error) {
+	now := s.clock.Now().UTC()
+	result, err := s.db.Exec(
+		"INSERT INTO assets (artifact_id, name, hash, size, content_type, uploaded_at) VALUES (?, ?, ?, ?, ?, ?)",
+		artifactID, in.Name, in.Hash, in.Size, in.ContentType, now,
+	)
+	if err != nil {
+		if isUniqueConstraint(err) {
+			return nil, fmt.Errorf("%w: asset %s already exists", services.ErrConflict, in.Name)
+		}
+		return nil, fmt.Errorf("creating asset: %w", err)
+	}
+
+	id, _ := result.LastInsertId()
+	if _, err := s.db.Exec("UPDATE artifacts SET revision = revision + 1 WHERE id = ?", artifactID); err != nil {
+		return nil, fmt.Errorf("updating revision: %w", err)
+	}
+	return &models.Asset{
+		ID:          id,
+		ArtifactID:  artifactID,
+		Name:        in.Name,
+		Hash:        in.Hash,
+		Size:        in.Size,
+		ContentType: in.ContentType,
+		UploadedAt:  now,
+	}, nil
+}
+
+func (s *SQLiteStore) GetAsset(packageName, version, name string) (*models.Asset, error) {
+	var a models.Asset
+	err := s.db.QueryRow(`
+		SELECT s.id, s.artifact_id, s.name, s.hash, s.size, s.content_type, s.uploaded_at
+		FROM assets s
+		JOIN artifacts a ON s.artifact_id = a.id
+		JOIN packages p ON a.package_id = p.id
+		WHERE p.name = ? AND a.version = ? AND s.name = ?
+	`, packageName, version, name).Scan(&a.ID, &a.ArtifactID, &a.Name, &a.Hash, &a.Size, &a.ContentType, &a.UploadedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting asset: %w", err)
+	}
+	a.UploadedAt = a.UploadedAt.UTC()
+	return &a, nil
+}
+
+func (s *SQLiteStore) ListAssets(packageName, version string) ([]models.Asset, error) {
+	rows, err := s.db.Query(`
+		SELECT s.id, s.artifact_id, s.name, s.hash, s.size, s.content_type, s.uploaded_at
+		FROM assets s
+		JOIN artifacts a ON s.artifact_id = a.id
+		JOIN packages p ON a.package_id = p.id
+		WHERE p.name = ? AND a.version = ?
+		ORDER BY s.name
+	`, packageName, version)
+	if err != nil {
+		return nil, fmt.Errorf("listing assets: %w", err)
+	}
+	defer rows.Close()
+
+	var assets []models.Asset
+	for rows.Next() {
+		var a models.Asset
+		if err := rows.Scan(&a.ID, &a.ArtifactID, &a.Name, &a.Hash, &a.Size, &a.ContentType, &a.UploadedAt); err != nil {
+			return nil, fmt.Errorf("scanning asset: %w", err)
+		}
+		a.UploadedAt = a.UploadedAt.UTC()
+		assets = append(assets, a)
+	}
+	return assets, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteAsset(packageName, version, name string) error {
+	result, err := s.db.Exec(`
+		DELETE FROM assets WHERE name = ? AND artifact_id = (
+			SELECT a.id FROM artifacts a JOIN packages p ON a.package_id = p.id
+			WHERE p.name = ? AND a.version = ?
+		)
+	`, name, packageName, version)
+	if err != nil {
+		return fmt.Errorf("deleting asset: %w", err)
+	}
+
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("%w: asset %s of %s@%s", services.ErrNotFound, name, packageName, version)
+	}
+	return bumpRevision(s.db, packageName, version)
+}
@@ -0,0 +1,73 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+func (s *SQLiteStore) AddAttestation(packageName, version string, att models.Attestation) (*models.Attestation, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("adding attestation: %w", err)
+	}
+	defer tx.Rollback()
+
+	var artifactID int64
+	err = tx.QueryRow(`
+		SELECT a.id FROM artifacts a JOIN packages p ON a.package_id = p.id
+		WHERE p.name = ? AND a.version = ?
+	`, packageName, version).Scan(&artifactID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, packageName, version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("adding attestation: %w", err)
+	}
+
+	att.UploadedAt = s.clock.Now().UTC()
+	result, err := tx.Exec(`
+		INSERT INTO attestations (artifact_id, hash, size, subject, predicate_type, builder, verified, uploaded_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, artifactID, att.Hash, att.Size, att.Subject, att.PredicateType, att.Builder, att.Verified, att.UploadedAt)
+	if err != nil {
+		return nil, fmt.Errorf("recording attestation: %w", err)
+	}
+	att.ID, _ = result.LastInsertId()
+
+	if err := bumpRevision(tx, packageName, version); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("adding attestation: %w", err)
+	}
+	return &att, nil
+}
+
+func (s *SQLiteStore) ListAttestations(packageName, version string) ([]models.Attestation, error) {
+	rows, err := s.db.Query(`
+		SELECT t.id, t.hash, t.size, t.subject, t.predicate_type, t.builder, t.verified, t.uploaded_at
+		FROM attestations t
+		JOIN artifacts a ON t.artifact_id = a.id
+		JOIN packages p ON a.package_id = p.id
+		WHERE p.name = ? AND a.version = ?
+		ORDER BY t.id
+	`, packageName, version)
+	if err != nil {
+		return nil, fmt.Errorf("listing attestations: %w", err)
+	}
+	defer rows.Close()
+
+	var atts []models.Attestation
+	for rows.Next() {
+		var t models.Attestation
+		if err := rows.Scan(&t.ID, &t.Hash, &t.Size, &t.Subject, &t.PredicateType, &t.Builder, &t.Verified, &t.UploadedAt); err != nil {
+			return nil, fmt.Errorf("scanning attestation: %w", err)
+		}
+		t.UploadedAt = t.UploadedAt.UTC()
+		atts = append(atts, t)
+	}
+	return atts, rows.Err()
+}
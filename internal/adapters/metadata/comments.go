@@ -0,0 +1,101 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// SQLiteStore also implements services.CommentStore. Comments on the
+// package itself have no artifact_id.
+
+const commentQuery = `
+	SELECT c.id, p.name, COALESCE(a.version, ''), c.body, c.author, c.created_at
+	FROM comments c
+	JOIN packages p ON p.id = c.package_id
+	LEFT JOIN artifacts a ON a.id = c.artifact_id
+`
+
+func (s *SQLiteStore) AddComment(c models.Comment) (*models.Comment, error) {
+	var packageID int64
+	var artifactID sql.NullInt64
+	var err error
+	if c.Version == "" {
+		err = s.db.QueryRow("SELECT id FROM packages WHERE name = ?", c.Package).Scan(&packageID)
+	} else {
+		err = s.db.QueryRow(`
+			SELECT a.package_id, a.id FROM artifacts a JOIN packages p ON a.package_id = p.id
+			WHERE p.name = ? AND a.version = ?
+		`, c.Package, c.Version).Scan(&packageID, &artifactID)
+	}
+	if err == sql.ErrNoRows {
+		if c.Version == "" {
+			return nil, fmt.Errorf("%w: package %s", services.ErrNotFound, c.Package)
+		}
+		return nil, fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, c.Package, c.Version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("adding comment: %w", err)
+	}
+
+	c.CreatedAt = s.clock.Now().UTC()
+	result, err := s.db.Exec(`
+		INSERT INTO comments (package_id, artifact_id, body, author, created_at) VALUES (?, ?, ?, ?, ?)
+	`, packageID, artifactID, c.Body, c.Author, c.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("adding comment: %w", err)
+	}
+	c.ID, _ = result.LastInsertId()
+	return &c, nil
+}
+
+func (s *SQLiteStore) ListComments(packageName string) ([]models.Comment, error) {
+	rows, err := s.db.Query(commentQuery+"WHERE p.name = ? ORDER BY c.id", packageName)
+	if err != nil {
+		return nil, fmt.Errorf("listing comments: %w", err)
+	}
+	defer rows.Close()
+
+	var comments []models.Comment
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning comment: %w", err)
+		}
+		comments = append(comments, *c)
+	}
+	return comments, rows.Err()
+}
+
+func (s *SQLiteStore) GetComment(id int64) (*models.Comment, error) {
+	c, err := scanComment(s.db.QueryRow(commentQuery+"WHERE c.id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting comment: %w", err)
+	}
+	return c, nil
+}
+
+func (s *SQLiteStore) DeleteComment(id int64) error {
+	result, err := s.db.Exec("DELETE FROM comments WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("deleting comment: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: comment %d", services.ErrNotFound, id)
+	}
+	return nil
+}
+
+func scanComment(row interface{ Scan(...any) error }) (*models.Comment, error) {
+	var c models.Comment
+	if err := row.Scan(&c.ID, &c.Package, &c.Version, &c.Body, &c.Author, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	c.CreatedAt = c.CreatedAt.UTC()
+	return &c, nil
+}
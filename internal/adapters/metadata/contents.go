@@ -0,0 +1,138 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+func (s *SQLiteStore) SetContents(contents models.Contents) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("setting contents: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM content_entries WHERE hash = ?", contents.Hash); err != nil {
+		return fmt.Errorf("clearing content entries: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM content_licenses WHERE hash = ?", contents.Hash); err != nil {
+		return fmt.Errorf("clearing content licenses: %w", err)
+	}
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO contents (hash, format, truncated, indexed_at)
+		VALUES (?, ?, ?, ?)
+	`, contents.Hash, contents.Format, contents.Truncated, contents.IndexedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("recording contents: %w", err)
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO content_entries (hash, position, path, size, mode) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("recording content entries: %w", err)
+	}
+	defer stmt.Close()
+	for i, e := range contents.Entries {
+		if _, err := stmt.Exec(contents.Hash, i, e.Path, e.Size, e.Mode); err != nil {
+			return fmt.Errorf("recording content entry: %w", err)
+		}
+	}
+	for _, l := range contents.Licenses {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO content_licenses (hash, license, path) VALUES (?, ?, ?)",
+			contents.Hash, l.License, l.Path); err != nil {
+			return fmt.Errorf("recording content license: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("setting contents: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetContents(hash string) (*models.Contents, error) {
+	c := models.Contents{Hash: hash, Entries: []models.ContentEntry{}}
+	err := s.db.QueryRow(`
+		SELECT format, truncated, indexed_at FROM contents WHERE hash = ?
+	`, hash).Scan(&c.Format, &c.Truncated, &c.IndexedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting contents: %w", err)
+	}
+	c.IndexedAt = c.IndexedAt.UTC()
+
+	rows, err := s.db.Query(`
+		SELECT path, size, mode FROM content_entries WHERE hash = ? ORDER BY position
+	`, hash)
+	if err != nil {
+		return nil, fmt.Errorf("listing content entries: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.ContentEntry
+		if err := rows.Scan(&e.Path, &e.Size, &e.Mode); err != nil {
+			return nil, fmt.Errorf("scanning content entry: %w", err)
+		}
+		c.Entries = append(c.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing content entries: %w", err)
+	}
+
+	licenses, err := s.db.Query(`
+		SELECT license, path FROM content_licenses WHERE hash = ? ORDER BY path, license
+	`, hash)
+	if err != nil {
+		return nil, fmt.Errorf("listing content licenses: %w", err)
+	}
+	defer licenses.Close()
+	for licenses.Next() {
+		var l models.DetectedLicense
+		if err := licenses.Scan(&l.License, &l.Path); err != nil {
+			return nil, fmt.Errorf("scanning content license: %w", err)
+		}
+		c.Licenses = append(c.Licenses, l)
+	}
+	return &c, licenses.Err()
+}
+
+func (s *SQLiteStore) PruneContents() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("pruning contents: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		DELETE FROM content_entries WHERE hash NOT IN (SELECT hash FROM blob_refs)
+	`); err != nil {
+		return fmt.Errorf("pruning content entries: %w", err)
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM content_licenses WHERE hash NOT IN (SELECT hash FROM blob_refs)
+	`); err != nil {
+		return fmt.Errorf("pruning content licenses: %w", err)
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM contents WHERE hash NOT IN (SELECT hash FROM blob_refs)
+	`); err != nil {
+		return fmt.Errorf("pruning contents: %w", err)
+	}
+	return tx.Commit()
+}
+
+// splitLicenses turns the group_concat of a blob's licenses into a sorted
+// list, or nil when it has none.
+func splitLicenses(concat string) []string {
+	if concat == "" {
+		return nil
+	}
+	licenses := strings.Split(concat, ",")
+	sort.Strings(licenses)
+	return licenses
+}
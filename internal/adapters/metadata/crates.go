@@ -0,0 +1,64 @@
+package metadata
+
+import (
+	"fmt"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// SQLiteStore also implements services.CrateIndex.
+
+func (s *SQLiteStore) CreateCrateVersion(artifactID int64, entry string) error {
+	_, err := s.db.Exec("INSERT INTO crate_versions (artifact_id, entry) VALUES (?, ?)", artifactID, entry)
+	if err != nil {
+		if isUniqueConstraint(err) {
+			return fmt.Errorf("%w: crate version already exists", services.ErrConflict)
+		}
+		return fmt.Errorf("creating crate version: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListCrateVersions(packageName string) ([]models.CrateVersion, error) {
+	rows, err := s.db.Query(`
+		SELECT a.version, c.entry, c.yanked, a.quarantined, a.stage
+		FROM crate_versions c
+		JOIN artifacts a ON c.artifact_id = a.id
+		JOIN packages p ON a.package_id = p.id
+		WHERE p.name = ?
+		ORDER BY a.id
+	`, packageName)
+	if err != nil {
+		return nil, fmt.Errorf("listing crate versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []models.CrateVersion
+	for rows.Next() {
+		var v models.CrateVersion
+		if err := rows.Scan(&v.Version, &v.Entry, &v.Yanked, &v.Quarantined, &v.Stage); err != nil {
+			return nil, fmt.Errorf("scanning crate version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+func (s *SQLiteStore) SetCrateYanked(packageName, version string, yanked bool) error {
+	result, err := s.db.Exec(`
+		UPDATE crate_versions SET yanked = ?
+		WHERE artifact_id = (
+			SELECT a.id FROM artifacts a JOIN packages p ON a.package_id = p.id
+			WHERE p.name = ? AND a.version = ?
+		)
+	`, yanked, packageName, version)
+	if err != nil {
+		return fmt.Errorf("updating crate version: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("%w: crate %s@%s", services.ErrNotFound, packageName, version)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+func (s *SQLiteStore) SetDependencies(packageName, version string, deps []models.Dependency) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("setting dependencies: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`
+		SELECT a.id FROM artifacts a JOIN packages p ON a.package_id = p.id
+		WHERE p.name = ? AND a.version = ?
+	`, packageName, version).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, packageName, version)
+	}
+	if err != nil {
+		return fmt.Errorf("setting dependencies: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM dependencies WHERE artifact_id = ?", id); err != nil {
+		return fmt.Errorf("clearing dependencies: %w", err)
+	}
+	for i, d := range deps {
+		_, err := tx.Exec(
+			"INSERT INTO dependencies (artifact_id, position, package, version_constraint) VALUES (?, ?, ?, ?)",
+			id, i, d.Package, d.Constraint,
+		)
+		if err != nil {
+			if isUniqueConstraint(err) {
+				return fmt.Errorf("%w: duplicate dependency on %s", services.ErrConflict, d.Package)
+			}
+			return fmt.Errorf("inserting dependency: %w", err)
+		}
+	}
+	if err := bumpRevision(tx, packageName, version); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("setting dependencies: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListDependencies(packageName, version string) ([]models.Dependency, error) {
+	rows, err := s.db.Query(`
+		SELECT d.package, d.version_constraint
+		FROM dependencies d
+		JOIN artifacts a ON d.artifact_id = a.id
+		JOIN packages p ON a.package_id = p.id
+		WHERE p.name = ? AND a.version = ?
+		ORDER BY d.position
+	`, packageName, version)
+	if err != nil {
+		return nil, fmt.Errorf("listing dependencies: %w", err)
+	}
+	defer rows.Close()
+
+	var deps []models.Dependency
+	for rows.Next() {
+		var d models.Dependency
+		if err := rows.Scan(&d.Package, &d.Constraint); err != nil {
+			return nil, fmt.Errorf("scanning dependency: %w", err)
+		}
+		deps = append(deps, d)
+	}
+	return deps, rows.Err()
+}
+
+func (s *SQLiteStore) ListDependents(packageName string, after *models.Dependent, limit int) ([]models.Dependent, error) {
+	query := `
+		SELECT a.id, p.name, a.version, d.version_constraint
+		FROM dependencies d
+		JOIN artifacts a ON d.artifact_id = a.id
+		JOIN packages p ON a.package_id = p.id
+		WHERE d.package = ?`
+	args := []any{packageName}
+	if after != nil {
+		query += " AND (p.name > ? OR (p.name = ? AND a.id > ?))"
+		args = append(args, after.Package, after.Package, after.ArtifactID)
+	}
+	query += " ORDER BY p.name, a.id LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing dependents: %w", err)
+	}
+	defer rows.Close()
+
+	var dependents []models.Dependent
+	for rows.Next() {
+		var d models.Dependent
+		if err := rows.Scan(&d.ArtifactID, &d.Package, &d.Version, &d.Constraint); err != nil {
+			return nil, fmt.Errorf("scanning dependent: %w", err)
+		}
+		dependents = append(dependents, d)
+	}
+	return dependents, rows.Err()
+}
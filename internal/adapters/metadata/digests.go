@@ -0,0 +1,45 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+func (s *SQLiteStore) SetBlobDigests(d models.BlobDigests) error {
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO blob_digests (hash, sha512, blake3, computed_at)
+		VALUES (?, ?, ?, ?)
+	`, d.Hash, d.SHA512, d.BLAKE3, d.ComputedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("recording blob digests: %w", err)
+	}
+	return nil
+}
+
+const getBlobDigestsQuery = "SELECT sha512, blake3, computed_at FROM blob_digests WHERE hash = ?"
+
+func (s *SQLiteStore) GetBlobDigests(hash string) (*models.BlobDigests, error) {
+	d := models.BlobDigests{Hash: hash}
+	err := s.db.QueryRow(getBlobDigestsQuery, hash).Scan(&d.SHA512, &d.BLAKE3, &d.ComputedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting blob digests: %w", err)
+	}
+	d.ComputedAt = d.ComputedAt.UTC()
+	return &d, nil
+}
+
+func (s *SQLiteStore) PruneBlobDigests(before time.Time) error {
+	_, err := s.db.Exec(`
+		DELETE FROM blob_digests WHERE computed_at < ? AND hash NOT IN (SELECT hash FROM blob_refs)
+	`, before.UTC())
+	if err != nil {
+		return fmt.Errorf("pruning blob digests: %w", err)
+	}
+	return nil
+}
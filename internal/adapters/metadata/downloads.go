@@ -0,0 +1,79 @@
+package metadata
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// reportColumns lists the download report dimensions in the order rows are
+// grouped and sorted.
+var reportColumns = []string{models.ReportByPackage, models.ReportByVersion, models.ReportByPrincipal, models.ReportByDay}
+
+// reportDimensions returns the dimensions of groupBy in reportColumns
+// order. Grouping by version also groups by package.
+func reportDimensions(groupBy []string) []string {
+	var dims []string
+	for _, col := range reportColumns {
+		if slices.Contains(groupBy, col) || col == models.ReportByPackage && slices.Contains(groupBy, models.ReportByVersion) {
+			dims = append(dims, col)
+		}
+	}
+	return dims
+}
+
+const recordDownloadQuery = `
+	INSERT INTO download_stats (day, package, version, principal, downloads, bytes)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT (day, package, version, principal) DO UPDATE SET
+		downloads = downloads + excluded.downloads,
+		bytes = bytes + excluded.bytes`
+
+func (s *SQLiteStore) RecordDownload(packageName, version, principal string, downloads, bytes int64) error {
+	_, err := s.db.Exec(recordDownloadQuery, s.clock.Now().UTC().Format(time.DateOnly), packageName, version, principal, downloads, bytes)
+	if err != nil {
+		return fmt.Errorf("recording download: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DownloadStats(from, to string, groupBy []string) ([]models.DownloadStat, error) {
+	dims := reportDimensions(groupBy)
+	query := "SELECT COALESCE(SUM(downloads), 0), COALESCE(SUM(bytes), 0) FROM download_stats WHERE day >= ? AND day <= ?"
+	if len(dims) > 0 {
+		cols := strings.Join(dims, ", ")
+		query = "SELECT " + cols + ", SUM(downloads), SUM(bytes) FROM download_stats WHERE day >= ? AND day <= ?" +
+			" GROUP BY " + cols + " ORDER BY " + cols
+	}
+	rows, err := s.db.Query(query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("querying download stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []models.DownloadStat
+	for rows.Next() {
+		var st models.DownloadStat
+		dest := make([]any, 0, len(dims)+2)
+		for _, dim := range dims {
+			switch dim {
+			case models.ReportByPackage:
+				dest = append(dest, &st.Package)
+			case models.ReportByVersion:
+				dest = append(dest, &st.Version)
+			case models.ReportByPrincipal:
+				dest = append(dest, &st.Principal)
+			case models.ReportByDay:
+				dest = append(dest, &st.Day)
+			}
+		}
+		if err := rows.Scan(append(dest, &st.Downloads, &st.Bytes)...); err != nil {
+			return nil, fmt.Errorf("scanning download stats: %w", err)
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,41 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func (s *SQLiteStore) SetBlobFormat(hash, format string) error {
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO blob_formats (hash, format, detected_at) VALUES (?, ?, ?)
+	`, hash, format, s.clock.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("recording blob format: %w", err)
+	}
+	return nil
+}
+
+const getBlobFormatQuery = "SELECT format FROM blob_formats WHERE hash = ?"
+
+func (s *SQLiteStore) GetBlobFormat(hash string) (string, error) {
+	var format string
+	err := s.db.QueryRow(getBlobFormatQuery, hash).Scan(&format)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting blob format: %w", err)
+	}
+	return format, nil
+}
+
+func (s *SQLiteStore) PruneBlobFormats(before time.Time) error {
+	_, err := s.db.Exec(`
+		DELETE FROM blob_formats WHERE detected_at < ? AND hash NOT IN (SELECT hash FROM blob_refs)
+	`, before.UTC())
+	if err != nil {
+		return fmt.Errorf("pruning blob formats: %w", err)
+	}
+	return nil
+}
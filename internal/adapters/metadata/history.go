@@ -0,0 +1,82 @@
+package metadata
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// History is keyed by package name rather than ID, so it outlives the
+// versions and packages it describes.
+
+func (s *SQLiteStore) RecordHistory(e models.HistoryEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("recording history: %w", err)
+	}
+	defer tx.Rollback()
+
+	if e.Action == models.HistoryCreate {
+		var action, oldHash string
+		err := tx.QueryRow(`
+			SELECT action, old_hash FROM history
+			WHERE package = ? AND version = ? AND file = ?
+			ORDER BY id DESC LIMIT 1
+		`, e.Package, e.Version, e.File).Scan(&action, &oldHash)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return fmt.Errorf("reading history: %w", err)
+		case action == models.HistoryDelete && oldHash != e.NewHash:
+			e.Action = models.HistoryOverwrite
+			e.OldHash = oldHash
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO history (package, version, file, action, old_hash, new_hash, source, actor, at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.Package, e.Version, e.File, e.Action, e.OldHash, e.NewHash, e.Source, e.Actor, s.clock.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("recording history: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("recording history: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListHistory(packageName, version string, beforeID int64, limit int) ([]models.HistoryEvent, error) {
+	query := `
+		SELECT id, package, version, file, action, old_hash, new_hash, source, actor, at
+		FROM history WHERE package = ?`
+	args := []any{packageName}
+	if version != "" {
+		query += " AND version = ?"
+		args = append(args, version)
+	}
+	if beforeID > 0 {
+		query += " AND id < ?"
+		args = append(args, beforeID)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing history: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.HistoryEvent
+	for rows.Next() {
+		var e models.HistoryEvent
+		if err := rows.Scan(&e.ID, &e.Package, &e.Version, &e.File, &e.Action, &e.OldHash, &e.NewHash, &e.Source, &e.Actor, &e.At); err != nil {
+			return nil, fmt.Errorf("scanning history event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
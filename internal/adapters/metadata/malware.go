@@ -0,0 +1,45 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+func (s *SQLiteStore) SetMalwareScan(scan models.MalwareScan) error {
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO malware_scans (hash, result, signature, scanned_at)
+		VALUES (?, ?, ?, ?)
+	`, scan.Hash, scan.Result, scan.Signature, scan.ScannedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("recording malware scan: %w", err)
+	}
+	return nil
+}
+
+const getMalwareScanQuery = "SELECT result, signature, scanned_at FROM malware_scans WHERE hash = ?"
+
+func (s *SQLiteStore) GetMalwareScan(hash string) (*models.MalwareScan, error) {
+	scan := models.MalwareScan{Hash: hash}
+	err := s.db.QueryRow(getMalwareScanQuery, hash).Scan(&scan.Result, &scan.Signature, &scan.ScannedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting malware scan: %w", err)
+	}
+	scan.ScannedAt = scan.ScannedAt.UTC()
+	return &scan, nil
+}
+
+func (s *SQLiteStore) PruneMalwareScans(before time.Time) error {
+	_, err := s.db.Exec(`
+		DELETE FROM malware_scans WHERE scanned_at < ? AND hash NOT IN (SELECT hash FROM blob_refs)
+	`, before.UTC())
+	if err != nil {
+		return fmt.Errorf("pruning malware scans: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,1702 @@
+package metadata
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/clock"
+)
+
+// MemoryStore implements MetadataStore, TokenStore, TokenUsageStore,
+// AccountStore, ApprovalStore, CommentStore, SubscriptionStore and CrateIndex in memory,
+// with the same semantics as SQLiteStore. It is meant for tests and for embedding the
+// registry where nothing needs to survive a restart.
+type MemoryStore struct {
+	mu    sync.Mutex
+	clock clock.Clock
+
+	packages map[string]int64
+	// deprecations maps package names to their deprecation, if any.
+	deprecations map[string]models.Deprecation
+	artifacts    map[int64]*memArtifact
+	refcounts    map[string]*memBlob
+	contents     map[string]models.Contents
+	malware      map[string]models.MalwareScan
+	digests      map[string]models.BlobDigests
+	formats      map[string]memFormat
+	history      []models.HistoryEvent
+	tokens       []memToken
+	subs         []models.Subscription
+	approvals    []models.Approval
+	comments     []models.Comment
+	downloads    map[memDownloadKey]*models.DownloadStat
+	users        map[string]models.User
+	teams        map[string]*memTeam
+	// owners maps package names to the names of the teams owning them.
+	owners map[string]map[string]bool
+
+	lastUserID     int64
+	lastTeamID     int64
+	lastPackageID  int64
+	lastArtifactID int64
+	lastAssetID    int64
+	lastAttestID   int64
+	lastSubID      int64
+	lastCommentID  int64
+}
+
+// memFormat is the recorded format of a blob.
+type memFormat struct {
+	format     string
+	detectedAt time.Time
+}
+
+// memArtifact is a version with everything attached to it. Package holds
+// the package name; Vulnerabilities, Tier and Malware are filled in when
+// read.
+type memArtifact struct {
+	models.Artifact
+	assets     map[string]models.Asset
+	deps       []models.Dependency
+	sbom       *models.SBOM
+	components []models.SBOMComponent
+	scan       *models.ScanReport
+	attests    []models.Attestation
+	crate      *models.CrateVersion
+}
+
+// memBlob is the reference count of a blob, as in blob_refcounts.
+type memBlob struct {
+	size       int64
+	refs       int64
+	tier       string
+	accessedAt *time.Time
+}
+
+// memDownloadKey identifies a row of download_stats.
+type memDownloadKey struct {
+	day, pkg, version, principal string
+}
+
+type memToken struct {
+	models.APIToken
+	secretHash string
+	usage      models.TokenUsage
+}
+
+// memTeam is a team with its members by name; Team.Members is filled in
+// when read.
+type memTeam struct {
+	models.Team
+	members map[string]bool
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore(opts ...Option) *MemoryStore {
+	o := applyOptions(opts)
+	return &MemoryStore{
+		clock:        o.clock,
+		packages:     make(map[string]int64),
+		deprecations: make(map[string]models.Deprecation),
+		artifacts:    make(map[int64]*memArtifact),
+		refcounts:    make(map[string]*memBlob),
+		contents:     make(map[string]models.Contents),
+		malware:      make(map[string]models.MalwareScan),
+		digests:      make(map[string]models.BlobDigests),
+		formats:      make(map[string]memFormat),
+		downloads:    make(map[memDownloadKey]*models.DownloadStat),
+		users:        make(map[string]models.User),
+		teams:        make(map[string]*memTeam),
+		owners:       make(map[string]map[string]bool),
+	}
+}
+
+func (s *MemoryStore) CreatePackage(name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.packages[name]; ok {
+		return id, nil
+	}
+	s.lastPackageID++
+	s.packages[name] = s.lastPackageID
+	return s.lastPackageID, nil
+}
+
+func (s *MemoryStore) GetPackage(name string) (*models.Package, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.packages[name]
+	if !ok {
+		return nil, nil
+	}
+	pkg := s.pkg(id, name)
+	return &pkg, nil
+}
+
+func (s *MemoryStore) ListPackages() ([]models.Package, error) {
+	return s.findPackages(func(string) bool { return true }), nil
+}
+
+func (s *MemoryStore) SearchPackages(query string) ([]models.Package, error) {
+	// LIKE matches ASCII case-insensitively.
+	query = strings.ToLower(query)
+	return s.findPackages(func(name string) bool {
+		return strings.Contains(strings.ToLower(name), query)
+	}), nil
+}
+
+// pkg returns the package with its deprecation and the aggregates the
+// SQLite store's triggers keep.
+func (s *MemoryStore) pkg(id int64, name string) models.Package {
+	p := models.Package{ID: id, Name: name}
+	if d, ok := s.deprecations[name]; ok {
+		p.Deprecated = &d
+	}
+	for _, a := range s.artifacts {
+		if a.PackageID != id {
+			continue
+		}
+		p.TotalSize += a.Size
+		if p.UpdatedAt == nil || a.UploadedAt.After(*p.UpdatedAt) {
+			uploaded := a.UploadedAt
+			p.UpdatedAt = &uploaded
+		}
+	}
+	return p
+}
+
+// findPackages returns the packages whose names match, ordered by name.
+func (s *MemoryStore) findPackages(match func(name string) bool) []models.Package {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pkgs []models.Package
+	for name, id := range s.packages {
+		if match(name) {
+			pkgs = append(pkgs, s.pkg(id, name))
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs
+}
+
+func (s *MemoryStore) CreateArtifact(packageID int64, in models.ArtifactInput) (*models.Artifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pkgName, ok := s.packageName(packageID)
+	if !ok {
+		return nil, fmt.Errorf("creating artifact: %w: package %d", services.ErrNotFound, packageID)
+	}
+	a, err := s.createArtifact(packageID, pkgName, in)
+	if err != nil {
+		return nil, err
+	}
+	a.Package = ""
+	return a, nil
+}
+
+func (s *MemoryStore) CreateArtifactForPackage(packageName string, in models.ArtifactInput) (*models.Artifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check first, so a failed version leaves no package behind.
+	if s.lookup(packageName, in.Version) != nil {
+		return nil, fmt.Errorf("%w: artifact version already exists", services.ErrConflict)
+	}
+	if err := checkDependencies(in.Dependencies); err != nil {
+		return nil, err
+	}
+	packageID, ok := s.packages[packageName]
+	if !ok {
+		s.lastPackageID++
+		packageID = s.lastPackageID
+		s.packages[packageName] = packageID
+	}
+	return s.createArtifact(packageID, packageName, in)
+}
+
+// createArtifact records a version of a package. The caller holds mu.
+func (s *MemoryStore) createArtifact(packageID int64, pkgName string, in models.ArtifactInput) (*models.Artifact, error) {
+	if s.lookup(pkgName, in.Version) != nil {
+		return nil, fmt.Errorf("%w: artifact version already exists", services.ErrConflict)
+	}
+	if err := checkDependencies(in.Dependencies); err != nil {
+		return nil, err
+	}
+
+	stage := in.Stage
+	if stage == "" {
+		stage = models.StageRelease
+	}
+	var expiresAt *time.Time
+	if in.ExpiresAt != nil {
+		t := in.ExpiresAt.UTC()
+		expiresAt = &t
+	}
+	s.lastArtifactID++
+	a := &memArtifact{
+		Artifact: models.Artifact{
+			ID:          s.lastArtifactID,
+			PackageID:   packageID,
+			Package:     pkgName,
+			Version:     in.Version,
+			Hash:        in.Hash,
+			Size:        in.Size,
+			Filename:    in.Filename,
+			ContentType: in.ContentType,
+			UploadedAt:  s.clock.Now().UTC(),
+			Quarantined: in.Quarantined,
+			Stage:       stage,
+			Stability:   inputStability(in),
+			ExpiresAt:   expiresAt,
+			Description: in.Description,
+			Labels:      maps.Clone(in.Labels),
+			Revision:    1,
+		},
+		assets: make(map[string]models.Asset),
+		deps:   append([]models.Dependency(nil), in.Dependencies...),
+	}
+	s.artifacts[a.ID] = a
+	s.ref(in.Hash, in.Size)
+
+	out := s.view(a)
+	return &out, nil
+}
+
+func (s *MemoryStore) packageName(id int64) (string, bool) {
+	for name, pkgID := range s.packages {
+		if pkgID == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// lookup returns a version by package name, or nil.
+func (s *MemoryStore) lookup(packageName, version string) *memArtifact {
+	for _, a := range s.artifacts {
+		if a.Package == packageName && a.Version == version {
+			return a
+		}
+	}
+	return nil
+}
+
+// view returns a copy of a version as it is read back, with its scan
+// summary and blob tier.
+func (s *MemoryStore) view(a *memArtifact) models.Artifact {
+	out := a.Artifact
+	out.Labels = maps.Clone(a.Labels)
+	if a.scan != nil {
+		v := a.scan.Summary
+		out.Vulnerabilities = &v
+	}
+	out.Tier = models.TierHot
+	if b, ok := s.refcounts[a.Hash]; ok {
+		out.Tier = b.tier
+	}
+	out.Malware = s.malware[a.Hash].Result
+	out.SHA512, out.BLAKE3 = s.digests[a.Hash].SHA512, s.digests[a.Hash].BLAKE3
+	out.Format = s.formats[a.Hash].format
+	for _, l := range s.contents[a.Hash].Licenses {
+		if !slices.Contains(out.Licenses, l.License) {
+			out.Licenses = append(out.Licenses, l.License)
+		}
+	}
+	sort.Strings(out.Licenses)
+	return out
+}
+
+// sorted returns the versions that match, ordered by less.
+func (s *MemoryStore) sorted(match func(a *memArtifact) bool, less func(a, b *memArtifact) bool) []*memArtifact {
+	var list []*memArtifact
+	for _, a := range s.artifacts {
+		if match(a) {
+			list = append(list, a)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return less(list[i], list[j]) })
+	return list
+}
+
+func (s *MemoryStore) views(list []*memArtifact) []models.Artifact {
+	var out []models.Artifact
+	for _, a := range list {
+		out = append(out, s.view(a))
+	}
+	return out
+}
+
+func (s *MemoryStore) GetArtifact(packageName, version string) (*models.Artifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.lookup(packageName, version)
+	if a == nil {
+		return nil, nil
+	}
+	out := s.view(a)
+	return &out, nil
+}
+
+func (s *MemoryStore) ListArtifacts(packageName string) ([]models.Artifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.views(s.sorted(
+		func(a *memArtifact) bool { return a.Package == packageName },
+		func(a, b *memArtifact) bool {
+			if !a.UploadedAt.Equal(b.UploadedAt) {
+				return a.UploadedAt.After(b.UploadedAt)
+			}
+			return a.ID > b.ID
+		},
+	)), nil
+}
+
+func (s *MemoryStore) FindArtifacts(f models.ArtifactFilter) ([]models.Artifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.sorted(func(a *memArtifact) bool {
+		switch {
+		case f.Package != "" && a.Package != f.Package,
+			!f.UploadedAfter.IsZero() && a.UploadedAt.Before(f.UploadedAfter),
+			!f.UploadedBefore.IsZero() && !a.UploadedAt.Before(f.UploadedBefore),
+			f.MinSize != nil && a.Size < *f.MinSize,
+			f.MaxSize != nil && a.Size > *f.MaxSize,
+			f.BeforeID > 0 && a.ID >= f.BeforeID:
+			return false
+		}
+		if f.License != "" && !slices.ContainsFunc(s.contents[a.Hash].Licenses, func(l models.DetectedLicense) bool {
+			return strings.EqualFold(l.License, f.License)
+		}) {
+			return false
+		}
+		if f.Format != "" && s.formats[a.Hash].format != f.Format {
+			return false
+		}
+		if f.Channel != "" && !slices.Contains(models.ChannelStabilities(f.Channel), a.Stability) {
+			return false
+		}
+		if f.Hash != "" && a.Hash != f.Hash {
+			for _, asset := range a.assets {
+				if asset.Hash == f.Hash {
+					return true
+				}
+			}
+			return false
+		}
+		return true
+	}, func(a, b *memArtifact) bool { return a.ID > b.ID })
+	if f.Limit > 0 && len(list) > f.Limit {
+		list = list[:f.Limit]
+	}
+	return s.views(list), nil
+}
+
+// update applies fn to a version and advances its revision, or returns
+// ErrNotFound.
+func (s *MemoryStore) update(packageName, version string, fn func(a *memArtifact)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.lookup(packageName, version)
+	if a == nil {
+		return fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, packageName, version)
+	}
+	fn(a)
+	a.Revision++
+	return nil
+}
+
+func (s *MemoryStore) SetQuarantined(packageName, version string, quarantined bool) error {
+	return s.update(packageName, version, func(a *memArtifact) {
+		a.Quarantined = quarantined
+	})
+}
+
+func (s *MemoryStore) ListQuarantined() ([]models.Artifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.views(s.sorted(
+		func(a *memArtifact) bool { return a.Quarantined },
+		func(a, b *memArtifact) bool {
+			if !a.UploadedAt.Equal(b.UploadedAt) {
+				return a.UploadedAt.Before(b.UploadedAt)
+			}
+			return a.ID < b.ID
+		},
+	)), nil
+}
+
+func (s *MemoryStore) SetStage(packageName, version, stage, promotedBy string) error {
+	now := s.clock.Now().UTC()
+	return s.update(packageName, version, func(a *memArtifact) {
+		a.Stage = stage
+		a.PromotedBy = promotedBy
+		a.PromotedAt = &now
+	})
+}
+
+func (s *MemoryStore) ListExpired(now time.Time) ([]models.Artifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.views(s.sorted(
+		func(a *memArtifact) bool { return a.ExpiresAt != nil && !a.ExpiresAt.After(now) && !a.Pinned },
+		func(a, b *memArtifact) bool {
+			if !a.ExpiresAt.Equal(*b.ExpiresAt) {
+				return a.ExpiresAt.Before(*b.ExpiresAt)
+			}
+			return a.ID < b.ID
+		},
+	)), nil
+}
+
+func (s *MemoryStore) SetPinned(packageName, version string, pinned bool) error {
+	return s.update(packageName, version, func(a *memArtifact) {
+		a.Pinned = pinned
+	})
+}
+
+func (s *MemoryStore) SetDeprecation(packageName, version string, d *models.Deprecation) error {
+	return s.update(packageName, version, func(a *memArtifact) {
+		a.Deprecated = nil
+		if d != nil && d.Message != "" {
+			a.Deprecated = &models.Deprecation{Message: d.Message, InFavorOf: d.InFavorOf}
+		}
+	})
+}
+
+func (s *MemoryStore) SetPackageDeprecation(packageName string, d *models.Deprecation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.packages[packageName]; !ok {
+		return fmt.Errorf("%w: package %s", services.ErrNotFound, packageName)
+	}
+	if d == nil || d.Message == "" {
+		delete(s.deprecations, packageName)
+		return nil
+	}
+	s.deprecations[packageName] = models.Deprecation{Message: d.Message, InFavorOf: d.InFavorOf}
+	return nil
+}
+
+func (s *MemoryStore) SetReleaseNotes(packageName, version, notes string) error {
+	return s.update(packageName, version, func(a *memArtifact) {
+		a.ReleaseNotes = notes
+	})
+}
+
+func (s *MemoryStore) DeleteArtifact(packageName, version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.lookup(packageName, version)
+	if a == nil {
+		return fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, packageName, version)
+	}
+	if a.Pinned {
+		return fmt.Errorf("%w: artifact %s@%s is pinned", services.ErrConflict, packageName, version)
+	}
+
+	for _, asset := range a.assets {
+		s.unref(asset.Hash)
+	}
+	if a.sbom != nil {
+		s.unref(a.sbom.Hash)
+	}
+	if a.scan != nil {
+		s.unref(a.scan.Hash)
+	}
+	for _, att := range a.attests {
+		s.unref(att.Hash)
+	}
+	s.unref(a.Hash)
+	delete(s.artifacts, a.ID)
+	s.comments = slices.DeleteFunc(s.comments, func(c models.Comment) bool {
+		return c.Package == packageName && c.Version == version
+	})
+	return nil
+}
+
+func (s *MemoryStore) CreateAsset(artifactID int64, in models.AssetInput) (*models.Asset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.artifacts[artifactID]
+	if !ok {
+		return nil, fmt.Errorf("creating asset: %w: artifact %d", services.ErrNotFound, artifactID)
+	}
+	if _, ok := a.assets[in.Name]; ok {
+		return nil, fmt.Errorf("%w: asset %s already exists", services.ErrConflict, in.Name)
+	}
+
+	s.lastAssetID++
+	asset := models.Asset{
+		ID:          s.lastAssetID,
+		ArtifactID:  artifactID,
+		Name:        in.Name,
+		Hash:        in.Hash,
+		Size:        in.Size,
+		ContentType: in.ContentType,
+		UploadedAt:  s.clock.Now().UTC(),
+	}
+	a.assets[in.Name] = asset
+	a.Revision++
+	s.ref(in.Hash, in.Size)
+	return &asset, nil
+}
+
+func (s *MemoryStore) GetAsset(packageName, version, name string) (*models.Asset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.lookup(packageName, version)
+	if a == nil {
+		return nil, nil
+	}
+	asset, ok := a.assets[name]
+	if !ok {
+		return nil, nil
+	}
+	return &asset, nil
+}
+
+func (s *MemoryStore) ListAssets(packageName, version string) ([]models.Asset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.lookup(packageName, version)
+	if a == nil {
+		return nil, nil
+	}
+	var assets []models.Asset
+	for _, asset := range a.assets {
+		assets = append(assets, asset)
+	}
+	sort.Slice(assets, func(i, j int) bool { return assets[i].Name < assets[j].Name })
+	return assets, nil
+}
+
+func (s *MemoryStore) DeleteAsset(packageName, version, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.lookup(packageName, version)
+	var asset models.Asset
+	ok := false
+	if a != nil {
+		asset, ok = a.assets[name]
+	}
+	if !ok {
+		return fmt.Errorf("%w: asset %s of %s@%s", services.ErrNotFound, name, packageName, version)
+	}
+	delete(a.assets, name)
+	a.Revision++
+	s.unref(asset.Hash)
+	return nil
+}
+
+func (s *MemoryStore) SetDependencies(packageName, version string, deps []models.Dependency) error {
+	if err := checkDependencies(deps); err != nil {
+		return err
+	}
+	return s.update(packageName, version, func(a *memArtifact) {
+		a.deps = append([]models.Dependency(nil), deps...)
+	})
+}
+
+// checkDependencies refuses two dependencies on one package, as the
+// dependencies table's unique key does.
+func checkDependencies(deps []models.Dependency) error {
+	seen := make(map[string]bool, len(deps))
+	for _, d := range deps {
+		if seen[d.Package] {
+			return fmt.Errorf("%w: duplicate dependency on %s", services.ErrConflict, d.Package)
+		}
+		seen[d.Package] = true
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListDependencies(packageName, version string) ([]models.Dependency, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.lookup(packageName, version)
+	if a == nil || len(a.deps) == 0 {
+		return nil, nil
+	}
+	return append([]models.Dependency(nil), a.deps...), nil
+}
+
+func (s *MemoryStore) ListDependents(packageName string, after *models.Dependent, limit int) ([]models.Dependent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var dependents []models.Dependent
+	for _, a := range s.artifacts {
+		for _, d := range a.deps {
+			if d.Package != packageName {
+				continue
+			}
+			if after != nil && (a.Package < after.Package || (a.Package == after.Package && a.ID <= after.ArtifactID)) {
+				continue
+			}
+			dependents = append(dependents, models.Dependent{
+				ArtifactID: a.ID, Package: a.Package, Version: a.Version, Constraint: d.Constraint,
+			})
+		}
+	}
+	sort.Slice(dependents, func(i, j int) bool {
+		if dependents[i].Package != dependents[j].Package {
+			return dependents[i].Package < dependents[j].Package
+		}
+		return dependents[i].ArtifactID < dependents[j].ArtifactID
+	})
+	if limit >= 0 && len(dependents) > limit {
+		dependents = dependents[:limit]
+	}
+	return dependents, nil
+}
+
+func (s *MemoryStore) SetSBOM(packageName, version string, sbom models.SBOM, components []models.SBOMComponent) (*models.SBOM, error) {
+	sbom.Components = len(components)
+	sbom.UploadedAt = s.clock.Now().UTC()
+	err := s.update(packageName, version, func(a *memArtifact) {
+		s.ref(sbom.Hash, sbom.Size)
+		if a.sbom != nil {
+			s.unref(a.sbom.Hash)
+		}
+		stored := sbom
+		a.sbom = &stored
+		a.components = append([]models.SBOMComponent(nil), components...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sbom, nil
+}
+
+func (s *MemoryStore) GetSBOM(packageName, version string) (*models.SBOM, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.lookup(packageName, version)
+	if a == nil || a.sbom == nil {
+		return nil, nil
+	}
+	sbom := *a.sbom
+	return &sbom, nil
+}
+
+func (s *MemoryStore) PackagesWithComponent(component, version string) ([]models.Package, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := make(map[string]bool)
+	for _, a := range s.artifacts {
+		for _, c := range a.components {
+			// Names match case-insensitively, package URLs with or without
+			// their @version suffix.
+			matches := strings.EqualFold(c.Name, component) || c.PURL == component ||
+				strings.HasPrefix(c.PURL, component+"@")
+			if matches && (version == "" || c.Version == version) {
+				found[a.Package] = true
+			}
+		}
+	}
+	var pkgs []models.Package
+	for name := range found {
+		pkgs = append(pkgs, s.pkg(s.packages[name], name))
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs, nil
+}
+
+func (s *MemoryStore) SetScanReport(packageName, version string, report models.ScanReport) (*models.ScanReport, error) {
+	report.ScannedAt = s.clock.Now().UTC()
+	err := s.update(packageName, version, func(a *memArtifact) {
+		s.ref(report.Hash, report.Size)
+		if a.scan != nil {
+			s.unref(a.scan.Hash)
+		}
+		stored := report
+		a.scan = &stored
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (s *MemoryStore) GetScanReport(packageName, version string) (*models.ScanReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.lookup(packageName, version)
+	if a == nil || a.scan == nil {
+		return nil, nil
+	}
+	report := *a.scan
+	return &report, nil
+}
+
+func (s *MemoryStore) AddAttestation(packageName, version string, att models.Attestation) (*models.Attestation, error) {
+	att.UploadedAt = s.clock.Now().UTC()
+	err := s.update(packageName, version, func(a *memArtifact) {
+		s.lastAttestID++
+		att.ID = s.lastAttestID
+		s.ref(att.Hash, att.Size)
+		a.attests = append(a.attests, att)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &att, nil
+}
+
+func (s *MemoryStore) ListAttestations(packageName, version string) ([]models.Attestation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.lookup(packageName, version)
+	if a == nil {
+		return nil, nil
+	}
+	return slices.Clone(a.attests), nil
+}
+
+func (s *MemoryStore) SetContents(contents models.Contents) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contents.IndexedAt = contents.IndexedAt.UTC()
+	contents.Entries = append([]models.ContentEntry{}, contents.Entries...)
+	// Stored like SQLite's content_licenses rows: unique and ordered by
+	// path, then license.
+	var licenses []models.DetectedLicense
+	for _, l := range contents.Licenses {
+		if !slices.Contains(licenses, l) {
+			licenses = append(licenses, l)
+		}
+	}
+	sort.Slice(licenses, func(i, j int) bool {
+		if licenses[i].Path != licenses[j].Path {
+			return licenses[i].Path < licenses[j].Path
+		}
+		return licenses[i].License < licenses[j].License
+	})
+	contents.Licenses = licenses
+	s.contents[contents.Hash] = contents
+	return nil
+}
+
+func (s *MemoryStore) GetContents(hash string) (*models.Contents, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.contents[hash]
+	if !ok {
+		return nil, nil
+	}
+	c.Entries = append([]models.ContentEntry{}, c.Entries...)
+	c.Licenses = slices.Clone(c.Licenses)
+	return &c, nil
+}
+
+func (s *MemoryStore) PruneContents() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash := range s.contents {
+		if b, ok := s.refcounts[hash]; !ok || b.refs == 0 {
+			delete(s.contents, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetMalwareScan(scan models.MalwareScan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scan.ScannedAt = scan.ScannedAt.UTC()
+	s.malware[scan.Hash] = scan
+	return nil
+}
+
+func (s *MemoryStore) GetMalwareScan(hash string) (*models.MalwareScan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scan, ok := s.malware[hash]
+	if !ok {
+		return nil, nil
+	}
+	return &scan, nil
+}
+
+func (s *MemoryStore) PruneMalwareScans(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, scan := range s.malware {
+		if b, ok := s.refcounts[hash]; scan.ScannedAt.Before(before) && (!ok || b.refs == 0) {
+			delete(s.malware, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetBlobDigests(d models.BlobDigests) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.ComputedAt = d.ComputedAt.UTC()
+	s.digests[d.Hash] = d
+	return nil
+}
+
+func (s *MemoryStore) GetBlobDigests(hash string) (*models.BlobDigests, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.digests[hash]
+	if !ok {
+		return nil, nil
+	}
+	return &d, nil
+}
+
+func (s *MemoryStore) PruneBlobDigests(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, d := range s.digests {
+		if b, ok := s.refcounts[hash]; d.ComputedAt.Before(before) && (!ok || b.refs == 0) {
+			delete(s.digests, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetBlobFormat(hash, format string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.formats[hash] = memFormat{format: format, detectedAt: s.clock.Now()}
+	return nil
+}
+
+func (s *MemoryStore) GetBlobFormat(hash string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.formats[hash].format, nil
+}
+
+func (s *MemoryStore) PruneBlobFormats(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, f := range s.formats {
+		if b, ok := s.refcounts[hash]; f.detectedAt.Before(before) && (!ok || b.refs == 0) {
+			delete(s.formats, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RecordHistory(e models.HistoryEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.Action == models.HistoryCreate {
+		for i := len(s.history) - 1; i >= 0; i-- {
+			last := s.history[i]
+			if last.Package != e.Package || last.Version != e.Version || last.File != e.File {
+				continue
+			}
+			if last.Action == models.HistoryDelete && last.OldHash != e.NewHash {
+				e.Action = models.HistoryOverwrite
+				e.OldHash = last.OldHash
+			}
+			break
+		}
+	}
+	e.ID = int64(len(s.history)) + 1
+	e.At = s.clock.Now().UTC()
+	s.history = append(s.history, e)
+	return nil
+}
+
+func (s *MemoryStore) ListHistory(packageName, version string, beforeID int64, limit int) ([]models.HistoryEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []models.HistoryEvent
+	for i := len(s.history) - 1; i >= 0 && (limit < 0 || len(events) < limit); i-- {
+		e := s.history[i]
+		if e.Package != packageName || (version != "" && e.Version != version) || (beforeID > 0 && e.ID >= beforeID) {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// ref and unref keep refcounts in step with blob references, as the
+// blob_refcounts triggers do. A blob whose count reaches zero stays listed
+// until ForgetBlob.
+func (s *MemoryStore) ref(hash string, size int64) {
+	b, ok := s.refcounts[hash]
+	if !ok {
+		b = &memBlob{size: size, tier: models.TierHot}
+		s.refcounts[hash] = b
+	}
+	b.refs++
+}
+
+func (s *MemoryStore) unref(hash string) {
+	if b, ok := s.refcounts[hash]; ok {
+		b.refs--
+	}
+}
+
+func (s *MemoryStore) ReferencedHashes() (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	refs := make(map[string]bool)
+	for hash, b := range s.refcounts {
+		if b.refs > 0 {
+			refs[hash] = true
+		}
+	}
+	return refs, nil
+}
+
+func (s *MemoryStore) ReleasedBlobs(after string, limit int) ([]string, error) {
+	return s.blobsAfter(after, limit, func(b *memBlob) bool { return b.refs == 0 }), nil
+}
+
+// blobsAfter returns up to limit counted hashes after the given one, in
+// order, that match.
+func (s *MemoryStore) blobsAfter(after string, limit int, match func(b *memBlob) bool) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hashes []string
+	for hash, b := range s.refcounts {
+		if hash > after && match(b) {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+	if limit >= 0 && len(hashes) > limit {
+		hashes = hashes[:limit]
+	}
+	return hashes
+}
+
+func (s *MemoryStore) ForgetBlob(hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.refcounts[hash]
+	if !ok {
+		return true, nil
+	}
+	if b.refs > 0 {
+		return false, nil
+	}
+	delete(s.refcounts, hash)
+	return true, nil
+}
+
+func (s *MemoryStore) TouchBlob(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now().UTC()
+	if b, ok := s.refcounts[hash]; ok && (b.accessedAt == nil || b.accessedAt.Before(now.Add(-touchInterval))) {
+		b.accessedAt = &now
+	}
+	return nil
+}
+
+func (s *MemoryStore) RecordDownload(packageName, version, principal string, downloads, bytes int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := memDownloadKey{s.clock.Now().UTC().Format(time.DateOnly), packageName, version, principal}
+	st, ok := s.downloads[key]
+	if !ok {
+		st = &models.DownloadStat{Package: packageName, Version: version, Principal: principal, Day: key.day}
+		s.downloads[key] = st
+	}
+	st.Downloads += downloads
+	st.Bytes += bytes
+	return nil
+}
+
+func (s *MemoryStore) DownloadStats(from, to string, groupBy []string) ([]models.DownloadStat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dims := reportDimensions(groupBy)
+	groups := make(map[models.DownloadStat]*models.DownloadStat)
+	var stats []*models.DownloadStat
+	for key, st := range s.downloads {
+		if key.day < from || key.day > to {
+			continue
+		}
+		var group models.DownloadStat
+		for _, dim := range dims {
+			switch dim {
+			case models.ReportByPackage:
+				group.Package = st.Package
+			case models.ReportByVersion:
+				group.Version = st.Version
+			case models.ReportByPrincipal:
+				group.Principal = st.Principal
+			case models.ReportByDay:
+				group.Day = st.Day
+			}
+		}
+		total, ok := groups[group]
+		if !ok {
+			total = &group
+			groups[group] = total
+			stats = append(stats, total)
+		}
+		total.Downloads += st.Downloads
+		total.Bytes += st.Bytes
+	}
+	if len(dims) == 0 && len(stats) == 0 {
+		// SQLite sums no rows to a single zero row.
+		stats = append(stats, &models.DownloadStat{})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		if a.Principal != b.Principal {
+			return a.Principal < b.Principal
+		}
+		return a.Day < b.Day
+	})
+	out := make([]models.DownloadStat, len(stats))
+	for i, st := range stats {
+		out[i] = *st
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) ListIdleBlobs(idleSince time.Time, after string, limit int) ([]string, error) {
+	s.mu.Lock()
+	// Blobs never read count as idle from their latest upload.
+	for hash, b := range s.refcounts {
+		if b.accessedAt == nil && b.refs > 0 {
+			t := s.lastUpload(hash)
+			b.accessedAt = &t
+		}
+	}
+	s.mu.Unlock()
+
+	return s.blobsAfter(after, limit, func(b *memBlob) bool {
+		return b.tier == models.TierHot && b.refs > 0 && b.accessedAt.Before(idleSince)
+	}), nil
+}
+
+// lastUpload returns when hash was last uploaded as a version, then as a
+// file, SBOM or scan report, or now if nothing references it.
+func (s *MemoryStore) lastUpload(hash string) time.Time {
+	var versions, files, sboms, scans time.Time
+	for _, a := range s.artifacts {
+		if a.Hash == hash && a.UploadedAt.After(versions) {
+			versions = a.UploadedAt
+		}
+		for _, asset := range a.assets {
+			if asset.Hash == hash && asset.UploadedAt.After(files) {
+				files = asset.UploadedAt
+			}
+		}
+		if a.sbom != nil && a.sbom.Hash == hash && a.sbom.UploadedAt.After(sboms) {
+			sboms = a.sbom.UploadedAt
+		}
+		if a.scan != nil && a.scan.Hash == hash && a.scan.ScannedAt.After(scans) {
+			scans = a.scan.ScannedAt
+		}
+	}
+	for _, t := range []time.Time{versions, files, sboms, scans} {
+		if !t.IsZero() {
+			return t
+		}
+	}
+	return s.clock.Now().UTC()
+}
+
+func (s *MemoryStore) SetBlobTier(hash, tier string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.refcounts[hash]; ok {
+		b.tier = tier
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListFileRefs() ([]models.FileRef, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var refs []models.FileRef
+	for _, a := range s.artifacts {
+		refs = append(refs, models.FileRef{Package: a.Package, Version: a.Version, Hash: a.Hash})
+		for _, asset := range a.assets {
+			refs = append(refs, models.FileRef{Package: a.Package, Version: a.Version, File: asset.Name, Hash: asset.Hash})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Package != refs[j].Package {
+			return refs[i].Package < refs[j].Package
+		}
+		if refs[i].Version != refs[j].Version {
+			return refs[i].Version < refs[j].Version
+		}
+		return refs[i].File < refs[j].File
+	})
+	return refs, nil
+}
+
+func (s *MemoryStore) Stats() (*models.RegistryStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := models.RegistryStats{
+		Packages:  int64(len(s.packages)),
+		Artifacts: int64(len(s.artifacts)),
+	}
+	for _, a := range s.artifacts {
+		st.ArtifactBytes += a.Size
+		for _, asset := range a.assets {
+			st.ArtifactBytes += asset.Size
+		}
+	}
+	for _, b := range s.refcounts {
+		if b.refs > 0 {
+			st.UniqueBlobs++
+			st.UniqueBlobBytes += b.size
+		}
+	}
+	for _, t := range s.tokens {
+		if t.RevokedAt == nil {
+			st.ActiveTokens++
+		}
+	}
+	return &st, nil
+}
+
+func (s *MemoryStore) Usage() (*models.UsageReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// refs counts the files of each package and namespace pointing at
+	// each blob, as package_blobs does for packages.
+	type blobRef struct{ size, refs int64 }
+	packages := map[string]map[string]*blobRef{}
+	namespaces := map[string]map[string]*blobRef{}
+	refs := func(group map[string]map[string]*blobRef, name, hash string, size int64) {
+		if group[name] == nil {
+			group[name] = map[string]*blobRef{}
+		}
+		if group[name][hash] == nil {
+			group[name][hash] = &blobRef{size: size}
+		}
+		group[name][hash].refs++
+	}
+	for _, a := range s.artifacts {
+		add := func(hash string, size int64) {
+			refs(packages, a.Package, hash, size)
+			refs(namespaces, namespaceOf(a.Package), hash, size)
+		}
+		add(a.Hash, a.Size)
+		for _, asset := range a.assets {
+			add(asset.Hash, asset.Size)
+		}
+		if a.sbom != nil {
+			add(a.sbom.Hash, a.sbom.Size)
+		}
+		if a.scan != nil {
+			add(a.scan.Hash, a.scan.Size)
+		}
+		for _, att := range a.attests {
+			add(att.Hash, att.Size)
+		}
+	}
+	summarize := func(group map[string]map[string]*blobRef) []models.Usage {
+		usage := []models.Usage{}
+		for name, blobs := range group {
+			u := models.Usage{Name: name, Blobs: int64(len(blobs))}
+			for _, b := range blobs {
+				u.Files += b.refs
+				u.LogicalBytes += b.size * b.refs
+				u.PhysicalBytes += b.size
+			}
+			usage = append(usage, u)
+		}
+		slices.SortFunc(usage, func(a, b models.Usage) int { return strings.Compare(a.Name, b.Name) })
+		return usage
+	}
+
+	report := models.UsageReport{Packages: summarize(packages), Namespaces: summarize(namespaces)}
+	for _, u := range report.Packages {
+		report.Total.Files += u.Files
+		report.Total.LogicalBytes += u.LogicalBytes
+	}
+	for _, b := range s.refcounts {
+		if b.refs > 0 {
+			report.Total.Blobs++
+			report.Total.PhysicalBytes += b.size
+		}
+	}
+	return &report, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// MemoryStore also implements services.TokenStore.
+
+func (s *MemoryStore) CreateToken(name, secretHash string, admin bool) (*models.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.secretHash == secretHash {
+			return nil, fmt.Errorf("%w: token already exists", services.ErrConflict)
+		}
+	}
+	t := models.APIToken{ID: int64(len(s.tokens)) + 1, Name: name, Admin: admin, CreatedAt: s.clock.Now().UTC()}
+	s.tokens = append(s.tokens, memToken{APIToken: t, secretHash: secretHash})
+	return &t, nil
+}
+
+func (s *MemoryStore) LookupToken(secretHash string) (*models.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.secretHash == secretHash && t.RevokedAt == nil {
+			token := t.APIToken
+			return &token, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) ListTokens() ([]models.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tokens []models.APIToken
+	for _, t := range s.tokens {
+		tokens = append(tokens, t.APIToken)
+	}
+	return tokens, nil
+}
+
+func (s *MemoryStore) RevokeToken(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 1 || id > int64(len(s.tokens)) || s.tokens[id-1].RevokedAt != nil {
+		return fmt.Errorf("%w: active token %d", services.ErrNotFound, id)
+	}
+	now := s.clock.Now().UTC()
+	s.tokens[id-1].RevokedAt = &now
+	return nil
+}
+
+// MemoryStore also implements services.TokenUsageStore.
+
+func (s *MemoryStore) AddTokenUsage(usage []models.TokenUsage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range usage {
+		if u.TokenID < 1 || u.TokenID > int64(len(s.tokens)) {
+			continue
+		}
+		t := &s.tokens[u.TokenID-1].usage
+		t.Requests += u.Requests
+		t.BytesUploaded += u.BytesUploaded
+		t.BytesDownloaded += u.BytesDownloaded
+		if u.LastUsedAt != nil && (t.LastUsedAt == nil || u.LastUsedAt.After(*t.LastUsedAt)) {
+			at := u.LastUsedAt.UTC()
+			t.LastUsedAt = &at
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) TokenUsage(id int64) (*models.TokenUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 1 || id > int64(len(s.tokens)) {
+		return nil, fmt.Errorf("%w: token %d", services.ErrNotFound, id)
+	}
+	u := s.tokens[id-1].usage
+	u.TokenID = id
+	return &u, nil
+}
+
+// MemoryStore also implements services.AccountStore.
+
+func (s *MemoryStore) CreateUser(name string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[name]; ok {
+		return nil, fmt.Errorf("%w: user %s already exists", services.ErrConflict, name)
+	}
+	s.lastUserID++
+	u := models.User{ID: s.lastUserID, Name: name, CreatedAt: s.clock.Now().UTC()}
+	s.users[name] = u
+	return &u, nil
+}
+
+func (s *MemoryStore) GetUser(name string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[name]
+	if !ok {
+		return nil, nil
+	}
+	return &u, nil
+}
+
+func (s *MemoryStore) ListUsers() ([]models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []models.User
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users, nil
+}
+
+func (s *MemoryStore) DeleteUser(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[name]; !ok {
+		return fmt.Errorf("%w: user %s", services.ErrNotFound, name)
+	}
+	delete(s.users, name)
+	for _, t := range s.teams {
+		delete(t.members, name)
+	}
+	now := s.clock.Now().UTC()
+	for i := range s.tokens {
+		if t := &s.tokens[i]; t.User == name && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetTokenUser(tokenID int64, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user]; !ok || tokenID < 1 || tokenID > int64(len(s.tokens)) {
+		return fmt.Errorf("%w: token %d or user %s", services.ErrNotFound, tokenID, user)
+	}
+	s.tokens[tokenID-1].User = user
+	return nil
+}
+
+func (s *MemoryStore) CreateTeam(name, ssoGroup string) (*models.Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.teams[name]; ok {
+		return nil, fmt.Errorf("%w: team %s already exists", services.ErrConflict, name)
+	}
+	s.lastTeamID++
+	t := &memTeam{
+		Team:    models.Team{ID: s.lastTeamID, Name: name, SSOGroup: ssoGroup, CreatedAt: s.clock.Now().UTC()},
+		members: make(map[string]bool),
+	}
+	s.teams[name] = t
+	team := t.read()
+	return &team, nil
+}
+
+func (s *MemoryStore) GetTeam(name string) (*models.Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.teams[name]
+	if !ok {
+		return nil, nil
+	}
+	team := t.read()
+	return &team, nil
+}
+
+func (s *MemoryStore) ListTeams() ([]models.Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.teamsWhere(func(*memTeam) bool { return true }), nil
+}
+
+func (s *MemoryStore) PackageOwners(packageName string) ([]models.Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	owners := s.owners[packageName]
+	return s.teamsWhere(func(t *memTeam) bool { return owners[t.Name] }), nil
+}
+
+// teamsWhere returns the teams match accepts, by name. Callers hold s.mu.
+func (s *MemoryStore) teamsWhere(match func(*memTeam) bool) []models.Team {
+	var teams []models.Team
+	for _, t := range s.teams {
+		if match(t) {
+			teams = append(teams, t.read())
+		}
+	}
+	sort.Slice(teams, func(i, j int) bool { return teams[i].Name < teams[j].Name })
+	return teams
+}
+
+// read returns a copy of the team with its members by name.
+func (t *memTeam) read() models.Team {
+	team := t.Team
+	team.Members = make([]string, 0, len(t.members))
+	for name := range t.members {
+		team.Members = append(team.Members, name)
+	}
+	sort.Strings(team.Members)
+	return team
+}
+
+func (s *MemoryStore) DeleteTeam(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.teams[name]; !ok {
+		return fmt.Errorf("%w: team %s", services.ErrNotFound, name)
+	}
+	delete(s.teams, name)
+	for pkg, owners := range s.owners {
+		delete(owners, name)
+		if len(owners) == 0 {
+			delete(s.owners, pkg)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) AddTeamMember(team, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.teams[team]
+	if !ok {
+		return fmt.Errorf("%w: team %s", services.ErrNotFound, team)
+	}
+	if _, ok := s.users[user]; !ok {
+		return fmt.Errorf("%w: user %s", services.ErrNotFound, user)
+	}
+	t.members[user] = true
+	return nil
+}
+
+func (s *MemoryStore) RemoveTeamMember(team, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.teams[team]
+	if !ok || !t.members[user] {
+		return fmt.Errorf("%w: %s is not a member of team %s", services.ErrNotFound, user, team)
+	}
+	delete(t.members, user)
+	return nil
+}
+
+func (s *MemoryStore) AddPackageOwner(packageName, team string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.teams[team]; !ok {
+		return fmt.Errorf("%w: team %s", services.ErrNotFound, team)
+	}
+	if s.owners[packageName] == nil {
+		s.owners[packageName] = make(map[string]bool)
+	}
+	s.owners[packageName][team] = true
+	return nil
+}
+
+func (s *MemoryStore) RemovePackageOwner(packageName, team string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.owners[packageName][team] {
+		return fmt.Errorf("%w: team %s does not own %s", services.ErrNotFound, team, packageName)
+	}
+	delete(s.owners[packageName], team)
+	if len(s.owners[packageName]) == 0 {
+		delete(s.owners, packageName)
+	}
+	return nil
+}
+
+// MemoryStore also implements services.ApprovalStore. Approvals are kept
+// in ID order.
+
+func (s *MemoryStore) CreateApproval(a models.Approval) (*models.Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a.ID = int64(len(s.approvals)) + 1
+	a.Status = models.ApprovalPending
+	a.RequestedAt = s.clock.Now().UTC()
+	a.DecidedBy, a.DecidedAt, a.Result = "", nil, ""
+	s.approvals = append(s.approvals, a)
+	return &a, nil
+}
+
+func (s *MemoryStore) GetApproval(id int64) (*models.Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 1 || id > int64(len(s.approvals)) {
+		return nil, nil
+	}
+	a := s.approvals[id-1]
+	return &a, nil
+}
+
+func (s *MemoryStore) ListApprovals(status string) ([]models.Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var approvals []models.Approval
+	for i := len(s.approvals) - 1; i >= 0; i-- {
+		if status == "" || s.approvals[i].Status == status {
+			approvals = append(approvals, s.approvals[i])
+		}
+	}
+	return approvals, nil
+}
+
+func (s *MemoryStore) DecideApproval(id int64, status, by, result string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 1 || id > int64(len(s.approvals)) {
+		return fmt.Errorf("%w: approval %d", services.ErrNotFound, id)
+	}
+	a := &s.approvals[id-1]
+	if a.Status != models.ApprovalPending {
+		return fmt.Errorf("%w: approval %d is already %s", services.ErrConflict, id, a.Status)
+	}
+	now := s.clock.Now().UTC()
+	a.Status, a.DecidedBy, a.DecidedAt, a.Result = status, by, &now, result
+	return nil
+}
+
+// MemoryStore also implements services.CommentStore. Comments are kept in
+// ID order.
+
+func (s *MemoryStore) AddComment(c models.Comment) (*models.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.Version == "" {
+		if _, ok := s.packages[c.Package]; !ok {
+			return nil, fmt.Errorf("%w: package %s", services.ErrNotFound, c.Package)
+		}
+	} else if s.lookup(c.Package, c.Version) == nil {
+		return nil, fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, c.Package, c.Version)
+	}
+	s.lastCommentID++
+	c.ID = s.lastCommentID
+	c.CreatedAt = s.clock.Now().UTC()
+	s.comments = append(s.comments, c)
+	return &c, nil
+}
+
+func (s *MemoryStore) ListComments(packageName string) ([]models.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var comments []models.Comment
+	for _, c := range s.comments {
+		if c.Package == packageName {
+			comments = append(comments, c)
+		}
+	}
+	return comments, nil
+}
+
+func (s *MemoryStore) GetComment(id int64) (*models.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.comments {
+		if c.ID == id {
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) DeleteComment(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, c := range s.comments {
+		if c.ID == id {
+			s.comments = slices.Delete(s.comments, i, i+1)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: comment %d", services.ErrNotFound, id)
+}
+
+// MemoryStore also implements services.SubscriptionStore.
+
+func (s *MemoryStore) CreateSubscription(sub models.Subscription) (*models.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSubID++
+	sub.ID = s.lastSubID
+	sub.Events = slices.Clone(sub.Events)
+	sub.CreatedAt = s.clock.Now().UTC()
+	s.subs = append(s.subs, sub)
+	return &sub, nil
+}
+
+func (s *MemoryStore) ListSubscriptions() ([]models.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var subs []models.Subscription
+	for _, sub := range s.subs {
+		sub.Events = slices.Clone(sub.Events)
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+func (s *MemoryStore) DeleteSubscription(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sub := range s.subs {
+		if sub.ID == id {
+			s.subs = slices.Delete(s.subs, i, i+1)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: subscription %d", services.ErrNotFound, id)
+}
+
+// MemoryStore also implements services.CrateIndex.
+
+func (s *MemoryStore) CreateCrateVersion(artifactID int64, entry string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.artifacts[artifactID]
+	if !ok {
+		return fmt.Errorf("creating crate version: %w: artifact %d", services.ErrNotFound, artifactID)
+	}
+	if a.crate != nil {
+		return fmt.Errorf("%w: crate version already exists", services.ErrConflict)
+	}
+	a.crate = &models.CrateVersion{Entry: entry}
+	return nil
+}
+
+func (s *MemoryStore) ListCrateVersions(packageName string) ([]models.CrateVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var versions []models.CrateVersion
+	for _, a := range s.sorted(
+		func(a *memArtifact) bool { return a.Package == packageName && a.crate != nil },
+		func(a, b *memArtifact) bool { return a.ID < b.ID },
+	) {
+		v := *a.crate
+		v.Version, v.Quarantined, v.Stage = a.Version, a.Quarantined, a.Stage
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+func (s *MemoryStore) SetCrateYanked(packageName, version string, yanked bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.lookup(packageName, version)
+	if a == nil || a.crate == nil {
+		return fmt.Errorf("%w: crate %s@%s", services.ErrNotFound, packageName, version)
+	}
+	a.crate.Yanked = yanked
+	return nil
+}
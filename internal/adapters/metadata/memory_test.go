@@ -0,0 +1,291 @@
+package metadata
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/clock"
+)
+
+// memoryStoreScenario is the surface both stores are compared on.
+type memoryStoreScenario interface {
+	services.MetadataStore
+	services.TokenStore
+	services.TokenUsageStore
+	services.AccountStore
+	services.ApprovalStore
+	services.CommentStore
+	services.SubscriptionStore
+	services.CrateIndex
+}
+
+// TestMemoryStoreMatchesSQLite runs the same writes against both stores
+// and expects every read to answer the same.
+func TestMemoryStoreMatchesSQLite(t *testing.T) {
+	base := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	run := func(store memoryStoreScenario, fake *clock.Fake) string {
+		t.Helper()
+		app, _ := store.CreatePackage("app")
+		lib, _ := store.CreatePackage("lib")
+		expires := base.Add(time.Hour)
+		a1, _ := store.CreateArtifact(app, models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 10, Filename: "app.tgz"})
+		fake.Advance(time.Minute)
+		store.CreateArtifact(app, models.ArtifactInput{Version: "2.0.0", Hash: "h2", Size: 20, Quarantined: true, ExpiresAt: &expires})
+		fake.Advance(time.Minute)
+		l1, _ := store.CreateArtifact(lib, models.ArtifactInput{Version: "0.1.0", Hash: "h1", Size: 10, Stage: models.StageStaging})
+		store.CreateArtifactForPackage("lib-extra", models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 10})
+		created, _ := store.CreateArtifactForPackage("lib-extra", models.ArtifactInput{Version: "1.1.0", Hash: "x1", Size: 7})
+		store.CreateArtifactForPackage("lib-extra", models.ArtifactInput{Version: "1.2.0-rc.1", Hash: "x1", Size: 7})
+		store.CreateArtifactForPackage("lib-extra", models.ArtifactInput{Version: "1.2.0", Hash: "x1", Size: 7, Stability: models.StabilityBeta})
+		_, conflictErr := store.CreateArtifactForPackage("lib-extra", models.ArtifactInput{Version: "1.1.0", Hash: "x2", Size: 7})
+		store.CreateAsset(a1.ID, models.AssetInput{Name: "notes.txt", Hash: "n1", Size: 2})
+		store.CreateAsset(a1.ID, models.AssetInput{Name: "linux.bin", Hash: "h2", Size: 20})
+		store.SetDependencies("lib", "0.1.0", []models.Dependency{{Package: "app", Constraint: "^1.0.0"}})
+		store.SetSBOM("app", "1.0.0", models.SBOM{Hash: "s1", Size: 3, Format: "spdx"},
+			[]models.SBOMComponent{{Name: "OpenSSL", Version: "3.0.0", PURL: "pkg:generic/openssl@3.0.0"}})
+		store.SetScanReport("app", "1.0.0", models.ScanReport{Hash: "r1", Size: 4, Scanner: "trivy",
+			Summary: models.VulnerabilitySummary{High: 2}})
+		store.AddAttestation("app", "1.0.0", models.Attestation{Hash: "t1", Size: 5, Subject: "h1",
+			PredicateType: "https://slsa.dev/provenance/v1", Builder: "https://ci.example/builder", Verified: true})
+		store.AddAttestation("lib", "0.1.0", models.Attestation{Hash: "t2", Size: 6, Subject: "h1", PredicateType: "https://spdx.dev/Document"})
+		store.AddAttestation("app", "1.0.0", models.Attestation{Hash: "t2", Size: 6, Subject: "h1", PredicateType: "https://spdx.dev/Document"})
+		store.AddAttestation("app", "9.9.9", models.Attestation{Hash: "t3", Size: 1})
+		store.SetStage("lib", "0.1.0", models.StageRelease, "ops")
+		store.SetPinned("app", "1.0.0", true)
+		store.SetDeprecation("app", "1.0.0", &models.Deprecation{Message: "use 2.0.0", InFavorOf: "2.0.0"})
+		store.SetDeprecation("lib", "0.1.0", &models.Deprecation{Message: "old"})
+		store.SetDeprecation("lib", "0.1.0", nil)
+		store.SetPackageDeprecation("lib-extra", &models.Deprecation{Message: "merged into lib", InFavorOf: "lib"})
+		store.SetPackageDeprecation("lib", &models.Deprecation{Message: "old"})
+		store.SetPackageDeprecation("lib", &models.Deprecation{})
+		store.SetReleaseNotes("app", "1.0.0", "## Fixes\n\n- crash on start\n")
+		store.SetReleaseNotes("lib", "0.1.0", "draft")
+		store.SetReleaseNotes("lib", "0.1.0", "")
+		deprecationErrs := []string{store.SetDeprecation("app", "9.9.9", &models.Deprecation{Message: "x"}).Error(),
+			store.SetPackageDeprecation("nope", &models.Deprecation{Message: "x"}).Error()}
+		store.CreateCrateVersion(l1.ID, `{"name":"lib"}`)
+		store.SetCrateYanked("lib", "0.1.0", true)
+		store.RecordHistory(models.HistoryEvent{Package: "app", Version: "3.0.0", Action: models.HistoryDelete, OldHash: "old"})
+		store.RecordHistory(models.HistoryEvent{Package: "app", Version: "3.0.0", Action: models.HistoryCreate, NewHash: "new"})
+		store.RecordHistory(models.HistoryEvent{Package: "app", Version: "3.0.1", Action: models.HistoryCopy, NewHash: "new", Source: "app@3.0.0"})
+		store.SetContents(models.Contents{Hash: "h1", Format: "tar", IndexedAt: base,
+			Entries: []models.ContentEntry{{Path: "bin/app", Size: 8, Mode: "-rwxr-xr-x"}},
+			Licenses: []models.DetectedLicense{{License: "MIT", Path: "package.json"}, {License: "Apache-2.0", Path: "LICENSE"},
+				{License: "MIT", Path: "LICENSE"}, {License: "MIT", Path: "package.json"}}})
+		store.SetContents(models.Contents{Hash: "gone", Format: "zip", IndexedAt: base})
+		store.CreateToken("ci", "secret", false)
+		store.CreateToken("carol-ci", "carol-secret", false)
+		store.AddTokenUsage([]models.TokenUsage{{TokenID: 1, Requests: 2, BytesUploaded: 10, BytesDownloaded: 20, LastUsedAt: &expires},
+			{TokenID: 9, Requests: 1, LastUsedAt: &expires}})
+		store.AddTokenUsage([]models.TokenUsage{{TokenID: 1, Requests: 1, BytesDownloaded: 5, LastUsedAt: &base}})
+		store.CreateUser("alice")
+		store.CreateUser("bob")
+		store.CreateUser("carol")
+		_, dupUserErr := store.CreateUser("alice")
+		store.CreateTeam("platform", "sso-platform")
+		store.CreateTeam("web", "")
+		store.CreateTeam("gone", "")
+		store.AddTeamMember("platform", "alice")
+		store.AddTeamMember("platform", "bob")
+		store.AddTeamMember("platform", "bob")
+		store.AddTeamMember("web", "carol")
+		store.AddTeamMember("web", "bob")
+		addMemberErrs := []string{store.AddTeamMember("web", "nobody").Error(), store.AddTeamMember("nobody", "bob").Error()}
+		store.AddPackageOwner("app", "web")
+		store.AddPackageOwner("app", "platform")
+		store.AddPackageOwner("unpublished", "web")
+		store.AddPackageOwner("lib", "gone")
+		addOwnerErr := store.AddPackageOwner("app", "nobody")
+		store.SetTokenUser(1, "alice")
+		store.SetTokenUser(2, "carol")
+		setTokenUserErr := store.SetTokenUser(1, "nobody")
+		store.DeleteUser("carol")
+		store.RemoveTeamMember("platform", "bob")
+		store.DeleteTeam("gone")
+		accountErrs := []string{dupUserErr.Error(), setTokenUserErr.Error(), addOwnerErr.Error(),
+			store.RemoveTeamMember("platform", "bob").Error(), store.RemovePackageOwner("lib", "gone").Error(),
+			store.DeleteUser("carol").Error(), store.DeleteTeam("gone").Error()}
+		store.CreateApproval(models.Approval{Action: models.ApprovalActionDelete, Package: "app", Version: "1.0.0",
+			Reason: "app is protected", RequestedBy: "alice"})
+		store.CreateApproval(models.Approval{Action: models.ApprovalActionGC, Tombstone: true, Reason: "too much", RequestedBy: "bob"})
+		store.CreateApproval(models.Approval{Action: models.ApprovalActionDelete, Package: "lib", Version: "0.1.0",
+			Reason: "lib is protected", RequestedBy: "bob", Status: models.ApprovalApproved})
+		store.DecideApproval(1, models.ApprovalRejected, "bob", "")
+		fake.Advance(time.Minute)
+		store.DecideApproval(2, models.ApprovalApproved, "alice", "job 7")
+		approvalErrs := []string{store.DecideApproval(1, models.ApprovalApproved, "carol", "").Error(),
+			store.DecideApproval(9, models.ApprovalApproved, "carol", "").Error()}
+		store.AddComment(models.Comment{Package: "app", Body: "moved to app2", Author: "ci"})
+		store.AddComment(models.Comment{Package: "app", Version: "2.0.0", Body: "known bad, use 1.0.0", Author: "ops"})
+		store.AddComment(models.Comment{Package: "lib-extra", Version: "1.0.0", Body: "gone soon"})
+		store.AddComment(models.Comment{Package: "app", Body: "stray"})
+		store.DeleteComment(4)
+		store.DeleteArtifact("lib-extra", "1.0.0")
+		_, noPackageErr := store.AddComment(models.Comment{Package: "nope", Body: "x"})
+		_, noVersionErr := store.AddComment(models.Comment{Package: "app", Version: "9.9.9", Body: "x"})
+		commentErrs := []string{store.DeleteComment(4).Error(), noPackageErr.Error(), noVersionErr.Error()}
+		store.CreateSubscription(models.Subscription{Package: "app", Channel: models.ChannelWebhook, Target: "https://hooks.example/app",
+			Secret: "s3", CreatedBy: "ci"})
+		store.CreateSubscription(models.Subscription{Package: "li*", Events: []string{models.NotifyCreate, models.NotifyPromote},
+			Channel: models.ChannelEmail, Target: "team@example.com"})
+		store.CreateSubscription(models.Subscription{Package: "gone", Channel: models.ChannelSlack, Target: "https://slack.example/x"})
+		store.DeleteSubscription(3)
+		store.RecordDownload("app", "1.0.0", "ci", 1, 10)
+		store.RecordDownload("app", "1.0.0", "ci", 1, 10)
+		store.RecordDownload("lib", "0.1.0", "web", 0, 4)
+		store.RecordDownload("app", "2.0.0", "web", 1, 20)
+		store.SetMalwareScan(models.MalwareScan{Hash: "h2", Result: models.MalwareInfected, Signature: "Eicar-Test-Signature", ScannedAt: base})
+		store.SetMalwareScan(models.MalwareScan{Hash: "stray", Result: models.MalwareClean, ScannedAt: base})
+		store.SetMalwareScan(models.MalwareScan{Hash: "staged", Result: models.MalwareClean, ScannedAt: fake.Now()})
+		store.SetBlobDigests(models.BlobDigests{Hash: "h1", SHA512: "s512", BLAKE3: "b3", ComputedAt: base})
+		store.SetBlobDigests(models.BlobDigests{Hash: "stray", SHA512: "x512", ComputedAt: base})
+		store.SetBlobDigests(models.BlobDigests{Hash: "staged", SHA512: "y512", ComputedAt: fake.Now()})
+		store.SetBlobFormat("h1", "tar.gz")
+		store.SetBlobFormat("h2", "zip")
+		store.SetBlobFormat("h2", "wheel")
+		store.SetBlobFormat("stray", "binary")
+		fake.Advance(time.Hour)
+		store.TouchBlob("h2")
+		fake.Advance(24 * time.Hour)
+		store.RecordDownload("app", "1.0.0", "web", 1, 10)
+		store.SetBlobTier("n1", models.TierCold)
+		store.DeleteAsset("app", "1.0.0", "notes.txt")
+		store.PruneContents()
+		store.PruneMalwareScans(fake.Now())
+		store.PruneBlobDigests(fake.Now())
+		store.PruneBlobFormats(fake.Now())
+
+		var deleteErrs []string
+		for _, v := range []string{"1.0.0", "9.9.9"} {
+			if err := store.DeleteArtifact("app", v); err != nil {
+				deleteErrs = append(deleteErrs, err.Error())
+			}
+		}
+		pinned, _ := store.GetArtifact("app", "1.0.0")
+		listed, _ := store.ListArtifacts("app")
+		found, _ := store.FindArtifacts(models.ArtifactFilter{Hash: "h2"})
+		licensed, _ := store.FindArtifacts(models.ArtifactFilter{License: "mit"})
+		wheels, _ := store.FindArtifacts(models.ArtifactFilter{Format: "wheel"})
+		candidates, _ := store.FindArtifacts(models.ArtifactFilter{Channel: models.StabilityRC})
+		unknownChannel, _ := store.FindArtifacts(models.ArtifactFilter{Channel: "nightly"})
+		quarantined, _ := store.ListQuarantined()
+		expired, _ := store.ListExpired(fake.Now())
+		assets, _ := store.ListAssets("app", "1.0.0")
+		attestations, _ := store.ListAttestations("app", "1.0.0")
+		dependents, _ := store.ListDependents("app", nil, 10)
+		withOpenSSL, _ := store.PackagesWithComponent("openssl", "")
+		byPURL, _ := store.PackagesWithComponent("pkg:generic/openssl", "3.0.0")
+		crates, _ := store.ListCrateVersions("lib")
+		history, _ := store.ListHistory("app", "", 0, 10)
+		contents, _ := store.GetContents("h1")
+		pruned, _ := store.GetContents("gone")
+		released, _ := store.ReleasedBlobs("", 10)
+		idle, _ := store.ListIdleBlobs(fake.Now().Add(-time.Minute), "", 10)
+		fileRefs, _ := store.ListFileRefs()
+		stats, _ := store.Stats()
+		usage, _ := store.Usage()
+		token, _ := store.LookupToken("secret")
+		tokens, _ := store.ListTokens()
+		var tokenUsage []*models.TokenUsage
+		for _, id := range []int64{1, 2} {
+			u, _ := store.TokenUsage(id)
+			tokenUsage = append(tokenUsage, u)
+		}
+		_, tokenUsageErr := store.TokenUsage(9)
+		users, _ := store.ListUsers()
+		teams, _ := store.ListTeams()
+		web, _ := store.GetTeam("web")
+		missingTeam, _ := store.GetTeam("gone")
+		missingUser, _ := store.GetUser("carol")
+		var owners [][]models.Team
+		for _, pkg := range []string{"app", "unpublished", "lib"} {
+			o, _ := store.PackageOwners(pkg)
+			owners = append(owners, o)
+		}
+		approvals, _ := store.ListApprovals("")
+		pendingApprovals, _ := store.ListApprovals(models.ApprovalPending)
+		approval, _ := store.GetApproval(2)
+		missingApproval, _ := store.GetApproval(9)
+		appComments, _ := store.ListComments("app")
+		extraComments, _ := store.ListComments("lib-extra")
+		comment, _ := store.GetComment(2)
+		missingComment, _ := store.GetComment(3)
+		searched, _ := store.SearchPackages("AP")
+		packages, _ := store.ListPackages()
+		extraPackage, _ := store.GetPackage("lib-extra")
+		subs, _ := store.ListSubscriptions()
+		deleteSubErr := store.DeleteSubscription(3)
+		byPackage, _ := store.DownloadStats("2024-06-01", "2024-06-02", []string{models.ReportByPackage})
+		byVersionDay, _ := store.DownloadStats("2024-06-01", "2024-06-02", []string{models.ReportByDay, models.ReportByVersion})
+		byPrincipal, _ := store.DownloadStats("2024-06-02", "2024-06-30", []string{models.ReportByPrincipal})
+		totals, _ := store.DownloadStats("2024-07-01", "2024-07-31", nil)
+		var malware []*models.MalwareScan
+		for _, hash := range []string{"h2", "stray", "staged"} {
+			scan, _ := store.GetMalwareScan(hash)
+			malware = append(malware, scan)
+		}
+		var digests []*models.BlobDigests
+		for _, hash := range []string{"h1", "stray", "staged"} {
+			d, _ := store.GetBlobDigests(hash)
+			digests = append(digests, d)
+		}
+		var formats []string
+		for _, hash := range []string{"h1", "h2", "stray"} {
+			f, _ := store.GetBlobFormat(hash)
+			formats = append(formats, f)
+		}
+		out, err := json.MarshalIndent(map[string]any{
+			"deleteErrs": deleteErrs, "pinned": pinned, "listed": listed, "found": found,
+			"quarantined": quarantined, "expired": expired, "assets": assets, "dependents": dependents,
+			"withOpenSSL": withOpenSSL, "byPURL": byPURL, "crates": crates, "history": history,
+			"contents": contents, "pruned": pruned, "released": released, "idle": idle,
+			"fileRefs": fileRefs, "stats": stats, "token": token, "searched": searched,
+			"malware": malware, "licensed": licensed, "candidates": candidates, "unknownChannel": unknownChannel, "attestations": attestations,
+			"digests": digests, "wheels": wheels, "formats": formats,
+			"subscriptions": subs, "deleteSubErr": deleteSubErr.Error(),
+			"byPackage": byPackage, "byVersionDay": byVersionDay, "byPrincipal": byPrincipal, "totals": totals,
+			"usage": usage, "created": created, "conflictErr": conflictErr.Error(),
+			"tokens": tokens, "tokenUsage": tokenUsage, "tokenUsageErr": tokenUsageErr.Error(), "users": users, "teams": teams, "web": web, "missingTeam": missingTeam,
+			"missingUser": missingUser, "owners": owners, "addMemberErrs": addMemberErrs, "accountErrs": accountErrs,
+			"approvals": approvals, "pendingApprovals": pendingApprovals, "approval": approval,
+			"missingApproval": missingApproval, "approvalErrs": approvalErrs,
+			"appComments": appComments, "extraComments": extraComments, "comment": comment,
+			"missingComment": missingComment, "commentErrs": commentErrs,
+			"packages": packages, "extraPackage": extraPackage, "deprecationErrs": deprecationErrs,
+		}, "", "  ")
+		if err != nil {
+			t.Fatalf("encoding results: %v", err)
+		}
+		return string(out)
+	}
+
+	sqlClock := clock.NewFake(base)
+	sqlStore, err := NewSQLiteStore(t.TempDir(), WithClock(sqlClock))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer sqlStore.Close()
+	memClock := clock.NewFake(base)
+
+	want := run(sqlStore, sqlClock)
+	if got := run(NewMemoryStore(WithClock(memClock)), memClock); got != want {
+		t.Errorf("MemoryStore disagrees with SQLiteStore.\nmemory: %s\nsqlite: %s", got, want)
+	}
+}
+
+func TestMemoryStoreRejectsUnknownParents(t *testing.T) {
+	store := NewMemoryStore()
+
+	if _, err := store.CreateArtifact(42, models.ArtifactInput{Version: "1.0.0", Hash: "h"}); err == nil {
+		t.Error("expected an error creating a version of an unknown package")
+	}
+	if _, err := store.CreateAsset(42, models.AssetInput{Name: "a", Hash: "h"}); err == nil {
+		t.Error("expected an error attaching a file to an unknown version")
+	}
+	if err := store.SetDependencies("app", "1.0.0", []models.Dependency{{Package: "lib"}, {Package: "lib"}}); err == nil {
+		t.Error("expected an error for duplicate dependencies")
+	}
+}
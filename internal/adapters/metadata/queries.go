@@ -0,0 +1,302 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// hotQueries are run on every download or authenticated request, so they
+// are prepared once when the store opens instead of parsed and planned on
+// each call.
+var hotQueries = []string{
+	getArtifactQuery,
+	lookupTokenQuery,
+	getMalwareScanQuery,
+	getBlobDigestsQuery,
+	getBlobFormatQuery,
+	recordDownloadQuery,
+	touchBlobQuery,
+}
+
+// cacheSafeWrites change nothing GetArtifact reads, so they leave its
+// cache alone. Every other write empties it.
+var cacheSafeWrites = map[string]bool{
+	recordDownloadQuery: true,
+	touchBlobQuery:      true,
+}
+
+// queryDB is the store's *sql.DB, running hot queries through prepared
+// statements, timing every query under the name of the store method that
+// ran it and counting writes so cached reads can tell they are stale.
+type queryDB struct {
+	*sql.DB
+	stmts   map[string]*sql.Stmt
+	metrics queryMetrics
+	// writes advances after every write, once it is visible to readers.
+	writes atomic.Uint64
+}
+
+func newQueryDB(db *sql.DB) (*queryDB, error) {
+	q := &queryDB{DB: db, stmts: make(map[string]*sql.Stmt, len(hotQueries))}
+	for _, query := range hotQueries {
+		stmt, err := db.Prepare(query)
+		if err != nil {
+			q.Close()
+			return nil, fmt.Errorf("preparing %q: %w", strings.Join(strings.Fields(query), " "), err)
+		}
+		q.stmts[query] = stmt
+	}
+	return q, nil
+}
+
+func (q *queryDB) Exec(query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	var result sql.Result
+	var err error
+	if stmt, ok := q.stmts[query]; ok {
+		result, err = stmt.Exec(args...)
+	} else {
+		result, err = q.DB.Exec(query, args...)
+	}
+	q.wrote(query)
+	q.metrics.observe(callerLabel(), start, err)
+	return result, err
+}
+
+func (q *queryDB) Query(query string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	var rows *sql.Rows
+	var err error
+	if stmt, ok := q.stmts[query]; ok {
+		rows, err = stmt.Query(args...)
+	} else {
+		rows, err = q.DB.Query(query, args...)
+	}
+	q.wrote(query)
+	q.metrics.observe(callerLabel(), start, err)
+	return rows, err
+}
+
+func (q *queryDB) QueryRow(query string, args ...any) *sql.Row {
+	start := time.Now()
+	var row *sql.Row
+	if stmt, ok := q.stmts[query]; ok {
+		row = stmt.QueryRow(args...)
+	} else {
+		row = q.DB.QueryRow(query, args...)
+	}
+	q.wrote(query)
+	q.metrics.observe(callerLabel(), start, row.Err())
+	return row
+}
+
+// Begin starts a transaction timed from here until it commits or rolls
+// back.
+func (q *queryDB) Begin() (*queryTx, error) {
+	label := callerLabel() + " (tx)"
+	start := time.Now()
+	tx, err := q.DB.Begin()
+	if err != nil {
+		q.metrics.observe(label, start, err)
+		return nil, err
+	}
+	return &queryTx{Tx: tx, db: q, label: label, start: start}, nil
+}
+
+func (q *queryDB) Close() error {
+	for _, stmt := range q.stmts {
+		stmt.Close()
+	}
+	return q.DB.Close()
+}
+
+// wrote advances the write count if query is a write that may change
+// cached reads.
+func (q *queryDB) wrote(query string) {
+	if !cacheSafeWrites[query] && isWrite(query) {
+		q.writes.Add(1)
+	}
+}
+
+// isWrite reports whether query changes the database. Reads may be
+// reported as writes; writes are never reported as reads.
+func isWrite(query string) bool {
+	verb, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	switch strings.ToUpper(strings.TrimSpace(verb)) {
+	case "SELECT":
+		return false
+	}
+	return true
+}
+
+// queryTx is a transaction begun by queryDB.Begin.
+type queryTx struct {
+	*sql.Tx
+	db    *queryDB
+	label string
+	start time.Time
+	done  bool
+}
+
+func (tx *queryTx) Commit() error {
+	err := tx.Tx.Commit()
+	if !tx.done {
+		tx.done = true
+		tx.db.writes.Add(1)
+		tx.db.metrics.observe(tx.label, tx.start, err)
+	}
+	return err
+}
+
+func (tx *queryTx) Rollback() error {
+	err := tx.Tx.Rollback()
+	if !tx.done {
+		tx.done = true
+		tx.db.metrics.observe(tx.label, tx.start, err)
+	}
+	return err
+}
+
+// callerLabel names the store method two calls up, the one that called a
+// queryDB method.
+func callerLabel() string {
+	pc, _, _, ok := runtime.Caller(2)
+	if !ok {
+		return "unknown"
+	}
+	if label, ok := callerLabels.Load(pc); ok {
+		return label.(string)
+	}
+	label := "unknown"
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		label = fn.Name()
+		label = label[strings.LastIndex(label, "/")+1:]
+		label = strings.TrimPrefix(label, "metadata.")
+		label = strings.TrimPrefix(label, "(*SQLiteStore).")
+	}
+	callerLabels.Store(pc, label)
+	return label
+}
+
+var callerLabels sync.Map
+
+// queryMetrics accumulates query latency by label.
+type queryMetrics struct {
+	mu    sync.Mutex
+	stats map[string]*models.QueryStat
+}
+
+func (m *queryMetrics) observe(label string, start time.Time, err error) {
+	elapsed := time.Since(start).Microseconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stats == nil {
+		m.stats = make(map[string]*models.QueryStat)
+	}
+	st, ok := m.stats[label]
+	if !ok {
+		st = &models.QueryStat{Label: label}
+		m.stats[label] = st
+	}
+	st.Calls++
+	if err != nil && err != sql.ErrNoRows {
+		st.Errors++
+	}
+	st.TotalMicros += elapsed
+	st.MaxMicros = max(st.MaxMicros, elapsed)
+}
+
+func (m *queryMetrics) snapshot() []models.QueryStat {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make([]models.QueryStat, 0, len(m.stats))
+	for _, st := range m.stats {
+		s := *st
+		s.MeanMicros = s.TotalMicros / s.Calls
+		stats = append(stats, s)
+	}
+	slices.SortFunc(stats, func(a, b models.QueryStat) int {
+		return strings.Compare(a.Label, b.Label)
+	})
+	return stats
+}
+
+// QueryStats reports the calls and latency of the store's queries by the
+// method that ran them, sorted by method. Transactions are timed from
+// begin to commit under the method's name with " (tx)" appended.
+func (s *SQLiteStore) QueryStats() []models.QueryStat {
+	return s.db.metrics.snapshot()
+}
+
+// artifactCacheSize bounds the versions GetArtifact keeps cached.
+const artifactCacheSize = 1024
+
+// artifactCache holds recent GetArtifact results, each valid until the
+// next write to the database after it was read.
+type artifactCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedArtifact
+}
+
+type cachedArtifact struct {
+	artifact *models.Artifact
+	writes   uint64
+}
+
+func artifactKey(packageName, version string) string {
+	return packageName + "\x00" + version
+}
+
+// get returns a copy of the cached version, or false if it is not cached
+// or writes have happened since.
+func (c *artifactCache) get(key string, writes uint64) (*models.Artifact, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || e.writes != writes {
+		return nil, false
+	}
+	return cloneArtifact(e.artifact), true
+}
+
+// put caches a copy of a, read when the write count was writes. A nil a
+// caches that the version does not exist.
+func (c *artifactCache) put(key string, a *models.Artifact, writes uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= artifactCacheSize {
+		c.entries = make(map[string]cachedArtifact)
+	}
+	c.entries[key] = cachedArtifact{artifact: cloneArtifact(a), writes: writes}
+}
+
+// cloneArtifact copies a so callers may change it without changing the
+// cache.
+func cloneArtifact(a *models.Artifact) *models.Artifact {
+	if a == nil {
+		return nil
+	}
+	c := *a
+	if a.PromotedAt != nil {
+		t := *a.PromotedAt
+		c.PromotedAt = &t
+	}
+	if a.ExpiresAt != nil {
+		t := *a.ExpiresAt
+		c.ExpiresAt = &t
+	}
+	if a.Vulnerabilities != nil {
+		v := *a.Vulnerabilities
+		c.Vulnerabilities = &v
+	}
+	c.Licenses = slices.Clone(a.Licenses)
+	return &c
+}
@@ -0,0 +1,123 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+func (s *SQLiteStore) SetSBOM(packageName, version string, sbom models.SBOM, components []models.SBOMComponent) (*models.SBOM, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("setting SBOM: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`
+		SELECT a.id FROM artifacts a JOIN packages p ON a.package_id = p.id
+		WHERE p.name = ? AND a.version = ?
+	`, packageName, version).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, packageName, version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("setting SBOM: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM sbom_components WHERE artifact_id = ?", id); err != nil {
+		return nil, fmt.Errorf("clearing SBOM components: %w", err)
+	}
+	sbom.Components = len(components)
+	sbom.UploadedAt = s.clock.Now().UTC()
+	_, err = tx.Exec(`
+		INSERT INTO sboms (artifact_id, hash, size, format, spec_version, components, uploaded_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (artifact_id) DO UPDATE SET
+			hash = excluded.hash, size = excluded.size, format = excluded.format,
+			spec_version = excluded.spec_version, components = excluded.components,
+			uploaded_at = excluded.uploaded_at
+	`, id, sbom.Hash, sbom.Size, sbom.Format, sbom.SpecVersion, sbom.Components, sbom.UploadedAt)
+	if err != nil {
+		return nil, fmt.Errorf("recording SBOM: %w", err)
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO sbom_components (artifact_id, name, version, purl) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return nil, fmt.Errorf("recording SBOM components: %w", err)
+	}
+	defer stmt.Close()
+	for _, c := range components {
+		if _, err := stmt.Exec(id, c.Name, c.Version, c.PURL); err != nil {
+			return nil, fmt.Errorf("recording SBOM component: %w", err)
+		}
+	}
+
+	if err := bumpRevision(tx, packageName, version); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("setting SBOM: %w", err)
+	}
+	return &sbom, nil
+}
+
+func (s *SQLiteStore) GetSBOM(packageName, version string) (*models.SBOM, error) {
+	var sbom models.SBOM
+	err := s.db.QueryRow(`
+		SELECT b.hash, b.size, b.format, b.spec_version, b.components, b.uploaded_at
+		FROM sboms b
+		JOIN artifacts a ON b.artifact_id = a.id
+		JOIN packages p ON a.package_id = p.id
+		WHERE p.name = ? AND a.version = ?
+	`, packageName, version).Scan(&sbom.Hash, &sbom.Size, &sbom.Format, &sbom.SpecVersion, &sbom.Components, &sbom.UploadedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting SBOM: %w", err)
+	}
+	sbom.UploadedAt = sbom.UploadedAt.UTC()
+	return &sbom, nil
+}
+
+func (s *SQLiteStore) PackagesWithComponent(component, version string) ([]models.Package, error) {
+	// Package URLs match with or without their @version suffix.
+	query := `
+		SELECT DISTINCT p.id, p.name, p.deprecation, p.deprecated_in_favor_of, p.updated_at, p.total_size
+		FROM sbom_components c
+		JOIN artifacts a ON c.artifact_id = a.id
+		JOIN packages p ON a.package_id = p.id
+		WHERE (c.name = ? OR c.purl = ? OR c.purl LIKE ? ESCAPE '\')`
+	args := []any{component, component, escapeLike(component) + "@%"}
+	if version != "" {
+		query += " AND c.version = ?"
+		args = append(args, version)
+	}
+	query += " ORDER BY p.name"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("searching SBOM components: %w", err)
+	}
+	defer rows.Close()
+
+	var pkgs []models.Package
+	for rows.Next() {
+		p, err := scanPackage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning package: %w", err)
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, rows.Err()
+}
+
+// escapeLike escapes LIKE wildcards so s matches literally.
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
@@ -0,0 +1,55 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+func (s *SQLiteStore) SetScanReport(packageName, version string, report models.ScanReport) (*models.ScanReport, error) {
+	report.ScannedAt = s.clock.Now().UTC()
+	v := report.Summary
+	result, err := s.db.Exec(`
+		INSERT INTO scan_reports (artifact_id, hash, size, scanner, critical, high, medium, low, unknown, scanned_at)
+		SELECT a.id, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		FROM artifacts a JOIN packages p ON a.package_id = p.id
+		WHERE p.name = ? AND a.version = ?
+		ON CONFLICT (artifact_id) DO UPDATE SET
+			hash = excluded.hash, size = excluded.size, scanner = excluded.scanner,
+			critical = excluded.critical, high = excluded.high, medium = excluded.medium,
+			low = excluded.low, unknown = excluded.unknown, scanned_at = excluded.scanned_at
+	`, report.Hash, report.Size, report.Scanner, v.Critical, v.High, v.Medium, v.Low, v.Unknown, report.ScannedAt,
+		packageName, version)
+	if err != nil {
+		return nil, fmt.Errorf("recording scan report: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, packageName, version)
+	}
+	if err := bumpRevision(s.db, packageName, version); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (s *SQLiteStore) GetScanReport(packageName, version string) (*models.ScanReport, error) {
+	var r models.ScanReport
+	v := &r.Summary
+	err := s.db.QueryRow(`
+		SELECT s.hash, s.size, s.scanner, s.critical, s.high, s.medium, s.low, s.unknown, s.scanned_at
+		FROM scan_reports s
+		JOIN artifacts a ON s.artifact_id = a.id
+		JOIN packages p ON a.package_id = p.id
+		WHERE p.name = ? AND a.version = ?
+	`, packageName, version).Scan(&r.Hash, &r.Size, &r.Scanner, &v.Critical, &v.High, &v.Medium, &v.Low, &v.Unknown, &r.ScannedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting scan report: %w", err)
+	}
+	r.ScannedAt = r.ScannedAt.UTC()
+	return &r, nil
+}
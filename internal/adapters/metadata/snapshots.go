@@ -0,0 +1,458 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// OpenSnapshot opens the database of another registry's data directory
+// read-only, as an archive that nothing writes to while it is open. Its
+// schema must be current, since it cannot be migrated in place.
+func OpenSnapshot(dataDir string, opts ...Option) (*SQLiteStore, error) {
+	// immutable skips locking and the WAL, neither of which a read-only
+	// archive on a network share needs or may even allow.
+	dsn := "file:" + filepath.ToSlash(filepath.Join(dataDir, "registry.db")) + "?mode=ro&immutable=1"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("reading schema version: %w", err)
+	}
+	if version != len(migrations) {
+		db.Close()
+		return nil, fmt.Errorf("schema version %d, want %d: upgrade a copy by starting a server on it once", version, len(migrations))
+	}
+
+	qdb, err := newQueryDB(db)
+	if err != nil {
+		return nil, err
+	}
+	o := applyOptions(opts)
+	return &SQLiteStore{db: qdb, clock: o.clock}, nil
+}
+
+// Snapshot is a read-only store of another registry's metadata, served
+// under Name.
+type Snapshot struct {
+	Name  string
+	Store services.MetadataStore
+}
+
+// LayeredStore merges the packages of read-only snapshots into a live
+// store's namespace. A version is served from the live store if it has
+// it, and otherwise from the first snapshot that does, so the live store
+// and earlier snapshots shadow later ones version by version; a package
+// lists the versions of every layer. Versions served from a snapshot name
+// it in their Snapshot field, and changing them fails with ErrReadOnly.
+//
+// FindArtifacts covers every layer when it is not paging, since cursors
+// are IDs of the live store. Everything else not tied to a version, such
+// as package history, garbage collection and statistics, covers the live
+// store alone. Close closes only the live store.
+type LayeredStore struct {
+	services.MetadataStore
+	snapshots []Snapshot
+}
+
+// NewLayeredStore layers snapshots beneath live, earlier ones first.
+func NewLayeredStore(live services.MetadataStore, snapshots ...Snapshot) *LayeredStore {
+	return &LayeredStore{MetadataStore: live, snapshots: snapshots}
+}
+
+// layerFor finds pkg@version in the layer serving it, which it returns
+// too, and names the version's snapshot, if any, in its Snapshot field.
+// Both are nil if no layer has the version.
+func (l *LayeredStore) layerFor(pkg, version string) (*models.Artifact, services.MetadataStore, error) {
+	a, err := l.MetadataStore.GetArtifact(pkg, version)
+	if err != nil || a != nil {
+		return a, l.MetadataStore, err
+	}
+	for _, snap := range l.snapshots {
+		a, err := snap.Store.GetArtifact(pkg, version)
+		if err != nil {
+			return nil, nil, fmt.Errorf("snapshot %s: %w", snap.Name, err)
+		}
+		if a != nil {
+			copied := *a
+			copied.Snapshot = snap.Name
+			return &copied, snap.Store, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// storeFor returns the store serving pkg@version, the live store if none
+// has it.
+func (l *LayeredStore) storeFor(pkg, version string) (services.MetadataStore, error) {
+	_, store, err := l.layerFor(pkg, version)
+	if store == nil && err == nil {
+		store = l.MetadataStore
+	}
+	return store, err
+}
+
+// writable fails with ErrReadOnly if pkg@version is served from a
+// snapshot.
+func (l *LayeredStore) writable(pkg, version string) error {
+	a, _, err := l.layerFor(pkg, version)
+	if err != nil {
+		return err
+	}
+	if a != nil && a.Snapshot != "" {
+		return fmt.Errorf("%w: %s@%s is in snapshot %s", services.ErrReadOnly, pkg, version, a.Snapshot)
+	}
+	return nil
+}
+
+// GetPackage returns the package from the live store, or from the first
+// snapshot that has it.
+func (l *LayeredStore) GetPackage(name string) (*models.Package, error) {
+	pkg, err := l.MetadataStore.GetPackage(name)
+	if err != nil || pkg != nil {
+		return pkg, err
+	}
+	for _, snap := range l.snapshots {
+		pkg, err := snap.Store.GetPackage(name)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", snap.Name, err)
+		}
+		if pkg != nil {
+			return pkg, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListPackages lists the packages of every layer by name.
+func (l *LayeredStore) ListPackages() ([]models.Package, error) {
+	return l.mergePackages(services.MetadataStore.ListPackages)
+}
+
+// SearchPackages searches every layer.
+func (l *LayeredStore) SearchPackages(query string) ([]models.Package, error) {
+	return l.mergePackages(func(s services.MetadataStore) ([]models.Package, error) {
+		return s.SearchPackages(query)
+	})
+}
+
+// mergePackages merges the packages list returns from each layer. A
+// package in several layers keeps the first one's ID and deprecation; its
+// size adds up and it was updated when the latest layer was.
+func (l *LayeredStore) mergePackages(list func(services.MetadataStore) ([]models.Package, error)) ([]models.Package, error) {
+	pkgs, err := list(l.MetadataStore)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int, len(pkgs))
+	for i, p := range pkgs {
+		index[p.Name] = i
+	}
+	for _, snap := range l.snapshots {
+		more, err := list(snap.Store)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", snap.Name, err)
+		}
+		for _, p := range more {
+			i, ok := index[p.Name]
+			if !ok {
+				index[p.Name] = len(pkgs)
+				pkgs = append(pkgs, p)
+				continue
+			}
+			pkgs[i].TotalSize += p.TotalSize
+			if p.UpdatedAt != nil && (pkgs[i].UpdatedAt == nil || p.UpdatedAt.After(*pkgs[i].UpdatedAt)) {
+				pkgs[i].UpdatedAt = p.UpdatedAt
+			}
+		}
+	}
+	slices.SortFunc(pkgs, func(a, b models.Package) int { return strings.Compare(a.Name, b.Name) })
+	return pkgs, nil
+}
+
+// GetArtifact returns the version from the layer serving it.
+func (l *LayeredStore) GetArtifact(packageName, version string) (*models.Artifact, error) {
+	a, _, err := l.layerFor(packageName, version)
+	return a, err
+}
+
+// ListArtifacts lists the versions of a package across layers, newest
+// first, each from the layer serving it.
+func (l *LayeredStore) ListArtifacts(packageName string) ([]models.Artifact, error) {
+	artifacts, err := l.MetadataStore.ListArtifacts(packageName)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(artifacts))
+	for _, a := range artifacts {
+		seen[a.Version] = true
+	}
+	for _, snap := range l.snapshots {
+		more, err := snap.Store.ListArtifacts(packageName)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", snap.Name, err)
+		}
+		for _, a := range more {
+			if seen[a.Version] {
+				continue
+			}
+			seen[a.Version] = true
+			a.Snapshot = snap.Name
+			artifacts = append(artifacts, a)
+		}
+	}
+	slices.SortStableFunc(artifacts, func(a, b models.Artifact) int { return b.UploadedAt.Compare(a.UploadedAt) })
+	return artifacts, nil
+}
+
+// FindArtifacts finds the versions matching f in every layer, newest
+// first, each only from the layer serving it. Paged queries search the
+// live store only.
+func (l *LayeredStore) FindArtifacts(f models.ArtifactFilter) ([]models.Artifact, error) {
+	artifacts, err := l.MetadataStore.FindArtifacts(f)
+	if err != nil || f.Limit > 0 || f.BeforeID > 0 {
+		return artifacts, err
+	}
+	for _, snap := range l.snapshots {
+		more, err := snap.Store.FindArtifacts(f)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", snap.Name, err)
+		}
+		for _, a := range more {
+			served, _, err := l.layerFor(a.Package, a.Version)
+			if err != nil {
+				return nil, err
+			}
+			if served != nil && served.Snapshot == snap.Name {
+				artifacts = append(artifacts, *served)
+			}
+		}
+	}
+	slices.SortStableFunc(artifacts, func(a, b models.Artifact) int { return b.UploadedAt.Compare(a.UploadedAt) })
+	return artifacts, nil
+}
+
+// CreateArtifactForPackage creates a version in the live store unless a
+// snapshot has it.
+func (l *LayeredStore) CreateArtifactForPackage(packageName string, in models.ArtifactInput) (*models.Artifact, error) {
+	if err := l.writable(packageName, in.Version); err != nil {
+		return nil, err
+	}
+	return l.MetadataStore.CreateArtifactForPackage(packageName, in)
+}
+
+func (l *LayeredStore) SetQuarantined(packageName, version string, quarantined bool) error {
+	if err := l.writable(packageName, version); err != nil {
+		return err
+	}
+	return l.MetadataStore.SetQuarantined(packageName, version, quarantined)
+}
+
+func (l *LayeredStore) SetStage(packageName, version, stage, promotedBy string) error {
+	if err := l.writable(packageName, version); err != nil {
+		return err
+	}
+	return l.MetadataStore.SetStage(packageName, version, stage, promotedBy)
+}
+
+func (l *LayeredStore) SetPinned(packageName, version string, pinned bool) error {
+	if err := l.writable(packageName, version); err != nil {
+		return err
+	}
+	return l.MetadataStore.SetPinned(packageName, version, pinned)
+}
+
+func (l *LayeredStore) SetDeprecation(packageName, version string, d *models.Deprecation) error {
+	if err := l.writable(packageName, version); err != nil {
+		return err
+	}
+	return l.MetadataStore.SetDeprecation(packageName, version, d)
+}
+
+// SetPackageDeprecation deprecates a package of the live store; a package
+// only snapshots have is read-only.
+func (l *LayeredStore) SetPackageDeprecation(packageName string, d *models.Deprecation) error {
+	pkg, err := l.MetadataStore.GetPackage(packageName)
+	if err != nil {
+		return err
+	}
+	if pkg == nil {
+		if pkg, err = l.GetPackage(packageName); err != nil {
+			return err
+		}
+		if pkg != nil {
+			return fmt.Errorf("%w: package %s is only in snapshots", services.ErrReadOnly, packageName)
+		}
+	}
+	return l.MetadataStore.SetPackageDeprecation(packageName, d)
+}
+
+func (l *LayeredStore) SetReleaseNotes(packageName, version, notes string) error {
+	if err := l.writable(packageName, version); err != nil {
+		return err
+	}
+	return l.MetadataStore.SetReleaseNotes(packageName, version, notes)
+}
+
+func (l *LayeredStore) DeleteArtifact(packageName, version string) error {
+	if err := l.writable(packageName, version); err != nil {
+		return err
+	}
+	return l.MetadataStore.DeleteArtifact(packageName, version)
+}
+
+func (l *LayeredStore) GetAsset(packageName, version, name string) (*models.Asset, error) {
+	store, err := l.storeFor(packageName, version)
+	if err != nil {
+		return nil, err
+	}
+	return store.GetAsset(packageName, version, name)
+}
+
+func (l *LayeredStore) ListAssets(packageName, version string) ([]models.Asset, error) {
+	store, err := l.storeFor(packageName, version)
+	if err != nil {
+		return nil, err
+	}
+	return store.ListAssets(packageName, version)
+}
+
+func (l *LayeredStore) DeleteAsset(packageName, version, name string) error {
+	if err := l.writable(packageName, version); err != nil {
+		return err
+	}
+	return l.MetadataStore.DeleteAsset(packageName, version, name)
+}
+
+func (l *LayeredStore) SetDependencies(packageName, version string, deps []models.Dependency) error {
+	if err := l.writable(packageName, version); err != nil {
+		return err
+	}
+	return l.MetadataStore.SetDependencies(packageName, version, deps)
+}
+
+func (l *LayeredStore) ListDependencies(packageName, version string) ([]models.Dependency, error) {
+	store, err := l.storeFor(packageName, version)
+	if err != nil {
+		return nil, err
+	}
+	return store.ListDependencies(packageName, version)
+}
+
+func (l *LayeredStore) SetSBOM(packageName, version string, sbom models.SBOM, components []models.SBOMComponent) (*models.SBOM, error) {
+	if err := l.writable(packageName, version); err != nil {
+		return nil, err
+	}
+	return l.MetadataStore.SetSBOM(packageName, version, sbom, components)
+}
+
+func (l *LayeredStore) GetSBOM(packageName, version string) (*models.SBOM, error) {
+	store, err := l.storeFor(packageName, version)
+	if err != nil {
+		return nil, err
+	}
+	return store.GetSBOM(packageName, version)
+}
+
+func (l *LayeredStore) SetScanReport(packageName, version string, report models.ScanReport) (*models.ScanReport, error) {
+	if err := l.writable(packageName, version); err != nil {
+		return nil, err
+	}
+	return l.MetadataStore.SetScanReport(packageName, version, report)
+}
+
+func (l *LayeredStore) GetScanReport(packageName, version string) (*models.ScanReport, error) {
+	store, err := l.storeFor(packageName, version)
+	if err != nil {
+		return nil, err
+	}
+	return store.GetScanReport(packageName, version)
+}
+
+func (l *LayeredStore) AddAttestation(packageName, version string, att models.Attestation) (*models.Attestation, error) {
+	if err := l.writable(packageName, version); err != nil {
+		return nil, err
+	}
+	return l.MetadataStore.AddAttestation(packageName, version, att)
+}
+
+func (l *LayeredStore) ListAttestations(packageName, version string) ([]models.Attestation, error) {
+	store, err := l.storeFor(packageName, version)
+	if err != nil {
+		return nil, err
+	}
+	return store.ListAttestations(packageName, version)
+}
+
+// ListHistory lists the history of a version from the layer serving it. A
+// package's history is the live store's.
+func (l *LayeredStore) ListHistory(packageName, version string, beforeID int64, limit int) ([]models.HistoryEvent, error) {
+	if version == "" {
+		return l.MetadataStore.ListHistory(packageName, version, beforeID, limit)
+	}
+	store, err := l.storeFor(packageName, version)
+	if err != nil {
+		return nil, err
+	}
+	return store.ListHistory(packageName, version, beforeID, limit)
+}
+
+// GetContents returns a blob's contents from the first layer that indexed
+// them.
+func (l *LayeredStore) GetContents(hash string) (*models.Contents, error) {
+	return firstFound(l, func(s services.MetadataStore) (*models.Contents, error) { return s.GetContents(hash) })
+}
+
+// GetMalwareScan returns a blob's malware scan from the first layer that
+// scanned it.
+func (l *LayeredStore) GetMalwareScan(hash string) (*models.MalwareScan, error) {
+	return firstFound(l, func(s services.MetadataStore) (*models.MalwareScan, error) { return s.GetMalwareScan(hash) })
+}
+
+// GetBlobDigests returns a blob's digests from the first layer that
+// computed them.
+func (l *LayeredStore) GetBlobDigests(hash string) (*models.BlobDigests, error) {
+	return firstFound(l, func(s services.MetadataStore) (*models.BlobDigests, error) { return s.GetBlobDigests(hash) })
+}
+
+// GetBlobFormat returns a blob's format from the first layer that detected
+// it.
+func (l *LayeredStore) GetBlobFormat(hash string) (string, error) {
+	format, err := l.MetadataStore.GetBlobFormat(hash)
+	for _, snap := range l.snapshots {
+		if err != nil || format != "" {
+			break
+		}
+		format, err = snap.Store.GetBlobFormat(hash)
+	}
+	return format, err
+}
+
+// QueryStats reports the live store's query timings, if it keeps them.
+func (l *LayeredStore) QueryStats() []models.QueryStat {
+	if qm, ok := l.MetadataStore.(services.QueryMetrics); ok {
+		return qm.QueryStats()
+	}
+	return nil
+}
+
+// firstFound returns what get finds in the first layer where it finds
+// anything.
+func firstFound[T any](l *LayeredStore, get func(services.MetadataStore) (*T, error)) (*T, error) {
+	v, err := get(l.MetadataStore)
+	for _, snap := range l.snapshots {
+		if err != nil || v != nil {
+			break
+		}
+		v, err = get(snap.Store)
+	}
+	return v, err
+}
@@ -2,29 +2,60 @@ package metadata
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/foundry/registry/internal/core/models"
 	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/clock"
+	"github.com/foundry/registry/internal/util/semver"
 
 	_ "modernc.org/sqlite"
 )
 
 // SQLiteStore implements MetadataStore backed by SQLite.
 type SQLiteStore struct {
-	db *sql.DB
+	db        *queryDB
+	clock     clock.Clock
+	artifacts artifactCache
+}
+
+// Option configures a SQLiteStore or MemoryStore.
+type Option func(*options)
+
+type options struct {
+	clock clock.Clock
+}
+
+// WithClock sets the clock used for recorded timestamps.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+func applyOptions(opts []Option) options {
+	o := options{clock: clock.System}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
 }
 
 // NewSQLiteStore opens or creates the SQLite database and runs migrations.
-func NewSQLiteStore(dataDir string) (*SQLiteStore, error) {
+func NewSQLiteStore(dataDir string, opts ...Option) (*SQLiteStore, error) {
 	if err := os.MkdirAll(dataDir, 0o755); err != nil {
 		return nil, fmt.Errorf("creating data directory: %w", err)
 	}
 
-	dsn := dataDir + "/registry.db?_journal_mode=WAL&_busy_timeout=5000"
+	// The modernc driver applies pragmas per connection via _pragma, so every
+	// pooled connection gets WAL and waits on locks instead of failing.
+	dsn := dataDir + "/registry.db?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
 	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("opening database: %w", err)
@@ -35,28 +66,624 @@ func NewSQLiteStore(dataDir string) (*SQLiteStore, error) {
 		return nil, fmt.Errorf("running migrations: %w", err)
 	}
 
-	return &SQLiteStore{db: db}, nil
+	qdb, err := newQueryDB(db)
+	if err != nil {
+		return nil, err
+	}
+
+	o := applyOptions(opts)
+	return &SQLiteStore{db: qdb, clock: o.clock}, nil
+}
+
+// migrations are applied in order and tracked in PRAGMA user_version, so
+// each runs once per database. Append new steps; never edit applied ones.
+var migrations = []string{
+	`
+	CREATE TABLE IF NOT EXISTS packages (
+		id   INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS artifacts (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		package_id  INTEGER NOT NULL,
+		version     TEXT NOT NULL,
+		hash        TEXT NOT NULL,
+		size        INTEGER NOT NULL,
+		uploaded_at DATETIME NOT NULL,
+		UNIQUE(package_id, version),
+		FOREIGN KEY (package_id) REFERENCES packages(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_artifacts_hash ON artifacts(hash);
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		name        TEXT NOT NULL,
+		secret_hash TEXT UNIQUE NOT NULL,
+		admin       INTEGER NOT NULL DEFAULT 0,
+		created_at  DATETIME NOT NULL,
+		revoked_at  DATETIME
+	);
+	`,
+	`
+	ALTER TABLE artifacts ADD COLUMN filename TEXT NOT NULL DEFAULT '';
+	ALTER TABLE artifacts ADD COLUMN content_type TEXT NOT NULL DEFAULT '';
+	`,
+	`
+	CREATE TABLE assets (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		artifact_id  INTEGER NOT NULL,
+		name         TEXT NOT NULL,
+		hash         TEXT NOT NULL,
+		size         INTEGER NOT NULL,
+		content_type TEXT NOT NULL DEFAULT '',
+		uploaded_at  DATETIME NOT NULL,
+		UNIQUE(artifact_id, name),
+		FOREIGN KEY (artifact_id) REFERENCES artifacts(id)
+	);
+	CREATE INDEX idx_assets_hash ON assets(hash);
+	`,
+	`
+	CREATE TABLE crate_versions (
+		artifact_id INTEGER PRIMARY KEY,
+		entry       TEXT NOT NULL,
+		yanked      INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY (artifact_id) REFERENCES artifacts(id)
+	);
+	`,
+	`
+	CREATE TABLE dependencies (
+		artifact_id        INTEGER NOT NULL,
+		position           INTEGER NOT NULL,
+		package            TEXT NOT NULL,
+		version_constraint TEXT NOT NULL,
+		PRIMARY KEY (artifact_id, position),
+		UNIQUE(artifact_id, package),
+		FOREIGN KEY (artifact_id) REFERENCES artifacts(id)
+	);
+	CREATE INDEX idx_dependencies_package ON dependencies(package);
+	`,
+	`
+	CREATE TABLE sboms (
+		artifact_id  INTEGER PRIMARY KEY,
+		hash         TEXT NOT NULL,
+		size         INTEGER NOT NULL,
+		format       TEXT NOT NULL,
+		spec_version TEXT NOT NULL,
+		components   INTEGER NOT NULL,
+		uploaded_at  DATETIME NOT NULL,
+		FOREIGN KEY (artifact_id) REFERENCES artifacts(id)
+	);
+	CREATE INDEX idx_sboms_hash ON sboms(hash);
+	CREATE TABLE sbom_components (
+		artifact_id INTEGER NOT NULL,
+		name        TEXT NOT NULL COLLATE NOCASE,
+		version     TEXT NOT NULL,
+		purl        TEXT NOT NULL,
+		FOREIGN KEY (artifact_id) REFERENCES artifacts(id)
+	);
+	CREATE INDEX idx_sbom_components_artifact ON sbom_components(artifact_id);
+	CREATE INDEX idx_sbom_components_name ON sbom_components(name);
+	CREATE INDEX idx_sbom_components_purl ON sbom_components(purl);
+	-- Every blob reference, for GC and stats. Tables that reference blobs
+	-- are added here by recreating the view.
+	CREATE VIEW blob_refs AS
+		SELECT hash, size FROM artifacts
+		UNION ALL SELECT hash, size FROM assets
+		UNION ALL SELECT hash, size FROM sboms;
+	`,
+	`
+	CREATE TABLE scan_reports (
+		artifact_id INTEGER PRIMARY KEY,
+		hash        TEXT NOT NULL,
+		size        INTEGER NOT NULL,
+		scanner     TEXT NOT NULL,
+		critical    INTEGER NOT NULL,
+		high        INTEGER NOT NULL,
+		medium      INTEGER NOT NULL,
+		low         INTEGER NOT NULL,
+		unknown     INTEGER NOT NULL,
+		scanned_at  DATETIME NOT NULL,
+		FOREIGN KEY (artifact_id) REFERENCES artifacts(id)
+	);
+	CREATE INDEX idx_scan_reports_hash ON scan_reports(hash);
+	DROP VIEW blob_refs;
+	CREATE VIEW blob_refs AS
+		SELECT hash, size FROM artifacts
+		UNION ALL SELECT hash, size FROM assets
+		UNION ALL SELECT hash, size FROM sboms
+		UNION ALL SELECT hash, size FROM scan_reports;
+	`,
+	`
+	ALTER TABLE artifacts ADD COLUMN quarantined INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX idx_artifacts_quarantined ON artifacts(quarantined) WHERE quarantined = 1;
+	`,
+	`
+	ALTER TABLE artifacts ADD COLUMN stage TEXT NOT NULL DEFAULT 'release';
+	ALTER TABLE artifacts ADD COLUMN promoted_by TEXT NOT NULL DEFAULT '';
+	ALTER TABLE artifacts ADD COLUMN promoted_at DATETIME;
+	`,
+	`
+	ALTER TABLE artifacts ADD COLUMN expires_at DATETIME;
+	CREATE INDEX idx_artifacts_expires_at ON artifacts(expires_at) WHERE expires_at IS NOT NULL;
+	`,
+	`
+	ALTER TABLE artifacts ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0;
+	`,
+	`
+	CREATE TABLE contents (
+		hash       TEXT PRIMARY KEY,
+		format     TEXT NOT NULL,
+		truncated  INTEGER NOT NULL DEFAULT 0,
+		indexed_at DATETIME NOT NULL
+	);
+	CREATE TABLE content_entries (
+		hash     TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		path     TEXT NOT NULL,
+		size     INTEGER NOT NULL,
+		mode     TEXT NOT NULL,
+		PRIMARY KEY (hash, position),
+		FOREIGN KEY (hash) REFERENCES contents(hash)
+	);
+	`,
+	`
+	CREATE INDEX idx_artifacts_uploaded_at ON artifacts(uploaded_at);
+	CREATE INDEX idx_artifacts_size ON artifacts(size);
+	`,
+	`
+	CREATE TABLE history (
+		id       INTEGER PRIMARY KEY AUTOINCREMENT,
+		package  TEXT NOT NULL,
+		version  TEXT NOT NULL,
+		file     TEXT NOT NULL DEFAULT '',
+		action   TEXT NOT NULL,
+		old_hash TEXT NOT NULL DEFAULT '',
+		new_hash TEXT NOT NULL DEFAULT '',
+		actor    TEXT NOT NULL DEFAULT '',
+		at       DATETIME NOT NULL
+	);
+	CREATE INDEX idx_history_package ON history(package, version, file);
+	`,
+	`
+	ALTER TABLE artifacts ADD COLUMN revision INTEGER NOT NULL DEFAULT 1;
+	`,
+	`
+	-- How many rows of blob_refs point at each blob, kept up to date by
+	-- triggers in the same transaction as the write. Rows at zero are
+	-- blobs waiting to be reclaimed; the reclaimer removes them with the
+	-- blob. Tables that reference blobs need the same three triggers. The
+	-- triggers avoid conflict clauses, which the statement that fires them
+	-- would override.
+	CREATE TABLE blob_refcounts (
+		hash TEXT PRIMARY KEY,
+		size INTEGER NOT NULL,
+		refs INTEGER NOT NULL
+	);
+	CREATE INDEX idx_blob_refcounts_released ON blob_refcounts(hash) WHERE refs = 0;
+	INSERT INTO blob_refcounts (hash, size, refs)
+		SELECT hash, MAX(size), COUNT(*) FROM blob_refs GROUP BY hash;
+	CREATE TRIGGER artifacts_ref_insert AFTER INSERT ON artifacts BEGIN
+		INSERT INTO blob_refcounts (hash, size, refs)
+			SELECT NEW.hash, NEW.size, 0 WHERE NOT EXISTS (SELECT 1 FROM blob_refcounts WHERE hash = NEW.hash);
+		UPDATE blob_refcounts SET refs = refs + 1 WHERE hash = NEW.hash;
+	END;
+	CREATE TRIGGER artifacts_ref_update AFTER UPDATE OF hash ON artifacts WHEN NEW.hash != OLD.hash BEGIN
+		UPDATE blob_refcounts SET refs = refs - 1 WHERE hash = OLD.hash;
+		INSERT INTO blob_refcounts (hash, size, refs)
+			SELECT NEW.hash, NEW.size, 0 WHERE NOT EXISTS (SELECT 1 FROM blob_refcounts WHERE hash = NEW.hash);
+		UPDATE blob_refcounts SET refs = refs + 1 WHERE hash = NEW.hash;
+	END;
+	CREATE TRIGGER artifacts_ref_delete AFTER DELETE ON artifacts BEGIN
+		UPDATE blob_refcounts SET refs = refs - 1 WHERE hash = OLD.hash;
+	END;
+	CREATE TRIGGER assets_ref_insert AFTER INSERT ON assets BEGIN
+		INSERT INTO blob_refcounts (hash, size, refs)
+			SELECT NEW.hash, NEW.size, 0 WHERE NOT EXISTS (SELECT 1 FROM blob_refcounts WHERE hash = NEW.hash);
+		UPDATE blob_refcounts SET refs = refs + 1 WHERE hash = NEW.hash;
+	END;
+	CREATE TRIGGER assets_ref_update AFTER UPDATE OF hash ON assets WHEN NEW.hash != OLD.hash BEGIN
+		UPDATE blob_refcounts SET refs = refs - 1 WHERE hash = OLD.hash;
+		INSERT INTO blob_refcounts (hash, size, refs)
+			SELECT NEW.hash, NEW.size, 0 WHERE NOT EXISTS (SELECT 1 FROM blob_refcounts WHERE hash = NEW.hash);
+		UPDATE blob_refcounts SET refs = refs + 1 WHERE hash = NEW.hash;
+	END;
+	CREATE TRIGGER assets_ref_delete AFTER DELETE ON assets BEGIN
+		UPDATE blob_refcounts SET refs = refs - 1 WHERE hash = OLD.hash;
+	END;
+	CREATE TRIGGER sboms_ref_insert AFTER INSERT ON sboms BEGIN
+		INSERT INTO blob_refcounts (hash, size, refs)
+			SELECT NEW.hash, NEW.size, 0 WHERE NOT EXISTS (SELECT 1 FROM blob_refcounts WHERE hash = NEW.hash);
+		UPDATE blob_refcounts SET refs = refs + 1 WHERE hash = NEW.hash;
+	END;
+	CREATE TRIGGER sboms_ref_update AFTER UPDATE OF hash ON sboms WHEN NEW.hash != OLD.hash BEGIN
+		UPDATE blob_refcounts SET refs = refs - 1 WHERE hash = OLD.hash;
+		INSERT INTO blob_refcounts (hash, size, refs)
+			SELECT NEW.hash, NEW.size, 0 WHERE NOT EXISTS (SELECT 1 FROM blob_refcounts WHERE hash = NEW.hash);
+		UPDATE blob_refcounts SET refs = refs + 1 WHERE hash = NEW.hash;
+	END;
+	CREATE TRIGGER sboms_ref_delete AFTER DELETE ON sboms BEGIN
+		UPDATE blob_refcounts SET refs = refs - 1 WHERE hash = OLD.hash;
+	END;
+	CREATE TRIGGER scan_reports_ref_insert AFTER INSERT ON scan_reports BEGIN
+		INSERT INTO blob_refcounts (hash, size, refs)
+			SELECT NEW.hash, NEW.size, 0 WHERE NOT EXISTS (SELECT 1 FROM blob_refcounts WHERE hash = NEW.hash);
+		UPDATE blob_refcounts SET refs = refs + 1 WHERE hash = NEW.hash;
+	END;
+	CREATE TRIGGER scan_reports_ref_update AFTER UPDATE OF hash ON scan_reports WHEN NEW.hash != OLD.hash BEGIN
+		UPDATE blob_refcounts SET refs = refs - 1 WHERE hash = OLD.hash;
+		INSERT INTO blob_refcounts (hash, size, refs)
+			SELECT NEW.hash, NEW.size, 0 WHERE NOT EXISTS (SELECT 1 FROM blob_refcounts WHERE hash = NEW.hash);
+		UPDATE blob_refcounts SET refs = refs + 1 WHERE hash = NEW.hash;
+	END;
+	CREATE TRIGGER scan_reports_ref_delete AFTER DELETE ON scan_reports BEGIN
+		UPDATE blob_refcounts SET refs = refs - 1 WHERE hash = OLD.hash;
+	END;
+	`,
+	`
+	ALTER TABLE blob_refcounts ADD COLUMN tier TEXT NOT NULL DEFAULT 'hot';
+	ALTER TABLE blob_refcounts ADD COLUMN accessed_at DATETIME;
+	CREATE INDEX idx_blob_refcounts_tier ON blob_refcounts(tier, accessed_at);
+	`,
+	`
+	-- Malware scans are keyed by blob and recorded before the upload's
+	-- metadata, so they do not count as references.
+	CREATE TABLE malware_scans (
+		hash TEXT PRIMARY KEY,
+		result TEXT NOT NULL,
+		signature TEXT NOT NULL DEFAULT '',
+		scanned_at DATETIME NOT NULL
+	);
+	`,
+	`
+	CREATE TABLE content_licenses (
+		hash    TEXT NOT NULL,
+		license TEXT NOT NULL,
+		path    TEXT NOT NULL,
+		PRIMARY KEY (hash, license, path),
+		FOREIGN KEY (hash) REFERENCES contents(hash)
+	);
+	CREATE INDEX idx_content_licenses_license ON content_licenses(license COLLATE NOCASE);
+	`,
+	`
+	CREATE TABLE attestations (
+		id             INTEGER PRIMARY KEY AUTOINCREMENT,
+		artifact_id    INTEGER NOT NULL,
+		hash           TEXT NOT NULL,
+		size           INTEGER NOT NULL,
+		subject        TEXT NOT NULL,
+		predicate_type TEXT NOT NULL,
+		builder        TEXT NOT NULL DEFAULT '',
+		verified       INTEGER NOT NULL DEFAULT 0,
+		uploaded_at    DATETIME NOT NULL,
+		FOREIGN KEY (artifact_id) REFERENCES artifacts(id)
+	);
+	CREATE INDEX idx_attestations_artifact ON attestations(artifact_id);
+	CREATE INDEX idx_attestations_hash ON attestations(hash);
+	DROP VIEW blob_refs;
+	CREATE VIEW blob_refs AS
+		SELECT hash, size FROM artifacts
+		UNION ALL SELECT hash, size FROM assets
+		UNION ALL SELECT hash, size FROM sboms
+		UNION ALL SELECT hash, size FROM scan_reports
+		UNION ALL SELECT hash, size FROM attestations;
+	CREATE TRIGGER attestations_ref_insert AFTER INSERT ON attestations BEGIN
+		INSERT INTO blob_refcounts (hash, size, refs)
+			SELECT NEW.hash, NEW.size, 0 WHERE NOT EXISTS (SELECT 1 FROM blob_refcounts WHERE hash = NEW.hash);
+		UPDATE blob_refcounts SET refs = refs + 1 WHERE hash = NEW.hash;
+	END;
+	CREATE TRIGGER attestations_ref_update AFTER UPDATE OF hash ON attestations WHEN NEW.hash != OLD.hash BEGIN
+		UPDATE blob_refcounts SET refs = refs - 1 WHERE hash = OLD.hash;
+		INSERT INTO blob_refcounts (hash, size, refs)
+			SELECT NEW.hash, NEW.size, 0 WHERE NOT EXISTS (SELECT 1 FROM blob_refcounts WHERE hash = NEW.hash);
+		UPDATE blob_refcounts SET refs = refs + 1 WHERE hash = NEW.hash;
+	END;
+	CREATE TRIGGER attestations_ref_delete AFTER DELETE ON attestations BEGIN
+		UPDATE blob_refcounts SET refs = refs - 1 WHERE hash = OLD.hash;
+	END;
+	`,
+	`
+	-- Digests are keyed by blob and, like malware scans, recorded before
+	-- the upload's metadata.
+	CREATE TABLE blob_digests (
+		hash TEXT PRIMARY KEY,
+		sha512 TEXT NOT NULL,
+		blake3 TEXT NOT NULL DEFAULT '',
+		computed_at DATETIME NOT NULL
+	);
+	`,
+	`
+	CREATE TABLE blob_formats (
+		hash TEXT PRIMARY KEY,
+		format TEXT NOT NULL,
+		detected_at DATETIME NOT NULL
+	);
+	CREATE INDEX idx_blob_formats_format ON blob_formats(format);
+	`,
+	`
+	CREATE TABLE subscriptions (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		package    TEXT NOT NULL,
+		events     TEXT NOT NULL DEFAULT '',
+		channel    TEXT NOT NULL,
+		target     TEXT NOT NULL,
+		secret     TEXT NOT NULL DEFAULT '',
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	`,
+	`
+	CREATE TABLE download_stats (
+		day       TEXT NOT NULL,
+		package   TEXT NOT NULL,
+		version   TEXT NOT NULL,
+		principal TEXT NOT NULL DEFAULT '',
+		downloads INTEGER NOT NULL DEFAULT 0,
+		bytes     INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, package, version, principal)
+	);
+	`,
+	`
+	-- How many rows of blob_refs each package has pointing at each blob,
+	-- kept up to date by triggers like blob_refcounts, for storage usage
+	-- by package. Rows are removed when they drop to zero. Named files,
+	-- SBOMs, scan reports and attestations are deleted before their
+	-- version, so they can still find its package.
+	CREATE TABLE package_blobs (
+		package_id INTEGER NOT NULL,
+		hash       TEXT NOT NULL,
+		size       INTEGER NOT NULL,
+		refs       INTEGER NOT NULL,
+		PRIMARY KEY (package_id, hash)
+	);
+	INSERT INTO package_blobs (package_id, hash, size, refs)
+		SELECT package_id, hash, MAX(size), COUNT(*) FROM (
+			SELECT package_id, hash, size FROM artifacts
+			UNION ALL SELECT a.package_id, x.hash, x.size FROM assets x JOIN artifacts a ON a.id = x.artifact_id
+			UNION ALL SELECT a.package_id, x.hash, x.size FROM sboms x JOIN artifacts a ON a.id = x.artifact_id
+			UNION ALL SELECT a.package_id, x.hash, x.size FROM scan_reports x JOIN artifacts a ON a.id = x.artifact_id
+			UNION ALL SELECT a.package_id, x.hash, x.size FROM attestations x JOIN artifacts a ON a.id = x.artifact_id
+		) GROUP BY package_id, hash;
+	CREATE TRIGGER package_blobs_release AFTER UPDATE OF refs ON package_blobs WHEN NEW.refs <= 0 BEGIN
+		DELETE FROM package_blobs WHERE package_id = NEW.package_id AND hash = NEW.hash;
+	END;
+	CREATE TRIGGER artifacts_usage_insert AFTER INSERT ON artifacts BEGIN
+		INSERT INTO package_blobs (package_id, hash, size, refs)
+			SELECT NEW.package_id, NEW.hash, NEW.size, 0
+			WHERE NOT EXISTS (SELECT 1 FROM package_blobs WHERE package_id = NEW.package_id AND hash = NEW.hash);
+		UPDATE package_blobs SET refs = refs + 1 WHERE package_id = NEW.package_id AND hash = NEW.hash;
+	END;
+	CREATE TRIGGER artifacts_usage_update AFTER UPDATE OF hash ON artifacts WHEN NEW.hash != OLD.hash BEGIN
+		UPDATE package_blobs SET refs = refs - 1 WHERE package_id = OLD.package_id AND hash = OLD.hash;
+		INSERT INTO package_blobs (package_id, hash, size, refs)
+			SELECT NEW.package_id, NEW.hash, NEW.size, 0
+			WHERE NOT EXISTS (SELECT 1 FROM package_blobs WHERE package_id = NEW.package_id AND hash = NEW.hash);
+		UPDATE package_blobs SET refs = refs + 1 WHERE package_id = NEW.package_id AND hash = NEW.hash;
+	END;
+	CREATE TRIGGER artifacts_usage_delete AFTER DELETE ON artifacts BEGIN
+		UPDATE package_blobs SET refs = refs - 1 WHERE package_id = OLD.package_id AND hash = OLD.hash;
+	END;
+	CREATE TRIGGER assets_usage_insert AFTER INSERT ON assets BEGIN
+		INSERT INTO package_blobs (package_id, hash, size, refs)
+			SELECT (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id), NEW.hash, NEW.size, 0
+			WHERE NOT EXISTS (SELECT 1 FROM package_blobs WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash);
+		UPDATE package_blobs SET refs = refs + 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash;
+	END;
+	CREATE TRIGGER assets_usage_update AFTER UPDATE OF hash ON assets WHEN NEW.hash != OLD.hash BEGIN
+		UPDATE package_blobs SET refs = refs - 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = OLD.artifact_id) AND hash = OLD.hash;
+		INSERT INTO package_blobs (package_id, hash, size, refs)
+			SELECT (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id), NEW.hash, NEW.size, 0
+			WHERE NOT EXISTS (SELECT 1 FROM package_blobs WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash);
+		UPDATE package_blobs SET refs = refs + 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash;
+	END;
+	CREATE TRIGGER assets_usage_delete AFTER DELETE ON assets BEGIN
+		UPDATE package_blobs SET refs = refs - 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = OLD.artifact_id) AND hash = OLD.hash;
+	END;
+	CREATE TRIGGER sboms_usage_insert AFTER INSERT ON sboms BEGIN
+		INSERT INTO package_blobs (package_id, hash, size, refs)
+			SELECT (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id), NEW.hash, NEW.size, 0
+			WHERE NOT EXISTS (SELECT 1 FROM package_blobs WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash);
+		UPDATE package_blobs SET refs = refs + 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash;
+	END;
+	CREATE TRIGGER sboms_usage_update AFTER UPDATE OF hash ON sboms WHEN NEW.hash != OLD.hash BEGIN
+		UPDATE package_blobs SET refs = refs - 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = OLD.artifact_id) AND hash = OLD.hash;
+		INSERT INTO package_blobs (package_id, hash, size, refs)
+			SELECT (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id), NEW.hash, NEW.size, 0
+			WHERE NOT EXISTS (SELECT 1 FROM package_blobs WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash);
+		UPDATE package_blobs SET refs = refs + 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash;
+	END;
+	CREATE TRIGGER sboms_usage_delete AFTER DELETE ON sboms BEGIN
+		UPDATE package_blobs SET refs = refs - 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = OLD.artifact_id) AND hash = OLD.hash;
+	END;
+	CREATE TRIGGER scan_reports_usage_insert AFTER INSERT ON scan_reports BEGIN
+		INSERT INTO package_blobs (package_id, hash, size, refs)
+			SELECT (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id), NEW.hash, NEW.size, 0
+			WHERE NOT EXISTS (SELECT 1 FROM package_blobs WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash);
+		UPDATE package_blobs SET refs = refs + 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash;
+	END;
+	CREATE TRIGGER scan_reports_usage_update AFTER UPDATE OF hash ON scan_reports WHEN NEW.hash != OLD.hash BEGIN
+		UPDATE package_blobs SET refs = refs - 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = OLD.artifact_id) AND hash = OLD.hash;
+		INSERT INTO package_blobs (package_id, hash, size, refs)
+			SELECT (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id), NEW.hash, NEW.size, 0
+			WHERE NOT EXISTS (SELECT 1 FROM package_blobs WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash);
+		UPDATE package_blobs SET refs = refs + 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash;
+	END;
+	CREATE TRIGGER scan_reports_usage_delete AFTER DELETE ON scan_reports BEGIN
+		UPDATE package_blobs SET refs = refs - 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = OLD.artifact_id) AND hash = OLD.hash;
+	END;
+	CREATE TRIGGER attestations_usage_insert AFTER INSERT ON attestations BEGIN
+		INSERT INTO package_blobs (package_id, hash, size, refs)
+			SELECT (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id), NEW.hash, NEW.size, 0
+			WHERE NOT EXISTS (SELECT 1 FROM package_blobs WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash);
+		UPDATE package_blobs SET refs = refs + 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash;
+	END;
+	CREATE TRIGGER attestations_usage_update AFTER UPDATE OF hash ON attestations WHEN NEW.hash != OLD.hash BEGIN
+		UPDATE package_blobs SET refs = refs - 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = OLD.artifact_id) AND hash = OLD.hash;
+		INSERT INTO package_blobs (package_id, hash, size, refs)
+			SELECT (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id), NEW.hash, NEW.size, 0
+			WHERE NOT EXISTS (SELECT 1 FROM package_blobs WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash);
+		UPDATE package_blobs SET refs = refs + 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = NEW.artifact_id) AND hash = NEW.hash;
+	END;
+	CREATE TRIGGER attestations_usage_delete AFTER DELETE ON attestations BEGIN
+		UPDATE package_blobs SET refs = refs - 1 WHERE package_id = (SELECT package_id FROM artifacts WHERE id = OLD.artifact_id) AND hash = OLD.hash;
+	END;
+	`,
+	`
+	CREATE TABLE users (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		name       TEXT NOT NULL UNIQUE,
+		created_at DATETIME NOT NULL
+	);
+	CREATE TABLE teams (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		name       TEXT NOT NULL UNIQUE,
+		sso_group  TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	CREATE TABLE team_members (
+		team_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		PRIMARY KEY (team_id, user_id)
+	);
+	-- Owners are kept by package name, so a name can be claimed before
+	-- its first version is published and stays claimed after its last is
+	-- deleted.
+	CREATE TABLE package_owners (
+		package TEXT NOT NULL,
+		team_id INTEGER NOT NULL,
+		PRIMARY KEY (package, team_id)
+	);
+	ALTER TABLE api_tokens ADD COLUMN user_name TEXT NOT NULL DEFAULT '';
+	`,
+	`
+	CREATE TABLE approvals (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		action       TEXT NOT NULL,
+		package      TEXT NOT NULL DEFAULT '',
+		version      TEXT NOT NULL DEFAULT '',
+		tombstone    INTEGER NOT NULL DEFAULT 0,
+		reason       TEXT NOT NULL,
+		status       TEXT NOT NULL,
+		requested_by TEXT NOT NULL,
+		requested_at DATETIME NOT NULL,
+		decided_by   TEXT NOT NULL DEFAULT '',
+		decided_at   DATETIME,
+		result       TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX idx_approvals_status ON approvals(status);
+	`,
+	`
+	CREATE TABLE comments (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		package_id  INTEGER NOT NULL,
+		artifact_id INTEGER,
+		body        TEXT NOT NULL,
+		author      TEXT NOT NULL DEFAULT '',
+		created_at  DATETIME NOT NULL
+	);
+	CREATE INDEX idx_comments_package ON comments(package_id);
+	CREATE INDEX idx_comments_artifact ON comments(artifact_id);
+	`,
+	`
+	-- An empty deprecation message means not deprecated.
+	ALTER TABLE packages ADD COLUMN deprecation TEXT NOT NULL DEFAULT '';
+	ALTER TABLE packages ADD COLUMN deprecated_in_favor_of TEXT NOT NULL DEFAULT '';
+	ALTER TABLE artifacts ADD COLUMN deprecation TEXT NOT NULL DEFAULT '';
+	ALTER TABLE artifacts ADD COLUMN deprecated_in_favor_of TEXT NOT NULL DEFAULT '';
+	`,
+	`
+	ALTER TABLE artifacts ADD COLUMN release_notes TEXT NOT NULL DEFAULT '';
+	`,
+	`
+	ALTER TABLE artifacts ADD COLUMN stability TEXT NOT NULL DEFAULT 'stable';
+	-- Earlier versions get the stability uploads now default to, from the
+	-- pre-release tag of MAJOR.MINOR.PATCH-TAG versions.
+	UPDATE artifacts SET stability = CASE
+			WHEN lower(substr(version, instr(version, '-') + 1)) GLOB 'rc*' THEN 'rc'
+			WHEN lower(substr(version, instr(version, '-') + 1)) GLOB 'beta*' THEN 'beta'
+			ELSE 'alpha'
+		END
+		WHERE ltrim(version, 'v') GLOB '[0-9]*.[0-9]*.[0-9]*-?*'
+		AND (instr(version, '+') = 0 OR instr(version, '-') < instr(version, '+'));
+	CREATE INDEX idx_artifacts_stability ON artifacts(stability);
+	`,
+	`
+	ALTER TABLE history ADD COLUMN source TEXT NOT NULL DEFAULT '';
+	`,
+	`
+	-- Per-package aggregates for sorting the package list, kept current by
+	-- triggers like blob_refcounts.
+	ALTER TABLE packages ADD COLUMN updated_at DATETIME;
+	ALTER TABLE packages ADD COLUMN total_size INTEGER NOT NULL DEFAULT 0;
+	UPDATE packages SET
+		updated_at = (SELECT MAX(uploaded_at) FROM artifacts WHERE package_id = packages.id),
+		total_size = (SELECT COALESCE(SUM(size), 0) FROM artifacts WHERE package_id = packages.id);
+	CREATE TRIGGER artifacts_stats_insert AFTER INSERT ON artifacts BEGIN
+		UPDATE packages SET
+			updated_at = (SELECT MAX(uploaded_at) FROM artifacts WHERE package_id = NEW.package_id),
+			total_size = total_size + NEW.size
+		WHERE id = NEW.package_id;
+	END;
+	CREATE TRIGGER artifacts_stats_update AFTER UPDATE OF package_id, size, uploaded_at ON artifacts BEGIN
+		UPDATE packages SET
+			updated_at = (SELECT MAX(uploaded_at) FROM artifacts WHERE package_id = packages.id),
+			total_size = (SELECT COALESCE(SUM(size), 0) FROM artifacts WHERE package_id = packages.id)
+		WHERE id IN (OLD.package_id, NEW.package_id);
+	END;
+	CREATE TRIGGER artifacts_stats_delete AFTER DELETE ON artifacts BEGIN
+		UPDATE packages SET
+			updated_at = (SELECT MAX(uploaded_at) FROM artifacts WHERE package_id = OLD.package_id),
+			total_size = total_size - OLD.size
+		WHERE id = OLD.package_id;
+	END;
+	CREATE INDEX idx_packages_updated_at ON packages(updated_at);
+	CREATE INDEX idx_packages_total_size ON packages(total_size);
+	`,
+	`
+	-- labels holds the version's labels as a JSON object, or '' for none.
+	ALTER TABLE artifacts ADD COLUMN description TEXT NOT NULL DEFAULT '';
+	ALTER TABLE artifacts ADD COLUMN labels TEXT NOT NULL DEFAULT '';
+	`,
+	`
+	CREATE TABLE token_usage (
+		token_id         INTEGER PRIMARY KEY,
+		requests         INTEGER NOT NULL,
+		bytes_uploaded   INTEGER NOT NULL,
+		bytes_downloaded INTEGER NOT NULL,
+		last_used_at     DATETIME NOT NULL,
+		FOREIGN KEY (token_id) REFERENCES api_tokens(id)
+	);
+	`,
+	`
+	-- Subscriptions made before admins were recorded are treated as
+	-- made by someone else.
+	ALTER TABLE subscriptions ADD COLUMN admin INTEGER NOT NULL DEFAULT 0;
+	`,
 }
 
 func migrate(db *sql.DB) error {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS packages (
-			id   INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT UNIQUE NOT NULL
-		);
-		CREATE TABLE IF NOT EXISTS artifacts (
-			id          INTEGER PRIMARY KEY AUTOINCREMENT,
-			package_id  INTEGER NOT NULL,
-			version     TEXT NOT NULL,
-			hash        TEXT NOT NULL,
-			size        INTEGER NOT NULL,
-			uploaded_at DATETIME NOT NULL,
-			UNIQUE(package_id, version),
-			FOREIGN KEY (package_id) REFERENCES packages(id)
-		);
-		CREATE INDEX IF NOT EXISTS idx_artifacts_hash ON artifacts(hash);
-	`)
-	return err
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("starting migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("applying migration %d: %w", i+1, err)
+		}
+		// PRAGMA does not accept bound parameters.
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("recording migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("committing migration %d: %w", i+1, err)
+		}
+	}
+	return nil
 }
 
 func (s *SQLiteStore) CreatePackage(name string) (int64, error) {
@@ -73,9 +700,25 @@ func (s *SQLiteStore) CreatePackage(name string) (int64, error) {
 	return id, nil
 }
 
+// packageSelect selects packages, read with scanPackage.
+const packageSelect = "SELECT p.id, p.name, p.deprecation, p.deprecated_in_favor_of, p.updated_at, p.total_size FROM packages p"
+
+func scanPackage(row interface{ Scan(...any) error }) (models.Package, error) {
+	var p models.Package
+	var message, inFavorOf string
+	var updatedAt sql.NullTime
+	if err := row.Scan(&p.ID, &p.Name, &message, &inFavorOf, &updatedAt, &p.TotalSize); err != nil {
+		return p, err
+	}
+	p.Deprecated = deprecation(message, inFavorOf)
+	if updatedAt.Valid {
+		p.UpdatedAt = &updatedAt.Time
+	}
+	return p, nil
+}
+
 func (s *SQLiteStore) GetPackage(name string) (*models.Package, error) {
-	var pkg models.Package
-	err := s.db.QueryRow("SELECT id, name FROM packages WHERE name = ?", name).Scan(&pkg.ID, &pkg.Name)
+	pkg, err := scanPackage(s.db.QueryRow(packageSelect+" WHERE p.name = ?", name))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -86,7 +729,7 @@ func (s *SQLiteStore) GetPackage(name string) (*models.Package, error) {
 }
 
 func (s *SQLiteStore) ListPackages() ([]models.Package, error) {
-	rows, err := s.db.Query("SELECT id, name FROM packages ORDER BY name")
+	rows, err := s.db.Query(packageSelect + " ORDER BY p.name")
 	if err != nil {
 		return nil, fmt.Errorf("listing packages: %w", err)
 	}
@@ -94,8 +737,8 @@ func (s *SQLiteStore) ListPackages() ([]models.Package, error) {
 
 	var pkgs []models.Package
 	for rows.Next() {
-		var p models.Package
-		if err := rows.Scan(&p.ID, &p.Name); err != nil {
+		p, err := scanPackage(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scanning package: %w", err)
 		}
 		pkgs = append(pkgs, p)
@@ -104,7 +747,7 @@ func (s *SQLiteStore) ListPackages() ([]models.Package, error) {
 }
 
 func (s *SQLiteStore) SearchPackages(query string) ([]models.Package, error) {
-	rows, err := s.db.Query("SELECT id, name FROM packages WHERE name LIKE ? ORDER BY name", "%"+query+"%")
+	rows, err := s.db.Query(packageSelect+" WHERE p.name LIKE ? ORDER BY p.name", "%"+query+"%")
 	if err != nil {
 		return nil, fmt.Errorf("searching packages: %w", err)
 	}
@@ -112,8 +755,8 @@ func (s *SQLiteStore) SearchPackages(query string) ([]models.Package, error) {
 
 	var pkgs []models.Package
 	for rows.Next() {
-		var p models.Package
-		if err := rows.Scan(&p.ID, &p.Name); err != nil {
+		p, err := scanPackage(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scanning package: %w", err)
 		}
 		pkgs = append(pkgs, p)
@@ -121,11 +764,62 @@ func (s *SQLiteStore) SearchPackages(query string) ([]models.Package, error) {
 	return pkgs, rows.Err()
 }
 
-func (s *SQLiteStore) CreateArtifact(packageID int64, version, hash string, size int64) (*models.Artifact, error) {
-	now := time.Now().UTC()
-	result, err := s.db.Exec(
-		"INSERT INTO artifacts (package_id, version, hash, size, uploaded_at) VALUES (?, ?, ?, ?, ?)",
-		packageID, version, hash, size, now,
+func (s *SQLiteStore) CreateArtifact(packageID int64, in models.ArtifactInput) (*models.Artifact, error) {
+	return s.insertArtifact(s.db, packageID, in)
+}
+
+func (s *SQLiteStore) CreateArtifactForPackage(packageName string, in models.ArtifactInput) (*models.Artifact, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("creating artifact: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT OR IGNORE INTO packages (name) VALUES (?)", packageName); err != nil {
+		return nil, fmt.Errorf("creating package: %w", err)
+	}
+	var packageID int64
+	if err := tx.QueryRow("SELECT id FROM packages WHERE name = ?", packageName).Scan(&packageID); err != nil {
+		return nil, fmt.Errorf("getting package id: %w", err)
+	}
+	artifact, err := s.insertArtifact(tx, packageID, in)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("creating artifact: %w", err)
+	}
+	artifact.Package = packageName
+	return artifact, nil
+}
+
+// dbtx is a *queryDB or a *queryTx.
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// insertArtifact records a version of packageID through db and reads back
+// what is known about its blob.
+func (s *SQLiteStore) insertArtifact(db dbtx, packageID int64, in models.ArtifactInput) (*models.Artifact, error) {
+	now := s.clock.Now().UTC()
+	stage := in.Stage
+	if stage == "" {
+		stage = models.StageRelease
+	}
+	stability := inputStability(in)
+	var expiresAt *time.Time
+	if in.ExpiresAt != nil {
+		t := in.ExpiresAt.UTC()
+		expiresAt = &t
+	}
+	labels, err := encodeLabels(in.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("creating artifact: %w", err)
+	}
+	result, err := db.Exec(
+		"INSERT INTO artifacts (package_id, version, hash, size, filename, content_type, uploaded_at, quarantined, stage, stability, expires_at, description, labels) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		packageID, in.Version, in.Hash, in.Size, in.Filename, in.ContentType, now, in.Quarantined, stage, stability, expiresAt, in.Description, labels,
 	)
 	if err != nil {
 		if isUniqueConstraint(err) {
@@ -135,39 +829,139 @@ func (s *SQLiteStore) CreateArtifact(packageID int64, version, hash string, size
 	}
 
 	id, _ := result.LastInsertId()
+	for i, d := range in.Dependencies {
+		_, err := db.Exec(
+			"INSERT INTO dependencies (artifact_id, position, package, version_constraint) VALUES (?, ?, ?, ?)",
+			id, i, d.Package, d.Constraint,
+		)
+		if err != nil {
+			if isUniqueConstraint(err) {
+				return nil, fmt.Errorf("%w: duplicate dependency on %s", services.ErrConflict, d.Package)
+			}
+			return nil, fmt.Errorf("inserting dependency: %w", err)
+		}
+	}
+	tier := models.TierHot
+	db.QueryRow("SELECT tier FROM blob_refcounts WHERE hash = ?", in.Hash).Scan(&tier)
+	var malware, licenses string
+	db.QueryRow("SELECT result FROM malware_scans WHERE hash = ?", in.Hash).Scan(&malware)
+	db.QueryRow("SELECT COALESCE(group_concat(DISTINCT license), '') FROM content_licenses WHERE hash = ?", in.Hash).Scan(&licenses)
+	var sha512, blake3 string
+	db.QueryRow("SELECT sha512, blake3 FROM blob_digests WHERE hash = ?", in.Hash).Scan(&sha512, &blake3)
+	var format string
+	db.QueryRow("SELECT format FROM blob_formats WHERE hash = ?", in.Hash).Scan(&format)
 	return &models.Artifact{
-		ID:         id,
-		PackageID:  packageID,
-		Version:    version,
-		Hash:       hash,
-		Size:       size,
-		UploadedAt: now,
+		ID:          id,
+		PackageID:   packageID,
+		Version:     in.Version,
+		Hash:        in.Hash,
+		Size:        in.Size,
+		Filename:    in.Filename,
+		ContentType: in.ContentType,
+		UploadedAt:  now,
+		Quarantined: in.Quarantined,
+		Stage:       stage,
+		Stability:   stability,
+		ExpiresAt:   expiresAt,
+		Description: in.Description,
+		Labels:      maps.Clone(in.Labels),
+		Revision:    1,
+		Tier:        tier,
+		Malware:     malware,
+		Licenses:    splitLicenses(licenses),
+		SHA512:      sha512,
+		BLAKE3:      blake3,
+		Format:      format,
 	}, nil
 }
 
-func (s *SQLiteStore) GetArtifact(packageName, version string) (*models.Artifact, error) {
+// artifactSelect selects artifacts with their package name and, through the
+// left joins, the vulnerability summary of their scan report and the
+// storage tier, malware scan result, detected licenses, digests and format
+// of their blob.
+// Rows are read with scanArtifact.
+const artifactSelect = `
+	SELECT a.id, a.package_id, p.name, a.version, a.hash, a.size, a.filename, a.content_type, a.uploaded_at,
+		a.quarantined, a.stage, a.promoted_by, a.promoted_at, a.stability, a.expires_at, a.pinned, a.deprecation, a.deprecated_in_favor_of, a.release_notes, a.description, a.labels, a.revision, s.artifact_id IS NOT NULL, COALESCE(s.critical, 0), COALESCE(s.high, 0),
+		COALESCE(s.medium, 0), COALESCE(s.low, 0), COALESCE(s.unknown, 0), COALESCE(b.tier, 'hot'),
+		COALESCE(m.result, ''),
+		(SELECT COALESCE(group_concat(DISTINCT l.license), '') FROM content_licenses l WHERE l.hash = a.hash),
+		COALESCE(d.sha512, ''), COALESCE(d.blake3, ''), COALESCE(f.format, '')
+	FROM artifacts a
+	JOIN packages p ON a.package_id = p.id
+	LEFT JOIN scan_reports s ON s.artifact_id = a.id
+	LEFT JOIN blob_refcounts b ON b.hash = a.hash
+	LEFT JOIN malware_scans m ON m.hash = a.hash
+	LEFT JOIN blob_digests d ON d.hash = a.hash
+	LEFT JOIN blob_formats f ON f.hash = a.hash`
+
+func scanArtifact(row interface{ Scan(...any) error }) (models.Artifact, error) {
 	var a models.Artifact
-	err := s.db.QueryRow(`
-		SELECT a.id, a.package_id, p.name, a.version, a.hash, a.size, a.uploaded_at
-		FROM artifacts a JOIN packages p ON a.package_id = p.id
-		WHERE p.name = ? AND a.version = ?
-	`, packageName, version).Scan(&a.ID, &a.PackageID, &a.Package, &a.Version, &a.Hash, &a.Size, &a.UploadedAt)
+	var scanned bool
+	var promotedAt, expiresAt sql.NullTime
+	var v models.VulnerabilitySummary
+	var licenses, deprecationMessage, inFavorOf, labels string
+	err := row.Scan(&a.ID, &a.PackageID, &a.Package, &a.Version, &a.Hash, &a.Size, &a.Filename, &a.ContentType, &a.UploadedAt,
+		&a.Quarantined, &a.Stage, &a.PromotedBy, &promotedAt, &a.Stability, &expiresAt, &a.Pinned, &deprecationMessage, &inFavorOf, &a.ReleaseNotes, &a.Description, &labels, &a.Revision, &scanned, &v.Critical, &v.High, &v.Medium, &v.Low, &v.Unknown, &a.Tier,
+		&a.Malware, &licenses, &a.SHA512, &a.BLAKE3, &a.Format)
+	if err != nil {
+		return a, err
+	}
+	a.Licenses = splitLicenses(licenses)
+	a.Deprecated = deprecation(deprecationMessage, inFavorOf)
+	if labels != "" {
+		if err := json.Unmarshal([]byte(labels), &a.Labels); err != nil {
+			return a, fmt.Errorf("decoding labels: %w", err)
+		}
+	}
+	a.UploadedAt = a.UploadedAt.UTC()
+	if promotedAt.Valid {
+		t := promotedAt.Time.UTC()
+		a.PromotedAt = &t
+	}
+	if expiresAt.Valid {
+		t := expiresAt.Time.UTC()
+		a.ExpiresAt = &t
+	}
+	if scanned {
+		a.Vulnerabilities = &v
+	}
+	return a, nil
+}
+
+// encodeLabels returns labels as stored in artifacts.labels.
+func encodeLabels(labels map[string]string) (string, error) {
+	if len(labels) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(labels)
+	return string(data), err
+}
+
+const getArtifactQuery = artifactSelect + " WHERE p.name = ? AND a.version = ?"
+
+// GetArtifact answers from a cache of recent results, which any write to
+// the database other than recording a download empties.
+func (s *SQLiteStore) GetArtifact(packageName, version string) (*models.Artifact, error) {
+	key := artifactKey(packageName, version)
+	writes := s.db.writes.Load()
+	if a, ok := s.artifacts.get(key, writes); ok {
+		return a, nil
+	}
+	a, err := scanArtifact(s.db.QueryRow(getArtifactQuery, packageName, version))
 	if err == sql.ErrNoRows {
+		s.artifacts.put(key, nil, writes)
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("getting artifact: %w", err)
 	}
+	s.artifacts.put(key, &a, writes)
 	return &a, nil
 }
 
 func (s *SQLiteStore) ListArtifacts(packageName string) ([]models.Artifact, error) {
-	rows, err := s.db.Query(`
-		SELECT a.id, a.package_id, p.name, a.version, a.hash, a.size, a.uploaded_at
-		FROM artifacts a JOIN packages p ON a.package_id = p.id
-		WHERE p.name = ?
-		ORDER BY a.uploaded_at DESC
-	`, packageName)
+	rows, err := s.db.Query(artifactSelect+" WHERE p.name = ? ORDER BY a.uploaded_at DESC", packageName)
 	if err != nil {
 		return nil, fmt.Errorf("listing artifacts: %w", err)
 	}
@@ -175,8 +969,8 @@ func (s *SQLiteStore) ListArtifacts(packageName string) ([]models.Artifact, erro
 
 	var artifacts []models.Artifact
 	for rows.Next() {
-		var a models.Artifact
-		if err := rows.Scan(&a.ID, &a.PackageID, &a.Package, &a.Version, &a.Hash, &a.Size, &a.UploadedAt); err != nil {
+		a, err := scanArtifact(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scanning artifact: %w", err)
 		}
 		artifacts = append(artifacts, a)
@@ -184,25 +978,314 @@ func (s *SQLiteStore) ListArtifacts(packageName string) ([]models.Artifact, erro
 	return artifacts, rows.Err()
 }
 
-func (s *SQLiteStore) DeleteArtifact(packageName, version string) error {
+func (s *SQLiteStore) FindArtifacts(f models.ArtifactFilter) ([]models.Artifact, error) {
+	var where []string
+	var args []any
+	if f.Package != "" {
+		where = append(where, "p.name = ?")
+		args = append(args, f.Package)
+	}
+	if !f.UploadedAfter.IsZero() {
+		where = append(where, "a.uploaded_at >= ?")
+		args = append(args, f.UploadedAfter.UTC())
+	}
+	if !f.UploadedBefore.IsZero() {
+		where = append(where, "a.uploaded_at < ?")
+		args = append(args, f.UploadedBefore.UTC())
+	}
+	if f.MinSize != nil {
+		where = append(where, "a.size >= ?")
+		args = append(args, *f.MinSize)
+	}
+	if f.MaxSize != nil {
+		where = append(where, "a.size <= ?")
+		args = append(args, *f.MaxSize)
+	}
+	if f.Hash != "" {
+		where = append(where, "(a.hash = ? OR a.id IN (SELECT artifact_id FROM assets WHERE hash = ?))")
+		args = append(args, f.Hash, f.Hash)
+	}
+	if f.License != "" {
+		where = append(where, "a.hash IN (SELECT hash FROM content_licenses WHERE license = ? COLLATE NOCASE)")
+		args = append(args, f.License)
+	}
+	if f.Format != "" {
+		where = append(where, "f.format = ?")
+		args = append(args, f.Format)
+	}
+	if f.Channel != "" {
+		levels := models.ChannelStabilities(f.Channel)
+		if len(levels) == 0 {
+			// An unknown channel offers nothing.
+			levels = []string{""}
+		}
+		where = append(where, "a.stability IN (?"+strings.Repeat(", ?", len(levels)-1)+")")
+		for _, level := range levels {
+			args = append(args, level)
+		}
+	}
+	if f.BeforeID > 0 {
+		where = append(where, "a.id < ?")
+		args = append(args, f.BeforeID)
+	}
+
+	query := artifactSelect
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY a.id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("finding artifacts: %w", err)
+	}
+	defer rows.Close()
+
+	var artifacts []models.Artifact
+	for rows.Next() {
+		a, err := scanArtifact(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning artifact: %w", err)
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, rows.Err()
+}
+
+func (s *SQLiteStore) SetQuarantined(packageName, version string, quarantined bool) error {
+	result, err := s.db.Exec(`
+		UPDATE artifacts SET quarantined = ?, revision = revision + 1
+		WHERE version = ? AND package_id = (SELECT id FROM packages WHERE name = ?)
+	`, quarantined, version, packageName)
+	if err != nil {
+		return fmt.Errorf("setting quarantine: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, packageName, version)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetStage(packageName, version, stage, promotedBy string) error {
+	result, err := s.db.Exec(`
+		UPDATE artifacts SET stage = ?, promoted_by = ?, promoted_at = ?, revision = revision + 1
+		WHERE version = ? AND package_id = (SELECT id FROM packages WHERE name = ?)
+	`, stage, promotedBy, s.clock.Now().UTC(), version, packageName)
+	if err != nil {
+		return fmt.Errorf("setting stage: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, packageName, version)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetPinned(packageName, version string, pinned bool) error {
+	result, err := s.db.Exec(`
+		UPDATE artifacts SET pinned = ?, revision = revision + 1
+		WHERE version = ? AND package_id = (SELECT id FROM packages WHERE name = ?)
+	`, pinned, version, packageName)
+	if err != nil {
+		return fmt.Errorf("setting pin: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, packageName, version)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetDeprecation(packageName, version string, d *models.Deprecation) error {
+	message, inFavorOf := deprecationColumns(d)
+	result, err := s.db.Exec(`
+		UPDATE artifacts SET deprecation = ?, deprecated_in_favor_of = ?, revision = revision + 1
+		WHERE version = ? AND package_id = (SELECT id FROM packages WHERE name = ?)
+	`, message, inFavorOf, version, packageName)
+	if err != nil {
+		return fmt.Errorf("setting deprecation: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, packageName, version)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetPackageDeprecation(packageName string, d *models.Deprecation) error {
+	message, inFavorOf := deprecationColumns(d)
+	result, err := s.db.Exec(
+		"UPDATE packages SET deprecation = ?, deprecated_in_favor_of = ? WHERE name = ?",
+		message, inFavorOf, packageName,
+	)
+	if err != nil {
+		return fmt.Errorf("setting package deprecation: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: package %s", services.ErrNotFound, packageName)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetReleaseNotes(packageName, version, notes string) error {
 	result, err := s.db.Exec(`
-		DELETE FROM artifacts WHERE package_id = (
-			SELECT id FROM packages WHERE name = ?
-		) AND version = ?
-	`, packageName, version)
+		UPDATE artifacts SET release_notes = ?, revision = revision + 1
+		WHERE version = ? AND package_id = (SELECT id FROM packages WHERE name = ?)
+	`, notes, version, packageName)
+	if err != nil {
+		return fmt.Errorf("setting release notes: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, packageName, version)
+	}
+	return nil
+}
+
+// inputStability is the stability a new version is recorded with: the one
+// declared, or else the level its semver pre-release tag names.
+func inputStability(in models.ArtifactInput) string {
+	if in.Stability != "" {
+		return in.Stability
+	}
+	v, err := semver.Parse(in.Version)
+	if err != nil || len(v.Pre) == 0 {
+		return models.StabilityStable
+	}
+	switch tag := strings.ToLower(v.Pre[0]); {
+	case strings.HasPrefix(tag, "rc"):
+		return models.StabilityRC
+	case strings.HasPrefix(tag, "beta"):
+		return models.StabilityBeta
+	}
+	return models.StabilityAlpha
+}
+
+// deprecationColumns returns the columns recording d, empty for nil.
+func deprecationColumns(d *models.Deprecation) (message, inFavorOf string) {
+	if d == nil || d.Message == "" {
+		return "", ""
+	}
+	return d.Message, d.InFavorOf
+}
+
+// deprecation reads back the columns written by deprecationColumns.
+func deprecation(message, inFavorOf string) *models.Deprecation {
+	if message == "" {
+		return nil
+	}
+	return &models.Deprecation{Message: message, InFavorOf: inFavorOf}
+}
+
+// bumpRevision advances the revision of a version whose metadata changed
+// outside the artifacts row, such as its files, dependencies or reports.
+func bumpRevision(e interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, packageName, version string) error {
+	_, err := e.Exec(`
+		UPDATE artifacts SET revision = revision + 1
+		WHERE version = ? AND package_id = (SELECT id FROM packages WHERE name = ?)
+	`, version, packageName)
+	if err != nil {
+		return fmt.Errorf("updating revision: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListExpired(now time.Time) ([]models.Artifact, error) {
+	rows, err := s.db.Query(artifactSelect+" WHERE a.expires_at IS NOT NULL AND a.expires_at <= ? AND a.pinned = 0 ORDER BY a.expires_at, a.id", now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("listing expired artifacts: %w", err)
+	}
+	defer rows.Close()
+
+	var artifacts []models.Artifact
+	for rows.Next() {
+		a, err := scanArtifact(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning artifact: %w", err)
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, rows.Err()
+}
+
+func (s *SQLiteStore) ListQuarantined() ([]models.Artifact, error) {
+	rows, err := s.db.Query(artifactSelect + " WHERE a.quarantined = 1 ORDER BY a.uploaded_at, a.id")
+	if err != nil {
+		return nil, fmt.Errorf("listing quarantined artifacts: %w", err)
+	}
+	defer rows.Close()
+
+	var artifacts []models.Artifact
+	for rows.Next() {
+		a, err := scanArtifact(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning artifact: %w", err)
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteArtifact(packageName, version string) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("deleting artifact: %w", err)
 	}
+	defer tx.Rollback()
 
-	n, _ := result.RowsAffected()
-	if n == 0 {
+	var id int64
+	var pinned bool
+	err = tx.QueryRow(`
+		SELECT a.id, a.pinned FROM artifacts a JOIN packages p ON a.package_id = p.id
+		WHERE p.name = ? AND a.version = ?
+	`, packageName, version).Scan(&id, &pinned)
+	if err == sql.ErrNoRows {
 		return fmt.Errorf("%w: artifact %s@%s", services.ErrNotFound, packageName, version)
 	}
+	if err != nil {
+		return fmt.Errorf("deleting artifact: %w", err)
+	}
+	if pinned {
+		return fmt.Errorf("%w: artifact %s@%s is pinned", services.ErrConflict, packageName, version)
+	}
+
+	if _, err := tx.Exec("DELETE FROM assets WHERE artifact_id = ?", id); err != nil {
+		return fmt.Errorf("deleting assets: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM crate_versions WHERE artifact_id = ?", id); err != nil {
+		return fmt.Errorf("deleting crate version: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM dependencies WHERE artifact_id = ?", id); err != nil {
+		return fmt.Errorf("deleting dependencies: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM sbom_components WHERE artifact_id = ?", id); err != nil {
+		return fmt.Errorf("deleting SBOM components: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM sboms WHERE artifact_id = ?", id); err != nil {
+		return fmt.Errorf("deleting SBOM: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM scan_reports WHERE artifact_id = ?", id); err != nil {
+		return fmt.Errorf("deleting scan report: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM attestations WHERE artifact_id = ?", id); err != nil {
+		return fmt.Errorf("deleting attestations: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM comments WHERE artifact_id = ?", id); err != nil {
+		return fmt.Errorf("deleting comments: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM artifacts WHERE id = ?", id); err != nil {
+		return fmt.Errorf("deleting artifact: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("deleting artifact: %w", err)
+	}
 	return nil
 }
 
 func (s *SQLiteStore) ReferencedHashes() (map[string]bool, error) {
-	rows, err := s.db.Query("SELECT DISTINCT hash FROM artifacts")
+	rows, err := s.db.Query("SELECT hash FROM blob_refcounts WHERE refs > 0")
 	if err != nil {
 		return nil, fmt.Errorf("querying referenced hashes: %w", err)
 	}
@@ -219,6 +1302,86 @@ func (s *SQLiteStore) ReferencedHashes() (map[string]bool, error) {
 	return refs, rows.Err()
 }
 
+func (s *SQLiteStore) ReleasedBlobs(after string, limit int) ([]string, error) {
+	rows, err := s.db.Query("SELECT hash FROM blob_refcounts WHERE refs = 0 AND hash > ? ORDER BY hash LIMIT ?", after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("listing released blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, fmt.Errorf("scanning hash: %w", err)
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
+
+func (s *SQLiteStore) ForgetBlob(hash string) (bool, error) {
+	var refs int64
+	err := s.db.QueryRow("DELETE FROM blob_refcounts WHERE hash = ? AND refs = 0 RETURNING refs", hash).Scan(&refs)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("forgetting blob: %w", err)
+	}
+	// Nothing deleted: the blob is either referenced or was never counted.
+	err = s.db.QueryRow("SELECT refs FROM blob_refcounts WHERE hash = ?", hash).Scan(&refs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("forgetting blob: %w", err)
+	}
+	return false, nil
+}
+
+func (s *SQLiteStore) ListFileRefs() ([]models.FileRef, error) {
+	rows, err := s.db.Query(`
+		SELECT p.name, a.version, '', a.hash
+		FROM artifacts a JOIN packages p ON a.package_id = p.id
+		UNION ALL
+		SELECT p.name, a.version, f.name, f.hash
+		FROM assets f JOIN artifacts a ON f.artifact_id = a.id JOIN packages p ON a.package_id = p.id
+		ORDER BY 1, 2, 3
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("listing file references: %w", err)
+	}
+	defer rows.Close()
+
+	var refs []models.FileRef
+	for rows.Next() {
+		var f models.FileRef
+		if err := rows.Scan(&f.Package, &f.Version, &f.File, &f.Hash); err != nil {
+			return nil, fmt.Errorf("scanning file reference: %w", err)
+		}
+		refs = append(refs, f)
+	}
+	return refs, rows.Err()
+}
+
+func (s *SQLiteStore) Stats() (*models.RegistryStats, error) {
+	var st models.RegistryStats
+	err := s.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM packages),
+			(SELECT COUNT(*) FROM artifacts),
+			(SELECT COALESCE(SUM(size), 0) FROM artifacts) + (SELECT COALESCE(SUM(size), 0) FROM assets),
+			(SELECT COUNT(*) FROM blob_refcounts WHERE refs > 0),
+			(SELECT COALESCE(SUM(size), 0) FROM blob_refcounts WHERE refs > 0),
+			(SELECT COUNT(*) FROM api_tokens WHERE revoked_at IS NULL)
+	`).Scan(&st.Packages, &st.Artifacts, &st.ArtifactBytes, &st.UniqueBlobs, &st.UniqueBlobBytes, &st.ActiveTokens)
+	if err != nil {
+		return nil, fmt.Errorf("querying stats: %w", err)
+	}
+	return &st, nil
+}
+
 func (s *SQLiteStore) Close() error {
 	return s.db.Close()
 }
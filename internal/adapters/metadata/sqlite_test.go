@@ -1,11 +1,19 @@
 package metadata
 
 import (
+	"cmp"
+	"database/sql"
 	"errors"
+	"fmt"
 	"os"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/foundry/registry/internal/core/models"
 	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/clock"
 )
 
 func newTestStore(t *testing.T) *SQLiteStore {
@@ -81,6 +89,50 @@ func TestListPackages(t *testing.T) {
 	}
 }
 
+func TestPackageAggregates(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC))
+	store, err := NewSQLiteStore(t.TempDir(), WithClock(fake))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	check := func(when string, wantSize int64, wantUpdated time.Time) {
+		t.Helper()
+		pkg, err := store.GetPackage("lib")
+		if err != nil || pkg == nil {
+			t.Fatalf("%s: GetPackage: %v, %v", when, pkg, err)
+		}
+		if pkg.TotalSize != wantSize {
+			t.Errorf("%s: total size = %d, want %d", when, pkg.TotalSize, wantSize)
+		}
+		switch {
+		case wantUpdated.IsZero() && pkg.UpdatedAt != nil:
+			t.Errorf("%s: updated at = %v, want unset", when, pkg.UpdatedAt)
+		case !wantUpdated.IsZero() && (pkg.UpdatedAt == nil || !pkg.UpdatedAt.Equal(wantUpdated)):
+			t.Errorf("%s: updated at = %v, want %v", when, pkg.UpdatedAt, wantUpdated)
+		}
+	}
+
+	store.CreatePackage("lib")
+	check("empty", 0, time.Time{})
+	first := fake.Now()
+	store.CreateArtifactForPackage("lib", models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 100})
+	fake.Advance(time.Hour)
+	store.CreateArtifactForPackage("lib", models.ArtifactInput{Version: "2.0.0", Hash: "h2", Size: 50})
+	check("after two uploads", 150, fake.Now())
+	if list, _ := store.ListPackages(); len(list) != 1 || list[0].TotalSize != 150 {
+		t.Errorf("ListPackages = %+v", list)
+	}
+
+	if err := store.DeleteArtifact("lib", "2.0.0"); err != nil {
+		t.Fatalf("DeleteArtifact: %v", err)
+	}
+	check("after deleting the latest", 100, first)
+	store.DeleteArtifact("lib", "1.0.0")
+	check("after deleting every version", 0, time.Time{})
+}
+
 func TestSearchPackages(t *testing.T) {
 	store := newTestStore(t)
 
@@ -101,7 +153,7 @@ func TestCreateAndGetArtifact(t *testing.T) {
 	store := newTestStore(t)
 
 	pkgID, _ := store.CreatePackage("mylib")
-	artifact, err := store.CreateArtifact(pkgID, "1.0.0", "abc123", 1024)
+	artifact, err := store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "abc123", Size: 1024})
 	if err != nil {
 		t.Fatalf("CreateArtifact: %v", err)
 	}
@@ -137,8 +189,8 @@ func TestCreateDuplicateArtifact(t *testing.T) {
 	store := newTestStore(t)
 
 	pkgID, _ := store.CreatePackage("mylib")
-	store.CreateArtifact(pkgID, "1.0.0", "hash1", 100)
-	_, err := store.CreateArtifact(pkgID, "1.0.0", "hash2", 200)
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "hash1", Size: 100})
+	_, err := store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "hash2", Size: 200})
 	if err == nil {
 		t.Error("expected error for duplicate version")
 	}
@@ -147,12 +199,58 @@ func TestCreateDuplicateArtifact(t *testing.T) {
 	}
 }
 
+func TestCreateArtifactForPackage(t *testing.T) {
+	store := newTestStore(t)
+
+	a, err := store.CreateArtifactForPackage("mylib", models.ArtifactInput{Version: "1.0.0", Hash: "hash1", Size: 100})
+	if err != nil {
+		t.Fatalf("CreateArtifactForPackage: %v", err)
+	}
+	if a.Package != "mylib" || a.PackageID == 0 || a.Version != "1.0.0" {
+		t.Errorf("unexpected artifact %+v", a)
+	}
+	if _, err := store.CreateArtifactForPackage("mylib", models.ArtifactInput{Version: "1.0.0", Hash: "hash2"}); !errors.Is(err, services.ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	// A version that cannot be recorded takes its new package with it.
+	store.db.Exec(`CREATE TRIGGER refuse_bad BEFORE INSERT ON artifacts WHEN NEW.version = 'bad'
+		BEGIN SELECT RAISE(ABORT, 'refused'); END`)
+	if _, err := store.CreateArtifactForPackage("doomed", models.ArtifactInput{Version: "bad", Hash: "hash3"}); err == nil {
+		t.Fatal("expected the refused version to fail")
+	}
+	if pkg, _ := store.GetPackage("doomed"); pkg != nil {
+		t.Errorf("package of a failed version was left behind: %+v", pkg)
+	}
+
+	// Concurrent uploads of one new package agree on it.
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.CreateArtifactForPackage("shared", models.ArtifactInput{Version: fmt.Sprintf("1.0.%d", i), Hash: "hash4"}); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent create: %v", err)
+	}
+	if list, _ := store.ListArtifacts("shared"); len(list) != 16 {
+		t.Errorf("expected 16 versions of one package, got %d", len(list))
+	}
+}
+
 func TestListArtifacts(t *testing.T) {
 	store := newTestStore(t)
 
 	pkgID, _ := store.CreatePackage("mylib")
-	store.CreateArtifact(pkgID, "1.0.0", "hash1", 100)
-	store.CreateArtifact(pkgID, "2.0.0", "hash2", 200)
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "hash1", Size: 100})
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "2.0.0", Hash: "hash2", Size: 200})
 
 	artifacts, err := store.ListArtifacts("mylib")
 	if err != nil {
@@ -167,7 +265,7 @@ func TestDeleteArtifact(t *testing.T) {
 	store := newTestStore(t)
 
 	pkgID, _ := store.CreatePackage("mylib")
-	store.CreateArtifact(pkgID, "1.0.0", "hash1", 100)
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "hash1", Size: 100})
 
 	err := store.DeleteArtifact("mylib", "1.0.0")
 	if err != nil {
@@ -196,12 +294,12 @@ func TestReferencedHashes(t *testing.T) {
 	store := newTestStore(t)
 
 	pkgID, _ := store.CreatePackage("mylib")
-	store.CreateArtifact(pkgID, "1.0.0", "hash1", 100)
-	store.CreateArtifact(pkgID, "2.0.0", "hash2", 200)
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "hash1", Size: 100})
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "2.0.0", Hash: "hash2", Size: 200})
 
 	// Different package, same hash (dedup).
 	pkgID2, _ := store.CreatePackage("otherlib")
-	store.CreateArtifact(pkgID2, "1.0.0", "hash1", 100)
+	store.CreateArtifact(pkgID2, models.ArtifactInput{Version: "1.0.0", Hash: "hash1", Size: 100})
 
 	refs, err := store.ReferencedHashes()
 	if err != nil {
@@ -215,6 +313,140 @@ func TestReferencedHashes(t *testing.T) {
 	}
 }
 
+func TestBlobRefcounts(t *testing.T) {
+	store := newTestStore(t)
+
+	pkgID, _ := store.CreatePackage("app")
+	a1, _ := store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "shared", Size: 10})
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "2.0.0", Hash: "shared", Size: 10})
+	store.CreateAsset(a1.ID, models.AssetInput{Name: "notes.txt", Hash: "notes", Size: 2})
+	store.SetSBOM("app", "1.0.0", models.SBOM{Hash: "sbom1", Size: 3, Format: "spdx"}, nil)
+	if released, _ := store.ReleasedBlobs("", 10); len(released) != 0 {
+		t.Fatalf("nothing should be released yet: %v", released)
+	}
+
+	// Replacing the SBOM releases its old blob.
+	store.SetSBOM("app", "1.0.0", models.SBOM{Hash: "sbom2", Size: 3, Format: "spdx"}, nil)
+	released, err := store.ReleasedBlobs("", 10)
+	if err != nil || len(released) != 1 || released[0] != "sbom1" {
+		t.Fatalf("ReleasedBlobs = %v, %v; want [sbom1]", released, err)
+	}
+
+	// Pointing at a blob that is already counted adds a reference.
+	if _, err := store.SetSBOM("app", "1.0.0", models.SBOM{Hash: "shared", Size: 10, Format: "spdx"}, nil); err != nil {
+		t.Fatalf("SetSBOM: %v", err)
+	}
+	store.SetSBOM("app", "1.0.0", models.SBOM{Hash: "sbom2", Size: 3, Format: "spdx"}, nil)
+
+	// A shared blob is released with its last reference only.
+	store.DeleteArtifact("app", "1.0.0")
+	released, _ = store.ReleasedBlobs("", 10)
+	if fmt.Sprint(released) != "[notes sbom1 sbom2]" {
+		t.Errorf("ReleasedBlobs = %v, want [notes sbom1 sbom2]", released)
+	}
+	if released, _ := store.ReleasedBlobs("notes", 1); fmt.Sprint(released) != "[sbom1]" {
+		t.Errorf("ReleasedBlobs(notes, 1) = %v, want [sbom1]", released)
+	}
+
+	// Forgetting a referenced blob is refused; released and unknown blobs
+	// may go.
+	if ok, err := store.ForgetBlob("shared"); err != nil || ok {
+		t.Errorf("ForgetBlob(shared) = %v, %v; want false", ok, err)
+	}
+	if ok, err := store.ForgetBlob("notes"); err != nil || !ok {
+		t.Errorf("ForgetBlob(notes) = %v, %v; want true", ok, err)
+	}
+	if ok, err := store.ForgetBlob("unknown"); err != nil || !ok {
+		t.Errorf("ForgetBlob(unknown) = %v, %v; want true", ok, err)
+	}
+
+	// A released blob referenced again is no longer released.
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "3.0.0", Hash: "sbom1", Size: 3})
+	released, _ = store.ReleasedBlobs("", 10)
+	if fmt.Sprint(released) != "[sbom2]" {
+		t.Errorf("ReleasedBlobs = %v, want [sbom2]", released)
+	}
+	st, _ := store.Stats()
+	if st.UniqueBlobs != 2 || st.UniqueBlobBytes != 13 {
+		t.Errorf("stats should count referenced blobs only: %+v", st)
+	}
+}
+
+func TestBlobTiers(t *testing.T) {
+	base := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(base)
+	store, err := NewSQLiteStore(t.TempDir(), WithClock(fake))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer store.Close()
+
+	pkgID, _ := store.CreatePackage("app")
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "old", Size: 1})
+	fake.Advance(10 * 24 * time.Hour)
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "2.0.0", Hash: "new", Size: 1})
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "3.0.0", Hash: "read", Size: 1})
+
+	// Never-read blobs are idle from their upload; reads restart the clock.
+	fake.Advance(5 * 24 * time.Hour)
+	store.TouchBlob("read")
+	fake.Advance(24 * time.Hour)
+	idle, err := store.ListIdleBlobs(fake.Now().Add(-5*24*time.Hour), "", 10)
+	if err != nil || fmt.Sprint(idle) != "[new old]" {
+		t.Fatalf("ListIdleBlobs = %v, %v; want [new old]", idle, err)
+	}
+	if idle, _ := store.ListIdleBlobs(fake.Now().Add(-5*24*time.Hour), "new", 10); fmt.Sprint(idle) != "[old]" {
+		t.Errorf("ListIdleBlobs after new = %v, want [old]", idle)
+	}
+
+	// Cold blobs are not listed again, and versions report their tier.
+	if err := store.SetBlobTier("old", models.TierCold); err != nil {
+		t.Fatalf("SetBlobTier: %v", err)
+	}
+	if idle, _ := store.ListIdleBlobs(fake.Now(), "", 10); fmt.Sprint(idle) != "[new read]" {
+		t.Errorf("ListIdleBlobs = %v, want [new read]", idle)
+	}
+	if a, _ := store.GetArtifact("app", "1.0.0"); a.Tier != models.TierCold {
+		t.Errorf("1.0.0 tier = %q, want cold", a.Tier)
+	}
+	if a, _ := store.CreateArtifact(pkgID, models.ArtifactInput{Version: "4.0.0", Hash: "old", Size: 1}); a.Tier != models.TierCold {
+		t.Errorf("new version of a cold blob: tier = %q, want cold", a.Tier)
+	}
+	if a, _ := store.GetArtifact("app", "2.0.0"); a.Tier != models.TierHot {
+		t.Errorf("2.0.0 tier = %q, want hot", a.Tier)
+	}
+}
+
+func TestListFileRefs(t *testing.T) {
+	store := newTestStore(t)
+
+	libID, _ := store.CreatePackage("lib")
+	appID, _ := store.CreatePackage("app")
+	v2, _ := store.CreateArtifact(libID, models.ArtifactInput{Version: "2.0.0", Hash: "hash2", Size: 1})
+	store.CreateArtifact(libID, models.ArtifactInput{Version: "1.0.0", Hash: "hash1", Size: 1})
+	store.CreateArtifact(appID, models.ArtifactInput{Version: "1.0.0", Hash: "hash1", Size: 1})
+	store.CreateAsset(v2.ID, models.AssetInput{Name: "notes.txt", Hash: "notes", Size: 1})
+
+	refs, err := store.ListFileRefs()
+	if err != nil {
+		t.Fatalf("ListFileRefs: %v", err)
+	}
+	want := []models.FileRef{
+		{Package: "app", Version: "1.0.0", Hash: "hash1"},
+		{Package: "lib", Version: "1.0.0", Hash: "hash1"},
+		{Package: "lib", Version: "2.0.0", Hash: "hash2"},
+		{Package: "lib", Version: "2.0.0", File: "notes.txt", Hash: "notes"},
+	}
+	if len(refs) != len(want) {
+		t.Fatalf("expected %d refs, got %+v", len(want), refs)
+	}
+	for i := range want {
+		if refs[i] != want[i] {
+			t.Errorf("ref %d: expected %+v, got %+v", i, want[i], refs[i])
+		}
+	}
+}
+
 func TestSQLiteStoreDataDir(t *testing.T) {
 	dir := t.TempDir()
 	store, err := NewSQLiteStore(dir)
@@ -228,3 +460,1366 @@ func TestSQLiteStoreDataDir(t *testing.T) {
 		t.Error("expected registry.db to exist")
 	}
 }
+
+func TestArtifactTimestampsUseClock(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 8, 30, 0, 0, time.FixedZone("PDT", -7*3600)))
+	store, err := NewSQLiteStore(t.TempDir(), WithClock(fake))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	pkgID, _ := store.CreatePackage("mylib")
+	created, err := store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "hash1", Size: 100})
+	if err != nil {
+		t.Fatalf("CreateArtifact: %v", err)
+	}
+	if !created.UploadedAt.Equal(fake.Now()) {
+		t.Errorf("created uploaded_at = %v, want %v", created.UploadedAt, fake.Now())
+	}
+
+	got, err := store.GetArtifact("mylib", "1.0.0")
+	if err != nil {
+		t.Fatalf("GetArtifact: %v", err)
+	}
+	if !got.UploadedAt.Equal(fake.Now()) {
+		t.Errorf("stored uploaded_at = %v, want %v", got.UploadedAt, fake.Now())
+	}
+	if got.UploadedAt.Location() != time.UTC {
+		t.Errorf("uploaded_at location = %v, want UTC", got.UploadedAt.Location())
+	}
+}
+
+func TestConcurrentWritesDoNotFailWithBusy(t *testing.T) {
+	store := newTestStore(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("pkg-%d", i)
+			id, err := store.CreatePackage(name)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err := store.CreateArtifact(id, models.ArtifactInput{Version: "1.0.0", Hash: fmt.Sprintf("%064d", i), Size: 1}); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := store.GetArtifact(name, "1.0.0"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent write: %v", err)
+	}
+}
+
+func TestTokenLifecycle(t *testing.T) {
+	store := newTestStore(t)
+
+	created, err := store.CreateToken("ci", "secret-hash", false)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	got, err := store.LookupToken("secret-hash")
+	if err != nil || got == nil {
+		t.Fatalf("LookupToken: %v, %v", got, err)
+	}
+	if got.ID != created.ID || got.Name != "ci" || got.Admin {
+		t.Errorf("unexpected token: %+v", got)
+	}
+
+	if err := store.RevokeToken(created.ID); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	if got, _ := store.LookupToken("secret-hash"); got != nil {
+		t.Error("revoked token still resolves")
+	}
+	if err := store.RevokeToken(created.ID); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound revoking twice, got %v", err)
+	}
+
+	tokens, err := store.ListTokens()
+	if err != nil {
+		t.Fatalf("ListTokens: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].RevokedAt == nil {
+		t.Errorf("expected one revoked token, got %+v", tokens)
+	}
+}
+
+func TestTokenUsage(t *testing.T) {
+	store := newTestStore(t)
+	created, err := store.CreateToken("ci", "secret-hash", false)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	usage, err := store.TokenUsage(created.ID)
+	if err != nil || usage.Requests != 0 || usage.LastUsedAt != nil {
+		t.Fatalf("TokenUsage of an unused token = %+v, %v", usage, err)
+	}
+
+	later := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	earlier := later.Add(-time.Hour)
+	batches := [][]models.TokenUsage{
+		{{TokenID: created.ID, Requests: 3, BytesUploaded: 100, BytesDownloaded: 7, LastUsedAt: &later}},
+		// Usage flushed late does not move the last use back, and usage of
+		// tokens that do not exist is dropped.
+		{{TokenID: created.ID, Requests: 1, BytesDownloaded: 3, LastUsedAt: &earlier}, {TokenID: 99, Requests: 1, LastUsedAt: &later}},
+	}
+	for _, batch := range batches {
+		if err := store.AddTokenUsage(batch); err != nil {
+			t.Fatalf("AddTokenUsage: %v", err)
+		}
+	}
+
+	usage, err = store.TokenUsage(created.ID)
+	if err != nil {
+		t.Fatalf("TokenUsage: %v", err)
+	}
+	if usage.Requests != 4 || usage.BytesUploaded != 100 || usage.BytesDownloaded != 10 || usage.LastUsedAt == nil || !usage.LastUsedAt.Equal(later) {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+	if _, err := store.TokenUsage(99); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown token, got %v", err)
+	}
+}
+
+func TestAccounts(t *testing.T) {
+	store := newTestStore(t)
+
+	store.CreateUser("alice")
+	store.CreateUser("bob")
+	if _, err := store.CreateUser("alice"); !errors.Is(err, services.ErrConflict) {
+		t.Errorf("expected ErrConflict for a duplicate user, got %v", err)
+	}
+	if _, err := store.CreateTeam("platform", "sso-platform"); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+	for _, user := range []string{"bob", "alice", "alice"} {
+		if err := store.AddTeamMember("platform", user); err != nil {
+			t.Fatalf("AddTeamMember(%s): %v", user, err)
+		}
+	}
+	if err := store.AddTeamMember("platform", "nobody"); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound adding an unknown user, got %v", err)
+	}
+	team, err := store.GetTeam("platform")
+	if err != nil || team == nil || team.SSOGroup != "sso-platform" || !slices.Equal(team.Members, []string{"alice", "bob"}) {
+		t.Fatalf("GetTeam: %+v, %v", team, err)
+	}
+
+	// Packages can be claimed before they exist.
+	if err := store.AddPackageOwner("unpublished", "platform"); err != nil {
+		t.Fatalf("AddPackageOwner: %v", err)
+	}
+	owners, err := store.PackageOwners("unpublished")
+	if err != nil || len(owners) != 1 || owners[0].Name != "platform" || len(owners[0].Members) != 2 {
+		t.Fatalf("PackageOwners: %+v, %v", owners, err)
+	}
+	if owners, _ := store.PackageOwners("other"); len(owners) != 0 {
+		t.Errorf("expected no owners of an unclaimed package, got %+v", owners)
+	}
+
+	// Tokens act for their user until it is deleted.
+	token, _ := store.CreateToken("alice-ci", "alice-secret", false)
+	if err := store.SetTokenUser(token.ID, "alice"); err != nil {
+		t.Fatalf("SetTokenUser: %v", err)
+	}
+	if got, _ := store.LookupToken("alice-secret"); got == nil || got.User != "alice" {
+		t.Errorf("expected the token to act for alice, got %+v", got)
+	}
+	if err := store.DeleteUser("alice"); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if got, _ := store.LookupToken("alice-secret"); got != nil {
+		t.Error("token of a deleted user still resolves")
+	}
+	if team, _ := store.GetTeam("platform"); !slices.Equal(team.Members, []string{"bob"}) {
+		t.Errorf("expected alice to leave the team, got %v", team.Members)
+	}
+
+	if err := store.DeleteTeam("platform"); err != nil {
+		t.Fatalf("DeleteTeam: %v", err)
+	}
+	if owners, _ := store.PackageOwners("unpublished"); len(owners) != 0 {
+		t.Errorf("deleted team still owns the package: %+v", owners)
+	}
+	if err := store.RemovePackageOwner("unpublished", "platform"); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound removing a missing owner, got %v", err)
+	}
+}
+
+func TestComments(t *testing.T) {
+	store := newTestStore(t)
+	pkgID, _ := store.CreatePackage("app")
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 1})
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.1", Hash: "h2", Size: 1})
+
+	store.AddComment(models.Comment{Package: "app", Body: "use app2 for new work", Author: "ops"})
+	bad, err := store.AddComment(models.Comment{Package: "app", Version: "1.0.0", Body: "known bad, use 1.0.1", Author: "ci"})
+	if err != nil || bad.ID != 2 || bad.CreatedAt.IsZero() {
+		t.Fatalf("AddComment: %+v, %v", bad, err)
+	}
+	if _, err := store.AddComment(models.Comment{Package: "app", Version: "9.9.9", Body: "x"}); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing version, got %v", err)
+	}
+	if got, err := store.GetComment(2); err != nil || got == nil || got.Version != "1.0.0" || got.Author != "ci" {
+		t.Errorf("GetComment: %+v, %v", got, err)
+	}
+
+	// Comments on a version go with it; those on the package stay.
+	if err := store.DeleteArtifact("app", "1.0.0"); err != nil {
+		t.Fatalf("DeleteArtifact: %v", err)
+	}
+	comments, err := store.ListComments("app")
+	if err != nil || len(comments) != 1 || comments[0].Version != "" || comments[0].Body != "use app2 for new work" {
+		t.Errorf("ListComments after delete: %+v, %v", comments, err)
+	}
+	if err := store.DeleteComment(1); err != nil {
+		t.Fatalf("DeleteComment: %v", err)
+	}
+	if err := store.DeleteComment(1); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestApprovals(t *testing.T) {
+	store := newTestStore(t)
+
+	first, err := store.CreateApproval(models.Approval{Action: models.ApprovalActionDelete, Package: "app", Version: "1.0.0",
+		Reason: "app is protected", RequestedBy: "alice"})
+	if err != nil || first.ID != 1 || first.Status != models.ApprovalPending {
+		t.Fatalf("CreateApproval: %+v, %v", first, err)
+	}
+	store.CreateApproval(models.Approval{Action: models.ApprovalActionGC, Reason: "too much", RequestedBy: "bob"})
+
+	if err := store.DecideApproval(1, models.ApprovalApproved, "bob", "deleted"); err != nil {
+		t.Fatalf("DecideApproval: %v", err)
+	}
+	if err := store.DecideApproval(1, models.ApprovalRejected, "carol", ""); !errors.Is(err, services.ErrConflict) {
+		t.Errorf("expected ErrConflict deciding twice, got %v", err)
+	}
+	if err := store.DecideApproval(3, models.ApprovalRejected, "carol", ""); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown approval, got %v", err)
+	}
+
+	decided, err := store.GetApproval(1)
+	if err != nil || decided.Status != models.ApprovalApproved || decided.DecidedBy != "bob" ||
+		decided.DecidedAt == nil || decided.Result != "deleted" {
+		t.Fatalf("GetApproval: %+v, %v", decided, err)
+	}
+	if missing, err := store.GetApproval(3); missing != nil || err != nil {
+		t.Errorf("GetApproval of an unknown approval: %+v, %v", missing, err)
+	}
+	all, _ := store.ListApprovals("")
+	pending, _ := store.ListApprovals(models.ApprovalPending)
+	if len(all) != 2 || all[0].ID != 2 || len(pending) != 1 || pending[0].Action != models.ApprovalActionGC {
+		t.Errorf("ListApprovals: all %+v, pending %+v", all, pending)
+	}
+}
+
+func TestStats(t *testing.T) {
+	store := newTestStore(t)
+
+	pkgID, _ := store.CreatePackage("mylib")
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "hash1", Size: 100})
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "2.0.0", Hash: "hash1", Size: 100})
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "3.0.0", Hash: "hash2", Size: 50})
+	store.CreateToken("ci", "secret-hash", false)
+
+	st, err := store.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if st.Packages != 1 || st.Artifacts != 3 || st.ArtifactBytes != 250 {
+		t.Errorf("unexpected artifact totals: %+v", st)
+	}
+	if st.UniqueBlobs != 2 || st.UniqueBlobBytes != 150 {
+		t.Errorf("unexpected blob totals: %+v", st)
+	}
+	if st.ActiveTokens != 1 {
+		t.Errorf("expected 1 active token, got %d", st.ActiveTokens)
+	}
+}
+
+func TestArtifactFileInfoRoundTrip(t *testing.T) {
+	store := newTestStore(t)
+
+	pkgID, _ := store.CreatePackage("mylib")
+	_, err := store.CreateArtifact(pkgID, models.ArtifactInput{
+		Version: "1.0.0", Hash: "hash1", Size: 100, Filename: "mylib.tar.gz", ContentType: "application/gzip",
+	})
+	if err != nil {
+		t.Fatalf("CreateArtifact: %v", err)
+	}
+
+	got, err := store.GetArtifact("mylib", "1.0.0")
+	if err != nil {
+		t.Fatalf("GetArtifact: %v", err)
+	}
+	if got.Filename != "mylib.tar.gz" || got.ContentType != "application/gzip" {
+		t.Errorf("unexpected file info: %q %q", got.Filename, got.ContentType)
+	}
+}
+
+func TestArtifactUploadMetadataRoundTrip(t *testing.T) {
+	store := newTestStore(t)
+
+	labels := map[string]string{"team": "infra", "ci/build": "1234"}
+	deps := []models.Dependency{{Package: "libfoo", Constraint: "^1.2"}, {Package: "libbar", Constraint: "*"}}
+	created, err := store.CreateArtifactForPackage("mylib", models.ArtifactInput{
+		Version: "1.0.0", Hash: "hash1", Size: 100, Description: "A library", Labels: labels, Dependencies: deps,
+	})
+	if err != nil {
+		t.Fatalf("CreateArtifactForPackage: %v", err)
+	}
+	labels["team"] = "changed"
+	if created.Description != "A library" || created.Labels["team"] != "infra" || created.Revision != 1 {
+		t.Errorf("created = %+v", created)
+	}
+
+	got, err := store.GetArtifact("mylib", "1.0.0")
+	if err != nil || got == nil {
+		t.Fatalf("GetArtifact: %v, %v", got, err)
+	}
+	if got.Description != "A library" || len(got.Labels) != 2 || got.Labels["team"] != "infra" || got.Labels["ci/build"] != "1234" {
+		t.Errorf("got description %q, labels %v", got.Description, got.Labels)
+	}
+	gotDeps, err := store.ListDependencies("mylib", "1.0.0")
+	if err != nil || !slices.Equal(gotDeps, deps) {
+		t.Errorf("ListDependencies = %v, %v", gotDeps, err)
+	}
+
+	// A version without metadata has none, and a duplicate dependency
+	// leaves no version behind.
+	store.CreateArtifactForPackage("mylib", models.ArtifactInput{Version: "1.1.0", Hash: "hash2", Size: 100})
+	if got, _ := store.GetArtifact("mylib", "1.1.0"); got.Description != "" || got.Labels != nil {
+		t.Errorf("plain version = %+v", got)
+	}
+	_, err = store.CreateArtifactForPackage("mylib", models.ArtifactInput{
+		Version: "2.0.0", Hash: "hash3", Size: 100, Dependencies: []models.Dependency{{Package: "a", Constraint: "*"}, {Package: "a", Constraint: "*"}},
+	})
+	if !errors.Is(err, services.ErrConflict) {
+		t.Errorf("duplicate dependency: err = %v", err)
+	}
+	if got, _ := store.GetArtifact("mylib", "2.0.0"); got != nil {
+		t.Errorf("failed version was recorded: %+v", got)
+	}
+}
+
+func TestMigratesLegacyDatabase(t *testing.T) {
+	dir := t.TempDir()
+
+	// A database written before schema versioning: base tables only and
+	// user_version 0.
+	db, err := sql.Open("sqlite", dir+"/registry.db")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE packages (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE NOT NULL);
+		CREATE TABLE artifacts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT, package_id INTEGER NOT NULL, version TEXT NOT NULL,
+			hash TEXT NOT NULL, size INTEGER NOT NULL, uploaded_at DATETIME NOT NULL,
+			UNIQUE(package_id, version)
+		);
+		INSERT INTO packages (name) VALUES ('old');
+		INSERT INTO artifacts (package_id, version, hash, size, uploaded_at) VALUES (1, '1.0.0', 'h', 1, '2024-01-01 00:00:00');
+		INSERT INTO artifacts (package_id, version, hash, size, uploaded_at) VALUES
+			(1, 'v1.1.0-RC.1', 'h', 1, '2024-01-02 00:00:00'),
+			(1, '1.1.0-beta', 'h', 1, '2024-01-03 00:00:00'),
+			(1, '1.1.0-nightly.20240104', 'h', 1, '2024-01-04 00:00:00'),
+			(1, '1.1.0+build-5', 'h', 1, '2024-01-05 00:00:00'),
+			(1, '2024-01-06', 'h', 1, '2024-01-06 00:00:00');
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("creating legacy schema: %v", err)
+	}
+
+	store, err := NewSQLiteStore(dir)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer store.Close()
+
+	got, err := store.GetArtifact("old", "1.0.0")
+	if err != nil || got == nil {
+		t.Fatalf("GetArtifact after migration: %v, %v", got, err)
+	}
+	if got.Filename != "" {
+		t.Errorf("expected empty filename for legacy row, got %q", got.Filename)
+	}
+	// Earlier versions get the stability an upload would default to.
+	for _, v := range []string{"1.0.0", "v1.1.0-RC.1", "1.1.0-beta", "1.1.0-nightly.20240104", "1.1.0+build-5", "2024-01-06"} {
+		got, _ := store.GetArtifact("old", v)
+		if want := inputStability(models.ArtifactInput{Version: v}); got == nil || got.Stability != want {
+			t.Errorf("%s: stability after migration = %+v, want %s", v, got, want)
+		}
+	}
+	if refs, _ := store.ReferencedHashes(); !refs["h"] {
+		t.Errorf("expected the legacy blob to be counted: %v", refs)
+	}
+	if pkg, _ := store.GetPackage("old"); pkg == nil || pkg.TotalSize != 6 || pkg.UpdatedAt == nil ||
+		!pkg.UpdatedAt.Equal(time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("package aggregates after migration = %+v", pkg)
+	}
+
+	var version int
+	store.db.QueryRow("PRAGMA user_version").Scan(&version)
+	if version != len(migrations) {
+		t.Errorf("user_version = %d, want %d", version, len(migrations))
+	}
+}
+
+func TestAssets(t *testing.T) {
+	store := newTestStore(t)
+
+	pkgID, _ := store.CreatePackage("tool")
+	artifact, _ := store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "hash1", Size: 100})
+	if _, err := store.CreateAsset(artifact.ID, models.AssetInput{Name: "tool.zip", Hash: "hash2", Size: 50}); err != nil {
+		t.Fatalf("CreateAsset: %v", err)
+	}
+	if _, err := store.CreateAsset(artifact.ID, models.AssetInput{Name: "tool.zip", Hash: "hash3", Size: 1}); !errors.Is(err, services.ErrConflict) {
+		t.Errorf("expected ErrConflict for duplicate name, got %v", err)
+	}
+
+	got, err := store.GetAsset("tool", "1.0.0", "tool.zip")
+	if err != nil || got == nil || got.Hash != "hash2" {
+		t.Fatalf("GetAsset: %+v, %v", got, err)
+	}
+
+	refs, _ := store.ReferencedHashes()
+	if !refs["hash1"] || !refs["hash2"] {
+		t.Errorf("asset hashes should be referenced: %v", refs)
+	}
+
+	// Deleting the version removes its assets too.
+	if err := store.DeleteArtifact("tool", "1.0.0"); err != nil {
+		t.Fatalf("DeleteArtifact: %v", err)
+	}
+	if assets, _ := store.ListAssets("tool", "1.0.0"); len(assets) != 0 {
+		t.Errorf("expected no assets after delete, got %d", len(assets))
+	}
+	if refs, _ := store.ReferencedHashes(); len(refs) != 0 {
+		t.Errorf("expected no referenced hashes, got %v", refs)
+	}
+}
+
+func TestCrateVersions(t *testing.T) {
+	store := newTestStore(t)
+
+	pkgID, _ := store.CreatePackage("serde_lite")
+	for _, v := range []string{"0.1.0", "0.2.0"} {
+		artifact, _ := store.CreateArtifact(pkgID, models.ArtifactInput{Version: v, Hash: "hash" + v, Size: 10})
+		if err := store.CreateCrateVersion(artifact.ID, `{"vers":"`+v+`"}`); err != nil {
+			t.Fatalf("CreateCrateVersion: %v", err)
+		}
+		if err := store.CreateCrateVersion(artifact.ID, "{}"); !errors.Is(err, services.ErrConflict) {
+			t.Errorf("expected ErrConflict for duplicate entry, got %v", err)
+		}
+	}
+
+	if err := store.SetCrateYanked("serde_lite", "0.1.0", true); err != nil {
+		t.Fatalf("SetCrateYanked: %v", err)
+	}
+	if err := store.SetCrateYanked("serde_lite", "9.9.9", true); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	versions, err := store.ListCrateVersions("serde_lite")
+	if err != nil {
+		t.Fatalf("ListCrateVersions: %v", err)
+	}
+	if len(versions) != 2 || versions[0].Version != "0.1.0" || !versions[0].Yanked || versions[1].Yanked {
+		t.Errorf("unexpected versions: %+v", versions)
+	}
+
+	// Deleting the version removes its index entry too.
+	if err := store.DeleteArtifact("serde_lite", "0.1.0"); err != nil {
+		t.Fatalf("DeleteArtifact: %v", err)
+	}
+	versions, _ = store.ListCrateVersions("serde_lite")
+	if len(versions) != 1 || versions[0].Version != "0.2.0" {
+		t.Errorf("expected only 0.2.0 after delete, got %+v", versions)
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	store := newTestStore(t)
+
+	pkgID, _ := store.CreatePackage("app")
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "hash1", Size: 1})
+
+	deps := []models.Dependency{{Package: "zlib", Constraint: "^1"}, {Package: "abc", Constraint: "*"}}
+	if err := store.SetDependencies("app", "1.0.0", deps); err != nil {
+		t.Fatalf("SetDependencies: %v", err)
+	}
+	got, err := store.ListDependencies("app", "1.0.0")
+	if err != nil || len(got) != 2 || got[0] != deps[0] || got[1] != deps[1] {
+		t.Fatalf("ListDependencies: %+v, %v", got, err)
+	}
+
+	// Setting again replaces the manifest.
+	if err := store.SetDependencies("app", "1.0.0", deps[1:]); err != nil {
+		t.Fatalf("SetDependencies: %v", err)
+	}
+	if got, _ := store.ListDependencies("app", "1.0.0"); len(got) != 1 || got[0].Package != "abc" {
+		t.Errorf("expected manifest to be replaced, got %+v", got)
+	}
+
+	if err := store.SetDependencies("app", "1.0.0", []models.Dependency{deps[0], deps[0]}); !errors.Is(err, services.ErrConflict) {
+		t.Errorf("expected ErrConflict for duplicate package, got %v", err)
+	}
+	if got, _ := store.ListDependencies("app", "1.0.0"); len(got) != 1 {
+		t.Errorf("failed set should leave the manifest unchanged, got %+v", got)
+	}
+	if err := store.SetDependencies("app", "2.0.0", deps); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := store.DeleteArtifact("app", "1.0.0"); err != nil {
+		t.Fatalf("DeleteArtifact: %v", err)
+	}
+	if got, _ := store.ListDependencies("app", "1.0.0"); len(got) != 0 {
+		t.Errorf("expected dependencies deleted with the version, got %+v", got)
+	}
+}
+
+func TestListDependents(t *testing.T) {
+	store := newTestStore(t)
+
+	for _, name := range []string{"web", "api"} {
+		pkgID, _ := store.CreatePackage(name)
+		store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "h", Size: 1})
+		store.SetDependencies(name, "1.0.0", []models.Dependency{{Package: "core", Constraint: "^1"}})
+	}
+
+	first, err := store.ListDependents("core", nil, 1)
+	if err != nil || len(first) != 1 || first[0].Package != "api" {
+		t.Fatalf("first page: %+v, %v", first, err)
+	}
+	rest, err := store.ListDependents("core", &first[0], 10)
+	if err != nil || len(rest) != 1 || rest[0].Package != "web" || rest[0].Constraint != "^1" {
+		t.Fatalf("second page: %+v, %v", rest, err)
+	}
+}
+
+func TestSBOM(t *testing.T) {
+	store := newTestStore(t)
+
+	pkgID, _ := store.CreatePackage("app")
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "artifact", Size: 1})
+	if got, err := store.GetSBOM("app", "1.0.0"); err != nil || got != nil {
+		t.Fatalf("expected no SBOM, got %+v, %v", got, err)
+	}
+
+	components := []models.SBOMComponent{
+		{Name: "OpenSSL", Version: "3.0.1", PURL: "pkg:generic/openssl@3.0.1"},
+		{Name: "zlib", Version: "1.3"},
+	}
+	sbom, err := store.SetSBOM("app", "1.0.0", models.SBOM{Hash: "sbom1", Size: 10, Format: "cyclonedx", SpecVersion: "1.5"}, components)
+	if err != nil || sbom.Components != 2 {
+		t.Fatalf("SetSBOM: %+v, %v", sbom, err)
+	}
+	if _, err := store.SetSBOM("app", "2.0.0", models.SBOM{Hash: "x"}, nil); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	for _, tc := range []struct{ component, version string }{
+		{"openssl", ""},
+		{"OpenSSL", "3.0.1"},
+		{"pkg:generic/openssl", ""},
+		{"pkg:generic/openssl@3.0.1", ""},
+	} {
+		pkgs, err := store.PackagesWithComponent(tc.component, tc.version)
+		if err != nil || len(pkgs) != 1 || pkgs[0].Name != "app" {
+			t.Errorf("PackagesWithComponent(%q, %q): %+v, %v", tc.component, tc.version, pkgs, err)
+		}
+	}
+	if pkgs, _ := store.PackagesWithComponent("openssl", "1.1.1"); len(pkgs) != 0 {
+		t.Errorf("version mismatch should not match: %+v", pkgs)
+	}
+	if pkgs, _ := store.PackagesWithComponent("pkg:generic/open%", ""); len(pkgs) != 0 {
+		t.Errorf("LIKE wildcards should match literally: %+v", pkgs)
+	}
+
+	// Replacing the SBOM replaces its components and keeps only the new blob
+	// referenced.
+	store.SetSBOM("app", "1.0.0", models.SBOM{Hash: "sbom2", Size: 5, Format: "spdx", SpecVersion: "2.3"}, components[1:])
+	if pkgs, _ := store.PackagesWithComponent("openssl", ""); len(pkgs) != 0 {
+		t.Errorf("replaced components should not match: %+v", pkgs)
+	}
+	refs, _ := store.ReferencedHashes()
+	if !refs["sbom2"] || refs["sbom1"] || !refs["artifact"] {
+		t.Errorf("unexpected referenced hashes: %v", refs)
+	}
+	st, _ := store.Stats()
+	if st.UniqueBlobs != 2 || st.UniqueBlobBytes != 6 {
+		t.Errorf("stats should count the SBOM blob: %+v", st)
+	}
+
+	if err := store.DeleteArtifact("app", "1.0.0"); err != nil {
+		t.Fatalf("DeleteArtifact: %v", err)
+	}
+	if refs, _ := store.ReferencedHashes(); refs["sbom2"] {
+		t.Error("SBOM should be deleted with its version")
+	}
+}
+
+func TestScanReport(t *testing.T) {
+	store := newTestStore(t)
+
+	pkgID, _ := store.CreatePackage("app")
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "artifact", Size: 1})
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "2.0.0", Hash: "artifact", Size: 1})
+	if got, err := store.GetScanReport("app", "1.0.0"); err != nil || got != nil {
+		t.Fatalf("expected no scan report, got %+v, %v", got, err)
+	}
+	if a, _ := store.GetArtifact("app", "1.0.0"); a.Vulnerabilities != nil {
+		t.Errorf("unscanned artifact should have no summary: %+v", a.Vulnerabilities)
+	}
+
+	summary := models.VulnerabilitySummary{Critical: 1, High: 2, Low: 3}
+	report, err := store.SetScanReport("app", "1.0.0", models.ScanReport{Hash: "scan1", Size: 7, Scanner: "trivy", Summary: summary})
+	if err != nil || report.ScannedAt.IsZero() {
+		t.Fatalf("SetScanReport: %+v, %v", report, err)
+	}
+	if _, err := store.SetScanReport("app", "3.0.0", models.ScanReport{Hash: "x"}); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	got, err := store.GetScanReport("app", "1.0.0")
+	if err != nil || got.Scanner != "trivy" || got.Summary != summary || got.Hash != "scan1" {
+		t.Fatalf("GetScanReport: %+v, %v", got, err)
+	}
+	if a, _ := store.GetArtifact("app", "1.0.0"); a.Vulnerabilities == nil || *a.Vulnerabilities != summary {
+		t.Errorf("GetArtifact should carry the summary: %+v", a.Vulnerabilities)
+	}
+	artifacts, _ := store.ListArtifacts("app")
+	for _, a := range artifacts {
+		if scanned := a.Vulnerabilities != nil; scanned != (a.Version == "1.0.0") {
+			t.Errorf("%s: unexpected summary %+v", a.Version, a.Vulnerabilities)
+		}
+	}
+
+	// A rescan replaces the report and only the new blob stays referenced.
+	if _, err := store.SetScanReport("app", "1.0.0", models.ScanReport{Hash: "scan2", Size: 3, Scanner: "grype"}); err != nil {
+		t.Fatalf("rescan: %v", err)
+	}
+	if got, _ := store.GetScanReport("app", "1.0.0"); got.Scanner != "grype" || got.Summary != (models.VulnerabilitySummary{}) {
+		t.Errorf("expected the rescan to replace the report: %+v", got)
+	}
+	refs, _ := store.ReferencedHashes()
+	if !refs["scan2"] || refs["scan1"] {
+		t.Errorf("unexpected referenced hashes: %v", refs)
+	}
+
+	if err := store.DeleteArtifact("app", "1.0.0"); err != nil {
+		t.Fatalf("DeleteArtifact: %v", err)
+	}
+	if refs, _ := store.ReferencedHashes(); refs["scan2"] {
+		t.Error("scan report should be deleted with its version")
+	}
+}
+
+func TestAttestations(t *testing.T) {
+	store := newTestStore(t)
+
+	pkgID, _ := store.CreatePackage("app")
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "artifact", Size: 1})
+	if got, err := store.ListAttestations("app", "1.0.0"); err != nil || len(got) != 0 {
+		t.Fatalf("expected no attestations, got %+v, %v", got, err)
+	}
+
+	first, err := store.AddAttestation("app", "1.0.0", models.Attestation{Hash: "prov", Size: 5, Subject: "artifact",
+		PredicateType: "https://slsa.dev/provenance/v1", Builder: "https://ci.example/builder", Verified: true})
+	if err != nil || first.ID == 0 || first.UploadedAt.IsZero() {
+		t.Fatalf("AddAttestation: %+v, %v", first, err)
+	}
+	store.AddAttestation("app", "1.0.0", models.Attestation{Hash: "sbom", Size: 3, Subject: "artifact", PredicateType: "https://spdx.dev/Document"})
+	if _, err := store.AddAttestation("app", "2.0.0", models.Attestation{Hash: "x"}); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	got, err := store.ListAttestations("app", "1.0.0")
+	if err != nil || len(got) != 2 || got[0] != *first || got[1].PredicateType != "https://spdx.dev/Document" {
+		t.Fatalf("ListAttestations: %+v, %v", got, err)
+	}
+	if a, _ := store.GetArtifact("app", "1.0.0"); a.Revision != 3 {
+		t.Errorf("revision = %d, want 3 after two attestations", a.Revision)
+	}
+	refs, _ := store.ReferencedHashes()
+	if !refs["prov"] || !refs["sbom"] {
+		t.Errorf("attestation blobs should be referenced: %v", refs)
+	}
+
+	if err := store.DeleteArtifact("app", "1.0.0"); err != nil {
+		t.Fatalf("DeleteArtifact: %v", err)
+	}
+	refs, _ = store.ReferencedHashes()
+	if refs["prov"] || refs["sbom"] {
+		t.Errorf("deleting the version should release its attestations: %v", refs)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	store := newTestStore(t)
+
+	pkgID, _ := store.CreatePackage("lib")
+	a, err := store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 1, Quarantined: true})
+	if err != nil || !a.Quarantined {
+		t.Fatalf("CreateArtifact: %+v, %v", a, err)
+	}
+	store.CreateCrateVersion(a.ID, `{"name":"lib","vers":"1.0.0"}`)
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "2.0.0", Hash: "h2", Size: 1})
+
+	if got, _ := store.GetArtifact("lib", "1.0.0"); !got.Quarantined {
+		t.Error("GetArtifact should report quarantine")
+	}
+	if versions, _ := store.ListCrateVersions("lib"); len(versions) != 1 || !versions[0].Quarantined {
+		t.Errorf("ListCrateVersions should report quarantine: %+v", versions)
+	}
+	pending, err := store.ListQuarantined()
+	if err != nil || len(pending) != 1 || pending[0].Version != "1.0.0" || pending[0].Package != "lib" {
+		t.Fatalf("ListQuarantined: %+v, %v", pending, err)
+	}
+
+	if err := store.SetQuarantined("lib", "1.0.0", false); err != nil {
+		t.Fatalf("SetQuarantined: %v", err)
+	}
+	if pending, _ := store.ListQuarantined(); len(pending) != 0 {
+		t.Errorf("expected nothing pending after approval, got %+v", pending)
+	}
+	if err := store.SetQuarantined("lib", "3.0.0", true); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSetStageRecordsPromotion(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 8, 30, 0, 0, time.UTC))
+	store, err := NewSQLiteStore(t.TempDir(), WithClock(fake))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	pkgID, _ := store.CreatePackage("lib")
+	a, err := store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 1, Stage: models.StageStaging})
+	if err != nil || a.Stage != models.StageStaging {
+		t.Fatalf("CreateArtifact: %+v, %v", a, err)
+	}
+	c, _ := store.CreateArtifact(pkgID, models.ArtifactInput{Version: "2.0.0", Hash: "h2", Size: 1})
+	if c.Stage != models.StageRelease {
+		t.Errorf("stage should default to release, got %q", c.Stage)
+	}
+	store.CreateCrateVersion(a.ID, `{"name":"lib","vers":"1.0.0"}`)
+	if versions, _ := store.ListCrateVersions("lib"); len(versions) != 1 || versions[0].Stage != models.StageStaging {
+		t.Errorf("ListCrateVersions should report the stage: %+v", versions)
+	}
+	if got, _ := store.GetArtifact("lib", "1.0.0"); got.Stage != models.StageStaging || got.PromotedAt != nil || got.PromotedBy != "" {
+		t.Errorf("unpromoted version: %+v", got)
+	}
+
+	fake.Advance(time.Hour)
+	if err := store.SetStage("lib", "1.0.0", models.StageRelease, "release-manager"); err != nil {
+		t.Fatalf("SetStage: %v", err)
+	}
+	got, _ := store.GetArtifact("lib", "1.0.0")
+	if got.Stage != models.StageRelease || got.PromotedBy != "release-manager" {
+		t.Errorf("promoted version: %+v", got)
+	}
+	if got.PromotedAt == nil || !got.PromotedAt.Equal(fake.Now()) {
+		t.Errorf("promoted_at = %v, want %v", got.PromotedAt, fake.Now())
+	}
+	if err := store.SetStage("lib", "3.0.0", models.StageRelease, "x"); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestListExpired(t *testing.T) {
+	store := newTestStore(t)
+
+	base := time.Date(2024, 6, 1, 8, 30, 0, 0, time.UTC)
+	soon := base.Add(1500 * time.Millisecond)
+	later := base.Add(48 * time.Hour)
+	pkgID, _ := store.CreatePackage("pr-build")
+	a, err := store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0-pr.1", Hash: "h1", Size: 1, ExpiresAt: &soon})
+	if err != nil || a.ExpiresAt == nil || !a.ExpiresAt.Equal(soon) {
+		t.Fatalf("CreateArtifact: %+v, %v", a, err)
+	}
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0-pr.2", Hash: "h2", Size: 1, ExpiresAt: &later})
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "h3", Size: 1})
+
+	if got, _ := store.GetArtifact("pr-build", "1.0.0-pr.1"); got.ExpiresAt == nil || !got.ExpiresAt.Equal(soon) {
+		t.Errorf("GetArtifact expires_at = %v, want %v", got.ExpiresAt, soon)
+	}
+	if got, _ := store.GetArtifact("pr-build", "1.0.0"); got.ExpiresAt != nil {
+		t.Errorf("version without expiry reports %v", got.ExpiresAt)
+	}
+
+	for _, tc := range []struct {
+		now  time.Time
+		want []string
+	}{
+		{base.Add(time.Second), nil},
+		{soon, []string{"1.0.0-pr.1"}},
+		{later.Add(time.Minute), []string{"1.0.0-pr.1", "1.0.0-pr.2"}},
+	} {
+		expired, err := store.ListExpired(tc.now)
+		if err != nil {
+			t.Fatalf("ListExpired: %v", err)
+		}
+		var got []string
+		for _, a := range expired {
+			got = append(got, a.Version)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("ListExpired(%v) = %v, want %v", tc.now, got, tc.want)
+		}
+	}
+}
+
+func TestFindArtifacts(t *testing.T) {
+	base := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(base)
+	store, err := NewSQLiteStore(t.TempDir(), WithClock(fake))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer store.Close()
+
+	app, _ := store.CreatePackage("app")
+	lib, _ := store.CreatePackage("lib")
+	for _, v := range []struct {
+		pkg     int64
+		version string
+		size    int64
+	}{
+		{app, "1.0.0", 10},
+		{lib, "1.0.0", 500},
+		{app, "2.0.0", 1000},
+		{lib, "2.0.0", 0},
+	} {
+		a, err := store.CreateArtifact(v.pkg, models.ArtifactInput{Version: v.version, Hash: "h-" + v.version, Size: v.size})
+		if err != nil {
+			t.Fatalf("CreateArtifact: %v", err)
+		}
+		if v.pkg == lib && v.version == "1.0.0" {
+			store.CreateAsset(a.ID, models.AssetInput{Name: "lib.zip", Hash: "asset-hash", Size: 5})
+		}
+		fake.Advance(24 * time.Hour)
+	}
+
+	size := func(n int64) *int64 { return &n }
+	for _, tc := range []struct {
+		name   string
+		filter models.ArtifactFilter
+		want   string
+	}{
+		{"all", models.ArtifactFilter{}, "lib@2.0.0 app@2.0.0 lib@1.0.0 app@1.0.0"},
+		{"package", models.ArtifactFilter{Package: "app"}, "app@2.0.0 app@1.0.0"},
+		{"after", models.ArtifactFilter{UploadedAfter: base.Add(24 * time.Hour)}, "lib@2.0.0 app@2.0.0 lib@1.0.0"},
+		{"range", models.ArtifactFilter{UploadedAfter: base.Add(24 * time.Hour), UploadedBefore: base.Add(72 * time.Hour)}, "app@2.0.0 lib@1.0.0"},
+		{"min size", models.ArtifactFilter{MinSize: size(500)}, "app@2.0.0 lib@1.0.0"},
+		{"max size zero", models.ArtifactFilter{MaxSize: size(0)}, "lib@2.0.0"},
+		{"hash", models.ArtifactFilter{Hash: "h-2.0.0"}, "lib@2.0.0 app@2.0.0"},
+		{"asset hash", models.ArtifactFilter{Hash: "asset-hash"}, "lib@1.0.0"},
+		{"combined", models.ArtifactFilter{Package: "lib", MinSize: size(1), MaxSize: size(1000)}, "lib@1.0.0"},
+		{"limit", models.ArtifactFilter{Limit: 2}, "lib@2.0.0 app@2.0.0"},
+	} {
+		found, err := store.FindArtifacts(tc.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var got []string
+		for _, a := range found {
+			got = append(got, a.Package+"@"+a.Version)
+		}
+		if fmt.Sprint(got) != "["+tc.want+"]" {
+			t.Errorf("%s: got %v, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	page, _ := store.FindArtifacts(models.ArtifactFilter{Limit: 2})
+	rest, _ := store.FindArtifacts(models.ArtifactFilter{BeforeID: page[1].ID})
+	if len(rest) != 2 || rest[0].Package != "lib" || rest[1].Package != "app" {
+		t.Errorf("second page = %+v", rest)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	store := newTestStore(t)
+
+	for _, e := range []models.HistoryEvent{
+		{Package: "app", Version: "1.0.0", Action: models.HistoryCreate, NewHash: "h1", Actor: "ci"},
+		{Package: "app", Version: "1.0.0", File: "app.zip", Action: models.HistoryCreate, NewHash: "z1"},
+		{Package: "app", Version: "1.0.0", Action: models.HistoryDelete, OldHash: "h1", Actor: "ops"},
+		{Package: "app", Version: "1.0.0", Action: models.HistoryCreate, NewHash: "h2"},
+		{Package: "app", Version: "1.0.0", Action: models.HistoryDelete, OldHash: "h2"},
+		{Package: "app", Version: "1.0.0", Action: models.HistoryCreate, NewHash: "h2"},
+		{Package: "app", Version: "2.0.0", Action: models.HistoryCreate, NewHash: "h3"},
+		{Package: "app", Version: "2.0.1", Action: models.HistoryCopy, NewHash: "h3", Source: "app@2.0.0"},
+		{Package: "other", Version: "1.0.0", Action: models.HistoryCreate, NewHash: "h4"},
+	} {
+		if err := store.RecordHistory(e); err != nil {
+			t.Fatalf("RecordHistory: %v", err)
+		}
+	}
+
+	events, err := store.ListHistory("app", "1.0.0", 0, 100)
+	if err != nil {
+		t.Fatalf("ListHistory: %v", err)
+	}
+	var got []string
+	for _, e := range events {
+		got = append(got, e.File+":"+e.Action+":"+e.OldHash+">"+e.NewHash)
+	}
+	// Re-creating with new content is an overwrite; with the same content,
+	// a plain create.
+	want := []string{":create:>h2", ":delete:h2>", ":overwrite:h1>h2", ":delete:h1>", "app.zip:create:>z1", ":create:>h1"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("history = %v, want %v", got, want)
+	}
+	if events[3].Actor != "ops" || events[3].At.IsZero() {
+		t.Errorf("delete event = %+v", events[3])
+	}
+
+	all, _ := store.ListHistory("app", "", 0, 100)
+	if copied := all[0]; copied.Action != models.HistoryCopy || copied.Source != "app@2.0.0" || copied.NewHash != "h3" {
+		t.Errorf("copy event = %+v", copied)
+	}
+	page, _ := store.ListHistory("app", "", all[1].ID, 100)
+	if len(all) != 8 || len(page) != 6 || page[0].ID != all[2].ID {
+		t.Errorf("paging: %d events, %d after the second", len(all), len(page))
+	}
+	if none, _ := store.ListHistory("missing", "", 0, 100); len(none) != 0 {
+		t.Errorf("unknown package has history %+v", none)
+	}
+}
+
+func TestPinnedVersionsResistDeletion(t *testing.T) {
+	store := newTestStore(t)
+
+	expired := time.Now().Add(-time.Hour)
+	pkgID, _ := store.CreatePackage("app")
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 1, ExpiresAt: &expired})
+
+	if err := store.SetPinned("app", "1.0.0", true); err != nil {
+		t.Fatalf("SetPinned: %v", err)
+	}
+	if got, _ := store.GetArtifact("app", "1.0.0"); !got.Pinned {
+		t.Error("GetArtifact should report the pin")
+	}
+	if list, _ := store.ListExpired(time.Now()); len(list) != 0 {
+		t.Errorf("pinned versions should not be listed as expired: %+v", list)
+	}
+	if err := store.DeleteArtifact("app", "1.0.0"); !errors.Is(err, services.ErrConflict) {
+		t.Fatalf("expected ErrConflict deleting a pinned version, got %v", err)
+	}
+
+	store.SetPinned("app", "1.0.0", false)
+	if err := store.DeleteArtifact("app", "1.0.0"); err != nil {
+		t.Fatalf("DeleteArtifact after unpinning: %v", err)
+	}
+	if err := store.SetPinned("app", "1.0.0", true); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDeprecation(t *testing.T) {
+	store := newTestStore(t)
+	pkgID, _ := store.CreatePackage("app")
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 1})
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "2.0.0", Hash: "h2", Size: 1})
+
+	want := &models.Deprecation{Message: "security fix in 2.0.0", InFavorOf: "2.0.0"}
+	if err := store.SetDeprecation("app", "1.0.0", want); err != nil {
+		t.Fatalf("SetDeprecation: %v", err)
+	}
+	if got, _ := store.GetArtifact("app", "1.0.0"); got.Deprecated == nil || *got.Deprecated != *want {
+		t.Errorf("GetArtifact deprecation = %+v, want %+v", got.Deprecated, want)
+	}
+	if got, _ := store.GetArtifact("app", "2.0.0"); got.Deprecated != nil {
+		t.Errorf("2.0.0 should not be deprecated: %+v", got.Deprecated)
+	}
+	store.SetDeprecation("app", "1.0.0", nil)
+	if got, _ := store.GetArtifact("app", "1.0.0"); got.Deprecated != nil {
+		t.Errorf("cleared deprecation still set: %+v", got.Deprecated)
+	}
+	if err := store.SetDeprecation("app", "9.9.9", want); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing version, got %v", err)
+	}
+
+	pkgWant := &models.Deprecation{Message: "renamed", InFavorOf: "app2"}
+	if err := store.SetPackageDeprecation("app", pkgWant); err != nil {
+		t.Fatalf("SetPackageDeprecation: %v", err)
+	}
+	if got, _ := store.GetPackage("app"); got.Deprecated == nil || *got.Deprecated != *pkgWant {
+		t.Errorf("GetPackage deprecation = %+v, want %+v", got.Deprecated, pkgWant)
+	}
+	if list, _ := store.ListPackages(); len(list) != 1 || list[0].Deprecated == nil {
+		t.Errorf("ListPackages should report the deprecation: %+v", list)
+	}
+	if got, _ := store.GetArtifact("app", "2.0.0"); got.Deprecated != nil {
+		t.Errorf("a package deprecation should not be copied onto its versions: %+v", got.Deprecated)
+	}
+	store.SetPackageDeprecation("app", nil)
+	if got, _ := store.GetPackage("app"); got.Deprecated != nil {
+		t.Errorf("cleared package deprecation still set: %+v", got.Deprecated)
+	}
+	if err := store.SetPackageDeprecation("missing", pkgWant); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing package, got %v", err)
+	}
+}
+
+func TestReleaseNotes(t *testing.T) {
+	store := newTestStore(t)
+	pkgID, _ := store.CreatePackage("app")
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 1})
+
+	notes := "## 1.0.0\n\n- First release\n"
+	if err := store.SetReleaseNotes("app", "1.0.0", notes); err != nil {
+		t.Fatalf("SetReleaseNotes: %v", err)
+	}
+	if got, _ := store.GetArtifact("app", "1.0.0"); got.ReleaseNotes != notes {
+		t.Errorf("GetArtifact notes = %q, want %q", got.ReleaseNotes, notes)
+	}
+	if list, _ := store.ListArtifacts("app"); len(list) != 1 || list[0].ReleaseNotes != notes {
+		t.Errorf("ListArtifacts should carry the notes: %+v", list)
+	}
+	store.SetReleaseNotes("app", "1.0.0", "")
+	if got, _ := store.GetArtifact("app", "1.0.0"); got.ReleaseNotes != "" {
+		t.Errorf("cleared notes still set: %q", got.ReleaseNotes)
+	}
+	if err := store.SetReleaseNotes("app", "9.9.9", notes); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing version, got %v", err)
+	}
+}
+
+func TestContentsAreKeptPerReferencedBlob(t *testing.T) {
+	store := newTestStore(t)
+	pkgID, _ := store.CreatePackage("tool")
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 10})
+
+	if c, err := store.GetContents("h1"); err != nil || c != nil {
+		t.Fatalf("unindexed blob: %+v, %v", c, err)
+	}
+	indexedAt := time.Date(2024, 6, 1, 8, 30, 0, 0, time.UTC)
+	for _, c := range []models.Contents{
+		{Hash: "h1", Format: "tar.gz", Entries: []models.ContentEntry{{Path: "old", Size: 1, Mode: "-rw-r--r--"}}, IndexedAt: indexedAt},
+		{Hash: "h1", Format: "tar.gz", Truncated: true, IndexedAt: indexedAt, Entries: []models.ContentEntry{
+			{Path: "bin/", Mode: "drwxr-xr-x"},
+			{Path: "bin/tool", Size: 4, Mode: "-rwxr-xr-x"},
+		}},
+		{Hash: "orphan", Format: "", IndexedAt: indexedAt},
+	} {
+		if err := store.SetContents(c); err != nil {
+			t.Fatalf("SetContents: %v", err)
+		}
+	}
+
+	c, err := store.GetContents("h1")
+	if err != nil || c == nil {
+		t.Fatalf("GetContents: %+v, %v", c, err)
+	}
+	if c.Format != "tar.gz" || !c.Truncated || !c.IndexedAt.Equal(indexedAt) || len(c.Entries) != 2 || c.Entries[1].Path != "bin/tool" || c.Entries[1].Mode != "-rwxr-xr-x" {
+		t.Errorf("replaced listing = %+v", c)
+	}
+
+	if err := store.PruneContents(); err != nil {
+		t.Fatalf("PruneContents: %v", err)
+	}
+	if c, _ := store.GetContents("orphan"); c != nil {
+		t.Error("listing of an unreferenced blob survived pruning")
+	}
+	if c, _ := store.GetContents("h1"); c == nil || len(c.Entries) != 2 {
+		t.Errorf("referenced listing was pruned: %+v", c)
+	}
+}
+
+func TestArtifactLicenses(t *testing.T) {
+	store := newTestStore(t)
+	pkgID, _ := store.CreatePackage("tool")
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 10})
+	store.CreateArtifact(pkgID, models.ArtifactInput{Version: "2.0.0", Hash: "h2", Size: 10})
+	store.SetContents(models.Contents{Hash: "h1", Format: "tar.gz", IndexedAt: time.Now(), Licenses: []models.DetectedLicense{
+		{License: "MIT", Path: "tool/package.json"},
+		{License: "MIT", Path: "tool/LICENSE"},
+		{License: "Apache-2.0", Path: "tool/LICENSE"},
+	}})
+	store.SetContents(models.Contents{Hash: "h2", Format: "tar.gz", IndexedAt: time.Now(), Licenses: []models.DetectedLicense{
+		{License: "GPL-3.0", Path: "tool/COPYING"},
+	}})
+
+	a, _ := store.GetArtifact("tool", "1.0.0")
+	if a == nil || !slices.Equal(a.Licenses, []string{"Apache-2.0", "MIT"}) {
+		t.Errorf("licenses of 1.0.0 = %+v", a)
+	}
+	c, _ := store.GetContents("h1")
+	if c == nil || len(c.Licenses) != 3 || c.Licenses[0] != (models.DetectedLicense{License: "Apache-2.0", Path: "tool/LICENSE"}) {
+		t.Errorf("contents licenses = %+v", c)
+	}
+
+	found, err := store.FindArtifacts(models.ArtifactFilter{License: "gpl-3.0"})
+	if err != nil || len(found) != 1 || found[0].Version != "2.0.0" {
+		t.Errorf("FindArtifacts(gpl-3.0) = %+v, %v", found, err)
+	}
+	if found, _ := store.FindArtifacts(models.ArtifactFilter{License: "BSD-3-Clause"}); len(found) != 0 {
+		t.Errorf("FindArtifacts(BSD-3-Clause) = %+v, want none", found)
+	}
+}
+
+func TestArtifactRevision(t *testing.T) {
+	store := newTestStore(t)
+
+	pkgID, _ := store.CreatePackage("app")
+	created, err := store.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 1, Quarantined: true})
+	if err != nil {
+		t.Fatalf("CreateArtifact: %v", err)
+	}
+	if created.Revision != 1 {
+		t.Errorf("expected revision 1 on create, got %d", created.Revision)
+	}
+
+	revision := func() int64 {
+		t.Helper()
+		a, err := store.GetArtifact("app", "1.0.0")
+		if err != nil || a == nil {
+			t.Fatalf("GetArtifact: %v", err)
+		}
+		return a.Revision
+	}
+	steps := []struct {
+		name string
+		do   func() error
+	}{
+		{"SetQuarantined", func() error { return store.SetQuarantined("app", "1.0.0", false) }},
+		{"SetStage", func() error { return store.SetStage("app", "1.0.0", models.StageStaging, "ops") }},
+		{"SetPinned", func() error { return store.SetPinned("app", "1.0.0", true) }},
+		{"SetDeprecation", func() error { return store.SetDeprecation("app", "1.0.0", &models.Deprecation{Message: "old"}) }},
+		{"SetReleaseNotes", func() error { return store.SetReleaseNotes("app", "1.0.0", "- fixed a crash") }},
+		{"SetDependencies", func() error {
+			return store.SetDependencies("app", "1.0.0", []models.Dependency{{Package: "lib", Constraint: "^1.0.0"}})
+		}},
+		{"SetSBOM", func() error {
+			_, err := store.SetSBOM("app", "1.0.0", models.SBOM{Hash: "s1", Format: "cyclonedx"}, nil)
+			return err
+		}},
+		{"SetScanReport", func() error {
+			_, err := store.SetScanReport("app", "1.0.0", models.ScanReport{Hash: "r1", Scanner: "trivy"})
+			return err
+		}},
+		{"CreateAsset", func() error {
+			_, err := store.CreateAsset(created.ID, models.AssetInput{Name: "notes.txt", Hash: "n1", Size: 1})
+			return err
+		}},
+		{"DeleteAsset", func() error { return store.DeleteAsset("app", "1.0.0", "notes.txt") }},
+	}
+	want := int64(1)
+	for _, step := range steps {
+		if err := step.do(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		want++
+		if got := revision(); got != want {
+			t.Errorf("after %s: expected revision %d, got %d", step.name, want, got)
+		}
+	}
+}
+
+func TestGetArtifactCache(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.CreateArtifactForPackage("lib", models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 1}); err != nil {
+		t.Fatalf("CreateArtifactForPackage: %v", err)
+	}
+
+	a, _ := store.GetArtifact("lib", "1.0.0")
+	a.Stage = "mutated"
+	a, _ = store.GetArtifact("lib", "1.0.0")
+	if a.Stage != models.StageRelease {
+		t.Errorf("changing a result changed the cache: stage %q", a.Stage)
+	}
+
+	// Downloads leave the cache alone.
+	writes := store.db.writes.Load()
+	store.RecordDownload("lib", "1.0.0", "ci", 1, 1)
+	store.TouchBlob("h1")
+	if store.db.writes.Load() != writes {
+		t.Error("recording a download invalidated the cache")
+	}
+
+	if err := store.SetStage("lib", "1.0.0", models.StageStaging, ""); err != nil {
+		t.Fatalf("SetStage: %v", err)
+	}
+	if a, _ := store.GetArtifact("lib", "1.0.0"); a.Stage != models.StageStaging {
+		t.Errorf("stage after SetStage = %q, want staging", a.Stage)
+	}
+	if err := store.SetMalwareScan(models.MalwareScan{Hash: "h1", Result: models.MalwareClean, ScannedAt: time.Now()}); err != nil {
+		t.Fatalf("SetMalwareScan: %v", err)
+	}
+	if a, _ := store.GetArtifact("lib", "1.0.0"); a.Malware != models.MalwareClean {
+		t.Errorf("malware after scan = %q", a.Malware)
+	}
+
+	// Misses are cached too, until the version is created.
+	if a, _ := store.GetArtifact("lib", "2.0.0"); a != nil {
+		t.Fatalf("expected no 2.0.0, got %+v", a)
+	}
+	store.CreateArtifactForPackage("lib", models.ArtifactInput{Version: "2.0.0", Hash: "h2", Size: 2})
+	if a, _ := store.GetArtifact("lib", "2.0.0"); a == nil {
+		t.Error("expected 2.0.0 after creating it")
+	}
+	if err := store.DeleteArtifact("lib", "2.0.0"); err != nil {
+		t.Fatalf("DeleteArtifact: %v", err)
+	}
+	if a, _ := store.GetArtifact("lib", "2.0.0"); a != nil {
+		t.Errorf("expected no 2.0.0 after deleting it, got %+v", a)
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	store := newTestStore(t)
+	store.CreateArtifactForPackage("lib", models.ArtifactInput{Version: "1.0.0", Hash: "h1", Size: 1})
+	store.SetStage("lib", "1.0.0", models.StageStaging, "")
+	store.GetArtifact("lib", "1.0.0")
+	store.GetArtifact("lib", "2.0.0")
+	store.LookupToken("missing")
+	store.SetStage("lib", "2.0.0", models.StageStaging, "")
+
+	stats := make(map[string]models.QueryStat)
+	for _, st := range store.QueryStats() {
+		stats[st.Label] = st
+	}
+	for label, calls := range map[string]int64{
+		"GetArtifact":                   2,
+		"LookupToken":                   1,
+		"CreateArtifactForPackage (tx)": 1,
+	} {
+		if st := stats[label]; st.Calls != calls || st.Errors != 0 {
+			t.Errorf("%s: %+v, want %d calls", label, st, calls)
+		}
+	}
+	if st := stats["SetStage"]; st.Calls == 0 || st.MeanMicros != st.TotalMicros/st.Calls || st.MaxMicros > st.TotalMicros {
+		t.Errorf("SetStage: %+v", st)
+	}
+}
+
+// newTestSnapshot creates a registry database with versions of app and
+// reopens it as a read-only snapshot.
+func newTestSnapshot(t *testing.T, versions ...string) *SQLiteStore {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := NewSQLiteStore(dir)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	for _, v := range versions {
+		if _, err := store.CreateArtifactForPackage("app", models.ArtifactInput{Version: v, Hash: "old-" + v, Size: 10}); err != nil {
+			t.Fatalf("CreateArtifactForPackage: %v", err)
+		}
+	}
+	store.Close()
+
+	snap, err := OpenSnapshot(dir)
+	if err != nil {
+		t.Fatalf("OpenSnapshot: %v", err)
+	}
+	t.Cleanup(func() { snap.Close() })
+	return snap
+}
+
+func TestOpenSnapshot(t *testing.T) {
+	snap := newTestSnapshot(t, "1.0.0")
+	if a, err := snap.GetArtifact("app", "1.0.0"); err != nil || a == nil {
+		t.Fatalf("GetArtifact: %v, %v", a, err)
+	}
+	if _, err := snap.CreatePackage("new"); err == nil {
+		t.Error("a snapshot should refuse writes")
+	}
+
+	if _, err := OpenSnapshot(t.TempDir()); err == nil {
+		t.Error("opening a directory without a database should fail")
+	}
+}
+
+func TestLayeredStore(t *testing.T) {
+	live := newTestStore(t)
+	if _, err := live.CreateArtifactForPackage("app", models.ArtifactInput{Version: "2.0.0", Hash: "new-2.0.0", Size: 20}); err != nil {
+		t.Fatalf("CreateArtifactForPackage: %v", err)
+	}
+	older := newTestSnapshot(t, "1.0.0", "2.0.0")
+	oldest := newTestSnapshot(t, "0.9.0", "1.0.0")
+	store := NewLayeredStore(live, Snapshot{Name: "2021", Store: older}, Snapshot{Name: "2019", Store: oldest})
+
+	for _, tc := range []struct {
+		version, hash, snapshot string
+	}{
+		{"2.0.0", "new-2.0.0", ""},
+		{"1.0.0", "old-1.0.0", "2021"},
+		{"0.9.0", "old-0.9.0", "2019"},
+	} {
+		a, err := store.GetArtifact("app", tc.version)
+		if err != nil || a == nil || a.Hash != tc.hash || a.Snapshot != tc.snapshot {
+			t.Errorf("GetArtifact(%s) = %+v, %v; want hash %s from %q", tc.version, a, err, tc.hash, tc.snapshot)
+		}
+	}
+	if a, err := store.GetArtifact("app", "3.0.0"); err != nil || a != nil {
+		t.Errorf("missing version: %+v, %v", a, err)
+	}
+
+	versions, err := store.ListArtifacts("app")
+	if err != nil {
+		t.Fatalf("ListArtifacts: %v", err)
+	}
+	var got []string
+	for _, a := range versions {
+		got = append(got, a.Version+"@"+cmp.Or(a.Snapshot, "live"))
+	}
+	slices.Sort(got)
+	if want := []string{"0.9.0@2019", "1.0.0@2021", "2.0.0@live"}; !slices.Equal(got, want) {
+		t.Errorf("ListArtifacts = %v, want %v", got, want)
+	}
+
+	found, err := store.FindArtifacts(models.ArtifactFilter{Package: "app", MinSize: new(int64)})
+	if err != nil || len(found) != 3 {
+		t.Errorf("FindArtifacts = %+v, %v", found, err)
+	}
+	if paged, _ := store.FindArtifacts(models.ArtifactFilter{Limit: 10}); len(paged) != 1 || paged[0].Snapshot != "" {
+		t.Errorf("paged FindArtifacts should cover the live store only: %+v", paged)
+	}
+
+	if _, err := older.CreatePackage("archived"); err == nil {
+		t.Fatal("snapshot accepted a write")
+	}
+	pkgs, err := store.ListPackages()
+	if err != nil || len(pkgs) != 1 || pkgs[0].Name != "app" || pkgs[0].ID != 1 {
+		t.Errorf("ListPackages = %+v, %v", pkgs, err)
+	}
+
+	if err := store.SetPinned("app", "1.0.0", true); !errors.Is(err, services.ErrReadOnly) {
+		t.Errorf("pinning a snapshot version: %v", err)
+	}
+	if err := store.DeleteArtifact("app", "0.9.0"); !errors.Is(err, services.ErrReadOnly) {
+		t.Errorf("deleting a snapshot version: %v", err)
+	}
+	if _, err := store.CreateArtifactForPackage("app", models.ArtifactInput{Version: "1.0.0", Hash: "h", Size: 1}); !errors.Is(err, services.ErrReadOnly) {
+		t.Errorf("recreating a snapshot version: %v", err)
+	}
+	if err := store.SetPinned("app", "2.0.0", true); err != nil {
+		t.Errorf("pinning a live version: %v", err)
+	}
+	if err := store.DeleteArtifact("app", "3.0.0"); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("deleting a missing version: %v", err)
+	}
+}
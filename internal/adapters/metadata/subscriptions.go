@@ -0,0 +1,63 @@
+package metadata
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// SQLiteStore also implements services.SubscriptionStore. Events are
+// stored comma-separated.
+
+func (s *SQLiteStore) CreateSubscription(sub models.Subscription) (*models.Subscription, error) {
+	sub.CreatedAt = s.clock.Now().UTC()
+	result, err := s.db.Exec(`
+		INSERT INTO subscriptions (package, events, channel, target, secret, created_by, admin, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, sub.Package, strings.Join(sub.Events, ","), sub.Channel, sub.Target, sub.Secret, sub.CreatedBy, sub.Admin, sub.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("creating subscription: %w", err)
+	}
+	sub.ID, _ = result.LastInsertId()
+	return &sub, nil
+}
+
+func (s *SQLiteStore) ListSubscriptions() ([]models.Subscription, error) {
+	rows, err := s.db.Query(`
+		SELECT id, package, events, channel, target, secret, created_by, admin, created_at
+		FROM subscriptions ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("listing subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []models.Subscription
+	for rows.Next() {
+		var sub models.Subscription
+		var events string
+		if err := rows.Scan(&sub.ID, &sub.Package, &events, &sub.Channel, &sub.Target, &sub.Secret, &sub.CreatedBy, &sub.Admin, &sub.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning subscription: %w", err)
+		}
+		if events != "" {
+			sub.Events = strings.Split(events, ",")
+		}
+		sub.CreatedAt = sub.CreatedAt.UTC()
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteSubscription(id int64) error {
+	result, err := s.db.Exec("DELETE FROM subscriptions WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("deleting subscription: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("%w: subscription %d", services.ErrNotFound, id)
+	}
+	return nil
+}
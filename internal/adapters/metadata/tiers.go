@@ -0,0 +1,64 @@
+package metadata
+
+import (
+	"fmt"
+	"time"
+)
+
+// touchInterval is how stale a blob's access time must be before a read
+// records a new one, so busy blobs do not cost a write per download.
+const touchInterval = time.Hour
+
+const touchBlobQuery = "UPDATE blob_refcounts SET accessed_at = ? WHERE hash = ? AND (accessed_at IS NULL OR accessed_at < ?)"
+
+func (s *SQLiteStore) TouchBlob(hash string) error {
+	now := s.clock.Now().UTC()
+	_, err := s.db.Exec(touchBlobQuery, now, hash, now.Add(-touchInterval))
+	if err != nil {
+		return fmt.Errorf("recording blob access: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListIdleBlobs(idleSince time.Time, after string, limit int) ([]string, error) {
+	// Blobs never read count as idle from their latest upload.
+	_, err := s.db.Exec(`
+		UPDATE blob_refcounts SET accessed_at = COALESCE(
+			(SELECT MAX(uploaded_at) FROM artifacts WHERE hash = blob_refcounts.hash),
+			(SELECT MAX(uploaded_at) FROM assets WHERE hash = blob_refcounts.hash),
+			(SELECT MAX(uploaded_at) FROM sboms WHERE hash = blob_refcounts.hash),
+			(SELECT MAX(scanned_at) FROM scan_reports WHERE hash = blob_refcounts.hash),
+			?)
+		WHERE accessed_at IS NULL AND refs > 0
+	`, s.clock.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("initializing blob access times: %w", err)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT hash FROM blob_refcounts
+		WHERE tier = 'hot' AND refs > 0 AND accessed_at < ? AND hash > ?
+		ORDER BY hash LIMIT ?
+	`, idleSince.UTC(), after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("listing idle blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, fmt.Errorf("scanning hash: %w", err)
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
+
+func (s *SQLiteStore) SetBlobTier(hash, tier string) error {
+	if _, err := s.db.Exec("UPDATE blob_refcounts SET tier = ? WHERE hash = ?", tier, hash); err != nil {
+		return fmt.Errorf("setting blob tier: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// SQLiteStore also implements services.TokenStore.
+
+func (s *SQLiteStore) CreateToken(name, secretHash string, admin bool) (*models.APIToken, error) {
+	now := s.clock.Now().UTC()
+	result, err := s.db.Exec(
+		"INSERT INTO api_tokens (name, secret_hash, admin, created_at) VALUES (?, ?, ?, ?)",
+		name, secretHash, admin, now,
+	)
+	if err != nil {
+		if isUniqueConstraint(err) {
+			return nil, fmt.Errorf("%w: token already exists", services.ErrConflict)
+		}
+		return nil, fmt.Errorf("creating token: %w", err)
+	}
+
+	id, _ := result.LastInsertId()
+	return &models.APIToken{ID: id, Name: name, Admin: admin, CreatedAt: now}, nil
+}
+
+const lookupTokenQuery = "SELECT id, name, admin, user_name, created_at FROM api_tokens WHERE secret_hash = ? AND revoked_at IS NULL"
+
+func (s *SQLiteStore) LookupToken(secretHash string) (*models.APIToken, error) {
+	var t models.APIToken
+	err := s.db.QueryRow(lookupTokenQuery, secretHash).Scan(&t.ID, &t.Name, &t.Admin, &t.User, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("looking up token: %w", err)
+	}
+	t.CreatedAt = t.CreatedAt.UTC()
+	return &t, nil
+}
+
+func (s *SQLiteStore) ListTokens() ([]models.APIToken, error) {
+	rows, err := s.db.Query("SELECT id, name, admin, user_name, created_at, revoked_at FROM api_tokens ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("listing tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		var t models.APIToken
+		var revoked sql.NullTime
+		if err := rows.Scan(&t.ID, &t.Name, &t.Admin, &t.User, &t.CreatedAt, &revoked); err != nil {
+			return nil, fmt.Errorf("scanning token: %w", err)
+		}
+		t.CreatedAt = t.CreatedAt.UTC()
+		if revoked.Valid {
+			at := revoked.Time.UTC()
+			t.RevokedAt = &at
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *SQLiteStore) RevokeToken(id int64) error {
+	result, err := s.db.Exec(
+		"UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL",
+		s.clock.Now().UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("revoking token: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("%w: active token %d", services.ErrNotFound, id)
+	}
+	return nil
+}
+
+// SQLiteStore also implements services.TokenUsageStore.
+
+const addTokenUsageQuery = `
+	INSERT INTO token_usage (token_id, requests, bytes_uploaded, bytes_downloaded, last_used_at)
+	SELECT id, ?, ?, ?, ? FROM api_tokens WHERE id = ?
+	ON CONFLICT (token_id) DO UPDATE SET
+		requests = requests + excluded.requests,
+		bytes_uploaded = bytes_uploaded + excluded.bytes_uploaded,
+		bytes_downloaded = bytes_downloaded + excluded.bytes_downloaded,
+		last_used_at = MAX(last_used_at, excluded.last_used_at)`
+
+func (s *SQLiteStore) AddTokenUsage(usage []models.TokenUsage) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("recording token usage: %w", err)
+	}
+	defer tx.Rollback()
+	for _, u := range usage {
+		var at time.Time
+		if u.LastUsedAt != nil {
+			at = u.LastUsedAt.UTC()
+		}
+		if _, err := tx.Exec(addTokenUsageQuery, u.Requests, u.BytesUploaded, u.BytesDownloaded, at, u.TokenID); err != nil {
+			return fmt.Errorf("recording token usage: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) TokenUsage(id int64) (*models.TokenUsage, error) {
+	u := models.TokenUsage{TokenID: id}
+	var last sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT COALESCE(u.requests, 0), COALESCE(u.bytes_uploaded, 0), COALESCE(u.bytes_downloaded, 0), u.last_used_at
+		FROM api_tokens t LEFT JOIN token_usage u ON u.token_id = t.id
+		WHERE t.id = ?`, id,
+	).Scan(&u.Requests, &u.BytesUploaded, &u.BytesDownloaded, &last)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: token %d", services.ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying token usage: %w", err)
+	}
+	if last.Valid {
+		at := last.Time.UTC()
+		u.LastUsedAt = &at
+	}
+	return &u, nil
+}
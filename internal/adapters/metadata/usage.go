@@ -0,0 +1,82 @@
+package metadata
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// namespaceOf returns the namespace of a package, as models.UsageReport
+// describes; namespaceExpr computes the same in SQL for packages p.
+func namespaceOf(name string) string {
+	for _, sep := range []string{":", "/", "-"} {
+		if ns, _, ok := strings.Cut(name, sep); ok {
+			return ns
+		}
+	}
+	return name
+}
+
+const namespaceExpr = `CASE
+	WHEN instr(p.name, ':') > 0 THEN substr(p.name, 1, instr(p.name, ':') - 1)
+	WHEN instr(p.name, '/') > 0 THEN substr(p.name, 1, instr(p.name, '/') - 1)
+	WHEN instr(p.name, '-') > 0 THEN substr(p.name, 1, instr(p.name, '-') - 1)
+	ELSE p.name END`
+
+func (s *SQLiteStore) Usage() (*models.UsageReport, error) {
+	report := models.UsageReport{Packages: []models.Usage{}, Namespaces: []models.Usage{}}
+
+	packages, err := s.queryUsage(`
+		SELECT p.name, SUM(b.refs), SUM(b.size * b.refs), COUNT(*), SUM(b.size)
+		FROM package_blobs b JOIN packages p ON p.id = b.package_id
+		GROUP BY p.name ORDER BY p.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	// A blob in several packages of a namespace counts once toward it.
+	namespaces, err := s.queryUsage(`
+		SELECT ns, SUM(refs), SUM(logical), COUNT(*), SUM(size) FROM (
+			SELECT ` + namespaceExpr + ` AS ns, b.hash, MAX(b.size) AS size,
+				SUM(b.refs) AS refs, SUM(b.size * b.refs) AS logical
+			FROM package_blobs b JOIN packages p ON p.id = b.package_id
+			GROUP BY ns, b.hash
+		) GROUP BY ns ORDER BY ns
+	`)
+	if err != nil {
+		return nil, err
+	}
+	report.Packages = append(report.Packages, packages...)
+	report.Namespaces = append(report.Namespaces, namespaces...)
+
+	err = s.db.QueryRow(`
+		SELECT
+			(SELECT COALESCE(SUM(refs), 0) FROM package_blobs),
+			(SELECT COALESCE(SUM(size * refs), 0) FROM package_blobs),
+			(SELECT COUNT(*) FROM blob_refcounts WHERE refs > 0),
+			(SELECT COALESCE(SUM(size), 0) FROM blob_refcounts WHERE refs > 0)
+	`).Scan(&report.Total.Files, &report.Total.LogicalBytes, &report.Total.Blobs, &report.Total.PhysicalBytes)
+	if err != nil {
+		return nil, fmt.Errorf("querying total usage: %w", err)
+	}
+	return &report, nil
+}
+
+func (s *SQLiteStore) queryUsage(query string) ([]models.Usage, error) {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("querying usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []models.Usage
+	for rows.Next() {
+		var u models.Usage
+		if err := rows.Scan(&u.Name, &u.Files, &u.LogicalBytes, &u.Blobs, &u.PhysicalBytes); err != nil {
+			return nil, fmt.Errorf("scanning usage: %w", err)
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
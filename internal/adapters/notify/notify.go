@@ -0,0 +1,293 @@
+// Package notify delivers notifications to subscriptions: signed JSON posts
+// to webhooks, messages to Slack incoming webhooks and email over SMTP.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/netip"
+	"net/smtp"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// SMTP is the mail server email notifications are sent through. Addr is
+// host:port; Username and Password, if set, authenticate with PLAIN, which
+// net/smtp only allows over TLS or to localhost. STARTTLS is used when the
+// server offers it.
+type SMTP struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// Dispatcher implements services.Notifier.
+type Dispatcher struct {
+	client  *http.Client
+	smtp    SMTP
+	timeout time.Duration
+	allowed []netip.Prefix
+}
+
+// New creates a Dispatcher bounding each delivery by timeout. Email is
+// only supported if mail.Addr is set. Webhooks may only reach public
+// addresses and those in allowed: loopback, private, link-local and other
+// internal addresses are refused when the connection is made, so neither
+// a target nor a redirect nor a DNS answer can point a delivery at the
+// registry's own network.
+func New(timeout time.Duration, mail SMTP, allowed []netip.Prefix) *Dispatcher {
+	d := &Dispatcher{smtp: mail, timeout: timeout, allowed: allowed}
+	dialer := &net.Dialer{Timeout: timeout, Control: d.control}
+	d.client = &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+	}
+	return d
+}
+
+// control refuses connections to addresses webhooks may not reach.
+func (d *Dispatcher) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !d.reachable(addr) {
+		return fmt.Errorf("%s is an internal address", addr)
+	}
+	return nil
+}
+
+// reachable reports whether webhooks may connect to addr.
+func (d *Dispatcher) reachable(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, network := range d.allowed {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which
+// IsPrivate leaves out but which is no more public.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Validate implements services.Notifier. Webhook targets naming an internal
+// address outright are refused here; host names are checked when
+// delivering.
+func (d *Dispatcher) Validate(sub models.Subscription) error {
+	switch sub.Channel {
+	case models.ChannelWebhook, models.ChannelSlack:
+		u, err := url.Parse(sub.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("target %q is not an http or https URL", sub.Target)
+		}
+		if addr, err := netip.ParseAddr(u.Hostname()); err == nil && !d.reachable(addr) {
+			return fmt.Errorf("target %q is an internal address", sub.Target)
+		}
+	case models.ChannelEmail:
+		if d.smtp.Addr == "" {
+			return errors.New("email notifications are not configured")
+		}
+		if _, err := mail.ParseAddress(sub.Target); err != nil {
+			return fmt.Errorf("target %q is not an email address", sub.Target)
+		}
+	default:
+		return fmt.Errorf("channel must be %s, %s or %s", models.ChannelWebhook, models.ChannelSlack, models.ChannelEmail)
+	}
+	return nil
+}
+
+// Notify implements services.Notifier.
+func (d *Dispatcher) Notify(ctx context.Context, sub models.Subscription, n models.Notification) error {
+	switch sub.Channel {
+	case models.ChannelWebhook:
+		return d.webhook(ctx, sub, n)
+	case models.ChannelSlack:
+		return d.slack(ctx, sub, n)
+	case models.ChannelEmail:
+		return d.email(ctx, sub, n)
+	}
+	return fmt.Errorf("unsupported channel %q", sub.Channel)
+}
+
+// Sign returns the X-Foundry-Signature of a webhook body: the hex HMAC-SHA256
+// of the body keyed by the subscription secret, prefixed with "sha256=".
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhook posts the notification as JSON, signed when the subscription has
+// a secret.
+func (d *Dispatcher) webhook(ctx context.Context, sub models.Subscription, n models.Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("encoding notification: %w", err)
+	}
+	header := http.Header{"X-Foundry-Event": {n.Event}}
+	if sub.Secret != "" {
+		header.Set("X-Foundry-Signature", Sign(sub.Secret, body))
+	}
+	return d.post(ctx, sub.Target, header, body)
+}
+
+// slack posts the notification's summary to a Slack incoming webhook.
+func (d *Dispatcher) slack(ctx context.Context, sub models.Subscription, n models.Notification) error {
+	body, err := json.Marshal(map[string]string{"text": Summary(n)})
+	if err != nil {
+		return fmt.Errorf("encoding notification: %w", err)
+	}
+	return d.post(ctx, sub.Target, nil, body)
+}
+
+func (d *Dispatcher) post(ctx context.Context, target string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building notification request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("delivering notification: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("delivering notification: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// email sends the notification's summary as a plain text message.
+func (d *Dispatcher) email(ctx context.Context, sub models.Subscription, n models.Notification) error {
+	if d.smtp.Addr == "" {
+		return errors.New("email notifications are not configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", d.smtp.Addr)
+	if err != nil {
+		return fmt.Errorf("connecting to SMTP server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	host, _, _ := net.SplitHostPort(d.smtp.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("connecting to SMTP server: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("starting TLS: %w", err)
+		}
+	}
+	if d.smtp.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", d.smtp.Username, d.smtp.Password, host)); err != nil {
+			return fmt.Errorf("authenticating to SMTP server: %w", err)
+		}
+	}
+	if err := c.Mail(d.smtp.From); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	to, err := mail.ParseAddress(sub.Target)
+	if err != nil {
+		return fmt.Errorf("invalid email address %q: %w", sub.Target, err)
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	if _, err := w.Write(message(d.smtp.From, sub.Target, n)); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	return c.Quit()
+}
+
+// message formats an email carrying the notification.
+func message(from, to string, n models.Notification) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: [foundry] %s\r\n", Summary(n))
+	fmt.Fprintf(&b, "Date: %s\r\n", n.At.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "%s\r\n\r\n", Summary(n))
+	fmt.Fprintf(&b, "Package: %s\r\nVersion: %s\r\n", n.Package, n.Version)
+	if n.File != "" {
+		fmt.Fprintf(&b, "File: %s\r\n", n.File)
+	}
+	if n.Hash != "" {
+		fmt.Fprintf(&b, "SHA256: %s\r\n", n.Hash)
+	}
+	if n.Actor != "" {
+		fmt.Fprintf(&b, "By: %s\r\n", n.Actor)
+	}
+	fmt.Fprintf(&b, "At: %s\r\n", n.At.Format(time.RFC3339))
+	return b.Bytes()
+}
+
+// Summary describes a notification in one line, such as "New version of
+// app: 1.2.0".
+func Summary(n models.Notification) string {
+	subject := n.Package + "@" + n.Version
+	if n.File != "" {
+		subject = fmt.Sprintf("file %s of %s", n.File, subject)
+	}
+	var s string
+	switch n.Event {
+	case models.NotifyCreate:
+		s = fmt.Sprintf("New version of %s: %s", n.Package, n.Version)
+		if n.File != "" {
+			s = "New " + subject
+		}
+	case models.NotifyOverwrite:
+		s = "Overwrote " + subject
+	case models.NotifyDelete:
+		s = "Deleted " + subject
+	case models.NotifyPromote:
+		s = fmt.Sprintf("Promoted %s to %s", subject, n.Stage)
+	case models.NotifyCopy:
+		s = fmt.Sprintf("Copied %s to %s", n.Source, subject)
+	default:
+		s = fmt.Sprintf("%s: %s", n.Event, subject)
+	}
+	if n.Actor != "" {
+		s += " by " + n.Actor
+	}
+	return s
+}
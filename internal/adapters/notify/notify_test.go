@@ -0,0 +1,223 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// loopback lets deliveries reach the test servers.
+var loopback = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+var note = models.Notification{
+	Event: models.NotifyCreate, Package: "app", Version: "1.2.0", Hash: "abc", Actor: "ci",
+	At: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+}
+
+func TestWebhook(t *testing.T) {
+	var body []byte
+	var header http.Header
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	d := New(time.Second, SMTP{}, loopback)
+	sub := models.Subscription{Channel: models.ChannelWebhook, Target: srv.URL, Secret: "s3cret"}
+
+	if err := d.Notify(context.Background(), sub, note); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	var got models.Notification
+	if err := json.Unmarshal(body, &got); err != nil || got != note {
+		t.Errorf("delivered %s (%v), want %+v", body, err, note)
+	}
+	if header.Get("X-Foundry-Event") != "create" {
+		t.Errorf("X-Foundry-Event = %q", header.Get("X-Foundry-Event"))
+	}
+	if sig := header.Get("X-Foundry-Signature"); sig != Sign("s3cret", body) || !strings.HasPrefix(sig, "sha256=") {
+		t.Errorf("X-Foundry-Signature = %q, want %q", sig, Sign("s3cret", body))
+	}
+
+	sub.Secret = ""
+	d.Notify(context.Background(), sub, note)
+	if sig := header.Get("X-Foundry-Signature"); sig != "" {
+		t.Errorf("unsigned delivery carries signature %q", sig)
+	}
+
+	status = http.StatusInternalServerError
+	if err := d.Notify(context.Background(), sub, note); err == nil {
+		t.Error("expected an error for a 500 response")
+	}
+}
+
+func TestSlack(t *testing.T) {
+	var got struct{ Text string }
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	promote := note
+	promote.Event, promote.Stage = models.NotifyPromote, models.StageRelease
+	sub := models.Subscription{Channel: models.ChannelSlack, Target: srv.URL}
+	if err := New(time.Second, SMTP{}, loopback).Notify(context.Background(), sub, promote); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if want := "Promoted app@1.2.0 to release by ci"; got.Text != want {
+		t.Errorf("text = %q, want %q", got.Text, want)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	file := note
+	file.File = "notes.txt"
+	deleted := note
+	deleted.Event, deleted.Actor = models.NotifyDelete, ""
+	copied := note
+	copied.Event, copied.Source = models.NotifyCopy, "app@1.2.0-rc.1"
+	for _, tc := range []struct {
+		n    models.Notification
+		want string
+	}{
+		{note, "New version of app: 1.2.0 by ci"},
+		{file, "New file notes.txt of app@1.2.0 by ci"},
+		{deleted, "Deleted app@1.2.0"},
+		{copied, "Copied app@1.2.0-rc.1 to app@1.2.0 by ci"},
+	} {
+		if got := Summary(tc.n); got != tc.want {
+			t.Errorf("Summary(%+v) = %q, want %q", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// A minimal SMTP server accepting one message.
+	type received struct {
+		from, to, data string
+	}
+	done := make(chan received, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		var msg received
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 localhost")
+			case "MAIL":
+				msg.from = line
+				tp.PrintfLine("250 OK")
+			case "RCPT":
+				msg.to = line
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, _ := io.ReadAll(tp.DotReader())
+				msg.data = string(data)
+				tp.PrintfLine("250 OK")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				done <- msg
+				return
+			default:
+				tp.PrintfLine("502 unsupported")
+			}
+		}
+	}()
+
+	d := New(time.Second, SMTP{Addr: ln.Addr().String(), From: "registry@example.com"}, nil)
+	sub := models.Subscription{Channel: models.ChannelEmail, Target: "Team <team@example.com>"}
+	if err := d.Validate(sub); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := d.Notify(context.Background(), sub, note); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	msg := <-done
+	if msg.from != "MAIL FROM:<registry@example.com>" || !strings.HasPrefix(msg.to, "RCPT TO:<team@example.com>") {
+		t.Errorf("envelope %q / %q", msg.from, msg.to)
+	}
+	headers, _ := textproto.NewReader(bufio.NewReader(strings.NewReader(msg.data))).ReadMIMEHeader()
+	if got := headers.Get("Subject"); got != "[foundry] New version of app: 1.2.0 by ci" {
+		t.Errorf("Subject = %q", got)
+	}
+	if !strings.Contains(msg.data, "SHA256: abc") {
+		t.Errorf("message lacks the hash:\n%s", msg.data)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	d := New(time.Second, SMTP{}, []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")})
+	for _, tc := range []struct {
+		sub models.Subscription
+		ok  bool
+	}{
+		{models.Subscription{Channel: models.ChannelWebhook, Target: "https://hooks.example/x"}, true},
+		{models.Subscription{Channel: models.ChannelSlack, Target: "ftp://hooks.example/x"}, false},
+		{models.Subscription{Channel: models.ChannelWebhook, Target: "/relative"}, false},
+		{models.Subscription{Channel: models.ChannelWebhook, Target: "http://169.254.169.254/latest/meta-data"}, false},
+		{models.Subscription{Channel: models.ChannelWebhook, Target: "http://127.0.0.1:8080/admin"}, false},
+		{models.Subscription{Channel: models.ChannelWebhook, Target: "http://[::1]/"}, false},
+		{models.Subscription{Channel: models.ChannelSlack, Target: "http://192.168.1.1/"}, false},
+		{models.Subscription{Channel: models.ChannelWebhook, Target: "http://10.1.2.3/hook"}, true}, // allowed
+		{models.Subscription{Channel: models.ChannelWebhook, Target: "http://10.2.0.1/hook"}, false},
+		{models.Subscription{Channel: models.ChannelEmail, Target: "team@example.com"}, false}, // no SMTP server
+		{models.Subscription{Channel: "pager", Target: "x"}, false},
+	} {
+		if err := d.Validate(tc.sub); (err == nil) != tc.ok {
+			t.Errorf("Validate(%s %s) = %v, want ok=%v", tc.sub.Channel, tc.sub.Target, err, tc.ok)
+		}
+	}
+}
+
+func TestInternalTargetsRefused(t *testing.T) {
+	delivered := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered = true
+	}))
+	defer srv.Close()
+
+	// A host name passes Validate but resolves to loopback when dialled.
+	target := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	sub := models.Subscription{Channel: models.ChannelWebhook, Target: target}
+	d := New(time.Second, SMTP{}, nil)
+	if err := d.Validate(sub); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := d.Notify(context.Background(), sub, note); err == nil || !strings.Contains(err.Error(), "internal address") {
+		t.Errorf("Notify to loopback: %v", err)
+	}
+
+	if delivered {
+		t.Error("a notification reached the internal server")
+	}
+}
@@ -0,0 +1,78 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// OPA asks an Open Policy Agent server for decisions through its data API.
+// The request is posted as the input document to a rule that evaluates
+// either to a boolean or to an object {"allow": bool, "reason": string}.
+type OPA struct {
+	url    string
+	client *http.Client
+}
+
+// NewOPA creates an OPA client querying the data API URL of a rule, such as
+// http://localhost:8181/v1/data/foundry/decision. Each decision is bounded
+// by timeout.
+func NewOPA(url string, timeout time.Duration) *OPA {
+	return &OPA{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// opaDecision is the object form of a rule's result.
+type opaDecision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason"`
+}
+
+// Evaluate implements services.PolicyEngine. A rule left undefined for the
+// input denies the request.
+func (o *OPA) Evaluate(ctx context.Context, req models.PolicyRequest) error {
+	body, err := json.Marshal(map[string]any{"input": req})
+	if err != nil {
+		return fmt.Errorf("encoding policy input: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building OPA request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("querying OPA: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("querying OPA: unexpected status %s", resp.Status)
+	}
+
+	var out struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("decoding OPA response: %w", err)
+	}
+	if len(out.Result) == 0 {
+		return fmt.Errorf("%w: no policy decision for %s of %s@%s", services.ErrPolicyDenied, req.Action, req.Package, req.Version)
+	}
+
+	decision := opaDecision{Reason: "rejected by OPA policy"}
+	if err := json.Unmarshal(out.Result, &decision.Allow); err != nil {
+		if err := json.Unmarshal(out.Result, &decision); err != nil {
+			return fmt.Errorf("decoding OPA decision: result must be a boolean or an object with \"allow\"")
+		}
+	}
+	if !decision.Allow {
+		return fmt.Errorf("%w: %s", services.ErrPolicyDenied, decision.Reason)
+	}
+	return nil
+}
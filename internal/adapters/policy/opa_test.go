@@ -0,0 +1,74 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+func TestOPA(t *testing.T) {
+	var result string
+	var input models.PolicyRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input models.PolicyRequest `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		input = body.Input
+		w.Write([]byte(result))
+	}))
+	defer srv.Close()
+
+	opa := NewOPA(srv.URL+"/v1/data/foundry/decision", time.Second)
+	req := models.PolicyRequest{
+		Action:    models.PolicyActionDelete,
+		Package:   "app",
+		Version:   "1.0.0",
+		Principal: &models.Principal{Name: "ci"},
+	}
+
+	for _, tc := range []struct {
+		result string
+		allow  bool
+		reason string
+	}{
+		{`{"result": true}`, true, ""},
+		{`{"result": false}`, false, "rejected by OPA policy"},
+		{`{"result": {"allow": true}}`, true, ""},
+		{`{"result": {"allow": false, "reason": "releases are immutable"}}`, false, "releases are immutable"},
+		{`{}`, false, "no policy decision"},
+	} {
+		result = tc.result
+		err := opa.Evaluate(context.Background(), req)
+		if tc.allow {
+			if err != nil {
+				t.Errorf("%s: expected allow, got %v", tc.result, err)
+			}
+			continue
+		}
+		if !errors.Is(err, services.ErrPolicyDenied) || !strings.Contains(err.Error(), tc.reason) {
+			t.Errorf("%s: expected denial mentioning %q, got %v", tc.result, tc.reason, err)
+		}
+	}
+	if input.Action != "delete" || input.Package != "app" || input.Principal == nil || input.Principal.Name != "ci" {
+		t.Errorf("unexpected input document: %+v", input)
+	}
+
+	// Malformed decisions and unreachable servers are errors, not denials.
+	result = `{"result": "yes"}`
+	if err := opa.Evaluate(context.Background(), req); err == nil || errors.Is(err, services.ErrPolicyDenied) {
+		t.Errorf("malformed decision: expected a non-denial error, got %v", err)
+	}
+	srv.Close()
+	if err := opa.Evaluate(context.Background(), req); err == nil || errors.Is(err, services.ErrPolicyDenied) {
+		t.Errorf("unreachable OPA: expected a non-denial error, got %v", err)
+	}
+}
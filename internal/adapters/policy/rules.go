@@ -0,0 +1,118 @@
+// Package policy implements services.PolicyEngine with built-in rules and
+// an Open Policy Agent client.
+package policy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/filetype"
+	"github.com/foundry/registry/internal/util/semver"
+)
+
+// Rules enforces the built-in policies. The zero value allows everything.
+type Rules struct {
+	// RequireSemver refuses uploads whose version is not valid semver.
+	RequireSemver bool
+	// PackagePrefixes maps a principal name to the package name prefixes
+	// it may upload to and delete from. The "*" entry applies to principals
+	// not listed; without one, unlisted principals are unrestricted.
+	PackagePrefixes map[string][]string
+	// ProtectReleases refuses deletes from semver release versions.
+	// Pre-releases and versions that are not semver stay deletable.
+	ProtectReleases bool
+	// Formats maps package name prefixes to the formats uploads to those
+	// packages may have. The longest matching prefix applies; packages no
+	// prefix matches are unrestricted.
+	Formats map[string][]string
+	// MatchFilenames refuses uploads whose content is not in the format
+	// their file name claims, such as a .whl that is not a wheel.
+	MatchFilenames bool
+}
+
+// Evaluate implements services.PolicyEngine.
+func (rl *Rules) Evaluate(_ context.Context, req models.PolicyRequest) error {
+	if prefixes, ok := rl.prefixes(req.Principal); ok && !hasAnyPrefix(req.Package, prefixes) {
+		return fmt.Errorf("%w: package %s must start with one of %s", services.ErrPolicyDenied,
+			req.Package, strings.Join(prefixes, ", "))
+	}
+
+	switch req.Action {
+	case models.PolicyActionUpload:
+		if rl.RequireSemver {
+			if _, err := semver.Parse(req.Version); err != nil {
+				return fmt.Errorf("%w: version %q is not valid semver", services.ErrPolicyDenied, req.Version)
+			}
+		}
+		// Formats are only known once the content is stored.
+		if req.Format == "" {
+			break
+		}
+		if formats, ok := rl.formats(req.Package); ok && !slices.Contains(formats, req.Format) {
+			return fmt.Errorf("%w: %s uploads must be %s, not %s", services.ErrPolicyDenied,
+				req.Package, strings.Join(formats, ", "), req.Format)
+		}
+		if claimed := filetype.FromFilename(req.File); rl.MatchFilenames && claimed != "" && !filetype.Satisfies(claimed, req.Format) {
+			return fmt.Errorf("%w: %s is named as %s but its content is %s", services.ErrPolicyDenied,
+				req.File, claimed, req.Format)
+		}
+	case models.PolicyActionDelete:
+		if rl.ProtectReleases {
+			if v, err := semver.Parse(req.Version); err == nil && len(v.Pre) == 0 {
+				return fmt.Errorf("%w: %s@%s is a release and cannot be deleted", services.ErrPolicyDenied, req.Package, req.Version)
+			}
+		}
+	}
+	return nil
+}
+
+// prefixes returns the package prefixes allowed to p, or ok=false if p is
+// unrestricted.
+func (rl *Rules) prefixes(p *models.Principal) (prefixes []string, ok bool) {
+	if p != nil {
+		if prefixes, ok := rl.PackagePrefixes[p.Name]; ok {
+			return prefixes, true
+		}
+	}
+	prefixes, ok = rl.PackagePrefixes["*"]
+	return prefixes, ok
+}
+
+// formats returns the formats allowed in package pkg, or ok=false if its
+// uploads are unrestricted.
+func (rl *Rules) formats(pkg string) (formats []string, ok bool) {
+	longest := -1
+	for prefix, allowed := range rl.Formats {
+		if strings.HasPrefix(pkg, prefix) && len(prefix) > longest {
+			longest, formats = len(prefix), allowed
+		}
+	}
+	return formats, longest >= 0
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Chain consults each engine in order and returns the first refusal or
+// error.
+type Chain []services.PolicyEngine
+
+// Evaluate implements services.PolicyEngine.
+func (c Chain) Evaluate(ctx context.Context, req models.PolicyRequest) error {
+	for _, e := range c {
+		if err := e.Evaluate(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
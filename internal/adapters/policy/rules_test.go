@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+func TestRules(t *testing.T) {
+	rules := &Rules{
+		RequireSemver: true,
+		PackagePrefixes: map[string][]string{
+			"payments-ci": {"payments-", "shared-"},
+			"*":           {"sandbox-"},
+		},
+		ProtectReleases: true,
+	}
+	payments := &models.Principal{Name: "payments-ci"}
+	other := &models.Principal{Name: "intern"}
+
+	for _, tc := range []struct {
+		name  string
+		req   models.PolicyRequest
+		allow bool
+	}{
+		{"semver upload", models.PolicyRequest{Action: models.PolicyActionUpload, Package: "payments-api", Version: "1.2.0", Principal: payments}, true},
+		{"non-semver upload", models.PolicyRequest{Action: models.PolicyActionUpload, Package: "payments-api", Version: "nightly", Principal: payments}, false},
+		{"second prefix", models.PolicyRequest{Action: models.PolicyActionUpload, Package: "shared-lib", Version: "1.0.0", Principal: payments}, true},
+		{"foreign package", models.PolicyRequest{Action: models.PolicyActionUpload, Package: "search-api", Version: "1.0.0", Principal: payments}, false},
+		{"default prefix", models.PolicyRequest{Action: models.PolicyActionUpload, Package: "sandbox-x", Version: "1.0.0", Principal: other}, true},
+		{"default prefix miss", models.PolicyRequest{Action: models.PolicyActionUpload, Package: "payments-api", Version: "1.0.0", Principal: other}, false},
+		{"delete release", models.PolicyRequest{Action: models.PolicyActionDelete, Package: "payments-api", Version: "1.2.0", Principal: payments}, false},
+		{"delete release file", models.PolicyRequest{Action: models.PolicyActionDelete, Package: "payments-api", Version: "1.2.0", File: "a.zip", Principal: payments}, false},
+		{"delete pre-release", models.PolicyRequest{Action: models.PolicyActionDelete, Package: "payments-api", Version: "1.2.0-rc.1", Principal: payments}, true},
+		{"delete non-semver", models.PolicyRequest{Action: models.PolicyActionDelete, Package: "payments-api", Version: "nightly", Principal: payments}, true},
+	} {
+		err := rules.Evaluate(context.Background(), tc.req)
+		if tc.allow && err != nil {
+			t.Errorf("%s: expected allow, got %v", tc.name, err)
+		}
+		if !tc.allow && !errors.Is(err, services.ErrPolicyDenied) {
+			t.Errorf("%s: expected ErrPolicyDenied, got %v", tc.name, err)
+		}
+	}
+
+	var zero Rules
+	req := models.PolicyRequest{Action: models.PolicyActionDelete, Package: "anything", Version: "1.0.0"}
+	if err := zero.Evaluate(context.Background(), req); err != nil {
+		t.Errorf("zero Rules should allow everything, got %v", err)
+	}
+}
+
+func TestFormatRules(t *testing.T) {
+	rules := &Rules{
+		Formats: map[string][]string{
+			"":    {"tar.gz", "zip", "wheel", "jar"},
+			"py-": {"wheel", "tar.gz"},
+			"bin": {"binary"},
+		},
+		MatchFilenames: true,
+	}
+	upload := func(pkg, file, format string) models.PolicyRequest {
+		return models.PolicyRequest{Action: models.PolicyActionUpload, Package: pkg, Version: "1.0.0", File: file, Format: format}
+	}
+	for _, tc := range []struct {
+		name  string
+		req   models.PolicyRequest
+		allow bool
+	}{
+		{"before the content is known", upload("py-lib", "", ""), true},
+		{"allowed format", upload("py-lib", "lib-1.0-py3-none-any.whl", "wheel"), true},
+		{"longest prefix wins", upload("py-lib", "lib.zip", "zip"), false},
+		{"catch-all prefix", upload("app", "app.zip", "zip"), true},
+		{"catch-all refuses", upload("app", "app", "binary"), false},
+		{"own prefix", upload("bin-tool", "tool", "binary"), true},
+		{"zipped directory named as a wheel", upload("app", "app-1.0-py3-none-any.whl", "zip"), false},
+		{"jar named as a zip", upload("app", "app.zip", "jar"), true},
+		{"tarball named as a zip", upload("app", "app.zip", "tar.gz"), false},
+		{"name claims nothing", upload("app", "app-linux", "tar.gz"), true},
+	} {
+		err := rules.Evaluate(context.Background(), tc.req)
+		if tc.allow && err != nil {
+			t.Errorf("%s: expected allow, got %v", tc.name, err)
+		}
+		if !tc.allow && !errors.Is(err, services.ErrPolicyDenied) {
+			t.Errorf("%s: expected ErrPolicyDenied, got %v", tc.name, err)
+		}
+	}
+}
+
+type denyAll struct{ calls *int }
+
+func (d denyAll) Evaluate(context.Context, models.PolicyRequest) error {
+	*d.calls++
+	return services.ErrPolicyDenied
+}
+
+func TestChainStopsAtFirstDenial(t *testing.T) {
+	calls := 0
+	chain := Chain{&Rules{}, denyAll{&calls}, denyAll{&calls}}
+	if err := chain.Evaluate(context.Background(), models.PolicyRequest{}); !errors.Is(err, services.ErrPolicyDenied) {
+		t.Fatalf("expected denial, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected evaluation to stop at the first denial, got %d calls", calls)
+	}
+}
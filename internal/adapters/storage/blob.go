@@ -7,86 +7,138 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/diskspace"
 	"github.com/foundry/registry/internal/util/hashing"
 )
 
 // DiskBlobStorage stores blobs on disk in a content-addressed layout.
 type DiskBlobStorage struct {
 	dataDir string
+
+	// bufferSize and buffers shape the write pipeline; preallocate is the
+	// extent reserved ahead of writes, or zero.
+	bufferSize  int
+	buffers     int
+	preallocate int64
+	pool        sync.Pool
+	stats       writeStats
 }
 
 // NewDiskBlobStorage creates a new DiskBlobStorage.
-func NewDiskBlobStorage(dataDir string) (*DiskBlobStorage, error) {
+func NewDiskBlobStorage(dataDir string, opts ...DiskOption) (*DiskBlobStorage, error) {
 	blobDir := filepath.Join(dataDir, "blobs")
 	if err := os.MkdirAll(blobDir, 0o755); err != nil {
 		return nil, fmt.Errorf("creating blob directory: %w", err)
 	}
-	return &DiskBlobStorage{dataDir: dataDir}, nil
+	s := &DiskBlobStorage{dataDir: dataDir, bufferSize: defaultBufferSize, buffers: defaultBuffers}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
 }
 
 // Store streams data from r to disk, computing its SHA256 hash.
 // It writes to a temp file first then does an atomic rename.
 func (s *DiskBlobStorage) Store(r io.Reader) (string, int64, error) {
+	staged, err := s.Stage(r)
+	if err != nil {
+		return "", 0, err
+	}
+	return commitStaged(staged)
+}
+
+// Stage streams data from r to a temp file, computing its SHA256 hash.
+// Committing renames the file to its content-addressed path.
+func (s *DiskBlobStorage) Stage(r io.Reader) (services.StagedBlob, error) {
 	tmpDir := filepath.Join(s.dataDir, "tmp")
 	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
-		return "", 0, fmt.Errorf("creating temp directory: %w", err)
+		return nil, fmt.Errorf("creating temp directory: %w", err)
 	}
 
 	tmp, err := os.CreateTemp(tmpDir, "upload-*")
 	if err != nil {
-		return "", 0, fmt.Errorf("creating temp file: %w", err)
+		return nil, fmt.Errorf("creating temp file: %w", err)
 	}
 	tmpPath := tmp.Name()
 
-	// Ensure cleanup on failure.
-	success := false
-	defer func() {
-		if !success {
-			tmp.Close()
-			os.Remove(tmpPath)
-		}
-	}()
-
 	// Stream through SHA256 hasher while writing to temp.
-	h, size, err := streamToFile(tmp, r)
+	h, size, err := s.streamToFile(tmp, r)
 	if err != nil {
-		return "", 0, err
+		tmp.Close()
+		os.Remove(tmpPath)
+		return nil, err
 	}
-
 	if err := tmp.Close(); err != nil {
-		return "", 0, fmt.Errorf("closing temp file: %w", err)
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("closing temp file: %w", err)
 	}
+	return &stagedFile{tmpPath: tmpPath, finalPath: s.BlobPath(h), hash: h, size: size}, nil
+}
+
+// stagedFile is a blob written to the temp directory, awaiting a rename
+// into place.
+type stagedFile struct {
+	tmpPath   string
+	finalPath string
+	hash      string
+	size      int64
+	done      bool
+}
+
+func (f *stagedFile) Hash() string { return f.hash }
+func (f *stagedFile) Size() int64  { return f.size }
 
-	// Move to final content-addressed path.
-	dir := filepath.Join(s.dataDir, "blobs", hashing.BlobDir(h))
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return "", 0, fmt.Errorf("creating blob subdirectory: %w", err)
+func (f *stagedFile) Commit() error {
+	if f.done {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(f.finalPath), 0o755); err != nil {
+		return fmt.Errorf("creating blob subdirectory: %w", err)
 	}
 
-	finalPath := filepath.Join(dir, h)
-	if _, err := os.Stat(finalPath); err == nil {
+	if _, err := os.Stat(f.finalPath); err == nil {
 		// Blob already exists, remove the temp.
-		os.Remove(tmpPath)
-		success = true
-		return h, size, nil
+		os.Remove(f.tmpPath)
+		f.done = true
+		return nil
 	} else if !errors.Is(err, os.ErrNotExist) {
-		return "", 0, fmt.Errorf("checking final blob path: %w", err)
+		return fmt.Errorf("checking final blob path: %w", err)
 	}
 
-	if err := os.Rename(tmpPath, finalPath); err != nil {
+	if err := os.Rename(f.tmpPath, f.finalPath); err != nil {
 		// A concurrent upload may have already won the race to place the blob.
-		if _, statErr := os.Stat(finalPath); statErr == nil {
-			os.Remove(tmpPath)
-			success = true
-			return h, size, nil
+		if _, statErr := os.Stat(f.finalPath); statErr != nil {
+			return fmt.Errorf("moving blob to final path: %w", err)
 		}
-		return "", 0, fmt.Errorf("moving blob to final path: %w", err)
+		os.Remove(f.tmpPath)
+	}
+	f.done = true
+	return nil
+}
+
+func (f *stagedFile) Discard() error {
+	if f.done {
+		return nil
 	}
+	f.done = true
+	if err := os.Remove(f.tmpPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing staged blob: %w", err)
+	}
+	return nil
+}
 
-	success = true
-	return h, size, nil
+// commitStaged commits a blob staged on behalf of Store, discarding it if
+// the commit fails.
+func commitStaged(staged services.StagedBlob) (string, int64, error) {
+	if err := staged.Commit(); err != nil {
+		staged.Discard()
+		return "", 0, err
+	}
+	return staged.Hash(), staged.Size(), nil
 }
 
 // Open returns a ReadCloser for the blob with the given hash.
@@ -108,6 +160,18 @@ func (s *DiskBlobStorage) Exists(hash string) bool {
 	return err == nil
 }
 
+// Size returns the length of a stored blob.
+func (s *DiskBlobStorage) Size(hash string) (int64, error) {
+	info, err := os.Stat(s.BlobPath(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, fmt.Errorf("%w: blob %s", services.ErrNotFound, hash)
+		}
+		return 0, fmt.Errorf("stat blob: %w", err)
+	}
+	return info.Size(), nil
+}
+
 // Delete removes a blob.
 func (s *DiskBlobStorage) Delete(hash string) error {
 	p := s.BlobPath(hash)
@@ -124,10 +188,74 @@ func (s *DiskBlobStorage) BlobPath(hash string) string {
 
 // ListBlobs returns all blob hashes stored on disk.
 func (s *DiskBlobStorage) ListBlobs() ([]string, error) {
-	blobDir := filepath.Join(s.dataDir, "blobs")
+	return listHashDir(filepath.Join(s.dataDir, "blobs"))
+}
+
+// FreeSpace returns the bytes available on the data directory's filesystem.
+func (s *DiskBlobStorage) FreeSpace() (int64, error) {
+	return diskspace.Free(s.dataDir)
+}
+
+// CleanTemp removes upload temp files last written before before.
+func (s *DiskBlobStorage) CleanTemp(before time.Time) (int, int64, error) {
+	stale, err := staleUploads(filepath.Join(s.dataDir, "tmp"), before)
+	if err != nil {
+		return 0, 0, err
+	}
+	var files int
+	var bytes int64
+	for _, f := range stale {
+		if err := os.Remove(f.path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return files, bytes, fmt.Errorf("removing stale upload: %w", err)
+		}
+		files++
+		bytes += f.size
+	}
+	return files, bytes, nil
+}
+
+// staleUpload is an upload-* file found by staleUploads.
+type staleUpload struct {
+	path string
+	size int64
+}
+
+// staleUploads lists the upload-* files in dir last written before before.
+func staleUploads(dir string, before time.Time) ([]staleUpload, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading %s: %w", filepath.Base(dir), err)
+	}
+	var stale []staleUpload
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), "upload-") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("reading %s: %w", entry.Name(), err)
+		}
+		if info.ModTime().Before(before) {
+			stale = append(stale, staleUpload{path: filepath.Join(dir, entry.Name()), size: info.Size()})
+		}
+	}
+	return stale, nil
+}
+
+// listHashDir returns the hashes stored in a two-level <first2>/<hash> layout.
+func listHashDir(root string) ([]string, error) {
 	var hashes []string
 
-	prefixes, err := os.ReadDir(blobDir)
+	prefixes, err := os.ReadDir(root)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
@@ -139,7 +267,7 @@ func (s *DiskBlobStorage) ListBlobs() ([]string, error) {
 		if !prefix.IsDir() || len(prefix.Name()) != 2 {
 			continue
 		}
-		subDir := filepath.Join(blobDir, prefix.Name())
+		subDir := filepath.Join(root, prefix.Name())
 		entries, err := os.ReadDir(subDir)
 		if err != nil {
 			return nil, fmt.Errorf("reading blob subdirectory: %w", err)
@@ -155,16 +283,6 @@ func (s *DiskBlobStorage) ListBlobs() ([]string, error) {
 	return hashes, nil
 }
 
-// streamToFile writes from r to f while computing SHA256.
-func streamToFile(f *os.File, r io.Reader) (string, int64, error) {
-	hasher := newHashingWriter(f)
-	n, err := io.Copy(hasher, r)
-	if err != nil {
-		return "", 0, fmt.Errorf("streaming to file: %w", err)
-	}
-	return hasher.Hash(), n, nil
-}
-
 func isHexHash(v string) bool {
 	if len(v) != 64 {
 		return false
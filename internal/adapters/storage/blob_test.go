@@ -1,11 +1,22 @@
 package storage
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
 )
 
 func TestDiskBlobStorage_StoreAndOpen(t *testing.T) {
@@ -231,3 +242,236 @@ func TestDiskBlobStorage_ConcurrentDedup(t *testing.T) {
 		t.Fatalf("expected one blob for concurrent uploads, found %d", count)
 	}
 }
+
+func TestDiskBlobStorage_Size(t *testing.T) {
+	store, err := NewDiskBlobStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+
+	hash, _, err := store.Store(strings.NewReader("twelve bytes"))
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if size, err := store.Size(hash); err != nil || size != 12 {
+		t.Errorf("Size = %d, %v; want 12, nil", size, err)
+	}
+	if _, err := store.Size("0000000000000000000000000000000000000000000000000000000000000000"); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("Size of missing blob: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestDiskBlobStorage_Stage(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewDiskBlobStorage(dir)
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+
+	staged, err := store.Stage(strings.NewReader("staged"))
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	if staged.Size() != 6 {
+		t.Errorf("size = %d, want 6", staged.Size())
+	}
+	if store.Exists(staged.Hash()) {
+		t.Error("staged blob should not exist before commit")
+	}
+	if err := staged.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if !store.Exists(staged.Hash()) {
+		t.Error("committed blob should exist")
+	}
+	if err := staged.Discard(); err != nil || !store.Exists(staged.Hash()) {
+		t.Errorf("Discard after Commit should keep the blob, err = %v", err)
+	}
+
+	discarded, err := store.Stage(strings.NewReader("discarded"))
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	if err := discarded.Discard(); err != nil {
+		t.Fatalf("Discard: %v", err)
+	}
+	if store.Exists(discarded.Hash()) {
+		t.Error("discarded blob should not exist")
+	}
+	entries, _ := os.ReadDir(dir + "/tmp")
+	if len(entries) != 0 {
+		t.Errorf("expected no temp files after discard, found %d", len(entries))
+	}
+
+	// Discarding a duplicate of a stored blob leaves the stored copy alone.
+	dup, _ := store.Stage(strings.NewReader("staged"))
+	dup.Discard()
+	if !store.Exists(staged.Hash()) {
+		t.Error("discarding a duplicate removed the stored blob")
+	}
+}
+
+func TestDiskBlobStorage_CleanTemp(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewDiskBlobStorage(dir)
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+
+	// Uploads abandoned by a crash: one long ago, one just now.
+	store.Stage(strings.NewReader("crashed"))
+	old, _ := filepath.Glob(filepath.Join(dir, "tmp", "upload-*"))
+	longAgo := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(old[0], longAgo, longAgo)
+	store.Stage(strings.NewReader("in flight"))
+	os.WriteFile(filepath.Join(dir, "tmp", "other"), []byte("x"), 0o644)
+	os.Chtimes(filepath.Join(dir, "tmp", "other"), longAgo, longAgo)
+
+	files, reclaimed, err := store.CleanTemp(time.Now().Add(-time.Hour))
+	if err != nil || files != 1 || reclaimed != 7 {
+		t.Errorf("CleanTemp = %d, %d, %v; want 1 file of 7 bytes", files, reclaimed, err)
+	}
+	if _, err := os.Stat(old[0]); !os.IsNotExist(err) {
+		t.Error("stale upload should be removed")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(entries) != 2 {
+		t.Errorf("recent uploads and other files should stay, found %d entries", len(entries))
+	}
+}
+
+func TestDiskBlobStorage_Pipeline(t *testing.T) {
+	// Small buffers push blobs of every shape through several of them.
+	store, err := NewDiskBlobStorage(t.TempDir(), WithWriteBuffers(16, 2), WithPreallocation(40))
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	for _, n := range []int{0, 1, 15, 16, 17, 32, 100, 1000} {
+		data := make([]byte, n)
+		rand.New(rand.NewSource(int64(n))).Read(data)
+		sum := sha256.Sum256(data)
+		want := hex.EncodeToString(sum[:])
+
+		// A reader returning a few bytes at a time fills buffers over
+		// several reads.
+		hash, size, err := store.Store(iotest.HalfReader(bytes.NewReader(data)))
+		if err != nil || hash != want || size != int64(n) {
+			t.Errorf("Store(%d bytes) = %s, %d, %v; want %s", n, hash, size, err, want)
+			continue
+		}
+		f, _ := store.Open(hash)
+		got, _ := io.ReadAll(f)
+		f.Close()
+		if !bytes.Equal(got, data) {
+			t.Errorf("%d bytes: stored content differs", n)
+		}
+		if info, _ := os.Stat(store.BlobPath(hash)); info.Size() != int64(n) {
+			t.Errorf("%d bytes: file is %d bytes after preallocation", n, info.Size())
+		}
+	}
+
+	failing := iotest.TimeoutReader(bytes.NewReader(make([]byte, 100)))
+	if _, _, err := store.Store(io.MultiReader(strings.NewReader("partial"), failing, failing)); err == nil {
+		t.Error("expected the source's error")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(store.dataDir, "tmp")); len(entries) != 0 {
+		t.Errorf("a failed store left %d temp files", len(entries))
+	}
+
+	stats := store.WriteStats()
+	if stats.Blobs != 8 || stats.Errors != 1 || stats.Bytes != 1181 || stats.BufferSize != 16 || stats.Buffers != 2 {
+		t.Errorf("WriteStats = %+v; want 8 blobs of 1181 bytes, 1 error, 2 buffers of 16", stats)
+	}
+}
+
+func BenchmarkDiskBlobStorage_Store(b *testing.B) {
+	data := make([]byte, 64<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	for _, bc := range []struct {
+		name          string
+		size, buffers int
+	}{
+		{"32KiBx2", 32 << 10, 2},
+		{"1MiBx4", 1 << 20, 4},
+		{"4MiBx8", 4 << 20, 8},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			store, err := NewDiskBlobStorage(b.TempDir(), WithWriteBuffers(bc.size, bc.buffers))
+			if err != nil {
+				b.Fatalf("NewDiskBlobStorage: %v", err)
+			}
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				// Vary the first byte so every iteration writes a new blob.
+				data[0] = byte(i)
+				hash, _, err := store.Store(bytes.NewReader(data))
+				if err != nil {
+					b.Fatalf("Store: %v", err)
+				}
+				store.Delete(hash)
+			}
+		})
+	}
+}
+
+func TestDiskBlobStorage_StageFile(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewDiskBlobStorage(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	src := filepath.Join(dir, "share", "app.tar.gz")
+	os.MkdirAll(filepath.Dir(src), 0o755)
+	os.WriteFile(src, []byte("shared file"), 0o644)
+
+	// Without link, the blob never shares the original's inode.
+	staged, mode, err := store.StageFile(src, false)
+	if err != nil {
+		t.Fatalf("StageFile: %v", err)
+	}
+	sum := sha256.Sum256([]byte("shared file"))
+	if staged.Hash() != hex.EncodeToString(sum[:]) || staged.Size() != 11 {
+		t.Errorf("staged %s, %d bytes", staged.Hash(), staged.Size())
+	}
+	if mode != models.ImportReflink && mode != models.ImportCopy {
+		t.Errorf("mode = %s, want reflink or copy without link", mode)
+	}
+	staged.Discard()
+
+	staged, mode, err = store.StageFile(src, true)
+	if err != nil {
+		t.Fatalf("StageFile: %v", err)
+	}
+	if mode != models.ImportReflink && mode != models.ImportHardLink {
+		t.Errorf("mode = %s, want reflink or hardlink on one filesystem", mode)
+	}
+	if err := staged.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	blob, _ := os.Stat(store.BlobPath(staged.Hash()))
+	orig, err := os.Stat(src)
+	if err != nil {
+		t.Fatal("importing removed the original")
+	}
+	if linked := os.SameFile(blob, orig); linked != (mode == models.ImportHardLink) {
+		t.Errorf("blob and original are the same file: %v, with mode %s", linked, mode)
+	}
+
+	// Discarding leaves the original alone.
+	other := filepath.Join(dir, "share", "other.tar.gz")
+	os.WriteFile(other, []byte("another file"), 0o644)
+	staged, _, err = store.StageFile(other, true)
+	if err != nil {
+		t.Fatalf("StageFile: %v", err)
+	}
+	staged.Discard()
+	if _, err := os.Stat(other); err != nil {
+		t.Error("discarding removed the original")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "data", "tmp")); len(entries) != 0 {
+		t.Errorf("discard left %d temp files", len(entries))
+	}
+
+	if _, _, err := store.StageFile(filepath.Join(dir, "missing"), false); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,504 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/chunking"
+	"github.com/foundry/registry/internal/util/diskspace"
+	"github.com/foundry/registry/internal/util/hashing"
+)
+
+// ChunkedBlobStorage splits blobs into content-defined chunks and stores each
+// chunk content-addressed, so blobs that differ slightly share most of their
+// bytes on disk. Each blob is recorded as a manifest listing its chunks.
+//
+// Layout:
+//
+//	<dataDir>/chunks/<first2>/<chunk_sha256>
+//	<dataDir>/manifests/<first2>/<blob_sha256>
+//	<dataDir>/staging/<random>
+//
+// Staged uploads keep their manifest under staging until committed; those
+// manifests count as references, so pruning leaves their chunks alone.
+//
+// Every chunk's references, one per manifest entry naming it, are counted
+// in memory from the manifests found when the store is opened, and kept up
+// to date as uploads are staged, committed, discarded and deleted. A chunk
+// is removed when its count drops to zero.
+type ChunkedBlobStorage struct {
+	dataDir string
+	cfg     chunking.Config
+
+	// mu guards refs and is held while a chunk or manifest is removed or
+	// a manifest committed, never while an upload is read or written, so
+	// a slow upload holds up nothing else.
+	mu   sync.Mutex
+	refs map[string]int
+}
+
+type chunkManifest struct {
+	Size   int64        `json:"size"`
+	Chunks []chunkEntry `json:"chunks"`
+}
+
+type chunkEntry struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// NewChunkedBlobStorage creates a new ChunkedBlobStorage.
+func NewChunkedBlobStorage(dataDir string, cfg chunking.Config) (*ChunkedBlobStorage, error) {
+	// Validate the chunk bounds up front rather than on the first upload.
+	if _, err := chunking.NewChunker(strings.NewReader(""), cfg); err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{"chunks", "manifests", "staging"} {
+		if err := os.MkdirAll(filepath.Join(dataDir, dir), 0o755); err != nil {
+			return nil, fmt.Errorf("creating %s directory: %w", dir, err)
+		}
+	}
+	s := &ChunkedBlobStorage{dataDir: dataDir, cfg: cfg}
+	if err := s.countRefs(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// countRefs counts the chunk references of every committed and staged
+// manifest. Staged manifests a crash left unreadable are skipped; CleanTemp
+// removes them, and the chunks only they used once they are old enough.
+func (s *ChunkedBlobStorage) countRefs() error {
+	hashes, err := s.ListBlobs()
+	if err != nil {
+		return err
+	}
+	s.refs = make(map[string]int)
+	for _, h := range hashes {
+		m, err := readManifestFile(s.BlobPath(h))
+		if err != nil {
+			return err
+		}
+		s.addRefs(m.Chunks)
+	}
+	staged, err := os.ReadDir(filepath.Join(s.dataDir, "staging"))
+	if err != nil {
+		return fmt.Errorf("reading staging directory: %w", err)
+	}
+	for _, entry := range staged {
+		if m, err := readManifestFile(filepath.Join(s.dataDir, "staging", entry.Name())); err == nil {
+			s.addRefs(m.Chunks)
+		}
+	}
+	return nil
+}
+
+// addRefs counts one reference to each of chunks. The caller must hold
+// mu, except while the store is being opened.
+func (s *ChunkedBlobStorage) addRefs(chunks []chunkEntry) {
+	for _, c := range chunks {
+		s.refs[c.Hash]++
+	}
+}
+
+// releaseRefs drops one reference to each of chunks and removes those no
+// manifest uses any more, returning the bytes freed. The caller must hold
+// mu.
+func (s *ChunkedBlobStorage) releaseRefs(chunks []chunkEntry) (int64, error) {
+	var freed int64
+	var firstErr error
+	for _, c := range chunks {
+		if s.refs[c.Hash]--; s.refs[c.Hash] > 0 {
+			continue
+		}
+		delete(s.refs, c.Hash)
+		if err := os.Remove(s.chunkPath(c.Hash)); err != nil {
+			if !os.IsNotExist(err) && firstErr == nil {
+				firstErr = fmt.Errorf("deleting chunk: %w", err)
+			}
+			continue
+		}
+		freed += c.Size
+	}
+	return freed, firstErr
+}
+
+// Store splits r into chunks, writes any chunks not already present, and
+// records a manifest under the SHA256 of the whole stream.
+func (s *ChunkedBlobStorage) Store(r io.Reader) (string, int64, error) {
+	staged, err := s.Stage(r)
+	if err != nil {
+		return "", 0, err
+	}
+	return commitStaged(staged)
+}
+
+// Stage writes r's chunks like Store but records the manifest under
+// staging. Committing moves it into place; discarding removes it and
+// prunes the chunks only it used. Each chunk is referenced before it is
+// written, so a concurrent delete cannot prune it from under the upload.
+func (s *ChunkedBlobStorage) Stage(r io.Reader) (services.StagedBlob, error) {
+	chunker, err := chunking.NewChunker(r, s.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	whole := sha256.New()
+	var manifest chunkManifest
+	fail := func(err error) (services.StagedBlob, error) {
+		s.mu.Lock()
+		s.releaseRefs(manifest.Chunks)
+		s.mu.Unlock()
+		return nil, err
+	}
+	for {
+		chunk, err := chunker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fail(fmt.Errorf("chunking upload: %w", err))
+		}
+		whole.Write(chunk)
+
+		sum := sha256.Sum256(chunk)
+		entry := chunkEntry{Hash: hex.EncodeToString(sum[:]), Size: int64(len(chunk))}
+		s.mu.Lock()
+		s.addRefs([]chunkEntry{entry})
+		s.mu.Unlock()
+		manifest.Chunks = append(manifest.Chunks, entry)
+		manifest.Size += entry.Size
+		if err := s.writeChunk(entry.Hash, chunk); err != nil {
+			return fail(err)
+		}
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fail(fmt.Errorf("encoding chunk manifest: %w", err))
+	}
+	tmp, err := os.CreateTemp(filepath.Join(s.dataDir, "staging"), "upload-*")
+	if err != nil {
+		return fail(fmt.Errorf("creating staged manifest: %w", err))
+	}
+	path := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(path)
+		return fail(fmt.Errorf("writing staged manifest: %w", err))
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(path)
+		return fail(fmt.Errorf("writing staged manifest: %w", err))
+	}
+	return &stagedManifest{
+		store:    s,
+		path:     path,
+		hash:     hex.EncodeToString(whole.Sum(nil)),
+		manifest: manifest,
+	}, nil
+}
+
+// stagedManifest is a blob whose chunks are written and whose manifest
+// waits under staging.
+type stagedManifest struct {
+	store    *ChunkedBlobStorage
+	path     string
+	hash     string
+	manifest chunkManifest
+	done     bool
+}
+
+func (m *stagedManifest) Hash() string { return m.hash }
+func (m *stagedManifest) Size() int64  { return m.manifest.Size }
+
+// Commit moves the staged manifest into place, its chunk references with
+// it. If the blob is already stored, the staged manifest is dropped along
+// with its references, which the stored manifest's keep from reaching
+// zero. Whoever removes the staged manifest file releases its
+// references, so they are released once even if CleanTemp gets to it
+// first.
+func (m *stagedManifest) Commit() error {
+	if m.done {
+		return nil
+	}
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Exists(m.hash) {
+		m.done = true
+		return m.dropLocked()
+	}
+	final := s.BlobPath(m.hash)
+	if err := os.MkdirAll(filepath.Dir(final), 0o755); err != nil {
+		return fmt.Errorf("creating manifest subdirectory: %w", err)
+	}
+	if err := os.Rename(m.path, final); err != nil {
+		return fmt.Errorf("writing chunk manifest: %w", err)
+	}
+	m.done = true
+	return nil
+}
+
+// Discard removes the staged manifest and prunes the chunks that only this
+// upload used, without looking at any other manifest.
+func (m *stagedManifest) Discard() error {
+	if m.done {
+		return nil
+	}
+	m.done = true
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return m.dropLocked()
+}
+
+// dropLocked removes the staged manifest and releases its references if
+// it was still there. The caller must hold mu.
+func (m *stagedManifest) dropLocked() error {
+	if err := os.Remove(m.path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("removing staged manifest: %w", err)
+	}
+	_, err := m.store.releaseRefs(m.manifest.Chunks)
+	return err
+}
+
+// Open returns a ReadCloser that streams the blob's chunks in order.
+func (s *ChunkedBlobStorage) Open(hash string) (io.ReadCloser, error) {
+	manifest, err := s.readManifest(hash)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{store: s, chunks: manifest.Chunks}, nil
+}
+
+// Exists checks if a manifest exists for the blob.
+func (s *ChunkedBlobStorage) Exists(hash string) bool {
+	_, err := os.Stat(s.BlobPath(hash))
+	return err == nil
+}
+
+// Size returns the blob length recorded in its manifest.
+func (s *ChunkedBlobStorage) Size(hash string) (int64, error) {
+	manifest, err := s.readManifest(hash)
+	if err != nil {
+		return 0, err
+	}
+	return manifest.Size, nil
+}
+
+// Delete removes a blob's manifest and any chunks no other manifest uses.
+func (s *ChunkedBlobStorage) Delete(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	manifest, err := s.readManifest(hash)
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Remove(s.BlobPath(hash)); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("deleting chunk manifest: %w", err)
+	}
+	_, err = s.releaseRefs(manifest.Chunks)
+	return err
+}
+
+// BlobPath returns the manifest path for a given hash.
+func (s *ChunkedBlobStorage) BlobPath(hash string) string {
+	return filepath.Join(s.dataDir, "manifests", hashing.BlobDir(hash), hash)
+}
+
+// ListBlobs returns all blob hashes that have a manifest.
+func (s *ChunkedBlobStorage) ListBlobs() ([]string, error) {
+	return listHashDir(filepath.Join(s.dataDir, "manifests"))
+}
+
+// FreeSpace returns the bytes available on the data directory's filesystem.
+func (s *ChunkedBlobStorage) FreeSpace() (int64, error) {
+	return diskspace.Free(s.dataDir)
+}
+
+// CleanTemp removes the staged manifests last written before before, and
+// the chunks only they used. Manifests a crash left unreadable are removed
+// too, as are chunks written before before that no manifest uses, such as
+// those of an upload a crash cut short.
+func (s *ChunkedBlobStorage) CleanTemp(before time.Time) (int, int64, error) {
+	stale, err := staleUploads(filepath.Join(s.dataDir, "staging"), before)
+	if err != nil {
+		return 0, 0, err
+	}
+	var files int
+	var bytes int64
+	for _, f := range stale {
+		m, readErr := readManifestFile(f.path)
+		s.mu.Lock()
+		err := os.Remove(f.path)
+		if err == nil && readErr == nil {
+			var freed int64
+			freed, err = s.releaseRefs(m.Chunks)
+			bytes += freed
+		}
+		s.mu.Unlock()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return files, bytes, fmt.Errorf("removing stale upload: %w", err)
+		}
+		files++
+		bytes += f.size
+	}
+
+	chunks, err := listHashDir(filepath.Join(s.dataDir, "chunks"))
+	if err != nil {
+		return files, bytes, err
+	}
+	for _, hash := range chunks {
+		info, err := os.Stat(s.chunkPath(hash))
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+		s.mu.Lock()
+		if s.refs[hash] == 0 && os.Remove(s.chunkPath(hash)) == nil {
+			bytes += info.Size()
+		}
+		s.mu.Unlock()
+	}
+	return files, bytes, nil
+}
+
+func (s *ChunkedBlobStorage) chunkPath(hash string) string {
+	return filepath.Join(s.dataDir, "chunks", hashing.BlobDir(hash), hash)
+}
+
+func (s *ChunkedBlobStorage) writeChunk(hash string, data []byte) error {
+	p := s.chunkPath(hash)
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+	if err := s.writeAtomic(p, data); err != nil {
+		return fmt.Errorf("writing chunk: %w", err)
+	}
+	return nil
+}
+
+// writeAtomic writes data to a temp file and renames it into place. A
+// concurrent writer winning the rename is fine since the content is identical.
+func (s *ChunkedBlobStorage) writeAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		if _, statErr := os.Stat(path); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *ChunkedBlobStorage) readManifest(hash string) (*chunkManifest, error) {
+	m, err := readManifestFile(s.BlobPath(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: blob %s", services.ErrNotFound, hash)
+	}
+	return m, err
+}
+
+// readManifestFile decodes the manifest at path. A missing file is
+// reported with an error wrapping os.ErrNotExist.
+func readManifestFile(path string) (*chunkManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("reading chunk manifest: %w", err)
+	}
+	var m chunkManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("decoding chunk manifest %s: %w", filepath.Base(path), err)
+	}
+	return &m, nil
+}
+
+// chunkReader streams a blob by opening its chunks one at a time.
+type chunkReader struct {
+	store   *ChunkedBlobStorage
+	chunks  []chunkEntry
+	current *os.File
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if cr.current == nil {
+			if len(cr.chunks) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(cr.store.chunkPath(cr.chunks[0].Hash))
+			if err != nil {
+				if os.IsNotExist(err) {
+					return 0, fmt.Errorf("%w: chunk %s", services.ErrNotFound, cr.chunks[0].Hash)
+				}
+				return 0, fmt.Errorf("opening chunk: %w", err)
+			}
+			cr.current = f
+			cr.chunks = cr.chunks[1:]
+		}
+
+		n, err := cr.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			cr.current.Close()
+			cr.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (cr *chunkReader) Close() error {
+	if cr.current != nil {
+		err := cr.current.Close()
+		cr.current = nil
+		return err
+	}
+	return nil
+}
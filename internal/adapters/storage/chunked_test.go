@@ -0,0 +1,375 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/foundry/registry/internal/util/chunking"
+)
+
+var testChunking = chunking.Config{MinSize: 1 << 10, AvgSize: 4 << 10, MaxSize: 16 << 10}
+
+func newTestChunkedStore(t *testing.T) (*ChunkedBlobStorage, string) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := NewChunkedBlobStorage(dir, testChunking)
+	if err != nil {
+		t.Fatalf("NewChunkedBlobStorage: %v", err)
+	}
+	return store, dir
+}
+
+func countChunks(t *testing.T, dir string) int {
+	t.Helper()
+	hashes, err := listHashDir(filepath.Join(dir, "chunks"))
+	if err != nil {
+		t.Fatalf("listing chunks: %v", err)
+	}
+	return len(hashes)
+}
+
+func TestChunkedBlobStorage_StoreAndOpen(t *testing.T) {
+	store, _ := newTestChunkedStore(t)
+
+	content := make([]byte, 200<<10)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	hash, size, err := store.Store(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if size != int64(len(content)) {
+		t.Errorf("size = %d, want %d", size, len(content))
+	}
+	if !store.Exists(hash) {
+		t.Error("Exists returned false for stored blob")
+	}
+	if got, err := store.Size(hash); err != nil || got != size {
+		t.Errorf("Size = %d, %v; want %d", got, err, size)
+	}
+
+	rc, err := store.Open(hash)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading blob: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("content mismatch after chunked round trip")
+	}
+}
+
+func TestChunkedBlobStorage_MatchesDiskHash(t *testing.T) {
+	chunked, _ := newTestChunkedStore(t)
+	disk, err := NewDiskBlobStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+
+	content := []byte("same digest regardless of backend")
+	h1, _, _ := chunked.Store(bytes.NewReader(content))
+	h2, _, _ := disk.Store(bytes.NewReader(content))
+	if h1 != h2 {
+		t.Errorf("chunked hash %s differs from disk hash %s", h1, h2)
+	}
+}
+
+func TestChunkedBlobStorage_SharesChunksBetweenVersions(t *testing.T) {
+	store, dir := newTestChunkedStore(t)
+
+	v1 := make([]byte, 512<<10)
+	rand.New(rand.NewSource(2)).Read(v1)
+	v2 := append([]byte(nil), v1...)
+	copy(v2[len(v2)/2:], []byte("patched"))
+
+	if _, _, err := store.Store(bytes.NewReader(v1)); err != nil {
+		t.Fatalf("Store v1: %v", err)
+	}
+	afterV1 := countChunks(t, dir)
+
+	if _, _, err := store.Store(bytes.NewReader(v2)); err != nil {
+		t.Fatalf("Store v2: %v", err)
+	}
+	added := countChunks(t, dir) - afterV1
+	if added > 2 {
+		t.Errorf("expected a small edit to add at most 2 chunks, added %d of %d", added, afterV1)
+	}
+}
+
+func TestChunkedBlobStorage_DeleteKeepsSharedChunks(t *testing.T) {
+	store, dir := newTestChunkedStore(t)
+
+	v1 := make([]byte, 128<<10)
+	rand.New(rand.NewSource(3)).Read(v1)
+	v2 := append(append([]byte(nil), v1...), []byte("trailer")...)
+
+	h1, _, _ := store.Store(bytes.NewReader(v1))
+	h2, _, _ := store.Store(bytes.NewReader(v2))
+
+	if err := store.Delete(h1); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if store.Exists(h1) {
+		t.Error("deleted blob should not exist")
+	}
+
+	rc, err := store.Open(h2)
+	if err != nil {
+		t.Fatalf("Open remaining blob: %v", err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("reading remaining blob: %v", err)
+	}
+	if !bytes.Equal(data, v2) {
+		t.Error("remaining blob corrupted after deleting a sibling")
+	}
+
+	if err := store.Delete(h2); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if n := countChunks(t, dir); n != 0 {
+		t.Errorf("expected all chunks pruned, %d remain", n)
+	}
+}
+
+func TestChunkedBlobStorage_ListBlobs(t *testing.T) {
+	store, _ := newTestChunkedStore(t)
+
+	h1, _, _ := store.Store(bytes.NewReader([]byte("file1")))
+	h2, _, _ := store.Store(bytes.NewReader([]byte("file2")))
+
+	blobs, err := store.ListBlobs()
+	if err != nil {
+		t.Fatalf("ListBlobs: %v", err)
+	}
+	found := make(map[string]bool)
+	for _, b := range blobs {
+		found[b] = true
+	}
+	if !found[h1] || !found[h2] || len(blobs) != 2 {
+		t.Errorf("ListBlobs = %v, want [%s %s]", blobs, h1, h2)
+	}
+}
+
+func TestChunkedBlobStorage_OpenNonExistent(t *testing.T) {
+	store, dir := newTestChunkedStore(t)
+
+	if _, err := store.Open("0000000000000000000000000000000000000000000000000000000000000000"); err == nil {
+		t.Error("expected error opening non-existent blob")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blobs")); !os.IsNotExist(err) {
+		t.Error("chunked storage should not create the plain blobs directory")
+	}
+}
+
+func TestChunkedBlobStorage_Stage(t *testing.T) {
+	store, dir := newTestChunkedStore(t)
+
+	v1 := make([]byte, 128<<10)
+	rand.New(rand.NewSource(4)).Read(v1)
+	v2 := append(append([]byte(nil), v1...), []byte("trailer")...)
+
+	staged, err := store.Stage(bytes.NewReader(v1))
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	if store.Exists(staged.Hash()) {
+		t.Error("staged blob should not exist before commit")
+	}
+	if blobs, _ := store.ListBlobs(); len(blobs) != 0 {
+		t.Errorf("staged blob should not be listed, got %v", blobs)
+	}
+	// Deleting another blob must not prune chunks a staged upload uses.
+	other, _, _ := store.Store(bytes.NewReader([]byte("other")))
+	if err := store.Delete(other); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := staged.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	rc, err := store.Open(staged.Hash())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(data, v1) {
+		t.Error("committed blob corrupted")
+	}
+	afterCommit := countChunks(t, dir)
+
+	// Discarding prunes only the chunks no other manifest uses.
+	discarded, err := store.Stage(bytes.NewReader(v2))
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	if err := discarded.Discard(); err != nil {
+		t.Fatalf("Discard: %v", err)
+	}
+	if store.Exists(discarded.Hash()) {
+		t.Error("discarded blob should not exist")
+	}
+	if n := countChunks(t, dir); n != afterCommit {
+		t.Errorf("expected %d chunks after discard, got %d", afterCommit, n)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "staging")); len(entries) != 0 {
+		t.Errorf("expected no staged manifests, found %d", len(entries))
+	}
+}
+
+func TestChunkedBlobStorage_CleanTemp(t *testing.T) {
+	store, dir := newTestChunkedStore(t)
+
+	kept := make([]byte, 64<<10)
+	rand.New(rand.NewSource(5)).Read(kept)
+	crashed := append(append([]byte(nil), kept...), make([]byte, 64<<10)...)
+	rand.New(rand.NewSource(6)).Read(crashed[len(kept):])
+
+	hash, _, err := store.Store(bytes.NewReader(kept))
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	before := countChunks(t, dir)
+	// An upload a crash left staged, sharing its first chunks with kept,
+	// and a manifest a crash left half-written.
+	store.Stage(bytes.NewReader(crashed))
+	os.WriteFile(filepath.Join(dir, "staging", "upload-torn"), []byte(`{"size":`), 0o644)
+	longAgo := time.Now().Add(-48 * time.Hour)
+	staged, _ := filepath.Glob(filepath.Join(dir, "staging", "upload-*"))
+	for _, path := range staged {
+		os.Chtimes(path, longAgo, longAgo)
+	}
+	if countChunks(t, dir) <= before {
+		t.Fatal("staged upload should have written new chunks")
+	}
+
+	files, reclaimed, err := store.CleanTemp(time.Now().Add(-time.Hour))
+	if err != nil || files != 2 || reclaimed <= 0 {
+		t.Errorf("CleanTemp = %d, %d, %v; want 2 files", files, reclaimed, err)
+	}
+	if n := countChunks(t, dir); n != before {
+		t.Errorf("expected the crashed upload's own chunks gone, %d chunks left, want %d", n, before)
+	}
+	rc, err := store.Open(hash)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(data, kept) {
+		t.Error("committed blob corrupted by cleanup")
+	}
+}
+
+func TestChunkedBlobStorage_DeleteDuringStage(t *testing.T) {
+	store, _ := newTestChunkedStore(t)
+
+	shared := make([]byte, 64<<10)
+	rand.New(rand.NewSource(7)).Read(shared)
+	hash, _, err := store.Store(bytes.NewReader(shared))
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	// An upload that sends the same content and then stalls.
+	pr, pw := io.Pipe()
+	staged := make(chan error, 1)
+	go func() {
+		s, err := store.Stage(pr)
+		if err == nil {
+			err = s.Commit()
+		}
+		staged <- err
+	}()
+	pw.Write(shared)
+
+	deleted := make(chan error, 1)
+	go func() { deleted <- store.Delete(hash) }()
+	select {
+	case err := <-deleted:
+		if err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Delete blocked behind a stalled upload")
+	}
+
+	pw.Close()
+	if err := <-staged; err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	rc, err := store.Open(hash)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	blob, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(blob, shared) {
+		t.Error("upload lost chunks deleted while it was staging")
+	}
+}
+
+func TestChunkedBlobStorage_ReopenCountsRefs(t *testing.T) {
+	store, dir := newTestChunkedStore(t)
+
+	v1 := make([]byte, 128<<10)
+	rand.New(rand.NewSource(8)).Read(v1)
+	v2 := append(append([]byte(nil), v1...), []byte("trailer")...)
+	h1, _, _ := store.Store(bytes.NewReader(v1))
+	if _, err := store.Stage(bytes.NewReader(v2)); err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+
+	reopened, err := NewChunkedBlobStorage(dir, testChunking)
+	if err != nil {
+		t.Fatalf("NewChunkedBlobStorage: %v", err)
+	}
+	if err := reopened.Delete(h1); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	// The staged upload still uses the chunks it shares with v1.
+	if n := countChunks(t, dir); n == 0 {
+		t.Error("deleting after reopen pruned chunks a staged upload uses")
+	}
+	files, _, err := reopened.CleanTemp(time.Now().Add(time.Hour))
+	if err != nil || files != 1 {
+		t.Fatalf("CleanTemp = %d, %v; want 1 file", files, err)
+	}
+	if n := countChunks(t, dir); n != 0 {
+		t.Errorf("expected all chunks pruned, %d remain", n)
+	}
+}
+
+func TestChunkedBlobStorage_DiscardReleasesOwnChunks(t *testing.T) {
+	store, dir := newTestChunkedStore(t)
+
+	kept, _, _ := store.Store(bytes.NewReader([]byte("kept")))
+	before := countChunks(t, dir)
+	rejected := make([]byte, 64<<10)
+	rand.New(rand.NewSource(9)).Read(rejected)
+	staged, err := store.Stage(bytes.NewReader(rejected))
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	// Discarding must not read other manifests, so one it cannot read
+	// does not get in the way.
+	os.WriteFile(store.BlobPath(kept), []byte(`{"size":`), 0o644)
+
+	if err := staged.Discard(); err != nil {
+		t.Fatalf("Discard: %v", err)
+	}
+	if n := countChunks(t, dir); n != before {
+		t.Errorf("expected %d chunks after discard, got %d", before, n)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package storage
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst share the blocks of the file at src, on filesystems
+// that support it such as Btrfs and XFS.
+func cloneFile(dst *os.File, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return unix.IoctlFileClone(int(dst.Fd()), int(f.Fd()))
+}
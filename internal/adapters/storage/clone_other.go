@@ -0,0 +1,13 @@
+//go:build !linux
+
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is unsupported where files cannot share blocks.
+func cloneFile(*os.File, string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/hashing"
+)
+
+// StageFile stages the local file at path without copying it when it can:
+// it is cloned into the temp directory where the filesystem shares blocks
+// between files, and copied otherwise. With link set, a file that cannot
+// be cloned is hard-linked instead of copied where both are on one
+// filesystem. It reports which it did.
+//
+// A hard-linked blob is the same file as the original, so the original
+// must not be modified afterwards; it can be deleted. A clone or copy is
+// independent of it.
+func (s *DiskBlobStorage) StageFile(path string, link bool) (services.StagedBlob, string, error) {
+	tmpDir := filepath.Join(s.dataDir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return nil, "", fmt.Errorf("creating temp directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(tmpDir, "upload-*")
+	if err != nil {
+		return nil, "", fmt.Errorf("creating temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	mode := models.ImportReflink
+	cloneErr := cloneFile(tmp, path)
+	tmp.Close()
+	if cloneErr != nil {
+		os.Remove(tmpPath)
+		if !link || os.Link(path, tmpPath) != nil {
+			return s.copyFile(path)
+		}
+		mode = models.ImportHardLink
+	}
+
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, "", fmt.Errorf("opening imported file: %w", err)
+	}
+	defer f.Close()
+	h, size, err := hashing.ComputeSHA256(f)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, "", err
+	}
+	return &stagedFile{tmpPath: tmpPath, finalPath: s.BlobPath(h), hash: h, size: size}, mode, nil
+}
+
+// copyFile stages a copy of the file at path.
+func (s *DiskBlobStorage) copyFile(path string) (services.StagedBlob, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("opening imported file: %w", err)
+	}
+	defer f.Close()
+	staged, err := s.Stage(f)
+	return staged, models.ImportCopy, err
+}
+
+// StageFile stages a local file in the hot tier, where new blobs go, if
+// the hot tier can take files in without copying them.
+func (s *TieredBlobStorage) StageFile(path string, link bool) (services.StagedBlob, string, error) {
+	importer, ok := s.hot.(services.BlobImporter)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: hot tier cannot import files", errors.ErrUnsupported)
+	}
+	staged, mode, err := importer.StageFile(path, link)
+	if err != nil {
+		return nil, "", err
+	}
+	return &tieredStaged{StagedBlob: staged, cold: s.cold}, mode, nil
+}
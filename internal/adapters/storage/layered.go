@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// LayeredBlobStorage serves the blobs of read-only snapshots of other
+// registries beneath a live backend. Reads look in the live backend first
+// and then in each snapshot in order; everything else, writes, deletes and
+// listing included, sees only the live backend, so garbage collection
+// never touches a snapshot.
+type LayeredBlobStorage struct {
+	live      services.BlobStorage
+	snapshots []services.BlobStorage
+}
+
+// NewLayeredBlobStorage layers snapshots beneath live.
+func NewLayeredBlobStorage(live services.BlobStorage, snapshots ...services.BlobStorage) *LayeredBlobStorage {
+	return &LayeredBlobStorage{live: live, snapshots: snapshots}
+}
+
+// Store writes data to the live backend.
+func (s *LayeredBlobStorage) Store(r io.Reader) (string, int64, error) {
+	return s.live.Store(r)
+}
+
+// Stage stages data in the live backend.
+func (s *LayeredBlobStorage) Stage(r io.Reader) (services.StagedBlob, error) {
+	return s.live.Stage(r)
+}
+
+// holder returns the first layer holding hash, or nil.
+func (s *LayeredBlobStorage) holder(hash string) services.BlobStorage {
+	if s.live.Exists(hash) {
+		return s.live
+	}
+	for _, b := range s.snapshots {
+		if b.Exists(hash) {
+			return b
+		}
+	}
+	return nil
+}
+
+// Open opens a blob from the first layer holding it.
+func (s *LayeredBlobStorage) Open(hash string) (io.ReadCloser, error) {
+	rc, err := s.live.Open(hash)
+	if !errors.Is(err, services.ErrNotFound) {
+		return rc, err
+	}
+	for _, b := range s.snapshots {
+		rc, err := b.Open(hash)
+		if !errors.Is(err, services.ErrNotFound) {
+			return rc, err
+		}
+	}
+	return nil, err
+}
+
+// Exists reports whether any layer holds the blob.
+func (s *LayeredBlobStorage) Exists(hash string) bool {
+	return s.holder(hash) != nil
+}
+
+// Size returns the length of a blob in the first layer holding it.
+func (s *LayeredBlobStorage) Size(hash string) (int64, error) {
+	size, err := s.live.Size(hash)
+	if !errors.Is(err, services.ErrNotFound) {
+		return size, err
+	}
+	for _, b := range s.snapshots {
+		size, err := b.Size(hash)
+		if !errors.Is(err, services.ErrNotFound) {
+			return size, err
+		}
+	}
+	return 0, err
+}
+
+// Delete removes a blob from the live backend. Snapshots are never
+// changed.
+func (s *LayeredBlobStorage) Delete(hash string) error {
+	return s.live.Delete(hash)
+}
+
+// BlobPath returns the blob's path in the layer holding it, or in the live
+// backend if none does.
+func (s *LayeredBlobStorage) BlobPath(hash string) string {
+	if b := s.holder(hash); b != nil {
+		return b.BlobPath(hash)
+	}
+	return s.live.BlobPath(hash)
+}
+
+// ListBlobs returns the hashes held by the live backend.
+func (s *LayeredBlobStorage) ListBlobs() ([]string, error) {
+	return s.live.ListBlobs()
+}
+
+// FreeSpace returns the room left in the live backend, where new blobs go.
+func (s *LayeredBlobStorage) FreeSpace() (int64, error) {
+	sr, ok := s.live.(services.SpaceReporter)
+	if !ok {
+		return 0, fmt.Errorf("%w: live backend cannot report free space", errors.ErrUnsupported)
+	}
+	return sr.FreeSpace()
+}
+
+// CleanTemp cleans the live backend's temp files, if it keeps any.
+func (s *LayeredBlobStorage) CleanTemp(before time.Time) (int, int64, error) {
+	cleaner, ok := s.live.(services.TempCleaner)
+	if !ok {
+		return 0, 0, nil
+	}
+	return cleaner.CleanTemp(before)
+}
+
+// WriteStats returns the live backend's write stats, if it keeps them.
+func (s *LayeredBlobStorage) WriteStats() models.BlobWriteStats {
+	return sumWriteStats([]services.BlobStorage{s.live})
+}
+
+// StageFile stages a local file in the live backend if it can take files
+// in without copying them.
+func (s *LayeredBlobStorage) StageFile(path string, link bool) (services.StagedBlob, string, error) {
+	importer, ok := s.live.(services.BlobImporter)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: live backend cannot import files", errors.ErrUnsupported)
+	}
+	return importer.StageFile(path, link)
+}
+
+// SignedURL signs a download URL with the layer holding the blob, if it
+// can sign URLs.
+func (s *LayeredBlobStorage) SignedURL(hash string, ttl time.Duration) (string, error) {
+	b := s.holder(hash)
+	if b == nil {
+		return "", fmt.Errorf("%w: blob %s", services.ErrNotFound, hash)
+	}
+	signer, ok := b.(services.URLSigner)
+	if !ok {
+		return "", fmt.Errorf("%w: the backend holding blob %s cannot sign URLs", errors.ErrUnsupported, hash)
+	}
+	return signer.SignedURL(hash, ttl)
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/foundry/registry/internal/core/services"
+)
+
+func TestLayeredBlobStorage(t *testing.T) {
+	live, err := NewDiskBlobStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	snap, err := NewDiskBlobStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	archived, _, err := snap.Store(strings.NewReader("archived"))
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	layers := NewLayeredBlobStorage(live, snap)
+
+	fresh, _, err := layers.Store(strings.NewReader("fresh"))
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if !live.Exists(fresh) || snap.Exists(fresh) {
+		t.Error("new blobs should go to the live backend only")
+	}
+
+	rc, err := layers.Open(archived)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "archived" {
+		t.Errorf("Open = %q", data)
+	}
+	if size, err := layers.Size(archived); err != nil || size != int64(len("archived")) {
+		t.Errorf("Size = %d, %v", size, err)
+	}
+	if !layers.Exists(archived) || layers.BlobPath(archived) != snap.BlobPath(archived) {
+		t.Error("snapshot blobs should be readable through the layers")
+	}
+	if _, err := layers.Open(strings.Repeat("0", 64)); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("Open of a missing blob: %v", err)
+	}
+
+	// Garbage collection sees and deletes live blobs only.
+	hashes, err := layers.ListBlobs()
+	if err != nil || len(hashes) != 1 || hashes[0] != fresh {
+		t.Errorf("ListBlobs = %v, %v", hashes, err)
+	}
+	if err := layers.Delete(archived); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if !snap.Exists(archived) {
+		t.Error("Delete removed a snapshot blob")
+	}
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// MemoryBlobStorage keeps blobs in memory. It is meant for tests and for
+// embedding the registry where nothing needs to survive a restart.
+type MemoryBlobStorage struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+// NewMemoryBlobStorage creates an empty MemoryBlobStorage.
+func NewMemoryBlobStorage() *MemoryBlobStorage {
+	return &MemoryBlobStorage{blobs: make(map[string][]byte)}
+}
+
+// Store reads data from r into memory, computing its SHA256 hash.
+func (s *MemoryBlobStorage) Store(r io.Reader) (string, int64, error) {
+	staged, err := s.Stage(r)
+	if err != nil {
+		return "", 0, err
+	}
+	return commitStaged(staged)
+}
+
+// Stage reads data from r into a buffer, computing its SHA256 hash.
+// Committing adds the buffer to the store.
+func (s *MemoryBlobStorage) Stage(r io.Reader) (services.StagedBlob, error) {
+	var buf bytes.Buffer
+	hasher := newHashingWriter(&buf)
+	if _, err := io.Copy(hasher, r); err != nil {
+		return nil, fmt.Errorf("reading blob: %w", err)
+	}
+	return &stagedBuffer{store: s, hash: hasher.Hash(), data: buf.Bytes()}, nil
+}
+
+// stagedBuffer is a blob read into memory, awaiting its place in the store.
+type stagedBuffer struct {
+	store *MemoryBlobStorage
+	hash  string
+	data  []byte
+	done  bool
+}
+
+func (b *stagedBuffer) Hash() string { return b.hash }
+func (b *stagedBuffer) Size() int64  { return int64(len(b.data)) }
+
+func (b *stagedBuffer) Commit() error {
+	if b.done {
+		return nil
+	}
+	b.store.mu.Lock()
+	if _, ok := b.store.blobs[b.hash]; !ok {
+		b.store.blobs[b.hash] = b.data
+	}
+	b.store.mu.Unlock()
+	b.done = true
+	return nil
+}
+
+func (b *stagedBuffer) Discard() error {
+	b.done = true
+	b.data = nil
+	return nil
+}
+
+// Open returns a reader over the blob with the given hash. The reader also
+// implements io.Seeker.
+func (s *MemoryBlobStorage) Open(hash string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	data, ok := s.blobs[hash]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: blob %s", services.ErrNotFound, hash)
+	}
+	return memoryReader{bytes.NewReader(data)}, nil
+}
+
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error { return nil }
+
+// Exists checks if a blob exists.
+func (s *MemoryBlobStorage) Exists(hash string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.blobs[hash]
+	return ok
+}
+
+// Size returns the length of a stored blob.
+func (s *MemoryBlobStorage) Size(hash string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.blobs[hash]
+	if !ok {
+		return 0, fmt.Errorf("%w: blob %s", services.ErrNotFound, hash)
+	}
+	return int64(len(data)), nil
+}
+
+// Delete removes a blob.
+func (s *MemoryBlobStorage) Delete(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, hash)
+	return nil
+}
+
+// BlobPath returns a name for the blob. Nothing exists at it on disk.
+func (s *MemoryBlobStorage) BlobPath(hash string) string {
+	return "memory:" + hash
+}
+
+// ListBlobs returns all blob hashes in order.
+func (s *MemoryBlobStorage) ListBlobs() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hashes := make([]string, 0, len(s.blobs))
+	for h := range s.blobs {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	return hashes, nil
+}
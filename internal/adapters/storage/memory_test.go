@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/foundry/registry/internal/core/services"
+)
+
+func TestMemoryBlobStorage_StoreAndOpen(t *testing.T) {
+	store := NewMemoryBlobStorage()
+
+	disk, err := NewDiskBlobStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	want, _, _ := disk.Store(strings.NewReader("hello world"))
+
+	hash, size, err := store.Store(strings.NewReader("hello world"))
+	if err != nil || hash != want || size != 11 {
+		t.Fatalf("Store = %s, %d, %v; want %s, 11", hash, size, err, want)
+	}
+	rc, err := store.Open(hash)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer rc.Close()
+	if _, ok := rc.(io.Seeker); !ok {
+		t.Error("blob readers should seek")
+	}
+	if data, _ := io.ReadAll(rc); string(data) != "hello world" {
+		t.Errorf("content = %q", data)
+	}
+	if n, err := store.Size(hash); err != nil || n != 11 {
+		t.Errorf("Size = %d, %v", n, err)
+	}
+}
+
+func TestMemoryBlobStorage_StageAndDelete(t *testing.T) {
+	store := NewMemoryBlobStorage()
+
+	staged, err := store.Stage(strings.NewReader("staged"))
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	if store.Exists(staged.Hash()) {
+		t.Fatal("staged blob should not be visible before commit")
+	}
+	if err := staged.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	staged.Discard()
+	other, _, _ := store.Store(strings.NewReader("other"))
+	if blobs, _ := store.ListBlobs(); len(blobs) != 2 || blobs[0] > blobs[1] {
+		t.Errorf("ListBlobs = %v, want both hashes in order", blobs)
+	}
+
+	store.Delete(staged.Hash())
+	if _, err := store.Open(staged.Hash()); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("Open after delete: %v, want ErrNotFound", err)
+	}
+	if blobs, _ := store.ListBlobs(); fmt.Sprint(blobs) != fmt.Sprint([]string{other}) {
+		t.Errorf("ListBlobs = %v, want [%s]", blobs, other)
+	}
+}
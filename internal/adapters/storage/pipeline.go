@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// Defaults for the write pipeline: four 1 MiB buffers let the source, the
+// hasher and the disk each work on their own buffer with one to spare.
+const (
+	defaultBufferSize = 1 << 20
+	defaultBuffers    = 4
+)
+
+// DiskOption tunes a DiskBlobStorage.
+type DiskOption func(*DiskBlobStorage)
+
+// WithWriteBuffers sets the size and number of the buffers each write
+// streams through. More or larger buffers smooth out a bursty source or
+// disk at the cost of memory per concurrent upload. Values below one keep
+// the default.
+func WithWriteBuffers(size, count int) DiskOption {
+	return func(s *DiskBlobStorage) {
+		if size > 0 {
+			s.bufferSize = size
+		}
+		if count > 0 {
+			s.buffers = count
+		}
+	}
+}
+
+// WithPreallocation reserves disk space for a blob extent bytes at a time
+// ahead of the data written, where the filesystem supports it, so large
+// blobs are laid out contiguously. Zero disables it.
+func WithPreallocation(extent int64) DiskOption {
+	return func(s *DiskBlobStorage) {
+		s.preallocate = max(extent, 0)
+	}
+}
+
+// chunk is one buffer of data on its way through the pipeline. The hasher
+// and the writer each release it; the second release returns its buffer.
+type chunk struct {
+	buf  []byte
+	n    int
+	refs atomic.Int32
+}
+
+// writeStats accumulates the counters behind WriteStats.
+type writeStats struct {
+	blobs        atomic.Int64
+	errors       atomic.Int64
+	bytes        atomic.Int64
+	totalMicros  atomic.Int64
+	readMicros   atomic.Int64
+	hashMicros   atomic.Int64
+	writeMicros  atomic.Int64
+	maxPerSecond atomic.Int64
+}
+
+// WriteStats reports how fast blobs have been written since the storage
+// was opened.
+func (s *DiskBlobStorage) WriteStats() models.BlobWriteStats {
+	st := models.BlobWriteStats{
+		Blobs:       s.stats.blobs.Load(),
+		Errors:      s.stats.errors.Load(),
+		Bytes:       s.stats.bytes.Load(),
+		TotalMicros: s.stats.totalMicros.Load(),
+		ReadMicros:  s.stats.readMicros.Load(),
+		HashMicros:  s.stats.hashMicros.Load(),
+		WriteMicros: s.stats.writeMicros.Load(),
+		BufferSize:  s.bufferSize,
+		Buffers:     s.buffers,
+	}
+	if st.TotalMicros > 0 {
+		st.BytesPerSecond = st.Bytes * 1e6 / st.TotalMicros
+	}
+	st.MaxBytesPerSecond = s.stats.maxPerSecond.Load()
+	return st
+}
+
+// getBuffer takes a buffer from the pool, which holds buffers of the
+// configured size.
+func (s *DiskBlobStorage) getBuffer() []byte {
+	if b, ok := s.pool.Get().(*[]byte); ok {
+		return *b
+	}
+	return make([]byte, s.bufferSize)
+}
+
+func (s *DiskBlobStorage) putBuffer(b []byte) {
+	s.pool.Put(&b)
+}
+
+// streamToFile writes from r to f while computing its SHA256. Reading the
+// source, hashing and writing run side by side on a ring of buffers, so
+// the slowest of the three sets the pace rather than their sum.
+func (s *DiskBlobStorage) streamToFile(f *os.File, r io.Reader) (string, int64, error) {
+	start := time.Now()
+	free := make(chan []byte, s.buffers)
+	for range s.buffers {
+		free <- s.getBuffer()
+	}
+	toHash := make(chan *chunk, s.buffers)
+	toWrite := make(chan *chunk, s.buffers)
+	release := func(c *chunk) {
+		if c.refs.Add(-1) == 0 {
+			free <- c.buf
+		}
+	}
+
+	var wg sync.WaitGroup
+	hasher := sha256.New()
+	var hashTime time.Duration
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for c := range toHash {
+			t := time.Now()
+			hasher.Write(c.buf[:c.n])
+			hashTime += time.Since(t)
+			release(c)
+		}
+	}()
+
+	// failed stops the reader once the writer has given up.
+	var failed atomic.Bool
+	var writeErr error
+	var writeTime time.Duration
+	go func() {
+		defer wg.Done()
+		var written, reserved int64
+		for c := range toWrite {
+			if writeErr == nil {
+				t := time.Now()
+				if s.preallocate > 0 && written+int64(c.n) > reserved {
+					reserved = written + max(s.preallocate, int64(c.n))
+					preallocate(f, written, reserved-written)
+				}
+				if _, err := f.Write(c.buf[:c.n]); err != nil {
+					writeErr = err
+					failed.Store(true)
+				}
+				written += int64(c.n)
+				writeTime += time.Since(t)
+			}
+			release(c)
+		}
+	}()
+
+	var size int64
+	var readErr error
+	var readTime time.Duration
+	for !failed.Load() {
+		buf := <-free
+		t := time.Now()
+		n, err := fill(r, buf)
+		readTime += time.Since(t)
+		if n > 0 {
+			c := &chunk{buf: buf, n: n}
+			c.refs.Store(2)
+			size += int64(n)
+			toHash <- c
+			toWrite <- c
+		} else {
+			free <- buf
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErr = err
+			}
+			break
+		}
+	}
+	close(toHash)
+	close(toWrite)
+	wg.Wait()
+	for range s.buffers {
+		s.putBuffer(<-free)
+	}
+
+	err := readErr
+	if err == nil {
+		err = writeErr
+	}
+	s.record(size, time.Since(start), readTime, hashTime, writeTime, err)
+	if err != nil {
+		return "", 0, fmt.Errorf("streaming to file: %w", err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+// fill reads from r until buf is full or r fails. Unlike io.ReadFull it
+// keeps an error returned along with the bytes that filled the buffer.
+func fill(r io.Reader, buf []byte) (int, error) {
+	var n int
+	for n < len(buf) {
+		nn, err := r.Read(buf[n:])
+		n += nn
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// record adds one write to the stats. Writes too short to time fairly do
+// not count toward the peak rate.
+func (s *DiskBlobStorage) record(size int64, total, read, hash, write time.Duration, err error) {
+	if err != nil {
+		s.stats.errors.Add(1)
+		return
+	}
+	s.stats.blobs.Add(1)
+	s.stats.bytes.Add(size)
+	s.stats.totalMicros.Add(total.Microseconds())
+	s.stats.readMicros.Add(read.Microseconds())
+	s.stats.hashMicros.Add(hash.Microseconds())
+	s.stats.writeMicros.Add(write.Microseconds())
+	if total < 10*time.Millisecond {
+		return
+	}
+	rate := size * int64(time.Second) / int64(total)
+	for {
+		peak := s.stats.maxPerSecond.Load()
+		if rate <= peak || s.stats.maxPerSecond.CompareAndSwap(peak, rate) {
+			return
+		}
+	}
+}
+
+// sumWriteStats adds up the write stats of the backends that keep them.
+// Rates are recomputed over the combined totals, and the buffer settings
+// are kept only where the backends share them.
+func sumWriteStats(backends []services.BlobStorage) models.BlobWriteStats {
+	var sum models.BlobWriteStats
+	found := false
+	for _, b := range backends {
+		wm, ok := b.(services.WriteMetrics)
+		if !ok {
+			continue
+		}
+		st := wm.WriteStats()
+		if !found {
+			sum.BufferSize, sum.Buffers = st.BufferSize, st.Buffers
+		} else if sum.BufferSize != st.BufferSize || sum.Buffers != st.Buffers {
+			sum.BufferSize, sum.Buffers = 0, 0
+		}
+		found = true
+		sum.Blobs += st.Blobs
+		sum.Errors += st.Errors
+		sum.Bytes += st.Bytes
+		sum.TotalMicros += st.TotalMicros
+		sum.ReadMicros += st.ReadMicros
+		sum.HashMicros += st.HashMicros
+		sum.WriteMicros += st.WriteMicros
+		sum.MaxBytesPerSecond = max(sum.MaxBytesPerSecond, st.MaxBytesPerSecond)
+	}
+	if sum.TotalMicros > 0 {
+		sum.BytesPerSecond = sum.Bytes * 1e6 / sum.TotalMicros
+	}
+	return sum
+}
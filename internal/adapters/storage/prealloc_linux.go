@@ -0,0 +1,18 @@
+//go:build linux
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize reserves blocks without changing the file's length, so a
+// blob cut short is not padded out to the reservation.
+const fallocKeepSize = 0x1
+
+// preallocate reserves n bytes of f from off. It is only a hint: a
+// filesystem that cannot reserve space is written as usual.
+func preallocate(f *os.File, off, n int64) {
+	syscall.Fallocate(int(f.Fd()), fallocKeepSize, off, n)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package storage
+
+import "os"
+
+// preallocate does nothing where the space cannot be reserved ahead.
+func preallocate(*os.File, int64, int64) {}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/chunking"
+)
+
+// BackendConfig is what a backend is opened with: the storage.dataDir
+// setting and the storage.options map from the config file, which the
+// backend interprets as it likes.
+type BackendConfig struct {
+	DataDir string
+	Options map[string]string
+}
+
+// Factory opens a blob storage backend.
+type Factory func(cfg BackendConfig) (services.BlobStorage, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]Factory)
+)
+
+func init() {
+	Register("disk", func(cfg BackendConfig) (services.BlobStorage, error) {
+		var bufferSize, buffers, extent int
+		if err := intOptions(cfg.Options, map[string]*int{"bufferSize": &bufferSize, "buffers": &buffers, "preallocate": &extent}); err != nil {
+			return nil, err
+		}
+		return NewDiskBlobStorage(cfg.DataDir, WithWriteBuffers(bufferSize, buffers), WithPreallocation(int64(extent)))
+	})
+	Register("chunked", func(cfg BackendConfig) (services.BlobStorage, error) {
+		var c chunking.Config
+		if err := intOptions(cfg.Options, map[string]*int{"minSize": &c.MinSize, "avgSize": &c.AvgSize, "maxSize": &c.MaxSize}); err != nil {
+			return nil, err
+		}
+		return NewChunkedBlobStorage(cfg.DataDir, c)
+	})
+	Register("memory", func(BackendConfig) (services.BlobStorage, error) {
+		return NewMemoryBlobStorage(), nil
+	})
+}
+
+// intOptions parses the options named in sizes as non-negative integers
+// into them. Options not given leave their size alone.
+func intOptions(options map[string]string, sizes map[string]*int) error {
+	for key, size := range sizes {
+		v, ok := options[key]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid %s %q", key, v)
+		}
+		*size = n
+	}
+	return nil
+}
+
+// Register makes a backend available under name for the storage.backend
+// setting. It is meant to be called from an init function and panics if
+// factory is nil or name is already taken.
+func Register(name string, factory Factory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if factory == nil {
+		panic("storage: Register factory is nil for " + name)
+	}
+	if _, dup := backends[name]; dup {
+		panic("storage: Register called twice for " + name)
+	}
+	backends[name] = factory
+}
+
+// Backends returns the registered backend names in order.
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the backend registered under name.
+func Open(name string, cfg BackendConfig) (services.BlobStorage, error) {
+	backendsMu.RLock()
+	factory := backends[name]
+	backendsMu.RUnlock()
+	if factory == nil {
+		return nil, fmt.Errorf("unknown storage backend %q (registered: %s)", name, strings.Join(Backends(), ", "))
+	}
+	return factory(cfg)
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/foundry/registry/internal/core/services"
+)
+
+func TestOpenBuiltInBackends(t *testing.T) {
+	for _, name := range []string{"disk", "chunked", "memory"} {
+		blobs, err := Open(name, BackendConfig{DataDir: t.TempDir()})
+		if err != nil {
+			t.Fatalf("Open(%s): %v", name, err)
+		}
+		hash, _, err := blobs.Store(strings.NewReader("hello"))
+		if err != nil || !blobs.Exists(hash) {
+			t.Errorf("%s: Store = %s, %v", name, hash, err)
+		}
+	}
+
+	chunked, err := Open("chunked", BackendConfig{DataDir: t.TempDir(), Options: map[string]string{"minSize": "1024", "avgSize": "4096", "maxSize": "16384"}})
+	if err != nil {
+		t.Fatalf("Open(chunked): %v", err)
+	}
+	if got := chunked.(*ChunkedBlobStorage).cfg.AvgSize; got != 4096 {
+		t.Errorf("avgSize = %d, want the option's 4096", got)
+	}
+	if _, err := Open("chunked", BackendConfig{DataDir: t.TempDir(), Options: map[string]string{"minSize": "small"}}); err == nil {
+		t.Error("expected an error for a non-numeric chunk size")
+	}
+
+	disk, err := Open("disk", BackendConfig{DataDir: t.TempDir(), Options: map[string]string{"bufferSize": "65536", "buffers": "8", "preallocate": "1048576"}})
+	if err != nil {
+		t.Fatalf("Open(disk): %v", err)
+	}
+	if d := disk.(*DiskBlobStorage); d.bufferSize != 65536 || d.buffers != 8 || d.preallocate != 1<<20 {
+		t.Errorf("disk options = %d, %d, %d; want 65536, 8, 1048576", d.bufferSize, d.buffers, d.preallocate)
+	}
+	if _, err := Open("disk", BackendConfig{DataDir: t.TempDir(), Options: map[string]string{"buffers": "-1"}}); err == nil {
+		t.Error("expected an error for a negative buffer count")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	var opened BackendConfig
+	Register("test-registry", func(cfg BackendConfig) (services.BlobStorage, error) {
+		opened = cfg
+		return NewMemoryBlobStorage(), nil
+	})
+	if _, err := Open("test-registry", BackendConfig{DataDir: "d", Options: map[string]string{"pool": "p"}}); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if opened.DataDir != "d" || opened.Options["pool"] != "p" {
+		t.Errorf("factory got %+v", opened)
+	}
+
+	_, err := Open("ceph", BackendConfig{})
+	if err == nil || !strings.Contains(err.Error(), "disk, memory, test-registry") {
+		t.Errorf("Open(unknown) = %v, want an error listing the backends", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice should panic")
+		}
+	}()
+	Register("disk", func(BackendConfig) (services.BlobStorage, error) { return nil, nil })
+}
@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// RoutedBlobStorage writes the blobs of each package to the backend routed
+// to the longest prefix of its name, or to a fallback backend when no
+// prefix matches. Blobs are content-addressed across backends: reads look
+// in the fallback first and then in each route, so callers that know only
+// a hash see one store.
+type RoutedBlobStorage struct {
+	fallback services.BlobStorage
+	routes   []blobRoute
+}
+
+type blobRoute struct {
+	prefix string
+	blobs  services.BlobStorage
+}
+
+// NewRoutedBlobStorage routes the packages starting with each prefix of
+// routes to its backend and all others to fallback.
+func NewRoutedBlobStorage(fallback services.BlobStorage, routes map[string]services.BlobStorage) *RoutedBlobStorage {
+	s := &RoutedBlobStorage{fallback: fallback}
+	for prefix, blobs := range routes {
+		s.routes = append(s.routes, blobRoute{prefix: prefix, blobs: blobs})
+	}
+	// Longest prefix first, so the first match is the most specific.
+	sort.Slice(s.routes, func(i, j int) bool {
+		if len(s.routes[i].prefix) != len(s.routes[j].prefix) {
+			return len(s.routes[i].prefix) > len(s.routes[j].prefix)
+		}
+		return s.routes[i].prefix < s.routes[j].prefix
+	})
+	return s
+}
+
+// Route returns the backend the blobs of package pkg are written to.
+func (s *RoutedBlobStorage) Route(pkg string) services.BlobStorage {
+	for _, r := range s.routes {
+		if strings.HasPrefix(pkg, r.prefix) {
+			return r.blobs
+		}
+	}
+	return s.fallback
+}
+
+// Place copies a stored blob into the backend routed to pkg, checking the
+// copy, unless that backend holds it already.
+func (s *RoutedBlobStorage) Place(pkg, hash string) error {
+	dst := s.Route(pkg)
+	if dst.Exists(hash) {
+		return nil
+	}
+	rc, err := s.Open(hash)
+	if err != nil {
+		return err
+	}
+	staged, err := dst.Stage(rc)
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("copying blob %s: %w", hash, err)
+	}
+	defer staged.Discard()
+	if staged.Hash() != hash {
+		return fmt.Errorf("copying blob %s: copy has hash %s", hash, staged.Hash())
+	}
+	if err := staged.Commit(); err != nil {
+		return fmt.Errorf("copying blob %s: %w", hash, err)
+	}
+	return nil
+}
+
+// backends returns the fallback followed by the route backends.
+func (s *RoutedBlobStorage) backends() []services.BlobStorage {
+	all := make([]services.BlobStorage, 0, len(s.routes)+1)
+	all = append(all, s.fallback)
+	for _, r := range s.routes {
+		all = append(all, r.blobs)
+	}
+	return all
+}
+
+// holder returns the backend holding hash, or nil.
+func (s *RoutedBlobStorage) holder(hash string) services.BlobStorage {
+	for _, b := range s.backends() {
+		if b.Exists(hash) {
+			return b
+		}
+	}
+	return nil
+}
+
+// Store writes data to the fallback backend. Uploads for a package go
+// through Route instead.
+func (s *RoutedBlobStorage) Store(r io.Reader) (string, int64, error) {
+	return s.fallback.Store(r)
+}
+
+// Stage stages data in the fallback backend.
+func (s *RoutedBlobStorage) Stage(r io.Reader) (services.StagedBlob, error) {
+	return s.fallback.Stage(r)
+}
+
+// Open opens a blob from the first backend holding it.
+func (s *RoutedBlobStorage) Open(hash string) (io.ReadCloser, error) {
+	for _, b := range s.backends() {
+		rc, err := b.Open(hash)
+		if !errors.Is(err, services.ErrNotFound) {
+			return rc, err
+		}
+	}
+	return nil, fmt.Errorf("%w: blob %s", services.ErrNotFound, hash)
+}
+
+// Exists reports whether any backend holds the blob.
+func (s *RoutedBlobStorage) Exists(hash string) bool {
+	return s.holder(hash) != nil
+}
+
+// Size returns the length of a blob in the first backend holding it.
+func (s *RoutedBlobStorage) Size(hash string) (int64, error) {
+	for _, b := range s.backends() {
+		size, err := b.Size(hash)
+		if !errors.Is(err, services.ErrNotFound) {
+			return size, err
+		}
+	}
+	return 0, fmt.Errorf("%w: blob %s", services.ErrNotFound, hash)
+}
+
+// Delete removes a blob from every backend, since packages routed to
+// different backends may share it.
+func (s *RoutedBlobStorage) Delete(hash string) error {
+	var errs []error
+	for _, b := range s.backends() {
+		if err := b.Delete(hash); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// BlobPath returns the blob's path in the backend holding it, or in the
+// fallback if none does.
+func (s *RoutedBlobStorage) BlobPath(hash string) string {
+	if b := s.holder(hash); b != nil {
+		return b.BlobPath(hash)
+	}
+	return s.fallback.BlobPath(hash)
+}
+
+// ListBlobs returns the hashes held by any backend.
+func (s *RoutedBlobStorage) ListBlobs() ([]string, error) {
+	seen := make(map[string]bool)
+	var hashes []string
+	for _, b := range s.backends() {
+		list, err := b.ListBlobs()
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range list {
+			if !seen[h] {
+				seen[h] = true
+				hashes = append(hashes, h)
+			}
+		}
+	}
+	sort.Strings(hashes)
+	return hashes, nil
+}
+
+// FreeSpace returns the least room left in any backend that can tell, as
+// uploads may go to any of them.
+func (s *RoutedBlobStorage) FreeSpace() (int64, error) {
+	free := int64(-1)
+	for _, b := range s.backends() {
+		sr, ok := b.(services.SpaceReporter)
+		if !ok {
+			continue
+		}
+		n, err := sr.FreeSpace()
+		if errors.Is(err, errors.ErrUnsupported) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if free < 0 || n < free {
+			free = n
+		}
+	}
+	if free < 0 {
+		return 0, fmt.Errorf("%w: no backend can report free space", errors.ErrUnsupported)
+	}
+	return free, nil
+}
+
+// WriteStats adds up the write stats of whichever backends keep them.
+func (s *RoutedBlobStorage) WriteStats() models.BlobWriteStats {
+	return sumWriteStats(s.backends())
+}
+
+// CleanTemp cleans the temp files of whichever backends keep them.
+func (s *RoutedBlobStorage) CleanTemp(before time.Time) (int, int64, error) {
+	var files int
+	var bytes int64
+	for _, b := range s.backends() {
+		cleaner, ok := b.(services.TempCleaner)
+		if !ok {
+			continue
+		}
+		n, size, err := cleaner.CleanTemp(before)
+		files += n
+		bytes += size
+		if err != nil {
+			return files, bytes, err
+		}
+	}
+	return files, bytes, nil
+}
+
+// SignedURL signs a download URL with the backend holding the blob, if it
+// can sign URLs.
+func (s *RoutedBlobStorage) SignedURL(hash string, ttl time.Duration) (string, error) {
+	b := s.holder(hash)
+	if b == nil {
+		return "", fmt.Errorf("%w: blob %s", services.ErrNotFound, hash)
+	}
+	signer, ok := b.(services.URLSigner)
+	if !ok {
+		return "", fmt.Errorf("%w: the backend holding blob %s cannot sign URLs", errors.ErrUnsupported, hash)
+	}
+	return signer.SignedURL(hash, ttl)
+}
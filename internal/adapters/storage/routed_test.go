@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foundry/registry/internal/core/services"
+)
+
+func TestRoutedBlobStorage(t *testing.T) {
+	fallback := NewMemoryBlobStorage()
+	ci := NewMemoryBlobStorage()
+	release := NewMemoryBlobStorage()
+	releaseTools := NewMemoryBlobStorage()
+	routed := NewRoutedBlobStorage(fallback, map[string]services.BlobStorage{
+		"ci-":            ci,
+		"release-":       release,
+		"release-tools-": releaseTools,
+	})
+
+	for pkg, want := range map[string]services.BlobStorage{
+		"ci-build":          ci,
+		"release-app":       release,
+		"release-tools-cli": releaseTools,
+		"app":               fallback,
+	} {
+		if got := routed.Route(pkg); got != want {
+			t.Errorf("Route(%s) picked the wrong backend", pkg)
+		}
+	}
+
+	staged, err := routed.Route("ci-build").Stage(strings.NewReader("nightly"))
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	if err := staged.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	hash := staged.Hash()
+	if !ci.Exists(hash) || fallback.Exists(hash) {
+		t.Fatal("blob should be in the ci backend only")
+	}
+
+	// Reads find the blob without knowing the package.
+	rc, err := routed.Open(hash)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "nightly" {
+		t.Errorf("Open read %q", data)
+	}
+	if size, err := routed.Size(hash); err != nil || size != 7 {
+		t.Errorf("Size = %d, %v", size, err)
+	}
+	if _, err := routed.Open(strings.Repeat("0", 64)); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("Open of a missing blob: %v, want ErrNotFound", err)
+	}
+
+	// Publishing the same content to a release package copies it there.
+	if err := routed.Place("release-app", hash); err != nil {
+		t.Fatalf("Place: %v", err)
+	}
+	if !release.Exists(hash) || !ci.Exists(hash) {
+		t.Error("Place should copy the blob into the release backend")
+	}
+	if hashes, err := routed.ListBlobs(); err != nil || len(hashes) != 1 || hashes[0] != hash {
+		t.Errorf("ListBlobs = %v, %v", hashes, err)
+	}
+
+	if err := routed.Delete(hash); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if routed.Exists(hash) {
+		t.Error("Delete should remove the blob from every backend")
+	}
+
+	if _, err := routed.SignedURL(hash, time.Minute); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("SignedURL of a deleted blob: %v, want ErrNotFound", err)
+	}
+}
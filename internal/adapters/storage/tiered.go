@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// TieredBlobStorage keeps new blobs in a hot backend and moves rarely read
+// ones to a cold backend on request. Each blob lives in one tier; reads
+// look in the hot tier first and fall back to the cold one.
+type TieredBlobStorage struct {
+	hot  services.BlobStorage
+	cold services.BlobStorage
+}
+
+// NewTieredBlobStorage combines hot and cold backends into one store.
+func NewTieredBlobStorage(hot, cold services.BlobStorage) *TieredBlobStorage {
+	return &TieredBlobStorage{hot: hot, cold: cold}
+}
+
+// Store writes data to the hot tier.
+func (s *TieredBlobStorage) Store(r io.Reader) (string, int64, error) {
+	staged, err := s.Stage(r)
+	if err != nil {
+		return "", 0, err
+	}
+	return commitStaged(staged)
+}
+
+// Stage stages data in the hot tier. Committing a blob the cold tier holds
+// moves it to the hot tier.
+func (s *TieredBlobStorage) Stage(r io.Reader) (services.StagedBlob, error) {
+	staged, err := s.hot.Stage(r)
+	if err != nil {
+		return nil, err
+	}
+	return &tieredStaged{StagedBlob: staged, cold: s.cold}, nil
+}
+
+type tieredStaged struct {
+	services.StagedBlob
+	cold services.BlobStorage
+}
+
+func (t *tieredStaged) Commit() error {
+	if err := t.StagedBlob.Commit(); err != nil {
+		return err
+	}
+	return t.cold.Delete(t.Hash())
+}
+
+// Open opens a blob from whichever tier holds it.
+func (s *TieredBlobStorage) Open(hash string) (io.ReadCloser, error) {
+	rc, err := s.hot.Open(hash)
+	if errors.Is(err, services.ErrNotFound) {
+		return s.cold.Open(hash)
+	}
+	return rc, err
+}
+
+// Exists reports whether either tier holds the blob.
+func (s *TieredBlobStorage) Exists(hash string) bool {
+	return s.hot.Exists(hash) || s.cold.Exists(hash)
+}
+
+// Size returns the length of a blob in either tier.
+func (s *TieredBlobStorage) Size(hash string) (int64, error) {
+	size, err := s.hot.Size(hash)
+	if errors.Is(err, services.ErrNotFound) {
+		return s.cold.Size(hash)
+	}
+	return size, err
+}
+
+// Delete removes a blob from both tiers.
+func (s *TieredBlobStorage) Delete(hash string) error {
+	if err := s.hot.Delete(hash); err != nil {
+		return err
+	}
+	return s.cold.Delete(hash)
+}
+
+// BlobPath returns the blob's path in the hot tier.
+func (s *TieredBlobStorage) BlobPath(hash string) string {
+	return s.hot.BlobPath(hash)
+}
+
+// ListBlobs returns the hashes held by either tier.
+func (s *TieredBlobStorage) ListBlobs() ([]string, error) {
+	hot, err := s.hot.ListBlobs()
+	if err != nil {
+		return nil, err
+	}
+	cold, err := s.cold.ListBlobs()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(hot))
+	for _, h := range hot {
+		seen[h] = true
+	}
+	for _, h := range cold {
+		if !seen[h] {
+			hot = append(hot, h)
+		}
+	}
+	sort.Strings(hot)
+	return hot, nil
+}
+
+// FreeSpace returns the room left in the hot tier, where new blobs go.
+func (s *TieredBlobStorage) FreeSpace() (int64, error) {
+	hot, ok := s.hot.(services.SpaceReporter)
+	if !ok {
+		return 0, fmt.Errorf("%w: hot tier cannot report free space", errors.ErrUnsupported)
+	}
+	return hot.FreeSpace()
+}
+
+// CleanTemp cleans the temp files of whichever tiers keep them; moving
+// blobs between tiers stages them like uploads.
+func (s *TieredBlobStorage) CleanTemp(before time.Time) (int, int64, error) {
+	var files int
+	var bytes int64
+	for _, tier := range []services.BlobStorage{s.hot, s.cold} {
+		cleaner, ok := tier.(services.TempCleaner)
+		if !ok {
+			continue
+		}
+		n, size, err := cleaner.CleanTemp(before)
+		files += n
+		bytes += size
+		if err != nil {
+			return files, bytes, err
+		}
+	}
+	return files, bytes, nil
+}
+
+// WriteStats adds up the write stats of whichever tiers keep them; moving
+// blobs between tiers counts as writing them.
+func (s *TieredBlobStorage) WriteStats() models.BlobWriteStats {
+	return sumWriteStats([]services.BlobStorage{s.hot, s.cold})
+}
+
+// Offload copies a hot blob to the cold tier, checks the copy and removes
+// the hot one.
+func (s *TieredBlobStorage) Offload(hash string) error {
+	return move(s.hot, s.cold, hash)
+}
+
+// Restore copies a cold blob back to the hot tier, checks the copy and
+// removes the cold one.
+func (s *TieredBlobStorage) Restore(hash string) error {
+	return move(s.cold, s.hot, hash)
+}
+
+// Tier reports which tier holds the blob.
+func (s *TieredBlobStorage) Tier(hash string) (string, error) {
+	switch {
+	case s.hot.Exists(hash):
+		return models.TierHot, nil
+	case s.cold.Exists(hash):
+		return models.TierCold, nil
+	}
+	return "", fmt.Errorf("%w: blob %s", services.ErrNotFound, hash)
+}
+
+// move copies hash from src to dst and deletes it from src. A blob that is
+// only in dst already is left alone.
+func move(src, dst services.BlobStorage, hash string) error {
+	if !src.Exists(hash) {
+		if dst.Exists(hash) {
+			return nil
+		}
+		return fmt.Errorf("%w: blob %s", services.ErrNotFound, hash)
+	}
+	if !dst.Exists(hash) {
+		rc, err := src.Open(hash)
+		if err != nil {
+			return err
+		}
+		staged, err := dst.Stage(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("copying blob %s: %w", hash, err)
+		}
+		defer staged.Discard()
+		if staged.Hash() != hash {
+			return fmt.Errorf("copying blob %s: copy has hash %s", hash, staged.Hash())
+		}
+		if err := staged.Commit(); err != nil {
+			return fmt.Errorf("copying blob %s: %w", hash, err)
+		}
+	}
+	return src.Delete(hash)
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+func newTestTiers(t *testing.T) (*TieredBlobStorage, *DiskBlobStorage, *DiskBlobStorage) {
+	t.Helper()
+	hot, err := NewDiskBlobStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	cold, err := NewDiskBlobStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	return NewTieredBlobStorage(hot, cold), hot, cold
+}
+
+func TestTieredBlobStorage_OffloadAndRestore(t *testing.T) {
+	tiers, hot, cold := newTestTiers(t)
+
+	hash, _, err := tiers.Store(strings.NewReader("archive"))
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if tier, _ := tiers.Tier(hash); tier != models.TierHot || cold.Exists(hash) {
+		t.Fatalf("new blob should be hot only, tier %q", tier)
+	}
+
+	if err := tiers.Offload(hash); err != nil {
+		t.Fatalf("Offload: %v", err)
+	}
+	if hot.Exists(hash) || !cold.Exists(hash) {
+		t.Fatal("offloaded blob should be cold only")
+	}
+	if tier, _ := tiers.Tier(hash); tier != models.TierCold {
+		t.Errorf("tier = %q, want cold", tier)
+	}
+	// Reads fall through to the cold tier.
+	rc, err := tiers.Open(hash)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "archive" {
+		t.Errorf("Open = %q", data)
+	}
+	if size, err := tiers.Size(hash); err != nil || size != 7 {
+		t.Errorf("Size = %d, %v", size, err)
+	}
+	if blobs, _ := tiers.ListBlobs(); len(blobs) != 1 || blobs[0] != hash {
+		t.Errorf("ListBlobs = %v", blobs)
+	}
+	if err := tiers.Offload(hash); err != nil {
+		t.Errorf("offloading a cold blob again: %v", err)
+	}
+
+	if err := tiers.Restore(hash); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if !hot.Exists(hash) || cold.Exists(hash) {
+		t.Error("restored blob should be hot only")
+	}
+
+	if err := tiers.Offload("missing"); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("Offload(missing) = %v, want ErrNotFound", err)
+	}
+	if _, err := tiers.Tier("missing"); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("Tier(missing) = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTieredBlobStorage_StoreMovesColdBlobHot(t *testing.T) {
+	tiers, hot, cold := newTestTiers(t)
+
+	hash, _, _ := tiers.Store(strings.NewReader("shared"))
+	tiers.Offload(hash)
+
+	// Uploading the same content again brings the blob back to the hot
+	// tier instead of keeping two copies.
+	if _, _, err := tiers.Store(strings.NewReader("shared")); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if !hot.Exists(hash) || cold.Exists(hash) {
+		t.Error("re-uploaded blob should be hot only")
+	}
+
+	tiers.Offload(hash)
+	if err := tiers.Delete(hash); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if tiers.Exists(hash) {
+		t.Error("Delete should remove the blob from both tiers")
+	}
+}
+
+func TestTieredBlobStorage_FreeSpace(t *testing.T) {
+	tiers, _, _ := newTestTiers(t)
+	if free, err := tiers.FreeSpace(); err != nil || free <= 0 {
+		t.Errorf("FreeSpace = %d, %v; want the hot tier's free space", free, err)
+	}
+
+	memory := NewTieredBlobStorage(NewMemoryBlobStorage(), NewMemoryBlobStorage())
+	if _, err := memory.FreeSpace(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("FreeSpace of a memory hot tier: %v, want ErrUnsupported", err)
+	}
+}
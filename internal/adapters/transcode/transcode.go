@@ -0,0 +1,292 @@
+package transcode
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/hashing"
+)
+
+// Format identifies the compression wrapping of an archive.
+type Format string
+
+const (
+	// Tar is an uncompressed archive.
+	Tar Format = "tar"
+	// Gzip is a gzip-compressed archive.
+	Gzip Format = "gzip"
+	// Zstd is a zstd-compressed archive.
+	Zstd Format = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Detect reports the compression format of a stream from its first bytes.
+// Only compressed formats are detected; anything else returns false.
+func Detect(prefix []byte) (Format, bool) {
+	switch {
+	case bytes.HasPrefix(prefix, zstdMagic):
+		return Zstd, true
+	case bytes.HasPrefix(prefix, gzipMagic):
+		return Gzip, true
+	}
+	return "", false
+}
+
+// ContentType returns the MIME type served for the format.
+func (f Format) ContentType() string {
+	switch f {
+	case Gzip:
+		return "application/gzip"
+	case Zstd:
+		return "application/zstd"
+	default:
+		return "application/x-tar"
+	}
+}
+
+// Extension returns the file suffix conventionally used for the format.
+func (f Format) Extension() string {
+	switch f {
+	case Gzip:
+		return ".tar.gz"
+	case Zstd:
+		return ".tar.zst"
+	default:
+		return ".tar"
+	}
+}
+
+var mediaTypes = map[string]Format{
+	"application/gzip":   Gzip,
+	"application/x-gzip": Gzip,
+	"application/zstd":   Zstd,
+	"application/x-zstd": Zstd,
+	"application/x-tar":  Tar,
+}
+
+// Negotiate picks the preferred format from an Accept header. It returns
+// false when the client has no preference among the supported formats or
+// prefers the stored bytes as-is (application/octet-stream or */*).
+func Negotiate(accept string) (Format, bool) {
+	type candidate struct {
+		format Format
+		raw    bool
+		q      float64
+		order  int
+	}
+	var candidates []candidate
+	for i, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		if f, ok := mediaTypes[mt]; ok {
+			candidates = append(candidates, candidate{format: f, q: q, order: i})
+		} else if mt == "application/octet-stream" || mt == "*/*" {
+			candidates = append(candidates, candidate{raw: true, q: q, order: i})
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		return candidates[i].order < candidates[j].order
+	})
+	if candidates[0].raw {
+		return "", false
+	}
+	return candidates[0].format, true
+}
+
+// Convert decompresses src from one format and recompresses it into dst in
+// another. Converting to Tar only strips the compression.
+func Convert(dst io.Writer, src io.Reader, from, to Format) error {
+	var plain io.Reader
+	switch from {
+	case Gzip:
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return fmt.Errorf("opening gzip stream: %w", err)
+		}
+		defer gz.Close()
+		plain = gz
+	case Zstd:
+		zr, err := zstd.NewReader(src, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("opening zstd stream: %w", err)
+		}
+		defer zr.Close()
+		plain = zr
+	case Tar:
+		plain = src
+	default:
+		return fmt.Errorf("unsupported source format %q", from)
+	}
+
+	switch to {
+	case Gzip:
+		gw := gzip.NewWriter(dst)
+		if _, err := io.Copy(gw, plain); err != nil {
+			return fmt.Errorf("writing gzip stream: %w", err)
+		}
+		return gw.Close()
+	case Zstd:
+		zw, err := zstd.NewWriter(dst)
+		if err != nil {
+			return fmt.Errorf("creating zstd writer: %w", err)
+		}
+		if _, err := io.Copy(zw, plain); err != nil {
+			zw.Close()
+			return fmt.Errorf("writing zstd stream: %w", err)
+		}
+		return zw.Close()
+	case Tar:
+		if _, err := io.Copy(dst, plain); err != nil {
+			return fmt.Errorf("writing tar stream: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported target format %q", to)
+	}
+}
+
+// Cache stores transcoded copies of blobs keyed by source digest and target
+// format, so each conversion only runs once.
+//
+// Layout:
+//
+//	<dir>/<first2>/<source_sha256>.<format>
+type Cache struct {
+	dir string
+}
+
+// NewCache creates a Cache rooted at dir.
+func NewCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating transcode cache directory: %w", err)
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Open returns the cached conversion of hash to format.
+func (c *Cache) Open(hash string, format Format) (*os.File, error) {
+	f, err := os.Open(c.path(hash, format))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: transcoded %s as %s", services.ErrNotFound, hash, format)
+		}
+		return nil, fmt.Errorf("opening transcoded blob: %w", err)
+	}
+	return f, nil
+}
+
+// Create starts writing a cache entry. The entry only becomes visible once
+// Commit is called; Abort discards it.
+func (c *Cache) Create(hash string, format Format) (*Entry, error) {
+	dir := filepath.Join(c.dir, hashing.BlobDir(hash))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating transcode cache subdirectory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating transcode temp file: %w", err)
+	}
+	return &Entry{File: tmp, final: c.path(hash, format)}, nil
+}
+
+// Prune removes cache entries whose source digest is not in keep.
+func (c *Cache) Prune(keep map[string]bool) (int, error) {
+	prefixes, err := os.ReadDir(c.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("reading transcode cache: %w", err)
+	}
+
+	var removed int
+	for _, prefix := range prefixes {
+		if !prefix.IsDir() {
+			continue
+		}
+		sub := filepath.Join(c.dir, prefix.Name())
+		entries, err := os.ReadDir(sub)
+		if err != nil {
+			return removed, fmt.Errorf("reading transcode cache subdirectory: %w", err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if strings.HasPrefix(name, ".tmp-") {
+				continue
+			}
+			hash, _, _ := strings.Cut(name, ".")
+			if keep[hash] {
+				continue
+			}
+			if err := os.Remove(filepath.Join(sub, name)); err != nil && !os.IsNotExist(err) {
+				return removed, fmt.Errorf("removing transcoded blob: %w", err)
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (c *Cache) path(hash string, format Format) string {
+	return filepath.Join(c.dir, hashing.BlobDir(hash), hash+"."+string(format))
+}
+
+// Entry is a cache entry being written.
+type Entry struct {
+	*os.File
+	final string
+}
+
+// Commit closes the entry and moves it into place.
+func (e *Entry) Commit() error {
+	if err := e.File.Close(); err != nil {
+		os.Remove(e.File.Name())
+		return fmt.Errorf("closing transcoded blob: %w", err)
+	}
+	if err := os.Rename(e.File.Name(), e.final); err != nil {
+		os.Remove(e.File.Name())
+		if _, statErr := os.Stat(e.final); statErr == nil {
+			return nil
+		}
+		return fmt.Errorf("moving transcoded blob into cache: %w", err)
+	}
+	return nil
+}
+
+// Abort discards the entry.
+func (e *Entry) Abort() {
+	e.File.Close()
+	os.Remove(e.File.Name())
+}
@@ -0,0 +1,128 @@
+package transcode
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("zstd.NewWriter: %v", err)
+	}
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestDetect(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte("x"))
+	gw.Close()
+
+	tests := []struct {
+		name   string
+		prefix []byte
+		want   Format
+		ok     bool
+	}{
+		{"gzip", gz.Bytes(), Gzip, true},
+		{"zstd", zstdBytes(t, []byte("x")), Zstd, true},
+		{"plain", []byte("hello"), "", false},
+		{"short", []byte{0x1f}, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := Detect(tt.prefix)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: Detect = (%q, %v), want (%q, %v)", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   Format
+		ok     bool
+	}{
+		{"", "", false},
+		{"*/*", "", false},
+		{"application/gzip", Gzip, true},
+		{"application/x-gzip, */*;q=0.1", Gzip, true},
+		{"application/octet-stream, application/gzip;q=0.5", "", false},
+		{"application/gzip;q=0.5, application/zstd", Zstd, true},
+		{"application/x-tar", Tar, true},
+		{"text/html", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := Negotiate(tt.accept)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Negotiate(%q) = (%q, %v), want (%q, %v)", tt.accept, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestConvertZstdToGzip(t *testing.T) {
+	payload := bytes.Repeat([]byte("archive payload "), 1000)
+
+	var out bytes.Buffer
+	if err := Convert(&out, bytes.NewReader(zstdBytes(t, payload)), Zstd, Gzip); err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+
+	gr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	got, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("reading gzip output: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("payload changed during conversion")
+	}
+}
+
+func TestCacheCommitAndPrune(t *testing.T) {
+	cache, err := NewCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+
+	const hash = "ab00000000000000000000000000000000000000000000000000000000000000"
+	if _, err := cache.Open(hash, Gzip); err == nil {
+		t.Fatal("expected miss before commit")
+	}
+
+	entry, err := cache.Create(hash, Gzip)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	entry.Write([]byte("converted"))
+	if err := entry.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	f, err := cache.Open(hash, Gzip)
+	if err != nil {
+		t.Fatalf("Open after commit: %v", err)
+	}
+	f.Close()
+
+	if n, err := cache.Prune(map[string]bool{hash: true}); err != nil || n != 0 {
+		t.Fatalf("Prune with live source = (%d, %v), want (0, nil)", n, err)
+	}
+	if n, err := cache.Prune(map[string]bool{}); err != nil || n != 1 {
+		t.Fatalf("Prune with dead source = (%d, %v), want (1, nil)", n, err)
+	}
+	if _, err := cache.Open(hash, Gzip); err == nil {
+		t.Error("expected miss after prune")
+	}
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// WithAccounts enables users, teams and package ownership, kept in store.
+// Packages with owners may only be published to, deprecated or deleted
+// from by members of an owning team, and by admins.
+func WithAccounts(store services.AccountStore) Option {
+	return func(h *Handler) {
+		h.accounts = store
+	}
+}
+
+// ownershipDecision refuses uploads, deletes, deprecations and pins of
+// packages with owners by callers in none of the owning teams, answering
+// like policyDecision. Callers belong to a team as a member or through its
+// SSO group.
+func (h *Handler) ownershipDecision(r *http.Request, req models.PolicyRequest) (int, string) {
+	if h.accounts == nil || !ownedAction(req.Action) {
+		return 0, ""
+	}
+	p := principalFrom(r.Context())
+	if p != nil && p.Admin {
+		return 0, ""
+	}
+	owners, err := h.accounts.PackageOwners(req.Package)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("checking package owners")
+		return http.StatusServiceUnavailable, "ownership check failed"
+	}
+	if len(owners) == 0 {
+		return 0, ""
+	}
+	names := make([]string, len(owners))
+	for i, team := range owners {
+		if p != nil && (p.User != "" && slices.Contains(team.Members, p.User) ||
+			team.SSOGroup != "" && slices.Contains(p.Groups, team.SSOGroup)) {
+			return 0, ""
+		}
+		names[i] = team.Name
+	}
+	h.logger.Warn().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Str("action", req.Action).
+		Str("package", req.Package).
+		Str("version", req.Version).
+		Msg("request denied: not a package owner")
+	return http.StatusForbidden, fmt.Sprintf("%s is owned by %s; only their members may %s it",
+		req.Package, strings.Join(names, ", "), req.Action)
+}
+
+// ownedAction reports whether only owners may perform action on a package
+// with owners.
+func ownedAction(action string) bool {
+	switch action {
+	case models.PolicyActionUpload, models.PolicyActionDelete, models.PolicyActionDeprecate, models.PolicyActionPin:
+		return true
+	}
+	return false
+}
+
+// GetPackageOwners handles GET /api/v1/packages/{package}/owners
+func (h *Handler) GetPackageOwners(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+	pkgName := chi.URLParam(r, "package")
+	owners, err := h.accounts.PackageOwners(pkgName)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing package owners")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if owners == nil {
+		owners = []models.Team{}
+	}
+	writeJSON(w, http.StatusOK, models.PackageOwners{Package: pkgName, Owners: owners})
+}
+
+// AddPackageOwner handles PUT /api/v1/admin/packages/{package}/owners/{team}
+func (h *Handler) AddPackageOwner(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+	pkgName, team := chi.URLParam(r, "package"), chi.URLParam(r, "team")
+	if err := h.accounts.AddPackageOwner(pkgName, team); err != nil {
+		h.accountError(w, err, "adding package owner")
+		return
+	}
+	h.logAccountChange(r, "package owner added", "package", pkgName, "team", team)
+	h.GetPackageOwners(w, r)
+}
+
+// RemovePackageOwner handles DELETE /api/v1/admin/packages/{package}/owners/{team}
+func (h *Handler) RemovePackageOwner(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+	pkgName, team := chi.URLParam(r, "package"), chi.URLParam(r, "team")
+	if err := h.accounts.RemovePackageOwner(pkgName, team); err != nil {
+		h.accountError(w, err, "removing package owner")
+		return
+	}
+	h.logAccountChange(r, "package owner removed", "package", pkgName, "team", team)
+	h.GetPackageOwners(w, r)
+}
+
+// ListUsers handles GET /api/v1/admin/users
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+	users, err := h.accounts.ListUsers()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing users")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if users == nil {
+		users = []models.User{}
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+// CreateUser handles POST /api/v1/admin/users
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := validateAccountName(req.Name); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	user, err := h.accounts.CreateUser(req.Name)
+	if err != nil {
+		h.accountError(w, err, "creating user")
+		return
+	}
+	h.logAccountChange(r, "user created", "user", user.Name)
+	writeJSON(w, http.StatusCreated, user)
+}
+
+// DeleteUser handles DELETE /api/v1/admin/users/{user}. The user's tokens
+// are revoked.
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+	name := chi.URLParam(r, "user")
+	if err := h.accounts.DeleteUser(name); err != nil {
+		h.accountError(w, err, "deleting user")
+		return
+	}
+	h.logAccountChange(r, "user deleted", "user", name)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ListTeams handles GET /api/v1/admin/teams
+func (h *Handler) ListTeams(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+	teams, err := h.accounts.ListTeams()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing teams")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if teams == nil {
+		teams = []models.Team{}
+	}
+	writeJSON(w, http.StatusOK, teams)
+}
+
+// CreateTeam handles POST /api/v1/admin/teams
+func (h *Handler) CreateTeam(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+	var req struct {
+		Name     string `json:"name"`
+		SSOGroup string `json:"sso_group"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := validateAccountName(req.Name); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	team, err := h.accounts.CreateTeam(req.Name, strings.TrimSpace(req.SSOGroup))
+	if err != nil {
+		h.accountError(w, err, "creating team")
+		return
+	}
+	h.logAccountChange(r, "team created", "team", team.Name)
+	writeJSON(w, http.StatusCreated, team)
+}
+
+// GetTeam handles GET /api/v1/admin/teams/{team}
+func (h *Handler) GetTeam(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+	name := chi.URLParam(r, "team")
+	team, err := h.accounts.GetTeam(name)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting team")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if team == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("team %s not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, team)
+}
+
+// DeleteTeam handles DELETE /api/v1/admin/teams/{team}. The packages it
+// owned lose it as an owner.
+func (h *Handler) DeleteTeam(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+	name := chi.URLParam(r, "team")
+	if err := h.accounts.DeleteTeam(name); err != nil {
+		h.accountError(w, err, "deleting team")
+		return
+	}
+	h.logAccountChange(r, "team deleted", "team", name)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// AddTeamMember handles PUT /api/v1/admin/teams/{team}/members/{user}
+func (h *Handler) AddTeamMember(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+	team, user := chi.URLParam(r, "team"), chi.URLParam(r, "user")
+	if err := h.accounts.AddTeamMember(team, user); err != nil {
+		h.accountError(w, err, "adding team member")
+		return
+	}
+	h.logAccountChange(r, "team member added", "team", team, "user", user)
+	h.GetTeam(w, r)
+}
+
+// RemoveTeamMember handles DELETE /api/v1/admin/teams/{team}/members/{user}
+func (h *Handler) RemoveTeamMember(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+	team, user := chi.URLParam(r, "team"), chi.URLParam(r, "user")
+	if err := h.accounts.RemoveTeamMember(team, user); err != nil {
+		h.accountError(w, err, "removing team member")
+		return
+	}
+	h.logAccountChange(r, "team member removed", "team", team, "user", user)
+	h.GetTeam(w, r)
+}
+
+// validateAccountName checks a user or team name, which appears in URL
+// paths.
+func validateAccountName(name string) error {
+	if name == "" || len(name) > 100 || strings.ContainsAny(name, "/ \t\r\n") {
+		return errors.New("name must be 1 to 100 characters without slashes or spaces")
+	}
+	return nil
+}
+
+// accountError answers with the status an AccountStore error calls for.
+func (h *Handler) accountError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrConflict):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		h.logger.Error().Err(err).Msg(action)
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// logAccountChange logs a change to users, teams or ownership with the
+// string fields kv.
+func (h *Handler) logAccountChange(r *http.Request, msg string, kv ...string) {
+	ev := h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context()))
+	for i := 0; i+1 < len(kv); i += 2 {
+		ev = ev.Str(kv[i], kv[i+1])
+	}
+	ev.Msg(msg)
+}
+
+func (h *Handler) accountsEnabled(w http.ResponseWriter) bool {
+	if h.accounts == nil {
+		writeError(w, http.StatusNotImplemented, "accounts are not enabled")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/adapters/auth"
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, p *models.Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFrom returns the authenticated caller, or nil on unauthenticated
+// routes.
+func principalFrom(ctx context.Context) *models.Principal {
+	p, _ := ctx.Value(principalKey{}).(*models.Principal)
+	return p
+}
+
+// WithTokenStore enables the token management endpoints, issuing tokens
+// into store. The server's Authenticator should also consult store (see
+// auth.NewStoreAuth) for issued tokens to be accepted. If store keeps
+// usage totals, the use of issued tokens is recorded; see
+// FlushTokenUsage.
+func WithTokenStore(store services.TokenStore) Option {
+	return func(h *Handler) {
+		h.tokens = store
+		if us, ok := store.(services.TokenUsageStore); ok {
+			h.tokenUsage = newTokenUsage(us)
+		}
+	}
+}
+
+// adminMiddleware rejects callers whose token lacks admin rights.
+func (h *Handler) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			writeError(w, http.StatusForbidden, "admin token required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stats handles GET /api/v1/admin/stats
+func (h *Handler) Stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.registryStats()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("querying stats")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// registryStats combines the metadata store's counts with what blob
+// storage holds and the room left in it.
+func (h *Handler) registryStats() (*models.RegistryStats, error) {
+	stats, err := h.meta.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, err := h.blobs.ListBlobs()
+	if err != nil {
+		return nil, fmt.Errorf("listing blobs: %w", err)
+	}
+	stats.StoredBlobs = len(blobs)
+	for _, hash := range blobs {
+		if size, err := h.blobs.Size(hash); err == nil {
+			stats.StoredBytes += size
+		}
+	}
+	if free, ok := h.freeSpace(); ok {
+		stats.FreeBytes = &free
+	}
+	stats.ReserveBytes = h.storageReserve
+	return stats, nil
+}
+
+// Usage handles GET /api/v1/admin/usage, reporting the storage each package
+// and namespace takes, largest first. ?limit= caps both lists. The counts are
+// kept up to date as files come and go, so this does not scan the registry.
+func (h *Handler) Usage(w http.ResponseWriter, r *http.Request) {
+	limit, err := pageLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	report, err := h.meta.Usage()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("querying usage")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	report.Packages = largestUsage(report.Packages, limit)
+	report.Namespaces = largestUsage(report.Namespaces, limit)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// largestUsage sorts usage by physical size, largest first, and keeps the
+// first limit entries.
+func largestUsage(usage []models.Usage, limit int) []models.Usage {
+	slices.SortStableFunc(usage, func(a, b models.Usage) int {
+		return cmp.Compare(b.PhysicalBytes, a.PhysicalBytes)
+	})
+	if len(usage) > limit {
+		usage = usage[:limit]
+	}
+	return usage
+}
+
+// QueryMetrics handles GET /api/v1/admin/metrics/queries, reporting the
+// latency of metadata queries by the store method that ran them, most
+// total time first. ?limit= caps the list.
+func (h *Handler) QueryMetrics(w http.ResponseWriter, r *http.Request) {
+	limit, err := pageLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	qm, ok := h.meta.(services.QueryMetrics)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "the metadata store does not record query metrics")
+		return
+	}
+	stats := qm.QueryStats()
+	slices.SortStableFunc(stats, func(a, b models.QueryStat) int {
+		return cmp.Compare(b.TotalMicros, a.TotalMicros)
+	})
+	if len(stats) > limit {
+		stats = stats[:limit]
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// StorageMetrics handles GET /api/v1/admin/metrics/storage, reporting how
+// fast blob storage has written blobs since the registry started.
+func (h *Handler) StorageMetrics(w http.ResponseWriter, r *http.Request) {
+	wm, ok := h.blobs.(services.WriteMetrics)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "blob storage does not record write metrics")
+		return
+	}
+	writeJSON(w, http.StatusOK, wm.WriteStats())
+}
+
+// ListTokens handles GET /api/v1/admin/tokens
+func (h *Handler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	if !h.tokenStoreEnabled(w) {
+		return
+	}
+	tokens, err := h.tokens.ListTokens()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing tokens")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if tokens == nil {
+		tokens = []models.APIToken{}
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// CreateToken handles POST /api/v1/admin/tokens
+func (h *Handler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	if !h.tokenStoreEnabled(w) {
+		return
+	}
+
+	var req models.CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if req.User != "" {
+		if !h.accountsEnabled(w) {
+			return
+		}
+		user, err := h.accounts.GetUser(req.User)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("getting user")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if user == nil {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("user %s not found", req.User))
+			return
+		}
+	}
+
+	secret, err := auth.GenerateToken()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("generating token")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	token, err := h.tokens.CreateToken(req.Name, auth.HashToken(secret), req.Admin)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("creating token")
+		writeError(w, http.StatusInternalServerError, "failed to create token")
+		return
+	}
+	if req.User != "" {
+		if err := h.accounts.SetTokenUser(token.ID, req.User); err != nil {
+			h.logger.Error().Err(err).Msg("setting token user")
+			h.tokens.RevokeToken(token.ID)
+			writeError(w, http.StatusInternalServerError, "failed to create token")
+			return
+		}
+		token.User = req.User
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Int64("token_id", token.ID).
+		Str("name", token.Name).
+		Bool("admin", token.Admin).
+		Str("user", token.User).
+		Msg("token created")
+
+	writeJSON(w, http.StatusCreated, models.CreateTokenResponse{APIToken: *token, Token: secret})
+}
+
+// RevokeToken handles DELETE /api/v1/admin/tokens/{id}
+func (h *Handler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	if !h.tokenStoreEnabled(w) {
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid token id")
+		return
+	}
+	if err := h.tokens.RevokeToken(id); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("token %d not found or already revoked", id))
+			return
+		}
+		h.logger.Error().Err(err).Msg("revoking token")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Int64("token_id", id).
+		Msg("token revoked")
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+}
+
+func (h *Handler) tokenStoreEnabled(w http.ResponseWriter) bool {
+	if h.tokens == nil {
+		writeError(w, http.StatusNotImplemented, "token management is not enabled")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// ApprovalRules chooses the destructive operations held until a second
+// admin approves them.
+type ApprovalRules struct {
+	// Packages are the package name prefixes whose version deletes need
+	// approval; "" covers every package.
+	Packages []string
+	// GCBytes holds garbage collections that would free more than this
+	// many bytes. Zero lets every collection run.
+	GCBytes int64
+}
+
+// approvals holds operations for approval and carries them out once
+// approved.
+type approvals struct {
+	store services.ApprovalStore
+	rules ApprovalRules
+	// mu serializes decisions, so an approved operation runs once.
+	mu sync.Mutex
+}
+
+// WithApprovals holds the operations rules name for approval, keeping the
+// requests in store. Callers asking for one get 202 with a pending
+// approval, and the operation runs when an admin other than the requester
+// approves it.
+func WithApprovals(store services.ApprovalStore, rules ApprovalRules) Option {
+	return func(h *Handler) {
+		h.approvals = &approvals{store: store, rules: rules}
+	}
+}
+
+// protects reports whether deleting versions of pkgName needs approval.
+func (a *approvals) protects(pkgName string) bool {
+	if a == nil {
+		return false
+	}
+	for _, prefix := range a.rules.Packages {
+		if strings.HasPrefix(pkgName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// holdsGC reports whether a collection freeing freed bytes needs approval.
+func (a *approvals) holdsGC(freed int64) bool {
+	return a != nil && a.rules.GCBytes > 0 && freed > a.rules.GCBytes
+}
+
+// approvalIdentity is who p is for approvals: its user if it has one, else
+// its token name. Requester and approver must differ.
+func approvalIdentity(p *models.Principal) string {
+	switch {
+	case p == nil:
+		return ""
+	case p.User != "":
+		return p.User
+	default:
+		return p.Name
+	}
+}
+
+// holdDelete holds the deletion of pkg@version for approval, answering 404
+// or 409 at once if it could not succeed.
+func (h *Handler) holdDelete(w http.ResponseWriter, r *http.Request, pkgName, version string) {
+	artifact, err := h.meta.GetArtifact(pkgName, version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting artifact")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if artifact == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", pkgName, version))
+		return
+	}
+	if artifact.Pinned {
+		writeError(w, http.StatusConflict, fmt.Sprintf("artifact %s@%s is pinned; unpin it before deleting", pkgName, version))
+		return
+	}
+	h.holdForApproval(w, r, models.Approval{
+		Action:  models.ApprovalActionDelete,
+		Package: pkgName,
+		Version: version,
+		Reason:  fmt.Sprintf("%s is a protected package", pkgName),
+	})
+}
+
+// holdForApproval records a as pending on behalf of the caller of r and
+// answers 202 with it.
+func (h *Handler) holdForApproval(w http.ResponseWriter, r *http.Request, a models.Approval) {
+	created, err := h.requestApproval(r, a)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("creating approval")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	w.Header().Set("Location", h.externalURL(r)+"/api/v1/admin/approvals/"+strconv.FormatInt(created.ID, 10))
+	writeJSON(w, http.StatusAccepted, created)
+}
+
+// requestApproval records a as pending on behalf of the caller of r.
+func (h *Handler) requestApproval(r *http.Request, a models.Approval) (*models.Approval, error) {
+	a.RequestedBy = approvalIdentity(principalFrom(r.Context()))
+	created, err := h.approvals.store.CreateApproval(a)
+	if err != nil {
+		return nil, err
+	}
+	h.logApproval(r, created, "approval requested")
+	return created, nil
+}
+
+// ListApprovals handles GET /api/v1/admin/approvals, newest first.
+// ?status= narrows the list to pending, approved, rejected or failed ones.
+func (h *Handler) ListApprovals(w http.ResponseWriter, r *http.Request) {
+	if !h.approvalsEnabled(w) {
+		return
+	}
+	list, err := h.approvals.store.ListApprovals(r.URL.Query().Get("status"))
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing approvals")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if list == nil {
+		list = []models.Approval{}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// GetApproval handles GET /api/v1/admin/approvals/{id}
+func (h *Handler) GetApproval(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.lookupApproval(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// ApproveApproval handles POST /api/v1/admin/approvals/{id}/approve,
+// carrying out the operation. Only an admin other than the requester may
+// approve. An operation that fails leaves the approval failed, with the
+// reason as its result; a collection starts as a background job.
+func (h *Handler) ApproveApproval(w http.ResponseWriter, r *http.Request) {
+	if !h.approvalsEnabled(w) {
+		return
+	}
+	h.approvals.mu.Lock()
+	defer h.approvals.mu.Unlock()
+	a, ok := h.lookupPendingApproval(w, r)
+	if !ok {
+		return
+	}
+	by := approvalIdentity(principalFrom(r.Context()))
+	if by == a.RequestedBy {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("approval %d was requested by %s and needs another admin", a.ID, by))
+		return
+	}
+
+	status, result := models.ApprovalApproved, ""
+	switch a.Action {
+	case models.ApprovalActionDelete:
+		unlock := h.lockArtifactUpload(a.Package, a.Version)
+		err := h.deleteVersion(r, a.RequestedBy, a.Package, a.Version)
+		unlock()
+		switch {
+		case err == nil:
+			result = "deleted"
+		case errors.Is(err, services.ErrConflict):
+			status, result = models.ApprovalFailed, fmt.Sprintf("artifact %s@%s is pinned", a.Package, a.Version)
+		default:
+			if !errors.Is(err, services.ErrNotFound) {
+				h.logger.Error().Err(err).Msg("deleting approved artifact")
+			}
+			status, result = models.ApprovalFailed, err.Error()
+		}
+	case models.ApprovalActionGC:
+		if !h.gc.begin() {
+			writeError(w, http.StatusConflict, "garbage collection is already running; approve again once it finishes")
+			return
+		}
+		job := h.startGCJob(r, false, a.Tombstone)
+		result = "started job " + job.ID
+	default:
+		status, result = models.ApprovalFailed, fmt.Sprintf("unknown action %q", a.Action)
+	}
+	h.decideApproval(w, r, a, status, by, result)
+}
+
+// RejectApproval handles POST /api/v1/admin/approvals/{id}/reject. Any
+// admin may reject, the requester included.
+func (h *Handler) RejectApproval(w http.ResponseWriter, r *http.Request) {
+	if !h.approvalsEnabled(w) {
+		return
+	}
+	h.approvals.mu.Lock()
+	defer h.approvals.mu.Unlock()
+	a, ok := h.lookupPendingApproval(w, r)
+	if !ok {
+		return
+	}
+	h.decideApproval(w, r, a, models.ApprovalRejected, approvalIdentity(principalFrom(r.Context())), "")
+}
+
+// decideApproval records the decision on a and answers with the approval.
+func (h *Handler) decideApproval(w http.ResponseWriter, r *http.Request, a *models.Approval, status, by, result string) {
+	if err := h.approvals.store.DecideApproval(a.ID, status, by, result); err != nil {
+		h.logger.Error().Err(err).Int64("approval", a.ID).Msg("recording approval decision")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	decided, err := h.approvals.store.GetApproval(a.ID)
+	if err != nil || decided == nil {
+		h.logger.Error().Err(err).Int64("approval", a.ID).Msg("getting approval")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	h.logApproval(r, decided, "approval decided")
+	writeJSON(w, http.StatusOK, decided)
+}
+
+// lookupApproval loads the approval named in the URL, writing a 404 if it
+// does not exist.
+func (h *Handler) lookupApproval(w http.ResponseWriter, r *http.Request) (*models.Approval, bool) {
+	if !h.approvalsEnabled(w) {
+		return nil, false
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid approval id")
+		return nil, false
+	}
+	a, err := h.approvals.store.GetApproval(id)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting approval")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return nil, false
+	}
+	if a == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("approval %d not found", id))
+		return nil, false
+	}
+	return a, true
+}
+
+// lookupPendingApproval is lookupApproval, writing a 409 if the approval
+// was already decided.
+func (h *Handler) lookupPendingApproval(w http.ResponseWriter, r *http.Request) (*models.Approval, bool) {
+	a, ok := h.lookupApproval(w, r)
+	if ok && a.Status != models.ApprovalPending {
+		writeError(w, http.StatusConflict, fmt.Sprintf("approval %d is already %s", a.ID, a.Status))
+		return nil, false
+	}
+	return a, ok
+}
+
+// logApproval logs a request for approval or its decision, the audit trail
+// of held operations alongside the approvals themselves.
+func (h *Handler) logApproval(r *http.Request, a *models.Approval, msg string) {
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Int64("approval", a.ID).
+		Str("action", a.Action).
+		Str("package", a.Package).
+		Str("version", a.Version).
+		Str("status", a.Status).
+		Str("requested_by", a.RequestedBy).
+		Str("decided_by", a.DecidedBy).
+		Str("result", a.Result).
+		Msg(msg)
+}
+
+func (h *Handler) approvalsEnabled(w http.ResponseWriter) bool {
+	if h.approvals == nil {
+		writeError(w, http.StatusNotImplemented, "approvals are not enabled")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// maxArchiveEntries bounds how many files one archive request may bundle.
+const maxArchiveEntries = 1000
+
+// archiveManifestName is the first entry of every archive.
+const archiveManifestName = "manifest.json"
+
+// DownloadArchive handles POST /api/v1/archive. It streams the requested
+// files as one tar or zip, led by a manifest.json listing each file's path,
+// hash and size. Every entry is resolved before the response starts, so a
+// missing or blocked artifact fails the whole request with the usual status.
+func (h *Handler) DownloadArchive(w http.ResponseWriter, r *http.Request) {
+	var req models.ArchiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	format := req.Format
+	switch format {
+	case "":
+		format = "tar"
+	case "tar", "zip":
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported archive format %q: want tar or zip", req.Format))
+		return
+	}
+	if len(req.Artifacts) == 0 {
+		writeError(w, http.StatusBadRequest, "artifacts must list at least one entry")
+		return
+	}
+	if len(req.Artifacts) > maxArchiveEntries {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("an archive holds at most %d artifacts", maxArchiveEntries))
+		return
+	}
+
+	files, manifest, ok := h.resolveArchive(w, r, req.Artifacts)
+	if !ok {
+		return
+	}
+
+	w, release, ok := h.limitDownload(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	contentType := "application/x-tar"
+	if format == "zip" {
+		contentType = "application/zip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", contentDisposition("artifacts."+format))
+	w.WriteHeader(http.StatusOK)
+
+	var err error
+	if format == "zip" {
+		err = h.writeZipArchive(w, manifest, files)
+	} else {
+		err = h.writeTarArchive(w, manifest, files)
+	}
+	if err != nil {
+		h.logger.Error().Err(err).
+			Str("request_id", logging.RequestID(r.Context())).
+			Msg("streaming archive")
+		// The status is already sent. Dropping the connection leaves the
+		// client with a visibly truncated archive rather than one that
+		// looks complete.
+		panic(http.ErrAbortHandler)
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("format", format).
+		Int("files", len(files)).
+		Msg("archive downloaded")
+}
+
+// resolveArchive looks up every entry, answering the request and returning
+// ok=false on the first that cannot be served. Repeated entries are
+// included once.
+func (h *Handler) resolveArchive(w http.ResponseWriter, r *http.Request, entries []models.ArchiveEntry) ([]*models.Artifact, models.ArchiveManifest, bool) {
+	var files []*models.Artifact
+	manifest := models.ArchiveManifest{Artifacts: []models.ArchiveEntry{}}
+	seen := make(map[string]bool)
+
+	for _, e := range entries {
+		if e.Package == "" || e.Version == "" {
+			writeError(w, http.StatusBadRequest, "each archive entry needs a package and a version")
+			return nil, manifest, false
+		}
+		if e.File != "" && sanitizeFilename(e.File) != e.File {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid file name %q", e.File))
+			return nil, manifest, false
+		}
+
+		artifact, err := h.meta.GetArtifact(e.Package, e.Version)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("getting artifact")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return nil, manifest, false
+		}
+		if artifact == nil || hidden(r, artifact) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", e.Package, e.Version))
+			return nil, manifest, false
+		}
+		if reason := h.scanBlock(artifact); reason != "" {
+			writeError(w, http.StatusForbidden, reason)
+			return nil, manifest, false
+		}
+
+		file := artifact
+		if e.File != "" && e.File != downloadFilename(artifact) {
+			asset, err := h.meta.GetAsset(artifact.Package, artifact.Version, e.File)
+			if err != nil {
+				h.logger.Error().Err(err).Msg("getting asset")
+				writeError(w, http.StatusInternalServerError, "internal error")
+				return nil, manifest, false
+			}
+			if asset == nil {
+				writeError(w, http.StatusNotFound, fmt.Sprintf("file %s not found in %s@%s", e.File, artifact.Package, artifact.Version))
+				return nil, manifest, false
+			}
+			file = assetFile(artifact, asset)
+		}
+
+		name := archivePath(file)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		files = append(files, file)
+		manifest.Artifacts = append(manifest.Artifacts, models.ArchiveEntry{
+			Package: file.Package,
+			Version: file.Version,
+			File:    downloadFilename(file),
+			Path:    name,
+			Hash:    file.Hash,
+			Size:    file.Size,
+		})
+	}
+	return files, manifest, true
+}
+
+// archivePath places a file at <package>/<version>/<filename>, with each
+// segment flattened so no entry can escape the extraction directory.
+func archivePath(file *models.Artifact) string {
+	segment := func(s string) string {
+		s = strings.NewReplacer("/", "_", "\\", "_").Replace(s)
+		if s == "." || s == ".." {
+			return "_"
+		}
+		return s
+	}
+	return segment(file.Package) + "/" + segment(file.Version) + "/" + segment(downloadFilename(file))
+}
+
+func (h *Handler) writeTarArchive(w io.Writer, manifest models.ArchiveManifest, files []*models.Artifact) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	if err := tw.WriteHeader(&tar.Header{
+		Name: archiveManifestName, Mode: 0o644, Size: int64(len(data)), ModTime: h.clock.Now(),
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	for i, file := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Name: manifest.Artifacts[i].Path, Mode: 0o644, Size: file.Size, ModTime: file.UploadedAt,
+		}); err != nil {
+			return err
+		}
+		if err := h.copyBlob(tw, file); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (h *Handler) writeZipArchive(w io.Writer, manifest models.ArchiveManifest, files []*models.Artifact) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: archiveManifestName, Method: zip.Deflate, Modified: h.clock.Now()})
+	if err != nil {
+		return err
+	}
+	if _, err := mw.Write(data); err != nil {
+		return err
+	}
+
+	for i, file := range files {
+		// Artifacts are usually compressed already, so they are stored as is.
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: manifest.Artifacts[i].Path, Method: zip.Store, Modified: file.UploadedAt})
+		if err != nil {
+			return err
+		}
+		if err := h.copyBlob(fw, file); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// copyBlob writes file's contents to w.
+func (h *Handler) copyBlob(w io.Writer, file *models.Artifact) error {
+	defer h.leaseBlob(file.Hash)()
+	reader, err := h.blobs.Open(file.Hash)
+	if err != nil {
+		return fmt.Errorf("opening blob for %s@%s: %w", file.Package, file.Version, err)
+	}
+	defer reader.Close()
+	if _, err := io.Copy(w, reader); err != nil {
+		return fmt.Errorf("copying blob for %s@%s: %w", file.Package, file.Version, err)
+	}
+	return nil
+}
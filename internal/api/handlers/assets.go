@@ -0,0 +1,385 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// A version's files are its default file, the one served by the
+// single-file artifact routes, plus any named assets. The default file is
+// addressed under /files by its download filename.
+
+// ListFiles handles GET /api/v1/artifacts/{package}/{version}/files
+func (h *Handler) ListFiles(w http.ResponseWriter, r *http.Request) {
+	artifact, ok := h.lookupArtifact(w, r)
+	if !ok {
+		return
+	}
+
+	files, err := h.versionFiles(artifact)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing assets")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	h.cacheListing(w)
+	writeJSON(w, http.StatusOK, files)
+}
+
+// versionFiles returns the default file of a version followed by its assets.
+func (h *Handler) versionFiles(artifact *models.Artifact) ([]models.Asset, error) {
+	assets, err := h.meta.ListAssets(artifact.Package, artifact.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]models.Asset, 0, len(assets)+1)
+	files = append(files, models.Asset{
+		ArtifactID:  artifact.ID,
+		Name:        downloadFilename(artifact),
+		Hash:        artifact.Hash,
+		Size:        artifact.Size,
+		ContentType: artifact.ContentType,
+		Default:     true,
+		UploadedAt:  artifact.UploadedAt,
+	})
+	return append(files, assets...), nil
+}
+
+// UploadFile handles POST /api/v1/artifacts/{package}/{version}/files/{name}.
+// The first file uploaded to a new version becomes its default file; later
+// ones are added as assets.
+func (h *Handler) UploadFile(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	pkgName := h.naming.Normalize(chi.URLParam(r, "package"))
+	version := chi.URLParam(r, "version")
+	name, ok := fileName(w, r)
+	if !ok {
+		return
+	}
+	if !h.checkNaming(w, r, pkgName, version) {
+		return
+	}
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionUpload, Package: pkgName, Version: version, File: name}) {
+		return
+	}
+	opts, ok := h.uploadVersionOptions(w, r)
+	if !ok {
+		return
+	}
+	w, r, finish, ok := h.trackUpload(w, r, pkgName, version, name)
+	if !ok {
+		return
+	}
+	defer finish()
+
+	release, ok := h.limitUpload(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	unlock := h.lockArtifactUpload(pkgName, version)
+	defer unlock()
+
+	artifact, err := h.meta.GetArtifact(pkgName, version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting artifact")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if !h.checkIfMatch(w, r, artifact) {
+		return
+	}
+	if artifact != nil && downloadFilename(artifact) == name {
+		writeError(w, http.StatusConflict, fmt.Sprintf("file %s already exists in %s@%s", name, pkgName, version))
+		return
+	}
+
+	upload, err := uploadBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hash, size, releaseBlob, err := h.storeBlob(r.Context(), pkgName, upload.body)
+	if err != nil {
+		status, msg := h.storeFailure(err)
+		writeError(w, status, msg)
+		return
+	}
+	defer releaseBlob()
+	setUploadState(r, models.UploadVerifying)
+	if expected := r.Header.Get("X-Artifact-Hash"); expected != "" && expected != hash {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("digest mismatch: expected %s, got %s", expected, hash))
+		return
+	}
+
+	event := models.HookEvent{Hook: models.HookPreUpload, Package: pkgName, Version: version, File: name, Hash: hash, Size: size}
+	if !h.checkUpload(w, r, &event) {
+		return
+	}
+	setUploadState(r, models.UploadRecording)
+	file, err := h.attachFile(r, pkgName, version, opts, models.AssetInput{
+		Name:        name,
+		Hash:        hash,
+		Size:        size,
+		ContentType: upload.contentType,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrConflict) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("file %s already exists in %s@%s", name, pkgName, version))
+			return
+		}
+		if errors.Is(err, services.ErrReadOnly) {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		h.logger.Error().Err(err).Msg("recording file")
+		writeError(w, http.StatusInternalServerError, "failed to create artifact metadata")
+		return
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("package", pkgName).
+		Str("version", version).
+		Str("file", name).
+		Str("hash", hash).
+		Int64("size", size).
+		Bool("default", file.Default).
+		Dur("upload_latency", time.Since(start)).
+		Msg("file upload completed")
+	h.runPostUpload(r, event)
+
+	writeJSON(w, http.StatusCreated, models.UploadResponse{
+		Package:     pkgName,
+		Version:     version,
+		Hash:        file.Hash,
+		Size:        file.Size,
+		Filename:    file.Name,
+		ContentType: file.ContentType,
+		UploadedAt:  file.UploadedAt,
+	})
+}
+
+// attachFile records a stored blob as a file of pkg@version, creating the
+// version with opts and the file as its default file if the version does
+// not exist yet. The change is recorded in the history as made by r's
+// caller.
+// With quarantine enabled, the version is quarantined again when it gains a
+// file. Callers hold the version's upload lock. A taken name returns
+// ErrConflict, and a version served from a read-only snapshot ErrReadOnly.
+func (h *Handler) attachFile(r *http.Request, pkgName, version string, opts versionOptions, in models.AssetInput) (*models.Asset, error) {
+	artifact, err := h.meta.GetArtifact(pkgName, version)
+	if err != nil {
+		return nil, err
+	}
+	if artifact != nil && artifact.Snapshot != "" {
+		return nil, fmt.Errorf("%w: %s@%s is in snapshot %s", services.ErrReadOnly, pkgName, version, artifact.Snapshot)
+	}
+
+	if artifact == nil {
+		artifact, err = h.meta.CreateArtifactForPackage(pkgName, models.ArtifactInput{
+			Version:     version,
+			Hash:        in.Hash,
+			Size:        in.Size,
+			Filename:    in.Name,
+			ContentType: in.ContentType,
+			Quarantined: h.quarantine,
+			Stage:       opts.stage,
+			Stability:   opts.stability,
+			ExpiresAt:   opts.expiresAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		h.indexContents(artifact.Hash)
+		h.recordHistory(r, models.HistoryEvent{
+			Package: pkgName, Version: version, Action: models.HistoryCreate, NewHash: artifact.Hash,
+		})
+		return &models.Asset{
+			ArtifactID:  artifact.ID,
+			Name:        in.Name,
+			Hash:        artifact.Hash,
+			Size:        artifact.Size,
+			ContentType: artifact.ContentType,
+			Default:     true,
+			UploadedAt:  artifact.UploadedAt,
+		}, nil
+	}
+
+	if downloadFilename(artifact) == in.Name {
+		return nil, fmt.Errorf("%w: file %s already exists", services.ErrConflict, in.Name)
+	}
+	asset, err := h.meta.CreateAsset(artifact.ID, in)
+	if err != nil {
+		return nil, err
+	}
+	h.indexContents(asset.Hash)
+	h.recordHistory(r, models.HistoryEvent{
+		Package: pkgName, Version: version, File: in.Name, Action: models.HistoryCreate, NewHash: asset.Hash,
+	})
+	if h.quarantine && !artifact.Quarantined {
+		if err := h.meta.SetQuarantined(pkgName, version, true); err != nil {
+			return nil, err
+		}
+	}
+	return asset, nil
+}
+
+// DownloadFile handles GET /api/v1/artifacts/{package}/{version}/files/{name}
+func (h *Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
+	file, ok := h.resolveFile(w, r)
+	if !ok {
+		return
+	}
+	h.serveArtifact(w, r, file)
+}
+
+// HeadFile handles HEAD /api/v1/artifacts/{package}/{version}/files/{name}
+func (h *Handler) HeadFile(w http.ResponseWriter, r *http.Request) {
+	file, ok := h.resolveFile(w, r)
+	if !ok {
+		return
+	}
+	h.headArtifact(w, r, file)
+}
+
+// DeleteFile handles DELETE /api/v1/artifacts/{package}/{version}/files/{name}.
+// The default file goes away only with its version, and the files of pinned
+// versions stay until they are unpinned.
+func (h *Handler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	artifact, unlock, ok := h.lookupForUpdate(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+	name, ok := fileName(w, r)
+	if !ok {
+		return
+	}
+	if downloadFilename(artifact) == name {
+		writeError(w, http.StatusConflict, "the default file is removed by deleting the version")
+		return
+	}
+	if artifact.Pinned {
+		writeError(w, http.StatusConflict, fmt.Sprintf("artifact %s@%s is pinned; unpin it before deleting", artifact.Package, artifact.Version))
+		return
+	}
+	if !h.checkPolicy(w, r, models.PolicyRequest{
+		Action: models.PolicyActionDelete, Package: artifact.Package, Version: artifact.Version, File: name,
+	}) {
+		return
+	}
+	if !h.checkHooks(w, r, models.HookEvent{
+		Hook: models.HookPreDelete, Package: artifact.Package, Version: artifact.Version, File: name,
+	}) {
+		return
+	}
+
+	asset, err := h.meta.GetAsset(artifact.Package, artifact.Version, name)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting asset")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if asset == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("file %s not found in %s@%s", name, artifact.Package, artifact.Version))
+		return
+	}
+	if err := h.meta.DeleteAsset(artifact.Package, artifact.Version, name); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		h.logger.Error().Err(err).Msg("deleting asset")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	h.recordHistory(r, models.HistoryEvent{
+		Package: artifact.Package, Version: artifact.Version, File: name, Action: models.HistoryDelete, OldHash: asset.Hash,
+	})
+	h.reclaimReleased()
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// lookupArtifact loads the version named in the URL, writing a 404 if it
+// does not exist.
+func (h *Handler) lookupArtifact(w http.ResponseWriter, r *http.Request) (*models.Artifact, bool) {
+	pkgName := chi.URLParam(r, "package")
+	version := chi.URLParam(r, "version")
+
+	artifact, err := h.meta.GetArtifact(pkgName, version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting artifact")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return nil, false
+	}
+	if artifact == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", pkgName, version))
+		return nil, false
+	}
+	return artifact, true
+}
+
+// resolveFile finds the named file of a version and describes it as an
+// artifact so the regular download path can serve it.
+func (h *Handler) resolveFile(w http.ResponseWriter, r *http.Request) (*models.Artifact, bool) {
+	artifact, ok := h.lookupArtifact(w, r)
+	if !ok {
+		return nil, false
+	}
+	name, ok := fileName(w, r)
+	if !ok {
+		return nil, false
+	}
+	if downloadFilename(artifact) == name {
+		return artifact, true
+	}
+
+	asset, err := h.meta.GetAsset(artifact.Package, artifact.Version, name)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting asset")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return nil, false
+	}
+	if asset == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("file %s not found in %s@%s", name, artifact.Package, artifact.Version))
+		return nil, false
+	}
+
+	return assetFile(artifact, asset), true
+}
+
+// assetFile describes a named file of artifact as an artifact of its own,
+// so it can be served the same way as the default file.
+func assetFile(artifact *models.Artifact, asset *models.Asset) *models.Artifact {
+	file := *artifact
+	file.Hash = asset.Hash
+	file.Size = asset.Size
+	file.Filename = asset.Name
+	file.ContentType = asset.ContentType
+	file.UploadedAt = asset.UploadedAt
+	return &file
+}
+
+// fileName returns the {name} URL parameter, rejecting names that are not
+// plain file names.
+func fileName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, err := url.PathUnescape(chi.URLParam(r, "name"))
+	if err != nil || name == "" || sanitizeFilename(name) != name {
+		writeError(w, http.StatusBadRequest, "invalid file name")
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/dsse"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// maxAttestationSize bounds attestation uploads, which are parsed in
+// memory to find their subjects and builder.
+const maxAttestationSize = 16 << 20
+
+// inTotoPayloadType is the DSSE payload type of in-toto statements.
+const inTotoPayloadType = "application/vnd.in-toto+json"
+
+// TrustedBuilder is a build platform whose provenance the registry
+// verifies: ID is the builder ID its provenance names, and Key the public
+// key it signs attestations with.
+type TrustedBuilder struct {
+	ID  string
+	Key crypto.PublicKey
+}
+
+// WithTrustedBuilders verifies uploaded attestations against builders. A
+// builder ID may be listed more than once, one entry per key. With require
+// set, attestations that are not signed by a trusted builder are refused.
+func WithTrustedBuilders(builders []TrustedBuilder, require bool) Option {
+	return func(h *Handler) {
+		h.trustedBuilders = builders
+		h.requireVerified = require
+	}
+}
+
+// statement holds the fields of an in-toto statement the registry reads:
+// its subjects, predicate type and, for SLSA provenance v0.2 and v1, the
+// builder ID.
+type statement struct {
+	Type    string `json:"_type"`
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string `json:"predicateType"`
+	Predicate     struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		RunDetails struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+		} `json:"runDetails"`
+	} `json:"predicate"`
+}
+
+// parseAttestation reads a DSSE envelope holding an in-toto statement.
+func parseAttestation(data []byte) (*dsse.Envelope, *statement, error) {
+	env, payload, err := dsse.Parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if env.PayloadType != inTotoPayloadType {
+		return nil, nil, fmt.Errorf("unsupported payload type %q: expected %s", env.PayloadType, inTotoPayloadType)
+	}
+	var st statement
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return nil, nil, fmt.Errorf("invalid in-toto statement JSON: %v", err)
+	}
+	if !strings.HasPrefix(st.Type, "https://in-toto.io/Statement/") {
+		return nil, nil, fmt.Errorf("unsupported statement type %q", st.Type)
+	}
+	if st.PredicateType == "" {
+		return nil, nil, errors.New("in-toto statement has no predicateType")
+	}
+	return env, &st, nil
+}
+
+// builder returns the builder ID of a SLSA provenance predicate, or "".
+func (st *statement) builder() string {
+	if id := st.Predicate.RunDetails.Builder.ID; id != "" {
+		return id
+	}
+	return st.Predicate.Builder.ID
+}
+
+// verifyBuilder reports whether env is signed by a trusted key of the
+// builder with ID id.
+func (h *Handler) verifyBuilder(env *dsse.Envelope, id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, b := range h.trustedBuilders {
+		if b.ID == id && env.Verify(b.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddAttestation handles POST /api/v1/artifacts/{package}/{version}/attestations,
+// attaching a DSSE envelope holding an in-toto statement, such as SLSA
+// provenance, about the version's default file or one of its named files.
+func (h *Handler) AddAttestation(w http.ResponseWriter, r *http.Request) {
+	artifact, unlock, ok := h.lookupForUpdate(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionUpload, Package: artifact.Package, Version: artifact.Version}) {
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxAttestationSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "reading attestation")
+		return
+	}
+	if len(data) > maxAttestationSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("attestation exceeds %d bytes", maxAttestationSize))
+		return
+	}
+	env, st, err := parseAttestation(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The statement must be about one of the version's files.
+	files := map[string]bool{artifact.Hash: true}
+	assets, err := h.meta.ListAssets(artifact.Package, artifact.Version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing files")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	for _, a := range assets {
+		files[a.Hash] = true
+	}
+	subject := ""
+	for _, s := range st.Subject {
+		if digest := strings.ToLower(s.Digest["sha256"]); files[digest] {
+			subject = digest
+			break
+		}
+	}
+	if subject == "" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("attestation subjects do not include a file of %s@%s by sha256 digest", artifact.Package, artifact.Version))
+		return
+	}
+
+	builder := st.builder()
+	verified := h.verifyBuilder(env, builder)
+	if h.requireVerified && !verified {
+		reason := "attestation is not signed by a trusted builder"
+		if builder != "" {
+			reason = fmt.Sprintf("attestation is not signed by trusted builder %q", builder)
+		}
+		writeError(w, http.StatusUnprocessableEntity, reason)
+		return
+	}
+
+	hash, size, releaseBlob, err := h.storeBlob(r.Context(), artifact.Package, bytes.NewReader(data))
+	if err != nil {
+		status, msg := h.storeFailure(err)
+		writeError(w, status, msg)
+		return
+	}
+	defer releaseBlob()
+
+	att, err := h.meta.AddAttestation(artifact.Package, artifact.Version, models.Attestation{
+		Hash:          hash,
+		Size:          size,
+		Subject:       subject,
+		PredicateType: st.PredicateType,
+		Builder:       builder,
+		Verified:      verified,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", artifact.Package, artifact.Version))
+			return
+		}
+		h.logger.Error().Err(err).Msg("recording attestation")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("package", artifact.Package).
+		Str("version", artifact.Version).
+		Str("hash", hash).
+		Str("predicate_type", st.PredicateType).
+		Str("builder", builder).
+		Bool("verified", verified).
+		Msg("attestation attached")
+
+	writeJSON(w, http.StatusCreated, att)
+}
+
+// ListAttestations handles GET /api/v1/artifacts/{package}/{version}/attestations.
+// ?predicate_type= narrows the list.
+func (h *Handler) ListAttestations(w http.ResponseWriter, r *http.Request) {
+	artifact, ok := h.lookupArtifact(w, r)
+	if !ok {
+		return
+	}
+	atts, err := h.meta.ListAttestations(artifact.Package, artifact.Version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing attestations")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	predicateType := r.URL.Query().Get("predicate_type")
+	out := []models.Attestation{}
+	for _, att := range atts {
+		if predicateType == "" || att.PredicateType == predicateType {
+			out = append(out, att)
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// GetAttestation handles
+// GET /api/v1/artifacts/{package}/{version}/attestations/{id}, serving the
+// DSSE envelope as uploaded. Attestations stay available when the version
+// itself is blocked.
+func (h *Handler) GetAttestation(w http.ResponseWriter, r *http.Request) {
+	artifact, ok := h.lookupArtifact(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid attestation id")
+		return
+	}
+	atts, err := h.meta.ListAttestations(artifact.Package, artifact.Version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing attestations")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	for _, att := range atts {
+		if att.ID != id {
+			continue
+		}
+		// Serve the envelope blob through the regular download path.
+		doc := *artifact
+		doc.Hash = att.Hash
+		doc.Size = att.Size
+		doc.Filename = fmt.Sprintf("%s-%s.%d.intoto.json", artifact.Package, artifact.Version, att.ID)
+		doc.ContentType = "application/json"
+		doc.UploadedAt = att.UploadedAt
+		doc.Vulnerabilities = nil
+		h.serveArtifact(w, r, &doc)
+		return
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s has no attestation %d", artifact.Package, artifact.Version, id))
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// WithBasePath serves every route under prefix, e.g. "/foundry", so the
+// registry can share a host with other services.
+func WithBasePath(prefix string) Option {
+	return func(h *Handler) {
+		h.basePath = cleanPrefix(prefix)
+	}
+}
+
+// cleanPrefix normalizes a path prefix to a leading slash and no trailing
+// slash, returning "" for the root or for values that are not absolute
+// paths.
+func cleanPrefix(prefix string) string {
+	prefix = strings.TrimSpace(prefix)
+	if !strings.HasPrefix(prefix, "/") {
+		return ""
+	}
+	prefix = path.Clean(prefix)
+	if prefix == "/" {
+		return ""
+	}
+	return prefix
+}
+
+// pathPrefix returns the path that precedes the registry's routes in URLs
+// the client can follow: the prefix a trusted proxy stripped, followed by
+// the base path.
+func (h *Handler) pathPrefix(r *http.Request) string {
+	prefix := h.basePath
+	if h.fromTrustedProxy(r) {
+		prefix = cleanPrefix(forwardedValue(r, "X-Forwarded-Prefix")) + prefix
+	}
+	return prefix
+}
+
+// externalURL returns the scheme, host and path prefix the client addressed
+// the registry by.
+func (h *Handler) externalURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if h.fromTrustedProxy(r) {
+		if proto := forwardedValue(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwdHost := forwardedValue(r, "X-Forwarded-Host"); fwdHost != "" {
+			host = fwdHost
+		}
+	}
+	return scheme + "://" + host + h.pathPrefix(r)
+}
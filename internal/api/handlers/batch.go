@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// maxBatchEntries bounds how many versions one batch request may name or,
+// for a batch delete, select.
+const maxBatchEntries = 1000
+
+// BatchGetArtifacts handles POST /api/v1/artifacts/batch-get, returning the
+// metadata of many versions at once. Missing versions are marked in their
+// result rather than failing the request; only a malformed body does that.
+func (h *Handler) BatchGetArtifacts(w http.ResponseWriter, r *http.Request) {
+	var req models.BatchGetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(req.Artifacts) == 0 {
+		writeError(w, http.StatusBadRequest, "artifacts must list at least one entry")
+		return
+	}
+	if len(req.Artifacts) > maxBatchEntries {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("a batch holds at most %d artifacts", maxBatchEntries))
+		return
+	}
+	for _, ref := range req.Artifacts {
+		if ref.Package == "" || ref.Version == "" {
+			writeError(w, http.StatusBadRequest, "each entry needs a package and a version")
+			return
+		}
+	}
+
+	resp := models.BatchGetResponse{Artifacts: make([]models.BatchGetResult, 0, len(req.Artifacts))}
+	for _, ref := range req.Artifacts {
+		result := models.BatchGetResult{Package: ref.Package, Version: ref.Version}
+		artifact, err := h.meta.GetArtifact(ref.Package, ref.Version)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("getting artifact")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if artifact != nil && !hidden(r, artifact) {
+			result.Found = true
+			result.Artifact = artifact
+		}
+		resp.Artifacts = append(resp.Artifacts, result)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// BatchDeleteArtifacts handles POST /api/v1/artifacts/batch-delete,
+// deleting many versions in one call. A call without a confirm token only
+// previews: it lists the selected versions with what would happen to each
+// and returns the token. Passing the token back deletes them, unless the
+// selection has changed since, which answers 409 so the caller previews
+// again. Each version goes through the same checks as a single delete;
+// versions that fail them are reported rather than failing the batch.
+func (h *Handler) BatchDeleteArtifacts(w http.ResponseWriter, r *http.Request) {
+	var req models.BatchDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	selected, ok := h.selectBatchDelete(w, r, req)
+	if !ok {
+		return
+	}
+	results, token := h.previewBatchDelete(r, selected)
+	if req.Confirm == "" {
+		writeJSON(w, http.StatusOK, models.BatchDeleteResponse{DryRun: true, Confirm: token, Artifacts: results})
+		return
+	}
+	if req.Confirm != token {
+		writeError(w, http.StatusConflict, "the selected versions have changed since the preview; preview the deletion again")
+		return
+	}
+
+	resp := models.BatchDeleteResponse{Artifacts: results}
+	for i := range resp.Artifacts {
+		if resp.Artifacts[i].Status != models.BatchDeletePending {
+			continue
+		}
+		h.batchDeleteOne(r, &resp.Artifacts[i])
+		if resp.Artifacts[i].Status == models.BatchDeleteDeleted {
+			resp.Deleted++
+		}
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Int("selected", len(resp.Artifacts)).
+		Int("deleted", resp.Deleted).
+		Msg("batch delete completed")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// selectBatchDelete returns the versions req selects that the caller of r
+// can see, writing a 400 if req is malformed. A listed version that does
+// not exist is returned with only its package and version set.
+func (h *Handler) selectBatchDelete(w http.ResponseWriter, r *http.Request, req models.BatchDeleteRequest) ([]models.Artifact, bool) {
+	if (len(req.Artifacts) > 0) == (req.Filter != nil) {
+		writeError(w, http.StatusBadRequest, "give either artifacts or a filter")
+		return nil, false
+	}
+
+	if req.Filter == nil {
+		if len(req.Artifacts) > maxBatchEntries {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("a batch holds at most %d artifacts", maxBatchEntries))
+			return nil, false
+		}
+		selected := make([]models.Artifact, 0, len(req.Artifacts))
+		for _, ref := range req.Artifacts {
+			if ref.Package == "" || ref.Version == "" {
+				writeError(w, http.StatusBadRequest, "each entry needs a package and a version")
+				return nil, false
+			}
+		}
+		for _, ref := range req.Artifacts {
+			artifact, err := h.meta.GetArtifact(ref.Package, ref.Version)
+			if err != nil {
+				h.logger.Error().Err(err).Msg("getting artifact")
+				writeError(w, http.StatusInternalServerError, "internal error")
+				return nil, false
+			}
+			if artifact == nil || hidden(r, artifact) {
+				artifact = &models.Artifact{Package: ref.Package, Version: ref.Version}
+			}
+			selected = append(selected, *artifact)
+		}
+		return selected, true
+	}
+
+	f := *req.Filter
+	if f == (models.BatchDeleteFilter{}) {
+		writeError(w, http.StatusBadRequest, "a filter must set prefix, older_than or channel")
+		return nil, false
+	}
+	if f.Channel != "" && !slices.Contains(models.Stabilities, f.Channel) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown channel %q: expected one of %s", f.Channel, strings.Join(models.Stabilities, ", ")))
+		return nil, false
+	}
+	var cutoff time.Time
+	if f.OlderThan != "" {
+		age, err := parseTTL(f.OlderThan)
+		if err != nil || age <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid older_than %q: want a duration such as 720h or 30d", f.OlderThan))
+			return nil, false
+		}
+		cutoff = h.clock.Now().Add(-age)
+	}
+
+	artifacts, err := h.meta.FindArtifacts(models.ArtifactFilter{UploadedBefore: cutoff})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("finding artifacts")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return nil, false
+	}
+	var selected []models.Artifact
+	for i := range artifacts {
+		a := &artifacts[i]
+		if !strings.HasPrefix(a.Package, f.Prefix) || f.Channel != "" && a.Stability != f.Channel || hidden(r, a) {
+			continue
+		}
+		selected = append(selected, *a)
+	}
+	if len(selected) > maxBatchEntries {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("the filter selects %d versions; a batch deletes at most %d, so narrow it", len(selected), maxBatchEntries))
+		return nil, false
+	}
+	return selected, true
+}
+
+// previewBatchDelete reports what deleting each selected version would do,
+// checking everything but the pre-delete hooks, which may have effects of
+// their own. The token digests the selection and the outcomes, so it
+// changes when either does.
+func (h *Handler) previewBatchDelete(r *http.Request, selected []models.Artifact) ([]models.BatchDeleteResult, string) {
+	results := make([]models.BatchDeleteResult, 0, len(selected))
+	digest := sha256.New()
+	for _, a := range selected {
+		res := models.BatchDeleteResult{Package: a.Package, Version: a.Version, Size: a.Size, Status: models.BatchDeletePending}
+		switch {
+		case a.ID == 0:
+			res.Status = models.BatchDeleteNotFound
+		case a.Snapshot != "":
+			res.Status, res.Message = models.BatchDeleteRefused, fmt.Sprintf("in read-only snapshot %s", a.Snapshot)
+		case a.Pinned:
+			res.Status, res.Message = models.BatchDeletePinned, "unpin it before deleting"
+		default:
+			if status, msg := h.policyDecision(r, models.PolicyRequest{Action: models.PolicyActionDelete, Package: a.Package, Version: a.Version}); status != 0 {
+				res.Status, res.Message = batchDeleteOutcome(status), msg
+			} else if h.approvals.protects(a.Package) {
+				res.Message = fmt.Sprintf("%s is a protected package; deleting it needs approval", a.Package)
+			}
+		}
+		fmt.Fprintf(digest, "%s\x00%s\x00%d\x00%s\n", a.Package, a.Version, a.Revision, res.Status)
+		results = append(results, res)
+	}
+	return results, hex.EncodeToString(digest.Sum(nil))
+}
+
+// batchDeleteOne deletes the version of a pending result, or holds the
+// deletion for approval, recording the outcome in res.
+func (h *Handler) batchDeleteOne(r *http.Request, res *models.BatchDeleteResult) {
+	if status, msg := h.hookDecision(r, models.HookEvent{Hook: models.HookPreDelete, Package: res.Package, Version: res.Version}); status != 0 {
+		res.Status, res.Message = batchDeleteOutcome(status), msg
+		return
+	}
+
+	unlock := h.lockArtifactUpload(res.Package, res.Version)
+	defer unlock()
+	if h.approvals.protects(res.Package) {
+		approval, err := h.requestApproval(r, models.Approval{
+			Action:  models.ApprovalActionDelete,
+			Package: res.Package,
+			Version: res.Version,
+			Reason:  fmt.Sprintf("%s is a protected package", res.Package),
+		})
+		if err != nil {
+			h.logger.Error().Err(err).Msg("creating approval")
+			res.Status, res.Message = models.BatchDeleteFailed, "internal error"
+			return
+		}
+		res.Status, res.Approval = models.BatchDeleteHeld, approval.ID
+		return
+	}
+
+	err := h.deleteVersion(r, "", res.Package, res.Version)
+	switch {
+	case err == nil:
+		res.Status, res.Message = models.BatchDeleteDeleted, ""
+	case errors.Is(err, services.ErrNotFound):
+		res.Status, res.Message = models.BatchDeleteNotFound, ""
+	case errors.Is(err, services.ErrReadOnly):
+		res.Status, res.Message = models.BatchDeleteRefused, err.Error()
+	case errors.Is(err, services.ErrConflict):
+		res.Status, res.Message = models.BatchDeletePinned, "unpin it before deleting"
+	default:
+		h.logger.Error().Err(err).Str("package", res.Package).Str("version", res.Version).Msg("deleting artifact")
+		res.Status, res.Message = models.BatchDeleteFailed, "internal error"
+	}
+}
+
+// batchDeleteOutcome is the result status of a version whose check
+// answered status: refused for a denial, failed for anything else.
+func batchDeleteOutcome(status int) string {
+	if status == http.StatusForbidden {
+		return models.BatchDeleteRefused
+	}
+	return models.BatchDeleteFailed
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDownloadMaxAge = time.Minute
+	defaultListingMaxAge  = 30 * time.Second
+)
+
+// cachePolicy sets the Cache-Control of successful reads.
+type cachePolicy struct {
+	public         bool
+	downloadMaxAge time.Duration
+	listingMaxAge  time.Duration
+}
+
+// WithCaching sets how long clients and caches may keep responses.
+// Downloads are cacheable for downloadMaxAge and package and version
+// listings and package indexes for listingMaxAge; a zero age makes those
+// responses uncacheable, as every other response is. A version's URL
+// names no content, since the version may be deleted and uploaded again,
+// quarantined or blocked, so downloads are never marked immutable; they
+// carry the blob's ETag for revalidation instead. Responses are private,
+// kept only by the client, unless public lets shared caches such as a CDN
+// store them too; they vary by Authorization either way.
+func WithCaching(public bool, downloadMaxAge, listingMaxAge time.Duration) Option {
+	return func(h *Handler) {
+		h.cache = cachePolicy{public: public, downloadMaxAge: downloadMaxAge, listingMaxAge: listingMaxAge}
+	}
+}
+
+// noStoreMiddleware makes responses uncacheable unless the handler says
+// otherwise.
+func noStoreMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cacheDownload marks the response as a version's file, which may change
+// if the version is replaced.
+func (h *Handler) cacheDownload(w http.ResponseWriter) {
+	h.setCacheControl(w, h.cache.downloadMaxAge)
+}
+
+// cacheListing marks the response as a listing that may go stale.
+func (h *Handler) cacheListing(w http.ResponseWriter) {
+	h.setCacheControl(w, h.cache.listingMaxAge)
+}
+
+func (h *Handler) setCacheControl(w http.ResponseWriter, maxAge time.Duration) {
+	seconds := int64(maxAge / time.Second)
+	if seconds <= 0 {
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+	scope := "private"
+	if h.cache.public {
+		scope = "public"
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, seconds))
+	w.Header().Add("Vary", "Authorization")
+}
+
+// writeListing writes v as a cacheable listing, tagged with a weak entity
+// tag derived from its content so clients can revalidate a copy they hold.
+// A request whose If-None-Match already names the tag gets 304 Not
+// Modified without the body. Visibility is part of the content, so callers
+// that see different versions get different tags.
+func (h *Handler) writeListing(w http.ResponseWriter, r *http.Request, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("encoding listing")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	body = append(body, '\n')
+	sum := sha256.Sum256(body)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+
+	h.cacheListing(w)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// etagMatches reports whether an If-None-Match header names etag, comparing
+// weakly as RFC 9110 requires for that header.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,461 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// Crates are stored as packages named by the lowercased crate name, one
+// version per release, with the .crate archive as the version's default
+// file. The sparse index is served from the crate index store, whose
+// entries keep the crate name as published.
+
+// maxCrateMetadataSize bounds the JSON metadata of a publish request.
+const maxCrateMetadataSize = 1 << 20
+
+var (
+	crateNamePattern    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,63}$`)
+	crateVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+)
+
+// WithCrateIndex enables the Cargo registry routes, keeping index entries
+// in store.
+func WithCrateIndex(store services.CrateIndex) Option {
+	return func(h *Handler) {
+		h.crates = store
+	}
+}
+
+// cargoPublishDep is a dependency as sent by cargo publish.
+type cargoPublishDep struct {
+	Name               string   `json:"name"`
+	VersionReq         string   `json:"version_req"`
+	Features           []string `json:"features"`
+	Optional           bool     `json:"optional"`
+	DefaultFeatures    bool     `json:"default_features"`
+	Target             *string  `json:"target"`
+	Kind               string   `json:"kind"`
+	Registry           *string  `json:"registry"`
+	ExplicitNameInToml *string  `json:"explicit_name_in_toml"`
+}
+
+// cargoPublishMetadata is the JSON part of a cargo publish request.
+type cargoPublishMetadata struct {
+	Name        string              `json:"name"`
+	Vers        string              `json:"vers"`
+	Deps        []cargoPublishDep   `json:"deps"`
+	Features    map[string][]string `json:"features"`
+	Links       *string             `json:"links"`
+	RustVersion *string             `json:"rust_version"`
+}
+
+// cargoIndexDep is a dependency in a sparse index entry.
+type cargoIndexDep struct {
+	Name            string   `json:"name"`
+	Req             string   `json:"req"`
+	Features        []string `json:"features"`
+	Optional        bool     `json:"optional"`
+	DefaultFeatures bool     `json:"default_features"`
+	Target          *string  `json:"target"`
+	Kind            string   `json:"kind"`
+	Registry        *string  `json:"registry,omitempty"`
+	Package         *string  `json:"package,omitempty"`
+}
+
+// cargoIndexEntry is one line of a crate's sparse index file.
+type cargoIndexEntry struct {
+	Name        string              `json:"name"`
+	Vers        string              `json:"vers"`
+	Deps        []cargoIndexDep     `json:"deps"`
+	Cksum       string              `json:"cksum"`
+	Features    map[string][]string `json:"features"`
+	Features2   map[string][]string `json:"features2,omitempty"`
+	Yanked      bool                `json:"yanked"`
+	Links       *string             `json:"links,omitempty"`
+	V           int                 `json:"v,omitempty"`
+	RustVersion *string             `json:"rust_version,omitempty"`
+}
+
+// newCargoIndexEntry converts publish metadata to an index entry for a
+// .crate archive with the given SHA256.
+func newCargoIndexEntry(meta cargoPublishMetadata, cksum string) cargoIndexEntry {
+	entry := cargoIndexEntry{
+		Name:        meta.Name,
+		Vers:        meta.Vers,
+		Deps:        make([]cargoIndexDep, 0, len(meta.Deps)),
+		Cksum:       cksum,
+		Features:    map[string][]string{},
+		Links:       meta.Links,
+		RustVersion: meta.RustVersion,
+	}
+
+	for _, d := range meta.Deps {
+		dep := cargoIndexDep{
+			Name:            d.Name,
+			Req:             d.VersionReq,
+			Features:        d.Features,
+			Optional:        d.Optional,
+			DefaultFeatures: d.DefaultFeatures,
+			Target:          d.Target,
+			Kind:            d.Kind,
+			Registry:        d.Registry,
+		}
+		if dep.Features == nil {
+			dep.Features = []string{}
+		}
+		if dep.Kind == "" {
+			dep.Kind = "normal"
+		}
+		// A renamed dependency is listed under its local name.
+		if d.ExplicitNameInToml != nil {
+			dep.Name = *d.ExplicitNameInToml
+			pkg := d.Name
+			dep.Package = &pkg
+		}
+		entry.Deps = append(entry.Deps, dep)
+	}
+
+	// Features using the "dep:" or "?/" syntax go in features2 so that
+	// Cargo versions predating it skip them instead of failing to parse.
+	for name, values := range meta.Features {
+		if values == nil {
+			values = []string{}
+		}
+		if usesNewFeatureSyntax(values) {
+			if entry.Features2 == nil {
+				entry.Features2 = map[string][]string{}
+			}
+			entry.Features2[name] = values
+			entry.V = 2
+			continue
+		}
+		entry.Features[name] = values
+	}
+	return entry
+}
+
+func usesNewFeatureSyntax(values []string) bool {
+	for _, v := range values {
+		if strings.HasPrefix(v, "dep:") || strings.Contains(v, "?/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cargoIndexPath returns the sparse index path of a crate: 1/{name},
+// 2/{name}, 3/{c}/{name} or {ab}/{cd}/{name}, lowercased.
+func cargoIndexPath(name string) string {
+	name = strings.ToLower(name)
+	switch len(name) {
+	case 1:
+		return "1/" + name
+	case 2:
+		return "2/" + name
+	case 3:
+		return "3/" + name[:1] + "/" + name
+	default:
+		return name[:2] + "/" + name[2:4] + "/" + name
+	}
+}
+
+// cargoError writes an error in the format cargo displays to the user.
+func cargoError(w http.ResponseWriter, status int, msg string) {
+	type detail struct {
+		Detail string `json:"detail"`
+	}
+	writeJSON(w, status, map[string][]detail{"errors": {{Detail: msg}}})
+}
+
+func (h *Handler) crateIndexEnabled(w http.ResponseWriter) bool {
+	if h.crates == nil {
+		writeError(w, http.StatusNotImplemented, "the Cargo registry is not enabled")
+		return false
+	}
+	return true
+}
+
+// CargoIndex handles GET /cargo/index/*, serving config.json and the
+// sparse index files.
+func (h *Handler) CargoIndex(w http.ResponseWriter, r *http.Request) {
+	if !h.crateIndexEnabled(w) {
+		return
+	}
+	path := chi.URLParam(r, "*")
+
+	if path == "config.json" {
+		base := h.externalURL(r)
+		h.cacheListing(w)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"dl":            base + "/cargo/api/v1/crates",
+			"api":           base + "/cargo",
+			"auth-required": true,
+		})
+		return
+	}
+
+	name := path[strings.LastIndex(path, "/")+1:]
+	if !crateNamePattern.MatchString(name) || path != cargoIndexPath(name) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	versions, err := h.crates.ListCrateVersions(strings.ToLower(name))
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing crate versions")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	visible := versions[:0]
+	for _, v := range versions {
+		if v.Stage == models.StageRelease && (!v.Quarantined || isAdmin(r)) {
+			visible = append(visible, v)
+		}
+	}
+	versions = visible
+	if len(versions) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("crate %s not found", name))
+		return
+	}
+
+	var body strings.Builder
+	for _, v := range versions {
+		var entry cargoIndexEntry
+		if err := json.Unmarshal([]byte(v.Entry), &entry); err != nil {
+			h.logger.Error().Err(err).Str("crate", name).Str("version", v.Version).Msg("decoding crate index entry")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		entry.Yanked = v.Yanked
+		line, err := json.Marshal(entry)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("encoding crate index entry")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	h.cacheListing(w)
+	io.WriteString(w, body.String())
+}
+
+// CargoPublish handles PUT /cargo/api/v1/crates/new. The body is the
+// length-prefixed JSON metadata followed by the length-prefixed .crate.
+func (h *Handler) CargoPublish(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if !h.crateIndexEnabled(w) {
+		return
+	}
+
+	release, ok := h.limitUpload(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	var meta cargoPublishMetadata
+	if err := readCargoMetadata(r.Body, &meta); err != nil {
+		cargoError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !crateNamePattern.MatchString(meta.Name) {
+		cargoError(w, http.StatusBadRequest, fmt.Sprintf("invalid crate name %q", meta.Name))
+		return
+	}
+	if !crateVersionPattern.MatchString(meta.Vers) {
+		cargoError(w, http.StatusBadRequest, fmt.Sprintf("invalid version %q", meta.Vers))
+		return
+	}
+	pkgName := strings.ToLower(meta.Name)
+	if status, msg := h.namingDecision(r, pkgName, meta.Vers); status != 0 {
+		cargoError(w, status, msg)
+		return
+	}
+	if status, msg := h.policyDecision(r, models.PolicyRequest{Action: models.PolicyActionUpload, Package: pkgName, Version: meta.Vers}); status != 0 {
+		cargoError(w, status, msg)
+		return
+	}
+
+	var crateLen uint32
+	if err := binary.Read(r.Body, binary.LittleEndian, &crateLen); err != nil {
+		cargoError(w, http.StatusBadRequest, "reading crate length")
+		return
+	}
+	hash, size, releaseBlob, err := h.storeBlob(r.Context(), pkgName, io.LimitReader(r.Body, int64(crateLen)))
+	if err != nil {
+		status, msg := h.storeFailure(err)
+		cargoError(w, status, msg)
+		return
+	}
+	defer releaseBlob()
+	if size != int64(crateLen) {
+		cargoError(w, http.StatusBadRequest, "crate archive is truncated")
+		return
+	}
+
+	entry, err := json.Marshal(newCargoIndexEntry(meta, hash))
+	if err != nil {
+		h.logger.Error().Err(err).Msg("encoding crate index entry")
+		cargoError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	unlock := h.lockArtifactUpload(pkgName, meta.Vers)
+	defer unlock()
+
+	if existing, err := h.meta.GetArtifact(pkgName, meta.Vers); err != nil {
+		h.logger.Error().Err(err).Msg("checking existing artifact")
+		cargoError(w, http.StatusInternalServerError, "internal error")
+		return
+	} else if existing != nil {
+		cargoError(w, http.StatusConflict, fmt.Sprintf("crate version `%s@%s` is already uploaded", meta.Name, meta.Vers))
+		return
+	}
+
+	crateFile := fmt.Sprintf("%s-%s.crate", meta.Name, meta.Vers)
+	event := models.HookEvent{Hook: models.HookPreUpload, Package: pkgName, Version: meta.Vers, File: crateFile, Hash: hash, Size: size}
+	if status, msg := h.uploadDecision(r, &event); status != 0 {
+		cargoError(w, status, msg)
+		return
+	}
+	file, err := h.attachFile(r, pkgName, meta.Vers, versionOptions{stage: h.defaultStage}, models.AssetInput{
+		Name:        crateFile,
+		Hash:        hash,
+		Size:        size,
+		ContentType: "application/gzip",
+	})
+	if errors.Is(err, services.ErrReadOnly) {
+		cargoError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		h.logger.Error().Err(err).Msg("recording crate")
+		cargoError(w, http.StatusInternalServerError, "failed to create artifact metadata")
+		return
+	}
+	if err := h.crates.CreateCrateVersion(file.ArtifactID, string(entry)); err != nil {
+		// Without an index entry the version would be invisible to cargo
+		// yet block republishing, so remove it again.
+		if delErr := h.deleteVersion(r, "", pkgName, meta.Vers); delErr != nil {
+			h.logger.Error().Err(delErr).Msg("removing unindexed crate")
+		}
+		h.logger.Error().Err(err).Msg("recording crate index entry")
+		cargoError(w, http.StatusInternalServerError, "failed to create crate index entry")
+		return
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("package", pkgName).
+		Str("version", meta.Vers).
+		Str("hash", hash).
+		Int64("size", size).
+		Dur("upload_latency", time.Since(start)).
+		Msg("crate published")
+	h.runPostUpload(r, event)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"warnings": map[string][]string{
+			"invalid_categories": {},
+			"invalid_badges":     {},
+			"other":              {},
+		},
+	})
+}
+
+// readCargoMetadata decodes the length-prefixed JSON metadata of a publish
+// request.
+func readCargoMetadata(r io.Reader, meta *cargoPublishMetadata) error {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return errors.New("reading metadata length")
+	}
+	if n > maxCrateMetadataSize {
+		return fmt.Errorf("metadata exceeds %d bytes", maxCrateMetadataSize)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return errors.New("reading metadata")
+	}
+	if err := json.Unmarshal(buf, meta); err != nil {
+		return fmt.Errorf("invalid metadata: %v", err)
+	}
+	return nil
+}
+
+// CargoDownload handles GET /cargo/api/v1/crates/{crate}/{version}/download
+func (h *Handler) CargoDownload(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "crate")
+	version := chi.URLParam(r, "version")
+
+	artifact, err := h.meta.GetArtifact(strings.ToLower(name), version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting artifact")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if artifact == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("crate %s@%s not found", name, version))
+		return
+	}
+	h.serveArtifact(w, r, artifact)
+}
+
+// CargoYank handles DELETE /cargo/api/v1/crates/{crate}/{version}/yank
+func (h *Handler) CargoYank(w http.ResponseWriter, r *http.Request) {
+	h.setCrateYanked(w, r, true)
+}
+
+// CargoUnyank handles PUT /cargo/api/v1/crates/{crate}/{version}/unyank
+func (h *Handler) CargoUnyank(w http.ResponseWriter, r *http.Request) {
+	h.setCrateYanked(w, r, false)
+}
+
+func (h *Handler) setCrateYanked(w http.ResponseWriter, r *http.Request, yanked bool) {
+	if !h.crateIndexEnabled(w) {
+		return
+	}
+	name := chi.URLParam(r, "crate")
+	version := chi.URLParam(r, "version")
+	// A yank is how Cargo deprecates a version.
+	req := models.PolicyRequest{Action: models.PolicyActionDeprecate, Package: strings.ToLower(name), Version: version}
+	if status, msg := h.policyDecision(r, req); status != 0 {
+		cargoError(w, status, msg)
+		return
+	}
+
+	if err := h.crates.SetCrateYanked(strings.ToLower(name), version, yanked); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			cargoError(w, http.StatusNotFound, fmt.Sprintf("crate version `%s@%s` not found", name, version))
+			return
+		}
+		h.logger.Error().Err(err).Msg("updating crate version")
+		cargoError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("package", strings.ToLower(name)).
+		Str("version", version).
+		Bool("yanked", yanked).
+		Msg("crate yank state changed")
+
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// maxCommentBytes bounds the body of a comment.
+const maxCommentBytes = 4096
+
+// WithComments enables comments on packages and versions, kept in store.
+// Package details list them.
+func WithComments(store services.CommentStore) Option {
+	return func(h *Handler) {
+		h.comments = store
+	}
+}
+
+// ListComments handles GET /api/v1/packages/{package}/comments, oldest
+// first. ?version= narrows the list to the comments on that version.
+func (h *Handler) ListComments(w http.ResponseWriter, r *http.Request) {
+	if !h.commentsEnabled(w) {
+		return
+	}
+	pkgName := chi.URLParam(r, "package")
+	pkg, err := h.meta.GetPackage(pkgName)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting package")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if pkg == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("package %s not found", pkgName))
+		return
+	}
+
+	comments, err := h.packageComments(r, pkgName)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing comments")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	list := []models.Comment{}
+	version := r.URL.Query().Get("version")
+	for _, c := range comments {
+		if version == "" || c.Version == version {
+			list = append(list, c)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// AddComment handles POST /api/v1/packages/{package}/comments, leaving a
+// comment on the package, or on one of its versions if the request names
+// it.
+func (h *Handler) AddComment(w http.ResponseWriter, r *http.Request) {
+	if !h.commentsEnabled(w) {
+		return
+	}
+	pkgName := chi.URLParam(r, "package")
+	var req models.CreateCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	body := strings.TrimSpace(req.Body)
+	if body == "" || len(body) > maxCommentBytes {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("body must be 1 to %d bytes", maxCommentBytes))
+		return
+	}
+	if req.Version != "" {
+		artifact, err := h.meta.GetArtifact(pkgName, req.Version)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("getting artifact")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if artifact == nil || hidden(r, artifact) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", pkgName, req.Version))
+			return
+		}
+	}
+
+	c := models.Comment{Package: pkgName, Version: req.Version, Body: body}
+	if p := principalFrom(r.Context()); p != nil {
+		c.Author = p.Name
+	}
+	created, err := h.comments.AddComment(c)
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		h.logger.Error().Err(err).Msg("adding comment")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Int64("comment", created.ID).
+		Str("package", created.Package).
+		Str("version", created.Version).
+		Str("author", created.Author).
+		Msg("comment added")
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// DeleteComment handles DELETE /api/v1/packages/{package}/comments/{id}.
+// Only the comment's author and admins may delete it.
+func (h *Handler) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	if !h.commentsEnabled(w) {
+		return
+	}
+	pkgName := chi.URLParam(r, "package")
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid comment id")
+		return
+	}
+	c, err := h.comments.GetComment(id)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting comment")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if c == nil || c.Package != pkgName {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("comment %d not found on %s", id, pkgName))
+		return
+	}
+	p := principalFrom(r.Context())
+	if !isAdmin(r) && (p == nil || p.Name != c.Author) {
+		writeError(w, http.StatusForbidden, "only the comment's author or an admin may delete it")
+		return
+	}
+	if err := h.comments.DeleteComment(id); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("comment %d not found on %s", id, pkgName))
+			return
+		}
+		h.logger.Error().Err(err).Msg("deleting comment")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Int64("comment", id).
+		Str("package", pkgName).
+		Msg("comment deleted")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// packageComments returns the comments on a package and its versions that
+// the caller of r may see, leaving out those on versions hidden from it.
+func (h *Handler) packageComments(r *http.Request, pkgName string) ([]models.Comment, error) {
+	comments, err := h.comments.ListComments(pkgName)
+	if err != nil || isAdmin(r) || len(comments) == 0 {
+		return comments, err
+	}
+	artifacts, err := h.meta.ListArtifacts(pkgName)
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[string]bool, len(artifacts))
+	for _, a := range visibleArtifacts(r, artifacts) {
+		visible[a.Version] = true
+	}
+	shown := comments[:0]
+	for _, c := range comments {
+		if c.Version == "" || visible[c.Version] {
+			shown = append(shown, c)
+		}
+	}
+	return shown, nil
+}
+
+func (h *Handler) commentsEnabled(w http.ResponseWriter) bool {
+	if h.comments == nil {
+		writeError(w, http.StatusNotImplemented, "comments are not enabled")
+		return false
+	}
+	return true
+}
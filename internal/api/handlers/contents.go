@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/archive"
+	"github.com/foundry/registry/internal/util/license"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// maxContentEntries bounds how many entries of one archive are recorded.
+const maxContentEntries = 100000
+
+// maxLicenseFiles bounds how many license files and manifests of one
+// archive are read for licenses.
+const maxLicenseFiles = 10
+
+// GetContents handles GET /api/v1/artifacts/{package}/{version}/contents,
+// listing the files inside the version's default file when it is a tar or
+// zip archive.
+func (h *Handler) GetContents(w http.ResponseWriter, r *http.Request) {
+	artifact, ok := h.lookupArtifact(w, r)
+	if !ok {
+		return
+	}
+	h.writeContents(w, r, artifact)
+}
+
+// GetFileContents handles
+// GET /api/v1/artifacts/{package}/{version}/files/{name}/contents.
+func (h *Handler) GetFileContents(w http.ResponseWriter, r *http.Request) {
+	file, ok := h.resolveFile(w, r)
+	if !ok {
+		return
+	}
+	h.writeContents(w, r, file)
+}
+
+func (h *Handler) writeContents(w http.ResponseWriter, r *http.Request, file *models.Artifact) {
+	if hidden(r, file) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s is quarantined pending approval", file.Package, file.Version))
+		return
+	}
+	contents, err := h.contents(file.Hash)
+	if err != nil {
+		h.logger.Error().Err(err).Str("hash", file.Hash).Msg("listing archive contents")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if contents.Format == "" {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s in %s@%s is not a tar or zip archive", downloadFilename(file), file.Package, file.Version))
+		return
+	}
+	writeJSON(w, http.StatusOK, contents)
+}
+
+// ExtractContent handles
+// GET /api/v1/artifacts/{package}/{version}/contents/*, streaming one file
+// out of the version's default file when it is a tar or zip archive.
+func (h *Handler) ExtractContent(w http.ResponseWriter, r *http.Request) {
+	artifact, ok := h.lookupArtifact(w, r)
+	if !ok {
+		return
+	}
+	h.serveExtracted(w, r, artifact)
+}
+
+// ExtractFileContent handles
+// GET /api/v1/artifacts/{package}/{version}/files/{name}/contents/*.
+func (h *Handler) ExtractFileContent(w http.ResponseWriter, r *http.Request) {
+	file, ok := h.resolveFile(w, r)
+	if !ok {
+		return
+	}
+	h.serveExtracted(w, r, file)
+}
+
+// serveExtracted streams the archive member named by the route wildcard.
+// The stored listing answers for missing, non-regular and oversized
+// entries without reading the blob; only complete listings are trusted to
+// rule an entry out.
+func (h *Handler) serveExtracted(w http.ResponseWriter, r *http.Request, file *models.Artifact) {
+	if hidden(r, file) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s is quarantined pending approval", file.Package, file.Version))
+		return
+	}
+	if reason := h.scanBlock(file); reason != "" {
+		h.logger.Warn().
+			Str("request_id", logging.RequestID(r.Context())).
+			Str("package", file.Package).
+			Str("version", file.Version).
+			Msg("download blocked")
+		writeError(w, http.StatusForbidden, reason)
+		return
+	}
+	name := archive.Clean(chi.URLParam(r, "*"))
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "a path inside the archive is required")
+		return
+	}
+
+	contents, err := h.contents(file.Hash)
+	if err != nil {
+		h.logger.Error().Err(err).Str("hash", file.Hash).Msg("listing archive contents")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if contents.Format == "" {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s in %s@%s is not a tar or zip archive", downloadFilename(file), file.Package, file.Version))
+		return
+	}
+	found := false
+	for _, e := range contents.Entries {
+		if archive.Clean(e.Path) != name {
+			continue
+		}
+		found = true
+		if e.Mode != "" && e.Mode[0] != '-' {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("%s is not a regular file", name))
+			return
+		}
+		if !h.extractAllowed(w, name, e.Size) {
+			return
+		}
+		break
+	}
+	if !found && !contents.Truncated {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s not found in %s@%s", name, file.Package, file.Version))
+		return
+	}
+
+	w, release, ok := h.limitDownload(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ra, size, done, err := h.openBlobAt(file.Hash)
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "artifact blob missing on disk")
+			return
+		}
+		h.logger.Error().Err(err).Str("hash", file.Hash).Msg("opening blob")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	defer done()
+
+	member, memberSize, err := archive.Extract(ra, size, name)
+	switch {
+	case errors.Is(err, archive.ErrNoEntry):
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s not found in %s@%s", name, file.Package, file.Version))
+		return
+	case errors.Is(err, archive.ErrNotRegular):
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("%s is not a regular file", name))
+		return
+	case err != nil:
+		h.logger.Error().Err(err).Str("hash", file.Hash).Str("path", name).Msg("extracting archive entry")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	defer member.Close()
+	if !h.extractAllowed(w, name, memberSize) {
+		return
+	}
+
+	// Members are whatever the uploader packed, HTML and SVG included, so
+	// they are never given a type a browser would render on our origin.
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.FormatInt(memberSize, 10))
+	w.Header().Set("Content-Disposition", contentDisposition(path.Base(name)))
+	w.Header().Set("X-Artifact-Hash", file.Hash)
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.CopyN(w, member, memberSize); err != nil {
+		h.logger.Error().
+			Err(err).
+			Str("request_id", logging.RequestID(r.Context())).
+			Str("package", file.Package).
+			Str("version", file.Version).
+			Str("path", name).
+			Msg("streaming archive entry")
+	}
+}
+
+// extractAllowed answers 413 for archive members larger than the
+// configured extraction limit.
+func (h *Handler) extractAllowed(w http.ResponseWriter, name string, size int64) bool {
+	if h.limits == nil || h.limits.extractBytes <= 0 || size <= h.limits.extractBytes {
+		return true
+	}
+	writeError(w, http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("%s is %d bytes, over the %d byte extraction limit", name, size, h.limits.extractBytes))
+	return false
+}
+
+// indexContents records the listing of a newly stored blob. Failures are
+// logged rather than failing the upload; the listing is retried when it is
+// first requested.
+func (h *Handler) indexContents(hash string) {
+	if _, err := h.contents(hash); err != nil {
+		h.logger.Warn().Err(err).Str("hash", hash).Msg("indexing archive contents")
+	}
+}
+
+// contents returns the listing of a blob, reading the blob to make one if
+// it has not been indexed yet. Listings are kept per blob, so each archive
+// is read once however many versions share it.
+func (h *Handler) contents(hash string) (*models.Contents, error) {
+	existing, err := h.meta.GetContents(hash)
+	if err != nil || existing != nil {
+		return existing, err
+	}
+
+	ra, size, done, err := h.openBlobAt(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	contents := models.Contents{Hash: hash, Entries: []models.ContentEntry{}, IndexedAt: h.clock.Now()}
+	listing, err := archive.List(ra, size, maxContentEntries)
+	switch {
+	case errors.Is(err, archive.ErrNotArchive):
+		// Recorded with no format so the blob is not read again.
+	case err != nil:
+		return nil, err
+	default:
+		contents.Format = listing.Format
+		contents.Truncated = listing.Truncated
+		for _, e := range listing.Entries {
+			contents.Entries = append(contents.Entries, models.ContentEntry{Path: e.Path, Size: e.Size, Mode: e.Mode})
+		}
+		contents.Licenses = detectLicenses(ra, size, contents.Entries)
+	}
+	if err := h.meta.SetContents(contents); err != nil {
+		return nil, err
+	}
+	return &contents, nil
+}
+
+// detectLicenses reads the license files and manifests among an archive's
+// entries for the licenses they declare. Only the first maxLicenseFiles
+// candidates are read; members that cannot be extracted are skipped.
+func detectLicenses(ra io.ReaderAt, size int64, entries []models.ContentEntry) []models.DetectedLicense {
+	var found []models.DetectedLicense
+	read := 0
+	for _, e := range entries {
+		if read == maxLicenseFiles {
+			break
+		}
+		if (e.Mode != "" && e.Mode[0] != '-') || !license.IsCandidate(archive.Clean(e.Path)) {
+			continue
+		}
+		read++
+		member, _, err := archive.Extract(ra, size, e.Path)
+		if err != nil {
+			continue
+		}
+		content, err := io.ReadAll(io.LimitReader(member, license.MaxFileSize))
+		member.Close()
+		if err != nil {
+			continue
+		}
+		for _, id := range license.Detect(e.Path, content) {
+			found = append(found, models.DetectedLicense{License: id, Path: archive.Clean(e.Path)})
+		}
+	}
+	return found
+}
+
+// openBlobAt opens a blob for random access, which zip archives need.
+// Blobs whose storage cannot seek are spooled to a temporary file first.
+// done releases the blob and any temporary file.
+func (h *Handler) openBlobAt(hash string) (ra io.ReaderAt, size int64, done func(), err error) {
+	size, err = h.blobs.Size(hash)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	reader, err := h.blobs.Open(hash)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if ra, ok := reader.(io.ReaderAt); ok {
+		return ra, size, func() { reader.Close() }, nil
+	}
+	defer reader.Close()
+
+	tmp, err := os.CreateTemp("", "foundry-contents-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	done = func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	if _, err := io.Copy(tmp, reader); err != nil {
+		done()
+		return nil, 0, nil, fmt.Errorf("spooling blob: %w", err)
+	}
+	return tmp, size, done, nil
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// CopyArtifact handles POST /api/v1/artifacts/{package}/{version}/copy,
+// publishing an existing version again under another package or version.
+// The copy references the same blobs as the original, so re-tagging a
+// release candidate as final moves no bytes. Its named files and
+// dependencies are copied too; its stage, stability and expiry are those
+// of a new upload.
+func (h *Handler) CopyArtifact(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	source, ok := h.lookupArtifact(w, r)
+	if !ok {
+		return
+	}
+	if hidden(r, source) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s is quarantined pending approval", source.Package, source.Version))
+		return
+	}
+	if reason := h.scanBlock(source); reason != "" {
+		writeError(w, http.StatusForbidden, reason)
+		return
+	}
+
+	var req models.CopyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	pkgName := h.naming.Normalize(req.Package)
+	if pkgName == "" {
+		pkgName = source.Package
+	}
+	version := req.Version
+	if version == "" {
+		writeError(w, http.StatusBadRequest, "version is required")
+		return
+	}
+	if !h.checkNaming(w, r, pkgName, version) {
+		return
+	}
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionUpload, Package: pkgName, Version: version}) {
+		return
+	}
+	opts, ok := h.uploadVersionOptions(w, r)
+	if !ok {
+		return
+	}
+
+	files, err := h.meta.ListAssets(source.Package, source.Version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing assets")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	deps, err := h.meta.ListDependencies(source.Package, source.Version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing dependencies")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	unlock := h.lockArtifactUpload(pkgName, version)
+	defer unlock()
+
+	if !h.versionAvailable(w, pkgName, version) {
+		return
+	}
+
+	// Hold the blobs so deleting the original cannot reclaim them before
+	// the copy references them.
+	hashes := []string{source.Hash}
+	for _, f := range files {
+		hashes = append(hashes, f.Hash)
+	}
+	for _, hash := range hashes {
+		defer h.holdBlob(hash)()
+		if err := h.placeBlob(pkgName, hash); err != nil {
+			status, msg := h.storeFailure(err)
+			writeError(w, status, msg)
+			return
+		}
+	}
+
+	event := models.HookEvent{Hook: models.HookPreUpload, Package: pkgName, Version: version, File: source.Filename, Hash: source.Hash, Size: source.Size}
+	if !h.checkUpload(w, r, &event) {
+		return
+	}
+	artifact, err := h.meta.CreateArtifactForPackage(pkgName, models.ArtifactInput{
+		Version:     version,
+		Hash:        source.Hash,
+		Size:        source.Size,
+		Filename:    source.Filename,
+		ContentType: source.ContentType,
+		Quarantined: h.quarantine,
+		Stage:       opts.stage,
+		Stability:   opts.stability,
+		ExpiresAt:   opts.expiresAt,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrConflict) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("artifact %s@%s already exists", pkgName, version))
+			return
+		}
+		h.logger.Error().Err(err).Msg("creating artifact")
+		writeError(w, http.StatusInternalServerError, "failed to create artifact metadata")
+		return
+	}
+	if err := h.copyVersionContents(pkgName, artifact, files, deps); err != nil {
+		h.logger.Error().Err(err).Str("package", pkgName).Str("version", version).Msg("copying version contents")
+		if err := h.meta.DeleteArtifact(pkgName, version); err != nil {
+			h.logger.Error().Err(err).Str("package", pkgName).Str("version", version).Msg("removing incomplete copy")
+		}
+		writeError(w, http.StatusInternalServerError, "failed to copy artifact")
+		return
+	}
+
+	from := source.Package + "@" + source.Version
+	h.indexContents(artifact.Hash)
+	for _, f := range files {
+		h.recordHistory(r, models.HistoryEvent{
+			Package: pkgName, Version: version, File: f.Name, Action: models.HistoryCopy, NewHash: f.Hash, Source: from,
+		})
+	}
+	h.recordHistory(r, models.HistoryEvent{
+		Package: pkgName, Version: version, Action: models.HistoryCopy, NewHash: artifact.Hash, Source: from,
+	})
+	h.runPostUpload(r, event)
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("source", from).
+		Str("package", pkgName).
+		Str("version", version).
+		Str("hash", artifact.Hash).
+		Int("files", len(files)).
+		Bool("quarantined", artifact.Quarantined).
+		Dur("copy_latency", time.Since(start)).
+		Msg("artifact copied")
+
+	writeJSON(w, http.StatusCreated, models.UploadResponse{
+		Package:     pkgName,
+		Version:     artifact.Version,
+		Hash:        artifact.Hash,
+		Size:        artifact.Size,
+		Filename:    artifact.Filename,
+		ContentType: artifact.ContentType,
+		UploadedAt:  artifact.UploadedAt,
+		Quarantined: artifact.Quarantined,
+		Stage:       artifact.Stage,
+		Stability:   artifact.Stability,
+		ExpiresAt:   artifact.ExpiresAt,
+		Malware:     artifact.Malware,
+	})
+}
+
+// copyVersionContents attaches the named files and dependencies of the
+// version copied from to its new copy in package pkgName.
+func (h *Handler) copyVersionContents(pkgName string, artifact *models.Artifact, files []models.Asset, deps []models.Dependency) error {
+	for _, f := range files {
+		if _, err := h.meta.CreateAsset(artifact.ID, models.AssetInput{
+			Name: f.Name, Hash: f.Hash, Size: f.Size, ContentType: f.ContentType,
+		}); err != nil {
+			return fmt.Errorf("copying file %s: %w", f.Name, err)
+		}
+	}
+	if len(deps) > 0 {
+		if err := h.meta.SetDependencies(pkgName, artifact.Version, deps); err != nil {
+			return fmt.Errorf("copying dependencies: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+	"github.com/foundry/registry/internal/util/semver"
+)
+
+// maxResolveRounds bounds how often resolution restarts after finding that
+// an earlier selection violates a later requirement.
+const maxResolveRounds = 100
+
+// SetDependencies handles PUT /api/v1/artifacts/{package}/{version}/dependencies,
+// replacing the version's dependency manifest.
+func (h *Handler) SetDependencies(w http.ResponseWriter, r *http.Request) {
+	artifact, unlock, ok := h.lookupForUpdate(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionUpload, Package: artifact.Package, Version: artifact.Version}) {
+		return
+	}
+
+	var manifest models.DependencyManifest
+	if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	deps, err := validateDependencies(artifact.Package, manifest.Dependencies)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.meta.SetDependencies(artifact.Package, artifact.Version, deps); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", artifact.Package, artifact.Version))
+			return
+		}
+		h.logger.Error().Err(err).Msg("setting dependencies")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("package", artifact.Package).
+		Str("version", artifact.Version).
+		Int("dependencies", len(deps)).
+		Msg("dependencies set")
+
+	writeJSON(w, http.StatusOK, models.DependenciesResponse{
+		Package:      artifact.Package,
+		Version:      artifact.Version,
+		Dependencies: deps,
+	})
+}
+
+// validateDependencies checks a manifest and normalizes empty constraints
+// to "*".
+func validateDependencies(pkgName string, deps []models.Dependency) ([]models.Dependency, error) {
+	seen := make(map[string]bool, len(deps))
+	out := make([]models.Dependency, 0, len(deps))
+	for _, d := range deps {
+		d.Package = strings.TrimSpace(d.Package)
+		d.Constraint = strings.TrimSpace(d.Constraint)
+		if d.Package == "" || strings.Contains(d.Package, "/") {
+			return nil, fmt.Errorf("invalid dependency package name %q", d.Package)
+		}
+		if d.Package == pkgName {
+			return nil, fmt.Errorf("%s cannot depend on itself", pkgName)
+		}
+		if seen[d.Package] {
+			return nil, fmt.Errorf("duplicate dependency on %s", d.Package)
+		}
+		seen[d.Package] = true
+		if d.Constraint == "" {
+			d.Constraint = "*"
+		}
+		if _, err := semver.ParseConstraint(d.Constraint); err != nil {
+			return nil, fmt.Errorf("dependency %s: %v", d.Package, err)
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// GetDependencies handles GET /api/v1/artifacts/{package}/{version}/dependencies.
+// With ?resolve=true the response also lists the versions selected for
+// the transitive dependency tree, and ?channel= limits the selection to
+// versions of that stability or more stable.
+func (h *Handler) GetDependencies(w http.ResponseWriter, r *http.Request) {
+	channel, err := parseChannel(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	artifact, ok := h.lookupArtifact(w, r)
+	if !ok {
+		return
+	}
+
+	deps, err := h.meta.ListDependencies(artifact.Package, artifact.Version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing dependencies")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if deps == nil {
+		deps = []models.Dependency{}
+	}
+	resp := models.DependenciesResponse{
+		Package:      artifact.Package,
+		Version:      artifact.Version,
+		Dependencies: deps,
+	}
+
+	if r.URL.Query().Get("resolve") == "true" {
+		resolved, err := newResolver(h.meta, channel).resolve(artifact)
+		var unsatisfiable *unsatisfiableError
+		if errors.As(err, &unsatisfiable) {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			h.logger.Error().Err(err).Msg("resolving dependencies")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		resp.Resolved = resolved
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// unsatisfiableError reports requirements no stored version meets.
+type unsatisfiableError struct {
+	msg string
+}
+
+func (e *unsatisfiableError) Error() string { return e.msg }
+
+// requirement is a constraint on a package and the version imposing it.
+type requirement struct {
+	constraint semver.Constraint
+	by         string
+}
+
+// resolver selects one version per package, preferring the highest that
+// satisfies every requirement seen so far. When a later requirement rules
+// out an earlier selection, resolution restarts with that requirement
+// known up front. It does not backtrack over alternatives beyond that, so
+// requirements from versions that end up unselected still apply.
+type resolver struct {
+	meta services.MetadataStore
+	// channel, if set, limits selection to the versions it offers.
+	channel  string
+	versions map[string][]models.Artifact
+	deps     map[string][]models.Dependency
+}
+
+func newResolver(meta services.MetadataStore, channel string) *resolver {
+	return &resolver{
+		meta:     meta,
+		channel:  channel,
+		versions: make(map[string][]models.Artifact),
+		deps:     make(map[string][]models.Dependency),
+	}
+}
+
+func (rs *resolver) resolve(root *models.Artifact) ([]models.ResolvedDependency, error) {
+	known := make(map[string][]requirement)
+	for round := 0; round < maxResolveRounds; round++ {
+		selected, requiredBy, retry, err := rs.walk(root, known)
+		if err != nil {
+			return nil, err
+		}
+		if retry {
+			continue
+		}
+
+		resolved := make([]models.ResolvedDependency, 0, len(selected)-1)
+		for name, a := range selected {
+			if name == root.Package {
+				continue
+			}
+			resolved = append(resolved, models.ResolvedDependency{
+				Package:    name,
+				Version:    a.Version,
+				Hash:       a.Hash,
+				Size:       a.Size,
+				RequiredBy: requiredBy[name],
+			})
+		}
+		sort.Slice(resolved, func(i, j int) bool { return resolved[i].Package < resolved[j].Package })
+		return resolved, nil
+	}
+	return nil, &unsatisfiableError{msg: fmt.Sprintf("dependency resolution did not settle after %d rounds", maxResolveRounds)}
+}
+
+// walk makes one breadth-first pass over the tree. It returns retry=true
+// after adding a requirement to known that invalidates a selection.
+func (rs *resolver) walk(root *models.Artifact, known map[string][]requirement) (map[string]*models.Artifact, map[string][]string, bool, error) {
+	selected := map[string]*models.Artifact{root.Package: root}
+	requiredBy := make(map[string][]string)
+	reqs := make(map[string][]requirement, len(known))
+	for name, rr := range known {
+		reqs[name] = append([]requirement(nil), rr...)
+	}
+
+	queue := []*models.Artifact{root}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		from := current.Package + "@" + current.Version
+
+		deps, err := rs.dependencies(current)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		for _, d := range deps {
+			c, err := semver.ParseConstraint(d.Constraint)
+			if err != nil {
+				return nil, nil, false, fmt.Errorf("%s: %w", from, err)
+			}
+			req := requirement{constraint: c, by: from}
+			requiredBy[d.Package] = append(requiredBy[d.Package], from)
+
+			if sel, ok := selected[d.Package]; ok {
+				if v, err := semver.Parse(sel.Version); err == nil && c.Check(v) {
+					continue
+				}
+				if d.Package == root.Package {
+					return nil, nil, false, &unsatisfiableError{msg: fmt.Sprintf(
+						"%s requires %s %s, which excludes the version being resolved", from, d.Package, d.Constraint)}
+				}
+				// Start over so the selection, and the dependencies it
+				// brought in, account for this requirement.
+				known[d.Package] = append(known[d.Package], req)
+				return nil, nil, true, nil
+			}
+
+			reqs[d.Package] = append(reqs[d.Package], req)
+			best, err := rs.pick(d.Package, reqs[d.Package])
+			if err != nil {
+				return nil, nil, false, err
+			}
+			selected[d.Package] = best
+			queue = append(queue, best)
+		}
+	}
+	return selected, requiredBy, false, nil
+}
+
+// pick returns the highest stored version of pkgName meeting every
+// requirement. Quarantined and staging versions, versions outside the
+// channel and versions that are not valid semver are never selected.
+func (rs *resolver) pick(pkgName string, reqs []requirement) (*models.Artifact, error) {
+	stored, err := rs.artifacts(pkgName)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := inChannel(stored, rs.channel)
+	var best *models.Artifact
+	var bestVersion semver.Version
+	for i := range versions {
+		if versions[i].Quarantined || versions[i].Stage != models.StageRelease {
+			continue
+		}
+		v, err := semver.Parse(versions[i].Version)
+		if err != nil {
+			continue
+		}
+		ok := true
+		for _, req := range reqs {
+			if !req.constraint.Check(v) {
+				ok = false
+				break
+			}
+		}
+		if ok && (best == nil || v.Compare(bestVersion) > 0) {
+			best, bestVersion = &versions[i], v
+		}
+	}
+	if best != nil {
+		return best, nil
+	}
+
+	if len(stored) == 0 {
+		return nil, &unsatisfiableError{msg: fmt.Sprintf("%s requires %s, which does not exist", reqs[len(reqs)-1].by, pkgName)}
+	}
+	parts := make([]string, len(reqs))
+	for i, req := range reqs {
+		parts[i] = fmt.Sprintf("%s (from %s)", req.constraint, req.by)
+	}
+	in := ""
+	if rs.channel != "" {
+		in = " in the " + rs.channel + " channel"
+	}
+	return nil, &unsatisfiableError{msg: fmt.Sprintf("no version of %s%s satisfies %s", pkgName, in, strings.Join(parts, ", "))}
+}
+
+func (rs *resolver) artifacts(pkgName string) ([]models.Artifact, error) {
+	if versions, ok := rs.versions[pkgName]; ok {
+		return versions, nil
+	}
+	versions, err := rs.meta.ListArtifacts(pkgName)
+	if err != nil {
+		return nil, err
+	}
+	rs.versions[pkgName] = versions
+	return versions, nil
+}
+
+func (rs *resolver) dependencies(a *models.Artifact) ([]models.Dependency, error) {
+	key := a.Package + "@" + a.Version
+	if deps, ok := rs.deps[key]; ok {
+		return deps, nil
+	}
+	deps, err := rs.meta.ListDependencies(a.Package, a.Version)
+	if err != nil {
+		return nil, err
+	}
+	rs.deps[key] = deps
+	return deps, nil
+}
+
+// ListDependents handles GET /api/v1/packages/{package}/dependents, listing
+// the artifact versions whose manifests name the package, a page at a time.
+// Dependencies may name packages not yet uploaded, so an unknown package
+// simply has no dependents.
+func (h *Handler) ListDependents(w http.ResponseWriter, r *http.Request) {
+	pkgName := chi.URLParam(r, "package")
+
+	limit, err := pageLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var after *models.Dependent
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		id, name, err := decodeCursor(cursor)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		after = &models.Dependent{ArtifactID: id, Package: name}
+	}
+
+	// Fetch one extra row to learn whether another page follows.
+	dependents, err := h.meta.ListDependents(pkgName, after, limit+1)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing dependents")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	resp := models.DependentsResponse{Package: pkgName, Dependents: dependents}
+	if len(dependents) > limit {
+		last := dependents[limit-1]
+		resp.Dependents = dependents[:limit]
+		resp.NextCursor = encodeCursor(last.ArtifactID, last.Package)
+		h.setNextLink(w, r, resp.NextCursor)
+	}
+	if resp.Dependents == nil {
+		resp.Dependents = []models.Dependent{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// maxDeprecationBytes bounds a deprecation message, which is sent back in
+// download headers.
+const maxDeprecationBytes = 1024
+
+// DeprecateArtifact handles PUT /api/v1/artifacts/{package}/{version}/deprecation,
+// deprecating a version with {"message": "...", "deprecated_in_favor_of": "1.2.4"}.
+// Deprecating again replaces the message. Deprecated versions are still
+// served, with a warning.
+func (h *Handler) DeprecateArtifact(w http.ResponseWriter, r *http.Request) {
+	d, ok := decodeDeprecation(w, r)
+	if !ok {
+		return
+	}
+	h.setArtifactDeprecation(w, r, d)
+}
+
+// UndeprecateArtifact handles DELETE /api/v1/artifacts/{package}/{version}/deprecation.
+func (h *Handler) UndeprecateArtifact(w http.ResponseWriter, r *http.Request) {
+	h.setArtifactDeprecation(w, r, nil)
+}
+
+// setArtifactDeprecation deprecates the version named in the URL, or
+// clears its deprecation when d is nil, and writes the version back.
+func (h *Handler) setArtifactDeprecation(w http.ResponseWriter, r *http.Request, d *models.Deprecation) {
+	artifact, unlock, ok := h.lookupForUpdate(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionDeprecate, Package: artifact.Package, Version: artifact.Version}) {
+		return
+	}
+
+	if err := h.meta.SetDeprecation(artifact.Package, artifact.Version, d); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", artifact.Package, artifact.Version))
+			return
+		}
+		h.logger.Error().Err(err).Msg("setting deprecation")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	updated, err := h.meta.GetArtifact(artifact.Package, artifact.Version)
+	if err != nil || updated == nil {
+		h.logger.Error().Err(err).Msg("getting deprecated artifact")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	h.logDeprecation(r, artifact.Package, artifact.Version, d)
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// DeprecatePackage handles PUT /api/v1/packages/{package}/deprecation,
+// deprecating every version of a package, those published later included,
+// with the same body as DeprecateArtifact.
+func (h *Handler) DeprecatePackage(w http.ResponseWriter, r *http.Request) {
+	d, ok := decodeDeprecation(w, r)
+	if !ok {
+		return
+	}
+	h.setPackageDeprecation(w, r, d)
+}
+
+// UndeprecatePackage handles DELETE /api/v1/packages/{package}/deprecation.
+// Versions deprecated on their own stay deprecated.
+func (h *Handler) UndeprecatePackage(w http.ResponseWriter, r *http.Request) {
+	h.setPackageDeprecation(w, r, nil)
+}
+
+func (h *Handler) setPackageDeprecation(w http.ResponseWriter, r *http.Request, d *models.Deprecation) {
+	pkgName := chi.URLParam(r, "package")
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionDeprecate, Package: pkgName}) {
+		return
+	}
+	if err := h.meta.SetPackageDeprecation(pkgName, d); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("package %s not found", pkgName))
+			return
+		}
+		if errors.Is(err, services.ErrReadOnly) {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		h.logger.Error().Err(err).Msg("setting package deprecation")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	pkg, err := h.meta.GetPackage(pkgName)
+	if err != nil || pkg == nil {
+		h.logger.Error().Err(err).Msg("getting deprecated package")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	h.logDeprecation(r, pkgName, "", d)
+	writeJSON(w, http.StatusOK, pkg)
+}
+
+// decodeDeprecation reads a deprecation from the request body, folding the
+// message onto one line so it can be sent in headers.
+func decodeDeprecation(w http.ResponseWriter, r *http.Request) (*models.Deprecation, bool) {
+	var d models.Deprecation
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return nil, false
+	}
+	d.Message = strings.Join(strings.Fields(d.Message), " ")
+	d.InFavorOf = strings.TrimSpace(d.InFavorOf)
+	if d.Message == "" || len(d.Message) > maxDeprecationBytes {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("message must be 1 to %d bytes", maxDeprecationBytes))
+		return nil, false
+	}
+	if strings.ContainsAny(d.InFavorOf, "\r\n") || len(d.InFavorOf) > maxDeprecationBytes {
+		writeError(w, http.StatusBadRequest, "invalid deprecated_in_favor_of")
+		return nil, false
+	}
+	return &d, true
+}
+
+// setDeprecationHeaders warns downloaders of a deprecated version, or of
+// any version of a deprecated package, with a Warning header and the
+// X-Artifact-Deprecated headers carrying the message and replacement. The
+// version's own deprecation is reported over its package's.
+func (h *Handler) setDeprecationHeaders(w http.ResponseWriter, artifact *models.Artifact) {
+	d, subject := artifact.Deprecated, artifact.Package+"@"+artifact.Version
+	if d == nil {
+		pkg, err := h.meta.GetPackage(artifact.Package)
+		if err != nil {
+			h.logger.Warn().Err(err).Str("package", artifact.Package).Msg("getting package deprecation")
+			return
+		}
+		if pkg == nil || pkg.Deprecated == nil {
+			return
+		}
+		d, subject = pkg.Deprecated, artifact.Package
+	}
+	text := subject + " is deprecated: " + d.Message
+	if d.InFavorOf != "" {
+		text += " (use " + d.InFavorOf + ")"
+	}
+	w.Header().Set("Warning", "299 - "+strconv.Quote(text))
+	w.Header().Set("X-Artifact-Deprecated", d.Message)
+	if d.InFavorOf != "" {
+		w.Header().Set("X-Artifact-Deprecated-In-Favor-Of", d.InFavorOf)
+	}
+}
+
+// logDeprecation logs a change to the deprecation of a package, or of one
+// of its versions when version is set.
+func (h *Handler) logDeprecation(r *http.Request, pkgName, version string, d *models.Deprecation) {
+	by := ""
+	if p := principalFrom(r.Context()); p != nil {
+		by = p.Name
+	}
+	event := h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Str("package", pkgName).
+		Str("version", version).
+		Str("by", by)
+	if d == nil {
+		event.Msg("deprecation cleared")
+		return
+	}
+	event.
+		Str("message", d.Message).
+		Str("in_favor_of", d.InFavorOf).
+		Msg("deprecated")
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// DiffVersions handles GET /api/v1/packages/{package}/diff?from=&to=,
+// comparing two versions of a package: their fields, named files,
+// dependencies and, when both default files are archives, the files inside
+// them. Archive listings are read and recorded on first use, like those of
+// the contents endpoint.
+func (h *Handler) DiffVersions(w http.ResponseWriter, r *http.Request) {
+	pkgName := chi.URLParam(r, "package")
+	fromVersion, toVersion := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if fromVersion == "" || toVersion == "" {
+		writeError(w, http.StatusBadRequest, "from and to are required")
+		return
+	}
+	from, ok := h.diffVersion(w, r, pkgName, fromVersion)
+	if !ok {
+		return
+	}
+	to, ok := h.diffVersion(w, r, pkgName, toVersion)
+	if !ok {
+		return
+	}
+
+	diff := models.VersionDiff{Package: pkgName, From: fromVersion, To: toVersion, Properties: diffProperties(from, to)}
+
+	var files [2]map[string]string
+	var deps [2]map[string]string
+	for i, a := range []*models.Artifact{from, to} {
+		assets, err := h.meta.ListAssets(pkgName, a.Version)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("listing assets")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		files[i] = make(map[string]string, len(assets))
+		for _, f := range assets {
+			files[i][f.Name] = f.Hash
+		}
+
+		list, err := h.meta.ListDependencies(pkgName, a.Version)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("listing dependencies")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		deps[i] = make(map[string]string, len(list))
+		for _, d := range list {
+			deps[i][d.Package] = d.Constraint
+		}
+	}
+	diff.Files = diffMaps(files[0], files[1])
+	diff.Dependencies = diffMaps(deps[0], deps[1])
+
+	var listings [2]*models.Contents
+	for i, a := range []*models.Artifact{from, to} {
+		contents, err := h.contents(a.Hash)
+		if err != nil {
+			h.logger.Error().Err(err).Str("hash", a.Hash).Msg("listing archive contents")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		listings[i] = contents
+	}
+	if listings[0].Format != "" && listings[1].Format != "" {
+		var entries [2]map[string]string
+		for i, contents := range listings {
+			entries[i] = make(map[string]string, len(contents.Entries))
+			for _, e := range contents.Entries {
+				entries[i][e.Path] = fmt.Sprintf("%d %s", e.Size, e.Mode)
+			}
+		}
+		section := diffMaps(entries[0], entries[1])
+		section.Truncated = listings[0].Truncated || listings[1].Truncated
+		diff.Contents = &section
+	}
+
+	writeJSON(w, http.StatusOK, diff)
+}
+
+// diffVersion looks up one side of a diff, writing a 404 if it does not
+// exist or is hidden from the caller of r.
+func (h *Handler) diffVersion(w http.ResponseWriter, r *http.Request, pkgName, version string) (*models.Artifact, bool) {
+	artifact, err := h.meta.GetArtifact(pkgName, version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting artifact")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return nil, false
+	}
+	if artifact == nil || hidden(r, artifact) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", pkgName, version))
+		return nil, false
+	}
+	return artifact, true
+}
+
+// diffProperties lists the fields of from and to that differ.
+func diffProperties(from, to *models.Artifact) []models.DiffEntry {
+	deprecation := func(a *models.Artifact) string {
+		if a.Deprecated == nil {
+			return ""
+		}
+		return a.Deprecated.Message
+	}
+	fields := []struct{ name, from, to string }{
+		{"hash", from.Hash, to.Hash},
+		{"size", strconv.FormatInt(from.Size, 10), strconv.FormatInt(to.Size, 10)},
+		{"filename", from.Filename, to.Filename},
+		{"content_type", from.ContentType, to.ContentType},
+		{"format", from.Format, to.Format},
+		{"licenses", strings.Join(from.Licenses, ","), strings.Join(to.Licenses, ",")},
+		{"stage", from.Stage, to.Stage},
+		{"stability", from.Stability, to.Stability},
+		{"deprecated", deprecation(from), deprecation(to)},
+	}
+	changed := []models.DiffEntry{}
+	for _, f := range fields {
+		if f.from != f.to {
+			changed = append(changed, models.DiffEntry{Name: f.name, From: f.from, To: f.to})
+		}
+	}
+	return changed
+}
+
+// diffMaps compares two sets of named values, listing each kind of
+// difference by name.
+func diffMaps(from, to map[string]string) models.DiffSection {
+	section := models.DiffSection{Added: []models.DiffEntry{}, Removed: []models.DiffEntry{}, Changed: []models.DiffEntry{}}
+	names := make([]string, 0, len(from)+len(to))
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		before, inFrom := from[name]
+		after, inTo := to[name]
+		switch {
+		case !inFrom:
+			section.Added = append(section.Added, models.DiffEntry{Name: name, To: after})
+		case !inTo:
+			section.Removed = append(section.Removed, models.DiffEntry{Name: name, From: before})
+		case before != after:
+			section.Changed = append(section.Changed, models.DiffEntry{Name: name, From: before, To: after})
+		}
+	}
+	return section
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/util/blake3"
+	"github.com/foundry/registry/internal/util/clock"
+)
+
+// blobDigestGrace is how long the digests of an unreferenced blob are
+// kept, long enough for the upload that computed them to record its
+// metadata.
+const blobDigestGrace = time.Hour
+
+// WithBLAKE3 computes BLAKE3 digests of uploads alongside SHA-512.
+func WithBLAKE3(enabled bool) Option {
+	return func(h *Handler) {
+		h.blake3 = enabled
+	}
+}
+
+// digester computes a blob's SHA-512 and, optionally, BLAKE3 digests from
+// the data written to it.
+type digester struct {
+	sha512 hash.Hash
+	blake3 hash.Hash
+	clock  clock.Clock
+}
+
+func (h *Handler) newDigester() *digester {
+	d := &digester{sha512: sha512.New(), clock: h.clock}
+	if h.blake3 {
+		d.blake3 = blake3.New()
+	}
+	return d
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	d.sha512.Write(p)
+	if d.blake3 != nil {
+		d.blake3.Write(p)
+	}
+	return len(p), nil
+}
+
+// digests returns what was computed for the blob with SHA-256 hash.
+func (d *digester) digests(hash string) models.BlobDigests {
+	out := models.BlobDigests{
+		Hash:       hash,
+		SHA512:     hex.EncodeToString(d.sha512.Sum(nil)),
+		ComputedAt: d.clock.Now(),
+	}
+	if d.blake3 != nil {
+		out.BLAKE3 = hex.EncodeToString(d.blake3.Sum(nil))
+	}
+	return out
+}
+
+// blobDigests returns the digests of the blob with SHA-256 hash, computing
+// and recording them when the blob predates them or BLAKE3 was enabled
+// since.
+func (h *Handler) blobDigests(hash string) (*models.BlobDigests, error) {
+	d, err := h.meta.GetBlobDigests(hash)
+	if err != nil {
+		return nil, err
+	}
+	if d != nil && (d.BLAKE3 != "" || !h.blake3) {
+		return d, nil
+	}
+
+	reader, err := h.blobs.Open(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	dg := h.newDigester()
+	if _, err := io.Copy(dg, reader); err != nil {
+		return nil, fmt.Errorf("reading blob %s: %w", hash, err)
+	}
+	computed := dg.digests(hash)
+	if err := h.meta.SetBlobDigests(computed); err != nil {
+		return nil, err
+	}
+	return &computed, nil
+}
+
+// setDigestHeaders adds the X-Artifact-Digest-* headers of a download. The
+// SHA-512 and BLAKE3 headers are left out until those digests have been
+// computed.
+func (h *Handler) setDigestHeaders(w http.ResponseWriter, artifact *models.Artifact) {
+	w.Header().Set("X-Artifact-Digest-SHA256", artifact.Hash)
+	d, err := h.meta.GetBlobDigests(artifact.Hash)
+	if err != nil {
+		h.logger.Error().Err(err).Str("hash", artifact.Hash).Msg("getting blob digests")
+		return
+	}
+	if d == nil {
+		return
+	}
+	w.Header().Set("X-Artifact-Digest-SHA512", d.SHA512)
+	if d.BLAKE3 != "" {
+		w.Header().Set("X-Artifact-Digest-BLAKE3", d.BLAKE3)
+	}
+}
+
+// GetChecksums handles GET /api/v1/artifacts/{package}/{version}/checksums.txt,
+// listing the digest and name of each of the version's files in the format
+// of sha256sum and its siblings. ?algorithm= picks sha256 (the default),
+// sha512 or, when enabled, blake3.
+func (h *Handler) GetChecksums(w http.ResponseWriter, r *http.Request) {
+	artifact, ok := h.lookupArtifact(w, r)
+	if !ok {
+		return
+	}
+	if hidden(r, artifact) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s is quarantined pending approval", artifact.Package, artifact.Version))
+		return
+	}
+	algorithm := strings.ToLower(r.URL.Query().Get("algorithm"))
+	switch algorithm {
+	case "":
+		algorithm = "sha256"
+	case "sha256", "sha512":
+	case "blake3":
+		if !h.blake3 {
+			writeError(w, http.StatusBadRequest, "blake3 digests are not enabled")
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "algorithm must be sha256, sha512 or blake3")
+		return
+	}
+
+	files, err := h.versionFiles(artifact)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing assets")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	var b strings.Builder
+	for _, f := range files {
+		sum := f.Hash
+		if algorithm != "sha256" {
+			d, err := h.blobDigests(f.Hash)
+			if err != nil {
+				h.logger.Error().Err(err).Str("hash", f.Hash).Msg("computing blob digests")
+				writeError(w, http.StatusInternalServerError, "internal error")
+				return
+			}
+			sum = d.SHA512
+			if algorithm == "blake3" {
+				sum = d.BLAKE3
+			}
+		}
+		fmt.Fprintf(&b, "%s  %s\n", sum, f.Name)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, b.String())
+}
@@ -0,0 +1,86 @@
+//go:build unix
+
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// copyingStorage hides the files blob storage opens, so downloads are
+// copied through user space instead of sent with sendfile.
+type copyingStorage struct {
+	services.BlobStorage
+}
+
+func (s copyingStorage) Open(hash string) (io.ReadCloser, error) {
+	f, err := s.BlobStorage.Open(hash)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ io.ReadCloser }{f}, nil
+}
+
+// processCPU returns the user and system CPU time the process has used.
+func processCPU(b *testing.B) time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		b.Fatalf("getrusage: %v", err)
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// BenchmarkDownload serves a 64 MiB artifact over a real connection and
+// reports the CPU the whole process spent per GB served, with and without
+// sendfile.
+func BenchmarkDownload(b *testing.B) {
+	h, router := setupTestHandler(&testing.T{})
+	data := make([]byte, 64<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	req := httptest.NewRequest("POST", "/api/v1/artifacts/big/1.0.0", bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer test-token")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	disk := h.blobs
+
+	for _, bc := range []struct {
+		name  string
+		blobs services.BlobStorage
+	}{
+		{"sendfile", disk},
+		{"copy", copyingStorage{disk}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			h.blobs = bc.blobs
+			buf := make([]byte, 1<<20)
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			cpu := processCPU(b)
+			for i := 0; i < b.N; i++ {
+				req, _ := http.NewRequest("GET", srv.URL+"/api/v1/artifacts/big/1.0.0", nil)
+				req.Header.Set("Authorization", "Bearer test-token")
+				resp, err := srv.Client().Do(req)
+				if err != nil {
+					b.Fatalf("download: %v", err)
+				}
+				n, _ := io.CopyBuffer(io.Discard, resp.Body, buf)
+				resp.Body.Close()
+				if n != int64(len(data)) {
+					b.Fatalf("downloaded %d bytes, want %d", n, len(data))
+				}
+			}
+			cpu = processCPU(b) - cpu
+			gb := float64(b.N) * float64(len(data)) / 1e9
+			b.ReportMetric(float64(cpu.Milliseconds())/gb, "cpu-ms/GB")
+		})
+	}
+}
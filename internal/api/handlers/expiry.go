@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// uploadExpiry returns the expiry requested by the X-Artifact-Expires header
+// of r, or nil without one, writing a 400 if it is invalid. The header holds
+// either an RFC 3339 timestamp or a time to live such as "72h" or "7d".
+func uploadExpiry(w http.ResponseWriter, r *http.Request, now time.Time) (*time.Time, bool) {
+	value := strings.TrimSpace(r.Header.Get("X-Artifact-Expires"))
+	if value == "" {
+		return nil, true
+	}
+	expiresAt, err := parseExpiry(value, now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid X-Artifact-Expires: %v", err))
+		return nil, false
+	}
+	return &expiresAt, true
+}
+
+// parseExpiry reads an RFC 3339 timestamp or a TTL relative to now. The
+// result must lie after now.
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		ttl, ttlErr := parseTTL(value)
+		if ttlErr != nil {
+			return time.Time{}, errors.New("want an RFC 3339 timestamp or a duration such as 72h or 7d")
+		}
+		expiresAt = now.Add(ttl)
+	}
+	if !expiresAt.After(now) {
+		return time.Time{}, errors.New("expiry must be in the future")
+	}
+	return expiresAt.UTC(), nil
+}
+
+// parseTTL parses a Go duration, also accepting a whole number of days
+// written with a "d" suffix.
+func parseTTL(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// ExpireArtifacts deletes the versions whose expiry is at or before now and
+// returns how many it removed. Pinned versions are kept. Their blobs are
+// released like those of any delete, and go once nothing else uses them.
+func (h *Handler) ExpireArtifacts(now time.Time) (int, error) {
+	expired, err := h.meta.ListExpired(now)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, a := range expired {
+		if err := h.deleteVersion(nil, expiryActor, a.Package, a.Version); err != nil {
+			// Gone already, or pinned since it was listed.
+			if errors.Is(err, services.ErrNotFound) || errors.Is(err, services.ErrConflict) {
+				continue
+			}
+			return removed, err
+		}
+		removed++
+		h.logger.Info().
+			Str("package", a.Package).
+			Str("version", a.Version).
+			Time("expires_at", *a.ExpiresAt).
+			Msg("artifact expired")
+	}
+	return removed, nil
+}
+
+// RunExpiry calls ExpireArtifacts every interval until ctx is canceled.
+func (h *Handler) RunExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := h.ExpireArtifacts(h.clock.Now()); err != nil {
+				h.logger.Error().Err(err).Msg("expiring artifacts")
+			}
+		}
+	}
+}
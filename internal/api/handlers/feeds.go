@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+const (
+	// feedEntries is how many releases a feed lists, newest first.
+	feedEntries = 50
+	// feedPages bounds the pages of versions read for the global feed, so
+	// a long run of quarantined or staging uploads cannot make it scan
+	// every version.
+	feedPages = 10
+
+	atomContentType = "application/atom+xml; charset=utf-8"
+)
+
+// atomFeed is an Atom (RFC 4287) feed document.
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Author    atomAuthor  `xml:"author"`
+	Generator string      `xml:"generator"`
+	Links     []atomLink  `xml:"link"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string       `xml:"id"`
+	Title     string       `xml:"title"`
+	Updated   string       `xml:"updated"`
+	Published string       `xml:"published"`
+	Link      atomLink     `xml:"link"`
+	Summary   string       `xml:"summary"`
+	Content   *atomContent `xml:"content,omitempty"`
+}
+
+// atomContent carries a release's notes, as the Markdown source.
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// PackageFeed handles GET /feeds/packages/{package}.atom, an Atom feed of
+// the package's newest releases. ?channel= keeps those of that stability
+// or more stable.
+func (h *Handler) PackageFeed(w http.ResponseWriter, r *http.Request) {
+	pkgName, ok := strings.CutSuffix(chi.URLParam(r, "file"), ".atom")
+	if !ok || pkgName == "" {
+		writeError(w, http.StatusNotFound, "route not found")
+		return
+	}
+	channel, err := parseChannel(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pkg, err := h.meta.GetPackage(pkgName)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting package")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if pkg == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("package %s not found", pkgName))
+		return
+	}
+	artifacts, err := h.meta.ListArtifacts(pkgName)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing artifacts")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	releases := inChannel(released(visibleArtifacts(r, artifacts)), channel)
+	if len(releases) > feedEntries {
+		releases = releases[:feedEntries]
+	}
+
+	base := h.externalURL(r)
+	self := base + "/feeds/packages/" + url.PathEscape(pkgName) + ".atom" + channelQuery(channel)
+	title := pkgName + " releases"
+	if pkg.Deprecated != nil {
+		title += " (deprecated)"
+	}
+	h.writeFeed(w, r, atomFeed{
+		ID:    self,
+		Title: title,
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: self},
+			{Rel: "alternate", Type: "application/json", Href: base + "/api/v1/packages/" + url.PathEscape(pkgName)},
+		},
+	}, releases)
+}
+
+// ReleasesFeed handles GET /feeds/releases.atom, an Atom feed of the
+// newest releases across all packages, with the same ?channel= as
+// PackageFeed.
+func (h *Handler) ReleasesFeed(w http.ResponseWriter, r *http.Request) {
+	channel, err := parseChannel(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var releases []models.Artifact
+	f := models.ArtifactFilter{Channel: channel, Limit: feedEntries}
+	for range feedPages {
+		page, err := h.meta.FindArtifacts(f)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("finding artifacts")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		releases = append(releases, released(visibleArtifacts(r, page))...)
+		if len(releases) >= feedEntries || len(page) < f.Limit {
+			break
+		}
+		f.BeforeID = page[len(page)-1].ID
+	}
+	if len(releases) > feedEntries {
+		releases = releases[:feedEntries]
+	}
+
+	self := h.externalURL(r) + "/feeds/releases.atom" + channelQuery(channel)
+	h.writeFeed(w, r, atomFeed{
+		ID:    self,
+		Title: "Releases",
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: self},
+		},
+	}, releases)
+}
+
+// channelQuery is the query naming a feed's channel, if it has one.
+func channelQuery(channel string) string {
+	if channel == "" {
+		return ""
+	}
+	return "?channel=" + channel
+}
+
+// writeFeed fills feed with an entry per release, newest first, and writes
+// it. The feed was last updated when its newest entry was.
+func (h *Handler) writeFeed(w http.ResponseWriter, r *http.Request, feed atomFeed, releases []models.Artifact) {
+	base := h.externalURL(r)
+	feed.Author = atomAuthor{Name: "Foundry Registry"}
+	feed.Generator = "Foundry Registry"
+	var updated time.Time
+	for _, a := range releases {
+		feed.Entries = append(feed.Entries, feedEntry(base, a))
+		if t := releasedAt(a); t.After(updated) {
+			updated = t
+		}
+	}
+	if updated.IsZero() {
+		updated = h.clock.Now()
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		h.logger.Error().Err(err).Msg("encoding feed")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	w.Header().Set("Content-Type", atomContentType)
+	h.cacheListing(w)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(append(body, '\n'))
+}
+
+// feedEntry describes a release, with its notes as the content. Its ID names the content too, so a
+// version deleted and published again shows up as a new entry.
+func feedEntry(base string, a models.Artifact) atomEntry {
+	href := base + "/api/v1/artifacts/" + url.PathEscape(a.Package) + "/" + url.PathEscape(a.Version)
+	summary := fmt.Sprintf("%s %s, %d bytes, sha256 %s", a.Package, a.Version, a.Size, a.Hash)
+	if a.Filename != "" {
+		summary = fmt.Sprintf("%s %s (%s), %d bytes, sha256 %s", a.Package, a.Version, a.Filename, a.Size, a.Hash)
+	}
+	if a.Deprecated != nil {
+		summary += ". Deprecated: " + a.Deprecated.Message
+		if a.Deprecated.InFavorOf != "" {
+			summary += " (use " + a.Deprecated.InFavorOf + ")"
+		}
+	}
+	entry := atomEntry{
+		ID:        href + "#" + a.Hash,
+		Title:     a.Package + " " + a.Version,
+		Updated:   releasedAt(a).UTC().Format(time.RFC3339),
+		Published: a.UploadedAt.UTC().Format(time.RFC3339),
+		Link:      atomLink{Rel: "alternate", Href: href},
+		Summary:   summary,
+	}
+	if a.ReleaseNotes != "" {
+		entry.Content = &atomContent{Type: "text", Body: a.ReleaseNotes}
+	}
+	return entry
+}
+
+// releasedAt is when a version was released: its last promotion, or its
+// upload if it was published straight to release.
+func releasedAt(a models.Artifact) time.Time {
+	if a.PromotedAt != nil {
+		return *a.PromotedAt
+	}
+	return a.UploadedAt
+}
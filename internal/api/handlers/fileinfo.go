@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// maxFilenameLength bounds stored filenames to what common filesystems
+// accept.
+const maxFilenameLength = 255
+
+// genericContentTypes say nothing about the file itself: curl --data-binary
+// sends form-urlencoded by default, and octet-stream is every client's
+// fallback. They are not stored, so the download can fall back to the
+// filename's extension instead.
+var genericContentTypes = map[string]bool{
+	"application/octet-stream":          true,
+	"application/x-www-form-urlencoded": true,
+}
+
+// uploadFile is the file of an upload, as found by uploadBody.
+type uploadFile struct {
+	body        io.Reader
+	filename    string
+	contentType string
+	// form holds the parts of a multipart upload after the file, and meta
+	// its metadata part once read.
+	form *multipart.Reader
+	meta *models.UploadMetadata
+}
+
+// uploadBody returns the artifact content of an upload along with the
+// file's original name and MIME type. Raw uploads describe the file with
+// X-Artifact-Filename (or a Content-Disposition filename) and Content-Type;
+// multipart/form-data uploads use the first file part. A metadata part
+// before the file is read (see uploadFile.metadata); other form fields are
+// skipped.
+func uploadBody(r *http.Request) (*uploadFile, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		filename := r.Header.Get("X-Artifact-Filename")
+		if filename == "" {
+			if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil {
+				filename = params["filename"]
+			}
+		}
+		return &uploadFile{
+			body:        r.Body,
+			filename:    sanitizeFilename(filename),
+			contentType: normalizeContentType(r.Header.Get("Content-Type")),
+		}, nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("reading multipart body: %w", err)
+	}
+	f := &uploadFile{form: mr}
+	for {
+		p, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("multipart upload has no file part")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading multipart body: %w", err)
+		}
+		// curl -F metadata=@file names the metadata part's file too.
+		if p.FormName() == metadataPart {
+			if err := f.readMetadata(p); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.FileName() != "" || p.FormName() == "file" {
+			f.body = p
+			f.filename = sanitizeFilename(p.FileName())
+			f.contentType = normalizeContentType(p.Header.Get("Content-Type"))
+			return f, nil
+		}
+	}
+}
+
+// sanitizeFilename reduces a client-supplied name to a bare file name that
+// is safe to echo back in Content-Disposition, or "" if nothing usable
+// remains.
+func sanitizeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+	if len(name) > maxFilenameLength {
+		return ""
+	}
+	return name
+}
+
+// normalizeContentType returns the canonical form of a client-supplied MIME
+// type, or "" if it is invalid or generic.
+func normalizeContentType(ct string) string {
+	mediaType, params, err := mime.ParseMediaType(ct)
+	if err != nil || genericContentTypes[mediaType] || strings.HasPrefix(mediaType, "multipart/") {
+		return ""
+	}
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// servedContentType picks the Content-Type for a download: the uploaded
+// type, else one inferred from the filename, else octet-stream.
+func servedContentType(a *models.Artifact) string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+	if a.Filename != "" {
+		if ct := mime.TypeByExtension(path.Ext(a.Filename)); ct != "" {
+			return ct
+		}
+	}
+	return "application/octet-stream"
+}
+
+// downloadFilename is the name offered to clients: the original filename if
+// one was uploaded, otherwise <package>-<version>.
+func downloadFilename(a *models.Artifact) string {
+	if a.Filename != "" {
+		return a.Filename
+	}
+	return fmt.Sprintf("%s-%s", a.Package, a.Version)
+}
+
+// contentDisposition formats an attachment header for filename, using the
+// RFC 2231 encoding when the name is not plain ASCII.
+func contentDisposition(filename string) string {
+	if v := mime.FormatMediaType("attachment", map[string]string{"filename": filename}); v != "" {
+		return v
+	}
+	return "attachment"
+}
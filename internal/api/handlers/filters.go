@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// parseArtifactFilter reads the listing filters shared by the package
+// detail and artifact list endpoints: uploaded_after and uploaded_before as
+// RFC 3339 times or YYYY-MM-DD dates (midnight UTC), min_size and max_size
+// in bytes, hash, license as an SPDX identifier, format, and channel, a
+// stability level.
+func parseArtifactFilter(r *http.Request) (models.ArtifactFilter, error) {
+	query := r.URL.Query()
+	var f models.ArtifactFilter
+	var err error
+	if f.UploadedAfter, err = parseFilterTime(query.Get("uploaded_after"), "uploaded_after"); err != nil {
+		return f, err
+	}
+	if f.UploadedBefore, err = parseFilterTime(query.Get("uploaded_before"), "uploaded_before"); err != nil {
+		return f, err
+	}
+	if f.MinSize, err = parseFilterSize(query.Get("min_size"), "min_size"); err != nil {
+		return f, err
+	}
+	if f.MaxSize, err = parseFilterSize(query.Get("max_size"), "max_size"); err != nil {
+		return f, err
+	}
+	f.Hash = strings.ToLower(query.Get("hash"))
+	f.License = query.Get("license")
+	f.Format = strings.ToLower(query.Get("format"))
+	if f.Channel, err = parseChannel(r); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+func parseFilterTime(raw, name string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, raw); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time or a YYYY-MM-DD date", name)
+}
+
+func parseFilterSize(raw, name string) (*int64, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("%s must be a non-negative number of bytes", name)
+	}
+	return &n, nil
+}
+
+// ListArtifacts handles GET /api/v1/artifacts, listing versions across
+// every package, newest first, narrowed by the filters parseArtifactFilter
+// reads and ?package=. Results are paged with ?limit= and ?cursor=.
+func (h *Handler) ListArtifacts(w http.ResponseWriter, r *http.Request) {
+	f, err := parseArtifactFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	f.Package = r.URL.Query().Get("package")
+	limit, err := pageLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		id, _, err := decodeCursor(cursor)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.BeforeID = id
+	}
+
+	// Fetch one extra row to learn whether another page follows.
+	f.Limit = limit + 1
+	artifacts, err := h.meta.FindArtifacts(f)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("finding artifacts")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	var resp models.ArtifactList
+	if len(artifacts) > limit {
+		artifacts = artifacts[:limit]
+		resp.NextCursor = encodeCursor(artifacts[limit-1].ID, "")
+		h.setNextLink(w, r, resp.NextCursor)
+	}
+	resp.Artifacts = visibleArtifacts(r, artifacts)
+	h.writeListing(w, r, resp)
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/util/filetype"
+)
+
+// blobFormatGrace is how long the format of an unreferenced blob is kept,
+// long enough for the upload that classified it to record its metadata.
+const blobFormatGrace = time.Hour
+
+// blobFormat returns the format of the blob with hash, classifying and
+// recording it when the blob was stored before formats were, as linked
+// blobs may have been.
+func (h *Handler) blobFormat(hash string) (string, error) {
+	format, err := h.meta.GetBlobFormat(hash)
+	if err != nil || format != "" {
+		return format, err
+	}
+	reader, err := h.blobs.Open(hash)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	if format, err = filetype.Detect(reader); err != nil {
+		return "", err
+	}
+	return format, h.meta.SetBlobFormat(hash, format)
+}
+
+// uploadDecision checks a stored upload before it is published, setting
+// event.Format to the format of its content. The policy engine sees the
+// upload again, now with its format, and then the pre-upload hooks run. It
+// returns status 0 when the upload may proceed, like policyDecision.
+func (h *Handler) uploadDecision(r *http.Request, event *models.HookEvent) (int, string) {
+	format, err := h.blobFormat(event.Hash)
+	if err != nil {
+		h.logger.Error().Err(err).Str("hash", event.Hash).Msg("classifying upload")
+		return http.StatusInternalServerError, "internal error"
+	}
+	event.Format = format
+	if status, msg := h.policyDecision(r, models.PolicyRequest{
+		Action:  models.PolicyActionUpload,
+		Package: event.Package,
+		Version: event.Version,
+		File:    event.File,
+		Format:  format,
+	}); status != 0 {
+		return status, msg
+	}
+	return h.hookDecision(r, *event)
+}
+
+// checkUpload writes the error response and returns false when
+// uploadDecision refuses the upload.
+func (h *Handler) checkUpload(w http.ResponseWriter, r *http.Request, event *models.HookEvent) bool {
+	if status, msg := h.uploadDecision(r, event); status != 0 {
+		writeError(w, status, msg)
+		return false
+	}
+	return true
+}
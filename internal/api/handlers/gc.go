@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/util/logging"
+	"github.com/foundry/registry/internal/util/ratelimit"
+)
+
+const defaultGCBatchSize = 500
+
+// GCLimits paces garbage collection so a large collection does not
+// saturate storage. Zero values leave the corresponding limit disabled.
+type GCLimits struct {
+	// BatchSize is how many blobs are examined between progress updates
+	// and checks for cancellation and the time limit. It defaults to 500.
+	BatchSize int
+	// BlobsPerSecond caps how fast unreferenced blobs are deleted.
+	BlobsPerSecond int
+	// MaxDuration stops a run early. The next run resumes after the last
+	// blob examined.
+	MaxDuration time.Duration
+}
+
+// WithGCLimits paces garbage collection runs.
+func WithGCLimits(l GCLimits) Option {
+	return func(h *Handler) {
+		h.gc.limits = l
+	}
+}
+
+// gcRunner allows one garbage collection at a time and keeps the jobs
+// started in the background.
+type gcRunner struct {
+	limits GCLimits
+
+	mu      sync.Mutex
+	running bool
+	// resumeAfter is the last blob examined by a run that stopped early.
+	resumeAfter string
+	jobs        []*gcJob
+}
+
+func newGCRunner() *gcRunner {
+	return &gcRunner{}
+}
+
+// begin claims the runner, returning false if a collection is running.
+func (g *gcRunner) begin() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.running {
+		return false
+	}
+	g.running = true
+	return true
+}
+
+func (g *gcRunner) end() {
+	g.mu.Lock()
+	g.running = false
+	g.mu.Unlock()
+}
+
+// GarbageCollect handles POST /api/v1/gc. With ?dry_run=true it reports the
+// blobs that would be deleted without removing anything. It also reports
+// version files whose blob is missing from storage; ?tombstone=true removes
+// their metadata. ?async=true runs the collection as a background job and
+// answers 202 with the job. Collections that would free more than the
+// approval rules allow are held for approval instead, answering 202 with
+// the approval.
+func (h *Handler) GarbageCollect(w http.ResponseWriter, r *http.Request) {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	tombstone, _ := strconv.ParseBool(r.URL.Query().Get("tombstone"))
+	async, _ := strconv.ParseBool(r.URL.Query().Get("async"))
+
+	if !h.gc.begin() {
+		writeError(w, http.StatusConflict, "garbage collection is already running")
+		return
+	}
+
+	if !dryRun && h.approvals != nil && h.approvals.rules.GCBytes > 0 {
+		estimate, err := h.collectGarbage(r.Context(), r, true, false, func(models.GCProgress) {})
+		if err != nil {
+			h.gc.end()
+			h.logger.Error().Err(err).Msg("estimating garbage collection")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if h.approvals.holdsGC(estimate.FreedBytes) {
+			h.gc.end()
+			h.holdForApproval(w, r, models.Approval{
+				Action:    models.ApprovalActionGC,
+				Tombstone: tombstone,
+				Reason: fmt.Sprintf("would free %d bytes in %d blobs, over the %d allowed without approval",
+					estimate.FreedBytes, estimate.DeletedBlobs, h.approvals.rules.GCBytes),
+			})
+			return
+		}
+	}
+
+	if async {
+		job := h.startGCJob(r, dryRun, tombstone)
+		w.Header().Set("Location", h.externalURL(r)+"/api/v1/admin/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+		return
+	}
+
+	defer h.gc.end()
+	result, err := h.collectGarbage(r.Context(), r, dryRun, tombstone, func(models.GCProgress) {})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("collecting garbage")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// collectGarbage deletes the blobs no metadata references, in batches
+// paced by the runner's limits, and reports files whose blob is missing.
+// It stops early, with a partial result, once ctx is done or the time limit
+// passes. progress is called after every batch.
+func (h *Handler) collectGarbage(ctx context.Context, r *http.Request, dryRun, tombstone bool, progress func(models.GCProgress)) (models.GCResult, error) {
+	// List blobs before reading references: uploads record their metadata
+	// before committing the blob, so every listed blob that is in use is
+	// already referenced.
+	blobs, err := h.blobs.ListBlobs()
+	if err != nil {
+		return models.GCResult{}, err
+	}
+	present := make(map[string]bool, len(blobs))
+	for _, hash := range blobs {
+		present[hash] = true
+	}
+	missing, err := h.findMissingFiles(r, present, tombstone && !dryRun)
+	if err != nil {
+		return models.GCResult{}, err
+	}
+	referenced, err := h.meta.ReferencedHashes()
+	if err != nil {
+		return models.GCResult{}, err
+	}
+
+	result := models.GCResult{DryRun: dryRun, MissingFiles: len(missing), Missing: missing}
+	for _, f := range missing {
+		if f.Tombstoned {
+			result.Tombstoned++
+		}
+	}
+
+	// Dry runs always look at every blob; real runs pick up where a run
+	// cut short stopped.
+	sort.Strings(blobs)
+	start := 0
+	if !dryRun {
+		h.gc.mu.Lock()
+		resumeAfter := h.gc.resumeAfter
+		h.gc.mu.Unlock()
+		if resumeAfter != "" {
+			start = sort.Search(len(blobs), func(i int) bool { return blobs[i] > resumeAfter })
+		}
+	}
+
+	limits := h.gc.limits
+	batch := limits.BatchSize
+	if batch <= 0 {
+		batch = defaultGCBatchSize
+	}
+	var deadline time.Time
+	if limits.MaxDuration > 0 {
+		deadline = time.Now().Add(limits.MaxDuration)
+	}
+	pace := ratelimit.NewLimiter(int64(limits.BlobsPerSecond))
+
+	p := models.GCProgress{TotalBlobs: len(blobs), ScannedBlobs: start}
+	i := start
+	for ; i < len(blobs); i++ {
+		if i > start && (i-start)%batch == 0 {
+			p.DeletedBlobs, p.FreedBytes = result.DeletedBlobs, result.FreedBytes
+			p.ScannedBlobs = i
+			progress(p)
+			if ctx.Err() != nil || (!deadline.IsZero() && time.Now().After(deadline)) {
+				break
+			}
+		}
+
+		hash := blobs[i]
+		if referenced[hash] {
+			continue
+		}
+
+		size, _ := h.blobs.Size(hash)
+		if dryRun {
+			result.Candidates = append(result.Candidates, hash)
+			result.DeletedBlobs++
+			result.FreedBytes += size
+			continue
+		}
+
+		if err := pace.WaitN(ctx, 1); err != nil {
+			break
+		}
+		deleted, err := h.deleteUnreferencedBlob(hash)
+		if err != nil {
+			h.logger.Error().Err(err).Str("hash", hash).Msg("deleting unreferenced blob")
+			continue
+		}
+		if !deleted {
+			// Referenced or being uploaded since the references were read.
+			continue
+		}
+		result.DeletedBlobs++
+		result.FreedBytes += size
+		h.logger.Info().Str("hash", hash).Msg("garbage collected blob")
+	}
+	p.DeletedBlobs, p.FreedBytes = result.DeletedBlobs, result.FreedBytes
+	p.ScannedBlobs = i
+	progress(p)
+
+	result.Partial = i < len(blobs)
+	if !dryRun {
+		h.gc.mu.Lock()
+		switch {
+		case !result.Partial:
+			h.gc.resumeAfter = ""
+		case i > start:
+			h.gc.resumeAfter = blobs[i-1]
+		}
+		h.gc.mu.Unlock()
+	}
+	if result.Partial {
+		h.logger.Info().
+			Str("request_id", logging.RequestID(r.Context())).
+			Int("scanned_blobs", i).
+			Int("total_blobs", len(blobs)).
+			Msg("garbage collection stopped early")
+	}
+
+	if !dryRun && ctx.Err() == nil {
+		if h.transcodes != nil {
+			if n, err := h.transcodes.Prune(referenced); err != nil {
+				h.logger.Error().Err(err).Msg("pruning transcode cache")
+			} else if n > 0 {
+				h.logger.Info().Int("entries", n).Msg("pruned transcode cache")
+			}
+		}
+		if err := h.meta.PruneContents(); err != nil {
+			h.logger.Error().Err(err).Msg("pruning archive listings")
+		}
+		// Uploads record their scan before their metadata; the grace
+		// period keeps the scans of those in flight.
+		if err := h.meta.PruneMalwareScans(h.clock.Now().Add(-malwareScanGrace)); err != nil {
+			h.logger.Error().Err(err).Msg("pruning malware scans")
+		}
+		if err := h.meta.PruneBlobDigests(h.clock.Now().Add(-blobDigestGrace)); err != nil {
+			h.logger.Error().Err(err).Msg("pruning blob digests")
+		}
+		if err := h.meta.PruneBlobFormats(h.clock.Now().Add(-blobFormatGrace)); err != nil {
+			h.logger.Error().Err(err).Msg("pruning blob formats")
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/util/graphql"
+	"github.com/foundry/registry/internal/util/semver"
+)
+
+// maxGraphQLDepth bounds how deeply a GraphQL query may nest objects, so a
+// query cannot follow dependencies without end.
+const maxGraphQLDepth = 8
+
+// errGraphQLInternal stands in for the causes of failed fields, which are
+// logged rather than returned.
+var errGraphQLInternal = errors.New("internal error")
+
+// GraphQL handles POST /graphql, answering a GraphQL query over the
+// packages, versions, files, dependencies and statistics the REST API
+// serves, with the same visibility rules: quarantined versions are hidden
+// from non-admin callers, and statistics and download counts need admin
+// rights. GET /graphql takes the query, operationName and variables as
+// query parameters instead. Field names follow the REST API's JSON.
+//
+// Requests that cannot run, for being malformed or not matching the
+// schema, answer 400 with only errors; otherwise the answer is 200 with
+// data, and errors for any fields that failed.
+func (h *Handler) GraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeError(w, http.StatusBadRequest, "variables must be a JSON object")
+				return
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeError(w, http.StatusBadRequest, "query is required")
+		return
+	}
+
+	resp := h.graphqlSchema(r).Execute(r.Context(), req)
+	status := http.StatusOK
+	if resp.Data == nil {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, resp)
+}
+
+// graphqlQuery is what the resolvers of one GraphQL request share: its
+// caller, and the versions, dependencies and download totals already
+// loaded, so a package asked for twice is only read once.
+type graphqlQuery struct {
+	h         *Handler
+	r         *http.Request
+	versions  map[string][]models.Artifact
+	deps      map[string][]models.Dependency
+	downloads map[string]map[string]int64
+}
+
+// graphqlSchema builds the schema served to the caller of r.
+func (h *Handler) graphqlSchema(r *http.Request) *graphql.Schema {
+	q := &graphqlQuery{
+		h:         h,
+		r:         r,
+		versions:  make(map[string][]models.Artifact),
+		deps:      make(map[string][]models.Dependency),
+		downloads: make(map[string]map[string]int64),
+	}
+	channel := map[string]graphql.Arg{"channel": {Type: graphql.String}}
+	days := map[string]graphql.Arg{"days": {Type: graphql.Int, Default: int64(defaultReportDays)}}
+
+	deprecation := &graphql.Object{Name: "Deprecation", Fields: scalarFields("message", "deprecated_in_favor_of")}
+	vulnerabilities := &graphql.Object{Name: "Vulnerabilities", Fields: scalarFields("critical", "high", "medium", "low", "unknown")}
+	file := &graphql.Object{Name: "File", Fields: scalarFields("name", "hash", "size", "content_type", "default", "uploaded_at")}
+
+	version := &graphql.Object{Name: "Version", Fields: scalarFields(
+		"id", "package", "version", "hash", "size", "filename", "content_type", "uploaded_at",
+		"quarantined", "stage", "promoted_by", "promoted_at", "stability", "expires_at", "pinned",
+		"release_notes", "description", "labels", "revision", "tier", "malware", "licenses", "sha512", "blake3", "format",
+	)}
+	version.Fields["deprecated"] = &graphql.Field{Type: deprecation}
+	version.Fields["vulnerabilities"] = &graphql.Field{Type: vulnerabilities}
+	version.Fields["files"] = &graphql.Field{Type: file, Resolve: q.files}
+	version.Fields["downloads"] = &graphql.Field{Args: days, Resolve: q.versionDownloads}
+
+	dependency := &graphql.Object{Name: "Dependency", Fields: scalarFields("package", "constraint")}
+	dependency.Fields["resolved"] = &graphql.Field{Type: version, Args: channel, Resolve: q.resolved}
+	version.Fields["dependencies"] = &graphql.Field{Type: dependency, Resolve: q.dependencies}
+
+	pkg := &graphql.Object{Name: "Package", Fields: scalarFields("id", "name")}
+	pkg.Fields["deprecated"] = &graphql.Field{Type: deprecation}
+	pkg.Fields["latest"] = &graphql.Field{Args: channel, Resolve: q.latest}
+	pkg.Fields["version"] = &graphql.Field{
+		Type:    version,
+		Args:    map[string]graphql.Arg{"version": {Type: graphql.String, Required: true}},
+		Resolve: q.packageVersion,
+	}
+	pkg.Fields["versions"] = &graphql.Field{
+		Type: version,
+		Args: map[string]graphql.Arg{
+			"stage":   {Type: graphql.String, Default: models.StageRelease},
+			"channel": {Type: graphql.String},
+			"sort":    {Type: graphql.String, Default: sortUploaded},
+			"first":   {Type: graphql.Int, Default: int64(defaultPageSize)},
+		},
+		Resolve: q.packageVersions,
+	}
+	pkg.Fields["downloads"] = &graphql.Field{Args: days, Resolve: q.packageDownloads}
+
+	stats := &graphql.Object{Name: "Stats", Fields: scalarFields(
+		"packages", "artifacts", "artifact_bytes", "unique_blobs", "unique_blob_bytes",
+		"stored_blobs", "stored_bytes", "active_tokens", "free_bytes", "reserve_bytes",
+	)}
+
+	query := &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+		"package": {
+			Type:    pkg,
+			Args:    map[string]graphql.Arg{"name": {Type: graphql.String, Required: true}},
+			Resolve: q.pkg,
+		},
+		"packages": {
+			Type: pkg,
+			Args: map[string]graphql.Arg{
+				"search": {Type: graphql.String},
+				"first":  {Type: graphql.Int, Default: int64(defaultPageSize)},
+			},
+			Resolve: q.packages,
+		},
+		"version": {
+			Type: version,
+			Args: map[string]graphql.Arg{
+				"package": {Type: graphql.String, Required: true},
+				"version": {Type: graphql.String, Required: true},
+			},
+			Resolve: q.version,
+		},
+		"versions": {
+			Type: version,
+			Args: map[string]graphql.Arg{
+				"package":         {Type: graphql.String},
+				"uploaded_after":  {Type: graphql.String},
+				"uploaded_before": {Type: graphql.String},
+				"license":         {Type: graphql.String},
+				"format":          {Type: graphql.String},
+				"channel":         {Type: graphql.String},
+				"first":           {Type: graphql.Int, Default: int64(defaultPageSize)},
+			},
+			Resolve: q.findVersions,
+		},
+		"stats": {Type: stats, Resolve: q.stats},
+	}}
+	return &graphql.Schema{Query: query, MaxDepth: maxGraphQLDepth}
+}
+
+// scalarFields declares fields read straight from the source's JSON names.
+func scalarFields(names ...string) map[string]*graphql.Field {
+	fields := make(map[string]*graphql.Field, len(names))
+	for _, name := range names {
+		fields[name] = &graphql.Field{}
+	}
+	return fields
+}
+
+// fail logs the cause of a failed field and returns the error reported for
+// it.
+func (q *graphqlQuery) fail(err error, msg string) error {
+	q.h.logger.Error().Err(err).Msg(msg)
+	return errGraphQLInternal
+}
+
+// artifacts lists the versions of pkgName visible to the caller, newest
+// first.
+func (q *graphqlQuery) artifacts(pkgName string) ([]models.Artifact, error) {
+	versions, err := q.resolver("").artifacts(pkgName)
+	if err != nil {
+		return nil, q.fail(err, "listing artifacts")
+	}
+	return visibleArtifacts(q.r, versions), nil
+}
+
+// resolver returns a dependency resolver for channel sharing the versions
+// and dependencies this request has loaded.
+func (q *graphqlQuery) resolver(channel string) *resolver {
+	return &resolver{meta: q.h.meta, channel: channel, versions: q.versions, deps: q.deps}
+}
+
+func (q *graphqlQuery) pkg(_ context.Context, _ any, args map[string]any) (any, error) {
+	pkg, err := q.h.meta.GetPackage(args["name"].(string))
+	if err != nil {
+		return nil, q.fail(err, "getting package")
+	}
+	return pkg, nil
+}
+
+func (q *graphqlQuery) packages(_ context.Context, _ any, args map[string]any) (any, error) {
+	first, err := graphqlFirst(args)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []models.Package
+	if search, _ := args["search"].(string); search != "" {
+		pkgs, err = q.h.meta.SearchPackages(search)
+	} else {
+		pkgs, err = q.h.meta.ListPackages()
+	}
+	if err != nil {
+		return nil, q.fail(err, "listing packages")
+	}
+	if pkgs == nil {
+		pkgs = []models.Package{}
+	}
+	return pkgs[:min(first, len(pkgs))], nil
+}
+
+func (q *graphqlQuery) version(_ context.Context, _ any, args map[string]any) (any, error) {
+	return q.getVersion(args["package"].(string), args["version"].(string))
+}
+
+func (q *graphqlQuery) packageVersion(_ context.Context, source any, args map[string]any) (any, error) {
+	return q.getVersion(source.(*models.Package).Name, args["version"].(string))
+}
+
+// getVersion looks up a version, or nil if it does not exist or is hidden
+// from the caller.
+func (q *graphqlQuery) getVersion(pkgName, version string) (*models.Artifact, error) {
+	artifact, err := q.h.meta.GetArtifact(pkgName, version)
+	if err != nil {
+		return nil, q.fail(err, "getting artifact")
+	}
+	if artifact == nil || hidden(q.r, artifact) {
+		return nil, nil
+	}
+	return artifact, nil
+}
+
+// findVersions lists versions across packages, newest first, with the
+// filters of GET /api/v1/artifacts.
+func (q *graphqlQuery) findVersions(_ context.Context, _ any, args map[string]any) (any, error) {
+	first, err := graphqlFirst(args)
+	if err != nil {
+		return nil, err
+	}
+	f := models.ArtifactFilter{Limit: first}
+	f.Package, _ = args["package"].(string)
+	f.License, _ = args["license"].(string)
+	format, _ := args["format"].(string)
+	f.Format = strings.ToLower(format)
+	after, _ := args["uploaded_after"].(string)
+	if f.UploadedAfter, err = parseFilterTime(after, "uploaded_after"); err != nil {
+		return nil, err
+	}
+	before, _ := args["uploaded_before"].(string)
+	if f.UploadedBefore, err = parseFilterTime(before, "uploaded_before"); err != nil {
+		return nil, err
+	}
+	if f.Channel, err = graphqlChannel(args); err != nil {
+		return nil, err
+	}
+	artifacts, err := q.h.meta.FindArtifacts(f)
+	if err != nil {
+		return nil, q.fail(err, "listing artifacts")
+	}
+	artifacts = visibleArtifacts(q.r, artifacts)
+	if artifacts == nil {
+		artifacts = []models.Artifact{}
+	}
+	return artifacts, nil
+}
+
+// latest is a package's newest released version in a channel, as in GET
+// /api/v1/packages/{package}.
+func (q *graphqlQuery) latest(_ context.Context, source any, args map[string]any) (any, error) {
+	channel, err := graphqlChannel(args)
+	if err != nil {
+		return nil, err
+	}
+	artifacts, err := q.artifacts(source.(*models.Package).Name)
+	if err != nil {
+		return nil, err
+	}
+	if latest := channelLatest(released(artifacts), channel); latest != "" {
+		return latest, nil
+	}
+	return nil, nil
+}
+
+// packageVersions lists a package's versions with the stage, channel and
+// sort options of GET /api/v1/packages/{package}.
+func (q *graphqlQuery) packageVersions(_ context.Context, source any, args map[string]any) (any, error) {
+	first, err := graphqlFirst(args)
+	if err != nil {
+		return nil, err
+	}
+	channel, err := graphqlChannel(args)
+	if err != nil {
+		return nil, err
+	}
+	artifacts, err := q.artifacts(source.(*models.Package).Name)
+	if err != nil {
+		return nil, err
+	}
+	artifacts, ok := filterStage(inChannel(artifacts, channel), args["stage"].(string))
+	if !ok {
+		return nil, errors.New("stage must be staging, release or all")
+	}
+	switch args["sort"] {
+	case sortUploaded:
+	case sortSemver:
+		sortBySemver(artifacts)
+	default:
+		return nil, errors.New("sort must be uploaded or semver")
+	}
+	return artifacts[:min(first, len(artifacts))], nil
+}
+
+// files lists a version's files, its default file first.
+func (q *graphqlQuery) files(_ context.Context, source any, _ map[string]any) (any, error) {
+	files, err := q.h.versionFiles(source.(*models.Artifact))
+	if err != nil {
+		return nil, q.fail(err, "listing assets")
+	}
+	return files, nil
+}
+
+func (q *graphqlQuery) dependencies(_ context.Context, source any, _ map[string]any) (any, error) {
+	deps, err := q.resolver("").dependencies(source.(*models.Artifact))
+	if err != nil {
+		return nil, q.fail(err, "listing dependencies")
+	}
+	if deps == nil {
+		deps = []models.Dependency{}
+	}
+	return deps, nil
+}
+
+// resolved is the highest version meeting a dependency's own constraint,
+// or null if none does. Unlike ?resolve=true on the dependencies endpoint,
+// it does not weigh the constraints of the rest of the tree.
+func (q *graphqlQuery) resolved(_ context.Context, source any, args map[string]any) (any, error) {
+	channel, err := graphqlChannel(args)
+	if err != nil {
+		return nil, err
+	}
+	dep := source.(*models.Dependency)
+	c, err := semver.ParseConstraint(dep.Constraint)
+	if err != nil {
+		return nil, err
+	}
+	best, err := q.resolver(channel).pick(dep.Package, []requirement{{constraint: c}})
+	var unsatisfiable *unsatisfiableError
+	if errors.As(err, &unsatisfiable) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, q.fail(err, "resolving dependencies")
+	}
+	return best, nil
+}
+
+func (q *graphqlQuery) stats(_ context.Context, _ any, _ map[string]any) (any, error) {
+	if !isAdmin(q.r) {
+		return nil, errors.New("admin access required")
+	}
+	stats, err := q.h.registryStats()
+	if err != nil {
+		return nil, q.fail(err, "querying stats")
+	}
+	return stats, nil
+}
+
+func (q *graphqlQuery) packageDownloads(_ context.Context, source any, args map[string]any) (any, error) {
+	name := source.(*models.Package).Name
+	return q.downloadCount(models.ReportByPackage, name, args)
+}
+
+func (q *graphqlQuery) versionDownloads(_ context.Context, source any, args map[string]any) (any, error) {
+	a := source.(*models.Artifact)
+	return q.downloadCount(models.ReportByVersion, a.Package+"@"+a.Version, args)
+}
+
+// downloadCount totals the downloads of a package or version over the last
+// ?days= days, up to today. Totals are read once per grouping and period,
+// for every package at once.
+func (q *graphqlQuery) downloadCount(groupBy, key string, args map[string]any) (any, error) {
+	if !isAdmin(q.r) {
+		return nil, errors.New("admin access required")
+	}
+	days := args["days"].(int64)
+	if days < 1 || days > 366 {
+		return nil, errors.New("days must be between 1 and 366")
+	}
+	period := fmt.Sprintf("%s/%d", groupBy, days)
+	totals, ok := q.downloads[period]
+	if !ok {
+		to := q.h.clock.Now()
+		from := to.AddDate(0, 0, 1-int(days))
+		rows, err := q.h.meta.DownloadStats(from.Format(time.DateOnly), to.Format(time.DateOnly), []string{groupBy})
+		if err != nil {
+			return nil, q.fail(err, "querying download stats")
+		}
+		totals = make(map[string]int64, len(rows))
+		for _, row := range rows {
+			k := row.Package
+			if groupBy == models.ReportByVersion {
+				k += "@" + row.Version
+			}
+			totals[k] += row.Downloads
+		}
+		q.downloads[period] = totals
+	}
+	return totals[key], nil
+}
+
+// graphqlFirst reads the first argument, bounded like ?limit=.
+func graphqlFirst(args map[string]any) (int, error) {
+	first := args["first"].(int64)
+	if first < 1 || first > maxPageSize {
+		return 0, fmt.Errorf("first must be between 1 and %d", maxPageSize)
+	}
+	return int(first), nil
+}
+
+// graphqlChannel reads the channel argument, checked like ?channel=.
+func graphqlChannel(args map[string]any) (string, error) {
+	channel, _ := args["channel"].(string)
+	channel = strings.ToLower(channel)
+	if channel != "" && models.ChannelStabilities(channel) == nil {
+		return "", fmt.Errorf("channel must be one of %s", channelsHelp)
+	}
+	return channel, nil
+}
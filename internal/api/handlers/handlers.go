@@ -1,23 +1,32 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/netip"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 
+	"github.com/foundry/registry/internal/adapters/auth"
+	"github.com/foundry/registry/internal/adapters/cdn"
+	"github.com/foundry/registry/internal/adapters/transcode"
 	"github.com/foundry/registry/internal/core/models"
 	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/clock"
+	"github.com/foundry/registry/internal/util/hashing"
 	"github.com/foundry/registry/internal/util/logging"
+	"github.com/foundry/registry/internal/util/naming"
 )
 
 // Handler holds all HTTP handlers and their dependencies.
@@ -28,41 +37,323 @@ type Handler struct {
 	logger      zerolog.Logger
 	locksMu     sync.Mutex
 	uploadLocks map[string]*artifactLock
+	blobLocks   map[string]*blobLock
+	transcodes  *transcode.Cache
+	redirect    redirectPolicy
+	limits      *transferLimiter
+	tokens      services.TokenStore
+	// tokenUsage totals what issued tokens are used for; see
+	// FlushTokenUsage.
+	tokenUsage *tokenUsage
+	crates     services.CrateIndex
+	// accounts keeps users, teams and package owners; see WithAccounts.
+	accounts services.AccountStore
+	// approvals holds destructive operations for a second admin; see
+	// WithApprovals.
+	approvals *approvals
+	// comments keeps notes on packages and versions; see WithComments.
+	comments services.CommentStore
+	// blockSeverity is the scan finding severity at or above which
+	// downloads are refused; empty allows all.
+	blockSeverity string
+	// scanners are the token names besides admins that may attach scan
+	// reports; see WithScanners.
+	scanners     []string
+	policy       services.PolicyEngine
+	hooks        []services.Hook
+	malware      services.MalwareScanner
+	blake3       bool
+	quarantine   bool
+	defaultStage string
+	basePath     string
+	// trustedProxies are the networks whose forwarding headers are
+	// believed.
+	trustedProxies []netip.Prefix
+	// trustedSockets are the Unix sockets whose peers are trusted
+	// proxies.
+	trustedSockets []string
+	// requireIfMatch refuses version changes that do not name the
+	// revision they expect.
+	requireIfMatch bool
+	gc             *gcRunner
+	// reclaim is when released blobs are deleted; see WithBlobReclaim.
+	reclaim string
+	// downloadLease bounds how long a download keeps its blob; see
+	// WithDownloadLease.
+	downloadLease time.Duration
+	tiers         services.TieredStorage
+	tiering       TieringPolicy
+	// trustedBuilders verify attestations; see WithTrustedBuilders.
+	trustedBuilders []TrustedBuilder
+	requireVerified bool
+	// subscriptions and notifier deliver notifications; see
+	// WithNotifications.
+	subscriptions services.SubscriptionStore
+	notifier      services.Notifier
+	// storageReserve is the free space uploads must leave; see
+	// WithStorageReserve.
+	storageReserve int64
+	cache          cachePolicy
+	// cdn signs download redirects to the CDN; see WithCDN.
+	cdn *cdn.Signer
+	// federation is who searches fan out to; see WithFederation.
+	federation federation
+	// naming holds uploads to the naming rules; see WithNaming.
+	naming naming.Rules
+	// inflight is what the logging middleware is serving, for
+	// ListInflightRequests.
+	inflight *inflightRequests
+	// idempotentUploads accepts repeated uploads of a version's content;
+	// see WithIdempotentUploads.
+	idempotentUploads bool
+	// uploads are the uploads clients named to poll; see GetUpload.
+	uploads *uploadTracker
+	// importDirs are where ImportArtifact may read files from; none
+	// disables it.
+	importDirs []string
+	// signed verifies requests signed with a shared secret; see
+	// WithSignedRequests.
+	signed *auth.SignedRequests
+	// lockouts refuses clients that fail authentication too often; see
+	// WithLockouts.
+	lockouts *auth.Lockouts
+	// clock tells the time for expiry, leases and recorded timestamps; see
+	// WithClock.
+	clock clock.Clock
+}
+
+type redirectPolicy struct {
+	byDefault bool
+	ttl       time.Duration
+}
+
+// Option configures optional Handler behavior.
+type Option func(*Handler)
+
+// WithTranscodeCache enables on-the-fly archive format conversion on
+// download, caching converted copies in c.
+func WithTranscodeCache(c *transcode.Cache) Option {
+	return func(h *Handler) {
+		h.transcodes = c
+	}
+}
+
+// WithDownloadRedirects controls 307 redirects to signed blob URLs when the
+// blob storage implements services.URLSigner. byDefault applies when the
+// request has no ?redirect= parameter; ttl bounds the signed URL lifetime.
+func WithDownloadRedirects(byDefault bool, ttl time.Duration) Option {
+	return func(h *Handler) {
+		h.redirect = redirectPolicy{byDefault: byDefault, ttl: ttl}
+	}
+}
+
+// WithClock sets the clock that upload expiry, download leases and
+// recorded timestamps are read from, clock.System by default. Durations
+// of requests and temp file ages are measured by the system clock
+// regardless.
+func WithClock(c clock.Clock) Option {
+	return func(h *Handler) {
+		h.clock = c
+	}
 }
 
 // New creates a new Handler with the given dependencies.
-func New(blobs services.BlobStorage, meta services.MetadataStore, auth services.Authenticator, logger zerolog.Logger) *Handler {
-	return &Handler{
-		blobs:       blobs,
-		meta:        meta,
-		auth:        auth,
-		logger:      logger,
-		uploadLocks: make(map[string]*artifactLock),
+func New(blobs services.BlobStorage, meta services.MetadataStore, auth services.Authenticator, logger zerolog.Logger, opts ...Option) *Handler {
+	h := &Handler{
+		blobs:         blobs,
+		meta:          meta,
+		auth:          auth,
+		logger:        logger,
+		uploadLocks:   make(map[string]*artifactLock),
+		blobLocks:     make(map[string]*blobLock),
+		redirect:      redirectPolicy{ttl: defaultRedirectTTL},
+		cache:         cachePolicy{downloadMaxAge: defaultDownloadMaxAge, listingMaxAge: defaultListingMaxAge},
+		gc:            newGCRunner(),
+		downloadLease: defaultDownloadLease,
+		federation:    federation{name: defaultRegistryName, timeout: defaultFederationTimeout},
+		inflight:      newInflightRequests(),
+		uploads:       newUploadTracker(),
+		clock:         clock.System,
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
+// Route sets choose what a router serves: every route, the package APIs
+// without the admin routes, or only the admin routes.
+const (
+	RoutesAll    = "all"
+	RoutesPublic = "public"
+	RoutesAdmin  = "admin"
+)
+
 // Router returns the chi router with all routes.
 func (h *Handler) Router() http.Handler {
+	return h.RouterFor(RoutesAll)
+}
+
+// RouterFor returns a chi router serving one of the route sets, so the
+// admin API can listen on a separate address from the package APIs.
+func (h *Handler) RouterFor(routes string) http.Handler {
 	r := chi.NewRouter()
+	r.Use(noStoreMiddleware)
 	r.Use(h.requestIDMiddleware)
+	r.Use(h.clientIPMiddleware)
 	r.Use(h.loggingMiddleware)
-	r.Use(h.authMiddleware)
 
+	// The CDN fetches blobs with signed URLs instead of tokens.
+	if h.cdn != nil && routes != RoutesAdmin {
+		r.Get(cdn.BlobPath("{hash}"), h.CDNBlob)
+	}
+	r.Group(func(r chi.Router) {
+		r.Use(h.authMiddleware)
+		if routes != RoutesAdmin {
+			h.publicRoutes(r)
+		}
+		if routes != RoutesPublic {
+			r.Group(func(r chi.Router) {
+				r.Use(h.adminMiddleware)
+				h.adminRoutes(r)
+			})
+		}
+	})
+
+	r.NotFound(h.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusNotFound, "route not found")
+	})).ServeHTTP)
+	r.MethodNotAllowed(h.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	})).ServeHTTP)
+
+	if h.basePath == "" {
+		return r
+	}
+	root := chi.NewRouter()
+	root.Mount(h.basePath, r)
+	root.NotFound(func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusNotFound, "route not found")
+	})
+	return root
+}
+
+// publicRoutes registers the package APIs.
+func (h *Handler) publicRoutes(r chi.Router) {
 	r.Post("/api/v1/artifacts/{package}/{version}", h.UploadArtifact)
+	r.Get("/api/v1/uploads/{id}", h.GetUpload)
+	r.Post("/api/v1/artifacts/{package}/{version}/link", h.LinkArtifact)
+	r.Post("/api/v1/artifacts/{package}/{version}/copy", h.CopyArtifact)
 	r.Get("/api/v1/artifacts/{package}/{version}", h.DownloadArtifact)
+	r.Head("/api/v1/artifacts/{package}/{version}", h.HeadArtifact)
 	r.Get("/api/v1/packages", h.ListPackages)
+	r.Get("/api/v1/search", h.Search)
+	r.Get("/api/v1/artifacts", h.ListArtifacts)
 	r.Get("/api/v1/packages/{package}", h.GetPackage)
+	r.Get("/api/v1/packages/{package}/dependents", h.ListDependents)
+	r.Get("/api/v1/packages/{package}/history", h.GetHistory)
+	r.Get("/api/v1/packages/{package}/diff", h.DiffVersions)
+	r.Get("/api/v1/packages/{package}/owners", h.GetPackageOwners)
+	r.Get("/api/v1/packages/{package}/comments", h.ListComments)
+	r.Post("/api/v1/packages/{package}/comments", h.AddComment)
+	r.Delete("/api/v1/packages/{package}/comments/{id}", h.DeleteComment)
+	r.Put("/api/v1/packages/{package}/deprecation", h.DeprecatePackage)
+	r.Delete("/api/v1/packages/{package}/deprecation", h.UndeprecatePackage)
+	r.Post("/api/v1/archive", h.DownloadArchive)
+	r.Post("/api/v1/artifacts/batch-get", h.BatchGetArtifacts)
+	r.Post("/api/v1/artifacts/batch-delete", h.BatchDeleteArtifacts)
 	r.Delete("/api/v1/artifacts/{package}/{version}", h.DeleteArtifact)
-	r.Post("/api/v1/gc", h.GarbageCollect)
+	r.Get("/api/v1/artifacts/{package}/{version}/contents", h.GetContents)
+	r.Get("/api/v1/artifacts/{package}/{version}/contents/*", h.ExtractContent)
+	r.Get("/api/v1/artifacts/{package}/{version}/files", h.ListFiles)
+	r.Post("/api/v1/artifacts/{package}/{version}/files/{name}", h.UploadFile)
+	r.Get("/api/v1/artifacts/{package}/{version}/files/{name}", h.DownloadFile)
+	r.Head("/api/v1/artifacts/{package}/{version}/files/{name}", h.HeadFile)
+	r.Delete("/api/v1/artifacts/{package}/{version}/files/{name}", h.DeleteFile)
+	r.Get("/api/v1/artifacts/{package}/{version}/files/{name}/contents", h.GetFileContents)
+	r.Get("/api/v1/artifacts/{package}/{version}/files/{name}/contents/*", h.ExtractFileContent)
+	r.Get("/api/v1/artifacts/{package}/{version}/dependencies", h.GetDependencies)
+	r.Put("/api/v1/artifacts/{package}/{version}/dependencies", h.SetDependencies)
+	r.Get("/api/v1/artifacts/{package}/{version}/sbom", h.GetSBOM)
+	r.Put("/api/v1/artifacts/{package}/{version}/sbom", h.SetSBOM)
+	r.Get("/api/v1/artifacts/{package}/{version}/scan", h.GetScanReport)
+	r.Put("/api/v1/artifacts/{package}/{version}/scan", h.SetScanReport)
+	r.Get("/api/v1/artifacts/{package}/{version}/attestations", h.ListAttestations)
+	r.Post("/api/v1/artifacts/{package}/{version}/attestations", h.AddAttestation)
+	r.Get("/api/v1/artifacts/{package}/{version}/attestations/{id}", h.GetAttestation)
+	r.Get("/api/v1/artifacts/{package}/{version}/checksums.txt", h.GetChecksums)
+	r.Put("/api/v1/artifacts/{package}/{version}/pin", h.PinArtifact)
+	r.Put("/api/v1/artifacts/{package}/{version}/deprecation", h.DeprecateArtifact)
+	r.Delete("/api/v1/artifacts/{package}/{version}/deprecation", h.UndeprecateArtifact)
+	r.Get("/api/v1/artifacts/{package}/{version}/notes", h.GetReleaseNotes)
+	r.Put("/api/v1/artifacts/{package}/{version}/notes", h.SetReleaseNotes)
+	r.Delete("/api/v1/artifacts/{package}/{version}/notes", h.DeleteReleaseNotes)
+	r.Get("/api/v1/subscriptions", h.ListSubscriptions)
+	r.Post("/api/v1/subscriptions", h.CreateSubscription)
+	r.Delete("/api/v1/subscriptions/{id}", h.DeleteSubscription)
 
-	r.NotFound(func(w http.ResponseWriter, _ *http.Request) {
-		writeError(w, http.StatusNotFound, "route not found")
-	})
-	r.MethodNotAllowed(func(w http.ResponseWriter, _ *http.Request) {
-		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
-	})
+	r.Get("/graphql", h.GraphQL)
+	r.Post("/graphql", h.GraphQL)
+
+	r.Post("/pypi", h.PyPIUpload)
+	r.Post("/pypi/", h.PyPIUpload)
+	r.Get("/pypi/simple", h.PyPISimpleIndex)
+	r.Get("/pypi/simple/", h.PyPISimpleIndex)
+	r.Get("/pypi/simple/{project}", h.PyPISimpleProject)
+	r.Get("/pypi/simple/{project}/", h.PyPISimpleProject)
+
+	r.Get("/maven2/*", h.MavenGet)
+	r.Head("/maven2/*", h.MavenGet)
+	r.Put("/maven2/*", h.MavenPut)
 
-	return r
+	r.Get("/cargo/index/*", h.CargoIndex)
+	r.Put("/cargo/api/v1/crates/new", h.CargoPublish)
+	r.Get("/cargo/api/v1/crates/{crate}/{version}/download", h.CargoDownload)
+	r.Delete("/cargo/api/v1/crates/{crate}/{version}/yank", h.CargoYank)
+	r.Put("/cargo/api/v1/crates/{crate}/{version}/unyank", h.CargoUnyank)
+
+	r.Get("/feeds/releases.atom", h.ReleasesFeed)
+	r.Get("/feeds/packages/{file}", h.PackageFeed)
+}
+
+// adminRoutes registers the routes that require an admin token.
+func (h *Handler) adminRoutes(r chi.Router) {
+	r.Post("/api/v1/gc", h.GarbageCollect)
+	r.Get("/api/v1/admin/jobs", h.ListJobs)
+	r.Get("/api/v1/admin/jobs/{id}", h.GetJob)
+	r.Delete("/api/v1/admin/jobs/{id}", h.CancelJob)
+	r.Get("/api/v1/admin/stats", h.Stats)
+	r.Get("/api/v1/admin/usage", h.Usage)
+	r.Get("/api/v1/admin/metrics/queries", h.QueryMetrics)
+	r.Get("/api/v1/admin/metrics/storage", h.StorageMetrics)
+	r.Get("/api/v1/admin/metrics/auth", h.AuthMetrics)
+	r.Post("/api/v1/admin/import/{package}/{version}", h.ImportArtifact)
+	r.Get("/api/v1/admin/requests", h.ListInflightRequests)
+	r.Get("/api/v1/admin/reports/downloads", h.DownloadReport)
+	r.Get("/api/v1/admin/tokens", h.ListTokens)
+	r.Post("/api/v1/admin/tokens", h.CreateToken)
+	r.Delete("/api/v1/admin/tokens/{id}", h.RevokeToken)
+	r.Get("/api/v1/admin/tokens/{id}/usage", h.GetTokenUsage)
+	r.Get("/api/v1/admin/users", h.ListUsers)
+	r.Post("/api/v1/admin/users", h.CreateUser)
+	r.Delete("/api/v1/admin/users/{user}", h.DeleteUser)
+	r.Get("/api/v1/admin/teams", h.ListTeams)
+	r.Post("/api/v1/admin/teams", h.CreateTeam)
+	r.Get("/api/v1/admin/teams/{team}", h.GetTeam)
+	r.Delete("/api/v1/admin/teams/{team}", h.DeleteTeam)
+	r.Put("/api/v1/admin/teams/{team}/members/{user}", h.AddTeamMember)
+	r.Delete("/api/v1/admin/teams/{team}/members/{user}", h.RemoveTeamMember)
+	r.Put("/api/v1/admin/packages/{package}/owners/{team}", h.AddPackageOwner)
+	r.Delete("/api/v1/admin/packages/{package}/owners/{team}", h.RemovePackageOwner)
+	r.Get("/api/v1/admin/approvals", h.ListApprovals)
+	r.Get("/api/v1/admin/approvals/{id}", h.GetApproval)
+	r.Post("/api/v1/admin/approvals/{id}/approve", h.ApproveApproval)
+	r.Post("/api/v1/admin/approvals/{id}/reject", h.RejectApproval)
+	r.Get("/api/v1/admin/quarantine", h.ListQuarantined)
+	r.Get("/api/v1/admin/naming/violations", h.ListNamingViolations)
+	r.Post("/api/v1/artifacts/{package}/{version}/approve", h.ApproveArtifact)
+	r.Post("/api/v1/artifacts/{package}/{version}/promote", h.PromoteArtifact)
+	r.Delete("/api/v1/artifacts/{package}/{version}/pin", h.UnpinArtifact)
 }
 
 // requestIDMiddleware adds a unique request ID to each request.
@@ -75,66 +366,123 @@ func (h *Handler) requestIDMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// loggingMiddleware logs each request.
+// loggingMiddleware logs each request, and lists it as in flight until it
+// finishes.
 func (h *Handler) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
-		next.ServeHTTP(rw, r)
-		logging.LogRequest(h.logger, r.Context(), r.Method, r.URL.Path, rw.status, rw.written, time.Since(start))
+		req, done := h.inflight.track(r, rw, start)
+		defer done()
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), inflightKey{}, req)))
+		logging.LogRequest(h.logger, r.Context(), r.Method, r.URL.Path, rw.status, rw.written.Load(), time.Since(start))
 	})
 }
 
-// authMiddleware validates the bearer token.
+// authMiddleware validates the bearer token. HTTP Basic credentials are
+// also accepted with the token as the password, since package manager
+// clients such as pip and twine only send Basic auth, and so are signed
+// requests when enabled. The use of issued tokens is totalled once the
+// request is served. Clients locked out after failing too often are
+// refused before their credentials are looked at.
 func (h *Handler) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serve := func(r *http.Request, principal *models.Principal) {
+			next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), principal)))
+			h.recordTokenUsage(r, principal)
+		}
+		if !h.checkLockout(w, clientLockoutKey(r)) {
+			return
+		}
 		header := strings.TrimSpace(r.Header.Get("Authorization"))
-		if !strings.HasPrefix(header, "Bearer ") {
+		if h.signed != nil && strings.HasPrefix(header, auth.SignatureScheme+" ") {
+			principal, r, ok := h.verifySigned(w, r, header)
+			if ok {
+				serve(r, principal)
+			}
+			return
+		}
+		var token string
+		if strings.HasPrefix(header, "Bearer ") {
+			token = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		} else if _, password, ok := r.BasicAuth(); ok {
+			token = password
+		} else if header != "" && !strings.Contains(header, " ") {
+			// Cargo sends the bare token without a scheme.
+			token = header
+		} else {
+			w.Header().Set("WWW-Authenticate", `Basic realm="foundry"`)
 			writeError(w, http.StatusUnauthorized, "missing or invalid authorization header")
 			return
 		}
-		token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
-		if !h.auth.ValidateToken(token) {
+		principal, ok := h.auth.Authenticate(token)
+		if !ok {
+			h.authFailed(r, "invalid token", clientLockoutKey(r))
+			w.Header().Set("WWW-Authenticate", `Basic realm="foundry"`)
 			writeError(w, http.StatusUnauthorized, "invalid token")
 			return
 		}
-		next.ServeHTTP(w, r)
+		serve(r, principal)
 	})
 }
 
 // UploadArtifact handles POST /api/v1/artifacts/{package}/{version}
 func (h *Handler) UploadArtifact(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	pkgName := chi.URLParam(r, "package")
+	pkgName := h.naming.Normalize(chi.URLParam(r, "package"))
 	version := chi.URLParam(r, "version")
 
 	if pkgName == "" || version == "" {
 		writeError(w, http.StatusBadRequest, "package and version are required")
 		return
 	}
+	if !h.checkNaming(w, r, pkgName, version) {
+		return
+	}
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionUpload, Package: pkgName, Version: version}) {
+		return
+	}
+	opts, ok := h.uploadVersionOptions(w, r)
+	if !ok {
+		return
+	}
+	w, r, finish, ok := h.trackUpload(w, r, pkgName, version, "")
+	if !ok {
+		return
+	}
+	defer finish()
+
+	release, ok := h.limitUpload(w, r)
+	if !ok {
+		return
+	}
+	defer release()
 
 	unlock := h.lockArtifactUpload(pkgName, version)
 	defer unlock()
 
-	// Check for existing artifact.
-	existing, err := h.meta.GetArtifact(pkgName, version)
-	if err != nil {
-		h.logger.Error().Err(err).Msg("checking existing artifact")
-		writeError(w, http.StatusInternalServerError, "internal error")
+	existing, ok := h.uploadTarget(w, r, pkgName, version)
+	if !ok {
 		return
 	}
-	if existing != nil {
-		writeError(w, http.StatusConflict, fmt.Sprintf("artifact %s@%s already exists", pkgName, version))
+
+	file, err := uploadBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Stream the upload to blob storage.
-	hash, size, err := h.blobs.Store(r.Body)
+	// Stream the upload to blob storage, holding it back until its
+	// metadata is recorded so a failed upload leaves nothing behind.
+	staged, err := h.stage(r.Context(), pkgName, file.body)
 	if err != nil {
-		h.logger.Error().Err(err).Msg("storing blob")
-		writeError(w, http.StatusInternalServerError, "failed to store artifact")
+		status, msg := h.storeFailure(err)
+		writeError(w, status, msg)
 		return
 	}
+	defer staged.Discard()
+	hash, size := staged.Hash(), staged.Size()
+	setUploadState(r, models.UploadVerifying)
 
 	h.logger.Info().
 		Str("request_id", logging.RequestID(r.Context())).
@@ -142,43 +490,251 @@ func (h *Handler) UploadArtifact(w http.ResponseWriter, r *http.Request) {
 		Str("version", version).
 		Str("hash", hash).
 		Int64("size", size).
-		Msg("blob stored")
+		Msg("blob staged")
+
+	meta, err := file.metadata()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	// Store metadata.
-	pkgID, err := h.meta.CreatePackage(pkgName)
+	// A client-supplied digest guards against corruption in transit. It
+	// may come in X-Artifact-Hash or the metadata part.
+	digests := []string{r.Header.Get("X-Artifact-Hash")}
+	if meta != nil {
+		digests = append(digests, meta.Hash)
+	}
+	for _, expected := range digests {
+		if expected != "" && expected != hash {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("digest mismatch: expected %s, got %s", expected, hash))
+			return
+		}
+	}
+
+	in := models.ArtifactInput{
+		Version:     version,
+		Hash:        hash,
+		Size:        size,
+		Filename:    file.filename,
+		ContentType: file.contentType,
+		Stage:       opts.stage,
+		Stability:   opts.stability,
+		ExpiresAt:   opts.expiresAt,
+	}
+	if err := applyMetadata(&in, pkgName, meta); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if existing != nil {
+		h.repeatUpload(w, r, existing, hash)
+		return
+	}
+	h.recordArtifact(w, r, pkgName, in, staged, start)
+}
+
+// LinkArtifact handles POST /api/v1/artifacts/{package}/{version}/link,
+// publishing a version from a blob the server already stores so clients
+// copying between registries can skip re-sending bytes.
+func (h *Handler) LinkArtifact(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	pkgName := h.naming.Normalize(chi.URLParam(r, "package"))
+	version := chi.URLParam(r, "version")
+
+	var req models.LinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !hashing.IsSHA256(req.Hash) {
+		writeError(w, http.StatusBadRequest, "hash must be a hex-encoded sha256 digest")
+		return
+	}
+	if !h.checkNaming(w, r, pkgName, version) {
+		return
+	}
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionUpload, Package: pkgName, Version: version}) {
+		return
+	}
+	opts, ok := h.uploadVersionOptions(w, r)
+	if !ok {
+		return
+	}
+
+	unlock := h.lockArtifactUpload(pkgName, version)
+	defer unlock()
+
+	existing, ok := h.uploadTarget(w, r, pkgName, version)
+	if !ok {
+		return
+	}
+	if existing != nil {
+		h.repeatUpload(w, r, existing, req.Hash)
+		return
+	}
+
+	releaseBlob := h.holdBlob(req.Hash)
+	defer releaseBlob()
+	size, err := h.blobs.Size(req.Hash)
 	if err != nil {
-		h.logger.Error().Err(err).Msg("creating package")
-		writeError(w, http.StatusInternalServerError, "failed to create package")
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("blob %s not found", req.Hash))
+			return
+		}
+		h.logger.Error().Err(err).Str("hash", req.Hash).Msg("reading blob size")
+		writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
+	if err := h.placeBlob(pkgName, req.Hash); err != nil {
+		status, msg := h.storeFailure(err)
+		writeError(w, status, msg)
+		return
+	}
+
+	h.recordArtifact(w, r, pkgName, models.ArtifactInput{
+		Version:     version,
+		Hash:        req.Hash,
+		Size:        size,
+		Filename:    sanitizeFilename(req.Filename),
+		ContentType: normalizeContentType(req.ContentType),
+		Stage:       opts.stage,
+		Stability:   opts.stability,
+		ExpiresAt:   opts.expiresAt,
+	}, nil, start)
+}
+
+// versionOptions holds the settings a version starts with when an upload
+// creates it.
+type versionOptions struct {
+	stage     string
+	stability string
+	expiresAt *time.Time
+}
+
+// uploadVersionOptions reads the settings requested by the X-Artifact-Stage,
+// X-Artifact-Stability and X-Artifact-Expires headers of r, writing a 400
+// if any is invalid.
+func (h *Handler) uploadVersionOptions(w http.ResponseWriter, r *http.Request) (versionOptions, bool) {
+	stage, ok := h.uploadStage(w, r)
+	if !ok {
+		return versionOptions{}, false
+	}
+	stability, ok := uploadStability(w, r)
+	if !ok {
+		return versionOptions{}, false
+	}
+	expiresAt, ok := uploadExpiry(w, r, h.clock.Now())
+	if !ok {
+		return versionOptions{}, false
+	}
+	return versionOptions{stage: stage, stability: stability, expiresAt: expiresAt}, true
+}
 
-	artifact, err := h.meta.CreateArtifact(pkgID, version, hash, size)
+// versionAvailable writes a 409 and returns false if pkg@version exists.
+func (h *Handler) versionAvailable(w http.ResponseWriter, pkgName, version string) bool {
+	existing, err := h.meta.GetArtifact(pkgName, version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("checking existing artifact")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return false
+	}
+	if existing != nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("artifact %s@%s already exists", pkgName, version))
+		return false
+	}
+	return true
+}
+
+// recordArtifact stores metadata for a blob and writes the upload response.
+// A staged blob is committed once the metadata is recorded; if the commit
+// fails, the metadata is removed again. staged is nil for blobs already
+// in storage.
+func (h *Handler) recordArtifact(w http.ResponseWriter, r *http.Request, pkgName string, in models.ArtifactInput, staged services.StagedBlob, start time.Time) {
+	artifact, ok := h.createArtifact(w, r, pkgName, in, staged, start)
+	if !ok {
+		return
+	}
+	resp := uploadResponse(pkgName, artifact)
+	resp.Dependencies = in.Dependencies
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// createArtifact does the work of recordArtifact, leaving the response to
+// the caller once it returns the version.
+func (h *Handler) createArtifact(w http.ResponseWriter, r *http.Request, pkgName string, in models.ArtifactInput, staged services.StagedBlob, start time.Time) (*models.Artifact, bool) {
+	in.Quarantined = h.quarantine
+	event := models.HookEvent{Hook: models.HookPreUpload, Package: pkgName, Version: in.Version, File: in.Filename, Hash: in.Hash, Size: in.Size}
+	if !h.checkUpload(w, r, &event) {
+		return nil, false
+	}
+	if staged != nil {
+		defer h.holdBlob(in.Hash)()
+	}
+	setUploadState(r, models.UploadRecording)
+	artifact, err := h.meta.CreateArtifactForPackage(pkgName, in)
 	if err != nil {
 		if errors.Is(err, services.ErrConflict) {
-			writeError(w, http.StatusConflict, fmt.Sprintf("artifact %s@%s already exists", pkgName, version))
-			return
+			writeError(w, http.StatusConflict, fmt.Sprintf("artifact %s@%s already exists", pkgName, in.Version))
+			return nil, false
 		}
 		h.logger.Error().Err(err).Msg("creating artifact")
 		writeError(w, http.StatusInternalServerError, "failed to create artifact metadata")
-		return
+		return nil, false
 	}
+	if staged != nil {
+		setUploadState(r, models.UploadCommitting)
+		if err := staged.Commit(); err != nil {
+			h.logger.Error().Err(err).Msg("committing blob")
+			if err := h.meta.DeleteArtifact(pkgName, artifact.Version); err != nil {
+				h.logger.Error().Err(err).Str("package", pkgName).Str("version", artifact.Version).Msg("removing metadata of uncommitted blob")
+			}
+			writeError(w, http.StatusInternalServerError, "failed to store artifact")
+			return nil, false
+		}
+		if artifact.Tier == models.TierCold {
+			h.markBlobHot(artifact.Hash)
+			artifact.Tier = models.TierHot
+		}
+	}
+	setUploadState(r, models.UploadIndexing)
+	h.indexContents(artifact.Hash)
+	h.recordHistory(r, models.HistoryEvent{
+		Package: pkgName, Version: artifact.Version, Action: models.HistoryCreate, NewHash: artifact.Hash,
+	})
+	h.runPostUpload(r, event)
 
 	h.logger.Info().
 		Str("request_id", logging.RequestID(r.Context())).
 		Str("package", pkgName).
-		Str("version", version).
+		Str("version", artifact.Version).
 		Str("hash", artifact.Hash).
 		Int64("size", artifact.Size).
+		Str("filename", artifact.Filename).
+		Bool("quarantined", artifact.Quarantined).
+		Str("stage", artifact.Stage).
+		Str("stability", artifact.Stability).
 		Dur("upload_latency", time.Since(start)).
 		Msg("artifact upload completed")
+	return artifact, true
+}
 
-	writeJSON(w, http.StatusCreated, models.UploadResponse{
-		Package:    pkgName,
-		Version:    version,
-		Hash:       artifact.Hash,
-		Size:       artifact.Size,
-		UploadedAt: artifact.UploadedAt.Format(time.RFC3339),
-	})
+// uploadResponse describes a version of pkgName to its uploader.
+func uploadResponse(pkgName string, artifact *models.Artifact) models.UploadResponse {
+	return models.UploadResponse{
+		Package:     pkgName,
+		Version:     artifact.Version,
+		Hash:        artifact.Hash,
+		Size:        artifact.Size,
+		Filename:    artifact.Filename,
+		ContentType: artifact.ContentType,
+		UploadedAt:  artifact.UploadedAt,
+		Quarantined: artifact.Quarantined,
+		Stage:       artifact.Stage,
+		Stability:   artifact.Stability,
+		ExpiresAt:   artifact.ExpiresAt,
+		Malware:     artifact.Malware,
+		Description: artifact.Description,
+		Labels:      artifact.Labels,
+	}
 }
 
 // DownloadArtifact handles GET /api/v1/artifacts/{package}/{version}
@@ -197,6 +753,70 @@ func (h *Handler) DownloadArtifact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.serveArtifact(w, r, artifact)
+}
+
+// serveArtifact streams an artifact's file, honoring quarantine, the
+// severity block, transfer limits, transcoding, redirects and Range
+// requests.
+func (h *Handler) serveArtifact(w http.ResponseWriter, r *http.Request, artifact *models.Artifact) {
+	if hidden(r, artifact) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s is quarantined pending approval", artifact.Package, artifact.Version))
+		return
+	}
+	if reason := h.scanBlock(artifact); reason != "" {
+		h.logger.Warn().
+			Str("request_id", logging.RequestID(r.Context())).
+			Str("package", artifact.Package).
+			Str("version", artifact.Version).
+			Msg("download blocked")
+		writeError(w, http.StatusForbidden, reason)
+		return
+	}
+	if !h.checkHooks(w, r, models.HookEvent{
+		Hook: models.HookPreDownload, Package: artifact.Package, Version: artifact.Version,
+		File: downloadFilename(artifact), Hash: artifact.Hash, Size: artifact.Size,
+	}) {
+		return
+	}
+
+	w, release, ok := h.limitDownload(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+	// A version deleted mid-download leaves its blob to the next reclaim
+	// or collection.
+	defer h.leaseBlob(artifact.Hash)()
+	h.noteBlobRead(r, artifact.Hash)
+	h.setDeprecationHeaders(w, artifact)
+
+	if h.transcodes != nil && h.serveTranscoded(w, r, artifact) {
+		h.recordDownload(r, artifact, 1, artifact.Size)
+		return
+	}
+
+	if h.wantsRedirect(r) && h.redirectToSignedURL(w, r, artifact) {
+		h.recordDownload(r, artifact, 1, artifact.Size)
+		return
+	}
+
+	etag := artifactETag(artifact.Hash)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		h.setArtifactHeaders(w, artifact)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	start, end, partial, err := requestedRange(r, etag, artifact.Size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", artifact.Size))
+		writeError(w, http.StatusRequestedRangeNotSatisfiable, "requested range not satisfiable")
+		return
+	}
+	if !partial {
+		start, end = 0, artifact.Size-1
+	}
+
 	reader, err := h.blobs.Open(artifact.Hash)
 	if err != nil {
 		if errors.Is(err, services.ErrNotFound) {
@@ -209,30 +829,126 @@ func (h *Handler) DownloadArtifact(w http.ResponseWriter, r *http.Request) {
 	}
 	defer reader.Close()
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", artifact.Size))
-	w.Header().Set("X-Artifact-Hash", artifact.Hash)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s\"", pkgName, version))
-	w.WriteHeader(http.StatusOK)
-	if _, err := io.Copy(w, reader); err != nil {
+	if err := skipTo(reader, start); err != nil {
+		h.logger.Error().Err(err).Str("hash", artifact.Hash).Msg("seeking blob")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	status := http.StatusOK
+	if partial {
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, artifact.Size))
+	}
+	h.setArtifactHeaders(w, artifact)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", end-start+1))
+	w.WriteHeader(status)
+	n, err := io.CopyN(w, reader, end-start+1)
+	if err != nil {
 		h.logger.Error().
 			Err(err).
 			Str("request_id", logging.RequestID(r.Context())).
-			Str("package", pkgName).
-			Str("version", version).
+			Str("package", artifact.Package).
+			Str("version", artifact.Version).
 			Msg("streaming artifact response")
 	}
+	// Ranges past the start resume a download already counted.
+	var downloads int64
+	if start == 0 {
+		downloads = 1
+	}
+	h.recordDownload(r, artifact, downloads, n)
+}
+
+// HeadArtifact handles HEAD /api/v1/artifacts/{package}/{version}. It
+// answers with the download headers after confirming the blob is still in
+// storage at its recorded size, without reading its content.
+func (h *Handler) HeadArtifact(w http.ResponseWriter, r *http.Request) {
+	pkgName := chi.URLParam(r, "package")
+	version := chi.URLParam(r, "version")
+
+	artifact, err := h.meta.GetArtifact(pkgName, version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting artifact")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if artifact == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	h.headArtifact(w, r, artifact)
+}
+
+// headArtifact writes an artifact's download headers after checking
+// quarantine, the severity block and its blob.
+func (h *Handler) headArtifact(w http.ResponseWriter, r *http.Request, artifact *models.Artifact) {
+	if hidden(r, artifact) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if h.scanBlock(artifact) != "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	size, err := h.blobs.Size(artifact.Hash)
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		h.logger.Error().Err(err).Str("hash", artifact.Hash).Msg("checking blob size")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if size != artifact.Size {
+		h.logger.Error().
+			Str("hash", artifact.Hash).
+			Int64("recorded_size", artifact.Size).
+			Int64("stored_size", size).
+			Msg("stored blob size does not match metadata")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	h.setArtifactHeaders(w, artifact)
+	h.setDeprecationHeaders(w, artifact)
+	if etagMatches(r.Header.Get("If-None-Match"), artifactETag(artifact.Hash)) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", artifact.Size))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) setArtifactHeaders(w http.ResponseWriter, artifact *models.Artifact) {
+	w.Header().Set("Content-Type", servedContentType(artifact))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", artifactETag(artifact.Hash))
+	w.Header().Set("X-Artifact-Hash", artifact.Hash)
+	w.Header().Set("X-Artifact-Revision", strconv.FormatInt(artifact.Revision, 10))
+	w.Header().Set("Content-Disposition", contentDisposition(downloadFilename(artifact)))
+	h.setDigestHeaders(w, artifact)
+	h.cacheDownload(w)
 }
 
 // ListPackages handles GET /api/v1/packages
 func (h *Handler) ListPackages(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("search")
+	component := r.URL.Query().Get("component")
 
 	var pkgs []models.Package
 	var err error
-	if query != "" {
+	switch {
+	case component != "":
+		pkgs, err = h.meta.PackagesWithComponent(component, r.URL.Query().Get("component_version"))
+		if query != "" {
+			pkgs = filterPackagesByName(pkgs, query)
+		}
+	case query != "":
 		pkgs, err = h.meta.SearchPackages(query)
-	} else {
+	default:
 		pkgs, err = h.meta.ListPackages()
 	}
 
@@ -242,10 +958,22 @@ func (h *Handler) ListPackages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	order, err := parsePackageOrder(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	order.sort(pkgs)
+	if query := r.URL.Query(); query.Has("limit") || query.Has("cursor") {
+		var ok bool
+		if pkgs, ok = h.pagePackages(w, r, pkgs, order); !ok {
+			return
+		}
+	}
 	if pkgs == nil {
 		pkgs = []models.Package{}
 	}
-	writeJSON(w, http.StatusOK, pkgs)
+	h.writeListing(w, r, pkgs)
 }
 
 // GetPackage handles GET /api/v1/packages/{package}
@@ -263,19 +991,64 @@ func (h *Handler) GetPackage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	artifacts, err := h.meta.ListArtifacts(pkgName)
+	filter, err := parseArtifactFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Package = pkg.Name
+	artifacts, err := h.meta.FindArtifacts(filter)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("listing artifacts")
 		writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
 
+	// The latest version is the package's, whatever the filters other than
+	// the channel match.
+	all := artifacts
+	if filter != (models.ArtifactFilter{Package: pkg.Name}) {
+		if all, err = h.meta.ListArtifacts(pkg.Name); err != nil {
+			h.logger.Error().Err(err).Msg("listing artifacts")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+	}
+	latest := channelLatest(released(visibleArtifacts(r, all)), filter.Channel)
+	stage := r.URL.Query().Get("stage")
+	if stage == "" {
+		stage = models.StageRelease
+	}
+	artifacts, ok := filterStage(visibleArtifacts(r, artifacts), stage)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "stage must be staging, release or all")
+		return
+	}
+	switch r.URL.Query().Get("sort") {
+	case "", sortUploaded:
+	case sortSemver:
+		sortBySemver(artifacts)
+	default:
+		writeError(w, http.StatusBadRequest, "sort must be uploaded or semver")
+		return
+	}
 	if artifacts == nil {
 		artifacts = []models.Artifact{}
 	}
-	writeJSON(w, http.StatusOK, models.PackageInfo{
-		Name:     pkg.Name,
-		Versions: artifacts,
+	var comments []models.Comment
+	if h.comments != nil {
+		if comments, err = h.packageComments(r, pkg.Name); err != nil {
+			h.logger.Error().Err(err).Msg("listing comments")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+	}
+	h.writeListing(w, r, models.PackageInfo{
+		Name:       pkg.Name,
+		Latest:     latest,
+		Versions:   artifacts,
+		Deprecated: pkg.Deprecated,
+		Comments:   comments,
 	})
 }
 
@@ -284,60 +1057,49 @@ func (h *Handler) DeleteArtifact(w http.ResponseWriter, r *http.Request) {
 	pkgName := chi.URLParam(r, "package")
 	version := chi.URLParam(r, "version")
 
-	if err := h.meta.DeleteArtifact(pkgName, version); err != nil {
-		if errors.Is(err, services.ErrNotFound) {
-			writeError(w, http.StatusNotFound, err.Error())
-			return
-		}
-		h.logger.Error().Err(err).Msg("deleting artifact")
-		writeError(w, http.StatusInternalServerError, "internal error")
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionDelete, Package: pkgName, Version: version}) {
 		return
 	}
-
-	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
-}
-
-// GarbageCollect handles POST /api/v1/gc
-func (h *Handler) GarbageCollect(w http.ResponseWriter, r *http.Request) {
-	referenced, err := h.meta.ReferencedHashes()
-	if err != nil {
-		h.logger.Error().Err(err).Msg("getting referenced hashes")
-		writeError(w, http.StatusInternalServerError, "internal error")
+	if !h.checkHooks(w, r, models.HookEvent{Hook: models.HookPreDelete, Package: pkgName, Version: version}) {
 		return
 	}
 
-	blobs, err := h.blobs.ListBlobs()
-	if err != nil {
-		h.logger.Error().Err(err).Msg("listing blobs")
-		writeError(w, http.StatusInternalServerError, "internal error")
+	unlock := h.lockArtifactUpload(pkgName, version)
+	defer unlock()
+	if r.Header.Get("If-Match") != "" || h.requireIfMatch {
+		artifact, err := h.meta.GetArtifact(pkgName, version)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("getting artifact")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if !h.checkIfMatch(w, r, artifact) {
+			return
+		}
+	}
+	if h.approvals.protects(pkgName) {
+		h.holdDelete(w, r, pkgName, version)
 		return
 	}
-
-	var deleted int
-	var freed int64
-	for _, hash := range blobs {
-		if referenced[hash] {
-			continue
+	if err := h.deleteVersion(r, "", pkgName, version); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
 		}
-
-		path := h.blobs.BlobPath(hash)
-		info, err := os.Stat(path)
-		if err == nil {
-			freed += info.Size()
+		if errors.Is(err, services.ErrReadOnly) {
+			writeError(w, http.StatusConflict, err.Error())
+			return
 		}
-
-		if err := h.blobs.Delete(hash); err != nil {
-			h.logger.Error().Err(err).Str("hash", hash).Msg("deleting unreferenced blob")
-			continue
+		if errors.Is(err, services.ErrConflict) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("artifact %s@%s is pinned; unpin it before deleting", pkgName, version))
+			return
 		}
-		deleted++
-		h.logger.Info().Str("hash", hash).Msg("garbage collected blob")
+		h.logger.Error().Err(err).Msg("deleting artifact")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
 	}
 
-	writeJSON(w, http.StatusOK, models.GCResult{
-		DeletedBlobs: deleted,
-		FreedBytes:   freed,
-	})
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
 
 // Helper functions
@@ -349,6 +1111,7 @@ func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 }
 
 func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Cache-Control", "no-store")
 	writeJSON(w, status, models.ErrorResponse{
 		Error:   http.StatusText(status),
 		Code:    status,
@@ -356,11 +1119,12 @@ func writeError(w http.ResponseWriter, status int, msg string) {
 	})
 }
 
-// responseWriter wraps http.ResponseWriter to capture status and bytes written.
+// responseWriter wraps http.ResponseWriter to capture status and bytes
+// written. written is read while the response is still being written.
 type responseWriter struct {
 	http.ResponseWriter
 	status  int
-	written int64
+	written atomic.Int64
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
@@ -370,10 +1134,29 @@ func (rw *responseWriter) WriteHeader(code int) {
 
 func (rw *responseWriter) Write(b []byte) (int, error) {
 	n, err := rw.ResponseWriter.Write(b)
-	rw.written += int64(n)
+	rw.written.Add(int64(n))
 	return n, err
 }
 
+// ReadFrom passes files through to the underlying writer, which sends
+// them with sendfile where the connection allows.
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := rw.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(writerOnly{rw}, src)
+	}
+	return readFromSlices(rf, src, sendSlice, func(n int64) error {
+		rw.written.Add(n)
+		return nil
+	})
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// streamed responses can be flushed.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (h *Handler) lockArtifactUpload(pkgName, version string) func() {
 	key := pkgName + "@" + version
 	h.locksMu.Lock()
@@ -1,18 +1,58 @@
 package handlers
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog"
 
 	"github.com/foundry/registry/internal/adapters/auth"
+	"github.com/foundry/registry/internal/adapters/cdn"
 	"github.com/foundry/registry/internal/adapters/metadata"
+	"github.com/foundry/registry/internal/adapters/policy"
 	"github.com/foundry/registry/internal/adapters/storage"
+	"github.com/foundry/registry/internal/adapters/transcode"
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/blake3"
+	"github.com/foundry/registry/internal/util/clock"
+	"github.com/foundry/registry/internal/util/dsse"
+	"github.com/foundry/registry/internal/util/naming"
 )
 
 func setupTestHandler(t *testing.T) (*Handler, http.Handler) {
@@ -202,6 +242,279 @@ func TestGarbageCollect(t *testing.T) {
 	}
 }
 
+func TestGarbageCollectResumesAfterTimeLimit(t *testing.T) {
+	h, router := setupTestHandler(t)
+	// Every batch of one blob overruns the limit, so each run deletes one
+	// blob and the next picks up after it.
+	h.gc.limits = GCLimits{BatchSize: 1, MaxDuration: time.Nanosecond}
+
+	for _, v := range []string{"1", "2", "3"} {
+		doRequest(t, router, "POST", "/api/v1/artifacts/app/"+v, "test-token", []byte("content "+v))
+		doRequest(t, router, "DELETE", "/api/v1/artifacts/app/"+v, "test-token", nil)
+	}
+	for run, wantPartial := range []bool{true, true, false} {
+		rr := doRequest(t, router, "POST", "/api/v1/gc", "test-token", nil)
+		var result models.GCResult
+		json.Unmarshal(rr.Body.Bytes(), &result)
+		if result.DeletedBlobs != 1 || result.Partial != wantPartial {
+			t.Errorf("run %d: expected 1 deleted blob, partial=%v, got %+v", run+1, wantPartial, result)
+		}
+	}
+	if hashes, _ := h.blobs.ListBlobs(); len(hashes) != 0 {
+		t.Errorf("expected every blob collected, %d remain", len(hashes))
+	}
+}
+
+func TestGarbageCollectJob(t *testing.T) {
+	h, router := setupTestHandler(t)
+	h.gc.limits = GCLimits{BatchSize: 1, BlobsPerSecond: 1}
+
+	for _, v := range []string{"1", "2", "3"} {
+		doRequest(t, router, "POST", "/api/v1/artifacts/app/"+v, "test-token", []byte("content "+v))
+		doRequest(t, router, "DELETE", "/api/v1/artifacts/app/"+v, "test-token", nil)
+	}
+
+	rr := doRequest(t, router, "POST", "/api/v1/gc?async=true", "test-token", nil)
+	var job models.Job
+	json.Unmarshal(rr.Body.Bytes(), &job)
+	if rr.Code != http.StatusAccepted || job.Status != models.JobRunning || job.Kind != "gc" {
+		t.Fatalf("async gc: got %d: %s", rr.Code, rr.Body.String())
+	}
+	if loc := rr.Header().Get("Location"); !strings.HasSuffix(loc, "/api/v1/admin/jobs/"+job.ID) {
+		t.Errorf("unexpected Location %q", loc)
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/gc", "test-token", nil); rr.Code != http.StatusConflict {
+		t.Errorf("gc while a job runs: expected 409, got %d", rr.Code)
+	}
+
+	// Paced at one blob a second, the job is still running; cancel it.
+	rr = doRequest(t, router, "DELETE", "/api/v1/admin/jobs/"+job.ID, "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &job)
+	if rr.Code != http.StatusOK || job.Status != models.JobCanceled || job.Result == nil || !job.Result.Partial || job.FinishedAt == nil {
+		t.Fatalf("cancel: got %d: %s", rr.Code, rr.Body.String())
+	}
+	if job.Result.DeletedBlobs >= 3 {
+		t.Errorf("canceled job should not have finished: %+v", job.Result)
+	}
+
+	// Unpaced, a new job runs to completion; following it streams one
+	// line per change, ending with the finished job.
+	h.gc.limits = GCLimits{BatchSize: 1}
+	rr = doRequest(t, router, "POST", "/api/v1/gc?async=true", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &job)
+	rr = doRequest(t, router, "GET", "/api/v1/admin/jobs/"+job.ID+"?follow=true", "test-token", nil)
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("follow: unexpected Content-Type %q", ct)
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	var last models.Job
+	json.Unmarshal([]byte(lines[len(lines)-1]), &last)
+	if last.Status != models.JobSucceeded || last.Result == nil || last.Result.Partial || last.Progress.ScannedBlobs != last.Progress.TotalBlobs {
+		t.Errorf("follow: unexpected final line %s", lines[len(lines)-1])
+	}
+	if hashes, _ := h.blobs.ListBlobs(); len(hashes) != 0 {
+		t.Errorf("expected every blob collected, %d remain", len(hashes))
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/admin/jobs", "test-token", nil)
+	var jobs []models.Job
+	json.Unmarshal(rr.Body.Bytes(), &jobs)
+	if len(jobs) != 2 || jobs[0].ID != last.ID || jobs[1].Status != models.JobCanceled {
+		t.Errorf("unexpected job list: %s", rr.Body.String())
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/admin/jobs/unknown", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown job: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestBlobReclaim(t *testing.T) {
+	h, router := setupTestHandler(t)
+	h.reclaim = ReclaimImmediate
+	blobHash := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("shared"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/2.0.0", "test-token", []byte("shared"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/2.0.0/files/notes.txt", "test-token", []byte("notes"))
+
+	// Deleting a file deletes its blob at once; a shared blob goes with its
+	// last reference.
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/app/2.0.0/files/notes.txt", "test-token", nil)
+	if h.blobs.Exists(blobHash("notes")) {
+		t.Error("deleted file's blob should be reclaimed")
+	}
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.0.0", "test-token", nil)
+	if !h.blobs.Exists(blobHash("shared")) {
+		t.Fatal("blob still referenced by 2.0.0 was deleted")
+	}
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/app/2.0.0", "test-token", nil)
+	if h.blobs.Exists(blobHash("shared")) {
+		t.Error("blob should be reclaimed with its last reference")
+	}
+
+	// Lazily, released blobs wait for ReclaimBlobs, which skips blobs an
+	// upload holds.
+	h.reclaim = ReclaimLazy
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/3.0.0", "test-token", []byte("v3"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/4.0.0", "test-token", []byte("v4"))
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/app/3.0.0", "test-token", nil)
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/app/4.0.0", "test-token", nil)
+	if !h.blobs.Exists(blobHash("v3")) {
+		t.Fatal("lazy reclaim should not delete during the request")
+	}
+	release := h.holdBlob(blobHash("v4"))
+	deleted, freed, err := h.ReclaimBlobs(context.Background())
+	if err != nil || deleted != 1 || freed != 2 {
+		t.Fatalf("ReclaimBlobs = %d, %d, %v; want 1, 2", deleted, freed, err)
+	}
+	if h.blobs.Exists(blobHash("v3")) || !h.blobs.Exists(blobHash("v4")) {
+		t.Error("expected only the unheld blob to be reclaimed")
+	}
+	release()
+	if deleted, _, _ := h.ReclaimBlobs(context.Background()); deleted != 1 || h.blobs.Exists(blobHash("v4")) {
+		t.Errorf("released hold: expected v4 to be reclaimed, deleted %d", deleted)
+	}
+}
+
+func TestStorageTiering(t *testing.T) {
+	hot, _ := storage.NewDiskBlobStorage(t.TempDir())
+	cold, _ := storage.NewDiskBlobStorage(t.TempDir())
+	tiers := storage.NewTieredBlobStorage(hot, cold)
+	base := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(base)
+	meta, err := metadata.NewSQLiteStore(t.TempDir(), metadata.WithClock(fake))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { meta.Close() })
+	h := New(tiers, meta, auth.NewTokenAuth([]string{"test-token"}), zerolog.Nop(),
+		WithTiering(tiers, TieringPolicy{IdleAfter: 10 * 24 * time.Hour}))
+	router := h.Router()
+	blobHash := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	tier := func(version string) string {
+		a, _ := meta.GetArtifact("app", version)
+		return a.Tier
+	}
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("v1"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/2.0.0", "test-token", []byte("v2"))
+
+	// Only the version nobody downloaded lately moves.
+	fake.Advance(20 * 24 * time.Hour)
+	doRequest(t, router, "GET", "/api/v1/artifacts/app/2.0.0", "test-token", nil)
+	moved, size, err := h.OffloadIdleBlobs(fake.Now())
+	if err != nil || moved != 1 || size != 2 {
+		t.Fatalf("OffloadIdleBlobs = %d, %d, %v; want 1, 2", moved, size, err)
+	}
+	if tier("1.0.0") != models.TierCold || tier("2.0.0") != models.TierHot || !cold.Exists(blobHash("v1")) {
+		t.Fatalf("unexpected tiers: 1.0.0 %s, 2.0.0 %s", tier("1.0.0"), tier("2.0.0"))
+	}
+
+	// Without restore, cold blobs are read in place.
+	rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "v1" || tier("1.0.0") != models.TierCold {
+		t.Fatalf("cold download: %d %q, tier %s", rr.Code, rr.Body.String(), tier("1.0.0"))
+	}
+	h.tiering.Restore = true
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "v1" {
+		t.Fatalf("restoring download: %d %q", rr.Code, rr.Body.String())
+	}
+	if tier("1.0.0") != models.TierHot || !hot.Exists(blobHash("v1")) || cold.Exists(blobHash("v1")) {
+		t.Errorf("download should restore the blob, tier %s", tier("1.0.0"))
+	}
+
+	// Uploading the content of a cold blob brings it back.
+	fake.Advance(20 * 24 * time.Hour)
+	if moved, _, _ := h.OffloadIdleBlobs(fake.Now()); moved != 2 {
+		t.Fatalf("expected both blobs to move, moved %d", moved)
+	}
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/app/3.0.0", "test-token", []byte("v2"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload: %d", rr.Code)
+	}
+	if tier("2.0.0") != models.TierHot || tier("3.0.0") != models.TierHot || cold.Exists(blobHash("v2")) {
+		t.Errorf("re-upload should make the blob hot: 2.0.0 %s, 3.0.0 %s", tier("2.0.0"), tier("3.0.0"))
+	}
+}
+
+func TestGarbageCollectMissingBlobs(t *testing.T) {
+	h, router := setupTestHandler(t)
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("v1"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0/files/notes.txt", "test-token", []byte("notes"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/2.0.0", "test-token", []byte("v2"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/2.0.0/files/extra.txt", "test-token", []byte("extra"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/3.0.0", "test-token", []byte("v3"))
+	doRequest(t, router, "PUT", "/api/v1/artifacts/app/3.0.0/pin", "test-token", nil)
+
+	// Lose the notes of 1.0.0, the default file of 2.0.0 and the pinned
+	// 3.0.0.
+	for _, content := range []string{"notes", "v2", "v3"} {
+		sum := sha256.Sum256([]byte(content))
+		h.blobs.Delete(hex.EncodeToString(sum[:]))
+	}
+
+	gc := func(query string) models.GCResult {
+		t.Helper()
+		rr := doRequest(t, router, "POST", "/api/v1/gc"+query, "test-token", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("gc%s: expected 200, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+		var result models.GCResult
+		json.Unmarshal(rr.Body.Bytes(), &result)
+		return result
+	}
+	result := gc("")
+	var names []string
+	for _, m := range result.Missing {
+		names = append(names, m.Version+"/"+m.File)
+	}
+	if result.MissingFiles != 3 || result.Tombstoned != 0 || strings.Join(names, ",") != "1.0.0/notes.txt,2.0.0/,3.0.0/" {
+		t.Fatalf("unexpected report: %+v", result)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/2.0.0", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("reporting should not remove metadata, download got %d", rr.Code)
+	}
+
+	// A dry run never tombstones.
+	if result := gc("?tombstone=true&dry_run=true"); result.MissingFiles != 3 || result.Tombstoned != 0 {
+		t.Errorf("dry run: unexpected result %+v", result)
+	}
+
+	result = gc("?tombstone=true")
+	// The blob of 2.0.0's surviving file is left unreferenced and goes in
+	// the same run.
+	if result.MissingFiles != 3 || result.Tombstoned != 2 || result.Missing[2].Tombstoned || result.DeletedBlobs != 1 {
+		t.Fatalf("tombstone: unexpected result %+v", result)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/files/notes.txt", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("tombstoned file: expected 404, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("intact default file: expected 200, got %d", rr.Code)
+	}
+	if a, _ := h.meta.GetArtifact("app", "2.0.0"); a != nil {
+		t.Error("version with a missing default file should be removed")
+	}
+	if a, _ := h.meta.GetArtifact("app", "3.0.0"); a == nil {
+		t.Error("pinned version should be kept")
+	}
+	events, _ := h.meta.ListHistory("app", "2.0.0", 0, 10)
+	if len(events) == 0 || events[0].Action != models.HistoryDelete {
+		t.Errorf("tombstoning should be recorded in the history: %+v", events)
+	}
+
+	if result := gc(""); result.MissingFiles != 1 || result.DeletedBlobs != 0 {
+		t.Errorf("after tombstoning: unexpected result %+v", result)
+	}
+}
+
 func TestSearchPackages(t *testing.T) {
 	_, router := setupTestHandler(t)
 
@@ -301,3 +614,5650 @@ func TestConcurrentUploadSameVersion(t *testing.T) {
 		t.Fatalf("expected one created and one conflict, got created=%d conflict=%d", created, conflict)
 	}
 }
+
+func TestUploadTimestampIsRFC3339UTC(t *testing.T) {
+	_, router := setupTestHandler(t)
+
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/mylib/1.0.0", "test-token", []byte("data"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload: expected 201, got %d", rr.Code)
+	}
+
+	var resp struct {
+		UploadedAt string `json:"uploaded_at"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	ts, err := time.Parse(time.RFC3339, resp.UploadedAt)
+	if err != nil {
+		t.Fatalf("uploaded_at %q is not RFC3339: %v", resp.UploadedAt, err)
+	}
+	if !strings.HasSuffix(resp.UploadedAt, "Z") || ts.Location() != time.UTC {
+		t.Errorf("uploaded_at %q is not in UTC", resp.UploadedAt)
+	}
+}
+
+func TestDownloadTranscodesZstdToGzip(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := storage.NewDiskBlobStorage(dir)
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	meta, err := metadata.NewSQLiteStore(dir)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { meta.Close() })
+	cache, err := transcode.NewCache(dir + "/cache")
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	router := New(blobs, meta, auth.NewTokenAuth([]string{"test-token"}), zerolog.Nop(), WithTranscodeCache(cache)).Router()
+
+	payload := bytes.Repeat([]byte("tarball bytes "), 512)
+	var compressed bytes.Buffer
+	zw, _ := zstd.NewWriter(&compressed)
+	zw.Write(payload)
+	zw.Close()
+
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/bundle/1.0.0", "test-token", compressed.Bytes())
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload: expected 201, got %d", rr.Code)
+	}
+
+	for _, attempt := range []string{"fresh", "cached"} {
+		req := httptest.NewRequest("GET", "/api/v1/artifacts/bundle/1.0.0", nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Accept", "application/gzip")
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s download: expected 200, got %d", attempt, rr.Code)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/gzip" {
+			t.Errorf("%s download: content-type = %q, want application/gzip", attempt, got)
+		}
+		gr, err := gzip.NewReader(rr.Body)
+		if err != nil {
+			t.Fatalf("%s download: gzip.NewReader: %v", attempt, err)
+		}
+		got, _ := io.ReadAll(gr)
+		if !bytes.Equal(got, payload) {
+			t.Errorf("%s download: payload mismatch after transcoding", attempt)
+		}
+	}
+
+	// Without a format preference the stored bytes are served untouched.
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/bundle/1.0.0", "test-token", nil)
+	if !bytes.Equal(rr.Body.Bytes(), compressed.Bytes()) {
+		t.Error("expected raw zstd bytes without an Accept preference")
+	}
+}
+
+// signingBlobStorage adds URL signing to a disk backend, standing in for an
+// object store.
+type signingBlobStorage struct {
+	*storage.DiskBlobStorage
+	ttl time.Duration
+}
+
+func (s *signingBlobStorage) SignedURL(hash string, ttl time.Duration) (string, error) {
+	s.ttl = ttl
+	return "https://objects.example.com/blobs/" + hash + "?sig=abc", nil
+}
+
+func TestDownloadRedirectsToSignedURL(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := storage.NewDiskBlobStorage(dir)
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	blobs := &signingBlobStorage{DiskBlobStorage: disk}
+	meta, err := metadata.NewSQLiteStore(dir)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { meta.Close() })
+	router := New(blobs, meta, auth.NewTokenAuth([]string{"test-token"}), zerolog.Nop(),
+		WithDownloadRedirects(false, time.Minute)).Router()
+
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/big/1.0.0", "test-token", []byte("payload"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload: expected 201, got %d", rr.Code)
+	}
+
+	// Redirects are opt-in per request when not enabled by default.
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/big/1.0.0", "test-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "payload" {
+		t.Fatalf("default download: expected proxied 200, got %d", rr.Code)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/big/1.0.0?redirect=true", "test-token", nil)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("redirect download: expected 307, got %d", rr.Code)
+	}
+	if loc := rr.Header().Get("Location"); !strings.HasPrefix(loc, "https://objects.example.com/blobs/") {
+		t.Errorf("Location = %q, want signed object URL", loc)
+	}
+	if blobs.ttl != time.Minute {
+		t.Errorf("signed URL ttl = %v, want %v", blobs.ttl, time.Minute)
+	}
+}
+
+func TestDownloadRedirectWithoutSignerProxies(t *testing.T) {
+	_, router := setupTestHandler(t)
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/small/1.0.0", "test-token", []byte("payload"))
+	rr := doRequest(t, router, "GET", "/api/v1/artifacts/small/1.0.0?redirect=true", "test-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "payload" {
+		t.Fatalf("expected proxied 200 when storage cannot sign URLs, got %d", rr.Code)
+	}
+}
+
+func TestUploadRejectedWhenSaturated(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := storage.NewDiskBlobStorage(dir)
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	meta, err := metadata.NewSQLiteStore(dir)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { meta.Close() })
+	h := New(blobs, meta, auth.NewTokenAuth([]string{"test-token"}), zerolog.Nop(),
+		WithTransferLimits(TransferLimits{MaxConcurrentUploads: 1, RetryAfter: 3 * time.Second}))
+	router := h.Router()
+
+	// Hold the only upload slot with a body that has not finished arriving.
+	pr, pw := io.Pipe()
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		req := httptest.NewRequest("POST", "/api/v1/artifacts/slow/1.0.0", pr)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		done <- rr
+	}()
+	pw.Write([]byte("partial"))
+
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/fast/1.0.0", "test-token", []byte("payload"))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while saturated, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("Retry-After = %q, want 3", got)
+	}
+
+	pw.Close()
+	if rr := <-done; rr.Code != http.StatusCreated {
+		t.Fatalf("slow upload: expected 201, got %d", rr.Code)
+	}
+
+	// The slot is released once the first upload completes.
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/fast/1.0.0", "test-token", []byte("payload"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 after slot freed, got %d", rr.Code)
+	}
+}
+
+func TestDownloadBandwidthLimited(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := storage.NewDiskBlobStorage(dir)
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	meta, err := metadata.NewSQLiteStore(dir)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { meta.Close() })
+	router := New(blobs, meta, auth.NewTokenAuth([]string{"test-token"}), zerolog.Nop(),
+		WithTransferLimits(TransferLimits{DownloadBytesPerSecond: 32 << 10})).Router()
+
+	content := bytes.Repeat([]byte("x"), 64<<10)
+	doRequest(t, router, "POST", "/api/v1/artifacts/paced/1.0.0", "test-token", content)
+
+	start := time.Now()
+	rr := doRequest(t, router, "GET", "/api/v1/artifacts/paced/1.0.0", "test-token", nil)
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), content) {
+		t.Fatalf("download: expected 200 with full content, got %d", rr.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 700*time.Millisecond {
+		t.Errorf("64KiB at 32KiB/s finished in %v", elapsed)
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	_, router := setupTestHandler(t)
+
+	content := []byte("0123456789abcdef")
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/ranged/1.0.0", "test-token", content)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload: expected 201, got %d", rr.Code)
+	}
+	full := doRequest(t, router, "GET", "/api/v1/artifacts/ranged/1.0.0", "test-token", nil)
+	etag := full.Header().Get("ETag")
+	if etag == "" || full.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("expected ETag and Accept-Ranges on full download, got %v", full.Header())
+	}
+
+	tests := []struct {
+		name    string
+		rng     string
+		ifRange string
+		status  int
+		body    string
+	}{
+		{"open ended", "bytes=10-", "", http.StatusPartialContent, "abcdef"},
+		{"bounded", "bytes=2-4", "", http.StatusPartialContent, "234"},
+		{"suffix", "bytes=-3", "", http.StatusPartialContent, "def"},
+		{"matching if-range", "bytes=12-", etag, http.StatusPartialContent, "cdef"},
+		{"stale if-range", "bytes=12-", `"stale"`, http.StatusOK, string(content)},
+		{"multi range ignored", "bytes=0-1,4-5", "", http.StatusOK, string(content)},
+		{"past end", "bytes=16-", "", http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/artifacts/ranged/1.0.0", nil)
+			req.Header.Set("Authorization", "Bearer test-token")
+			req.Header.Set("Range", tt.rng)
+			if tt.ifRange != "" {
+				req.Header.Set("If-Range", tt.ifRange)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.status)
+			}
+			if tt.body != "" && rr.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rr.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestUploadRejectsDigestMismatch(t *testing.T) {
+	_, router := setupTestHandler(t)
+
+	req := httptest.NewRequest("POST", "/api/v1/artifacts/checked/1.0.0", strings.NewReader("payload"))
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("X-Artifact-Hash", strings.Repeat("0", 64))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 on digest mismatch, got %d", rr.Code)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/checked/1.0.0", "test-token", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("mismatched upload should not be published, got %d", rr.Code)
+	}
+}
+
+func TestLinkArtifactFromExistingBlob(t *testing.T) {
+	_, router := setupTestHandler(t)
+
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/staging/1.0.0", "test-token", []byte("promoted bytes"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload: expected 201, got %d", rr.Code)
+	}
+	var uploaded models.UploadResponse
+	json.NewDecoder(rr.Body).Decode(&uploaded)
+
+	body, _ := json.Marshal(models.LinkRequest{Hash: uploaded.Hash})
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/prod/1.0.0/link", "test-token", body)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("link: expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var linked models.UploadResponse
+	json.NewDecoder(rr.Body).Decode(&linked)
+	if linked.Hash != uploaded.Hash || linked.Size != uploaded.Size {
+		t.Errorf("linked %+v, want hash %s size %d", linked, uploaded.Hash, uploaded.Size)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/prod/1.0.0", "test-token", nil)
+	if rr.Body.String() != "promoted bytes" {
+		t.Errorf("linked download = %q", rr.Body.String())
+	}
+
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/prod/1.0.0/link", "test-token", body)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("relink: expected 409, got %d", rr.Code)
+	}
+
+	body, _ = json.Marshal(models.LinkRequest{Hash: strings.Repeat("a", 64)})
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/prod/2.0.0/link", "test-token", body)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown blob: expected 404, got %d", rr.Code)
+	}
+
+	body, _ = json.Marshal(models.LinkRequest{Hash: "../escape"})
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/prod/3.0.0/link", "test-token", body)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid hash: expected 400, got %d", rr.Code)
+	}
+}
+
+func TestGarbageCollectDryRun(t *testing.T) {
+	_, router := setupTestHandler(t)
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/mylib/1.0.0", "test-token", []byte("gc-dry-run"))
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/mylib/1.0.0", "test-token", nil)
+
+	rr := doRequest(t, router, "POST", "/api/v1/gc?dry_run=true", "test-token", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var result models.GCResult
+	json.NewDecoder(rr.Body).Decode(&result)
+	if !result.DryRun || len(result.Candidates) != 1 || result.FreedBytes != int64(len("gc-dry-run")) {
+		t.Errorf("unexpected dry-run result: %+v", result)
+	}
+
+	// Nothing was deleted, so a real run still finds the blob.
+	rr = doRequest(t, router, "POST", "/api/v1/gc", "test-token", nil)
+	json.NewDecoder(rr.Body).Decode(&result)
+	if result.DeletedBlobs != 1 {
+		t.Errorf("expected real gc to delete 1 blob, got %d", result.DeletedBlobs)
+	}
+}
+
+func TestAdminTokenLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	blobs, _ := storage.NewDiskBlobStorage(dir)
+	meta, err := metadata.NewSQLiteStore(dir)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { meta.Close() })
+	authenticator := auth.Chain{auth.NewTokenAuth([]string{"test-token"}), auth.NewStoreAuth(meta)}
+	router := New(blobs, meta, authenticator, zerolog.Nop(), WithTokenStore(meta)).Router()
+
+	rr := doRequest(t, router, "POST", "/api/v1/admin/tokens", "test-token", []byte(`{"name":"ci"}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created models.CreateTokenResponse
+	json.NewDecoder(rr.Body).Decode(&created)
+	if !strings.HasPrefix(created.Token, auth.TokenPrefix) || created.Admin {
+		t.Fatalf("unexpected token response: %+v", created)
+	}
+
+	// The issued token works for ordinary routes but not admin ones.
+	if rr := doRequest(t, router, "GET", "/api/v1/packages", created.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected 200 with issued token, got %d", rr.Code)
+	}
+	for _, path := range []string{"/api/v1/admin/stats", "/api/v1/admin/tokens"} {
+		if rr := doRequest(t, router, "GET", path, created.Token, nil); rr.Code != http.StatusForbidden {
+			t.Errorf("GET %s: expected 403 for non-admin token, got %d", path, rr.Code)
+		}
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/gc", created.Token, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("gc: expected 403 for non-admin token, got %d", rr.Code)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/admin/stats", "test-token", nil)
+	var stats models.RegistryStats
+	json.NewDecoder(rr.Body).Decode(&stats)
+	if rr.Code != http.StatusOK || stats.ActiveTokens != 1 {
+		t.Errorf("unexpected stats (%d): %+v", rr.Code, stats)
+	}
+
+	path := "/api/v1/admin/tokens/" + strconv.FormatInt(created.ID, 10)
+	if rr := doRequest(t, router, "DELETE", path, "test-token", nil); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 revoking, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/packages", created.Token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 after revocation, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "DELETE", path, "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 revoking twice, got %d", rr.Code)
+	}
+}
+
+func TestTokenEndpointsDisabledWithoutStore(t *testing.T) {
+	_, router := setupTestHandler(t)
+
+	rr := doRequest(t, router, "GET", "/api/v1/admin/tokens", "test-token", nil)
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("expected 501, got %d", rr.Code)
+	}
+}
+
+func TestHeadArtifact(t *testing.T) {
+	h, router := setupTestHandler(t)
+
+	content := []byte("head-check")
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/mylib/1.0.0", "test-token", content)
+	var uploaded models.UploadResponse
+	json.NewDecoder(rr.Body).Decode(&uploaded)
+
+	rr = doRequest(t, router, "HEAD", "/api/v1/artifacts/mylib/1.0.0", "test-token", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("expected empty body, got %q", rr.Body.String())
+	}
+	if got := rr.Header().Get("X-Artifact-Hash"); got != uploaded.Hash {
+		t.Errorf("X-Artifact-Hash = %q, want %q", got, uploaded.Hash)
+	}
+	if got := rr.Header().Get("Content-Length"); got != strconv.Itoa(len(content)) {
+		t.Errorf("Content-Length = %q, want %d", got, len(content))
+	}
+
+	// A blob lost from storage is reported even though metadata remains.
+	h.blobs.Delete(uploaded.Hash)
+	rr = doRequest(t, router, "HEAD", "/api/v1/artifacts/mylib/1.0.0", "test-token", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for missing blob, got %d", rr.Code)
+	}
+}
+
+func TestUploadPreservesFilenameAndContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		headers     map[string]string
+		wantType    string
+		wantDispose string
+	}{
+		{
+			name:        "headers",
+			headers:     map[string]string{"X-Artifact-Filename": "tool-linux.tar.gz", "Content-Type": "application/gzip"},
+			wantType:    "application/gzip",
+			wantDispose: `attachment; filename=tool-linux.tar.gz`,
+		},
+		{
+			name:        "type inferred from extension",
+			headers:     map[string]string{"Content-Disposition": `attachment; filename="report.json"`, "Content-Type": "application/octet-stream"},
+			wantType:    "application/json",
+			wantDispose: `attachment; filename=report.json`,
+		},
+		{
+			name:        "path components stripped",
+			headers:     map[string]string{"X-Artifact-Filename": `..\..\etc\passwd`},
+			wantType:    "application/octet-stream",
+			wantDispose: `attachment; filename=passwd`,
+		},
+		{
+			name:        "no file info",
+			wantType:    "application/octet-stream",
+			wantDispose: `attachment; filename=mylib-1.0.0`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, router := setupTestHandler(t)
+
+			req := httptest.NewRequest("POST", "/api/v1/artifacts/mylib/1.0.0", strings.NewReader("payload"))
+			req.Header.Set("Authorization", "Bearer test-token")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			rr = doRequest(t, router, "GET", "/api/v1/artifacts/mylib/1.0.0", "test-token", nil)
+			if got := rr.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := rr.Header().Get("Content-Disposition"); got != tt.wantDispose {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.wantDispose)
+			}
+		})
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	_, router := setupTestHandler(t)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("comment", "ignored")
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="tool.zip"`)
+	header.Set("Content-Type", "application/zip")
+	part, _ := mw.CreatePart(header)
+	part.Write([]byte("zip-bytes"))
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/api/v1/artifacts/mylib/1.0.0", &buf)
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var uploaded models.UploadResponse
+	json.NewDecoder(rr.Body).Decode(&uploaded)
+	if uploaded.Filename != "tool.zip" || uploaded.ContentType != "application/zip" || uploaded.Size != int64(len("zip-bytes")) {
+		t.Errorf("unexpected upload response: %+v", uploaded)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/mylib/1.0.0", "test-token", nil)
+	if rr.Body.String() != "zip-bytes" {
+		t.Errorf("downloaded %q, want only the file part", rr.Body.String())
+	}
+}
+
+func TestVersionFiles(t *testing.T) {
+	_, router := setupTestHandler(t)
+	base := "/api/v1/artifacts/tool/1.0.0"
+
+	// The first file creates the version and becomes its default file.
+	if rr := doRequest(t, router, "POST", base+"/files/tool-linux.tar.gz", "test-token", []byte("linux")); rr.Code != http.StatusCreated {
+		t.Fatalf("first file: expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "POST", base+"/files/tool-windows.zip", "test-token", []byte("windows")); rr.Code != http.StatusCreated {
+		t.Fatalf("second file: expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "POST", base+"/files/tool-windows.zip", "test-token", []byte("again")); rr.Code != http.StatusConflict {
+		t.Errorf("duplicate file: expected 409, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "POST", base+"/files/..", "test-token", []byte("x")); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid name: expected 400, got %d", rr.Code)
+	}
+
+	rr := doRequest(t, router, "GET", base+"/files", "test-token", nil)
+	var files []models.Asset
+	json.NewDecoder(rr.Body).Decode(&files)
+	if len(files) != 2 || !files[0].Default || files[0].Name != "tool-linux.tar.gz" || files[1].Name != "tool-windows.zip" {
+		t.Fatalf("unexpected file list: %+v", files)
+	}
+
+	// The single-file route serves the default file.
+	if rr := doRequest(t, router, "GET", base, "test-token", nil); rr.Body.String() != "linux" {
+		t.Errorf("default download = %q, want linux", rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", base+"/files/tool-windows.zip", "test-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "windows" {
+		t.Errorf("asset download = %d %q", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/zip" {
+		t.Errorf("asset Content-Type = %q, want application/zip", got)
+	}
+
+	if rr := doRequest(t, router, "DELETE", base+"/files/tool-linux.tar.gz", "test-token", nil); rr.Code != http.StatusConflict {
+		t.Errorf("deleting default file: expected 409, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "DELETE", base+"/files/tool-windows.zip", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("deleting asset: expected 200, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", base+"/files/tool-windows.zip", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("deleted asset: expected 404, got %d", rr.Code)
+	}
+}
+
+func pypiUploadRequest(t *testing.T, name, version, filename string, content []byte) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField(":action", "file_upload")
+	mw.WriteField("name", name)
+	mw.WriteField("version", version)
+	part, _ := mw.CreateFormFile("content", filename)
+	part.Write(content)
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/pypi/", &buf)
+	req.SetBasicAuth("__token__", "test-token")
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestPyPIUploadAndSimpleIndex(t *testing.T) {
+	_, router := setupTestHandler(t)
+
+	for _, filename := range []string{"My_Lib-1.0.0-py3-none-any.whl", "My_Lib-1.0.0.tar.gz"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, pypiUploadRequest(t, "My_Lib", "1.0.0", filename, []byte(filename)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("upload %s: expected 200, got %d: %s", filename, rr.Code, rr.Body.String())
+		}
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, pypiUploadRequest(t, "my-lib", "1.0.0", "My_Lib-1.0.0.tar.gz", []byte("again")))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("re-upload: expected 409, got %d", rr.Code)
+	}
+
+	// Non-normalized project names redirect to the canonical page.
+	rr = doRequest(t, router, "GET", "/pypi/simple/My.Lib/", "test-token", nil)
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/pypi/simple/my-lib/" {
+		t.Errorf("expected redirect to /pypi/simple/my-lib/, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	rr = doRequest(t, router, "GET", "/pypi/simple/my-lib/", "test-token", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	page := rr.Body.String()
+	for _, filename := range []string{"My_Lib-1.0.0-py3-none-any.whl", "My_Lib-1.0.0.tar.gz"} {
+		sum := sha256.Sum256([]byte(filename))
+		want := "/api/v1/artifacts/my-lib/1.0.0/files/" + filename + "#sha256=" + hex.EncodeToString(sum[:])
+		if !strings.Contains(page, want) {
+			t.Errorf("simple page missing link %s:\n%s", want, page)
+		}
+	}
+
+	rr = doRequest(t, router, "GET", "/pypi/simple/", "test-token", nil)
+	if !strings.Contains(rr.Body.String(), `<a href="my-lib/">my-lib</a>`) {
+		t.Errorf("root index missing project:\n%s", rr.Body.String())
+	}
+}
+
+func TestBasicAuthUsesPasswordAsToken(t *testing.T) {
+	_, router := setupTestHandler(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/packages", nil)
+	req.SetBasicAuth("anyone", "test-token")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 with basic auth, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/packages", nil)
+	req.SetBasicAuth("test-token", "")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected 401 with challenge, got %d", rr.Code)
+	}
+}
+
+func TestBasicAuthOnProtocolRoutes(t *testing.T) {
+	_, router := setupTestHandler(t)
+
+	// Maven and helm send credentials only after an anonymous request is
+	// challenged.
+	jar := "/maven2/com/example/mylib/1.0.0/mylib-1.0.0.jar"
+	req := httptest.NewRequest("PUT", jar, strings.NewReader("jar"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || !strings.HasPrefix(rr.Header().Get("WWW-Authenticate"), "Basic ") {
+		t.Fatalf("anonymous deploy: expected 401 with a Basic challenge, got %d %q", rr.Code, rr.Header().Get("WWW-Authenticate"))
+	}
+
+	for _, c := range []struct{ method, path, user string }{
+		{"PUT", jar, "token"},
+		{"GET", jar, "deployer"},
+		{"GET", "/pypi/simple/", "__token__"},
+	} {
+		var body io.Reader
+		if c.method == "PUT" {
+			body = strings.NewReader("jar")
+		}
+		req := httptest.NewRequest(c.method, c.path, body)
+		req.SetBasicAuth(c.user, "test-token")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code >= 300 {
+			t.Errorf("%s %s as %s: got %d: %s", c.method, c.path, c.user, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestParseMavenPath(t *testing.T) {
+	p, err := parseMavenPath("com/example/mylib/1.0.0/mylib-1.0.0-sources.jar.sha1")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := mavenPath{GroupID: "com.example", ArtifactID: "mylib", Version: "1.0.0", Filename: "mylib-1.0.0-sources.jar", Checksum: ".sha1"}
+	if p != want {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+
+	p, err = parseMavenPath("com/example/mylib/maven-metadata.xml")
+	if err != nil || p.Version != "" || p.packageName() != "com.example:mylib" {
+		t.Errorf("metadata path: got %+v, %v", p, err)
+	}
+
+	for _, bad := range []string{
+		"mylib/1.0.0/mylib-1.0.0.jar",
+		"com/example/mylib/1.0.0/other-1.0.0.jar",
+		"com/../mylib/1.0.0/mylib-1.0.0.jar",
+		"com/example/mylib/1.0-SNAPSHOT/mylib-1.0-SNAPSHOT.jar",
+	} {
+		if _, err := parseMavenPath(bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestMavenDeployAndResolve(t *testing.T) {
+	_, router := setupTestHandler(t)
+	base := "/maven2/com/example/mylib"
+
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		for _, ext := range []string{".jar", ".pom"} {
+			path := base + "/" + version + "/mylib-" + version + ext
+			rr := doRequest(t, router, "PUT", path, "test-token", []byte(version+ext))
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("PUT %s: expected 201, got %d: %s", path, rr.Code, rr.Body.String())
+			}
+		}
+	}
+
+	jar := base + "/1.0.0/mylib-1.0.0.jar"
+	sum := sha1.Sum([]byte("1.0.0.jar"))
+	if rr := doRequest(t, router, "PUT", jar+".sha1", "test-token", []byte(hex.EncodeToString(sum[:]))); rr.Code != http.StatusCreated {
+		t.Errorf("matching checksum: expected 201, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "PUT", jar+".sha1", "test-token", []byte("deadbeef")); rr.Code != http.StatusBadRequest {
+		t.Errorf("mismatched checksum: expected 400, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "PUT", jar, "test-token", []byte("1.0.0.jar")); rr.Code != http.StatusOK {
+		t.Errorf("identical redeploy: expected 200, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "PUT", jar, "test-token", []byte("changed")); rr.Code != http.StatusConflict {
+		t.Errorf("changed redeploy: expected 409, got %d", rr.Code)
+	}
+
+	rr := doRequest(t, router, "GET", base+"/1.0.0/mylib-1.0.0.pom", "test-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "1.0.0.pom" {
+		t.Errorf("GET pom: got %d %q", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", jar+".sha1", "test-token", nil)
+	if rr.Body.String() != hex.EncodeToString(sum[:]) {
+		t.Errorf("GET sha1: got %q", rr.Body.String())
+	}
+
+	rr = doRequest(t, router, "GET", base+"/maven-metadata.xml", "test-token", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET metadata: expected 200, got %d", rr.Code)
+	}
+	metadata := rr.Body.String()
+	for _, want := range []string{
+		"<groupId>com.example</groupId>",
+		"<release>1.1.0</release>",
+		"<version>1.0.0</version>\n      <version>1.1.0</version>",
+	} {
+		if !strings.Contains(metadata, want) {
+			t.Errorf("metadata missing %q:\n%s", want, metadata)
+		}
+	}
+	metaSum := sha1.Sum(rr.Body.Bytes())
+	rr = doRequest(t, router, "GET", base+"/maven-metadata.xml.sha1", "test-token", nil)
+	if rr.Body.String() != hex.EncodeToString(metaSum[:]) {
+		t.Errorf("metadata sha1: got %q", rr.Body.String())
+	}
+
+	if rr := doRequest(t, router, "GET", "/maven2/com/example/other/maven-metadata.xml", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown artifact metadata: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestCargoIndexPath(t *testing.T) {
+	for name, want := range map[string]string{
+		"a":        "1/a",
+		"ab":       "2/ab",
+		"abc":      "3/a/abc",
+		"My_Crate": "my/_c/my_crate",
+	} {
+		if got := cargoIndexPath(name); got != want {
+			t.Errorf("cargoIndexPath(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// cargoPublishBody encodes a cargo publish request body.
+func cargoPublishBody(t *testing.T, meta cargoPublishMetadata, crate []byte) []byte {
+	t.Helper()
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatalf("encoding metadata: %v", err)
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(len(encoded)))
+	buf.Write(encoded)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(crate)))
+	buf.Write(crate)
+	return buf.Bytes()
+}
+
+func TestCargoPublishIndexAndYank(t *testing.T) {
+	h, router := setupTestHandler(t)
+	h.crates = h.meta.(services.CrateIndex)
+
+	renamed := "json"
+	meta := cargoPublishMetadata{
+		Name: "My_Crate",
+		Vers: "0.1.0",
+		Deps: []cargoPublishDep{
+			{Name: "serde_json", VersionReq: "^1.0", ExplicitNameInToml: &renamed, DefaultFeatures: true, Kind: "normal"},
+		},
+		Features: map[string][]string{"default": {}, "json": {"dep:json"}},
+	}
+	crate := []byte("crate archive")
+	rr := doRequest(t, router, "PUT", "/cargo/api/v1/crates/new", "test-token", cargoPublishBody(t, meta, crate))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("publish: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(t, router, "PUT", "/cargo/api/v1/crates/new", "test-token", cargoPublishBody(t, meta, crate))
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), `"errors"`) {
+		t.Errorf("republish: expected 409 with cargo errors, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(t, router, "GET", "/cargo/index/my/_c/my_crate", "test-token", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("index: expected 200, got %d", rr.Code)
+	}
+	var entry cargoIndexEntry
+	if err := json.Unmarshal(rr.Body.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding index line: %v", err)
+	}
+	sum := sha256.Sum256(crate)
+	if entry.Name != "My_Crate" || entry.Cksum != hex.EncodeToString(sum[:]) || entry.Yanked {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	if len(entry.Deps) != 1 || entry.Deps[0].Name != "json" || entry.Deps[0].Package == nil || *entry.Deps[0].Package != "serde_json" || entry.Deps[0].Req != "^1.0" {
+		t.Errorf("unexpected deps: %+v", entry.Deps)
+	}
+	if entry.V != 2 || entry.Features2["json"] == nil || entry.Features["default"] == nil {
+		t.Errorf("expected dep: feature in features2: %+v", entry)
+	}
+
+	rr = doRequest(t, router, "GET", "/cargo/api/v1/crates/My_Crate/0.1.0/download", "test-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "crate archive" {
+		t.Errorf("download: got %d %q", rr.Code, rr.Body.String())
+	}
+
+	if rr := doRequest(t, router, "DELETE", "/cargo/api/v1/crates/My_Crate/0.1.0/yank", "test-token", nil); rr.Code != http.StatusOK {
+		t.Fatalf("yank: expected 200, got %d", rr.Code)
+	}
+	rr = doRequest(t, router, "GET", "/cargo/index/my/_c/my_crate", "test-token", nil)
+	if !strings.Contains(rr.Body.String(), `"yanked":true`) {
+		t.Errorf("expected yanked entry, got %s", rr.Body.String())
+	}
+	if rr := doRequest(t, router, "PUT", "/cargo/api/v1/crates/My_Crate/9.9.9/unyank", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unyank missing: expected 404, got %d", rr.Code)
+	}
+
+	if rr := doRequest(t, router, "GET", "/cargo/index/xx/yy/my_crate", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong index path: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestCargoAcceptsBareToken(t *testing.T) {
+	h, router := setupTestHandler(t)
+	h.crates = h.meta.(services.CrateIndex)
+
+	req := httptest.NewRequest("GET", "/cargo/index/config.json", nil)
+	req.Header.Set("Authorization", "test-token")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"dl":"http://example.com/cargo/api/v1/crates"`) {
+		t.Errorf("config.json: got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+// publishWithDeps uploads pkg@version and sets its dependencies, given as
+// package/constraint pairs.
+func publishWithDeps(t *testing.T, router http.Handler, pkg, version string, deps ...string) {
+	t.Helper()
+	path := "/api/v1/artifacts/" + pkg + "/" + version
+	if rr := doRequest(t, router, "POST", path, "test-token", []byte(pkg+version)); rr.Code != http.StatusCreated {
+		t.Fatalf("upload %s@%s: %d %s", pkg, version, rr.Code, rr.Body.String())
+	}
+	var manifest models.DependencyManifest
+	for i := 0; i < len(deps); i += 2 {
+		manifest.Dependencies = append(manifest.Dependencies, models.Dependency{Package: deps[i], Constraint: deps[i+1]})
+	}
+	body, _ := json.Marshal(manifest)
+	if rr := doRequest(t, router, "PUT", path+"/dependencies", "test-token", body); rr.Code != http.StatusOK {
+		t.Fatalf("set dependencies of %s@%s: %d %s", pkg, version, rr.Code, rr.Body.String())
+	}
+}
+
+func resolveDependencies(t *testing.T, router http.Handler, pkg, version string) (*httptest.ResponseRecorder, map[string]string) {
+	t.Helper()
+	rr := doRequest(t, router, "GET", "/api/v1/artifacts/"+pkg+"/"+version+"/dependencies?resolve=true", "test-token", nil)
+	var resp models.DependenciesResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	selected := make(map[string]string)
+	for _, d := range resp.Resolved {
+		selected[d.Package] = d.Version
+	}
+	return rr, selected
+}
+
+func TestSetDependenciesValidation(t *testing.T) {
+	_, router := setupTestHandler(t)
+	publishWithDeps(t, router, "app", "1.0.0", "lib", "")
+
+	rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/dependencies", "test-token", nil)
+	var resp models.DependenciesResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Dependencies) != 1 || resp.Dependencies[0].Constraint != "*" || resp.Resolved != nil {
+		t.Errorf("expected lib with constraint *, got %+v", resp)
+	}
+
+	for _, body := range []string{
+		`{"dependencies":[{"package":"app","constraint":"1.0.0"}]}`,
+		`{"dependencies":[{"package":"lib","constraint":"^1.a"}]}`,
+		`{"dependencies":[{"package":"lib"},{"package":"lib"}]}`,
+		`not json`,
+	} {
+		if rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/dependencies", "test-token", []byte(body)); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rr.Code)
+		}
+	}
+	if rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/9.9.9/dependencies", "test-token", []byte(`{}`)); rr.Code != http.StatusNotFound {
+		t.Errorf("missing version: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestResolveDependencies(t *testing.T) {
+	_, router := setupTestHandler(t)
+	publishWithDeps(t, router, "log", "1.0.0")
+	publishWithDeps(t, router, "log", "1.3.0")
+	publishWithDeps(t, router, "log", "1.4.0")
+	publishWithDeps(t, router, "log", "2.0.0")
+	publishWithDeps(t, router, "log", "1.5.0-beta.1")
+	publishWithDeps(t, router, "http", "1.0.0", "log", "^1.0")
+	publishWithDeps(t, router, "http", "1.1.0", "log", "^1.2")
+	// db caps log below 1.4, which rules out the first pick of 1.4.0.
+	publishWithDeps(t, router, "db", "3.0.0", "log", ">=1.0, <1.4")
+	publishWithDeps(t, router, "app", "1.0.0", "http", "^1", "db", "3.x")
+
+	rr, selected := resolveDependencies(t, router, "app", "1.0.0")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("resolve: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	want := map[string]string{"http": "1.1.0", "db": "3.0.0", "log": "1.3.0"}
+	if len(selected) != len(want) {
+		t.Errorf("expected %v, got %v", want, selected)
+	}
+	for pkg, version := range want {
+		if selected[pkg] != version {
+			t.Errorf("%s: expected %s, got %s", pkg, version, selected[pkg])
+		}
+	}
+	if !strings.Contains(rr.Body.String(), `"required_by":["http@1.1.0","db@3.0.0"]`) {
+		t.Errorf("expected log to list its dependents: %s", rr.Body.String())
+	}
+
+	// http 1.1.0 wants log ^1.2; resolution does not fall back to
+	// http 1.0.0 to satisfy a conflicting cap.
+	publishWithDeps(t, router, "strict", "1.0.0", "http", "1.1.0", "log", "<1.2")
+	rr, _ = resolveDependencies(t, router, "strict", "1.0.0")
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "no version of log satisfies") {
+		t.Errorf("expected 409 naming log, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	publishWithDeps(t, router, "broken", "1.0.0", "missing", "^1")
+	rr, _ = resolveDependencies(t, router, "broken", "1.0.0")
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "does not exist") {
+		t.Errorf("expected 409 for missing package, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestListDependentsPaginates(t *testing.T) {
+	_, router := setupTestHandler(t)
+	publishWithDeps(t, router, "core", "1.0.0")
+	publishWithDeps(t, router, "web", "1.0.0", "core", "^1")
+	publishWithDeps(t, router, "web", "1.1.0", "core", "^1.0")
+	publishWithDeps(t, router, "api", "2.0.0", "core", ">=1")
+	publishWithDeps(t, router, "cli", "0.1.0", "web", "*")
+
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination did not terminate")
+		}
+		path := "/api/v1/packages/core/dependents?limit=2"
+		if cursor != "" {
+			path += "&cursor=" + cursor
+		}
+		rr := doRequest(t, router, "GET", path, "test-token", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp models.DependentsResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		for _, d := range resp.Dependents {
+			got = append(got, d.Package+"@"+d.Version+" "+d.Constraint)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+
+	want := []string{"api@2.0.0 >=1", "web@1.0.0 ^1", "web@1.1.0 ^1.0"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	rr := doRequest(t, router, "GET", "/api/v1/packages/unknown/dependents", "test-token", nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"dependents":[]`) {
+		t.Errorf("unknown package: got %d %s", rr.Code, rr.Body.String())
+	}
+	for _, query := range []string{"limit=0", "limit=abc", "cursor=!!"} {
+		if rr := doRequest(t, router, "GET", "/api/v1/packages/core/dependents?"+query, "test-token", nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestSBOMAttachRetrieveAndSearch(t *testing.T) {
+	h, router := setupTestHandler(t)
+	for _, pkg := range []string{"web", "cli"} {
+		if rr := doRequest(t, router, "POST", "/api/v1/artifacts/"+pkg+"/1.0.0", "test-token", []byte(pkg)); rr.Code != http.StatusCreated {
+			t.Fatalf("upload %s: %d", pkg, rr.Code)
+		}
+	}
+
+	cyclonedx := `{"bomFormat":"CycloneDX","specVersion":"1.5","components":[
+		{"name":"express","version":"4.18.2","purl":"pkg:npm/express@4.18.2",
+		 "components":[{"name":"qs","version":"6.11.0"}]}]}`
+	rr := doRequest(t, router, "PUT", "/api/v1/artifacts/web/1.0.0/sbom", "test-token", []byte(cyclonedx))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("attach: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var sbom models.SBOM
+	json.Unmarshal(rr.Body.Bytes(), &sbom)
+	if sbom.Format != "cyclonedx" || sbom.SpecVersion != "1.5" || sbom.Components != 2 {
+		t.Errorf("unexpected SBOM summary: %+v", sbom)
+	}
+
+	spdx := `{"spdxVersion":"SPDX-2.3","packages":[{"name":"cobra","versionInfo":"1.8.0",
+		"externalRefs":[{"referenceType":"purl","referenceLocator":"pkg:golang/github.com/spf13/cobra@v1.8.0"}]}]}`
+	if rr := doRequest(t, router, "PUT", "/api/v1/artifacts/cli/1.0.0/sbom", "test-token", []byte(spdx)); rr.Code != http.StatusOK {
+		t.Fatalf("attach SPDX: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/web/1.0.0/sbom", "test-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != cyclonedx || rr.Header().Get("Content-Type") != "application/vnd.cyclonedx+json" {
+		t.Errorf("get: got %d %q %q", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+	if rr.Header().Get("X-Artifact-Hash") != sbom.Hash {
+		t.Errorf("expected the SBOM digest in X-Artifact-Hash, got %q", rr.Header().Get("X-Artifact-Hash"))
+	}
+
+	// The SBOM blob survives garbage collection.
+	if rr := doRequest(t, router, "POST", "/api/v1/gc", "test-token", nil); rr.Code != http.StatusOK {
+		t.Fatalf("gc: %d", rr.Code)
+	}
+	if !h.blobs.Exists(sbom.Hash) {
+		t.Error("gc deleted the SBOM blob")
+	}
+
+	for query, want := range map[string]string{
+		"component=qs": "web",
+		"component=express&component_version=4.18.2":  "web",
+		"component=pkg:golang/github.com/spf13/cobra": "cli",
+		"component=cobra&search=we":                   "",
+		"component=express&component_version=1.0.0":   "",
+	} {
+		rr := doRequest(t, router, "GET", "/api/v1/packages?"+query, "test-token", nil)
+		var pkgs []models.Package
+		json.Unmarshal(rr.Body.Bytes(), &pkgs)
+		var names []string
+		for _, p := range pkgs {
+			names = append(names, p.Name)
+		}
+		if strings.Join(names, ",") != want {
+			t.Errorf("%s: expected [%s], got %v", query, want, names)
+		}
+	}
+
+	if rr := doRequest(t, router, "PUT", "/api/v1/artifacts/web/1.0.0/sbom", "test-token", []byte(`{"hello":"world"}`)); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: expected 400, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/web/9.9.9/sbom", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("missing version: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestParseScanReport(t *testing.T) {
+	trivy := `{"SchemaVersion":2,"ArtifactName":"app.tar","Results":[
+		{"Target":"go.sum","Vulnerabilities":[
+			{"VulnerabilityID":"CVE-1","PkgName":"a","InstalledVersion":"1.0","Severity":"CRITICAL"},
+			{"VulnerabilityID":"CVE-2","PkgName":"b","InstalledVersion":"1.0","Severity":"MEDIUM"}]},
+		{"Target":"binary","Vulnerabilities":[
+			{"VulnerabilityID":"CVE-1","PkgName":"a","InstalledVersion":"1.0","Severity":"CRITICAL"},
+			{"VulnerabilityID":"CVE-3","PkgName":"c","InstalledVersion":"2.0","Severity":"UNKNOWN"}]},
+		{"Target":"clean"}]}`
+	grype := `{"matches":[
+		{"vulnerability":{"id":"GHSA-1","severity":"High"},"artifact":{"name":"a","version":"1.0"}},
+		{"vulnerability":{"id":"CVE-4","severity":"Negligible"},"artifact":{"name":"b","version":"2.0"}}],
+		"descriptor":{"name":"grype","version":"0.74.0"}}`
+
+	for _, tc := range []struct {
+		doc     string
+		scanner string
+		want    models.VulnerabilitySummary
+	}{
+		{trivy, "trivy", models.VulnerabilitySummary{Critical: 1, Medium: 1, Unknown: 1}},
+		{grype, "grype", models.VulnerabilitySummary{High: 1, Low: 1}},
+		{`{"SchemaVersion":2,"ArtifactName":"clean"}`, "trivy", models.VulnerabilitySummary{}},
+	} {
+		scanner, summary, err := parseScanReport([]byte(tc.doc))
+		if err != nil || scanner != tc.scanner || summary != tc.want {
+			t.Errorf("parseScanReport(%.30s): %q %+v %v, want %q %+v", tc.doc, scanner, summary, err, tc.scanner, tc.want)
+		}
+	}
+
+	for _, doc := range []string{`{"bomFormat":"CycloneDX"}`, `not json`} {
+		if _, _, err := parseScanReport([]byte(doc)); err == nil {
+			t.Errorf("parseScanReport(%q): expected error", doc)
+		}
+	}
+}
+
+func TestScanReportBlocksDownloads(t *testing.T) {
+	h, router := setupTestHandler(t)
+	h.blockSeverity = "high"
+	for _, v := range []string{"1.0.0", "2.0.0", "3.0.0"} {
+		if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/"+v, "test-token", []byte("app "+v)); rr.Code != http.StatusCreated {
+			t.Fatalf("upload %s: %d", v, rr.Code)
+		}
+	}
+
+	high := `{"SchemaVersion":2,"Results":[{"Vulnerabilities":[
+		{"VulnerabilityID":"CVE-1","PkgName":"a","InstalledVersion":"1.0","Severity":"HIGH"}]}]}`
+	low := `{"SchemaVersion":2,"Results":[{"Vulnerabilities":[
+		{"VulnerabilityID":"CVE-2","PkgName":"a","InstalledVersion":"1.0","Severity":"LOW"}]}]}`
+	rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/scan", "test-token", []byte(high))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("attach: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var report models.ScanReport
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	if report.Scanner != "trivy" || report.Summary.High != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/2.0.0/scan", "test-token", []byte(low)); rr.Code != http.StatusOK {
+		t.Fatalf("attach low: %d", rr.Code)
+	}
+
+	// The package listing carries each version's summary.
+	rr = doRequest(t, router, "GET", "/api/v1/packages/app", "test-token", nil)
+	var info models.PackageInfo
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	for _, a := range info.Versions {
+		switch {
+		case a.Version == "1.0.0" && (a.Vulnerabilities == nil || a.Vulnerabilities.High != 1),
+			a.Version == "2.0.0" && (a.Vulnerabilities == nil || a.Vulnerabilities.Low != 1),
+			a.Version == "3.0.0" && a.Vulnerabilities != nil:
+			t.Errorf("%s: unexpected summary %+v", a.Version, a.Vulnerabilities)
+		}
+	}
+
+	// Only the version with a high finding is blocked; unscanned versions
+	// download normally.
+	for version, want := range map[string]int{"1.0.0": http.StatusForbidden, "2.0.0": http.StatusOK, "3.0.0": http.StatusOK} {
+		if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/"+version, "test-token", nil); rr.Code != want {
+			t.Errorf("GET %s: expected %d, got %d", version, want, rr.Code)
+		}
+		if rr := doRequest(t, router, "HEAD", "/api/v1/artifacts/app/"+version, "test-token", nil); rr.Code != want {
+			t.Errorf("HEAD %s: expected %d, got %d", version, want, rr.Code)
+		}
+	}
+
+	// The report itself stays downloadable.
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/scan", "test-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != high {
+		t.Errorf("get report: got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/3.0.0/scan", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unscanned report: expected 404, got %d", rr.Code)
+	}
+
+	// A clean rescan lifts the block.
+	if rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/scan", "test-token", []byte(low)); rr.Code != http.StatusOK {
+		t.Fatalf("rescan: %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("after rescan: expected 200, got %d", rr.Code)
+	}
+
+	if rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/scan", "test-token", []byte(`{"hello":"world"}`)); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: expected 400, got %d", rr.Code)
+	}
+}
+
+// signedAttestation wraps an in-toto statement about the file with digest,
+// from builder, in a DSSE envelope signed by key.
+func signedAttestation(t *testing.T, key *ecdsa.PrivateKey, digest, predicateType, builder string) []byte {
+	t.Helper()
+	payload, _ := json.Marshal(map[string]any{
+		"_type":         "https://in-toto.io/Statement/v1",
+		"subject":       []map[string]any{{"name": "app.tgz", "digest": map[string]string{"sha256": digest}}},
+		"predicateType": predicateType,
+		"predicate":     map[string]any{"runDetails": map[string]any{"builder": map[string]string{"id": builder}}},
+	})
+	pae := dsse.PAE("application/vnd.in-toto+json", payload)
+	sum := sha256.Sum256(pae)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatalf("signing: %v", err)
+	}
+	envelope, _ := json.Marshal(dsse.Envelope{
+		PayloadType: "application/vnd.in-toto+json",
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []dsse.Signature{{Sig: base64.StdEncoding.EncodeToString(sig)}},
+	})
+	return envelope
+}
+
+func TestAttestations(t *testing.T) {
+	h, router := setupTestHandler(t)
+	builderKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	const builder = "https://ci.example/builders/release@v1"
+	const provenance = "https://slsa.dev/provenance/v1"
+	WithTrustedBuilders([]TrustedBuilder{{ID: builder, Key: builderKey.Public()}}, false)(h)
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("app 1.0.0"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0/files/app.sig", "test-token", []byte("sig"))
+	sum := sha256.Sum256([]byte("app 1.0.0"))
+	digest := hex.EncodeToString(sum[:])
+	fileSum := sha256.Sum256([]byte("sig"))
+
+	trusted := signedAttestation(t, builderKey, digest, provenance, builder)
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0/attestations", "test-token", trusted)
+	var att models.Attestation
+	json.Unmarshal(rr.Body.Bytes(), &att)
+	if rr.Code != http.StatusCreated || !att.Verified || att.Builder != builder || att.Subject != digest || att.PredicateType != provenance {
+		t.Fatalf("trusted attestation: %d %s", rr.Code, rr.Body.String())
+	}
+	// Statements about a named file, or signed by an unknown key, are kept
+	// unverified.
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0/attestations", "test-token",
+		signedAttestation(t, otherKey, hex.EncodeToString(fileSum[:]), provenance, builder))
+	var forged models.Attestation
+	json.Unmarshal(rr.Body.Bytes(), &forged)
+	if rr.Code != http.StatusCreated || forged.Verified {
+		t.Errorf("untrusted attestation: %d %s", rr.Code, rr.Body.String())
+	}
+
+	for name, body := range map[string][]byte{
+		"other subject": signedAttestation(t, builderKey, strings.Repeat("0", 64), provenance, builder),
+		"not DSSE":      []byte(`{"_type": "https://in-toto.io/Statement/v1"}`),
+	} {
+		if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0/attestations", "test-token", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, rr.Code)
+		}
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/attestations", "test-token", nil)
+	var list []models.Attestation
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if rr.Code != http.StatusOK || len(list) != 2 || list[0] != att {
+		t.Errorf("list: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/attestations?predicate_type=https://spdx.dev/Document", "test-token", nil)
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("filtered list: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", fmt.Sprintf("/api/v1/artifacts/app/1.0.0/attestations/%d", att.ID), "test-token", nil)
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), trusted) {
+		t.Errorf("get envelope: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/attestations/999", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown attestation: expected 404, got %d", rr.Code)
+	}
+
+	// Requiring verification refuses what does not verify.
+	WithTrustedBuilders([]TrustedBuilder{{ID: builder, Key: builderKey.Public()}}, true)(h)
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0/attestations", "test-token",
+		signedAttestation(t, otherKey, digest, provenance, builder))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("required verification: expected 422, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0/attestations", "test-token", trusted); rr.Code != http.StatusCreated {
+		t.Errorf("required verification, trusted: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+// failingPolicy cannot reach a decision.
+type failingPolicy struct{}
+
+func (failingPolicy) Evaluate(context.Context, models.PolicyRequest) error {
+	return errors.New("policy server unreachable")
+}
+
+func TestPolicyGatesUploadsAndDeletes(t *testing.T) {
+	h, router := setupTestHandler(t)
+	h.policy = &policy.Rules{
+		RequireSemver:   true,
+		PackagePrefixes: map[string][]string{"config": {"team-"}},
+		ProtectReleases: true,
+	}
+	h.crates = h.meta.(services.CrateIndex)
+
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{"POST", "/api/v1/artifacts/team-app/1.0.0", http.StatusCreated},
+		{"POST", "/api/v1/artifacts/team-app/2.0.0-rc.1", http.StatusCreated},
+		{"POST", "/api/v1/artifacts/team-app/latest", http.StatusForbidden},
+		{"POST", "/api/v1/artifacts/other-app/1.0.0", http.StatusForbidden},
+		{"POST", "/api/v1/artifacts/other-app/1.0.0/files/a.zip", http.StatusForbidden},
+		{"PUT", "/maven2/com/example/lib/1.0/lib-1.0.jar", http.StatusForbidden},
+		{"POST", "/api/v1/artifacts/team-app/1.0.0/files/notes.txt", http.StatusCreated},
+		{"DELETE", "/api/v1/artifacts/team-app/1.0.0/files/notes.txt", http.StatusForbidden},
+		{"DELETE", "/api/v1/artifacts/team-app/1.0.0", http.StatusForbidden},
+		{"DELETE", "/api/v1/artifacts/team-app/2.0.0-rc.1", http.StatusOK},
+	} {
+		rr := doRequest(t, router, tc.method, tc.path, "test-token", []byte("data"))
+		if rr.Code != tc.want {
+			t.Errorf("%s %s: expected %d, got %d: %s", tc.method, tc.path, tc.want, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Cargo clients get the refusal in their own error format.
+	body := cargoPublishBody(t, cargoPublishMetadata{Name: "other", Vers: "0.1.0"}, []byte("crate"))
+	rr := doRequest(t, router, "PUT", "/cargo/api/v1/crates/new", "test-token", body)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), `"errors"`) {
+		t.Errorf("cargo publish: expected 403 with cargo errors, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// An engine that cannot decide fails closed.
+	h.policy = failingPolicy{}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/team-app/3.0.0", "test-token", []byte("data")); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("failing policy: expected 503, got %d", rr.Code)
+	}
+}
+
+// recordingHook records the events it sees and rejects those reject
+// matches.
+type recordingHook struct {
+	events []models.HookEvent
+	reject func(models.HookEvent) error
+}
+
+func (r *recordingHook) Run(_ context.Context, event models.HookEvent) error {
+	r.events = append(r.events, event)
+	return r.reject(event)
+}
+
+func TestHooksRejectRequests(t *testing.T) {
+	h, router := setupTestHandler(t)
+	hook := &recordingHook{reject: func(e models.HookEvent) error {
+		switch {
+		case e.Hook == models.HookPreUpload && e.Package == "blocked":
+			return fmt.Errorf("%w: not approved by compliance", services.ErrHookRejected)
+		case e.Hook == models.HookPreDownload && e.File == "secret.txt",
+			e.Hook == models.HookPreDelete && e.File == "":
+			return services.ErrHookRejected
+		case e.Package == "broken":
+			return errors.New("compliance service down")
+		}
+		return nil
+	}}
+	h.hooks = []services.Hook{hook}
+
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/blocked/1.0.0", "test-token", []byte("data"))
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "not approved by compliance") {
+		t.Errorf("rejected upload: expected 403 with the reason, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if a, _ := h.meta.GetArtifact("blocked", "1.0.0"); a != nil {
+		t.Error("a rejected upload should record nothing")
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/broken/1.0.0", "test-token", []byte("data")); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("failing hook: expected 503, got %d", rr.Code)
+	}
+
+	hook.events = nil
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("data"))
+	if len(hook.events) != 2 || hook.events[0].Hook != models.HookPreUpload || hook.events[1].Hook != models.HookPostUpload ||
+		hook.events[1].Hash == "" || hook.events[1].Size != 4 || hook.events[1].Principal == nil {
+		t.Errorf("upload events = %+v, want pre- and post-upload with the blob and caller", hook.events)
+	}
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{"POST", "/api/v1/artifacts/app/1.0.0/files/secret.txt", http.StatusCreated},
+		{"GET", "/api/v1/artifacts/app/1.0.0", http.StatusOK},
+		{"GET", "/api/v1/artifacts/app/1.0.0/files/secret.txt", http.StatusForbidden},
+		{"DELETE", "/api/v1/artifacts/app/1.0.0/files/secret.txt", http.StatusOK},
+		{"DELETE", "/api/v1/artifacts/app/1.0.0", http.StatusForbidden},
+	} {
+		if rr := doRequest(t, router, tc.method, tc.path, "test-token", []byte("data")); rr.Code != tc.want {
+			t.Errorf("%s %s: expected %d, got %d: %s", tc.method, tc.path, tc.want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+// markerScanner finds "EICAR" in uploads, or fails when down.
+type markerScanner struct{ down bool }
+
+func (m markerScanner) Scan(_ context.Context, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	switch {
+	case err != nil:
+		return "", err
+	case m.down:
+		return "", errors.New("clamd unreachable")
+	case bytes.Contains(data, []byte("EICAR")):
+		return "Eicar-Test-Signature", nil
+	}
+	return "", nil
+}
+
+func TestMalwareScanning(t *testing.T) {
+	h, router := setupTestHandler(t)
+	h.malware = markerScanner{}
+
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("X5O!P%@AP EICAR"))
+	var created models.UploadResponse
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if rr.Code != http.StatusCreated || created.Malware != models.MalwareInfected {
+		t.Fatalf("infected upload: expected 201 marked infected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/2.0.0", "test-token", []byte("clean"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/2.0.0/files/payload.exe", "test-token", []byte("EICAR"))
+	if a, _ := h.meta.GetArtifact("app", "2.0.0"); a == nil || a.Malware != models.MalwareClean {
+		t.Errorf("clean version: %+v, want marked clean", a)
+	}
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/api/v1/artifacts/app/1.0.0", http.StatusForbidden},
+		{"/api/v1/artifacts/app/2.0.0", http.StatusOK},
+		{"/api/v1/artifacts/app/2.0.0/files/payload.exe", http.StatusForbidden},
+	} {
+		rr := doRequest(t, router, "GET", tc.path, "test-token", nil)
+		if rr.Code != tc.want {
+			t.Errorf("GET %s: expected %d, got %d: %s", tc.path, tc.want, rr.Code, rr.Body.String())
+		}
+		if tc.want == http.StatusForbidden && !strings.Contains(rr.Body.String(), "infected with Eicar-Test-Signature") {
+			t.Errorf("GET %s: error should name the signature: %s", tc.path, rr.Body.String())
+		}
+	}
+
+	// Without a verdict the upload fails and nothing is published.
+	h.malware = markerScanner{down: true}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/3.0.0", "test-token", []byte("data")); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("scanner down: expected 503, got %d", rr.Code)
+	}
+	if a, _ := h.meta.GetArtifact("app", "3.0.0"); a != nil {
+		t.Error("an unscanned upload should not be recorded")
+	}
+}
+
+func TestDigests(t *testing.T) {
+	h, router := setupTestHandler(t)
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("app binary"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0/files/linux.bin", "test-token", []byte("linux build"))
+	sum256 := func(s string) string { d := sha256.Sum256([]byte(s)); return hex.EncodeToString(d[:]) }
+	sum512 := func(s string) string { d := sha512.Sum512([]byte(s)); return hex.EncodeToString(d[:]) }
+
+	for _, method := range []string{"GET", "HEAD"} {
+		rr := doRequest(t, router, method, "/api/v1/artifacts/app/1.0.0/files/linux.bin", "test-token", nil)
+		if got := rr.Header().Get("X-Artifact-Digest-SHA256"); got != sum256("linux build") {
+			t.Errorf("%s: SHA256 header %q", method, got)
+		}
+		if got := rr.Header().Get("X-Artifact-Digest-SHA512"); got != sum512("linux build") {
+			t.Errorf("%s: SHA512 header %q", method, got)
+		}
+		if got := rr.Header().Get("X-Artifact-Digest-BLAKE3"); got != "" {
+			t.Errorf("%s: BLAKE3 header %q without BLAKE3 enabled", method, got)
+		}
+	}
+	if a, _ := h.meta.GetArtifact("app", "1.0.0"); a == nil || a.SHA512 != sum512("app binary") || a.BLAKE3 != "" {
+		t.Errorf("artifact metadata: %+v", a)
+	}
+
+	rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/checksums.txt", "test-token", nil)
+	want := sum256("app binary") + "  app-1.0.0\n" + sum256("linux build") + "  linux.bin\n"
+	if rr.Code != http.StatusOK || rr.Body.String() != want {
+		t.Errorf("checksums.txt: %d %q, want %q", rr.Code, rr.Body.String(), want)
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/checksums.txt?algorithm=sha512", "test-token", nil)
+	if want := sum512("app binary") + "  app-1.0.0\n"; !strings.HasPrefix(rr.Body.String(), want) {
+		t.Errorf("sha512 checksums.txt: %q", rr.Body.String())
+	}
+	for _, algorithm := range []string{"blake3", "md5"} {
+		if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/checksums.txt?algorithm="+algorithm, "test-token", nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("algorithm=%s: expected 400, got %d", algorithm, rr.Code)
+		}
+	}
+
+	// Enabling BLAKE3 computes it for new uploads and, on demand, for
+	// files uploaded before.
+	h.blake3 = true
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/2.0.0", "test-token", []byte("app 2"))
+	b3 := blake3.Sum256([]byte("app 2"))
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/2.0.0", "test-token", nil)
+	if got := rr.Header().Get("X-Artifact-Digest-BLAKE3"); got != hex.EncodeToString(b3[:]) {
+		t.Errorf("BLAKE3 header %q", got)
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/checksums.txt?algorithm=blake3", "test-token", nil)
+	b3 = blake3.Sum256([]byte("app binary"))
+	if want := hex.EncodeToString(b3[:]) + "  app-1.0.0\n"; rr.Code != http.StatusOK || !strings.HasPrefix(rr.Body.String(), want) {
+		t.Errorf("blake3 checksums.txt: %d %q", rr.Code, rr.Body.String())
+	}
+	if a, _ := h.meta.GetArtifact("app", "1.0.0"); a == nil || a.BLAKE3 != hex.EncodeToString(b3[:]) {
+		t.Errorf("BLAKE3 computed on demand should be recorded: %+v", a)
+	}
+}
+
+// principalAuth authenticates tokens as fixed principals.
+type principalAuth map[string]*models.Principal
+
+func (a principalAuth) Authenticate(token string) (*models.Principal, bool) {
+	p, ok := a[token]
+	return p, ok
+}
+
+func TestQuarantineHidesUploadsUntilApproved(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+	}
+	h.quarantine = true
+	router := h.Router()
+
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/lib/1.0.0", "ci-token", []byte("lib 1.0.0"))
+	var uploaded models.UploadResponse
+	json.Unmarshal(rr.Body.Bytes(), &uploaded)
+	if rr.Code != http.StatusCreated || !uploaded.Quarantined {
+		t.Fatalf("upload: expected 201 quarantined, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/lib/1.0.0/files/lib.whl", "ci-token", []byte("wheel")); rr.Code != http.StatusCreated {
+		t.Fatalf("upload file: %d", rr.Code)
+	}
+
+	// Non-admin callers cannot see the version; admins, such as scanners,
+	// can.
+	for _, path := range []string{"/api/v1/artifacts/lib/1.0.0", "/api/v1/artifacts/lib/1.0.0/files/lib.whl"} {
+		if rr := doRequest(t, router, "GET", path, "ci-token", nil); rr.Code != http.StatusNotFound {
+			t.Errorf("GET %s as ci: expected 404, got %d", path, rr.Code)
+		}
+		if rr := doRequest(t, router, "HEAD", path, "ci-token", nil); rr.Code != http.StatusNotFound {
+			t.Errorf("HEAD %s as ci: expected 404, got %d", path, rr.Code)
+		}
+		if rr := doRequest(t, router, "GET", path, "test-token", nil); rr.Code != http.StatusOK {
+			t.Errorf("GET %s as admin: expected 200, got %d", path, rr.Code)
+		}
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/packages/lib", "ci-token", nil)
+	var info models.PackageInfo
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	if len(info.Versions) != 0 {
+		t.Errorf("package listing should hide quarantined versions: %+v", info.Versions)
+	}
+
+	// Only admins list and approve quarantined versions.
+	if rr := doRequest(t, router, "GET", "/api/v1/admin/quarantine", "ci-token", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("quarantine list as ci: expected 403, got %d", rr.Code)
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/admin/quarantine", "test-token", nil)
+	var pending []models.Artifact
+	json.Unmarshal(rr.Body.Bytes(), &pending)
+	if len(pending) != 1 || pending[0].Package != "lib" || pending[0].Version != "1.0.0" {
+		t.Fatalf("unexpected quarantine list: %s", rr.Body.String())
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/lib/1.0.0/approve", "ci-token", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("approve as ci: expected 403, got %d", rr.Code)
+	}
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/lib/1.0.0/approve", "test-token", nil)
+	var approved models.Artifact
+	json.Unmarshal(rr.Body.Bytes(), &approved)
+	if rr.Code != http.StatusOK || approved.Quarantined {
+		t.Fatalf("approve: got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/lib/1.0.0", "ci-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("after approval: expected 200, got %d", rr.Code)
+	}
+
+	// Adding a file to an approved version puts it back in quarantine.
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/lib/1.0.0/files/extra.bin", "ci-token", []byte("extra")); rr.Code != http.StatusCreated {
+		t.Fatalf("upload extra file: %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/lib/1.0.0", "ci-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("after adding a file: expected 404, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/lib/9.9.9/approve", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("approve missing version: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestResolveSkipsQuarantinedVersions(t *testing.T) {
+	h, router := setupTestHandler(t)
+	for _, v := range []string{"1.0.0", "1.1.0"} {
+		if rr := doRequest(t, router, "POST", "/api/v1/artifacts/dep/"+v, "test-token", []byte(v)); rr.Code != http.StatusCreated {
+			t.Fatalf("upload dep %s: %d", v, rr.Code)
+		}
+	}
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("app"))
+	doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/dependencies", "test-token",
+		[]byte(`{"dependencies":[{"package":"dep","constraint":"^1.0"}]}`))
+	h.meta.SetQuarantined("dep", "1.1.0", true)
+
+	rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/dependencies?resolve=true", "test-token", nil)
+	var resp models.DependenciesResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Resolved) != 1 || resp.Resolved[0].Version != "1.0.0" {
+		t.Errorf("expected dep@1.0.0, got %+v", resp.Resolved)
+	}
+}
+
+func TestPromoteStagingToRelease(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.auth = principalAuth{
+		"test-token": {Name: "release-manager", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+	}
+	router := h.Router()
+
+	upload := func(version, stage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/artifacts/lib/"+version, strings.NewReader("lib "+version))
+		req.Header.Set("Authorization", "Bearer ci-token")
+		req.Header.Set("X-Artifact-Stage", stage)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := upload("1.0.0", "beta"); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown stage: expected 400, got %d", rr.Code)
+	}
+	rr := upload("1.0.0", models.StageStaging)
+	var uploaded models.UploadResponse
+	json.Unmarshal(rr.Body.Bytes(), &uploaded)
+	if rr.Code != http.StatusCreated || uploaded.Stage != models.StageStaging {
+		t.Fatalf("staging upload: got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := upload("0.9.0", models.StageRelease); rr.Code != http.StatusCreated {
+		t.Fatalf("release upload: %d", rr.Code)
+	}
+
+	// Staging versions download by exact version but are not listed or
+	// resolved.
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/lib/1.0.0", "ci-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("staging download: expected 200, got %d", rr.Code)
+	}
+	listed := func(query string) []string {
+		rr := doRequest(t, router, "GET", "/api/v1/packages/lib"+query, "ci-token", nil)
+		var info models.PackageInfo
+		json.Unmarshal(rr.Body.Bytes(), &info)
+		var versions []string
+		for _, v := range info.Versions {
+			versions = append(versions, v.Version)
+		}
+		return versions
+	}
+	if got := listed(""); len(got) != 1 || got[0] != "0.9.0" {
+		t.Errorf("default listing should show releases only: %v", got)
+	}
+	if got := listed("?stage=staging"); len(got) != 1 || got[0] != "1.0.0" {
+		t.Errorf("staging listing: %v", got)
+	}
+	if got := listed("?stage=all"); len(got) != 2 {
+		t.Errorf("full listing: %v", got)
+	}
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("app"))
+	doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/dependencies", "test-token",
+		[]byte(`{"dependencies":[{"package":"lib","constraint":">=0.9"}]}`))
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/dependencies?resolve=true", "test-token", nil)
+	var resp models.DependenciesResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Resolved) != 1 || resp.Resolved[0].Version != "0.9.0" {
+		t.Errorf("resolver should skip staging versions: %+v", resp.Resolved)
+	}
+
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/lib/1.0.0/promote", "ci-token", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("promote as ci: expected 403, got %d", rr.Code)
+	}
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/lib/1.0.0/promote", "test-token", nil)
+	var promoted models.Artifact
+	json.Unmarshal(rr.Body.Bytes(), &promoted)
+	if rr.Code != http.StatusOK || promoted.Stage != models.StageRelease || promoted.PromotedBy != "release-manager" || promoted.PromotedAt == nil {
+		t.Fatalf("promote: got %d: %s", rr.Code, rr.Body.String())
+	}
+	if promoted.Hash != uploaded.Hash {
+		t.Errorf("promotion should keep the blob: %s != %s", promoted.Hash, uploaded.Hash)
+	}
+	if got := listed(""); len(got) != 2 {
+		t.Errorf("promoted version should be listed: %v", got)
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/lib/1.0.0/promote", "test-token", nil); rr.Code != http.StatusConflict {
+		t.Errorf("promote twice: expected 409, got %d", rr.Code)
+	}
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/lib/1.0.0/promote", "test-token", []byte(`{"stage":"staging"}`))
+	if rr.Code != http.StatusOK {
+		t.Errorf("move back to staging: got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/lib/1.0.0/promote", "test-token", []byte(`{"stage":"prod"}`)); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown stage: expected 400, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/lib/9.9.9/promote", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("promote missing version: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"72h", now.Add(72 * time.Hour), true},
+		{"7d", now.Add(7 * 24 * time.Hour), true},
+		{"2024-06-08T12:00:00+02:00", time.Date(2024, 6, 8, 10, 0, 0, 0, time.UTC), true},
+		{"2024-05-01T00:00:00Z", time.Time{}, false},
+		{"-1h", time.Time{}, false},
+		{"0d", time.Time{}, false},
+		{"next week", time.Time{}, false},
+	} {
+		got, err := parseExpiry(tc.value, now)
+		if (err == nil) != tc.ok || (tc.ok && !got.Equal(tc.want)) {
+			t.Errorf("parseExpiry(%q) = %v, %v", tc.value, got, err)
+		}
+	}
+}
+
+func TestExpiredArtifactsAreRemoved(t *testing.T) {
+	h, router := setupTestHandler(t)
+	fake := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	WithClock(fake)(h)
+
+	upload := func(path, expires string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader("pr build"))
+		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("X-Artifact-Expires", expires)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := upload("/api/v1/artifacts/app/1.0.0-pr.7", "soon"); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid expiry: expected 400, got %d", rr.Code)
+	}
+	rr := upload("/api/v1/artifacts/app/1.0.0-pr.7", "7d")
+	var uploaded models.UploadResponse
+	json.Unmarshal(rr.Body.Bytes(), &uploaded)
+	if rr.Code != http.StatusCreated || uploaded.ExpiresAt == nil || !uploaded.ExpiresAt.Equal(fake.Now().Add(7*24*time.Hour)) {
+		t.Fatalf("upload: got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := upload("/api/v1/artifacts/app/1.0.0-pr.8/files/app.tgz", "1h"); rr.Code != http.StatusCreated {
+		t.Fatalf("file upload: %d", rr.Code)
+	}
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("release"))
+
+	rr = doRequest(t, router, "GET", "/api/v1/packages/app", "test-token", nil)
+	var info models.PackageInfo
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	for _, v := range info.Versions {
+		if (v.ExpiresAt != nil) != (v.Version != "1.0.0") {
+			t.Errorf("%s: unexpected expires_at %v", v.Version, v.ExpiresAt)
+		}
+	}
+
+	fake.Advance(2 * time.Hour)
+	removed, err := h.ExpireArtifacts(fake.Now())
+	if err != nil || removed != 1 {
+		t.Fatalf("ExpireArtifacts after 2h: %d, %v", removed, err)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0-pr.8/files/app.tgz", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expired file: expected 404, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0-pr.7", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("unexpired version: expected 200, got %d", rr.Code)
+	}
+
+	fake.Set(*uploaded.ExpiresAt)
+	if removed, _ := h.ExpireArtifacts(fake.Now()); removed != 1 {
+		t.Fatalf("ExpireArtifacts at expiry: removed %d", removed)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("version without expiry: expected 200, got %d", rr.Code)
+	}
+
+	// The expired blob is only referenced by the removed version, so GC
+	// reclaims it.
+	rr = doRequest(t, router, "POST", "/api/v1/gc?dry_run=true", "test-token", nil)
+	var gc models.GCResult
+	json.Unmarshal(rr.Body.Bytes(), &gc)
+	if len(gc.Candidates) != 1 || gc.Candidates[0] != uploaded.Hash {
+		t.Errorf("expected the expired blob to be collectable, got %+v", gc.Candidates)
+	}
+}
+
+func TestPinBlocksDeletion(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+	}
+	router := h.Router()
+
+	req := httptest.NewRequest("POST", "/api/v1/artifacts/app/1.0.0", strings.NewReader("ga"))
+	req.Header.Set("Authorization", "Bearer ci-token")
+	req.Header.Set("X-Artifact-Expires", "1h")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0/files/notes.txt", "ci-token", []byte("notes")); rr.Code != http.StatusCreated {
+		t.Fatalf("upload file: %d", rr.Code)
+	}
+
+	rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/pin", "ci-token", nil)
+	var pinned models.Artifact
+	json.Unmarshal(rr.Body.Bytes(), &pinned)
+	if rr.Code != http.StatusOK || !pinned.Pinned {
+		t.Fatalf("pin: got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/packages/app", "ci-token", nil)
+	var info models.PackageInfo
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	if len(info.Versions) != 1 || !info.Versions[0].Pinned {
+		t.Errorf("listing should show the pin: %s", rr.Body.String())
+	}
+
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Code != http.StatusConflict {
+		t.Errorf("delete pinned version: expected 409, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.0.0/files/notes.txt", "test-token", nil); rr.Code != http.StatusConflict {
+		t.Errorf("delete file of pinned version: expected 409, got %d", rr.Code)
+	}
+	if removed, err := h.ExpireArtifacts(time.Now().Add(2 * time.Hour)); err != nil || removed != 0 {
+		t.Errorf("expiry should keep pinned versions: removed %d, %v", removed, err)
+	}
+
+	// Only admins lift a pin.
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.0.0/pin", "ci-token", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("unpin as ci: expected 403, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.0.0/pin", "test-token", nil); rr.Code != http.StatusOK {
+		t.Fatalf("unpin: %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("delete after unpinning: expected 200, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/9.9.9/pin", "ci-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("pin missing version: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestCleanPrefix(t *testing.T) {
+	for in, want := range map[string]string{
+		"":             "",
+		"/":            "",
+		"/foundry":     "/foundry",
+		"/foundry/":    "/foundry",
+		" /a//b/ ":     "/a/b",
+		"foundry":      "",
+		"/a/../b":      "/b",
+		"/registry/v1": "/registry/v1",
+	} {
+		if got := cleanPrefix(in); got != want {
+			t.Errorf("cleanPrefix(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestBasePathAndForwardedHeaders(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.crates = h.meta.(services.CrateIndex)
+	WithBasePath("/foundry/")(h)
+	router := h.Router()
+
+	if rr := doRequest(t, router, "POST", "/foundry/api/v1/artifacts/lib/1.0.0", "test-token", []byte("lib")); rr.Code != http.StatusCreated {
+		t.Fatalf("upload under base path: %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/lib/1.0.0", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("route outside base path: expected 404, got %d", rr.Code)
+	}
+
+	req := pypiUploadRequest(t, "my-lib", "1.0.0", "my_lib-1.0.0.tar.gz", []byte("sdist"))
+	req.URL.Path = "/foundry/pypi/"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	rr := doRequest(t, router, "GET", "/foundry/pypi/simple/My.Lib/", "test-token", nil)
+	if loc := rr.Header().Get("Location"); rr.Code != http.StatusMovedPermanently || loc != "/foundry/pypi/simple/my-lib/" {
+		t.Errorf("redirect: got %d %q", rr.Code, loc)
+	}
+	rr = doRequest(t, router, "GET", "/foundry/pypi/simple/my-lib/", "test-token", nil)
+	if !strings.Contains(rr.Body.String(), `href="/foundry/api/v1/artifacts/my-lib/1.0.0/files/my_lib-1.0.0.tar.gz#sha256=`) {
+		t.Errorf("simple page should link under the base path:\n%s", rr.Body.String())
+	}
+
+	// httptest requests come from 192.0.2.1.
+	configJSON := func(trusted string) string {
+		h.trustedProxies = []netip.Prefix{netip.MustParsePrefix(trusted)}
+		req := httptest.NewRequest("GET", "/foundry/cargo/index/config.json", nil)
+		req.Header.Set("Authorization", "test-token")
+		req.Header.Set("X-Forwarded-Prefix", "/gateway/")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "registry.example.com, proxy.internal")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+	if body := configJSON("10.0.0.0/8"); !strings.Contains(body, `"dl":"http://example.com/foundry/cargo/api/v1/crates"`) {
+		t.Errorf("untrusted proxy headers should be ignored: %s", body)
+	}
+	if body := configJSON("192.0.2.0/24"); !strings.Contains(body, `"dl":"https://registry.example.com/gateway/foundry/cargo/api/v1/crates"`) {
+		t.Errorf("trusted proxy headers should shape URLs: %s", body)
+	}
+}
+
+func TestDownloadArchive(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+	}
+	router := h.Router()
+	for _, up := range []struct{ path, body string }{
+		{"/api/v1/artifacts/app/1.0.0", "app one"},
+		{"/api/v1/artifacts/lib/2.0.0", "lib two"},
+		{"/api/v1/artifacts/lib/2.0.0/files/lib-linux.tar.gz", "linux build"},
+	} {
+		if rr := doRequest(t, router, "POST", up.path, "test-token", []byte(up.body)); rr.Code != http.StatusCreated {
+			t.Fatalf("upload %s: %d", up.path, rr.Code)
+		}
+	}
+	h.quarantine = true
+	doRequest(t, router, "POST", "/api/v1/artifacts/held/1.0.0", "test-token", []byte("held"))
+	userToken := "ci-token"
+
+	body := `{"artifacts":[{"package":"app","version":"1.0.0"},{"package":"lib","version":"2.0.0","file":"lib-linux.tar.gz"},{"package":"app","version":"1.0.0"}]}`
+	rr := doRequest(t, router, "POST", "/api/v1/archive", userToken, []byte(body))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-tar" {
+		t.Fatalf("tar archive: %d %s", rr.Code, rr.Body.String())
+	}
+	tr := tar.NewReader(rr.Body)
+	contents := map[string]string{}
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading tar: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		names = append(names, hdr.Name)
+		contents[hdr.Name] = string(data)
+	}
+	want := []string{"manifest.json", "app/1.0.0/app-1.0.0", "lib/2.0.0/lib-linux.tar.gz"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("tar entries = %v, want %v", names, want)
+	}
+	if contents["lib/2.0.0/lib-linux.tar.gz"] != "linux build" {
+		t.Errorf("asset contents = %q", contents["lib/2.0.0/lib-linux.tar.gz"])
+	}
+	var manifest models.ArchiveManifest
+	if err := json.Unmarshal([]byte(contents["manifest.json"]), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("app one"))
+	if len(manifest.Artifacts) != 2 || manifest.Artifacts[0].Hash != hex.EncodeToString(sum[:]) || manifest.Artifacts[0].Size != 7 {
+		t.Errorf("manifest = %+v", manifest)
+	}
+
+	rr = doRequest(t, router, "POST", "/api/v1/archive", userToken, []byte(`{"format":"zip","artifacts":[{"package":"app","version":"1.0.0"}]}`))
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("zip archive: %d %v", rr.Code, err)
+	}
+	if len(zr.File) != 2 || zr.File[1].Name != "app/1.0.0/app-1.0.0" {
+		t.Errorf("zip entries: %d", len(zr.File))
+	}
+
+	for _, tc := range []struct {
+		body string
+		want int
+	}{
+		{`{"artifacts":[]}`, http.StatusBadRequest},
+		{`{"format":"rar","artifacts":[{"package":"app","version":"1.0.0"}]}`, http.StatusBadRequest},
+		{`{"artifacts":[{"package":"app","version":"1.0.0","file":"../x"}]}`, http.StatusBadRequest},
+		{`{"artifacts":[{"package":"app","version":"1.0.0"},{"package":"nope","version":"1.0.0"}]}`, http.StatusNotFound},
+		{`{"artifacts":[{"package":"lib","version":"2.0.0","file":"missing.zip"}]}`, http.StatusNotFound},
+		{`{"artifacts":[{"package":"held","version":"1.0.0"}]}`, http.StatusNotFound},
+	} {
+		if rr := doRequest(t, router, "POST", "/api/v1/archive", userToken, []byte(tc.body)); rr.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.body, tc.want, rr.Code)
+		}
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/archive", "test-token", []byte(`{"artifacts":[{"package":"held","version":"1.0.0"}]}`)); rr.Code != http.StatusOK {
+		t.Errorf("admins can archive quarantined versions: %d", rr.Code)
+	}
+}
+
+func TestBatchGetArtifacts(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+	}
+	router := h.Router()
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "ci-token", []byte("one"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/lib/2.0.0", "ci-token", []byte("two"))
+	h.quarantine = true
+	doRequest(t, router, "POST", "/api/v1/artifacts/held/1.0.0", "ci-token", []byte("held"))
+
+	body := []byte(`{"artifacts": [
+		{"package": "app", "version": "1.0.0"},
+		{"package": "app", "version": "9.9.9"},
+		{"package": "lib", "version": "2.0.0"},
+		{"package": "held", "version": "1.0.0"}
+	]}`)
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/batch-get", "ci-token", body)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("batch-get: %d %s", rr.Code, rr.Body.String())
+	}
+	var resp models.BatchGetResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	var found []string
+	for _, res := range resp.Artifacts {
+		found = append(found, res.Package+"@"+res.Version+"="+strconv.FormatBool(res.Found))
+		if res.Found != (res.Artifact != nil) {
+			t.Errorf("%s@%s: found=%v with artifact %+v", res.Package, res.Version, res.Found, res.Artifact)
+		}
+	}
+	if got := strings.Join(found, " "); got != "app@1.0.0=true app@9.9.9=false lib@2.0.0=true held@1.0.0=false" {
+		t.Errorf("results = %s", got)
+	}
+	if a := resp.Artifacts[2].Artifact; a == nil || a.Size != 3 {
+		t.Errorf("lib metadata = %+v", a)
+	}
+
+	// Admins see quarantined versions.
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/batch-get", "test-token", body)
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if !resp.Artifacts[3].Found {
+		t.Errorf("admin batch-get missed the quarantined version")
+	}
+
+	for name, bad := range map[string]string{
+		"empty":      `{"artifacts": []}`,
+		"no version": `{"artifacts": [{"package": "app"}]}`,
+		"malformed":  `{"artifacts": `,
+	} {
+		if rr := doRequest(t, router, "POST", "/api/v1/artifacts/batch-get", "ci-token", []byte(bad)); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, rr.Code)
+		}
+	}
+}
+
+func TestPackageVersionsSortBySemver(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	router := h.Router()
+
+	// Backfilled out of order, with a pre-release and a non-semver build.
+	for _, v := range []string{"1.10.0", "nightly", "1.2.0", "2.0.0-rc.1", "2.0.0-beta.2", "1.0.0"} {
+		if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/"+v, "test-token", []byte(v)); rr.Code != http.StatusCreated {
+			t.Fatalf("upload %s: %d", v, rr.Code)
+		}
+	}
+
+	rr := doRequest(t, router, "GET", "/api/v1/packages/app?sort=semver", "test-token", nil)
+	var info models.PackageInfo
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	var order []string
+	for _, a := range info.Versions {
+		order = append(order, a.Version)
+	}
+	if got := strings.Join(order, " "); got != "2.0.0-rc.1 2.0.0-beta.2 1.10.0 1.2.0 1.0.0 nightly" {
+		t.Errorf("semver order = %s", got)
+	}
+	if info.Latest != "1.10.0" {
+		t.Errorf("latest = %q, want the highest release", info.Latest)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/packages/app", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	if rr.Code != http.StatusOK || info.Latest != "1.10.0" || len(info.Versions) != 6 {
+		t.Errorf("default order: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/packages/app?sort=size", "test-token", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown sort: expected 400, got %d", rr.Code)
+	}
+
+	// With only pre-releases, the highest of them is the latest.
+	doRequest(t, router, "POST", "/api/v1/artifacts/next/3.0.0-alpha.1", "test-token", []byte("a"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/next/3.0.0-alpha.10", "test-token", []byte("b"))
+	rr = doRequest(t, router, "GET", "/api/v1/packages/next", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	if info.Latest != "3.0.0-alpha.10" {
+		t.Errorf("pre-release latest = %q", info.Latest)
+	}
+}
+
+func TestListArtifactsFilters(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	router := h.Router()
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("small"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/2.0.0", "test-token", bytes.Repeat([]byte("x"), 100))
+	doRequest(t, router, "POST", "/api/v1/artifacts/lib/1.0.0", "test-token", bytes.Repeat([]byte("y"), 200))
+
+	versions := func(list []models.Artifact) string {
+		var out []string
+		for _, a := range list {
+			out = append(out, a.Package+"@"+a.Version)
+		}
+		return strings.Join(out, " ")
+	}
+
+	var list models.ArtifactList
+	rr := doRequest(t, router, "GET", "/api/v1/artifacts?min_size=50", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if rr.Code != http.StatusOK || versions(list.Artifacts) != "lib@1.0.0 app@2.0.0" {
+		t.Errorf("min_size: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts?limit=2", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if list.NextCursor == "" || !strings.Contains(rr.Header().Get("Link"), `rel="next"`) {
+		t.Fatalf("first page: %s", rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts?limit=2&cursor="+list.NextCursor, "test-token", nil)
+	list = models.ArtifactList{}
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if versions(list.Artifacts) != "app@1.0.0" || list.NextCursor != "" {
+		t.Errorf("second page: %s", rr.Body.String())
+	}
+
+	sum := sha256.Sum256([]byte("small"))
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts?hash="+hex.EncodeToString(sum[:]), "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if versions(list.Artifacts) != "app@1.0.0" {
+		t.Errorf("hash: %s", rr.Body.String())
+	}
+
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format(time.DateOnly)
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts?uploaded_after="+tomorrow, "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if rr.Code != http.StatusOK || len(list.Artifacts) != 0 {
+		t.Errorf("uploaded_after: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// The package detail takes the same filters but reports the package's
+	// latest version regardless.
+	rr = doRequest(t, router, "GET", "/api/v1/packages/app?max_size=10", "test-token", nil)
+	var info models.PackageInfo
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	if versions(info.Versions) != "app@1.0.0" || info.Latest != "2.0.0" {
+		t.Errorf("package filter: %s", rr.Body.String())
+	}
+
+	for _, query := range []string{"min_size=-1", "max_size=big", "uploaded_before=yesterday", "limit=0", "cursor=!"} {
+		if rr := doRequest(t, router, "GET", "/api/v1/artifacts?"+query, "test-token", nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestPackageHistory(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+	}
+	router := h.Router()
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "ci-token", []byte("first"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0/files/app.zip", "ci-token", []byte("zip"))
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Code != http.StatusOK {
+		t.Fatalf("delete: %d", rr.Code)
+	}
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "ci-token", []byte("second"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0/files/notes.txt", "ci-token", []byte("notes"))
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.0.0/files/notes.txt", "ci-token", nil)
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/2.0.0", "ci-token", []byte("two"))
+	expiry := time.Now().Add(time.Hour)
+	pkgID, _ := h.meta.CreatePackage("app")
+	h.meta.CreateArtifact(pkgID, models.ArtifactInput{Version: "0.1.0", Hash: "h", Size: 1, ExpiresAt: &expiry})
+	if _, err := h.ExpireArtifacts(expiry); err != nil {
+		t.Fatalf("ExpireArtifacts: %v", err)
+	}
+
+	rr := doRequest(t, router, "GET", "/api/v1/packages/app/history?version=1.0.0", "ci-token", nil)
+	var history models.HistoryResponse
+	json.Unmarshal(rr.Body.Bytes(), &history)
+	var got []string
+	for _, e := range history.Events {
+		got = append(got, e.File+":"+e.Action+":"+e.Actor)
+	}
+	want := "notes.txt:delete:ci notes.txt:create:ci :overwrite:ci :delete:config app.zip:delete:config app.zip:create:ci :create:ci"
+	if rr.Code != http.StatusOK || strings.Join(got, " ") != want {
+		t.Errorf("history:\n got  %s\n want %s", strings.Join(got, " "), want)
+	}
+	if e := history.Events[2]; e.OldHash == "" || e.NewHash == "" || e.OldHash == e.NewHash {
+		t.Errorf("overwrite hashes = %+v", e)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/packages/app/history?version=0.1.0", "ci-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &history)
+	if len(history.Events) != 1 || history.Events[0].Action != models.HistoryDelete || history.Events[0].Actor != "expiry" {
+		t.Errorf("expiry history: %s", rr.Body.String())
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/packages/app/history?limit=2", "ci-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &history)
+	if history.NextCursor == "" || history.Events[0].Version != "0.1.0" {
+		t.Errorf("first page: %s", rr.Body.String())
+	}
+
+	// A version that never existed has no history.
+	rr = doRequest(t, router, "GET", "/api/v1/packages/app/history?version=9.9.9", "ci-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &history)
+	if rr.Code != http.StatusOK || len(history.Events) != 0 {
+		t.Errorf("unknown version: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestArchiveContents(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+	}
+	router := h.Router()
+
+	var tarball bytes.Buffer
+	gz := gzip.NewWriter(&tarball)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "bin/tool", Mode: 0o755, Size: 4})
+	tw.Write([]byte("tool"))
+	tw.Close()
+	gz.Close()
+	var wheel bytes.Buffer
+	zw := zip.NewWriter(&wheel)
+	fw, _ := zw.Create("pkg/__init__.py")
+	fw.Write([]byte("x = 1\n"))
+	zw.Close()
+
+	for _, up := range []struct {
+		path string
+		body []byte
+	}{
+		{"/api/v1/artifacts/tool/1.0.0", tarball.Bytes()},
+		{"/api/v1/artifacts/tool/1.0.0/files/tool.whl", wheel.Bytes()},
+		{"/api/v1/artifacts/notes/1.0.0", []byte("plain text")},
+	} {
+		if rr := doRequest(t, router, "POST", up.path, "ci-token", up.body); rr.Code != http.StatusCreated {
+			t.Fatalf("upload %s: %d", up.path, rr.Code)
+		}
+	}
+	// Uploads are indexed as they are stored.
+	sum := sha256.Sum256(tarball.Bytes())
+	if c, _ := h.meta.GetContents(hex.EncodeToString(sum[:])); c == nil || c.Format != "tar.gz" {
+		t.Fatalf("upload was not indexed: %+v", c)
+	}
+
+	rr := doRequest(t, router, "GET", "/api/v1/artifacts/tool/1.0.0/contents", "ci-token", nil)
+	var contents models.Contents
+	json.Unmarshal(rr.Body.Bytes(), &contents)
+	if rr.Code != http.StatusOK || contents.Format != "tar.gz" || len(contents.Entries) != 1 {
+		t.Fatalf("contents: %d %s", rr.Code, rr.Body.String())
+	}
+	if e := contents.Entries[0]; e.Path != "bin/tool" || e.Size != 4 || e.Mode != "-rwxr-xr-x" {
+		t.Errorf("entry = %+v", e)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/tool/1.0.0/files/tool.whl/contents", "ci-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &contents)
+	if rr.Code != http.StatusOK || contents.Format != "zip" || contents.Entries[0].Path != "pkg/__init__.py" {
+		t.Errorf("file contents: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/notes/1.0.0/contents", "ci-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("non-archive: expected 404, got %d", rr.Code)
+	}
+
+	// Versions stored before indexing existed are listed on first request.
+	var plain bytes.Buffer
+	tw = tar.NewWriter(&plain)
+	tw.WriteHeader(&tar.Header{Name: "README", Mode: 0o644, Size: 2})
+	tw.Write([]byte("hi"))
+	tw.Close()
+	hash, size, _ := h.blobs.Store(bytes.NewReader(plain.Bytes()))
+	pkgID, _ := h.meta.CreatePackage("legacy")
+	h.meta.CreateArtifact(pkgID, models.ArtifactInput{Version: "1.0.0", Hash: hash, Size: size})
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/legacy/1.0.0/contents", "ci-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &contents)
+	if rr.Code != http.StatusOK || contents.Format != "tar" || contents.Entries[0].Path != "README" {
+		t.Errorf("lazy indexing: %d %s", rr.Code, rr.Body.String())
+	}
+
+	h.quarantine = true
+	doRequest(t, router, "POST", "/api/v1/artifacts/held/1.0.0", "ci-token", tarball.Bytes())
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/held/1.0.0/contents", "ci-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("quarantined contents: expected 404, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/held/1.0.0/contents", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("admins list quarantined contents: %d", rr.Code)
+	}
+}
+
+func TestExtractContent(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	router := h.Router()
+
+	var bundle bytes.Buffer
+	gz := gzip.NewWriter(&bundle)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755})
+	tw.WriteHeader(&tar.Header{Name: "etc/app.yaml", Mode: 0o644, Size: 9})
+	tw.Write([]byte("port: 80\n"))
+	tw.WriteHeader(&tar.Header{Name: "data.bin", Mode: 0o644, Size: 64})
+	tw.Write(make([]byte, 64))
+	tw.WriteHeader(&tar.Header{Name: "docs/index.html", Mode: 0o644, Size: 26})
+	tw.Write([]byte("<script>alert(1)</script>\n"))
+	tw.Close()
+	gz.Close()
+	var wheel bytes.Buffer
+	zw := zip.NewWriter(&wheel)
+	fw, _ := zw.Create("pkg/__init__.py")
+	fw.Write([]byte("x = 1\n"))
+	zw.Close()
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/deploy/1.0.0", "test-token", bundle.Bytes())
+	doRequest(t, router, "POST", "/api/v1/artifacts/deploy/1.0.0/files/lib.whl", "test-token", wheel.Bytes())
+	doRequest(t, router, "POST", "/api/v1/artifacts/notes/1.0.0", "test-token", []byte("plain text"))
+
+	rr := doRequest(t, router, "GET", "/api/v1/artifacts/deploy/1.0.0/contents/etc/app.yaml", "test-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "port: 80\n" {
+		t.Fatalf("extract: %d %q", rr.Code, rr.Body.String())
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.Contains(cd, `filename=app.yaml`) {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	if cl := rr.Header().Get("Content-Length"); cl != "9" {
+		t.Errorf("Content-Length = %q", cl)
+	}
+
+	// Packed HTML must not render on the registry's origin.
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/deploy/1.0.0/contents/docs/index.html", "test-token", nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/octet-stream" ||
+		rr.Header().Get("X-Content-Type-Options") != "nosniff" || !strings.HasPrefix(rr.Header().Get("Content-Disposition"), "attachment") {
+		t.Errorf("html member: %d %v", rr.Code, rr.Header())
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/deploy/1.0.0/files/lib.whl/contents/pkg/__init__.py", "test-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "x = 1\n" {
+		t.Errorf("extract from file: %d %q", rr.Code, rr.Body.String())
+	}
+
+	for path, want := range map[string]int{
+		"/api/v1/artifacts/deploy/1.0.0/contents/missing.txt": http.StatusNotFound,
+		"/api/v1/artifacts/deploy/1.0.0/contents/etc":         http.StatusBadRequest,
+		"/api/v1/artifacts/deploy/1.0.0/contents/":            http.StatusBadRequest,
+		"/api/v1/artifacts/notes/1.0.0/contents/x":            http.StatusNotFound,
+	} {
+		if rr := doRequest(t, router, "GET", path, "test-token", nil); rr.Code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, rr.Code)
+		}
+	}
+
+	WithTransferLimits(TransferLimits{MaxExtractBytes: 32})(h)
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/deploy/1.0.0/contents/data.bin", "test-token", nil); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("over limit: expected 413, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/deploy/1.0.0/contents/etc/app.yaml", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("under limit: %d", rr.Code)
+	}
+}
+
+func TestArtifactFormats(t *testing.T) {
+	h, router := setupTestHandler(t)
+	h.policy = &policy.Rules{Formats: map[string][]string{"bin-": {"binary"}}, MatchFilenames: true}
+
+	var tarball bytes.Buffer
+	gz := gzip.NewWriter(&tarball)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "app/main.py", Mode: 0o644, Size: 4})
+	tw.Write([]byte("pass"))
+	tw.Close()
+	gz.Close()
+	zipOf := func(names ...string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, name := range names {
+			zw.Create(name)
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	for _, tc := range []struct {
+		path string
+		body []byte
+		want int
+	}{
+		{"/api/v1/artifacts/app/1.0.0", tarball.Bytes(), http.StatusCreated},
+		{"/api/v1/artifacts/app/1.0.0/files/app-1.0-py3-none-any.whl", zipOf("app/main.py", "app-1.0.dist-info/WHEEL"), http.StatusCreated},
+		// A directory zipped up and named as a wheel is refused.
+		{"/api/v1/artifacts/app/1.0.0/files/app-1.0-py3-none-macosx.whl", zipOf("app/main.py"), http.StatusForbidden},
+		{"/api/v1/artifacts/bin-tool/1.0.0", []byte("\x7fELF"), http.StatusCreated},
+		{"/api/v1/artifacts/bin-tool/2.0.0", tarball.Bytes(), http.StatusForbidden},
+	} {
+		rr := doRequest(t, router, "POST", tc.path, "test-token", tc.body)
+		if rr.Code != tc.want {
+			t.Errorf("POST %s: expected %d, got %d: %s", tc.path, tc.want, rr.Code, rr.Body.String())
+		}
+	}
+	if a, _ := h.meta.GetArtifact("bin-tool", "2.0.0"); a != nil {
+		t.Error("an upload refused for its format should not be recorded")
+	}
+
+	rr := doRequest(t, router, "GET", "/api/v1/artifacts?format=tar.gz", "test-token", nil)
+	var list models.ArtifactList
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Artifacts) != 1 || list.Artifacts[0].Package != "app" || list.Artifacts[0].Format != "tar.gz" {
+		t.Errorf("format filter: %s", rr.Body.String())
+	}
+	if a, _ := h.meta.GetArtifact("bin-tool", "1.0.0"); a == nil || a.Format != "binary" {
+		t.Errorf("bin-tool@1.0.0: %+v, want format binary", a)
+	}
+}
+
+func TestArchiveLicenses(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	router := h.Router()
+
+	archive := func(files map[string]string) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for name, body := range files {
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body))})
+			tw.Write([]byte(body))
+		}
+		tw.Close()
+		gz.Close()
+		return buf.Bytes()
+	}
+	gpl := "                    GNU GENERAL PUBLIC LICENSE\n                       Version 3, 29 June 2007\n"
+	for _, up := range []struct {
+		path  string
+		files map[string]string
+	}{
+		{"/api/v1/artifacts/app/1.0.0", map[string]string{
+			"app-1.0.0/package.json": `{"name": "app", "license": "(MIT OR Apache-2.0)"}`,
+			"app-1.0.0/index.js":     "module.exports = 1\n",
+		}},
+		{"/api/v1/artifacts/app/2.0.0", map[string]string{
+			"app-2.0.0/COPYING": gpl,
+			// Vendored dependencies do not license the package.
+			"app-2.0.0/vendor/dep/LICENSE": "SPDX-License-Identifier: BSD-3-Clause\n",
+		}},
+		{"/api/v1/artifacts/tool/1.0.0", map[string]string{"bin/tool": "tool"}},
+	} {
+		if rr := doRequest(t, router, "POST", up.path, "test-token", archive(up.files)); rr.Code != http.StatusCreated {
+			t.Fatalf("upload %s: %d %s", up.path, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := doRequest(t, router, "GET", "/api/v1/packages/app", "test-token", nil)
+	var info models.PackageInfo
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	licenses := map[string][]string{}
+	for _, v := range info.Versions {
+		licenses[v.Version] = v.Licenses
+	}
+	if !slices.Equal(licenses["1.0.0"], []string{"Apache-2.0", "MIT"}) || !slices.Equal(licenses["2.0.0"], []string{"GPL-3.0"}) {
+		t.Errorf("licenses = %v", licenses)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/2.0.0/contents", "test-token", nil)
+	var contents models.Contents
+	json.Unmarshal(rr.Body.Bytes(), &contents)
+	if len(contents.Licenses) != 1 || contents.Licenses[0] != (models.DetectedLicense{License: "GPL-3.0", Path: "app-2.0.0/COPYING"}) {
+		t.Errorf("contents licenses = %+v", contents.Licenses)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts?license=gpl-3.0", "test-token", nil)
+	var list models.ArtifactList
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if rr.Code != http.StatusOK || len(list.Artifacts) != 1 || list.Artifacts[0].Version != "2.0.0" {
+		t.Errorf("?license=gpl-3.0: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/packages/app?license=MIT", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	if len(info.Versions) != 1 || info.Versions[0].Version != "1.0.0" {
+		t.Errorf("package ?license=MIT: %s", rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts?license=BSD-3-Clause", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Artifacts) != 0 {
+		t.Errorf("?license=BSD-3-Clause matched %s", rr.Body.String())
+	}
+}
+
+func TestRouteSets(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	public := h.RouterFor(RoutesPublic)
+	admin := h.RouterFor(RoutesAdmin)
+
+	if rr := doRequest(t, public, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("data")); rr.Code != http.StatusCreated {
+		t.Fatalf("public upload: %d", rr.Code)
+	}
+	if rr := doRequest(t, public, "GET", "/api/v1/admin/stats", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("admin route on public listener: expected 404, got %d", rr.Code)
+	}
+	if rr := doRequest(t, admin, "GET", "/api/v1/admin/stats", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("admin route on admin listener: %d", rr.Code)
+	}
+	if rr := doRequest(t, admin, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("package route on admin listener: expected 404, got %d", rr.Code)
+	}
+	if rr := doRequest(t, admin, "GET", "/api/v1/admin/stats", "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("admin listener still requires auth: %d", rr.Code)
+	}
+}
+
+// recordingPolicy allows everything and remembers the last request.
+type recordingPolicy struct {
+	last models.PolicyRequest
+}
+
+func (p *recordingPolicy) Evaluate(_ context.Context, req models.PolicyRequest) error {
+	p.last = req
+	return nil
+}
+
+func TestClientIP(t *testing.T) {
+	h, router := setupTestHandler(t)
+	h.trustedProxies = []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+
+	for _, tc := range []struct {
+		name, remote   string
+		forwarded, xri string
+		want           string
+	}{
+		{"direct client", "203.0.113.7:5000", "", "", "203.0.113.7"},
+		{"untrusted peer forging headers", "203.0.113.7:5000", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:5000", "198.51.100.1", "", "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:5000", "198.51.100.1, 10.1.1.1, 10.2.2.2", "", "198.51.100.1"},
+		{"spoofed leftmost entry", "10.0.0.2:5000", "1.2.3.4, 198.51.100.1", "", "198.51.100.1"},
+		{"real ip header", "10.0.0.2:5000", "", "198.51.100.3", "198.51.100.3"},
+		{"garbage hop", "10.0.0.2:5000", "not-an-ip, 10.1.1.1", "", "10.1.1.1"},
+		{"ipv6 proxy", "[2001:db8::1]:5000", "2001:db8:ffff::9, 198.51.100.4", "", "198.51.100.4"},
+		{"ipv4-mapped peer", "[::ffff:10.0.0.2]:5000", "198.51.100.5", "", "198.51.100.5"},
+	} {
+		req := httptest.NewRequest("GET", "/health", nil)
+		req.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		if tc.xri != "" {
+			req.Header.Set("X-Real-IP", tc.xri)
+		}
+		if got := h.clientIP(req); got != tc.want {
+			t.Errorf("%s: clientIP = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	// Unix socket peers have no address and are trusted only on sockets
+	// listed as such.
+	req := httptest.NewRequest("GET", "/health", nil)
+	req.RemoteAddr = "@"
+	if got := h.clientIP(req); got != "@" {
+		t.Errorf("unix peer without headers: clientIP = %q", got)
+	}
+	req.Header.Set("X-Forwarded-For", "198.51.100.6")
+	if got := h.clientIP(req); got != "@" {
+		t.Errorf("TCP peers that are not addresses are untrusted: clientIP = %q", got)
+	}
+	ctx := context.WithValue(req.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/foundry.sock", Net: "unix"})
+	if got := h.clientIP(req.WithContext(ctx)); got != "@" {
+		t.Errorf("untrusted unix peer: clientIP = %q, want @", got)
+	}
+	h.trustedSockets = []string{"/run/foundry.sock"}
+	if got := h.clientIP(req.WithContext(ctx)); got != "198.51.100.6" {
+		t.Errorf("trusted unix peer: clientIP = %q, want 198.51.100.6", got)
+	}
+
+	// The resolved address reaches the policy engine.
+	recorder := &recordingPolicy{}
+	h.policy = recorder
+	req = httptest.NewRequest("POST", "/api/v1/artifacts/app/1.0.0", strings.NewReader("data"))
+	req.Header.Set("Authorization", "test-token")
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	req.RemoteAddr = "10.0.0.2:5000"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rr.Code, rr.Body.String())
+	}
+	if recorder.last.ClientIP != "198.51.100.9" {
+		t.Errorf("policy saw client IP %q, want 198.51.100.9", recorder.last.ClientIP)
+	}
+}
+
+func TestDependentsLinkHeader(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.basePath = "/foundry"
+	router := h.Router()
+	for _, app := range []string{"a", "b"} {
+		if rr := doRequest(t, router, "POST", "/foundry/api/v1/artifacts/"+app+"/1.0.0", "test-token", []byte(app)); rr.Code != http.StatusCreated {
+			t.Fatalf("upload %s: %d", app, rr.Code)
+		}
+		doRequest(t, router, "PUT", "/foundry/api/v1/artifacts/"+app+"/1.0.0/dependencies", "test-token",
+			[]byte(`{"dependencies":[{"package":"lib","constraint":"^1.0"}]}`))
+	}
+
+	rr := doRequest(t, router, "GET", "/foundry/api/v1/packages/lib/dependents?limit=1", "test-token", nil)
+	var page models.DependentsResponse
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	want := `<http://example.com/foundry/api/v1/packages/lib/dependents?cursor=` + page.NextCursor + `&limit=1>; rel="next"`
+	if page.NextCursor == "" || rr.Header().Get("Link") != want {
+		t.Fatalf("Link = %q, want %q", rr.Header().Get("Link"), want)
+	}
+
+	rr = doRequest(t, router, "GET", "/foundry/api/v1/packages/lib/dependents?limit=1&cursor="+page.NextCursor, "test-token", nil)
+	if link := rr.Header().Get("Link"); link != "" {
+		t.Errorf("last page should have no Link header, got %q", link)
+	}
+}
+
+func TestIfMatchRevision(t *testing.T) {
+	h, router := setupTestHandler(t)
+
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("app")); rr.Code != http.StatusCreated {
+		t.Fatalf("upload: %d", rr.Code)
+	}
+	withIfMatch := func(method, path, ifMatch string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := doRequest(t, router, "HEAD", "/api/v1/artifacts/app/1.0.0", "test-token", nil)
+	if got := rr.Header().Get("X-Artifact-Revision"); got != "1" {
+		t.Fatalf("new version: expected revision 1, got %q", got)
+	}
+
+	// Pinning at the current revision advances it; a second client still
+	// holding revision 1 is refused.
+	rr = withIfMatch("PUT", "/api/v1/artifacts/app/1.0.0/pin", `"1"`, nil)
+	var pinned models.Artifact
+	json.Unmarshal(rr.Body.Bytes(), &pinned)
+	if rr.Code != http.StatusOK || pinned.Revision != 2 {
+		t.Fatalf("pin at revision 1: got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = withIfMatch("PUT", "/api/v1/artifacts/app/1.0.0/dependencies", `"1"`, []byte(`{"dependencies":[]}`))
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale revision: expected 412, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("X-Artifact-Revision"); got != "2" {
+		t.Errorf("412 should report the current revision, got %q", got)
+	}
+	if rr := withIfMatch("PUT", "/api/v1/artifacts/app/1.0.0/dependencies", `"5", "2"`, []byte(`{"dependencies":[]}`)); rr.Code != http.StatusOK {
+		t.Errorf("list including the current revision: expected 200, got %d", rr.Code)
+	}
+	if rr := withIfMatch("POST", "/api/v1/artifacts/app/1.0.0/files/notes.txt", `"2"`, []byte("notes")); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("file upload at stale revision: expected 412, got %d", rr.Code)
+	}
+	if rr := withIfMatch("POST", "/api/v1/artifacts/app/1.0.0/files/notes.txt", `"3"`, []byte("notes")); rr.Code != http.StatusCreated {
+		t.Errorf("file upload at revision 3: expected 201, got %d", rr.Code)
+	}
+	if rr := withIfMatch("DELETE", "/api/v1/artifacts/app/1.0.0/pin", "*", nil); rr.Code != http.StatusOK {
+		t.Errorf("unpin with *: expected 200, got %d", rr.Code)
+	}
+	if rr := withIfMatch("DELETE", "/api/v1/artifacts/app/1.0.0", `"4"`, nil); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("delete at stale revision: expected 412, got %d", rr.Code)
+	}
+	if rr := withIfMatch("DELETE", "/api/v1/artifacts/app/2.0.0", `"1"`, nil); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-Match on a missing version: expected 412, got %d", rr.Code)
+	}
+
+	// Once required, changes without the header are refused, but new
+	// versions can still be created.
+	h.requireIfMatch = true
+	if rr := withIfMatch("PUT", "/api/v1/artifacts/app/1.0.0/pin", "", nil); rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("missing If-Match: expected 428, got %d", rr.Code)
+	}
+	if rr := withIfMatch("POST", "/api/v1/artifacts/app/2.0.0/files/app.bin", "", []byte("v2")); rr.Code != http.StatusCreated {
+		t.Errorf("new version without If-Match: expected 201, got %d", rr.Code)
+	}
+	if rr := withIfMatch("DELETE", "/api/v1/artifacts/app/1.0.0", `"5"`, nil); rr.Code != http.StatusOK {
+		t.Errorf("delete at current revision: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// failingArtifactStore refuses to record new versions.
+type failingArtifactStore struct {
+	services.MetadataStore
+}
+
+func (failingArtifactStore) CreateArtifactForPackage(string, models.ArtifactInput) (*models.Artifact, error) {
+	return nil, errors.New("disk full")
+}
+
+// failingCommitStorage stages blobs normally but cannot publish them.
+type failingCommitStorage struct {
+	*storage.DiskBlobStorage
+}
+
+func (s failingCommitStorage) Stage(r io.Reader) (services.StagedBlob, error) {
+	staged, err := s.DiskBlobStorage.Stage(r)
+	return failingCommit{staged}, err
+}
+
+type failingCommit struct {
+	services.StagedBlob
+}
+
+func (failingCommit) Commit() error { return errors.New("rename failed") }
+
+func TestUploadArtifactCommitsBlobAfterMetadata(t *testing.T) {
+	h, router := setupTestHandler(t)
+	blobs := h.blobs.(*storage.DiskBlobStorage)
+	meta := h.meta
+
+	// Metadata that cannot be recorded leaves no blob behind.
+	h.meta = failingArtifactStore{meta}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("app")); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("failed metadata insert: expected 500, got %d", rr.Code)
+	}
+	if hashes, _ := blobs.ListBlobs(); len(hashes) != 0 {
+		t.Errorf("blob should be discarded with its metadata, found %v", hashes)
+	}
+
+	// A blob that cannot be committed takes its metadata with it.
+	h.meta = meta
+	h.blobs = failingCommitStorage{blobs}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("app")); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("failed commit: expected 500, got %d", rr.Code)
+	}
+	if a, _ := meta.GetArtifact("app", "1.0.0"); a != nil {
+		t.Errorf("metadata should be removed when the blob is not committed: %+v", a)
+	}
+	if hashes, _ := blobs.ListBlobs(); len(hashes) != 0 {
+		t.Errorf("uncommitted blob should not be stored, found %v", hashes)
+	}
+
+	// A rejected digest discards the upload too.
+	h.blobs = blobs
+	req := httptest.NewRequest("POST", "/api/v1/artifacts/app/1.0.0", strings.NewReader("app"))
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("X-Artifact-Hash", strings.Repeat("0", 64))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("digest mismatch: expected 400, got %d", rr.Code)
+	}
+	if hashes, _ := blobs.ListBlobs(); len(hashes) != 0 {
+		t.Errorf("mismatched blob should be discarded, found %v", hashes)
+	}
+
+	// The version can be uploaded once storage recovers.
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("app")); rr.Code != http.StatusCreated {
+		t.Fatalf("upload: expected 201, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Body.String() != "app" {
+		t.Errorf("download: got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+// recordingNotifier delivers notifications to a channel.
+type recordingNotifier chan string
+
+func (recordingNotifier) Validate(sub models.Subscription) error {
+	if sub.Channel != models.ChannelWebhook {
+		return fmt.Errorf("unsupported channel %q", sub.Channel)
+	}
+	return nil
+}
+
+func (n recordingNotifier) Notify(_ context.Context, sub models.Subscription, note models.Notification) error {
+	n <- fmt.Sprintf("%s %s %s@%s by %s", sub.Target, note.Event, note.Package, note.Version, note.Actor)
+	return nil
+}
+
+func TestNotifications(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+		"web-token":  {TokenID: 3, Name: "web"},
+	}
+	h.defaultStage = models.StageStaging
+	notes := make(recordingNotifier, 10)
+	WithNotifications(h.meta.(services.SubscriptionStore), notes)(h)
+	router := h.Router()
+
+	subscribe := func(token, body string) *httptest.ResponseRecorder {
+		return doRequest(t, router, "POST", "/api/v1/subscriptions", token, []byte(body))
+	}
+	rr := subscribe("ci-token", `{"package":"lib-*","channel":"webhook","target":"lib-hook"}`)
+	var created models.Subscription
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if rr.Code != http.StatusCreated || created.Secret == "" || created.CreatedBy != "ci" {
+		t.Fatalf("subscribe: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := subscribe("web-token", `{"package":"web","events":["promote"],"channel":"webhook","target":"web-hook","secret":"s"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("subscribe to promotions: %d %s", rr.Code, rr.Body.String())
+	}
+	for _, body := range []string{
+		`{"package":"","channel":"webhook","target":"x"}`,
+		`{"package":"a*b","channel":"webhook","target":"x"}`,
+		`{"package":"lib","events":["download"],"channel":"webhook","target":"x"}`,
+		`{"package":"lib","channel":"pager","target":"x"}`,
+	} {
+		if rr := subscribe("ci-token", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("subscribe %s: expected 400, got %d", body, rr.Code)
+		}
+	}
+
+	// Each caller lists their own subscriptions, without secrets; admins
+	// list all of them.
+	var subs []models.Subscription
+	rr = doRequest(t, router, "GET", "/api/v1/subscriptions", "ci-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &subs)
+	if len(subs) != 1 || subs[0].Package != "lib-*" || subs[0].Secret != "" {
+		t.Errorf("ci subscriptions: %s", rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/subscriptions", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &subs)
+	if len(subs) != 2 {
+		t.Errorf("admin subscriptions: %s", rr.Body.String())
+	}
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/lib-core/1.0.0", "ci-token", []byte("lib"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/other/1.0.0", "ci-token", []byte("other"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/web/1.0.0", "web-token", []byte("web"))
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/web/1.0.0/promote", "test-token", nil); rr.Code != http.StatusOK {
+		t.Fatalf("promote: %d %s", rr.Code, rr.Body.String())
+	}
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/lib-core/1.0.0", "test-token", nil)
+	var got []string
+	for range 3 {
+		select {
+		case n := <-notes:
+			got = append(got, n)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for notifications; got %q", got)
+		}
+	}
+	slices.Sort(got)
+	want := []string{
+		"lib-hook create lib-core@1.0.0 by ci",
+		"lib-hook delete lib-core@1.0.0 by config",
+		"web-hook promote web@1.0.0 by config",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("notifications %q, want %q", got, want)
+	}
+	select {
+	case n := <-notes:
+		t.Errorf("unexpected notification %q", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Others' subscriptions cannot be deleted, except by admins.
+	if rr := doRequest(t, router, "DELETE", fmt.Sprintf("/api/v1/subscriptions/%d", created.ID), "web-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("delete another's subscription: expected 404, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "DELETE", fmt.Sprintf("/api/v1/subscriptions/%d", created.ID), "ci-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("delete own subscription: expected 200, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "DELETE", fmt.Sprintf("/api/v1/subscriptions/%d", created.ID), "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("delete deleted subscription: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestDownloadReport(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+	}
+	router := h.Router()
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("app binary"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/lib/2.0.0", "test-token", []byte("lib"))
+	doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "ci-token", nil)
+	doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil)
+	doRequest(t, router, "GET", "/api/v1/artifacts/lib/2.0.0", "ci-token", nil)
+	// Resuming a download adds its bytes without counting another download.
+	req := httptest.NewRequest("GET", "/api/v1/artifacts/app/1.0.0", nil)
+	req.Header.Set("Authorization", "Bearer ci-token")
+	req.Header.Set("Range", "bytes=4-")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if rr := doRequest(t, router, "GET", "/api/v1/admin/reports/downloads", "ci-token", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("non-admin report: expected 403, got %d", rr.Code)
+	}
+
+	var report models.DownloadReport
+	rr := doRequest(t, router, "GET", "/api/v1/admin/reports/downloads", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	want := []models.DownloadStat{{Package: "app", Downloads: 2, Bytes: 26}, {Package: "lib", Downloads: 1, Bytes: 3}}
+	if rr.Code != http.StatusOK || !slices.Equal(report.Rows, want) || report.TotalDownloads != 3 || report.TotalBytes != 29 {
+		t.Errorf("report by package: %d %s", rr.Code, rr.Body.String())
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	if report.To != today || !slices.Equal(report.GroupBy, []string{"package"}) {
+		t.Errorf("report range %s..%s grouped by %v", report.From, report.To, report.GroupBy)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/admin/reports/downloads?group_by=principal,version&format=csv&from="+today, "test-token", nil)
+	wantCSV := "package,version,principal,downloads,bytes\n" +
+		"app,1.0.0,ci,1,16\n" +
+		"app,1.0.0,config,1,10\n" +
+		"lib,2.0.0,ci,1,3\n"
+	if rr.Code != http.StatusOK || rr.Body.String() != wantCSV || rr.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("CSV report: %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/admin/reports/downloads?from=2000-01-01&to=2000-01-31", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	if rr.Code != http.StatusOK || len(report.Rows) != 0 || report.TotalDownloads != 0 {
+		t.Errorf("report before any downloads: %d %s", rr.Code, rr.Body.String())
+	}
+	for _, query := range []string{"from=June", "to=2024-13-01", "from=2024-06-02&to=2024-06-01", "group_by=team", "format=xml"} {
+		if rr := doRequest(t, router, "GET", "/api/v1/admin/reports/downloads?"+query, "test-token", nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	h, router := setupTestHandler(t)
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/team-app/1.0.0", "test-token", []byte("shared"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/team-app/1.1.0", "test-token", []byte("shared"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/team-lib/1.0.0", "test-token", []byte("shared"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/tool/1.0.0", "test-token", []byte("a bigger tool"))
+
+	var report models.UsageReport
+	rr := doRequest(t, router, "GET", "/api/v1/admin/usage", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	wantPackages := []models.Usage{
+		{Name: "tool", Files: 1, LogicalBytes: 13, Blobs: 1, PhysicalBytes: 13},
+		{Name: "team-app", Files: 2, LogicalBytes: 12, Blobs: 1, PhysicalBytes: 6},
+		{Name: "team-lib", Files: 1, LogicalBytes: 6, Blobs: 1, PhysicalBytes: 6},
+	}
+	wantNamespaces := []models.Usage{
+		{Name: "tool", Files: 1, LogicalBytes: 13, Blobs: 1, PhysicalBytes: 13},
+		{Name: "team", Files: 3, LogicalBytes: 18, Blobs: 1, PhysicalBytes: 6},
+	}
+	wantTotal := models.Usage{Files: 4, LogicalBytes: 31, Blobs: 2, PhysicalBytes: 19}
+	if rr.Code != http.StatusOK || !slices.Equal(report.Packages, wantPackages) ||
+		!slices.Equal(report.Namespaces, wantNamespaces) || report.Total != wantTotal {
+		t.Errorf("usage: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Deleting a version gives its share back.
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/team-app/1.0.0", "test-token", nil)
+	rr = doRequest(t, router, "GET", "/api/v1/admin/usage?limit=1", "test-token", nil)
+	report = models.UsageReport{}
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	if len(report.Packages) != 1 || report.Packages[0].Name != "tool" || report.Total.Files != 3 || report.Total.LogicalBytes != 25 {
+		t.Errorf("usage after delete: %d %s", rr.Code, rr.Body.String())
+	}
+
+	h.auth = principalAuth{"ci-token": {TokenID: 2, Name: "ci"}}
+	if rr := doRequest(t, router, "GET", "/api/v1/admin/usage", "ci-token", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("non-admin usage: expected 403, got %d", rr.Code)
+	}
+}
+
+// lowSpaceStorage is disk blob storage reporting a made-up free space.
+type lowSpaceStorage struct {
+	*storage.DiskBlobStorage
+	free int64
+}
+
+func (s *lowSpaceStorage) FreeSpace() (int64, error) { return s.free, nil }
+
+// shrinkingReader reports the disk filling up once it has been read past
+// after bytes.
+type shrinkingReader struct {
+	r     io.Reader
+	after int64
+	read  int64
+	store *lowSpaceStorage
+}
+
+func (r *shrinkingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	if r.read > r.after {
+		r.store.free = 0
+	}
+	return n, err
+}
+
+func TestStorageReserve(t *testing.T) {
+	h, router := setupTestHandler(t)
+	dir := t.TempDir()
+	disk, err := storage.NewDiskBlobStorage(dir)
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	blobs := &lowSpaceStorage{DiskBlobStorage: disk, free: 500}
+	h.blobs = blobs
+	h.storageReserve = 1000
+
+	assertStored := func(what string, want int) {
+		t.Helper()
+		if hashes, _ := blobs.ListBlobs(); len(hashes) != want {
+			t.Errorf("%s: expected %d blobs, got %v", what, want, hashes)
+		}
+		if tmp, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(tmp) != 0 {
+			t.Errorf("%s: temp files left behind: %d", what, len(tmp))
+		}
+	}
+
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("app"))
+	if rr.Code != http.StatusInsufficientStorage {
+		t.Errorf("upload below the reserve: expected 507, got %d", rr.Code)
+	}
+	assertStored("below the reserve", 0)
+
+	// A declared length that would cut into the reserve is refused unread.
+	blobs.free = 1010
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("twenty bytes of data")); rr.Code != http.StatusInsufficientStorage {
+		t.Errorf("upload larger than the space left: expected 507, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("app")); rr.Code != http.StatusCreated {
+		t.Errorf("upload that fits: expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Filling up partway through an upload stops it.
+	blobs.free = 1 << 30
+	const size = 3 * spaceCheckInterval
+	body := &shrinkingReader{r: bytes.NewReader(make([]byte, size)), after: spaceCheckInterval / 2, store: blobs}
+	req := httptest.NewRequest("POST", "/api/v1/artifacts/app/2.0.0", body)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInsufficientStorage {
+		t.Errorf("disk filling during upload: expected 507, got %d", rr.Code)
+	}
+	if body.read >= size {
+		t.Errorf("upload read to the end (%d bytes) after the disk filled", body.read)
+	}
+	assertStored("disk filling during upload", 1)
+
+	var stats models.RegistryStats
+	rr = doRequest(t, router, "GET", "/api/v1/admin/stats", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+	if stats.FreeBytes == nil || *stats.FreeBytes != 0 || stats.ReserveBytes != 1000 {
+		t.Errorf("stats: %s", rr.Body.String())
+	}
+
+	// A full disk answers 507 even without a reserve.
+	if status, _ := h.storeFailure(fmt.Errorf("streaming to file: %w", &os.PathError{Op: "write", Path: "x", Err: syscall.ENOSPC})); status != http.StatusInsufficientStorage {
+		t.Errorf("ENOSPC: expected 507, got %d", status)
+	}
+}
+
+func TestCleanTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := storage.NewDiskBlobStorage(dir)
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	h := New(blobs, metadata.NewMemoryStore(), auth.NewTokenAuth([]string{"test-token"}), zerolog.Nop())
+
+	blobs.Stage(strings.NewReader("crashed upload"))
+	stale, _ := filepath.Glob(filepath.Join(dir, "tmp", "upload-*"))
+	longAgo := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(stale[0], longAgo, longAgo)
+
+	files, reclaimed, err := h.CleanTempFiles(time.Now().Add(-24 * time.Hour))
+	if err != nil || files != 1 || reclaimed != 14 {
+		t.Errorf("CleanTempFiles = %d, %d, %v; want 1 file of 14 bytes", files, reclaimed, err)
+	}
+
+	// Backends without temp files have nothing to clean.
+	h.blobs = storage.NewMemoryBlobStorage()
+	if files, _, err := h.CleanTempFiles(time.Now()); err != nil || files != 0 {
+		t.Errorf("memory storage: %d files, %v", files, err)
+	}
+}
+
+func TestQueryMetrics(t *testing.T) {
+	h, router := setupTestHandler(t)
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/mylib/1.0.0", "test-token", []byte("data"))
+	doRequest(t, router, "GET", "/api/v1/artifacts/mylib/1.0.0", "test-token", nil)
+
+	var stats []models.QueryStat
+	rr := doRequest(t, router, "GET", "/api/v1/admin/metrics/queries", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+	if rr.Code != http.StatusOK || !slices.ContainsFunc(stats, func(st models.QueryStat) bool {
+		return st.Label == "GetArtifact" && st.Calls > 0
+	}) {
+		t.Fatalf("query metrics: %d %s", rr.Code, rr.Body.String())
+	}
+	for i := 1; i < len(stats); i++ {
+		if stats[i].TotalMicros > stats[i-1].TotalMicros {
+			t.Errorf("query metrics not sorted by total time: %s", rr.Body.String())
+			break
+		}
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/admin/metrics/queries?limit=1", "test-token", nil)
+	stats = nil
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+	if len(stats) != 1 {
+		t.Errorf("query metrics with limit: %s", rr.Body.String())
+	}
+
+	h.meta = metadata.NewMemoryStore()
+	if rr := doRequest(t, router, "GET", "/api/v1/admin/metrics/queries", "test-token", nil); rr.Code != http.StatusNotImplemented {
+		t.Errorf("memory store: expected 501, got %d", rr.Code)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	h, router := setupTestHandler(t)
+	doRequest(t, router, "POST", "/api/v1/artifacts/mylib/1.0.0", "test-token", []byte("data"))
+
+	for _, tc := range []struct {
+		method, path, want string
+	}{
+		{"GET", "/api/v1/artifacts/mylib/1.0.0", "private, max-age=60"},
+		{"HEAD", "/api/v1/artifacts/mylib/1.0.0", "private, max-age=60"},
+		{"GET", "/api/v1/packages", "private, max-age=30"},
+		{"GET", "/api/v1/packages/mylib", "private, max-age=30"},
+		{"GET", "/api/v1/artifacts/mylib/1.0.0/files", "private, max-age=30"},
+		{"GET", "/api/v1/artifacts/mylib/2.0.0", "no-store"},
+		{"GET", "/api/v1/packages/mylib/history", "no-store"},
+		{"GET", "/api/v1/admin/stats", "no-store"},
+	} {
+		rr := doRequest(t, router, tc.method, tc.path, "test-token", nil)
+		if got := rr.Header().Get("Cache-Control"); got != tc.want {
+			t.Errorf("%s %s: Cache-Control %q, want %q", tc.method, tc.path, got, tc.want)
+		}
+		if vary := rr.Header().Get("Vary"); tc.want != "no-store" && vary != "Authorization" {
+			t.Errorf("%s %s: Vary %q, want Authorization", tc.method, tc.path, vary)
+		}
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/packages", "bad-token", nil); rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("unauthorized: Cache-Control %q", rr.Header().Get("Cache-Control"))
+	}
+
+	// Version URLs are revalidated by the blob's ETag, which changes when
+	// the version is replaced.
+	rr := doRequest(t, router, "GET", "/api/v1/artifacts/mylib/1.0.0", "test-token", nil)
+	etag := rr.Header().Get("ETag")
+	for _, method := range []string{"GET", "HEAD"} {
+		req := httptest.NewRequest(method, "/api/v1/artifacts/mylib/1.0.0", nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("If-None-Match", etag)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 || rr.Header().Get("ETag") != etag {
+			t.Errorf("%s revalidation: %d %q", method, rr.Code, rr.Body.String())
+		}
+	}
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/mylib/1.0.0", "test-token", nil)
+	doRequest(t, router, "POST", "/api/v1/artifacts/mylib/1.0.0", "test-token", []byte("replaced"))
+	req := httptest.NewRequest("GET", "/api/v1/artifacts/mylib/1.0.0", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "replaced" {
+		t.Errorf("revalidating a replaced version: %d %q", rr.Code, rr.Body.String())
+	}
+
+	WithCaching(true, time.Hour, 0)(h)
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/mylib/1.0.0", "test-token", nil)
+	if got := rr.Header().Get("Cache-Control"); got != "public, max-age=3600" {
+		t.Errorf("public download: Cache-Control %q", got)
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/packages", "test-token", nil)
+	if got := rr.Header().Get("Cache-Control"); got != "no-store" || rr.Header().Get("Vary") != "" {
+		t.Errorf("listing with zero age: Cache-Control %q, Vary %q", got, rr.Header().Get("Vary"))
+	}
+}
+
+func TestCDNRedirects(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	fc := clock.NewFake(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	WithClock(fc)(h)
+	WithCDN(cdn.NewSigner("https://cdn.example.com/foundry", []byte("0123456789abcdef0123456789abcdef"), fc))(h)
+	router := h.Router()
+
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/mylib/1.0.0", "test-token", []byte("data"))
+	var artifact models.Artifact
+	json.Unmarshal(rr.Body.Bytes(), &artifact)
+
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/mylib/1.0.0?redirect=true", "test-token", nil)
+	location := rr.Header().Get("Location")
+	prefix := "https://cdn.example.com/foundry/cdn/blobs/" + artifact.Hash + "?expires="
+	if rr.Code != http.StatusTemporaryRedirect || !strings.HasPrefix(location, prefix) {
+		t.Fatalf("redirect: %d %q", rr.Code, location)
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("redirect Cache-Control %q", rr.Header().Get("Cache-Control"))
+	}
+
+	// The CDN fetches the blob from the registry with the signed URL alone.
+	origin := strings.TrimPrefix(location, "https://cdn.example.com/foundry")
+	rr = doRequest(t, router, "GET", origin, "", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "data" {
+		t.Fatalf("origin fetch: %d %s", rr.Code, rr.Body.String())
+	}
+	// The CDN keeps the blob only until the URL expires.
+	u, _ := url.Parse(origin)
+	expires, _ := strconv.ParseInt(u.Query().Get("expires"), 10, 64)
+	want := fmt.Sprintf("public, max-age=%d", expires-fc.Now().Unix())
+	if got := rr.Header().Get("Cache-Control"); got != want {
+		t.Errorf("origin fetch Cache-Control %q, want %q", got, want)
+	}
+	fc.Set(time.Unix(expires, 0))
+	rr = doRequest(t, router, "GET", origin, "", nil)
+	if got := rr.Header().Get("Cache-Control"); rr.Code != http.StatusOK || got != "public, max-age=0" {
+		t.Errorf("origin fetch at expiry: %d, Cache-Control %q", rr.Code, got)
+	}
+	tampered := origin[:len(origin)-1] + "0"
+	if tampered == origin {
+		tampered = origin[:len(origin)-1] + "1"
+	}
+	for _, path := range []string{
+		"/cdn/blobs/" + artifact.Hash,
+		tampered,
+		strings.Replace(origin, artifact.Hash, strings.Repeat("0", 64), 1),
+	} {
+		if rr := doRequest(t, router, "GET", path, "", nil); rr.Code != http.StatusForbidden {
+			t.Errorf("GET %s: expected 403, got %d", path, rr.Code)
+		}
+	}
+
+	// Everything else still needs a token.
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/mylib/2.0.0", "", []byte("data")); rr.Code != http.StatusUnauthorized {
+		t.Errorf("upload without a token: expected 401, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/no/such/route", "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("unknown route without a token: expected 401, got %d", rr.Code)
+	}
+	if rr := doRequest(t, h.RouterFor(RoutesAdmin), "GET", origin, "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("origin fetch on the admin listener: expected 401, got %d", rr.Code)
+	}
+}
+
+// fakePeer is a peer registry answering searches with search.
+type fakePeer struct {
+	name   string
+	search func(ctx context.Context, query string) ([]models.Package, error)
+}
+
+func (p *fakePeer) Name() string { return p.name }
+
+func (p *fakePeer) SearchPackages(ctx context.Context, query string) ([]models.Package, error) {
+	return p.search(ctx, query)
+}
+
+func TestFederatedSearch(t *testing.T) {
+	h, router := setupTestHandler(t)
+	doRequest(t, router, "POST", "/api/v1/artifacts/mylib/1.0.0", "test-token", []byte("data"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/other/1.0.0", "test-token", []byte("data"))
+
+	// Without peers the search covers this registry alone.
+	rr := doRequest(t, router, "GET", "/api/v1/search?q=lib", "test-token", nil)
+	var resp models.SearchResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || len(resp.Results) != 1 || resp.Results[0].Name != "mylib" ||
+		!slices.Equal(resp.Results[0].Registries, []string{"local"}) || resp.Partial {
+		t.Fatalf("local search: %d %s", rr.Code, rr.Body.String())
+	}
+
+	var gotQuery string
+	eu := &fakePeer{name: "eu", search: func(_ context.Context, query string) ([]models.Package, error) {
+		gotQuery = query
+		return []models.Package{{ID: 5, Name: "mylib"}, {ID: 6, Name: "alib"}}, nil
+	}}
+	down := &fakePeer{name: "down", search: func(context.Context, string) ([]models.Package, error) {
+		return nil, errors.New("connection refused")
+	}}
+	slow := &fakePeer{name: "slow", search: func(ctx context.Context, _ string) ([]models.Package, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	WithFederation("us", []services.PeerRegistry{eu, down, slow}, 50*time.Millisecond)(h)
+
+	rr = doRequest(t, router, "GET", "/api/v1/search?q=lib", "test-token", nil)
+	resp = models.SearchResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || gotQuery != "lib" {
+		t.Fatalf("federated search: %d %s (peer query %q)", rr.Code, rr.Body.String(), gotQuery)
+	}
+	want := []models.SearchResult{
+		{Name: "alib", Registries: []string{"eu"}},
+		{Name: "mylib", Registries: []string{"us", "eu"}},
+	}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("expected %d results, got %+v", len(want), resp.Results)
+	}
+	for i, w := range want {
+		if got := resp.Results[i]; got.Name != w.Name || !slices.Equal(got.Registries, w.Registries) {
+			t.Errorf("result %d: expected %+v, got %+v", i, w, got)
+		}
+	}
+	if !resp.Partial || len(resp.Errors) != 2 ||
+		resp.Errors[0].Registry != "down" || resp.Errors[0].Error != "connection refused" ||
+		resp.Errors[1].Registry != "slow" || !strings.Contains(resp.Errors[1].Error, "50ms") {
+		t.Errorf("expected down and slow to be reported, got partial=%v %+v", resp.Partial, resp.Errors)
+	}
+	if got := rr.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("partial results Cache-Control %q", got)
+	}
+
+	// Without a query every package is listed.
+	WithFederation("us", []services.PeerRegistry{eu}, time.Second)(h)
+	rr = doRequest(t, router, "GET", "/api/v1/search", "test-token", nil)
+	resp = models.SearchResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Results) != 3 || resp.Partial || gotQuery != "" {
+		t.Errorf("listing: %s (peer query %q)", rr.Body.String(), gotQuery)
+	}
+}
+
+func TestPackageOwnership(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	store := h.meta.(*metadata.SQLiteStore)
+	WithAccounts(store)(h)
+	WithTokenStore(store)(h)
+	h.auth = auth.Chain{principalAuth{
+		"test-token":    {Name: "config", Admin: true},
+		"mallory-token": {Name: "mallory-ci", User: "mallory"},
+		"sso-token":     {Name: "sso", User: "sam", Groups: []string{"eng-platform"}},
+	}, auth.NewStoreAuth(store)}
+	router := h.Router()
+
+	for _, req := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{"POST", "/api/v1/admin/users", `{"name":"alice"}`, http.StatusCreated},
+		{"POST", "/api/v1/admin/users", `{"name":"mallory"}`, http.StatusCreated},
+		{"POST", "/api/v1/admin/users", `{"name":"alice"}`, http.StatusConflict},
+		{"POST", "/api/v1/admin/users", `{"name":"a/b"}`, http.StatusBadRequest},
+		{"POST", "/api/v1/admin/teams", `{"name":"platform","sso_group":"eng-platform"}`, http.StatusCreated},
+		{"PUT", "/api/v1/admin/teams/platform/members/alice", "", http.StatusOK},
+		{"PUT", "/api/v1/admin/teams/platform/members/nobody", "", http.StatusNotFound},
+		{"PUT", "/api/v1/admin/packages/mylib/owners/platform", "", http.StatusOK},
+		{"PUT", "/api/v1/admin/packages/mylib/owners/nobody", "", http.StatusNotFound},
+	} {
+		var body []byte
+		if req.body != "" {
+			body = []byte(req.body)
+		}
+		if rr := doRequest(t, router, req.method, req.path, "test-token", body); rr.Code != req.want {
+			t.Fatalf("%s %s: expected %d, got %d: %s", req.method, req.path, req.want, rr.Code, rr.Body.String())
+		}
+	}
+	if rr := doRequest(t, router, "PUT", "/api/v1/admin/packages/mylib/owners/platform", "mallory-token", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("non-admin changing owners: expected 403, got %d", rr.Code)
+	}
+
+	rr := doRequest(t, router, "POST", "/api/v1/admin/tokens", "test-token", []byte(`{"name":"alice-laptop","user":"alice"}`))
+	var created models.CreateTokenResponse
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if rr.Code != http.StatusCreated || created.User != "alice" {
+		t.Fatalf("token for alice: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/admin/tokens", "test-token", []byte(`{"name":"x","user":"nobody"}`)); rr.Code != http.StatusNotFound {
+		t.Errorf("token for an unknown user: expected 404, got %d", rr.Code)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/packages/mylib/owners", "mallory-token", nil)
+	var owners models.PackageOwners
+	json.Unmarshal(rr.Body.Bytes(), &owners)
+	if rr.Code != http.StatusOK || owners.Package != "mylib" || len(owners.Owners) != 1 ||
+		owners.Owners[0].Name != "platform" || !slices.Equal(owners.Owners[0].Members, []string{"alice"}) {
+		t.Fatalf("owners: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Only owners publish to and delete from an owned package.
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/mylib/1.0.0", "mallory-token", []byte("evil"))
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "owned by platform") {
+		t.Errorf("upload by a non-owner: %d %s", rr.Code, rr.Body.String())
+	}
+	for token, version := range map[string]string{created.Token: "1.0.0", "sso-token": "1.1.0", "test-token": "1.2.0"} {
+		if rr := doRequest(t, router, "POST", "/api/v1/artifacts/mylib/"+version, token, []byte("data")); rr.Code != http.StatusCreated {
+			t.Errorf("upload of %s: expected 201, got %d: %s", version, rr.Code, rr.Body.String())
+		}
+	}
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/mylib/1.0.0", "mallory-token", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("delete by a non-owner: expected 403, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/mylib/1.0.0", created.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("delete by an owner: expected 200, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/otherlib/1.0.0", "mallory-token", []byte("data")); rr.Code != http.StatusCreated {
+		t.Errorf("upload to an unowned package: expected 201, got %d", rr.Code)
+	}
+
+	// Deleting a user revokes its tokens.
+	if rr := doRequest(t, router, "DELETE", "/api/v1/admin/users/alice", "test-token", nil); rr.Code != http.StatusOK {
+		t.Fatalf("delete user: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/packages", created.Token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("token of a deleted user: expected 401, got %d", rr.Code)
+	}
+}
+
+func TestApprovals(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	WithApprovals(h.meta.(*metadata.SQLiteStore), ApprovalRules{Packages: []string{"payments-"}, GCBytes: 4})(h)
+	h.auth = principalAuth{
+		"test-token":  {Name: "config", Admin: true},
+		"alice-token": {Name: "alice-laptop", User: "alice", Admin: true},
+		"bob-token":   {Name: "bob-laptop", User: "bob", Admin: true},
+	}
+	router := h.Router()
+	doRequest(t, router, "POST", "/api/v1/artifacts/payments-api/1.0.0", "test-token", []byte("payments"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1", "test-token", []byte("app content"))
+
+	// Deleting a protected version waits for a second admin.
+	rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/payments-api/1.0.0", "alice-token", nil)
+	var held models.Approval
+	json.Unmarshal(rr.Body.Bytes(), &held)
+	if rr.Code != http.StatusAccepted || held.Status != models.ApprovalPending || held.RequestedBy != "alice" ||
+		held.Action != models.ApprovalActionDelete || held.Package != "payments-api" {
+		t.Fatalf("held delete: %d %s", rr.Code, rr.Body.String())
+	}
+	if loc := rr.Header().Get("Location"); !strings.HasSuffix(loc, fmt.Sprintf("/api/v1/admin/approvals/%d", held.ID)) {
+		t.Errorf("unexpected Location %q", loc)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/payments-api/1.0.0", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("held version: expected 200, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/payments-api/9.9.9", "alice-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("held delete of a missing version: expected 404, got %d", rr.Code)
+	}
+
+	approve := fmt.Sprintf("/api/v1/admin/approvals/%d/approve", held.ID)
+	if rr := doRequest(t, router, "POST", approve, "alice-token", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("self-approval: expected 403, got %d", rr.Code)
+	}
+	rr = doRequest(t, router, "POST", approve, "bob-token", nil)
+	var decided models.Approval
+	json.Unmarshal(rr.Body.Bytes(), &decided)
+	if rr.Code != http.StatusOK || decided.Status != models.ApprovalApproved || decided.DecidedBy != "bob" ||
+		decided.DecidedAt == nil || decided.Result != "deleted" {
+		t.Fatalf("approve: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/payments-api/1.0.0", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("approved delete: expected 404, got %d", rr.Code)
+	}
+	var history models.HistoryResponse
+	json.Unmarshal(doRequest(t, router, "GET", "/api/v1/packages/payments-api/history", "test-token", nil).Body.Bytes(), &history)
+	if len(history.Events) == 0 || history.Events[0].Action != models.HistoryDelete || history.Events[0].Actor != "alice" {
+		t.Errorf("history after approval: %+v", history.Events)
+	}
+	if rr := doRequest(t, router, "POST", approve, "test-token", nil); rr.Code != http.StatusConflict {
+		t.Errorf("approving twice: expected 409, got %d", rr.Code)
+	}
+
+	// Other packages are deleted at once, leaving a blob to collect.
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1", "alice-token", nil); rr.Code != http.StatusOK {
+		t.Fatalf("unprotected delete: expected 200, got %d", rr.Code)
+	}
+
+	// Collections freeing more than allowed are held too; dry runs are not.
+	if rr := doRequest(t, router, "POST", "/api/v1/gc?dry_run=true", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("dry run: expected 200, got %d", rr.Code)
+	}
+	rr = doRequest(t, router, "POST", "/api/v1/gc", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &held)
+	if rr.Code != http.StatusAccepted || held.Action != models.ApprovalActionGC || !strings.Contains(held.Reason, "would free") {
+		t.Fatalf("held gc: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "POST", fmt.Sprintf("/api/v1/admin/approvals/%d/reject", held.ID), "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("withdrawing a request: expected 200, got %d", rr.Code)
+	}
+	rr = doRequest(t, router, "POST", "/api/v1/gc?tombstone=true", "alice-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &held)
+	rr = doRequest(t, router, "POST", fmt.Sprintf("/api/v1/admin/approvals/%d/approve", held.ID), "bob-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &decided)
+	jobID, ok := strings.CutPrefix(decided.Result, "started job ")
+	if rr.Code != http.StatusOK || !ok {
+		t.Fatalf("approve gc: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/admin/jobs/"+jobID+"?follow=true", "test-token", nil)
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	var job models.Job
+	json.Unmarshal([]byte(lines[len(lines)-1]), &job)
+	if job.Status != models.JobSucceeded || job.Result == nil || job.Result.DeletedBlobs != 2 {
+		t.Errorf("approved gc: %s", lines[len(lines)-1])
+	}
+
+	var list []models.Approval
+	json.Unmarshal(doRequest(t, router, "GET", "/api/v1/admin/approvals", "test-token", nil).Body.Bytes(), &list)
+	if len(list) != 3 || list[0].ID != held.ID || list[1].Status != models.ApprovalRejected {
+		t.Errorf("list: %+v", list)
+	}
+	json.Unmarshal(doRequest(t, router, "GET", "/api/v1/admin/approvals?status=pending", "test-token", nil).Body.Bytes(), &list)
+	if len(list) != 0 {
+		t.Errorf("pending list: %+v", list)
+	}
+
+	_, plain := setupTestHandler(t)
+	if rr := doRequest(t, plain, "GET", "/api/v1/admin/approvals", "test-token", nil); rr.Code != http.StatusNotImplemented {
+		t.Errorf("without approvals: expected 501, got %d", rr.Code)
+	}
+}
+
+func TestComments(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	WithComments(h.meta.(*metadata.SQLiteStore))(h)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {Name: "ci"},
+		"dev-token":  {Name: "dev"},
+	}
+	router := h.Router()
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.2.3", "test-token", []byte("bad build"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.2.4", "test-token", []byte("good build"))
+
+	rr := doRequest(t, router, "POST", "/api/v1/packages/app/comments", "ci-token", []byte(`{"body":"  moving to app2 next quarter "}`))
+	var note models.Comment
+	json.Unmarshal(rr.Body.Bytes(), &note)
+	if rr.Code != http.StatusCreated || note.Body != "moving to app2 next quarter" || note.Author != "ci" || note.Version != "" {
+		t.Fatalf("package comment: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(t, router, "POST", "/api/v1/packages/app/comments", "dev-token", []byte(`{"version":"1.2.3","body":"known bad, use 1.2.4"}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("version comment: %d %s", rr.Code, rr.Body.String())
+	}
+	for _, tc := range []struct {
+		path, body string
+		want       int
+	}{
+		{"/api/v1/packages/app/comments", `{"body":"   "}`, http.StatusBadRequest},
+		{"/api/v1/packages/app/comments", `{"version":"9.9.9","body":"x"}`, http.StatusNotFound},
+		{"/api/v1/packages/nope/comments", `{"body":"x"}`, http.StatusNotFound},
+	} {
+		if rr := doRequest(t, router, "POST", tc.path, "ci-token", []byte(tc.body)); rr.Code != tc.want {
+			t.Errorf("POST %s %s: expected %d, got %d", tc.path, tc.body, tc.want, rr.Code)
+		}
+	}
+
+	// Package details carry the comments, oldest first.
+	var info models.PackageInfo
+	json.Unmarshal(doRequest(t, router, "GET", "/api/v1/packages/app", "dev-token", nil).Body.Bytes(), &info)
+	if len(info.Comments) != 2 || info.Comments[0].ID != note.ID || info.Comments[1].Body != "known bad, use 1.2.4" {
+		t.Errorf("package info comments: %+v", info.Comments)
+	}
+	var list []models.Comment
+	json.Unmarshal(doRequest(t, router, "GET", "/api/v1/packages/app/comments?version=1.2.3", "dev-token", nil).Body.Bytes(), &list)
+	if len(list) != 1 || list[0].Version != "1.2.3" {
+		t.Errorf("comments on 1.2.3: %+v", list)
+	}
+
+	path := fmt.Sprintf("/api/v1/packages/app/comments/%d", note.ID)
+	if rr := doRequest(t, router, "DELETE", path, "dev-token", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("deleting another's comment: expected 403, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "DELETE", "/api/v1/packages/other/comments/1", "ci-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("deleting through another package: expected 404, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "DELETE", path, "ci-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("deleting own comment: expected 200, got %d", rr.Code)
+	}
+
+	// A version's comments go with it.
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.2.3", "test-token", nil)
+	json.Unmarshal(doRequest(t, router, "GET", "/api/v1/packages/app/comments", "dev-token", nil).Body.Bytes(), &list)
+	if len(list) != 0 {
+		t.Errorf("comments after deletes: %+v", list)
+	}
+
+	_, plain := setupTestHandler(t)
+	if rr := doRequest(t, plain, "GET", "/api/v1/packages/app/comments", "test-token", nil); rr.Code != http.StatusNotImplemented {
+		t.Errorf("without comments: expected 501, got %d", rr.Code)
+	}
+}
+
+func TestDeprecation(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	store := h.meta.(*metadata.SQLiteStore)
+	WithAccounts(store)(h)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {Name: "ci"},
+		"dev-token":  {Name: "dev"},
+	}
+	router := h.Router()
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("old"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.1.0", "test-token", []byte("new"))
+
+	rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/deprecation", "ci-token",
+		[]byte(`{"message":"CVE-2024-1234,\n upgrade","deprecated_in_favor_of":"1.1.0"}`))
+	var artifact models.Artifact
+	json.Unmarshal(rr.Body.Bytes(), &artifact)
+	if rr.Code != http.StatusOK || artifact.Deprecated == nil || artifact.Deprecated.Message != "CVE-2024-1234, upgrade" ||
+		artifact.Deprecated.InFavorOf != "1.1.0" || artifact.Revision != 2 {
+		t.Fatalf("deprecate: %d %s", rr.Code, rr.Body.String())
+	}
+	for _, method := range []string{"GET", "HEAD"} {
+		rr = doRequest(t, router, method, "/api/v1/artifacts/app/1.0.0", "dev-token", nil)
+		if rr.Code != http.StatusOK || rr.Header().Get("X-Artifact-Deprecated") != "CVE-2024-1234, upgrade" ||
+			rr.Header().Get("X-Artifact-Deprecated-In-Favor-Of") != "1.1.0" ||
+			rr.Header().Get("Warning") != `299 - "app@1.0.0 is deprecated: CVE-2024-1234, upgrade (use 1.1.0)"` {
+			t.Errorf("%s deprecated version: %d %v", method, rr.Code, rr.Header())
+		}
+	}
+	if rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/1.1.0", "dev-token", nil); rr.Header().Get("Warning") != "" {
+		t.Errorf("current version warns: %v", rr.Header())
+	}
+	var info models.PackageInfo
+	json.Unmarshal(doRequest(t, router, "GET", "/api/v1/packages/app?sort=semver", "dev-token", nil).Body.Bytes(), &info)
+	if len(info.Versions) != 2 || info.Versions[0].Deprecated != nil || info.Versions[1].Deprecated == nil || info.Deprecated != nil {
+		t.Errorf("package listing: %+v", info)
+	}
+
+	for _, tc := range []struct {
+		path, body string
+		want       int
+	}{
+		{"/api/v1/artifacts/app/1.1.0/deprecation", `{"message":"  "}`, http.StatusBadRequest},
+		{"/api/v1/artifacts/app/9.9.9/deprecation", `{"message":"x"}`, http.StatusNotFound},
+		{"/api/v1/packages/nope/deprecation", `{"message":"x"}`, http.StatusNotFound},
+	} {
+		if rr := doRequest(t, router, "PUT", tc.path, "ci-token", []byte(tc.body)); rr.Code != tc.want {
+			t.Errorf("PUT %s %s: expected %d, got %d", tc.path, tc.body, tc.want, rr.Code)
+		}
+	}
+
+	// A deprecated package warns on every version not deprecated itself.
+	rr = doRequest(t, router, "PUT", "/api/v1/packages/app/deprecation", "ci-token",
+		[]byte(`{"message":"renamed","deprecated_in_favor_of":"app2"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("deprecate package: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/1.1.0", "dev-token", nil)
+	if rr.Header().Get("Warning") != `299 - "app is deprecated: renamed (use app2)"` {
+		t.Errorf("version of a deprecated package: %v", rr.Header())
+	}
+	var pkgs []models.Package
+	json.Unmarshal(doRequest(t, router, "GET", "/api/v1/packages", "dev-token", nil).Body.Bytes(), &pkgs)
+	if len(pkgs) != 1 || pkgs[0].Deprecated == nil || pkgs[0].Deprecated.InFavorOf != "app2" {
+		t.Errorf("package list: %+v", pkgs)
+	}
+
+	// Owned packages may only be deprecated by their owners.
+	store.CreateTeam("platform", "")
+	store.AddPackageOwner("app", "platform")
+	if rr := doRequest(t, router, "DELETE", "/api/v1/packages/app/deprecation", "dev-token", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("non-owner undeprecating: expected 403, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "DELETE", "/api/v1/packages/app/deprecation", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("admin undeprecating: expected 200, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.0.0/deprecation", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("undeprecating version: expected 200, got %d", rr.Code)
+	}
+	if rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "dev-token", nil); rr.Header().Get("Warning") != "" {
+		t.Errorf("undeprecated version warns: %v", rr.Header())
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	_, router := setupTestHandler(t)
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("one"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/lib/0.1.0", "test-token", []byte("lib"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.1.0", "test-token", []byte("two"))
+	req := httptest.NewRequest("POST", "/api/v1/artifacts/app/2.0.0-rc1", strings.NewReader("rc"))
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("X-Artifact-Stage", models.StageStaging)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/deprecation", "test-token", []byte(`{"message":"old","deprecated_in_favor_of":"1.1.0"}`))
+
+	feed := func(path string) (atomFeed, *httptest.ResponseRecorder) {
+		t.Helper()
+		rr := doRequest(t, router, "GET", path, "test-token", nil)
+		var f atomFeed
+		if rr.Code == http.StatusOK {
+			if err := xml.Unmarshal(rr.Body.Bytes(), &f); err != nil {
+				t.Fatalf("%s: %v\n%s", path, err, rr.Body.String())
+			}
+		}
+		return f, rr
+	}
+	titles := func(f atomFeed) []string {
+		var out []string
+		for _, e := range f.Entries {
+			out = append(out, e.Title)
+		}
+		return out
+	}
+
+	f, rr := feed("/feeds/packages/app.atom")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != atomContentType {
+		t.Fatalf("package feed: %d %v", rr.Code, rr.Header())
+	}
+	// Staging versions are not releases.
+	if got := titles(f); !slices.Equal(got, []string{"app 1.1.0", "app 1.0.0"}) {
+		t.Errorf("package feed entries: %v", got)
+	}
+	if f.Entries[0].Updated != f.Updated || !strings.HasSuffix(f.Entries[0].Link.Href, "/api/v1/artifacts/app/1.1.0") {
+		t.Errorf("package feed: %+v", f)
+	}
+	if !strings.Contains(f.Entries[1].Summary, "Deprecated: old (use 1.1.0)") {
+		t.Errorf("deprecated entry summary: %q", f.Entries[1].Summary)
+	}
+
+	f, _ = feed("/feeds/releases.atom")
+	if got := titles(f); !slices.Equal(got, []string{"app 1.1.0", "lib 0.1.0", "app 1.0.0"}) {
+		t.Errorf("releases feed entries: %v", got)
+	}
+
+	for _, path := range []string{"/feeds/packages/nope.atom", "/feeds/packages/app", "/feeds/packages/app.rss"} {
+		if _, rr := feed(path); rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, rr.Code)
+		}
+	}
+}
+
+func TestReleaseNotes(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	store := h.meta.(*metadata.SQLiteStore)
+	WithAccounts(store)(h)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"dev-token":  {Name: "dev"},
+	}
+	router := h.Router()
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/2.3.1", "test-token", []byte("app"))
+
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/2.3.1/notes", "dev-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("notes before any are set: expected 404, got %d", rr.Code)
+	}
+	notes := "## 2.3.1\n\n- Fix crash when the cache is cold\n"
+	rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/2.3.1/notes", "dev-token", []byte("\n"+notes+"\n\n"))
+	var artifact models.Artifact
+	json.Unmarshal(rr.Body.Bytes(), &artifact)
+	if rr.Code != http.StatusOK || artifact.ReleaseNotes != notes || artifact.Revision != 2 {
+		t.Fatalf("set notes: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/2.3.1/notes", "dev-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != notes || rr.Header().Get("Content-Type") != "text/markdown; charset=utf-8" {
+		t.Errorf("get notes: %d %v %q", rr.Code, rr.Header(), rr.Body.String())
+	}
+	var info models.PackageInfo
+	json.Unmarshal(doRequest(t, router, "GET", "/api/v1/packages/app", "dev-token", nil).Body.Bytes(), &info)
+	if len(info.Versions) != 1 || info.Versions[0].ReleaseNotes != notes {
+		t.Errorf("package details should carry the notes: %+v", info.Versions)
+	}
+
+	for _, tc := range []struct {
+		path string
+		body []byte
+		want int
+	}{
+		{"/api/v1/artifacts/app/2.3.1/notes", []byte(" \n "), http.StatusBadRequest},
+		{"/api/v1/artifacts/app/2.3.1/notes", []byte{0xff, 0xfe}, http.StatusBadRequest},
+		{"/api/v1/artifacts/app/2.3.1/notes", bytes.Repeat([]byte("x"), maxReleaseNotesBytes+1), http.StatusRequestEntityTooLarge},
+		{"/api/v1/artifacts/app/9.9.9/notes", []byte("x"), http.StatusNotFound},
+	} {
+		if rr := doRequest(t, router, "PUT", tc.path, "dev-token", tc.body); rr.Code != tc.want {
+			t.Errorf("PUT %s (%d bytes): expected %d, got %d", tc.path, len(tc.body), tc.want, rr.Code)
+		}
+	}
+
+	// Only owners may write the notes of an owned package.
+	store.CreateTeam("app-team", "")
+	store.AddPackageOwner("app", "app-team")
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/app/2.3.1/notes", "dev-token", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("non-owner cleared notes: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/app/2.3.1/notes", "test-token", nil); rr.Code != http.StatusOK {
+		t.Fatalf("clear notes: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/2.3.1/notes", "dev-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("cleared notes: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestStabilityChannels(t *testing.T) {
+	_, router := setupTestHandler(t)
+	upload := func(path, stability string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(path))
+		req.Header.Set("Authorization", "Bearer test-token")
+		if stability != "" {
+			req.Header.Set("X-Artifact-Stability", stability)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := upload("/api/v1/artifacts/lib/3.0.0", "nightly"); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown stability: expected 400, got %d", rr.Code)
+	}
+	upload("/api/v1/artifacts/lib/1.0.0", "")
+	upload("/api/v1/artifacts/lib/1.1.0-rc.1", "")
+	upload("/api/v1/artifacts/lib/1.2.0-beta.1", "")
+	rr := upload("/api/v1/artifacts/lib/2.0.0", "Alpha")
+	var uploaded models.UploadResponse
+	json.Unmarshal(rr.Body.Bytes(), &uploaded)
+	if rr.Code != http.StatusCreated || uploaded.Stability != models.StabilityAlpha {
+		t.Fatalf("nightly upload: %d %s", rr.Code, rr.Body.String())
+	}
+
+	pkg := func(query string) (models.PackageInfo, int) {
+		rr := doRequest(t, router, "GET", "/api/v1/packages/lib"+query, "test-token", nil)
+		var info models.PackageInfo
+		json.Unmarshal(rr.Body.Bytes(), &info)
+		return info, rr.Code
+	}
+	// The nightly 2.0.0 is the highest version but not the latest.
+	if info, _ := pkg(""); info.Latest != "1.0.0" || len(info.Versions) != 4 {
+		t.Errorf("default: latest %q, %d versions", info.Latest, len(info.Versions))
+	}
+	for _, tc := range []struct {
+		channel  string
+		latest   string
+		versions int
+	}{
+		{"stable", "1.0.0", 1},
+		{"rc", "1.0.0", 2},
+		{"beta", "1.0.0", 3},
+		{"alpha", "2.0.0", 4},
+	} {
+		if info, code := pkg("?channel=" + tc.channel); code != http.StatusOK || info.Latest != tc.latest || len(info.Versions) != tc.versions {
+			t.Errorf("channel %s: %d latest %q, %d versions", tc.channel, code, info.Latest, len(info.Versions))
+		}
+	}
+	if _, code := pkg("?channel=nightly"); code != http.StatusBadRequest {
+		t.Errorf("unknown channel: expected 400, got %d", code)
+	}
+	var list models.ArtifactList
+	json.Unmarshal(doRequest(t, router, "GET", "/api/v1/artifacts?channel=rc", "test-token", nil).Body.Bytes(), &list)
+	if len(list.Artifacts) != 2 || list.Artifacts[0].Version != "1.1.0-rc.1" || list.Artifacts[0].Stability != models.StabilityRC {
+		t.Errorf("artifacts in the rc channel: %+v", list.Artifacts)
+	}
+
+	upload("/api/v1/artifacts/app/1.0.0", "")
+	doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/dependencies", "test-token", []byte(`{"dependencies":[{"package":"lib","constraint":">=1.0.0"}]}`))
+	resolved := func(query string) string {
+		var resp models.DependenciesResponse
+		json.Unmarshal(doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/dependencies?resolve=true"+query, "test-token", nil).Body.Bytes(), &resp)
+		if len(resp.Resolved) != 1 {
+			return ""
+		}
+		return resp.Resolved[0].Version
+	}
+	if got := resolved(""); got != "2.0.0" {
+		t.Errorf("resolved without a channel: %q", got)
+	}
+	if got := resolved("&channel=stable"); got != "1.0.0" {
+		t.Errorf("resolved in the stable channel: %q", got)
+	}
+}
+
+func TestNamingRules(t *testing.T) {
+	h, router := setupTestHandler(t)
+	// Names stored before the rules were configured.
+	doRequest(t, router, "POST", "/api/v1/artifacts/my%20lib/1.0.0", "test-token", []byte("legacy"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/lib/nightly", "test-token", []byte("legacy"))
+
+	pattern, err := naming.Anchor(`[a-z0-9][a-z0-9._-]*`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	WithNaming(naming.Rules{
+		PackagePattern:   pattern,
+		RequireSemver:    true,
+		MaxPackageLength: 20,
+		ReservedPrefixes: []string{"foundry-"},
+		Lowercase:        true,
+	})(h)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+	}
+
+	for _, tc := range []struct {
+		path  string
+		token string
+		code  int
+	}{
+		{"/api/v1/artifacts/lib%F0%9F%9A%80/1.0.0", "ci-token", http.StatusBadRequest},
+		{"/api/v1/artifacts/my%20lib/1.1.0", "ci-token", http.StatusBadRequest},
+		{"/api/v1/artifacts/a-very-long-package-name/1.0.0", "ci-token", http.StatusBadRequest},
+		{"/api/v1/artifacts/lib/2.0", "ci-token", http.StatusBadRequest},
+		{"/api/v1/artifacts/lib/2.0.0/files/lib.zip", "ci-token", http.StatusCreated},
+		{"/api/v1/artifacts/foundry-core/1.0.0", "ci-token", http.StatusForbidden},
+		{"/api/v1/artifacts/foundry-core/1.0.0", "test-token", http.StatusCreated},
+		// Once an admin created it, its owners may publish.
+		{"/api/v1/artifacts/foundry-core/1.1.0", "ci-token", http.StatusCreated},
+	} {
+		if rr := doRequest(t, router, "POST", tc.path, tc.token, []byte(tc.path)); rr.Code != tc.code {
+			t.Errorf("POST %s: expected %d, got %d: %s", tc.path, tc.code, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Upper case names are folded.
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/MyLib/1.0.0", "ci-token", []byte("mylib"))
+	var uploaded models.UploadResponse
+	json.Unmarshal(rr.Body.Bytes(), &uploaded)
+	if rr.Code != http.StatusCreated || uploaded.Package != "mylib" {
+		t.Fatalf("upper case upload: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/mylib/1.0.0", "ci-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("download of folded name: expected 200, got %d", rr.Code)
+	}
+
+	// Stored names breaking the rules are still served, and listed for
+	// admins.
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/my%20lib/1.0.0", "ci-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("download of legacy name: expected 200, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/admin/naming/violations", "ci-token", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("violations as non-admin: expected 403, got %d", rr.Code)
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/admin/naming/violations", "test-token", nil)
+	var violations []models.NamingViolation
+	json.Unmarshal(rr.Body.Bytes(), &violations)
+	if rr.Code != http.StatusOK || len(violations) != 2 {
+		t.Fatalf("violations: %d %s", rr.Code, rr.Body.String())
+	}
+	if v := violations[0]; v.Package != "lib" || v.Version != "nightly" || len(v.Problems) != 1 || v.Problems[0] != "not valid semver" {
+		t.Errorf("first violation: %+v", v)
+	}
+	if v := violations[1]; v.Package != "my lib" || v.Version != "" || len(v.Problems) != 1 {
+		t.Errorf("second violation: %+v", v)
+	}
+}
+
+func TestStorageRouting(t *testing.T) {
+	h, router := setupTestHandler(t)
+	fallback := h.blobs
+	ci := storage.NewMemoryBlobStorage()
+	release := storage.NewMemoryBlobStorage()
+	h.blobs = storage.NewRoutedBlobStorage(fallback, map[string]services.BlobStorage{"ci-": ci, "release-": release})
+
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/ci-build/1.0.0", "test-token", []byte("build output"))
+	var uploaded models.UploadResponse
+	json.Unmarshal(rr.Body.Bytes(), &uploaded)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rr.Code, rr.Body.String())
+	}
+	if !ci.Exists(uploaded.Hash) || fallback.Exists(uploaded.Hash) || release.Exists(uploaded.Hash) {
+		t.Fatal("ci upload should be stored in the ci backend only")
+	}
+	doRequest(t, router, "POST", "/api/v1/artifacts/ci-build/1.0.0/files/build.log", "test-token", []byte("log"))
+	doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("app"))
+	appSum, logSum := sha256.Sum256([]byte("app")), sha256.Sum256([]byte("log"))
+	if !fallback.Exists(hex.EncodeToString(appSum[:])) || !ci.Exists(hex.EncodeToString(logSum[:])) {
+		t.Error("files should follow their package's route")
+	}
+
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/ci-build/1.0.0", "test-token", nil); rr.Code != http.StatusOK || rr.Body.String() != "build output" {
+		t.Errorf("download from a routed backend: %d %q", rr.Code, rr.Body.String())
+	}
+
+	// Promoting the build to a release package by link copies its blob to
+	// the release backend.
+	body, _ := json.Marshal(models.LinkRequest{Hash: uploaded.Hash})
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/release-app/1.0.0/link", "test-token", body); rr.Code != http.StatusCreated {
+		t.Fatalf("link: %d %s", rr.Code, rr.Body.String())
+	}
+	if !release.Exists(uploaded.Hash) {
+		t.Error("link should copy the blob to the release backend")
+	}
+}
+
+func TestCopyArtifact(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+	}
+	router := h.Router()
+
+	publishWithDeps(t, router, "app", "1.0.0-rc.1", "libfoo", "^1.2")
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0-rc.1/files/app.sig", "test-token", []byte("signature")); rr.Code != http.StatusCreated {
+		t.Fatalf("file upload: %d %s", rr.Code, rr.Body.String())
+	}
+	source, _ := h.meta.GetArtifact("app", "1.0.0-rc.1")
+	blobs, _ := h.blobs.ListBlobs()
+
+	copyTo := func(from string, req models.CopyRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		return doRequest(t, router, "POST", "/api/v1/artifacts/app/"+from+"/copy", "ci-token", body)
+	}
+
+	rr := copyTo("1.0.0-rc.1", models.CopyRequest{Version: "1.0.0"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("copy: expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var copied models.UploadResponse
+	json.NewDecoder(rr.Body).Decode(&copied)
+	if copied.Package != "app" || copied.Version != "1.0.0" || copied.Hash != source.Hash || copied.Stability != models.StabilityStable {
+		t.Errorf("copy response = %+v", copied)
+	}
+	if after, _ := h.blobs.ListBlobs(); len(after) != len(blobs) {
+		t.Errorf("copy stored new blobs: %d before, %d after", len(blobs), len(after))
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0/files/app.sig", "ci-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "signature" {
+		t.Errorf("copied file: %d %q", rr.Code, rr.Body.String())
+	}
+	if deps, _ := h.meta.ListDependencies("app", "1.0.0"); len(deps) != 1 || deps[0].Package != "libfoo" {
+		t.Errorf("copied dependencies = %+v", deps)
+	}
+
+	events, _ := h.meta.ListHistory("app", "1.0.0", 0, 10)
+	if len(events) != 2 {
+		t.Fatalf("expected the version and its file in the history, got %+v", events)
+	}
+	for _, e := range events {
+		if e.Action != models.HistoryCopy || e.Source != "app@1.0.0-rc.1" || e.Actor != "ci" {
+			t.Errorf("history event = %+v", e)
+		}
+	}
+
+	// Copying to another package; deleting the original keeps the copy.
+	rr = copyTo("1.0.0", models.CopyRequest{Package: "app-lts", Version: "1.0.0"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("copy to another package: expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.0.0-rc.1", "test-token", nil)
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.0.0", "test-token", nil)
+	doRequest(t, router, "POST", "/api/v1/gc", "test-token", nil)
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app-lts/1.0.0", "ci-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "app1.0.0-rc.1" {
+		t.Errorf("copy after deleting the original: %d %q", rr.Code, rr.Body.String())
+	}
+
+	if rr := copyTo("1.0.0-rc.1", models.CopyRequest{Version: "1.0.1"}); rr.Code != http.StatusNotFound {
+		t.Errorf("copy of a deleted version: expected 404, got %d", rr.Code)
+	}
+	publishWithDeps(t, router, "app", "2.0.0")
+	if rr := copyTo("2.0.0", models.CopyRequest{}); rr.Code != http.StatusBadRequest {
+		t.Errorf("copy without a version: expected 400, got %d", rr.Code)
+	}
+	if rr := copyTo("2.0.0", models.CopyRequest{Package: "app-lts", Version: "1.0.0"}); rr.Code != http.StatusConflict {
+		t.Errorf("copy onto an existing version: expected 409, got %d", rr.Code)
+	}
+}
+
+func TestBatchDeleteArtifacts(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	WithApprovals(h.meta.(*metadata.SQLiteStore), ApprovalRules{Packages: []string{"ci-payments"}})(h)
+	router := h.Router()
+
+	for _, ref := range []string{"ci-app/1.0.0-alpha.1", "ci-app/1.0.0", "ci-lib/2.0.0-alpha.1", "ci-payments/1.0.0-alpha.1", "app/1.0.0-alpha.1"} {
+		if rr := doRequest(t, router, "POST", "/api/v1/artifacts/"+ref, "test-token", []byte(ref)); rr.Code != http.StatusCreated {
+			t.Fatalf("upload %s: %d %s", ref, rr.Code, rr.Body.String())
+		}
+	}
+	doRequest(t, router, "PUT", "/api/v1/artifacts/ci-lib/2.0.0-alpha.1/pin", "test-token", nil)
+
+	batchDelete := func(req models.BatchDeleteRequest) (*httptest.ResponseRecorder, models.BatchDeleteResponse) {
+		body, _ := json.Marshal(req)
+		rr := doRequest(t, router, "POST", "/api/v1/artifacts/batch-delete", "test-token", body)
+		var resp models.BatchDeleteResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr, resp
+	}
+	outcomes := func(resp models.BatchDeleteResponse) string {
+		var got []string
+		for _, res := range resp.Artifacts {
+			got = append(got, res.Package+"@"+res.Version+"="+res.Status)
+		}
+		return strings.Join(got, " ")
+	}
+
+	filter := &models.BatchDeleteFilter{Prefix: "ci-", Channel: models.StabilityAlpha}
+	rr, preview := batchDelete(models.BatchDeleteRequest{Filter: filter})
+	if rr.Code != http.StatusOK || !preview.DryRun || preview.Confirm == "" {
+		t.Fatalf("preview: %d %s", rr.Code, rr.Body.String())
+	}
+	if got := outcomes(preview); got != "ci-payments@1.0.0-alpha.1=pending ci-lib@2.0.0-alpha.1=pinned ci-app@1.0.0-alpha.1=pending" {
+		t.Errorf("preview = %s", got)
+	}
+	if a, _ := h.meta.GetArtifact("ci-app", "1.0.0-alpha.1"); a == nil {
+		t.Fatal("a preview should not delete anything")
+	}
+
+	if rr, _ := batchDelete(models.BatchDeleteRequest{Filter: filter, Confirm: strings.Repeat("0", 64)}); rr.Code != http.StatusConflict {
+		t.Errorf("wrong token: expected 409, got %d", rr.Code)
+	}
+
+	// A version matching after the preview invalidates its token.
+	doRequest(t, router, "POST", "/api/v1/artifacts/ci-app/1.1.0-alpha.1", "test-token", []byte("late"))
+	if rr, _ := batchDelete(models.BatchDeleteRequest{Filter: filter, Confirm: preview.Confirm}); rr.Code != http.StatusConflict {
+		t.Errorf("changed selection: expected 409, got %d", rr.Code)
+	}
+
+	_, preview = batchDelete(models.BatchDeleteRequest{Filter: filter})
+	rr, done := batchDelete(models.BatchDeleteRequest{Filter: filter, Confirm: preview.Confirm})
+	if rr.Code != http.StatusOK || done.DryRun || done.Deleted != 2 {
+		t.Fatalf("confirmed delete: %d %s", rr.Code, rr.Body.String())
+	}
+	if got := outcomes(done); got != "ci-app@1.1.0-alpha.1=deleted ci-payments@1.0.0-alpha.1=held ci-lib@2.0.0-alpha.1=pinned ci-app@1.0.0-alpha.1=deleted" {
+		t.Errorf("outcomes = %s", got)
+	}
+	if done.Artifacts[1].Approval == 0 {
+		t.Error("a held deletion should name its approval")
+	}
+	for _, ref := range []models.ArtifactRef{{Package: "ci-app", Version: "1.0.0"}, {Package: "app", Version: "1.0.0-alpha.1"}, {Package: "ci-payments", Version: "1.0.0-alpha.1"}} {
+		if a, _ := h.meta.GetArtifact(ref.Package, ref.Version); a == nil {
+			t.Errorf("%s@%s should be kept", ref.Package, ref.Version)
+		}
+	}
+	if events, _ := h.meta.ListHistory("ci-app", "1.0.0-alpha.1", 0, 10); len(events) == 0 || events[0].Action != models.HistoryDelete {
+		t.Errorf("batch deletions should be recorded in the history: %+v", events)
+	}
+
+	// Explicit versions, including one that does not exist.
+	refs := []models.ArtifactRef{{Package: "app", Version: "1.0.0-alpha.1"}, {Package: "app", Version: "9.9.9"}}
+	_, preview = batchDelete(models.BatchDeleteRequest{Artifacts: refs})
+	_, done = batchDelete(models.BatchDeleteRequest{Artifacts: refs, Confirm: preview.Confirm})
+	if got := outcomes(done); got != "app@1.0.0-alpha.1=deleted app@9.9.9=not_found" || done.Deleted != 1 {
+		t.Errorf("explicit outcomes = %s, deleted %d", got, done.Deleted)
+	}
+
+	if rr, resp := batchDelete(models.BatchDeleteRequest{Filter: &models.BatchDeleteFilter{OlderThan: "1d"}}); rr.Code != http.StatusOK || len(resp.Artifacts) != 0 {
+		t.Errorf("older_than 1d: %d %s", rr.Code, rr.Body.String())
+	}
+	for name, req := range map[string]models.BatchDeleteRequest{
+		"neither":         {},
+		"both":            {Artifacts: refs, Filter: filter},
+		"empty filter":    {Filter: &models.BatchDeleteFilter{}},
+		"unknown channel": {Filter: &models.BatchDeleteFilter{Channel: "nightly"}},
+		"bad older_than":  {Filter: &models.BatchDeleteFilter{OlderThan: "soon"}},
+	} {
+		if rr, _ := batchDelete(req); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, rr.Code)
+		}
+	}
+}
+
+func TestDiffVersions(t *testing.T) {
+	_, router := setupTestHandler(t)
+
+	archive := func(files map[string]string) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for name, body := range files {
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body))})
+			tw.Write([]byte(body))
+		}
+		tw.Close()
+		gz.Close()
+		return buf.Bytes()
+	}
+	publishWithDeps(t, router, "app", "1.2.0", "libfoo", "^1.0", "libbar", "^2.0")
+	publishWithDeps(t, router, "app", "1.3.0", "libfoo", "^1.4", "libbaz", "~3.1")
+	for _, up := range []struct {
+		path string
+		body []byte
+	}{
+		{"/api/v1/artifacts/app/1.2.0/files/app.tar.gz", archive(map[string]string{"bin/app": "v1", "README": "old"})},
+		{"/api/v1/artifacts/app/1.3.0/files/app.tar.gz", archive(map[string]string{"bin/app": "v1.3", "LICENSE": "MIT"})},
+		{"/api/v1/artifacts/app/1.2.0/files/app.sig", []byte("sig")},
+		{"/api/v1/artifacts/tool/1.0.0", archive(map[string]string{"bin/tool": "one", "README": "docs"})},
+		{"/api/v1/artifacts/tool/2.0.0", archive(map[string]string{"bin/tool": "two!", "README": "docs"})},
+	} {
+		if rr := doRequest(t, router, "POST", up.path, "test-token", up.body); rr.Code != http.StatusCreated {
+			t.Fatalf("upload %s: %d %s", up.path, rr.Code, rr.Body.String())
+		}
+	}
+
+	diff := func(path string) models.VersionDiff {
+		t.Helper()
+		rr := doRequest(t, router, "GET", path, "test-token", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", path, rr.Code, rr.Body.String())
+		}
+		var d models.VersionDiff
+		json.Unmarshal(rr.Body.Bytes(), &d)
+		return d
+	}
+	names := func(entries []models.DiffEntry) string {
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Name)
+		}
+		return strings.Join(got, ",")
+	}
+
+	d := diff("/api/v1/packages/app/diff?from=1.2.0&to=1.3.0")
+	if names(d.Properties) != "hash" {
+		t.Errorf("properties = %+v", d.Properties)
+	}
+	if names(d.Dependencies.Added) != "libbaz" || names(d.Dependencies.Removed) != "libbar" || names(d.Dependencies.Changed) != "libfoo" {
+		t.Errorf("dependencies = %+v", d.Dependencies)
+	}
+	if c := d.Dependencies.Changed; c[0].From != "^1.0" || c[0].To != "^1.4" {
+		t.Errorf("changed dependency = %+v", c[0])
+	}
+	if names(d.Files.Removed) != "app.sig" || names(d.Files.Changed) != "app.tar.gz" || len(d.Files.Added) != 0 {
+		t.Errorf("files = %+v", d.Files)
+	}
+	if d.Contents != nil {
+		t.Errorf("default files that are not archives should not be compared: %+v", d.Contents)
+	}
+
+	d = diff("/api/v1/packages/tool/diff?from=1.0.0&to=2.0.0")
+	if d.Contents == nil || names(d.Contents.Changed) != "bin/tool" || len(d.Contents.Added)+len(d.Contents.Removed) != 0 {
+		t.Fatalf("contents = %+v", d.Contents)
+	}
+	if c := d.Contents.Changed[0]; !strings.HasPrefix(c.From, "3 ") || !strings.HasPrefix(c.To, "4 ") {
+		t.Errorf("changed entry = %+v", c)
+	}
+	if names(d.Properties) != "hash,size" {
+		t.Errorf("properties = %+v", d.Properties)
+	}
+
+	if d := diff("/api/v1/packages/tool/diff?from=1.0.0&to=1.0.0"); len(d.Properties)+len(d.Contents.Changed) != 0 {
+		t.Errorf("a version compared with itself differs: %+v", d)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/packages/tool/diff?from=1.0.0&to=9.9.9", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("missing version: expected 404, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/packages/tool/diff?from=1.0.0", "test-token", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("missing to: expected 400, got %d", rr.Code)
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+	}
+	router := h.Router()
+
+	publishWithDeps(t, router, "libfoo", "1.0.0")
+	publishWithDeps(t, router, "libfoo", "1.4.0")
+	publishWithDeps(t, router, "libfoo", "2.0.0")
+	publishWithDeps(t, router, "app", "1.0.0", "libfoo", "^1.0", "missing", "^1.0")
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0/files/app.sig", "test-token", []byte("sig")); rr.Code != http.StatusCreated {
+		t.Fatalf("upload file: %d %s", rr.Code, rr.Body.String())
+	}
+
+	graphql := func(token, query string, vars map[string]any) (int, map[string]any) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]any{"query": query, "variables": vars})
+		rr := doRequest(t, router, "POST", "/graphql", token, body)
+		var resp map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decoding %s: %v", rr.Body.String(), err)
+		}
+		return rr.Code, resp
+	}
+
+	code, resp := graphql("ci-token", `query($name: String!) {
+		package(name: $name) {
+			name
+			latest
+			versions(sort: "semver", first: 1) {
+				version
+				files { name }
+				dependencies { package constraint resolved { version } }
+			}
+		}
+		nope: package(name: "nope") { name }
+	}`, map[string]any{"name": "app"})
+	if code != http.StatusOK || resp["errors"] != nil {
+		t.Fatalf("query: %d %v", code, resp)
+	}
+	got, _ := json.Marshal(resp["data"])
+	want := `{"nope":null,"package":{"latest":"1.0.0","name":"app","versions":[{"dependencies":[{"constraint":"^1.0","package":"libfoo","resolved":{"version":"1.4.0"}},{"constraint":"^1.0","package":"missing","resolved":null}],"files":[{"name":"app-1.0.0"},{"name":"app.sig"}],"version":"1.0.0"}]}}`
+	if string(got) != want {
+		t.Errorf("data = %s\nwant %s", got, want)
+	}
+
+	_, resp = graphql("test-token", `{ versions(package: "libfoo", first: 2) { version } stats { packages artifacts } }`, nil)
+	got, _ = json.Marshal(resp["data"])
+	if want := `{"stats":{"artifacts":4,"packages":2},"versions":[{"version":"2.0.0"},{"version":"1.4.0"}]}`; string(got) != want {
+		t.Errorf("data = %s\nwant %s", got, want)
+	}
+
+	// Admin-only fields fail on their own; the rest of the query answers.
+	code, resp = graphql("ci-token", `{ stats { packages } package(name: "libfoo") { latest downloads } }`, nil)
+	got, _ = json.Marshal(resp)
+	if code != http.StatusOK || !strings.Contains(string(got), `"data":{"package":{"downloads":null,"latest":"2.0.0"},"stats":null}`) ||
+		!strings.Contains(string(got), `"path":["stats"]`) || !strings.Contains(string(got), `"path":["package","downloads"]`) {
+		t.Errorf("non-admin query = %d %s", code, got)
+	}
+
+	for _, query := range []string{
+		`{ package(name: "app") { owner } }`,
+		`{ package { name } }`,
+		`{ package(name: "app") { name `,
+	} {
+		if code, resp := graphql("test-token", query, nil); code != http.StatusBadRequest || resp["data"] != nil || resp["errors"] == nil {
+			t.Errorf("%s: %d %v", query, code, resp)
+		}
+	}
+	if rr := doRequest(t, router, "GET", "/graphql?query="+url.QueryEscape(`{ package(name: "libfoo") { latest(channel: "stable") } }`), "ci-token", nil); rr.Code != http.StatusOK ||
+		!strings.Contains(rr.Body.String(), `"latest":"2.0.0"`) {
+		t.Errorf("GET: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "POST", "/graphql", "", []byte(`{"query": "{ packages { name } }"}`)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous query = %d, want 401", rr.Code)
+	}
+}
+
+func TestListingETags(t *testing.T) {
+	_, router := setupTestHandler(t)
+	publishWithDeps(t, router, "libfoo", "1.0.0")
+
+	for _, path := range []string{"/api/v1/packages", "/api/v1/packages/libfoo", "/api/v1/artifacts", "/api/v1/search?q=lib"} {
+		rr := doRequest(t, router, "GET", path, "test-token", nil)
+		etag := rr.Header().Get("ETag")
+		if rr.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+			t.Fatalf("GET %s: %d, ETag %q", path, rr.Code, etag)
+		}
+
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("If-None-Match", `"other", `+etag)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 || rr.Header().Get("ETag") != etag {
+			t.Errorf("GET %s with a matching If-None-Match: %d %q", path, rr.Code, rr.Body.String())
+		}
+	}
+
+	before := doRequest(t, router, "GET", "/api/v1/packages/libfoo", "test-token", nil).Header().Get("ETag")
+	publishWithDeps(t, router, "libfoo", "1.1.0")
+	req := httptest.NewRequest("GET", "/api/v1/packages/libfoo", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("If-None-Match", before)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") == before || !strings.Contains(rr.Body.String(), `"1.1.0"`) {
+		t.Errorf("after a new version: %d, ETag %q (was %q)", rr.Code, rr.Header().Get("ETag"), before)
+	}
+}
+
+func TestListPackagesPaging(t *testing.T) {
+	_, router := setupTestHandler(t)
+	for _, pkg := range []string{"delta", "alpha", "echo", "charlie", "bravo"} {
+		publishWithDeps(t, router, pkg, "1.0.0")
+	}
+
+	var names []string
+	path := "/api/v1/packages?limit=2"
+	for pages := 0; path != ""; pages++ {
+		if pages == 5 {
+			t.Fatal("paging did not end")
+		}
+		rr := doRequest(t, router, "GET", path, "test-token", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", path, rr.Code, rr.Body.String())
+		}
+		var page []models.Package
+		if err := json.NewDecoder(rr.Body).Decode(&page); err != nil || len(page) == 0 || len(page) > 2 {
+			t.Fatalf("GET %s: %v %v", path, page, err)
+		}
+		for _, p := range page {
+			names = append(names, p.Name)
+		}
+		path = ""
+		if link := rr.Header().Get("Link"); link != "" {
+			next, err := url.Parse(strings.TrimPrefix(strings.TrimSuffix(link, `>; rel="next"`), "<"))
+			if err != nil {
+				t.Fatalf("Link %q: %v", link, err)
+			}
+			path = next.RequestURI()
+		}
+	}
+	if got := strings.Join(names, ","); got != "alpha,bravo,charlie,delta,echo" {
+		t.Errorf("paged packages = %s", got)
+	}
+
+	rr := doRequest(t, router, "GET", "/api/v1/packages", "test-token", nil)
+	var all []models.Package
+	if err := json.NewDecoder(rr.Body).Decode(&all); err != nil || len(all) != 5 || rr.Header().Get("Link") != "" {
+		t.Errorf("unpaged list = %v, Link %q", all, rr.Header().Get("Link"))
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/packages?search=a&limit=2", "test-token", nil); !strings.Contains(rr.Header().Get("Link"), "search=a") {
+		t.Errorf("search Link = %q", rr.Header().Get("Link"))
+	}
+	for _, path := range []string{"/api/v1/packages?limit=0", "/api/v1/packages?cursor=%21"} {
+		if rr := doRequest(t, router, "GET", path, "test-token", nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", path, rr.Code)
+		}
+	}
+}
+
+func TestListPackagesSorting(t *testing.T) {
+	_, router := setupTestHandler(t)
+	for _, upload := range []struct {
+		pkg, version string
+		size         int
+	}{{"big", "1.0.0", 1000}, {"tiny", "1.0.0", 10}, {"mid", "1.0.0", 300}, {"mid", "2.0.0", 300}} {
+		body := bytes.Repeat([]byte(upload.version[:1]), upload.size)
+		if rr := doRequest(t, router, "POST", "/api/v1/artifacts/"+upload.pkg+"/"+upload.version, "test-token", body); rr.Code != http.StatusCreated {
+			t.Fatalf("upload %s@%s: %d %s", upload.pkg, upload.version, rr.Code, rr.Body.String())
+		}
+	}
+
+	// list follows the Link header from path, returning every name in order.
+	list := func(path string) string {
+		t.Helper()
+		var names []string
+		for path != "" {
+			rr := doRequest(t, router, "GET", path, "test-token", nil)
+			var page []models.Package
+			if err := json.NewDecoder(rr.Body).Decode(&page); rr.Code != http.StatusOK || err != nil {
+				t.Fatalf("GET %s: %d %v", path, rr.Code, err)
+			}
+			for _, p := range page {
+				names = append(names, p.Name)
+			}
+			path = ""
+			if link := rr.Header().Get("Link"); link != "" {
+				next, _ := url.Parse(strings.TrimPrefix(strings.TrimSuffix(link, `>; rel="next"`), "<"))
+				path = next.RequestURI()
+			}
+		}
+		return strings.Join(names, ",")
+	}
+	for query, want := range map[string]string{
+		"":                            "big,mid,tiny",
+		"?order=desc":                 "tiny,mid,big",
+		"?sort=updated":               "mid,tiny,big",
+		"?sort=updated&order=asc":     "big,tiny,mid",
+		"?sort=size":                  "big,mid,tiny",
+		"?sort=size&order=asc":        "tiny,mid,big",
+		"?sort=updated&limit=1":       "mid,tiny,big",
+		"?sort=size&limit=2":          "big,mid,tiny",
+		"?sort=name&limit=1":          "big,mid,tiny",
+		"?search=i&sort=size&limit=1": "big,mid,tiny",
+	} {
+		if got := list("/api/v1/packages" + query); got != want {
+			t.Errorf("%s: %s, want %s", query, got, want)
+		}
+	}
+
+	rr := doRequest(t, router, "GET", "/api/v1/packages?search=mid", "test-token", nil)
+	var pkgs []models.Package
+	json.NewDecoder(rr.Body).Decode(&pkgs)
+	if len(pkgs) != 1 || pkgs[0].TotalSize != 600 || pkgs[0].UpdatedAt == nil {
+		t.Errorf("mid = %+v", pkgs)
+	}
+	for _, query := range []string{"?sort=downloads", "?order=up", "?sort=size&cursor=" + encodeCursor(1, "big")} {
+		if rr := doRequest(t, router, "GET", "/api/v1/packages"+query, "test-token", nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", query, rr.Code)
+		}
+	}
+}
+
+// stallingWriter stands in for a slow client: its first Write closes
+// started and then waits for resume.
+type stallingWriter struct {
+	*httptest.ResponseRecorder
+	started, resume chan struct{}
+	once            sync.Once
+}
+
+func (w *stallingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.started)
+		<-w.resume
+	})
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestDownloadLease(t *testing.T) {
+	h, router := setupTestHandler(t)
+	h.reclaim = ReclaimImmediate
+	content := bytes.Repeat([]byte("lease"), 50000)
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	// startDownload pulls app@version until the first write stalls it.
+	startDownload := func(version string) (*stallingWriter, chan struct{}) {
+		if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/"+version, "test-token", content); rr.Code != http.StatusCreated {
+			t.Fatalf("upload %s: %d %s", version, rr.Code, rr.Body.String())
+		}
+		w := &stallingWriter{ResponseRecorder: httptest.NewRecorder(), started: make(chan struct{}), resume: make(chan struct{})}
+		req := httptest.NewRequest("GET", "/api/v1/artifacts/app/"+version, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		done := make(chan struct{})
+		go func() {
+			router.ServeHTTP(w, req)
+			close(done)
+		}()
+		<-w.started
+		return w, done
+	}
+
+	// Deleting the version mid-download, then collecting, leaves the blob
+	// to the download, and it goes once the download ends.
+	w, done := startDownload("1.0.0")
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", rr.Code, rr.Body.String())
+	}
+	if !h.blobs.Exists(hash) {
+		t.Fatal("blob deleted while a download was reading it")
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/gc", "test-token", nil); rr.Code != http.StatusOK || !h.blobs.Exists(hash) {
+		t.Fatalf("gc during the download: %d %s, blob exists %v", rr.Code, rr.Body.String(), h.blobs.Exists(hash))
+	}
+	close(w.resume)
+	<-done
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("download = %d, %d bytes, want all %d", w.Code, w.Body.Len(), len(content))
+	}
+	if h.blobs.Exists(hash) {
+		t.Error("blob should be reclaimed once the download ends")
+	}
+
+	// An expired lease protects nothing.
+	fake := clock.NewFake(time.Now())
+	WithClock(fake)(h)
+	w, done = startDownload("2.0.0")
+	fake.Advance(defaultDownloadLease + time.Second)
+	doRequest(t, router, "DELETE", "/api/v1/artifacts/app/2.0.0", "test-token", nil)
+	if h.blobs.Exists(hash) {
+		t.Error("blob kept for a download whose lease expired")
+	}
+	close(w.resume)
+	<-done
+}
+
+func TestListInflightRequests(t *testing.T) {
+	_, router := setupTestHandler(t)
+	content := bytes.Repeat([]byte("inflight"), 50000)
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", content); rr.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rr.Code, rr.Body.String())
+	}
+
+	list := func() []models.InflightRequest {
+		t.Helper()
+		rr := doRequest(t, router, "GET", "/api/v1/admin/requests", "test-token", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("list: %d %s", rr.Code, rr.Body.String())
+		}
+		var reqs []models.InflightRequest
+		if err := json.Unmarshal(rr.Body.Bytes(), &reqs); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return reqs
+	}
+	find := func(reqs []models.InflightRequest, method, path string) *models.InflightRequest {
+		for i := range reqs {
+			if reqs[i].Method == method && reqs[i].Path == path {
+				return &reqs[i]
+			}
+		}
+		return nil
+	}
+
+	// A download stalled on its first write.
+	w := &stallingWriter{ResponseRecorder: httptest.NewRecorder(), started: make(chan struct{}), resume: make(chan struct{})}
+	req := httptest.NewRequest("GET", "/api/v1/artifacts/app/1.0.0", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	downloaded := make(chan struct{})
+	go func() {
+		router.ServeHTTP(w, req)
+		close(downloaded)
+	}()
+	<-w.started
+
+	// An upload whose client has sent part of the body.
+	pr, pw := io.Pipe()
+	upload := httptest.NewRequest("POST", "/api/v1/artifacts/app/2.0.0", pr)
+	upload.Header.Set("Authorization", "Bearer test-token")
+	uploaded := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, upload)
+		uploaded <- rr
+	}()
+	if _, err := pw.Write(content[:1000]); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// The write returns once the server has the bytes, which may be just
+	// before they are counted.
+	reqs := list()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); reqs = list() {
+		if up := find(reqs, "POST", "/api/v1/artifacts/app/2.0.0"); up != nil && up.BytesIn == 1000 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	download := find(reqs, "GET", "/api/v1/artifacts/app/1.0.0")
+	if download == nil {
+		t.Fatalf("download not listed: %+v", reqs)
+	}
+	if download.ID != w.Header().Get("X-Request-ID") || download.DurationMillis < 0 {
+		t.Errorf("download = %+v", download)
+	}
+	up := find(reqs, "POST", "/api/v1/artifacts/app/2.0.0")
+	if up == nil || up.BytesIn != 1000 || up.BytesOut != 0 {
+		t.Errorf("upload = %+v, want 1000 bytes in", up)
+	}
+	if self := find(reqs, "GET", "/api/v1/admin/requests"); self == nil {
+		t.Errorf("listing not listed: %+v", reqs)
+	}
+	if len(reqs) != 3 || reqs[0].StartedAt.After(reqs[2].StartedAt) {
+		t.Errorf("want 3 requests oldest first, got %+v", reqs)
+	}
+
+	close(w.resume)
+	<-downloaded
+	pw.Close()
+	if rr := <-uploaded; rr.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rr.Code, rr.Body.String())
+	}
+	if reqs := list(); len(reqs) != 1 || reqs[0].Path != "/api/v1/admin/requests" {
+		t.Errorf("after finishing, got %+v", reqs)
+	}
+
+	if rr := doRequest(t, router, "GET", "/api/v1/admin/requests", "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated: %d", rr.Code)
+	}
+}
+
+func TestMultipartUploadMetadata(t *testing.T) {
+	_, router := setupTestHandler(t)
+	content := []byte("tool-bytes")
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	// upload sends the file with metadata parts before and after it, the
+	// way curl -F sends them in order.
+	upload := func(version string, before, after []string) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		addMetadata := func(meta string) {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", `form-data; name="metadata"; filename="meta.json"`)
+			header.Set("Content-Type", "application/json")
+			part, _ := mw.CreatePart(header)
+			part.Write([]byte(meta))
+		}
+		for _, meta := range before {
+			addMetadata(meta)
+		}
+		part, _ := mw.CreateFormFile("file", "tool.tar.gz")
+		part.Write(content)
+		for _, meta := range after {
+			addMetadata(meta)
+		}
+		mw.Close()
+		req := httptest.NewRequest("POST", "/api/v1/artifacts/tool/"+version, &buf)
+		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// version reads a version from the package detail.
+	version := func(v string) *models.Artifact {
+		t.Helper()
+		rr := doRequest(t, router, "GET", "/api/v1/packages/tool", "test-token", nil)
+		var detail struct {
+			Versions []models.Artifact `json:"versions"`
+		}
+		json.NewDecoder(rr.Body).Decode(&detail)
+		for i := range detail.Versions {
+			if detail.Versions[i].Version == v {
+				return &detail.Versions[i]
+			}
+		}
+		return nil
+	}
+
+	meta := `{"description": " Command line tool ", "labels": {"team": "infra", "ci/build": "1234"},
+		"dependencies": [{"package": "libfoo", "constraint": "^1.2"}, {"package": "libbar"}], "hash": "` + hash + `"}`
+	rr := upload("1.0.0", []string{meta}, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rr.Code, rr.Body.String())
+	}
+	var uploaded models.UploadResponse
+	json.NewDecoder(rr.Body).Decode(&uploaded)
+	wantLabels := map[string]string{"team": "infra", "ci/build": "1234"}
+	wantDeps := []models.Dependency{{Package: "libfoo", Constraint: "^1.2"}, {Package: "libbar", Constraint: "*"}}
+	if uploaded.Filename != "tool.tar.gz" || uploaded.Description != "Command line tool" || !maps.Equal(uploaded.Labels, wantLabels) || !slices.Equal(uploaded.Dependencies, wantDeps) {
+		t.Errorf("upload response = %+v", uploaded)
+	}
+
+	if a := version("1.0.0"); a == nil || a.Description != "Command line tool" || !maps.Equal(a.Labels, wantLabels) {
+		t.Errorf("stored version = %+v", a)
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/tool/1.0.0/dependencies", "test-token", nil)
+	var deps models.DependenciesResponse
+	json.NewDecoder(rr.Body).Decode(&deps)
+	if !slices.Equal(deps.Dependencies, wantDeps) {
+		t.Errorf("dependencies = %d %+v", rr.Code, deps)
+	}
+
+	// Metadata may follow the file.
+	if rr := upload("1.1.0", nil, []string{`{"labels": {"team": "infra"}}`}); rr.Code != http.StatusCreated {
+		t.Fatalf("metadata after file: %d %s", rr.Code, rr.Body.String())
+	}
+	if a := version("1.1.0"); a == nil || a.Labels["team"] != "infra" {
+		t.Errorf("metadata after file: stored version = %+v", a)
+	}
+
+	for name, tc := range map[string]struct {
+		before, after []string
+	}{
+		"digest mismatch":      {before: []string{`{"hash": "` + strings.Repeat("0", 64) + `"}`}},
+		"late digest mismatch": {after: []string{`{"hash": "` + strings.Repeat("0", 64) + `"}`}},
+		"invalid JSON":         {before: []string{`{"labels": [`}},
+		"invalid label":        {before: []string{`{"labels": {"-bad": "x"}}`}},
+		"control in value":     {before: []string{`{"labels": {"team": "a\nb"}}`}},
+		"self dependency":      {before: []string{`{"dependencies": [{"package": "tool"}]}`}},
+		"bad constraint":       {before: []string{`{"dependencies": [{"package": "libfoo", "constraint": "~~1"}]}`}},
+		"two metadata parts":   {before: []string{`{}`}, after: []string{`{}`}},
+	} {
+		if rr := upload("2.0.0", tc.before, tc.after); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d %s", name, rr.Code, rr.Body.String())
+		}
+	}
+	if a := version("2.0.0"); a != nil {
+		t.Errorf("rejected uploads left a version: %+v", a)
+	}
+}
+
+func TestIdempotentUploads(t *testing.T) {
+	h, router := setupTestHandler(t)
+	content := []byte("release-bytes")
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", content)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rr.Code, rr.Body.String())
+	}
+	var first models.UploadResponse
+	json.NewDecoder(rr.Body).Decode(&first)
+
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", content); rr.Code != http.StatusConflict {
+		t.Errorf("repeat while disabled: expected 409, got %d", rr.Code)
+	}
+
+	h.idempotentUploads = true
+	rr = doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", content)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("repeat: expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	var repeat models.UploadResponse
+	json.NewDecoder(rr.Body).Decode(&repeat)
+	if repeat.Package != "app" || repeat.Hash != hash || !repeat.UploadedAt.Equal(first.UploadedAt) {
+		t.Errorf("repeat = %+v, want the stored version %+v", repeat, first)
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "test-token", []byte("other")); rr.Code != http.StatusConflict {
+		t.Errorf("different content: expected 409, got %d", rr.Code)
+	}
+
+	link := func(hash string) int {
+		body, _ := json.Marshal(models.LinkRequest{Hash: hash})
+		return doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0/link", "test-token", body).Code
+	}
+	if code := link(hash); code != http.StatusOK {
+		t.Errorf("repeated link: expected 200, got %d", code)
+	}
+	if code := link(strings.Repeat("a", 64)); code != http.StatusConflict {
+		t.Errorf("link to other blob: expected 409, got %d", code)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/packages/app", "test-token", nil)
+	var detail struct {
+		Versions []models.Artifact `json:"versions"`
+	}
+	json.NewDecoder(rr.Body).Decode(&detail)
+	if len(detail.Versions) != 1 || detail.Versions[0].Revision != 1 {
+		t.Errorf("repeats changed the version: %+v", detail.Versions)
+	}
+}
+
+// blockingHook holds pre-upload hooks until release is closed, signalling
+// entered as each one starts.
+type blockingHook struct {
+	entered, release chan struct{}
+}
+
+func (b *blockingHook) Run(_ context.Context, event models.HookEvent) error {
+	if event.Hook == models.HookPreUpload {
+		b.entered <- struct{}{}
+		<-b.release
+	}
+	return nil
+}
+
+func TestUploadProgress(t *testing.T) {
+	h, router := setupTestHandler(t)
+	hook := &blockingHook{entered: make(chan struct{}), release: make(chan struct{})}
+	h.hooks = []services.Hook{hook}
+	content := bytes.Repeat([]byte("progress"), 1000)
+
+	progress := func(id string) (int, models.UploadProgress) {
+		t.Helper()
+		rr := doRequest(t, router, "GET", "/api/v1/uploads/"+id, "test-token", nil)
+		var p models.UploadProgress
+		json.Unmarshal(rr.Body.Bytes(), &p)
+		return rr.Code, p
+	}
+	// waitFor polls the upload until ok accepts its progress.
+	waitFor := func(id string, ok func(models.UploadProgress) bool) models.UploadProgress {
+		t.Helper()
+		var p models.UploadProgress
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if _, p = progress(id); ok(p) {
+				return p
+			}
+		}
+		t.Fatalf("upload %s stuck at %+v", id, p)
+		return p
+	}
+
+	pr, pw := io.Pipe()
+	req := httptest.NewRequest("POST", "/api/v1/artifacts/app/1.0.0", pr)
+	req.ContentLength = int64(len(content))
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("X-Upload-ID", "job-1")
+	uploaded := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		uploaded <- rr
+	}()
+
+	pw.Write(content[:3000])
+	p := waitFor("job-1", func(p models.UploadProgress) bool { return p.ReceivedBytes == 3000 })
+	if p.State != models.UploadReceiving || p.TotalBytes != int64(len(content)) || p.Package != "app" || p.Version != "1.0.0" || p.FinishedAt != nil {
+		t.Errorf("while receiving: %+v", p)
+	}
+
+	// Once the bytes are in, the pre-upload hook holds the upload in
+	// verification.
+	pw.Write(content[3000:])
+	pw.Close()
+	<-hook.entered
+	if _, p := progress("job-1"); p.State != models.UploadVerifying || p.ReceivedBytes != int64(len(content)) {
+		t.Errorf("while verifying: %+v", p)
+	}
+	again := httptest.NewRequest("POST", "/api/v1/artifacts/app/1.1.0", bytes.NewReader(content))
+	again.Header.Set("Authorization", "Bearer test-token")
+	again.Header.Set("X-Upload-ID", "job-1")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, again)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("reused running upload ID: expected 409, got %d", rr.Code)
+	}
+
+	close(hook.release)
+	if rr := <-uploaded; rr.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, p := progress("job-1"); p.State != models.UploadDone || p.Status != http.StatusCreated || p.FinishedAt == nil {
+		t.Errorf("after upload: %+v", p)
+	}
+
+	// A failed upload reports its status.
+	dup := httptest.NewRequest("POST", "/api/v1/artifacts/app/1.0.0", bytes.NewReader(content))
+	dup.Header.Set("Authorization", "Bearer test-token")
+	dup.Header.Set("X-Upload-ID", "job-2")
+	router.ServeHTTP(httptest.NewRecorder(), dup)
+	if _, p := progress("job-2"); p.State != models.UploadFailed || p.Status != http.StatusConflict {
+		t.Errorf("failed upload: %+v", p)
+	}
+
+	if code, _ := progress("unknown"); code != http.StatusNotFound {
+		t.Errorf("unknown upload: expected 404, got %d", code)
+	}
+	bad := httptest.NewRequest("POST", "/api/v1/artifacts/app/2.0.0", bytes.NewReader(content))
+	bad.Header.Set("Authorization", "Bearer test-token")
+	bad.Header.Set("X-Upload-ID", "no spaces")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, bad)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid upload ID: expected 400, got %d", rr.Code)
+	}
+}
+
+func TestStorageMetrics(t *testing.T) {
+	h, router := setupTestHandler(t)
+
+	doRequest(t, router, "POST", "/api/v1/artifacts/mylib/1.0.0", "test-token", []byte("data"))
+
+	var stats models.BlobWriteStats
+	rr := doRequest(t, router, "GET", "/api/v1/admin/metrics/storage", "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+	if rr.Code != http.StatusOK || stats.Blobs != 1 || stats.Bytes != 4 || stats.Buffers == 0 {
+		t.Fatalf("storage metrics: %d %s", rr.Code, rr.Body.String())
+	}
+
+	h.blobs = storage.NewMemoryBlobStorage()
+	if rr := doRequest(t, router, "GET", "/api/v1/admin/metrics/storage", "test-token", nil); rr.Code != http.StatusNotImplemented {
+		t.Errorf("memory storage: expected 501, got %d", rr.Code)
+	}
+}
+
+// fileRecorder is a ResponseRecorder that takes bodies through ReadFrom,
+// as a connection that can sendfile does, noting whether it got a file.
+type fileRecorder struct {
+	*httptest.ResponseRecorder
+	files int
+}
+
+func (r *fileRecorder) ReadFrom(src io.Reader) (int64, error) {
+	file := src
+	if lr, ok := src.(*io.LimitedReader); ok {
+		file = lr.R
+	}
+	if _, ok := file.(*os.File); ok {
+		r.files++
+	}
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func TestDownloadSendsFile(t *testing.T) {
+	h, router := setupTestHandler(t)
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	doRequest(t, router, "POST", "/api/v1/artifacts/mylib/1.0.0", "test-token", data)
+
+	download := func(rangeHeader string) *fileRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/artifacts/mylib/1.0.0", nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rec := &fileRecorder{ResponseRecorder: httptest.NewRecorder()}
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := download("")
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), data) || rec.files == 0 {
+		t.Errorf("download: %d, %d bytes, file passed through %d times", rec.Code, rec.Body.Len(), rec.files)
+	}
+	rec = download("bytes=9995-")
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "56789" || rec.files == 0 {
+		t.Errorf("range: %d %q, file passed through %d times", rec.Code, rec.Body.String(), rec.files)
+	}
+
+	// Paced downloads still hand the file over, a second's worth at a time.
+	WithTransferLimits(TransferLimits{DownloadBytesPerSecond: 4000})(h)
+	rec = download("")
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), data) || rec.files < 3 {
+		t.Errorf("paced download: %d, %d bytes, file passed through %d times", rec.Code, rec.Body.Len(), rec.files)
+	}
+}
+
+func TestImportArtifact(t *testing.T) {
+	h, router := setupTestHandler(t)
+	share := t.TempDir()
+	file := filepath.Join(share, "app", "app-1.0.0.tar.gz")
+	os.MkdirAll(filepath.Dir(file), 0o755)
+	os.WriteFile(file, []byte("release from the share"), 0o644)
+	body := func(v any) []byte {
+		data, _ := json.Marshal(v)
+		return data
+	}
+	req := body(models.ImportRequest{Path: file, Description: "from the share"})
+
+	if rr := doRequest(t, router, "POST", "/api/v1/admin/import/app/1.0.0", "test-token", req); rr.Code != http.StatusNotImplemented {
+		t.Errorf("imports disabled: expected 501, got %d", rr.Code)
+	}
+
+	WithImportDirs([]string{share})(h)
+	outside := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(outside, []byte("secret"), 0o644)
+	os.Symlink(outside, filepath.Join(share, "link"))
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"app/app-1.0.0.tar.gz", http.StatusBadRequest},
+		{outside, http.StatusForbidden},
+		{filepath.Join(share, "link"), http.StatusForbidden},
+		{filepath.Join(share, "missing"), http.StatusNotFound},
+		{filepath.Join(share, "app"), http.StatusBadRequest},
+	} {
+		if rr := doRequest(t, router, "POST", "/api/v1/admin/import/app/1.0.0", "test-token", body(models.ImportRequest{Path: tc.path})); rr.Code != tc.want {
+			t.Errorf("import %s: expected %d, got %d %s", tc.path, tc.want, rr.Code, rr.Body.String())
+		}
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/admin/import/app/1.0.0", "test-token", body(models.ImportRequest{Path: file, Hash: strings.Repeat("0", 64)})); rr.Code != http.StatusBadRequest {
+		t.Errorf("digest mismatch: expected 400, got %d", rr.Code)
+	}
+
+	rr := doRequest(t, router, "POST", "/api/v1/admin/import/app/1.0.0", "test-token", req)
+	var imported models.ImportResponse
+	json.Unmarshal(rr.Body.Bytes(), &imported)
+	if rr.Code != http.StatusCreated || imported.Import == models.ImportHardLink || imported.Filename != "app-1.0.0.tar.gz" || imported.Description != "from the share" {
+		t.Fatalf("import: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil)
+	if rr.Body.String() != "release from the share" {
+		t.Errorf("download of the import: %q", rr.Body.String())
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Error("importing removed the original")
+	}
+	if d, _ := h.meta.GetBlobDigests(imported.Hash); d == nil || d.SHA512 == "" {
+		t.Error("import did not record the blob's digests")
+	}
+
+	if rr := doRequest(t, router, "POST", "/api/v1/admin/import/app/1.0.0", "test-token", req); rr.Code != http.StatusConflict {
+		t.Errorf("repeated import: expected 409, got %d", rr.Code)
+	}
+
+	// Hard links are only made when asked for.
+	rr = doRequest(t, router, "POST", "/api/v1/admin/import/app/0.9.0", "test-token", body(models.ImportRequest{Path: file, Link: true}))
+	json.Unmarshal(rr.Body.Bytes(), &imported)
+	if rr.Code != http.StatusCreated || imported.Import == models.ImportCopy {
+		t.Errorf("linked import: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// In process, as registry-server import runs it.
+	res, err := h.Import(context.Background(), &models.Principal{Name: "import", Admin: true}, "app", "0.8.0", models.ImportRequest{Path: file})
+	if err != nil || res.Hash != imported.Hash || res.Import == "" {
+		t.Errorf("Import: %+v, %v", res, err)
+	}
+	if _, err := h.Import(context.Background(), &models.Principal{Name: "import", Admin: true}, "app", "0.8.1", models.ImportRequest{Path: outside}); err == nil || !strings.Contains(err.Error(), "outside the import directories") {
+		t.Errorf("Import from outside: %v", err)
+	}
+
+	// Backends that cannot link take a copy.
+	h.blobs = storage.NewMemoryBlobStorage()
+	rr = doRequest(t, router, "POST", "/api/v1/admin/import/app/1.0.1", "test-token", req)
+	json.Unmarshal(rr.Body.Bytes(), &imported)
+	if rr.Code != http.StatusCreated || imported.Import != models.ImportCopy {
+		t.Errorf("import into memory storage: %d %s", rr.Code, rr.Body.String())
+	}
+
+	h.auth = principalAuth{"ci-token": {TokenID: 2, Name: "ci"}}
+	if rr := doRequest(t, router, "POST", "/api/v1/admin/import/app/1.0.2", "ci-token", req); rr.Code != http.StatusForbidden {
+		t.Errorf("non-admin import: expected 403, got %d", rr.Code)
+	}
+}
+
+func TestSnapshotVersions(t *testing.T) {
+	// An archive made by another registry, reopened read-only.
+	archive := t.TempDir()
+	archiveBlobs, err := storage.NewDiskBlobStorage(archive)
+	if err != nil {
+		t.Fatalf("NewDiskBlobStorage: %v", err)
+	}
+	hash, size, err := archiveBlobs.Store(strings.NewReader("archived release"))
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	archiveMeta, err := metadata.NewSQLiteStore(archive)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	archiveMeta.CreateArtifactForPackage("app", models.ArtifactInput{Version: "1.0.0", Hash: hash, Size: size})
+	archiveMeta.Close()
+	snapshot, err := metadata.OpenSnapshot(archive)
+	if err != nil {
+		t.Fatalf("OpenSnapshot: %v", err)
+	}
+	t.Cleanup(func() { snapshot.Close() })
+
+	h, _ := setupTestHandler(t)
+	h.blobs = storage.NewLayeredBlobStorage(h.blobs, archiveBlobs)
+	h.meta = metadata.NewLayeredStore(h.meta, metadata.Snapshot{Name: "2019-2022", Store: snapshot})
+	router := h.Router()
+
+	rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "archived release" {
+		t.Fatalf("download from the snapshot: %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/2.0.0", "test-token", []byte("live release")); rr.Code != http.StatusCreated {
+		t.Fatalf("upload beside the snapshot: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/packages/app", "test-token", nil)
+	var info models.PackageInfo
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	from := make(map[string]string)
+	for _, v := range info.Versions {
+		from[v.Version] = v.Snapshot
+	}
+	if len(from) != 2 || from["1.0.0"] != "2019-2022" || from["2.0.0"] != "" {
+		t.Errorf("package versions: %s", rr.Body.String())
+	}
+
+	for _, req := range []struct{ method, path string }{
+		{"POST", "/api/v1/artifacts/app/1.0.0"},
+		{"PUT", "/api/v1/artifacts/app/1.0.0/pin"},
+		{"DELETE", "/api/v1/artifacts/app/1.0.0"},
+	} {
+		rr := doRequest(t, router, req.method, req.path, "test-token", []byte("changed"))
+		if rr.Code != http.StatusConflict {
+			t.Errorf("%s %s: expected 409, got %d %s", req.method, req.path, rr.Code, rr.Body.String())
+		}
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Body.String() != "archived release" {
+		t.Error("the snapshot version changed")
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+	h, router := setupTestHandler(t)
+	key := auth.SigningKey{ID: "promoter", Secret: []byte("0123456789abcdef0123456789abcdef"), Admin: true}
+	signed := func(method, target string, body []byte, header string) *httptest.ResponseRecorder {
+		sum := sha256.Sum256(body)
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		req.Header.Set(auth.ContentHashHeader, hex.EncodeToString(sum[:]))
+		if header == "" {
+			header = auth.Sign(key, time.Now().Unix(), method, target, hex.EncodeToString(sum[:]))
+		}
+		req.Header.Set("Authorization", header)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := signed("GET", "/api/v1/packages", nil, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("signed request while disabled: expected 401, got %d", rr.Code)
+	}
+
+	WithSignedRequests(auth.NewSignedRequests([]auth.SigningKey{key}, 5*time.Minute, clock.System))(h)
+	if rr := signed("POST", "/api/v1/artifacts/app/1.0.0", []byte("release"), ""); rr.Code != http.StatusCreated {
+		t.Fatalf("signed upload: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := signed("GET", "/api/v1/artifacts/app/1.0.0", nil, ""); rr.Code != http.StatusOK || rr.Body.String() != "release" {
+		t.Errorf("signed download: %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := signed("GET", "/api/v1/admin/stats", nil, ""); rr.Code != http.StatusOK {
+		t.Errorf("admin route with an admin key: %d", rr.Code)
+	}
+
+	// A body other than the signed one is refused, before the handler runs
+	// when it is small and as it streams when it is large.
+	sum := sha256.Sum256([]byte("release"))
+	header := auth.Sign(key, time.Now().Unix(), "POST", "/api/v1/artifacts/app/1.0.1", hex.EncodeToString(sum[:]))
+	req := httptest.NewRequest("POST", "/api/v1/artifacts/app/1.0.1", strings.NewReader("tampered"))
+	req.Header.Set("Authorization", header)
+	req.Header.Set(auth.ContentHashHeader, hex.EncodeToString(sum[:]))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("tampered small body: expected 400, got %d", rr.Code)
+	}
+	large := bytes.Repeat([]byte("x"), 2<<20)
+	sum = sha256.Sum256(large)
+	header = auth.Sign(key, time.Now().Unix(), "POST", "/api/v1/artifacts/app/1.0.2", hex.EncodeToString(sum[:]))
+	large[len(large)-1] = 'y'
+	req = httptest.NewRequest("POST", "/api/v1/artifacts/app/1.0.2", io.NopCloser(bytes.NewReader(large)))
+	req.ContentLength = -1
+	req.Header.Set("Authorization", header)
+	req.Header.Set(auth.ContentHashHeader, hex.EncodeToString(sum[:]))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("tampered streamed body: expected 400, got %d %s", rr.Code, rr.Body.String())
+	}
+	if a, _ := h.meta.GetArtifact("app", "1.0.2"); a != nil {
+		t.Error("a tampered upload was recorded")
+	}
+
+	stale := auth.Sign(key, time.Now().Add(-time.Hour).Unix(), "GET", "/api/v1/packages", auth.EmptyContentHash)
+	if rr := signed("GET", "/api/v1/packages", nil, stale); rr.Code != http.StatusUnauthorized {
+		t.Errorf("stale signature: expected 401, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/packages", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("bearer tokens should still work: %d", rr.Code)
+	}
+}
+
+func TestTokenUsage(t *testing.T) {
+	dir := t.TempDir()
+	blobs, _ := storage.NewDiskBlobStorage(dir)
+	meta, err := metadata.NewSQLiteStore(dir)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { meta.Close() })
+	authenticator := auth.Chain{auth.NewTokenAuth([]string{"test-token"}), auth.NewStoreAuth(meta)}
+	h := New(blobs, meta, authenticator, zerolog.Nop(), WithTokenStore(meta))
+	router := h.Router()
+
+	rr := doRequest(t, router, "POST", "/api/v1/admin/tokens", "test-token", []byte(`{"name":"ci"}`))
+	var created models.CreateTokenResponse
+	json.NewDecoder(rr.Body).Decode(&created)
+	path := "/api/v1/admin/tokens/" + strconv.FormatInt(created.ID, 10) + "/usage"
+
+	getUsage := func() models.TokenUsage {
+		t.Helper()
+		rr := doRequest(t, router, "GET", path, "test-token", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+		var usage models.TokenUsage
+		json.NewDecoder(rr.Body).Decode(&usage)
+		return usage
+	}
+	if usage := getUsage(); usage.Requests != 0 || usage.LastUsedAt != nil {
+		t.Errorf("unused token: %+v", usage)
+	}
+
+	content := []byte("token usage content")
+	upload := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", created.Token, content)
+	if upload.Code != http.StatusCreated {
+		t.Fatalf("upload: %d: %s", upload.Code, upload.Body.String())
+	}
+	download := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", created.Token, nil)
+	if download.Code != http.StatusOK {
+		t.Fatalf("download: %d", download.Code)
+	}
+	downloaded := int64(upload.Body.Len() + download.Body.Len())
+	// Requests made with config tokens are not counted toward it.
+	doRequest(t, router, "GET", "/api/v1/packages", "test-token", nil)
+
+	// Usage not yet flushed is included, and flushing does not count it
+	// twice.
+	for _, flush := range []bool{false, true} {
+		if flush {
+			if err := h.FlushTokenUsage(); err != nil {
+				t.Fatalf("FlushTokenUsage: %v", err)
+			}
+		}
+		usage := getUsage()
+		if usage.Requests != 2 || usage.BytesUploaded != int64(len(content)) || usage.BytesDownloaded != downloaded || usage.LastUsedAt == nil {
+			t.Errorf("flushed=%t: unexpected usage %+v", flush, usage)
+		}
+	}
+	if stored, err := meta.TokenUsage(created.ID); err != nil || stored.Requests != 2 {
+		t.Errorf("stored usage = %+v, %v", stored, err)
+	}
+
+	if rr := doRequest(t, router, "GET", path, created.Token, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("non-admin token: expected 403, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/admin/tokens/99/usage", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown token: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestAuthLockouts(t *testing.T) {
+	h, router := setupTestHandler(t)
+	WithLockouts(auth.NewLockouts(3, time.Minute, time.Minute, clock.System))(h)
+	key := auth.SigningKey{ID: "promoter", Secret: []byte("0123456789abcdef0123456789abcdef")}
+	WithSignedRequests(auth.NewSignedRequests([]auth.SigningKey{key}, 5*time.Minute, clock.System))(h)
+	from := func(addr, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/packages", nil)
+		req.RemoteAddr = addr + ":40000"
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+			req.Header.Set(auth.ContentHashHeader, auth.EmptyContentHash)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Requests without credentials, as package managers send before
+	// authenticating, do not count.
+	for range 5 {
+		if rr := from("10.0.0.1", ""); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("anonymous: expected 401, got %d", rr.Code)
+		}
+	}
+	for i := range 3 {
+		if rr := from("10.0.0.1", "Bearer guess-"+strconv.Itoa(i)); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: expected 401, got %d", i, rr.Code)
+		}
+	}
+	// Once locked out, even a valid token is refused.
+	rr := from("10.0.0.1", "Bearer test-token")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("locked out client: expected 429 with Retry-After, got %d", rr.Code)
+	}
+	if rr := from("10.0.0.2", "Bearer test-token"); rr.Code != http.StatusOK {
+		t.Errorf("other client: expected 200, got %d", rr.Code)
+	}
+
+	// Bad signatures count against the address they come from, not the
+	// key they name, so nobody can lock a signing key out for its owner.
+	now := time.Now().Unix()
+	wrong := auth.SigningKey{ID: key.ID, Secret: []byte("fedcba9876543210fedcba9876543210")}
+	for i := range 3 {
+		if rr := from("10.0.1.1", auth.Sign(wrong, now+int64(i), "GET", "/api/v1/packages", auth.EmptyContentHash)); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("bad signature %d: expected 401, got %d", i, rr.Code)
+		}
+	}
+	if rr := from("10.0.1.1", auth.Sign(key, now, "GET", "/api/v1/packages", auth.EmptyContentHash)); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("locked out address: expected 429, got %d", rr.Code)
+	}
+	if rr := from("10.0.2.1", auth.Sign(key, now+1, "GET", "/api/v1/packages", auth.EmptyContentHash)); rr.Code != http.StatusOK {
+		t.Errorf("key owner elsewhere: expected 200, got %d", rr.Code)
+	}
+
+	// Peers of an untrusted Unix socket have no address and do not share a
+	// lockout.
+	onSocket := func(authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/packages", nil)
+		req.RemoteAddr = "@"
+		req.Header.Set("Authorization", authorization)
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		ctx := context.WithValue(req.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/foundry.sock", Net: "unix"})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(ctx))
+		return rr
+	}
+	for i := range 4 {
+		if rr := onSocket("Bearer guess-" + strconv.Itoa(i)); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("socket guess %d: expected 401, got %d", i, rr.Code)
+		}
+	}
+	if rr := onSocket("Bearer test-token"); rr.Code != http.StatusOK {
+		t.Errorf("socket client: expected 200, got %d", rr.Code)
+	}
+
+	rr = doRequest(t, router, "GET", "/api/v1/admin/metrics/auth", "test-token", nil)
+	var metrics models.AuthMetrics
+	json.NewDecoder(rr.Body).Decode(&metrics)
+	if rr.Code != http.StatusOK || metrics.Failures != 6 || metrics.Lockouts != 2 || metrics.Refused != 2 || len(metrics.Locked) != 2 {
+		t.Errorf("unexpected auth metrics (%d): %+v", rr.Code, metrics)
+	}
+}
+
+func TestScanReportAccess(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.auth = principalAuth{
+		"test-token":    {Name: "config", Admin: true},
+		"ci-token":      {TokenID: 2, Name: "ci"},
+		"scanner-token": {TokenID: 3, Name: "trivy"},
+	}
+	h.blockSeverity = "high"
+	WithScanners([]string{"trivy"})(h)
+	router := h.Router()
+	for _, v := range []string{"1.0.0", "2.0.0"} {
+		if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/"+v, "test-token", []byte("app "+v)); rr.Code != http.StatusCreated {
+			t.Fatalf("upload %s: %d", v, rr.Code)
+		}
+	}
+
+	high := `{"SchemaVersion":2,"Results":[{"Vulnerabilities":[
+		{"VulnerabilityID":"CVE-1","PkgName":"a","InstalledVersion":"1.0","Severity":"HIGH"}]}]}`
+	clean := `{"SchemaVersion":2,"Results":[]}`
+
+	if rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/2.0.0/scan", "ci-token", []byte(clean)); rr.Code != http.StatusForbidden {
+		t.Errorf("non-admin report: expected 403, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/scan", "scanner-token", []byte(high)); rr.Code != http.StatusOK {
+		t.Fatalf("scanner report: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Neither a stray token nor the scanner can lift the block with a clean
+	// report; an admin can.
+	for _, token := range []string{"ci-token", "scanner-token"} {
+		if rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/scan", token, []byte(clean)); rr.Code != http.StatusForbidden {
+			t.Errorf("%s clean report over a block: expected 403, got %d", token, rr.Code)
+		}
+		if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Code != http.StatusForbidden {
+			t.Errorf("after %s report: expected the version to stay blocked, got %d", token, rr.Code)
+		}
+	}
+	if rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/scan", "scanner-token", []byte(high)); rr.Code != http.StatusOK {
+		t.Errorf("scanner report keeping the block: %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "PUT", "/api/v1/artifacts/app/1.0.0/scan", "test-token", []byte(clean)); rr.Code != http.StatusOK {
+		t.Fatalf("admin clean report: %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Code != http.StatusOK {
+		t.Errorf("after admin rescan: expected 200, got %d", rr.Code)
+	}
+}
+
+func TestOwnershipCoversVersionChanges(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	store := h.meta.(*metadata.SQLiteStore)
+	WithAccounts(store)(h)
+	WithScanners([]string{"mallory-ci"})(h)
+	h.crates = h.meta.(services.CrateIndex)
+	h.auth = principalAuth{
+		"test-token":    {Name: "config", Admin: true},
+		"mallory-token": {Name: "mallory-ci", User: "mallory"},
+		"sso-token":     {Name: "sso", User: "sam", Groups: []string{"eng-platform"}},
+	}
+	router := h.Router()
+
+	for _, req := range []struct{ method, path, body string }{
+		{"POST", "/api/v1/admin/teams", `{"name":"platform","sso_group":"eng-platform"}`},
+		{"PUT", "/api/v1/admin/packages/mylib/owners/platform", ""},
+		{"PUT", "/api/v1/admin/packages/mycrate/owners/platform", ""},
+		{"POST", "/api/v1/artifacts/mylib/1.0.0", "data"},
+	} {
+		if rr := doRequest(t, router, req.method, req.path, "test-token", []byte(req.body)); rr.Code >= 300 {
+			t.Fatalf("%s %s: %d %s", req.method, req.path, rr.Code, rr.Body.String())
+		}
+	}
+	if rr := doRequest(t, router, "PUT", "/cargo/api/v1/crates/new", "test-token",
+		cargoPublishBody(t, cargoPublishMetadata{Name: "mycrate", Vers: "0.1.0"}, []byte("crate"))); rr.Code != http.StatusOK {
+		t.Fatalf("publish crate: %d %s", rr.Code, rr.Body.String())
+	}
+
+	sbom := `{"bomFormat":"CycloneDX","specVersion":"1.5","components":[]}`
+	scan := `{"SchemaVersion":2,"Results":[]}`
+	for _, req := range []struct{ method, path, body string }{
+		{"PUT", "/api/v1/artifacts/mylib/1.0.0/dependencies", `{"dependencies":[]}`},
+		{"PUT", "/api/v1/artifacts/mylib/1.0.0/sbom", sbom},
+		{"PUT", "/api/v1/artifacts/mylib/1.0.0/scan", scan},
+		{"POST", "/api/v1/artifacts/mylib/1.0.0/attestations", `{}`},
+		{"PUT", "/api/v1/artifacts/mylib/1.0.0/pin", ""},
+		{"PUT", "/api/v1/artifacts/mylib/1.0.0/notes", "# 1.0.0"},
+		{"DELETE", "/cargo/api/v1/crates/mycrate/0.1.0/yank", ""},
+		{"PUT", "/cargo/api/v1/crates/mycrate/0.1.0/unyank", ""},
+	} {
+		rr := doRequest(t, router, req.method, req.path, "mallory-token", []byte(req.body))
+		if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "owned by platform") {
+			t.Errorf("%s %s by a non-owner: %d %s", req.method, req.path, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Owners make the same changes.
+	for _, req := range []struct{ method, path, body string }{
+		{"PUT", "/api/v1/artifacts/mylib/1.0.0/dependencies", `{"dependencies":[]}`},
+		{"PUT", "/api/v1/artifacts/mylib/1.0.0/sbom", sbom},
+		{"PUT", "/api/v1/artifacts/mylib/1.0.0/pin", ""},
+		{"DELETE", "/cargo/api/v1/crates/mycrate/0.1.0/yank", ""},
+	} {
+		if rr := doRequest(t, router, req.method, req.path, "sso-token", []byte(req.body)); rr.Code != http.StatusOK {
+			t.Errorf("%s %s by an owner: %d %s", req.method, req.path, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestOnlyAdminsUploadStraightToRelease(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.defaultStage = models.StageStaging
+	h.auth = principalAuth{
+		"test-token": {Name: "release-manager", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+	}
+	router := h.Router()
+
+	upload := func(token, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-Artifact-Stage", models.StageRelease)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := upload("ci-token", "/api/v1/artifacts/lib/1.0.0", "lib"); rr.Code != http.StatusForbidden {
+		t.Fatalf("non-admin release upload: expected 403, got %d", rr.Code)
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/lib/1.0.0", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("refused upload was stored: %d", rr.Code)
+	}
+	rr := upload("test-token", "/api/v1/artifacts/lib/1.0.0", "lib")
+	var uploaded models.UploadResponse
+	json.Unmarshal(rr.Body.Bytes(), &uploaded)
+	if rr.Code != http.StatusCreated || uploaded.Stage != models.StageRelease {
+		t.Fatalf("admin release upload: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Copies take the same header and the same rule.
+	if rr := upload("ci-token", "/api/v1/artifacts/lib/1.0.0/copy", `{"package":"lib2","version":"1.0.0"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("non-admin release copy: expected 403, got %d", rr.Code)
+	}
+
+	// Without the header, uploads start in staging and promote as usual.
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/lib/1.1.0", "ci-token", []byte("lib")); rr.Code != http.StatusCreated {
+		t.Fatalf("staging upload: %d", rr.Code)
+	}
+}
+
+func TestNotificationsHideQuarantinedVersions(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	h.auth = principalAuth{
+		"test-token": {Name: "config", Admin: true},
+		"ci-token":   {TokenID: 2, Name: "ci"},
+	}
+	h.quarantine = true
+	notes := make(recordingNotifier, 10)
+	WithNotifications(h.meta.(services.SubscriptionStore), notes)(h)
+	router := h.Router()
+
+	for token, target := range map[string]string{"ci-token": "ci-hook", "test-token": "admin-hook"} {
+		if rr := doRequest(t, router, "POST", "/api/v1/subscriptions", token, []byte(`{"package":"app","channel":"webhook","target":"`+target+`"}`)); rr.Code != http.StatusCreated {
+			t.Fatalf("subscribe %s: %d %s", target, rr.Code, rr.Body.String())
+		}
+	}
+	if rr := doRequest(t, router, "POST", "/api/v1/artifacts/app/1.0.0", "ci-token", []byte("app")); rr.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rr.Code, rr.Body.String())
+	}
+	select {
+	case n := <-notes:
+		if n != "admin-hook create app@1.0.0 by ci" {
+			t.Errorf("unexpected notification %q", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the admin's notification")
+	}
+	select {
+	case n := <-notes:
+		t.Errorf("quarantined version notified to %q", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Deleting the quarantined version is kept from the non-admin too,
+	// though the version can no longer be looked up.
+	if rr := doRequest(t, router, "DELETE", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", rr.Code, rr.Body.String())
+	}
+	select {
+	case n := <-notes:
+		if n != "admin-hook delete app@1.0.0 by config" {
+			t.Errorf("unexpected notification %q", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the admin's deletion notification")
+	}
+	select {
+	case n := <-notes:
+		t.Errorf("quarantined deletion notified to %q", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// scannerFunc is a malware scanner made from a function.
+type scannerFunc func(ctx context.Context, r io.Reader) (string, error)
+
+func (f scannerFunc) Scan(ctx context.Context, r io.Reader) (string, error) { return f(ctx, r) }
+
+func TestImportOfChangingFile(t *testing.T) {
+	h, router := setupTestHandler(t)
+	share := t.TempDir()
+	file := filepath.Join(share, "app.tar.gz")
+	os.WriteFile(file, []byte("release"), 0o644)
+	WithImportDirs([]string{share})(h)
+
+	// The original changes after it is staged, while it is being scanned.
+	h.malware = scannerFunc(func(_ context.Context, r io.Reader) (string, error) {
+		os.WriteFile(file, []byte("tampered"), 0o644)
+		_, err := io.ReadAll(r)
+		return "", err
+	})
+	body, _ := json.Marshal(models.ImportRequest{Path: file})
+	rr := doRequest(t, router, "POST", "/api/v1/admin/import/app/1.0.0", "test-token", body)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "changed while it was imported") {
+		t.Fatalf("import of a changing file: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, router, "GET", "/api/v1/artifacts/app/1.0.0", "test-token", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("refused import was stored: %d", rr.Code)
+	}
+}
+
+func TestStopJobs(t *testing.T) {
+	h, router := setupTestHandler(t)
+	h.gc.limits = GCLimits{BatchSize: 1, BlobsPerSecond: 1}
+	for _, v := range []string{"1", "2", "3"} {
+		doRequest(t, router, "POST", "/api/v1/artifacts/app/"+v, "test-token", []byte("content "+v))
+		doRequest(t, router, "DELETE", "/api/v1/artifacts/app/"+v, "test-token", nil)
+	}
+	rr := doRequest(t, router, "POST", "/api/v1/gc?async=true", "test-token", nil)
+	var job models.Job
+	json.Unmarshal(rr.Body.Bytes(), &job)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := h.StopJobs(ctx); err != nil {
+		t.Fatalf("StopJobs: %v", err)
+	}
+	rr = doRequest(t, router, "GET", "/api/v1/admin/jobs/"+job.ID, "test-token", nil)
+	json.Unmarshal(rr.Body.Bytes(), &job)
+	if job.Status != models.JobCanceled {
+		t.Errorf("expected the job canceled once StopJobs returns, got %s", rr.Body.String())
+	}
+	if err := h.StopJobs(ctx); err != nil {
+		t.Errorf("StopJobs with nothing running: %v", err)
+	}
+}
+
+func TestGraphQLVersionMetadata(t *testing.T) {
+	_, router := setupTestHandler(t)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="metadata"; filename="meta.json"`)
+	header.Set("Content-Type", "application/json")
+	part, _ := mw.CreatePart(header)
+	part.Write([]byte(`{"description": "Command line tool", "labels": {"team": "infra"}}`))
+	part, _ = mw.CreateFormFile("file", "tool.tar.gz")
+	part.Write([]byte("tool-bytes"))
+	mw.Close()
+	req := httptest.NewRequest("POST", "/api/v1/artifacts/tool/1.0.0", &buf)
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rr.Code, rr.Body.String())
+	}
+	doRequest(t, router, "POST", "/api/v1/artifacts/tool/2.0.0", "test-token", []byte("tool-bytes 2"))
+
+	rr = doRequest(t, router, "POST", "/graphql", "test-token",
+		[]byte(`{"query": "{ package(name: \"tool\") { versions(sort: \"semver\") { version description labels } } }"}`))
+	want := `{"data":{"package":{"versions":[{"version":"2.0.0","description":"","labels":null},{"version":"1.0.0","description":"Command line tool","labels":{"team":"infra"}}]}}}`
+	if got := strings.TrimSpace(rr.Body.String()); rr.Code != http.StatusOK || got != want {
+		t.Errorf("query = %d %s\nwant %s", rr.Code, got, want)
+	}
+}
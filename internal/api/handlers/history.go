@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// expiryActor is the actor recorded for versions removed by the expiry
+// sweep.
+const expiryActor = "expiry"
+
+// GetHistory handles GET /api/v1/packages/{package}/history, listing the
+// creations, overwrites and deletions of the package's versions and files,
+// newest first. ?version= narrows it to one version. Pages like the
+// dependents listing.
+func (h *Handler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	pkgName := chi.URLParam(r, "package")
+
+	limit, err := pageLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var beforeID int64
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		if beforeID, _, err = decodeCursor(cursor); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// Fetch one extra row to learn whether another page follows.
+	events, err := h.meta.ListHistory(pkgName, r.URL.Query().Get("version"), beforeID, limit+1)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing history")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	resp := models.HistoryResponse{Package: pkgName, Events: events}
+	if len(events) > limit {
+		resp.Events = events[:limit]
+		resp.NextCursor = encodeCursor(events[limit-1].ID, "")
+		h.setNextLink(w, r, resp.NextCursor)
+	}
+	if resp.Events == nil {
+		resp.Events = []models.HistoryEvent{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// recordHistory appends e to its package's history, naming the caller of r
+// as the actor unless e already has one, and notifies the package's
+// subscribers. Failures are logged rather than failing the request, since
+// the change itself has been made.
+func (h *Handler) recordHistory(r *http.Request, e models.HistoryEvent) {
+	h.recordHistoryOf(r, e, false)
+}
+
+// recordHistoryOf is recordHistory for a change to a version that may no
+// longer exist, such as its deletion, saying whether it was quarantined.
+func (h *Handler) recordHistoryOf(r *http.Request, e models.HistoryEvent, quarantined bool) {
+	if e.Actor == "" && r != nil {
+		if p := principalFrom(r.Context()); p != nil {
+			e.Actor = p.Name
+		}
+	}
+	if err := h.meta.RecordHistory(e); err != nil {
+		h.logger.Warn().Err(err).
+			Str("package", e.Package).
+			Str("version", e.Version).
+			Str("action", e.Action).
+			Msg("recording history")
+	}
+	h.notify(r, models.Notification{
+		Event: e.Action, Package: e.Package, Version: e.Version, File: e.File, Hash: e.NewHash, Actor: e.Actor, Source: e.Source,
+		Quarantined: quarantined,
+	})
+}
+
+// deleteVersion deletes pkg@version and records the deletion of it and its
+// named files in the history. actor overrides the caller of r, which may be
+// nil for deletions the server makes itself.
+func (h *Handler) deleteVersion(r *http.Request, actor, pkgName, version string) error {
+	artifact, err := h.meta.GetArtifact(pkgName, version)
+	if err != nil {
+		return err
+	}
+	var files []models.Asset
+	if artifact != nil {
+		if files, err = h.meta.ListAssets(pkgName, version); err != nil {
+			return err
+		}
+	}
+	if err := h.meta.DeleteArtifact(pkgName, version); err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		h.recordHistoryOf(r, models.HistoryEvent{
+			Package: pkgName, Version: version, File: f.Name, Action: models.HistoryDelete, OldHash: f.Hash, Actor: actor,
+		}, artifact.Quarantined)
+	}
+	h.recordHistoryOf(r, models.HistoryEvent{
+		Package: pkgName, Version: version, Action: models.HistoryDelete, OldHash: artifact.Hash, Actor: actor,
+	}, artifact.Quarantined)
+	h.reclaimReleased()
+	return nil
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// WithHooks runs hooks, in order, at the hook points of uploads, downloads
+// and deletes. Pre-upload hooks see the stored blob before any metadata is
+// recorded, so a rejected upload publishes nothing.
+func WithHooks(hooks ...services.Hook) Option {
+	return func(h *Handler) {
+		h.hooks = append(h.hooks, hooks...)
+	}
+}
+
+// hookDecision runs the hooks for event on behalf of the caller of r. Like
+// policyDecision it returns status 0 when the request may continue, and
+// otherwise the status and message to answer with: 403 when a hook
+// rejects the request, 503 when one fails.
+func (h *Handler) hookDecision(r *http.Request, event models.HookEvent) (int, string) {
+	if len(h.hooks) == 0 {
+		return 0, ""
+	}
+	event.Principal = principalFrom(r.Context())
+	event.ClientIP = logging.ClientIP(r.Context())
+
+	for _, hook := range h.hooks {
+		err := hook.Run(r.Context(), event)
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, services.ErrHookRejected):
+			h.logger.Warn().
+				Str("request_id", logging.RequestID(r.Context())).
+				Str("client_ip", event.ClientIP).
+				Str("hook", event.Hook).
+				Str("package", event.Package).
+				Str("version", event.Version).
+				Str("file", event.File).
+				Str("reason", err.Error()).
+				Msg("request rejected by hook")
+			return http.StatusForbidden, err.Error()
+		default:
+			h.logger.Error().Err(err).Str("hook", event.Hook).Msg("running hook")
+			return http.StatusServiceUnavailable, "hook failed"
+		}
+	}
+	return 0, ""
+}
+
+// checkHooks writes the error response and returns false when a hook
+// refuses event.
+func (h *Handler) checkHooks(w http.ResponseWriter, r *http.Request, event models.HookEvent) bool {
+	if status, msg := h.hookDecision(r, event); status != 0 {
+		writeError(w, status, msg)
+		return false
+	}
+	return true
+}
+
+// runPostUpload runs the post-upload hooks for a completed upload. The
+// upload stands whatever they return, so errors are only logged, and the
+// hooks run even if the client has gone away.
+func (h *Handler) runPostUpload(r *http.Request, event models.HookEvent) {
+	if len(h.hooks) == 0 {
+		return
+	}
+	event.Hook = models.HookPostUpload
+	event.Principal = principalFrom(r.Context())
+	event.ClientIP = logging.ClientIP(r.Context())
+
+	ctx := context.WithoutCancel(r.Context())
+	for _, hook := range h.hooks {
+		if err := hook.Run(ctx, event); err != nil {
+			h.logger.Error().
+				Err(err).
+				Str("request_id", logging.RequestID(r.Context())).
+				Str("package", event.Package).
+				Str("version", event.Version).
+				Str("file", event.File).
+				Msg("running post-upload hook")
+		}
+	}
+}
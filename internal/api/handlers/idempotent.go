@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// WithIdempotentUploads lets an upload to an existing version succeed when
+// it carries the content the version already holds, answering 200 with the
+// stored version, so clients can retry an upload whose response they lost.
+// Only the content is compared. Uploads of other content, and all uploads
+// to existing versions when disabled, answer 409.
+func WithIdempotentUploads(enabled bool) Option {
+	return func(h *Handler) {
+		h.idempotentUploads = enabled
+	}
+}
+
+// uploadTarget looks up the version an upload would create. An existing
+// version is refused with a 409 unless idempotent uploads are enabled, in
+// which case it is returned for repeatUpload to compare once the upload's
+// hash is known. The caller holds the version's upload lock.
+func (h *Handler) uploadTarget(w http.ResponseWriter, r *http.Request, pkgName, version string) (*models.Artifact, bool) {
+	existing, err := h.meta.GetArtifact(pkgName, version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("checking existing artifact")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return nil, false
+	}
+	if existing != nil && (!h.idempotentUploads || hidden(r, existing)) {
+		writeError(w, http.StatusConflict, fmt.Sprintf("artifact %s@%s already exists", pkgName, version))
+		return nil, false
+	}
+	return existing, true
+}
+
+// repeatUpload answers an upload of hash to a version that already exists:
+// 200 with the version if it holds the same content, 409 otherwise.
+func (h *Handler) repeatUpload(w http.ResponseWriter, r *http.Request, existing *models.Artifact, hash string) {
+	if existing.Hash != hash {
+		writeError(w, http.StatusConflict, fmt.Sprintf("artifact %s@%s already exists with different content", existing.Package, existing.Version))
+		return
+	}
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("package", existing.Package).
+		Str("version", existing.Version).
+		Str("hash", hash).
+		Msg("repeated upload matched existing version")
+	writeJSON(w, http.StatusOK, uploadResponse(existing.Package, existing))
+}
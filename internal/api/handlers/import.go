@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/filetype"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// errImportChanged reports a file that no longer matched what was staged
+// from it when it was read again.
+var errImportChanged = errors.New("file changed during import")
+
+// WithImportDirs enables importing versions from files under dirs on the
+// server's own filesystem, so a file share can be migrated without sending
+// every file over HTTP or storing it twice.
+func WithImportDirs(dirs []string) Option {
+	return func(h *Handler) {
+		h.importDirs = nil
+		for _, dir := range dirs {
+			if abs, err := filepath.Abs(dir); err == nil {
+				if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+					abs = resolved
+				}
+				h.importDirs = append(h.importDirs, abs)
+			}
+		}
+	}
+}
+
+// importPath resolves a requested file to import, refusing anything that
+// is not a regular file inside an import directory once symlinks are
+// followed.
+func (h *Handler) importPath(requested string) (string, int, error) {
+	if !filepath.IsAbs(requested) {
+		return "", http.StatusBadRequest, errors.New("path must be absolute")
+	}
+	resolved, err := filepath.EvalSymlinks(requested)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", http.StatusNotFound, fmt.Errorf("%s does not exist", requested)
+		}
+		return "", http.StatusBadRequest, fmt.Errorf("resolving %s: %v", requested, err)
+	}
+	inside := false
+	for _, dir := range h.importDirs {
+		if rel, err := filepath.Rel(dir, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			inside = true
+			break
+		}
+	}
+	if !inside {
+		return "", http.StatusForbidden, fmt.Errorf("%s is outside the import directories", requested)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("reading %s: %v", requested, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", http.StatusBadRequest, fmt.Errorf("%s is not a regular file", requested)
+	}
+	return resolved, 0, nil
+}
+
+// ImportArtifact handles POST /api/v1/admin/import/{package}/{version},
+// publishing a version from a file on the server's filesystem. The file is
+// cloned or hard-linked into blob storage where the backend and
+// filesystem allow, and copied otherwise; the version is otherwise
+// recorded like an upload, with the same checks and hooks.
+func (h *Handler) ImportArtifact(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if len(h.importDirs) == 0 {
+		writeError(w, http.StatusNotImplemented, "imports are not enabled")
+		return
+	}
+	pkgName := h.naming.Normalize(chi.URLParam(r, "package"))
+	version := chi.URLParam(r, "version")
+
+	var req models.ImportRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMetadataBytes)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	path, status, err := h.importPath(req.Path)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	if !h.checkNaming(w, r, pkgName, version) {
+		return
+	}
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionUpload, Package: pkgName, Version: version}) {
+		return
+	}
+	opts, ok := h.uploadVersionOptions(w, r)
+	if !ok {
+		return
+	}
+
+	unlock := h.lockArtifactUpload(pkgName, version)
+	defer unlock()
+
+	existing, ok := h.uploadTarget(w, r, pkgName, version)
+	if !ok {
+		return
+	}
+
+	staged, mode, err := h.stageFile(r.Context(), pkgName, path, req.Link)
+	if errors.Is(err, errImportChanged) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		status, msg := h.storeFailure(err)
+		writeError(w, status, msg)
+		return
+	}
+	defer staged.Discard()
+	hash, size := staged.Hash(), staged.Size()
+	if req.Hash != "" && req.Hash != hash {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("digest mismatch: expected %s, got %s", req.Hash, hash))
+		return
+	}
+
+	in := models.ArtifactInput{
+		Version:     version,
+		Hash:        hash,
+		Size:        size,
+		Filename:    sanitizeFilename(cmp.Or(req.Filename, filepath.Base(path))),
+		ContentType: normalizeContentType(req.ContentType),
+		Stage:       opts.stage,
+		Stability:   opts.stability,
+		ExpiresAt:   opts.expiresAt,
+	}
+	meta := &models.UploadMetadata{Description: req.Description, Labels: req.Labels, Dependencies: req.Dependencies}
+	if err := applyMetadata(&in, pkgName, meta); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if existing != nil {
+		h.repeatUpload(w, r, existing, hash)
+		return
+	}
+	artifact, ok := h.createArtifact(w, r, pkgName, in, staged, start)
+	if !ok {
+		return
+	}
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("package", pkgName).
+		Str("version", version).
+		Str("path", path).
+		Str("import", mode).
+		Msg("file imported")
+
+	resp := models.ImportResponse{UploadResponse: uploadResponse(pkgName, artifact), Import: mode}
+	resp.Dependencies = in.Dependencies
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// Import publishes pkg@version from the file req names as if principal had
+// asked for it with POST /api/v1/admin/import, going through the same
+// checks, hooks and history. It serves registry-server import, which takes
+// in a file share without the server running.
+func (h *Handler) Import(ctx context.Context, principal *models.Principal, pkg, version string, req models.ImportRequest) (*models.ImportResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encoding import request: %w", err)
+	}
+	route := chi.NewRouteContext()
+	route.URLParams.Add("package", pkg)
+	route.URLParams.Add("version", version)
+	ctx = context.WithValue(withPrincipal(ctx, principal), chi.RouteCtxKey, route)
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/api/v1/admin/import/"+url.PathEscape(pkg)+"/"+url.PathEscape(version), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("building import request: %w", err)
+	}
+	w := &bufferedResponse{header: http.Header{}}
+	h.ImportArtifact(w, r)
+	if w.status != http.StatusCreated && w.status != http.StatusOK {
+		var e models.ErrorResponse
+		json.Unmarshal(w.body.Bytes(), &e)
+		return nil, errors.New(cmp.Or(e.Message, http.StatusText(w.status)))
+	}
+	var resp models.ImportResponse
+	if err := json.Unmarshal(w.body.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("decoding import response: %w", err)
+	}
+	return &resp, nil
+}
+
+// bufferedResponse keeps a response written in process.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// stageFile stages the file at path for a version of pkg, without copying
+// it where the blob storage routed to pkg can, and records what stage
+// records of an upload: its digests, format and malware verdict. It
+// returns how the file was taken in. Hard links are only made if link is
+// set.
+func (h *Handler) stageFile(ctx context.Context, pkg, path string, link bool) (services.StagedBlob, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	importer, ok := h.blobsFor(pkg).(services.BlobImporter)
+	if !ok {
+		staged, err := h.stage(ctx, pkg, f)
+		return staged, models.ImportCopy, err
+	}
+	staged, mode, err := importer.StageFile(path, link)
+	if errors.Is(err, errors.ErrUnsupported) {
+		staged, err := h.stage(ctx, pkg, f)
+		return staged, models.ImportCopy, err
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The file is read once more for what stage learns as it streams,
+	// and its digest checked again, so that what was scanned is what was
+	// staged even if the original changed in between.
+	d := h.newDigester()
+	var sniffer filetype.Sniffer
+	sum := sha256.New()
+	signature, err := h.inspectFile(ctx, io.TeeReader(f, io.MultiWriter(d, &sniffer, sum)))
+	if err != nil {
+		staged.Discard()
+		return nil, "", err
+	}
+	if hex.EncodeToString(sum.Sum(nil)) != staged.Hash() {
+		staged.Discard()
+		return nil, "", fmt.Errorf("%w: %s changed while it was imported", errImportChanged, path)
+	}
+	if h.malware != nil {
+		if err := h.recordScan(staged.Hash(), signature); err != nil {
+			staged.Discard()
+			return nil, "", err
+		}
+	}
+	if err := h.meta.SetBlobDigests(d.digests(staged.Hash())); err != nil {
+		staged.Discard()
+		return nil, "", err
+	}
+	if err := h.meta.SetBlobFormat(staged.Hash(), sniffer.Format()); err != nil {
+		staged.Discard()
+		return nil, "", err
+	}
+	return staged, mode, nil
+}
+
+// inspectFile reads r to the end, scanning it for malware on the way if a
+// scanner is configured, and returns the signature found, if any.
+func (h *Handler) inspectFile(ctx context.Context, r io.Reader) (string, error) {
+	if h.malware == nil {
+		_, err := io.Copy(io.Discard, r)
+		return "", err
+	}
+	signature, err := h.malware.Scan(ctx, r)
+	io.Copy(io.Discard, r)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errMalwareScan, err)
+	}
+	return signature, nil
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"cmp"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// inflightRequest is a request the logging middleware is still serving.
+type inflightRequest struct {
+	id       string
+	method   string
+	path     string
+	clientIP string
+	start    time.Time
+	read     atomic.Int64
+	rw       *responseWriter
+}
+
+// inflightRequests tracks the requests being served, for the admin
+// request list.
+type inflightRequests struct {
+	mu   sync.Mutex
+	reqs map[*inflightRequest]struct{}
+}
+
+func newInflightRequests() *inflightRequests {
+	return &inflightRequests{reqs: make(map[*inflightRequest]struct{})}
+}
+
+// inflightKey is the context key of the request's inflightRequest.
+type inflightKey struct{}
+
+// track records r until the returned func is called. The body of r is
+// counted as it is read.
+func (t *inflightRequests) track(r *http.Request, rw *responseWriter, start time.Time) (*inflightRequest, func()) {
+	req := &inflightRequest{
+		id:       logging.RequestID(r.Context()),
+		method:   r.Method,
+		path:     r.URL.Path,
+		clientIP: logging.ClientIP(r.Context()),
+		start:    start,
+		rw:       rw,
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &countingBody{ReadCloser: r.Body, n: &req.read}
+	}
+	t.mu.Lock()
+	t.reqs[req] = struct{}{}
+	t.mu.Unlock()
+	return req, func() {
+		t.mu.Lock()
+		delete(t.reqs, req)
+		t.mu.Unlock()
+	}
+}
+
+// list returns the tracked requests, longest running first.
+func (t *inflightRequests) list(now time.Time) []models.InflightRequest {
+	t.mu.Lock()
+	reqs := make([]models.InflightRequest, 0, len(t.reqs))
+	for req := range t.reqs {
+		reqs = append(reqs, models.InflightRequest{
+			ID:             req.id,
+			Method:         req.method,
+			Path:           req.path,
+			ClientIP:       req.clientIP,
+			StartedAt:      req.start.UTC(),
+			DurationMillis: now.Sub(req.start).Milliseconds(),
+			BytesIn:        req.read.Load(),
+			BytesOut:       req.rw.written.Load(),
+		})
+	}
+	t.mu.Unlock()
+	slices.SortFunc(reqs, func(a, b models.InflightRequest) int {
+		return cmp.Or(a.StartedAt.Compare(b.StartedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return reqs
+}
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// ListInflightRequests handles GET /api/v1/admin/requests, listing the
+// requests the server is still handling, longest running first, with how
+// far each transfer has got. The listing itself is included.
+func (h *Handler) ListInflightRequests(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.inflight.list(time.Now()))
+}
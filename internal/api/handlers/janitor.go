@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// CleanTempFiles removes the temp files of uploads blob storage staged
+// before before and never committed or discarded, as a crash leaves them
+// behind, returning how many it removed and the bytes reclaimed. Backends
+// without temp files are left alone.
+func (h *Handler) CleanTempFiles(before time.Time) (int, int64, error) {
+	cleaner, ok := h.blobs.(services.TempCleaner)
+	if !ok {
+		return 0, 0, nil
+	}
+	files, bytes, err := cleaner.CleanTemp(before)
+	if files > 0 {
+		h.logger.Info().
+			Int("files", files).
+			Int64("bytes", bytes).
+			Time("before", before).
+			Msg("removed stale upload temp files")
+	}
+	return files, bytes, err
+}
+
+// RunTempCleanup removes upload temp files older than maxAge at once and
+// then every interval until ctx is done. A zero interval cleans once.
+func (h *Handler) RunTempCleanup(ctx context.Context, interval, maxAge time.Duration) {
+	clean := func(now time.Time) {
+		if _, _, err := h.CleanTempFiles(now.Add(-maxAge)); err != nil {
+			h.logger.Error().Err(err).Msg("removing stale upload temp files")
+		}
+	}
+	clean(time.Now())
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			clean(now)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// maxFinishedJobs is how many finished jobs are kept for the job API.
+const maxFinishedJobs = 20
+
+// gcJob is a garbage collection running in the background.
+type gcJob struct {
+	mu  sync.Mutex
+	job models.Job
+	// updated is closed and replaced on every change, waking followers.
+	updated chan struct{}
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// snapshot returns the job and a channel closed on its next change.
+func (j *gcJob) snapshot() (models.Job, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.job, j.updated
+}
+
+func (j *gcJob) update(fn func(*models.Job)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.job)
+	close(j.updated)
+	j.updated = make(chan struct{})
+}
+
+// addJob records j, forgetting the oldest finished jobs beyond
+// maxFinishedJobs.
+func (g *gcRunner) addJob(j *gcJob) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.jobs = append(g.jobs, j)
+
+	finished := 0
+	for i := len(g.jobs) - 1; i >= 0; i-- {
+		select {
+		case <-g.jobs[i].done:
+			finished++
+			if finished > maxFinishedJobs {
+				g.jobs = append(g.jobs[:i], g.jobs[i+1:]...)
+			}
+		default:
+		}
+	}
+}
+
+func (g *gcRunner) findJob(id string) *gcJob {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, j := range g.jobs {
+		if j.job.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// startGCJob runs a collection in the background. The caller must hold the
+// runner, which the job releases when it finishes.
+func (h *Handler) startGCJob(r *http.Request, dryRun, tombstone bool) models.Job {
+	// The job outlives the request but keeps its caller and request ID for
+	// logs and history.
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	r = r.WithContext(ctx)
+	j := &gcJob{
+		job: models.Job{
+			ID:        uuid.NewString(),
+			Kind:      "gc",
+			Status:    models.JobRunning,
+			StartedAt: h.clock.Now(),
+		},
+		updated: make(chan struct{}),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	h.gc.addJob(j)
+
+	go func() {
+		defer close(j.done)
+		defer h.gc.end()
+		defer cancel()
+
+		result, err := h.collectGarbage(ctx, r, dryRun, tombstone, func(p models.GCProgress) {
+			j.update(func(job *models.Job) { job.Progress = p })
+		})
+		if err != nil {
+			h.logger.Error().Err(err).Str("job_id", j.job.ID).Msg("collecting garbage")
+		}
+		j.update(func(job *models.Job) {
+			now := h.clock.Now()
+			job.FinishedAt = &now
+			switch {
+			case err != nil:
+				job.Status = models.JobFailed
+				job.Error = err.Error()
+			case ctx.Err() != nil:
+				job.Status = models.JobCanceled
+				job.Result = &result
+			default:
+				job.Status = models.JobSucceeded
+				job.Result = &result
+			}
+		})
+	}()
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Str("job_id", j.job.ID).
+		Bool("dry_run", dryRun).
+		Msg("garbage collection job started")
+	job, _ := j.snapshot()
+	return job
+}
+
+// StopJobs cancels the running jobs and waits until they have stopped, or
+// until ctx ends, when it returns ctx's error. Servers call it on shutdown
+// before closing the stores jobs use.
+func (h *Handler) StopJobs(ctx context.Context) error {
+	h.gc.mu.Lock()
+	tracked := append([]*gcJob(nil), h.gc.jobs...)
+	h.gc.mu.Unlock()
+
+	for _, j := range tracked {
+		j.cancel()
+	}
+	for _, j := range tracked {
+		select {
+		case <-j.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// ListJobs handles GET /api/v1/admin/jobs, listing running and recently
+// finished jobs, newest first.
+func (h *Handler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	h.gc.mu.Lock()
+	tracked := append([]*gcJob(nil), h.gc.jobs...)
+	h.gc.mu.Unlock()
+
+	jobs := make([]models.Job, 0, len(tracked))
+	for i := len(tracked) - 1; i >= 0; i-- {
+		job, _ := tracked[i].snapshot()
+		jobs = append(jobs, job)
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// GetJob handles GET /api/v1/admin/jobs/{id}. With ?follow=true the job is
+// streamed as newline-delimited JSON, one line per change, until it
+// finishes.
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	j := h.lookupJob(w, r)
+	if j == nil {
+		return
+	}
+	if follow, _ := strconv.ParseBool(r.URL.Query().Get("follow")); !follow {
+		job, _ := j.snapshot()
+		writeJSON(w, http.StatusOK, job)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	for {
+		job, updated := j.snapshot()
+		if err := enc.Encode(job); err != nil {
+			return
+		}
+		rc.Flush()
+		if job.Status != models.JobRunning {
+			return
+		}
+		select {
+		case <-updated:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// CancelJob handles DELETE /api/v1/admin/jobs/{id}, stopping a running job
+// at its next batch and answering with the job once it has stopped.
+// Canceling a finished job returns it unchanged.
+func (h *Handler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	j := h.lookupJob(w, r)
+	if j == nil {
+		return
+	}
+	j.cancel()
+	select {
+	case <-j.done:
+	case <-r.Context().Done():
+		return
+	}
+
+	job, _ := j.snapshot()
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Str("job_id", job.ID).
+		Str("status", job.Status).
+		Msg("job canceled")
+	writeJSON(w, http.StatusOK, job)
+}
+
+// lookupJob finds the job named in the URL, writing a 404 if there is none.
+func (h *Handler) lookupJob(w http.ResponseWriter, r *http.Request) *gcJob {
+	id := chi.URLParam(r, "id")
+	j := h.gc.findJob(id)
+	if j == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("job %s not found", id))
+	}
+	return j
+}
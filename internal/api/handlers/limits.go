@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/foundry/registry/internal/util/ratelimit"
+)
+
+const defaultRetryAfter = 5 * time.Second
+
+// TransferLimits caps concurrent transfers and per-connection bandwidth.
+// Zero values leave the corresponding limit disabled.
+type TransferLimits struct {
+	MaxConcurrentUploads   int
+	MaxConcurrentDownloads int
+	UploadBytesPerSecond   int64
+	DownloadBytesPerSecond int64
+	// MaxExtractBytes caps the size of a single file served from inside an
+	// archive artifact.
+	MaxExtractBytes int64
+	// RetryAfter is advertised to clients turned away while saturated.
+	RetryAfter time.Duration
+}
+
+type transferLimiter struct {
+	uploads      chan struct{}
+	downloads    chan struct{}
+	uploadRate   int64
+	downloadRate int64
+	extractBytes int64
+	retryAfter   time.Duration
+}
+
+// WithTransferLimits enables concurrency and bandwidth limits on artifact
+// uploads and downloads.
+func WithTransferLimits(l TransferLimits) Option {
+	return func(h *Handler) {
+		tl := &transferLimiter{
+			uploadRate:   l.UploadBytesPerSecond,
+			downloadRate: l.DownloadBytesPerSecond,
+			extractBytes: l.MaxExtractBytes,
+			retryAfter:   l.RetryAfter,
+		}
+		if l.MaxConcurrentUploads > 0 {
+			tl.uploads = make(chan struct{}, l.MaxConcurrentUploads)
+		}
+		if l.MaxConcurrentDownloads > 0 {
+			tl.downloads = make(chan struct{}, l.MaxConcurrentDownloads)
+		}
+		if tl.retryAfter <= 0 {
+			tl.retryAfter = defaultRetryAfter
+		}
+		h.limits = tl
+	}
+}
+
+// acquireSlot takes a transfer slot without blocking. When the server is
+// saturated it writes a 503 with Retry-After and returns ok=false.
+func (h *Handler) acquireSlot(w http.ResponseWriter, slots chan struct{}) (release func(), ok bool) {
+	if slots == nil {
+		return func() {}, true
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	default:
+		secs := int(h.limits.retryAfter.Round(time.Second) / time.Second)
+		if secs < 1 {
+			secs = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		writeError(w, http.StatusServiceUnavailable, "too many concurrent transfers, retry later")
+		return nil, false
+	}
+}
+
+// limitUpload reserves an upload slot and paces the request body. It returns
+// ok=false after answering the request if no slot is free or the upload
+// would not fit above the storage reserve.
+func (h *Handler) limitUpload(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	if !h.checkUploadSpace(w, r) {
+		return nil, false
+	}
+	if h.limits == nil {
+		return func() {}, true
+	}
+	release, ok = h.acquireSlot(w, h.limits.uploads)
+	if !ok {
+		return nil, false
+	}
+	if l := ratelimit.NewLimiter(h.limits.uploadRate); l != nil {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{ratelimit.NewReader(r.Context(), r.Body, l), r.Body}
+	}
+	return release, true
+}
+
+// limitDownload reserves a download slot and returns a ResponseWriter whose
+// body writes are paced. It returns ok=false after answering the request if
+// no slot is free.
+func (h *Handler) limitDownload(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func(), bool) {
+	if h.limits == nil {
+		return w, func() {}, true
+	}
+	release, ok := h.acquireSlot(w, h.limits.downloads)
+	if !ok {
+		return nil, nil, false
+	}
+	if l := ratelimit.NewLimiter(h.limits.downloadRate); l != nil {
+		w = &throttledWriter{ResponseWriter: w, body: ratelimit.NewWriter(r.Context(), w, l), ctx: r.Context(), limiter: l}
+	}
+	return w, release, true
+}
+
+// throttledWriter paces response body writes while passing headers through.
+type throttledWriter struct {
+	http.ResponseWriter
+	body    io.Writer
+	ctx     context.Context
+	limiter *ratelimit.Limiter
+}
+
+func (tw *throttledWriter) Write(b []byte) (int, error) {
+	return tw.body.Write(b)
+}
+
+// ReadFrom keeps sendfile for paced downloads, sending a second's worth
+// of bytes at a time and waiting out each.
+func (tw *throttledWriter) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := tw.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(writerOnly{tw}, src)
+	}
+	return readFromSlices(rf, src, int64(tw.limiter.Burst()), func(n int64) error {
+		return tw.limiter.WaitN(tw.ctx, int(n))
+	})
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+
+	"github.com/foundry/registry/internal/adapters/auth"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// WithLockouts refuses clients that fail authentication too often. Failed
+// credentials, signed requests included, count against the client address
+// only, so nobody can lock a token or signing key out for everyone else;
+// requests without credentials do not count, since package managers send
+// one before every authenticated request. A locked out address is refused
+// with 429 whatever it sends, so guesses made while locked out reveal
+// nothing. Peers of an untrusted Unix socket have no address to count
+// against; the socket's file mode is what limits them.
+func WithLockouts(l *auth.Lockouts) Option {
+	return func(h *Handler) {
+		h.lockouts = l
+	}
+}
+
+// clientLockoutKey is the lockout key of the caller of r, or "" if the
+// caller has no address, as on a Unix socket, so that such callers do not
+// all share one key.
+func clientLockoutKey(r *http.Request) string {
+	addr, err := netip.ParseAddr(logging.ClientIP(r.Context()))
+	if err != nil {
+		return ""
+	}
+	return "ip:" + addr.String()
+}
+
+// checkLockout writes a 429 and returns false if key is locked out.
+func (h *Handler) checkLockout(w http.ResponseWriter, key string) bool {
+	if h.lockouts == nil || key == "" {
+		return true
+	}
+	left, locked := h.lockouts.Locked(key)
+	if !locked {
+		return true
+	}
+	secs := int(math.Ceil(left.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(secs, 1)))
+	writeError(w, http.StatusTooManyRequests, "too many failed authentication attempts; try again later")
+	return false
+}
+
+// authFailed logs a refused credential, the audit trail of guessing, and
+// counts it as one failure against key, if any, logging any lockout that
+// follows.
+func (h *Handler) authFailed(r *http.Request, reason, key string) {
+	h.logger.Warn().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Str("method", r.Method).
+		Str("path", r.URL.Path).
+		Str("reason", reason).
+		Msg("authentication failed")
+	if h.lockouts == nil || key == "" {
+		return
+	}
+	for _, locked := range h.lockouts.Fail(key) {
+		h.logger.Warn().
+			Str("request_id", logging.RequestID(r.Context())).
+			Str("client_ip", logging.ClientIP(r.Context())).
+			Str("key", locked.Key).
+			Time("locked_until", locked.LockedUntil).
+			Msg("authentication locked out")
+	}
+}
+
+// AuthMetrics handles GET /api/v1/admin/metrics/auth, reporting failed
+// authentication and lockouts since the registry started.
+func (h *Handler) AuthMetrics(w http.ResponseWriter, r *http.Request) {
+	if h.lockouts == nil {
+		writeError(w, http.StatusNotImplemented, "authentication lockouts are not enabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.lockouts.Stats())
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/filetype"
+)
+
+// malwareScanGrace is how long the scan of an unreferenced blob is kept,
+// long enough for the upload that scanned it to record its metadata.
+const malwareScanGrace = time.Hour
+
+// errMalwareScan marks uploads refused because the scanner gave no verdict.
+var errMalwareScan = errors.New("malware scan failed")
+
+// WithMalwareScanner scans every upload with s as it is stored. Infected
+// uploads are still recorded, marked infected, and their downloads refused;
+// uploads the scanner cannot judge fail.
+func WithMalwareScanner(s services.MalwareScanner) Option {
+	return func(h *Handler) {
+		h.malware = s
+	}
+}
+
+// stage stages the data read from r in the storage of package pkg,
+// computing its digests, classifying its format and streaming it through
+// the malware scanner on the way, and records all three.
+func (h *Handler) stage(ctx context.Context, pkg string, r io.Reader) (services.StagedBlob, error) {
+	d := h.newDigester()
+	var sniffer filetype.Sniffer
+	r, err := h.guardSpace(r)
+	if err != nil {
+		return nil, err
+	}
+	staged, err := h.scanAndStage(ctx, h.blobsFor(pkg), io.TeeReader(r, io.MultiWriter(d, &sniffer)))
+	if err != nil {
+		return nil, err
+	}
+	if err := h.meta.SetBlobDigests(d.digests(staged.Hash())); err != nil {
+		staged.Discard()
+		return nil, err
+	}
+	if err := h.meta.SetBlobFormat(staged.Hash(), sniffer.Format()); err != nil {
+		staged.Discard()
+		return nil, err
+	}
+	return staged, nil
+}
+
+// scanAndStage stages the data read from r, streaming it through the
+// malware scanner on the way and recording the verdict.
+func (h *Handler) scanAndStage(ctx context.Context, blobs services.BlobStorage, r io.Reader) (services.StagedBlob, error) {
+	if h.malware == nil {
+		return blobs.Stage(r)
+	}
+
+	type verdict struct {
+		signature string
+		err       error
+	}
+	pr, pw := io.Pipe()
+	done := make(chan verdict, 1)
+	go func() {
+		signature, err := h.malware.Scan(ctx, pr)
+		// Keep the upload flowing if the scanner stopped reading early.
+		io.Copy(io.Discard, pr)
+		done <- verdict{signature, err}
+	}()
+	staged, err := blobs.Stage(io.TeeReader(r, pw))
+	pw.CloseWithError(err)
+	v := <-done
+	if err != nil {
+		return nil, err
+	}
+	if v.err != nil {
+		staged.Discard()
+		return nil, fmt.Errorf("%w: %v", errMalwareScan, v.err)
+	}
+
+	if err := h.recordScan(staged.Hash(), v.signature); err != nil {
+		staged.Discard()
+		return nil, err
+	}
+	return staged, nil
+}
+
+// recordScan records the scanner's verdict on a blob: infected with
+// signature, or clean if it is empty.
+func (h *Handler) recordScan(hash, signature string) error {
+	scan := models.MalwareScan{Hash: hash, Result: models.MalwareClean, ScannedAt: h.clock.Now()}
+	if signature != "" {
+		scan.Result, scan.Signature = models.MalwareInfected, signature
+		h.logger.Warn().Str("hash", hash).Str("signature", signature).Msg("malware found in upload")
+	}
+	return h.meta.SetMalwareScan(scan)
+}
+
+// storeFailure logs why an upload's blob could not be stored and returns
+// the status and message to answer with: 503 when the malware scanner gave
+// no verdict, 507 when blob storage is short of space or full, 500
+// otherwise.
+func (h *Handler) storeFailure(err error) (int, string) {
+	if errors.Is(err, errMalwareScan) {
+		h.logger.Error().Err(err).Msg("scanning upload")
+		return http.StatusServiceUnavailable, "malware scan failed; try again later"
+	}
+	if errors.Is(err, errBodyHash) {
+		return http.StatusBadRequest, errBodyHash.Error()
+	}
+	if errors.Is(err, errInsufficientStorage) || errors.Is(err, syscall.ENOSPC) {
+		h.logger.Error().Err(err).Msg("storing blob")
+		return http.StatusInsufficientStorage, "insufficient storage; try again later"
+	}
+	h.logger.Error().Err(err).Msg("storing blob")
+	return http.StatusInternalServerError, "failed to store artifact"
+}
+
+// malwareBlock returns why downloads of artifact are refused because its
+// file is infected, or "" if they are not.
+func (h *Handler) malwareBlock(artifact *models.Artifact) string {
+	scan, err := h.meta.GetMalwareScan(artifact.Hash)
+	if err != nil {
+		h.logger.Error().Err(err).Str("hash", artifact.Hash).Msg("getting malware scan")
+		return "malware scan status unavailable"
+	}
+	if scan == nil || scan.Result != models.MalwareInfected {
+		return ""
+	}
+	return fmt.Sprintf("artifact %s@%s is blocked: %s is infected with %s",
+		artifact.Package, artifact.Version, downloadFilename(artifact), scan.Signature)
+}
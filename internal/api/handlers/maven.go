@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// Maven artifacts are stored as packages named "<groupId>:<artifactId>",
+// one version per Maven version, with the jar, pom and any classified files
+// as files of that version. maven-metadata.xml and checksum files are
+// generated on request; uploaded copies are checked where possible and then
+// discarded. SNAPSHOT versions are not supported.
+
+const mavenMetadataFile = "maven-metadata.xml"
+
+// mavenChecksums maps checksum file suffixes to their hash functions.
+var mavenChecksums = map[string]func() hash.Hash{
+	".md5":    md5.New,
+	".sha1":   sha1.New,
+	".sha256": sha256.New,
+	".sha512": sha512.New,
+}
+
+// mavenPath is a parsed request path under /maven2/.
+type mavenPath struct {
+	GroupID    string
+	ArtifactID string
+	Version    string // empty for artifact-level metadata
+	Filename   string
+	Checksum   string // checksum suffix such as ".sha1", or empty
+}
+
+func (p mavenPath) packageName() string {
+	return p.GroupID + ":" + p.ArtifactID
+}
+
+// parseMavenPath splits a Maven2 layout path such as
+// com/example/mylib/1.0.0/mylib-1.0.0.jar.sha1.
+func parseMavenPath(raw string) (mavenPath, error) {
+	segments := strings.Split(strings.Trim(raw, "/"), "/")
+	for _, s := range segments {
+		if s == "" || s == "." || s == ".." {
+			return mavenPath{}, errors.New("invalid path")
+		}
+	}
+
+	var p mavenPath
+	p.Filename = segments[len(segments)-1]
+	for suffix := range mavenChecksums {
+		if strings.HasSuffix(p.Filename, suffix) {
+			p.Checksum = suffix
+			p.Filename = strings.TrimSuffix(p.Filename, suffix)
+			break
+		}
+	}
+
+	// Artifact-level metadata: group.../artifactId/maven-metadata.xml.
+	if p.Filename == mavenMetadataFile && len(segments) >= 3 {
+		p.ArtifactID = segments[len(segments)-2]
+		p.GroupID = strings.Join(segments[:len(segments)-2], ".")
+		// Version-level metadata only exists for snapshots.
+		if strings.HasSuffix(p.ArtifactID, "-SNAPSHOT") {
+			return mavenPath{}, errors.New("SNAPSHOT versions are not supported")
+		}
+		return p, nil
+	}
+
+	if len(segments) < 4 {
+		return mavenPath{}, errors.New("expected group/artifact/version/file")
+	}
+	p.Version = segments[len(segments)-2]
+	p.ArtifactID = segments[len(segments)-3]
+	p.GroupID = strings.Join(segments[:len(segments)-3], ".")
+	if strings.HasSuffix(p.Version, "-SNAPSHOT") {
+		return mavenPath{}, errors.New("SNAPSHOT versions are not supported")
+	}
+	if !strings.HasPrefix(p.Filename, p.ArtifactID+"-"+p.Version) {
+		return mavenPath{}, fmt.Errorf("file name must start with %s-%s", p.ArtifactID, p.Version)
+	}
+	if sanitizeFilename(p.Filename) != p.Filename {
+		return mavenPath{}, errors.New("invalid file name")
+	}
+	return p, nil
+}
+
+// mavenMetadata is the artifact-level maven-metadata.xml document.
+type mavenMetadata struct {
+	XMLName    xml.Name `xml:"metadata"`
+	GroupID    string   `xml:"groupId"`
+	ArtifactID string   `xml:"artifactId"`
+	Versioning struct {
+		Latest      string   `xml:"latest"`
+		Release     string   `xml:"release"`
+		Versions    []string `xml:"versions>version"`
+		LastUpdated string   `xml:"lastUpdated"`
+	} `xml:"versioning"`
+}
+
+// MavenGet handles GET and HEAD under /maven2/.
+func (h *Handler) MavenGet(w http.ResponseWriter, r *http.Request) {
+	p, err := parseMavenPath(chi.URLParam(r, "*"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if p.Version == "" {
+		h.serveMavenMetadata(w, r, p)
+		return
+	}
+
+	file, err := h.findMavenFile(p)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("finding maven file")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if file == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s not found", chi.URLParam(r, "*")))
+		return
+	}
+
+	if p.Checksum != "" {
+		sum := file.Hash
+		if p.Checksum != ".sha256" {
+			if sum, err = h.blobChecksum(file.Hash, mavenChecksums[p.Checksum]); err != nil {
+				h.logger.Error().Err(err).Str("hash", file.Hash).Msg("computing checksum")
+				writeError(w, http.StatusInternalServerError, "internal error")
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		h.cacheDownload(w)
+		io.WriteString(w, sum)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		h.headArtifact(w, r, file)
+		return
+	}
+	h.serveArtifact(w, r, file)
+}
+
+// MavenPut handles PUT under /maven2/, as sent by mvn deploy and Gradle's
+// maven-publish plugin.
+func (h *Handler) MavenPut(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	p, err := parseMavenPath(chi.URLParam(r, "*"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Metadata is generated from the stored versions.
+	if p.Filename == mavenMetadataFile {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if p.Checksum != "" {
+		h.checkMavenChecksum(w, r, p)
+		return
+	}
+	if !h.checkNaming(w, r, p.packageName(), p.Version) {
+		return
+	}
+	if !h.checkPolicy(w, r, models.PolicyRequest{
+		Action: models.PolicyActionUpload, Package: p.packageName(), Version: p.Version, File: p.Filename,
+	}) {
+		return
+	}
+
+	release, ok := h.limitUpload(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	hash, size, releaseBlob, err := h.storeBlob(r.Context(), p.packageName(), r.Body)
+	if err != nil {
+		status, msg := h.storeFailure(err)
+		writeError(w, status, msg)
+		return
+	}
+	defer releaseBlob()
+
+	pkgName := p.packageName()
+	unlock := h.lockArtifactUpload(pkgName, p.Version)
+	defer unlock()
+
+	existing, err := h.findMavenFile(p)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("finding maven file")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if existing != nil {
+		// Releases are immutable, but retrying an identical deploy succeeds.
+		if existing.Hash == hash {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		writeError(w, http.StatusConflict, fmt.Sprintf("%s already exists with different content", p.Filename))
+		return
+	}
+
+	event := models.HookEvent{Hook: models.HookPreUpload, Package: pkgName, Version: p.Version, File: p.Filename, Hash: hash, Size: size}
+	if !h.checkUpload(w, r, &event) {
+		return
+	}
+	if _, err := h.attachFile(r, pkgName, p.Version, versionOptions{stage: h.defaultStage}, models.AssetInput{Name: p.Filename, Hash: hash, Size: size}); err != nil {
+		if errors.Is(err, services.ErrConflict) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("%s already exists", p.Filename))
+			return
+		}
+		if errors.Is(err, services.ErrReadOnly) {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		h.logger.Error().Err(err).Msg("recording maven file")
+		writeError(w, http.StatusInternalServerError, "failed to create artifact metadata")
+		return
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("package", pkgName).
+		Str("version", p.Version).
+		Str("file", p.Filename).
+		Str("hash", hash).
+		Int64("size", size).
+		Dur("upload_latency", time.Since(start)).
+		Msg("maven upload completed")
+	h.runPostUpload(r, event)
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// findMavenFile returns the named file of a Maven version, or nil.
+func (h *Handler) findMavenFile(p mavenPath) (*models.Artifact, error) {
+	artifact, err := h.meta.GetArtifact(p.packageName(), p.Version)
+	if err != nil || artifact == nil {
+		return nil, err
+	}
+	if downloadFilename(artifact) == p.Filename {
+		return artifact, nil
+	}
+
+	asset, err := h.meta.GetAsset(artifact.Package, artifact.Version, p.Filename)
+	if err != nil || asset == nil {
+		return nil, err
+	}
+	file := *artifact
+	file.Hash = asset.Hash
+	file.Size = asset.Size
+	file.Filename = asset.Name
+	file.ContentType = asset.ContentType
+	file.UploadedAt = asset.UploadedAt
+	return &file, nil
+}
+
+// checkMavenChecksum compares an uploaded checksum with the stored file's
+// when the file is already present; checksums are otherwise generated.
+func (h *Handler) checkMavenChecksum(w http.ResponseWriter, r *http.Request, p mavenPath) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1024))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "reading checksum")
+		return
+	}
+	// Checksum files may carry a trailing file name after the digest.
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		writeError(w, http.StatusBadRequest, "empty checksum")
+		return
+	}
+
+	file, err := h.findMavenFile(p)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("finding maven file")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if file != nil {
+		want, err := h.blobChecksum(file.Hash, mavenChecksums[p.Checksum])
+		if err != nil {
+			h.logger.Error().Err(err).Str("hash", file.Hash).Msg("computing checksum")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if !strings.EqualFold(fields[0], want) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("%s checksum mismatch for %s", strings.TrimPrefix(p.Checksum, "."), p.Filename))
+			return
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// blobChecksum hashes a stored blob with newHash.
+func (h *Handler) blobChecksum(blobHash string, newHash func() hash.Hash) (string, error) {
+	reader, err := h.blobs.Open(blobHash)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hasher := newHash()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return "", fmt.Errorf("reading blob: %w", err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// serveMavenMetadata generates maven-metadata.xml, or one of its checksums,
+// from the versions stored for the artifact.
+func (h *Handler) serveMavenMetadata(w http.ResponseWriter, r *http.Request, p mavenPath) {
+	artifacts, err := h.meta.ListArtifacts(p.packageName())
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing artifacts")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	artifacts = released(visibleArtifacts(r, artifacts))
+	if len(artifacts) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s not found", p.packageName()))
+		return
+	}
+
+	// ListArtifacts returns the newest first; Maven lists oldest first.
+	doc := mavenMetadata{GroupID: p.GroupID, ArtifactID: p.ArtifactID}
+	doc.Versioning.Latest = artifacts[0].Version
+	doc.Versioning.Release = artifacts[0].Version
+	doc.Versioning.LastUpdated = artifacts[0].UploadedAt.UTC().Format("20060102150405")
+	for i := len(artifacts) - 1; i >= 0; i-- {
+		doc.Versioning.Versions = append(doc.Versioning.Versions, artifacts[i].Version)
+	}
+
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		h.logger.Error().Err(err).Msg("encoding maven metadata")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	body = append([]byte(xml.Header), append(body, '\n')...)
+
+	if p.Checksum != "" {
+		hasher := mavenChecksums[p.Checksum]()
+		hasher.Write(body)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		h.cacheListing(w)
+		io.WriteString(w, hex.EncodeToString(hasher.Sum(nil)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	h.cacheListing(w)
+	w.Write(body)
+}
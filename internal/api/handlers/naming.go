@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/util/logging"
+	"github.com/foundry/registry/internal/util/naming"
+)
+
+// WithNaming holds uploads to the naming rules. Packages and versions
+// stored before are still served, changed and deleted, but not uploaded
+// to while their names break the rules.
+func WithNaming(rules naming.Rules) Option {
+	return func(h *Handler) {
+		h.naming = rules
+	}
+}
+
+// namingDecision checks the package and version an upload by the caller of
+// r names. It returns status 0 when the names are allowed, and otherwise
+// the status and message to answer with: 400 for a name breaking the
+// rules, 403 for a new package under a reserved prefix created by anyone
+// but an admin.
+func (h *Handler) namingDecision(r *http.Request, pkgName, version string) (int, string) {
+	if err := h.naming.Check(pkgName, version); err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+	prefix := h.naming.Reserved(pkgName)
+	if prefix == "" || isAdmin(r) {
+		return 0, ""
+	}
+	pkg, err := h.meta.GetPackage(pkgName)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting package")
+		return http.StatusInternalServerError, "internal error"
+	}
+	if pkg != nil {
+		// Its owners manage it from here.
+		return 0, ""
+	}
+	h.logger.Warn().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Str("package", pkgName).
+		Str("prefix", prefix).
+		Msg("reserved package name refused")
+	return http.StatusForbidden, fmt.Sprintf("package names starting with %s are reserved", prefix)
+}
+
+// checkNaming writes the error response and returns false when an upload
+// may not use pkgName and version.
+func (h *Handler) checkNaming(w http.ResponseWriter, r *http.Request, pkgName, version string) bool {
+	if status, msg := h.namingDecision(r, pkgName, version); status != 0 {
+		writeError(w, status, msg)
+		return false
+	}
+	return true
+}
+
+// ListNamingViolations handles GET /api/v1/admin/naming/violations, listing
+// the stored packages and versions whose names break the naming rules, by
+// package name, so they can be renamed or removed before clients trip over
+// them.
+func (h *Handler) ListNamingViolations(w http.ResponseWriter, r *http.Request) {
+	packages, err := h.meta.ListPackages()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing packages")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	violations := []models.NamingViolation{}
+	for _, pkg := range packages {
+		if problems := h.naming.PackageProblems(pkg.Name); len(problems) > 0 {
+			violations = append(violations, models.NamingViolation{Package: pkg.Name, Problems: problems})
+		}
+		artifacts, err := h.meta.ListArtifacts(pkg.Name)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("listing artifacts")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		for _, a := range artifacts {
+			if problems := h.naming.VersionProblems(a.Version); len(problems) > 0 {
+				violations = append(violations, models.NamingViolation{Package: pkg.Name, Version: a.Version, Problems: problems})
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, violations)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// maxReleaseNotesBytes bounds a version's release notes, which are
+// returned with every listing of the version.
+const maxReleaseNotesBytes = 64 << 10
+
+// GetReleaseNotes handles GET /api/v1/artifacts/{package}/{version}/notes,
+// serving the version's release notes as Markdown.
+func (h *Handler) GetReleaseNotes(w http.ResponseWriter, r *http.Request) {
+	artifact, ok := h.lookupArtifact(w, r)
+	if !ok {
+		return
+	}
+	if artifact.ReleaseNotes == "" {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s has no release notes", artifact.Package, artifact.Version))
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	h.cacheListing(w)
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, artifact.ReleaseNotes)
+}
+
+// SetReleaseNotes handles PUT /api/v1/artifacts/{package}/{version}/notes,
+// attaching or replacing the version's release notes with the Markdown
+// request body. Anyone who may publish the version may write its notes.
+func (h *Handler) SetReleaseNotes(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxReleaseNotesBytes+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "reading release notes")
+		return
+	}
+	if len(data) > maxReleaseNotesBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("release notes exceed %d bytes", maxReleaseNotesBytes))
+		return
+	}
+	if !utf8.Valid(data) {
+		writeError(w, http.StatusBadRequest, "release notes must be UTF-8 text")
+		return
+	}
+	notes := strings.TrimSpace(string(data))
+	if notes == "" {
+		writeError(w, http.StatusBadRequest, "release notes are empty")
+		return
+	}
+	h.setReleaseNotes(w, r, notes+"\n")
+}
+
+// DeleteReleaseNotes handles DELETE /api/v1/artifacts/{package}/{version}/notes.
+func (h *Handler) DeleteReleaseNotes(w http.ResponseWriter, r *http.Request) {
+	h.setReleaseNotes(w, r, "")
+}
+
+// setReleaseNotes replaces the notes of the version named in the URL,
+// clearing them when notes is empty, and writes the version back.
+func (h *Handler) setReleaseNotes(w http.ResponseWriter, r *http.Request, notes string) {
+	artifact, unlock, ok := h.lookupForUpdate(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionUpload, Package: artifact.Package, Version: artifact.Version}) {
+		return
+	}
+
+	if err := h.meta.SetReleaseNotes(artifact.Package, artifact.Version, notes); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", artifact.Package, artifact.Version))
+			return
+		}
+		h.logger.Error().Err(err).Msg("setting release notes")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	updated, err := h.meta.GetArtifact(artifact.Package, artifact.Version)
+	if err != nil || updated == nil {
+		h.logger.Error().Err(err).Msg("getting artifact after setting release notes")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Str("package", artifact.Package).
+		Str("version", artifact.Version).
+		Int("bytes", len(notes)).
+		Msg("release notes set")
+	writeJSON(w, http.StatusOK, updated)
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/adapters/auth"
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// notifyEvents lists the events a subscription may name.
+var notifyEvents = []string{models.NotifyCreate, models.NotifyOverwrite, models.NotifyDelete, models.NotifyPromote, models.NotifyCopy}
+
+// WithNotifications enables the subscription endpoints, keeping
+// subscriptions in store and delivering their notifications with notifier.
+func WithNotifications(store services.SubscriptionStore, notifier services.Notifier) Option {
+	return func(h *Handler) {
+		h.subscriptions = store
+		h.notifier = notifier
+	}
+}
+
+// subscribed reports whether sub wants n.
+func subscribed(sub models.Subscription, n models.Notification) bool {
+	if len(sub.Events) > 0 && !slices.Contains(sub.Events, n.Event) {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(sub.Package, "*"); ok {
+		return strings.HasPrefix(n.Package, prefix)
+	}
+	return sub.Package == n.Package
+}
+
+// notify delivers n to every matching subscription in the background, so
+// a slow or failing channel never holds up the request. Failures are
+// logged. r may be nil for changes the server makes itself. Like the
+// routes, notifications keep quarantined versions from all but admins.
+func (h *Handler) notify(r *http.Request, n models.Notification) {
+	if h.subscriptions == nil {
+		return
+	}
+	quarantined := n.Quarantined
+	if artifact, err := h.meta.GetArtifact(n.Package, n.Version); err == nil && artifact != nil {
+		quarantined = quarantined || artifact.Quarantined
+	}
+	ctx := context.Background()
+	if r != nil {
+		ctx = context.WithoutCancel(r.Context())
+		if n.Actor == "" {
+			if p := principalFrom(r.Context()); p != nil {
+				n.Actor = p.Name
+			}
+		}
+	}
+	if n.At.IsZero() {
+		n.At = h.clock.Now()
+	}
+	subs, err := h.subscriptions.ListSubscriptions()
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("listing subscriptions")
+		return
+	}
+	for _, sub := range subs {
+		if !subscribed(sub, n) || quarantined && !sub.Admin {
+			continue
+		}
+		go func() {
+			if err := h.notifier.Notify(ctx, sub, n); err != nil {
+				h.logger.Warn().Err(err).
+					Str("request_id", logging.RequestID(ctx)).
+					Int64("subscription_id", sub.ID).
+					Str("channel", sub.Channel).
+					Str("package", n.Package).
+					Str("version", n.Version).
+					Str("event", n.Event).
+					Msg("delivering notification")
+			}
+		}()
+	}
+}
+
+// ListSubscriptions handles GET /api/v1/subscriptions. Admins see every
+// subscription, other callers those they created. Secrets are left out.
+func (h *Handler) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	if !h.subscriptionsEnabled(w) {
+		return
+	}
+	subs, err := h.subscriptions.ListSubscriptions()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing subscriptions")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	out := []models.Subscription{}
+	for _, sub := range subs {
+		if ownsSubscription(r, sub) {
+			sub.Secret = ""
+			out = append(out, sub)
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// CreateSubscription handles POST /api/v1/subscriptions. Webhook
+// subscriptions without a secret are given one, returned only in this
+// response.
+func (h *Handler) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	if !h.subscriptionsEnabled(w) {
+		return
+	}
+
+	var req models.CreateSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	sub := models.Subscription{
+		Package: strings.TrimSpace(req.Package),
+		Events:  req.Events,
+		Channel: req.Channel,
+		Target:  strings.TrimSpace(req.Target),
+	}
+	if sub.Package == "" || strings.Contains(strings.TrimSuffix(sub.Package, "*"), "*") {
+		writeError(w, http.StatusBadRequest, "package must be a package name or a prefix ending in *")
+		return
+	}
+	for _, e := range sub.Events {
+		if !slices.Contains(notifyEvents, e) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown event %q: expected one of %s", e, strings.Join(notifyEvents, ", ")))
+			return
+		}
+	}
+	if err := h.notifier.Validate(sub); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if sub.Channel == models.ChannelWebhook {
+		sub.Secret = req.Secret
+		if sub.Secret == "" {
+			secret, err := auth.GenerateToken()
+			if err != nil {
+				h.logger.Error().Err(err).Msg("generating subscription secret")
+				writeError(w, http.StatusInternalServerError, "internal error")
+				return
+			}
+			sub.Secret = secret
+		}
+	}
+	if p := principalFrom(r.Context()); p != nil {
+		sub.CreatedBy, sub.Admin = p.Name, p.Admin
+	}
+
+	created, err := h.subscriptions.CreateSubscription(sub)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("creating subscription")
+		writeError(w, http.StatusInternalServerError, "failed to create subscription")
+		return
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Int64("subscription_id", created.ID).
+		Str("package", created.Package).
+		Str("channel", created.Channel).
+		Str("created_by", created.CreatedBy).
+		Msg("subscription created")
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// DeleteSubscription handles DELETE /api/v1/subscriptions/{id}. Only
+// admins may delete subscriptions created by others.
+func (h *Handler) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if !h.subscriptionsEnabled(w) {
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid subscription id")
+		return
+	}
+	subs, err := h.subscriptions.ListSubscriptions()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing subscriptions")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	i := slices.IndexFunc(subs, func(sub models.Subscription) bool { return sub.ID == id })
+	// Others' subscriptions are reported missing rather than forbidden,
+	// so their IDs are not revealed.
+	if i < 0 || !ownsSubscription(r, subs[i]) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("subscription %d not found", id))
+		return
+	}
+	if err := h.subscriptions.DeleteSubscription(id); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("subscription %d not found", id))
+			return
+		}
+		h.logger.Error().Err(err).Msg("deleting subscription")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Int64("subscription_id", id).
+		Msg("subscription deleted")
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ownsSubscription reports whether the caller of r may see and delete sub.
+func ownsSubscription(r *http.Request, sub models.Subscription) bool {
+	if isAdmin(r) {
+		return true
+	}
+	p := principalFrom(r.Context())
+	return p != nil && p.Name == sub.CreatedBy
+}
+
+func (h *Handler) subscriptionsEnabled(w http.ResponseWriter) bool {
+	if h.subscriptions == nil {
+		writeError(w, http.StatusNotImplemented, "notifications are not enabled")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// findMissingFiles lists the version files whose blob is not among present,
+// the blobs in storage. With tombstone set, the metadata of each missing
+// file is removed the way a delete would: a missing default file takes its
+// version with it, and pinned versions are kept.
+func (h *Handler) findMissingFiles(r *http.Request, present map[string]bool, tombstone bool) ([]models.FileRef, error) {
+	refs, err := h.meta.ListFileRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []models.FileRef
+	removed := make(map[string]bool)
+	for _, ref := range refs {
+		if present[ref.Hash] {
+			continue
+		}
+		version := ref.Package + "@" + ref.Version
+		if removed[version] {
+			// Went with its version's default file.
+			ref.Tombstoned = true
+			missing = append(missing, ref)
+			continue
+		}
+		gone, err := h.checkMissingFile(r, &ref, tombstone)
+		if err != nil {
+			return nil, err
+		}
+		if !gone {
+			continue
+		}
+		if ref.Tombstoned && ref.File == "" {
+			removed[version] = true
+		}
+		missing = append(missing, ref)
+	}
+	return missing, nil
+}
+
+// checkMissingFile confirms under the version's lock that ref's blob is
+// still missing, since uploads record metadata before committing their
+// blob, and tombstones it if asked.
+func (h *Handler) checkMissingFile(r *http.Request, ref *models.FileRef, tombstone bool) (bool, error) {
+	unlock := h.lockArtifactUpload(ref.Package, ref.Version)
+	defer unlock()
+
+	if h.blobs.Exists(ref.Hash) {
+		return false, nil
+	}
+	h.logger.Warn().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("package", ref.Package).
+		Str("version", ref.Version).
+		Str("file", ref.File).
+		Str("hash", ref.Hash).
+		Msg("blob missing from storage")
+	if !tombstone {
+		return true, nil
+	}
+
+	artifact, err := h.meta.GetArtifact(ref.Package, ref.Version)
+	if err != nil {
+		return false, err
+	}
+	if artifact == nil {
+		return false, nil
+	}
+	if artifact.Pinned {
+		return true, nil
+	}
+	if ref.File == "" {
+		err = h.deleteVersion(r, "", ref.Package, ref.Version)
+	} else if err = h.meta.DeleteAsset(ref.Package, ref.Version, ref.File); err == nil {
+		h.recordHistory(r, models.HistoryEvent{
+			Package: ref.Package, Version: ref.Version, File: ref.File, Action: models.HistoryDelete, OldHash: ref.Hash,
+		})
+		h.reclaimReleased()
+	}
+	if errors.Is(err, services.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	ref.Tombstoned = true
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Str("package", ref.Package).
+		Str("version", ref.Version).
+		Str("file", ref.File).
+		Str("hash", ref.Hash).
+		Msg("tombstoned file with missing blob")
+	return true, nil
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// Orders accepted by ?sort= on the package list.
+const (
+	sortName    = "name"
+	sortUpdated = "updated"
+	sortSize    = "size"
+)
+
+// packageOrder is the order of the package list, from ?sort= and ?order=.
+type packageOrder struct {
+	by   string
+	desc bool
+}
+
+// parsePackageOrder reads ?sort= and ?order=. Names sort ascending by
+// default, recency and size descending, so the most recently updated or
+// largest packages come first.
+func parsePackageOrder(r *http.Request) (packageOrder, error) {
+	o := packageOrder{by: r.URL.Query().Get("sort")}
+	switch o.by {
+	case "":
+		o.by = sortName
+	case sortName:
+	case sortUpdated, sortSize:
+		o.desc = true
+	default:
+		return o, errors.New("sort must be name, updated or size")
+	}
+	switch r.URL.Query().Get("order") {
+	case "":
+	case "asc":
+		o.desc = false
+	case "desc":
+		o.desc = true
+	default:
+		return o, errors.New("order must be asc or desc")
+	}
+	return o, nil
+}
+
+// less orders packages by the sort key, then by name ascending, so pages
+// cut from the list are stable. Packages with no versions count as the
+// least recently updated.
+func (o packageOrder) less(a, b models.Package) bool {
+	var c int
+	switch o.by {
+	case sortName:
+		c = strings.Compare(a.Name, b.Name)
+	case sortUpdated:
+		c = compareUpdated(a.UpdatedAt, b.UpdatedAt)
+	case sortSize:
+		c = cmp.Compare(a.TotalSize, b.TotalSize)
+	}
+	if o.desc {
+		c = -c
+	}
+	if c == 0 {
+		c = strings.Compare(a.Name, b.Name)
+	}
+	return c < 0
+}
+
+func compareUpdated(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Compare(*b)
+}
+
+func (o packageOrder) sort(pkgs []models.Package) {
+	sort.SliceStable(pkgs, func(i, j int) bool { return o.less(pkgs[i], pkgs[j]) })
+}
+
+// cursorKey records p's place in the order: its name, preceded for the
+// other sorts by the sort value and a space.
+func (o packageOrder) cursorKey(p models.Package) string {
+	switch o.by {
+	case sortUpdated:
+		var updated string
+		if p.UpdatedAt != nil {
+			updated = p.UpdatedAt.UTC().Format(time.RFC3339Nano)
+		}
+		return updated + " " + p.Name
+	case sortSize:
+		return strconv.FormatInt(p.TotalSize, 10) + " " + p.Name
+	}
+	return p.Name
+}
+
+// cursorPackage rebuilds the sort position a cursor made from cursorKey
+// records.
+func (o packageOrder) cursorPackage(cursor string) (models.Package, error) {
+	_, key, err := decodeCursor(cursor)
+	if err != nil {
+		return models.Package{}, err
+	}
+	if o.by == sortName {
+		return models.Package{Name: key}, nil
+	}
+	value, name, ok := strings.Cut(key, " ")
+	if !ok {
+		return models.Package{}, errors.New("invalid cursor")
+	}
+	p := models.Package{Name: name}
+	switch o.by {
+	case sortUpdated:
+		if value != "" {
+			updated, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return p, errors.New("invalid cursor")
+			}
+			p.UpdatedAt = &updated
+		}
+	case sortSize:
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return p, errors.New("invalid cursor")
+		}
+		p.TotalSize = size
+	}
+	return p, nil
+}
+
+// pagePackages cuts one page from pkgs, sorted in order. Unlike the other
+// lists the response stays a bare array, so paging is opt-in and the next
+// page is only linked in the Link header.
+func (h *Handler) pagePackages(w http.ResponseWriter, r *http.Request, pkgs []models.Package, order packageOrder) ([]models.Package, bool) {
+	limit, err := pageLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		after, err := order.cursorPackage(cursor)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return nil, false
+		}
+		pkgs = pkgs[sort.Search(len(pkgs), func(i int) bool { return order.less(after, pkgs[i]) }):]
+	}
+	if len(pkgs) > limit {
+		pkgs = pkgs[:limit]
+		last := pkgs[limit-1]
+		h.setNextLink(w, r, encodeCursor(last.ID, order.cursorKey(last)))
+	}
+	return pkgs, true
+}
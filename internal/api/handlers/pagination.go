@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// List endpoints page with ?limit= and an opaque ?cursor= taken from the
+// previous page's next_cursor, which is also linked as rel="next" in a Link
+// header. Cursors record the sort key of the last item returned, so pages
+// stay consistent while rows are added or removed.
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// pageLimit parses ?limit=, defaulting to defaultPageSize.
+func pageLimit(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return defaultPageSize, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > maxPageSize {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+	return n, nil
+}
+
+// encodeCursor packs an (id, key) sort position into a cursor.
+func encodeCursor(id int64, key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10) + ":" + key))
+}
+
+// decodeCursor unpacks a cursor made by encodeCursor.
+func decodeCursor(cursor string) (int64, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", errors.New("invalid cursor")
+	}
+	idPart, key, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, "", errors.New("invalid cursor")
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return 0, "", errors.New("invalid cursor")
+	}
+	return id, key, nil
+}
+
+// setNextLink points a Link header at the page after cursor, keeping the
+// request's other query parameters.
+func (h *Handler) setNextLink(w http.ResponseWriter, r *http.Request, cursor string) {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	next := h.externalURL(r) + strings.TrimPrefix(r.URL.EscapedPath(), h.basePath) + "?" + query.Encode()
+	w.Header().Set("Link", "<"+next+`>; rel="next"`)
+}
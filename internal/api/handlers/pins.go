@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// PinArtifact handles PUT /api/v1/artifacts/{package}/{version}/pin,
+// protecting a version from deletion, whether by DELETE or by expiry. Any
+// caller who may publish to the package may pin; only admins unpin.
+func (h *Handler) PinArtifact(w http.ResponseWriter, r *http.Request) {
+	h.setPinned(w, r, true)
+}
+
+// UnpinArtifact handles DELETE /api/v1/artifacts/{package}/{version}/pin.
+func (h *Handler) UnpinArtifact(w http.ResponseWriter, r *http.Request) {
+	h.setPinned(w, r, false)
+}
+
+// setPinned changes the pin of the version named in the URL and writes the
+// version back. Setting the state it already has succeeds without change.
+func (h *Handler) setPinned(w http.ResponseWriter, r *http.Request, pinned bool) {
+	artifact, unlock, ok := h.lookupForUpdate(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionPin, Package: artifact.Package, Version: artifact.Version}) {
+		return
+	}
+	if artifact.Pinned == pinned {
+		writeJSON(w, http.StatusOK, artifact)
+		return
+	}
+
+	if err := h.meta.SetPinned(artifact.Package, artifact.Version, pinned); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", artifact.Package, artifact.Version))
+			return
+		}
+		h.logger.Error().Err(err).Msg("setting pin")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	artifact.Pinned = pinned
+	artifact.Revision++
+
+	by := ""
+	if p := principalFrom(r.Context()); p != nil {
+		by = p.Name
+	}
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Str("package", artifact.Package).
+		Str("version", artifact.Version).
+		Bool("pinned", pinned).
+		Str("by", by).
+		Msg("artifact pin changed")
+
+	writeJSON(w, http.StatusOK, artifact)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// WithPolicy consults p before uploads and deletes.
+func WithPolicy(p services.PolicyEngine) Option {
+	return func(h *Handler) {
+		h.policy = p
+	}
+}
+
+// policyDecision asks the policy engine whether the caller of r may perform
+// req, once package ownership allows it. It returns status 0 when the
+// operation may proceed, and otherwise the status and message to answer
+// with: 403 when the policy refuses, 503 when it cannot decide.
+func (h *Handler) policyDecision(r *http.Request, req models.PolicyRequest) (int, string) {
+	if status, msg := h.ownershipDecision(r, req); status != 0 {
+		return status, msg
+	}
+	if h.policy == nil {
+		return 0, ""
+	}
+	req.Principal = principalFrom(r.Context())
+	req.ClientIP = logging.ClientIP(r.Context())
+
+	err := h.policy.Evaluate(r.Context(), req)
+	switch {
+	case err == nil:
+		return 0, ""
+	case errors.Is(err, services.ErrPolicyDenied):
+		h.logger.Warn().
+			Str("request_id", logging.RequestID(r.Context())).
+			Str("client_ip", req.ClientIP).
+			Str("action", req.Action).
+			Str("package", req.Package).
+			Str("version", req.Version).
+			Str("reason", err.Error()).
+			Msg("request denied by policy")
+		return http.StatusForbidden, err.Error()
+	default:
+		h.logger.Error().Err(err).Msg("evaluating policy")
+		return http.StatusServiceUnavailable, "policy evaluation failed"
+	}
+}
+
+// checkPolicy writes the error response and returns false when the policy
+// does not allow req.
+func (h *Handler) checkPolicy(w http.ResponseWriter, r *http.Request, req models.PolicyRequest) bool {
+	if status, msg := h.policyDecision(r, req); status != 0 {
+		writeError(w, status, msg)
+		return false
+	}
+	return true
+}
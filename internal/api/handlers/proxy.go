@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// WithTrustedProxies lists the networks of the reverse proxies in front of
+// the server. Requests arriving from them have their X-Forwarded-For and
+// X-Real-IP headers believed for the client address, and their
+// X-Forwarded-Prefix, X-Forwarded-Proto and X-Forwarded-Host headers shape
+// the URLs the server hands out. Headers from other peers are ignored, since
+// clients could forge them.
+func WithTrustedProxies(networks []netip.Prefix) Option {
+	return func(h *Handler) {
+		h.trustedProxies = networks
+	}
+}
+
+// WithTrustedSockets lists the Unix sockets whose peers are trusted
+// proxies. Peers on other sockets have no address and their headers are
+// ignored like those of any untrusted peer.
+func WithTrustedSockets(paths []string) Option {
+	return func(h *Handler) {
+		h.trustedSockets = paths
+	}
+}
+
+// peerAddr returns the address of the host that opened the connection.
+func peerAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func (h *Handler) isTrustedProxy(addr netip.Addr) bool {
+	for _, network := range h.trustedProxies {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromTrustedProxy reports whether r arrived through a trusted proxy.
+func (h *Handler) fromTrustedProxy(r *http.Request) bool {
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+		return slices.Contains(h.trustedSockets, local.String())
+	}
+	addr, ok := peerAddr(r)
+	return ok && h.isTrustedProxy(addr)
+}
+
+// clientIP returns the address of the client behind r. For requests from a
+// trusted proxy it walks X-Forwarded-For from the nearest hop outwards and
+// takes the first address that is not itself a trusted proxy, falling back
+// to X-Real-IP; otherwise it is the peer address.
+func (h *Handler) clientIP(r *http.Request) string {
+	client := r.RemoteAddr
+	if peer, ok := peerAddr(r); ok {
+		client = peer.String()
+	}
+	if !h.fromTrustedProxy(r) {
+		return client
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			addr = addr.Unmap()
+			client = addr.String()
+			if !h.isTrustedProxy(addr) {
+				break
+			}
+		}
+		return client
+	}
+	if real, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return real.Unmap().String()
+	}
+	return client
+}
+
+// clientIPMiddleware records the client address for logging and policy
+// checks.
+func (h *Handler) clientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := logging.WithClientIP(r.Context(), h.clientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// forwardedValue returns the first entry of a proxy header, which may list
+// one value per hop.
+func forwardedValue(r *http.Request, name string) string {
+	value, _, _ := strings.Cut(r.Header.Get(name), ",")
+	return strings.TrimSpace(value)
+}
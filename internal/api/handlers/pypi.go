@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// PyPI projects are stored as packages named by their PEP 503 normalized
+// name, one version per release, with each distribution file (wheel or
+// sdist) as a file of that version. They share the package namespace with
+// other artifacts.
+
+// maxPyPIFieldSize bounds each non-file field of an upload form.
+const maxPyPIFieldSize = 1 << 20
+
+var pypiNameSeparators = regexp.MustCompile(`[-_.]+`)
+
+// normalizePyPIName implements the PEP 503 project name normalization.
+func normalizePyPIName(name string) string {
+	return strings.ToLower(pypiNameSeparators.ReplaceAllString(name, "-"))
+}
+
+// distributionSuffixes are the file types served in the simple index.
+var distributionSuffixes = []string{".whl", ".tar.gz", ".zip", ".tar.bz2", ".egg"}
+
+func isDistribution(filename string) bool {
+	lower := strings.ToLower(filename)
+	for _, suffix := range distributionSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+var simpleRootTemplate = template.Must(template.New("root").Parse(`<!DOCTYPE html>
+<html>
+  <head><meta name="pypi:repository-version" content="1.0"><title>Simple index</title></head>
+  <body>
+{{- range .}}
+    <a href="{{.}}/">{{.}}</a><br>
+{{- end}}
+  </body>
+</html>
+`))
+
+var simpleProjectTemplate = template.Must(template.New("project").Parse(`<!DOCTYPE html>
+<html>
+  <head><meta name="pypi:repository-version" content="1.0"><title>Links for {{.Name}}</title></head>
+  <body>
+    <h1>Links for {{.Name}}</h1>
+{{- range .Files}}
+    <a href="{{.URL}}">{{.Name}}</a><br>
+{{- end}}
+  </body>
+</html>
+`))
+
+type simpleFile struct {
+	Name string
+	URL  string
+}
+
+// PyPISimpleIndex handles GET /pypi/simple/, listing every project.
+func (h *Handler) PyPISimpleIndex(w http.ResponseWriter, r *http.Request) {
+	pkgs, err := h.meta.ListPackages()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing packages")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	names := make([]string, 0, len(pkgs))
+	for _, p := range pkgs {
+		// Only normalized names can be PyPI projects.
+		if p.Name == normalizePyPIName(p.Name) {
+			names = append(names, p.Name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	h.cacheListing(w)
+	if err := simpleRootTemplate.Execute(w, names); err != nil {
+		h.logger.Error().Err(err).Msg("rendering simple index")
+	}
+}
+
+// PyPISimpleProject handles GET /pypi/simple/{project}/, linking each
+// distribution file with its sha256 so pip can verify downloads.
+func (h *Handler) PyPISimpleProject(w http.ResponseWriter, r *http.Request) {
+	project := chi.URLParam(r, "project")
+	normalized := normalizePyPIName(project)
+	if project != normalized || !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, h.pathPrefix(r)+"/pypi/simple/"+normalized+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	artifacts, err := h.meta.ListArtifacts(normalized)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing artifacts")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	artifacts = released(visibleArtifacts(r, artifacts))
+	if len(artifacts) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("project %s not found", normalized))
+		return
+	}
+
+	var files []simpleFile
+	for i := range artifacts {
+		versionFiles, err := h.versionFiles(&artifacts[i])
+		if err != nil {
+			h.logger.Error().Err(err).Msg("listing assets")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		for _, f := range versionFiles {
+			if !isDistribution(f.Name) {
+				continue
+			}
+			files = append(files, simpleFile{
+				Name: f.Name,
+				URL: fmt.Sprintf("%s/api/v1/artifacts/%s/%s/files/%s#sha256=%s", h.pathPrefix(r),
+					url.PathEscape(normalized), url.PathEscape(artifacts[i].Version), url.PathEscape(f.Name), f.Hash),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	h.cacheListing(w)
+	err = simpleProjectTemplate.Execute(w, struct {
+		Name  string
+		Files []simpleFile
+	}{normalized, files})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("rendering simple project page")
+	}
+}
+
+// PyPIUpload handles POST /pypi/, the legacy upload API used by twine. The
+// form's fields are read as they arrive and the "content" file is streamed
+// straight to blob storage.
+func (h *Handler) PyPIUpload(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	release, ok := h.limitUpload(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		writeError(w, http.StatusBadRequest, "expected a multipart/form-data upload")
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fields := make(map[string]string)
+	var filename, hash string
+	var size int64
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("reading upload: %v", err))
+			return
+		}
+
+		if part.FormName() == "content" {
+			filename = sanitizeFilename(part.FileName())
+			var releaseBlob func()
+			hash, size, releaseBlob, err = h.storeBlob(r.Context(), normalizePyPIName(fields["name"]), part)
+			if err != nil {
+				status, msg := h.storeFailure(err)
+				writeError(w, status, msg)
+				return
+			}
+			defer releaseBlob()
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxPyPIFieldSize+1))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("reading upload: %v", err))
+			return
+		}
+		if len(value) > maxPyPIFieldSize {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("field %s is too large", part.FormName()))
+			return
+		}
+		fields[part.FormName()] = string(value)
+	}
+
+	if action := fields[":action"]; action != "" && action != "file_upload" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported action %q", action))
+		return
+	}
+	project := normalizePyPIName(fields["name"])
+	version := strings.TrimSpace(fields["version"])
+	switch {
+	case project == "" || version == "":
+		writeError(w, http.StatusBadRequest, "name and version are required")
+		return
+	case hash == "":
+		writeError(w, http.StatusBadRequest, "upload has no content file")
+		return
+	case !isDistribution(filename):
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid distribution file name %q", filename))
+		return
+	}
+	if expected := fields["sha256_digest"]; expected != "" && !strings.EqualFold(expected, hash) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("digest mismatch: expected %s, got %s", expected, hash))
+		return
+	}
+	if !h.checkNaming(w, r, project, version) {
+		return
+	}
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionUpload, Package: project, Version: version, File: filename}) {
+		return
+	}
+
+	unlock := h.lockArtifactUpload(project, version)
+	defer unlock()
+
+	event := models.HookEvent{Hook: models.HookPreUpload, Package: project, Version: version, File: filename, Hash: hash, Size: size}
+	if !h.checkUpload(w, r, &event) {
+		return
+	}
+	if _, err := h.attachFile(r, project, version, versionOptions{stage: h.defaultStage}, models.AssetInput{Name: filename, Hash: hash, Size: size}); err != nil {
+		if errors.Is(err, services.ErrConflict) {
+			// twine --skip-existing recognizes 409.
+			writeError(w, http.StatusConflict, fmt.Sprintf("File already exists: %s", filename))
+			return
+		}
+		if errors.Is(err, services.ErrReadOnly) {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		h.logger.Error().Err(err).Msg("recording distribution")
+		writeError(w, http.StatusInternalServerError, "failed to create artifact metadata")
+		return
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("package", project).
+		Str("version", version).
+		Str("file", filename).
+		Str("hash", hash).
+		Int64("size", size).
+		Dur("upload_latency", time.Since(start)).
+		Msg("pypi upload completed")
+	h.runPostUpload(r, event)
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// WithQuarantine makes new versions, and versions gaining a file, wait in
+// quarantine until an admin approves them. Quarantined versions are hidden
+// from downloads and listings for non-admin callers.
+func WithQuarantine(enabled bool) Option {
+	return func(h *Handler) {
+		h.quarantine = enabled
+	}
+}
+
+// hidden reports whether artifact is quarantined and the caller of r may
+// not see it. Admins, including the scanners that review quarantined
+// versions, see everything.
+func hidden(r *http.Request, artifact *models.Artifact) bool {
+	return artifact.Quarantined && !isAdmin(r)
+}
+
+// isAdmin reports whether the caller of r has admin rights.
+func isAdmin(r *http.Request) bool {
+	p := principalFrom(r.Context())
+	return p != nil && p.Admin
+}
+
+// visibleArtifacts drops the artifacts hidden from the caller of r.
+func visibleArtifacts(r *http.Request, artifacts []models.Artifact) []models.Artifact {
+	visible := artifacts[:0:0]
+	for i := range artifacts {
+		if !hidden(r, &artifacts[i]) {
+			visible = append(visible, artifacts[i])
+		}
+	}
+	return visible
+}
+
+// ApproveArtifact handles POST /api/v1/artifacts/{package}/{version}/approve,
+// releasing a version from quarantine. Approving a version that is not
+// quarantined succeeds without change.
+func (h *Handler) ApproveArtifact(w http.ResponseWriter, r *http.Request) {
+	artifact, unlock, ok := h.lookupForUpdate(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	if artifact.Quarantined {
+		if err := h.meta.SetQuarantined(artifact.Package, artifact.Version, false); err != nil {
+			if errors.Is(err, services.ErrNotFound) {
+				writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", artifact.Package, artifact.Version))
+				return
+			}
+			h.logger.Error().Err(err).Msg("approving artifact")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		artifact.Quarantined = false
+		artifact.Revision++
+
+		approver := ""
+		if p := principalFrom(r.Context()); p != nil {
+			approver = p.Name
+		}
+		h.logger.Info().
+			Str("request_id", logging.RequestID(r.Context())).
+			Str("client_ip", logging.ClientIP(r.Context())).
+			Str("package", artifact.Package).
+			Str("version", artifact.Version).
+			Str("approved_by", approver).
+			Msg("artifact approved")
+	}
+
+	writeJSON(w, http.StatusOK, artifact)
+}
+
+// ListQuarantined handles GET /api/v1/admin/quarantine, listing the versions
+// awaiting approval, oldest first.
+func (h *Handler) ListQuarantined(w http.ResponseWriter, r *http.Request) {
+	artifacts, err := h.meta.ListQuarantined()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("listing quarantined artifacts")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if artifacts == nil {
+		artifacts = []models.Artifact{}
+	}
+	writeJSON(w, http.StatusOK, artifacts)
+}
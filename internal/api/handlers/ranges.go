@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// artifactETag returns the strong entity tag for a blob. Blobs are content
+// addressed, so the hash identifies the exact bytes.
+func artifactETag(hash string) string {
+	return `"` + hash + `"`
+}
+
+// requestedRange returns the byte range to serve for r, or ok=false to serve
+// the whole artifact. Only single ranges are honored; multi-range requests
+// and ranges guarded by a stale If-Range get the full body.
+func requestedRange(r *http.Request, etag string, size int64) (start, end int64, ok bool, err error) {
+	header := r.Header.Get("Range")
+	if header == "" {
+		return 0, 0, false, nil
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		return 0, 0, false, nil
+	}
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	if first == "" {
+		// Suffix range: the final n bytes.
+		n, perr := strconv.ParseInt(last, 10, 64)
+		if perr != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, errUnsatisfiableRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, nil
+	}
+
+	start, perr := strconv.ParseInt(first, 10, 64)
+	if perr != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	if start >= size {
+		return 0, 0, false, errUnsatisfiableRange
+	}
+	end = size - 1
+	if last != "" {
+		end, perr = strconv.ParseInt(last, 10, 64)
+		if perr != nil || end < start {
+			return 0, 0, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true, nil
+}
+
+// skipTo advances r by n bytes, seeking when the reader supports it.
+func skipTo(r io.Reader, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekStart)
+		return err
+	}
+	if _, err := io.CopyN(io.Discard, r, n); err != nil {
+		return fmt.Errorf("skipping to range start: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// The metadata store counts the references to every blob as it records
+// and removes them. A blob whose count drops to zero is released, and can
+// be deleted without the full scan garbage collection makes: right away,
+// with ReclaimImmediate, or every so often by RunReclaim, with
+// ReclaimLazy. Otherwise released blobs wait for the next collection.
+const (
+	ReclaimImmediate = "immediate"
+	ReclaimLazy      = "lazy"
+)
+
+// reclaimBatch is how many released blobs are listed at a time.
+const reclaimBatch = 100
+
+// WithBlobReclaim sets when released blobs are deleted: ReclaimImmediate,
+// ReclaimLazy or empty to leave them to garbage collection.
+func WithBlobReclaim(mode string) Option {
+	return func(h *Handler) {
+		h.reclaim = mode
+	}
+}
+
+// defaultDownloadLease is how long a download keeps its blob from being
+// deleted by default.
+const defaultDownloadLease = time.Hour
+
+// WithDownloadLease bounds how long a download keeps the blob it reads
+// from being deleted, so a stalled client cannot pin a released blob for
+// good. A download still running when its lease expires may be cut short.
+func WithDownloadLease(d time.Duration) Option {
+	return func(h *Handler) {
+		if d > 0 {
+			h.downloadLease = d
+		}
+	}
+}
+
+// blobLock guards a blob between its commit and the recording of the
+// reference to it, which would otherwise leave a window in which the blob
+// looks released, and while downloads read it.
+type blobLock struct {
+	mu   sync.RWMutex
+	refs int
+	// leases holds the expiry of each download reading the blob, and
+	// skipped is set when a delete gave way to one of them.
+	leases  map[*time.Time]bool
+	skipped bool
+}
+
+// leased reports whether a download's lease on the blob is still live.
+// Callers hold locksMu.
+func (l *blobLock) leased(now time.Time) bool {
+	for expires := range l.leases {
+		if now.Before(*expires) {
+			return true
+		}
+	}
+	return false
+}
+
+// holdBlob keeps hash from being deleted until the returned func is
+// called. Any number of uploads may hold the same blob.
+func (h *Handler) holdBlob(hash string) func() {
+	lock := h.blobLock(hash)
+	lock.mu.RLock()
+	return func() {
+		lock.mu.RUnlock()
+		h.releaseBlobLock(hash, lock)
+	}
+}
+
+// leaseBlob keeps hash from being deleted while a download reads it, until
+// the returned func is called or downloadLease passes. Unlike holdBlob it
+// only waits out a delete already under way, after which the download
+// finds the blob gone, and never holds deleters up for longer than the
+// lease. With ReclaimImmediate, a delete skipped for the lease is retried
+// when the last download ends.
+func (h *Handler) leaseBlob(hash string) func() {
+	lock := h.blobLock(hash)
+	lock.mu.RLock()
+	expires := h.clock.Now().Add(h.downloadLease)
+	h.locksMu.Lock()
+	if lock.leases == nil {
+		lock.leases = make(map[*time.Time]bool)
+	}
+	lock.leases[&expires] = true
+	h.locksMu.Unlock()
+	lock.mu.RUnlock()
+
+	return func() {
+		h.locksMu.Lock()
+		delete(lock.leases, &expires)
+		retry := lock.skipped && len(lock.leases) == 0
+		if retry {
+			lock.skipped = false
+		}
+		h.locksMu.Unlock()
+		h.releaseBlobLock(hash, lock)
+
+		if retry && h.reclaim == ReclaimImmediate {
+			if _, err := h.deleteUnreferencedBlob(hash); err != nil {
+				h.logger.Error().Err(err).Str("hash", hash).Msg("reclaiming blob after download")
+			}
+		}
+	}
+}
+
+// tryLockBlob claims hash for deletion, returning false without waiting
+// if an upload holds it or a download has a live lease on it. Deleters
+// never block, so they may be called with version locks held.
+func (h *Handler) tryLockBlob(hash string) (func(), bool) {
+	lock := h.blobLock(hash)
+	if !lock.mu.TryLock() {
+		h.releaseBlobLock(hash, lock)
+		return nil, false
+	}
+	h.locksMu.Lock()
+	leased := lock.leased(h.clock.Now())
+	lock.skipped = lock.skipped || leased
+	h.locksMu.Unlock()
+	if leased {
+		lock.mu.Unlock()
+		h.releaseBlobLock(hash, lock)
+		return nil, false
+	}
+	return func() {
+		lock.mu.Unlock()
+		h.releaseBlobLock(hash, lock)
+	}, true
+}
+
+func (h *Handler) blobLock(hash string) *blobLock {
+	h.locksMu.Lock()
+	defer h.locksMu.Unlock()
+	lock, ok := h.blobLocks[hash]
+	if !ok {
+		lock = &blobLock{}
+		h.blobLocks[hash] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+func (h *Handler) releaseBlobLock(hash string, lock *blobLock) {
+	h.locksMu.Lock()
+	defer h.locksMu.Unlock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(h.blobLocks, hash)
+	}
+}
+
+// storeBlob stores the data read from r like BlobStorage.Store, in the
+// storage of package pkg, scanning it for malware if enabled, and holds
+// the blob until the returned release func is called. Callers release it
+// once the metadata referencing the blob is recorded.
+func (h *Handler) storeBlob(ctx context.Context, pkg string, r io.Reader) (string, int64, func(), error) {
+	staged, err := h.stage(ctx, pkg, r)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	release := h.holdBlob(staged.Hash())
+	if err := staged.Commit(); err != nil {
+		staged.Discard()
+		release()
+		return "", 0, nil, err
+	}
+	h.markBlobHot(staged.Hash())
+	return staged.Hash(), staged.Size(), release, nil
+}
+
+// deleteUnreferencedBlob deletes hash unless something references it, an
+// upload holds it or a download leases it, reporting whether it did.
+func (h *Handler) deleteUnreferencedBlob(hash string) (bool, error) {
+	unlock, ok := h.tryLockBlob(hash)
+	if !ok {
+		return false, nil
+	}
+	defer unlock()
+
+	if ok, err := h.meta.ForgetBlob(hash); err != nil || !ok {
+		return false, err
+	}
+	if err := h.blobs.Delete(hash); err != nil && h.blobs.Exists(hash) {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReclaimBlobs deletes the blobs released since the last reclaim and
+// returns how many it removed and the bytes freed. It stops early once ctx
+// is done.
+func (h *Handler) ReclaimBlobs(ctx context.Context) (int, int64, error) {
+	var (
+		deleted int
+		freed   int64
+		after   string
+	)
+	for ctx.Err() == nil {
+		// Blobs held by an upload or download stay released, so page past
+		// them.
+		released, err := h.meta.ReleasedBlobs(after, reclaimBatch)
+		if err != nil {
+			return deleted, freed, err
+		}
+		if len(released) == 0 {
+			break
+		}
+		for _, hash := range released {
+			after = hash
+			size, _ := h.blobs.Size(hash)
+			ok, err := h.deleteUnreferencedBlob(hash)
+			if err != nil {
+				return deleted, freed, err
+			}
+			if ok {
+				deleted++
+				freed += size
+				h.logger.Info().Str("hash", hash).Msg("reclaimed released blob")
+			}
+		}
+	}
+	return deleted, freed, nil
+}
+
+// reclaimReleased deletes released blobs right away if the handler
+// reclaims immediately. Failures are logged; the blobs stay released for
+// the next attempt.
+func (h *Handler) reclaimReleased() {
+	if h.reclaim != ReclaimImmediate {
+		return
+	}
+	if _, _, err := h.ReclaimBlobs(context.Background()); err != nil {
+		h.logger.Error().Err(err).Msg("reclaiming released blobs")
+	}
+}
+
+// RunReclaim calls ReclaimBlobs every interval until ctx is canceled.
+func (h *Handler) RunReclaim(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, _, err := h.ReclaimBlobs(ctx); err != nil {
+				h.logger.Error().Err(err).Msg("reclaiming released blobs")
+			}
+		}
+	}
+}
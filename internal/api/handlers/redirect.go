@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/adapters/cdn"
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+const defaultRedirectTTL = 5 * time.Minute
+
+// wantsRedirect reports whether the download should be redirected, honoring
+// an explicit ?redirect=true|false over the configured default.
+func (h *Handler) wantsRedirect(r *http.Request) bool {
+	if v := r.URL.Query().Get("redirect"); v != "" {
+		want, err := strconv.ParseBool(v)
+		return err == nil && want
+	}
+	return h.redirect.byDefault
+}
+
+// WithCDN redirects downloads to signed URLs on a CDN in front of the
+// registry instead of the blob storage's own, and serves the CDN the blobs
+// behind those URLs at /cdn/blobs/{hash} without a token. Uploads and
+// everything else still go to the registry.
+func WithCDN(s *cdn.Signer) Option {
+	return func(h *Handler) {
+		h.cdn = s
+	}
+}
+
+// redirectToSignedURL answers with a 307 to a short-lived signed URL for the
+// artifact's blob, on the CDN when there is one. It returns false, leaving
+// the caller to proxy the bytes, when there is no CDN and the storage
+// backend cannot sign URLs, or signing fails.
+func (h *Handler) redirectToSignedURL(w http.ResponseWriter, r *http.Request, artifact *models.Artifact) bool {
+	var signer services.URLSigner
+	if h.cdn != nil {
+		signer = h.cdn
+	} else if s, ok := h.blobs.(services.URLSigner); ok {
+		signer = s
+	} else {
+		return false
+	}
+
+	url, err := signer.SignedURL(artifact.Hash, h.redirect.ttl)
+	if errors.Is(err, errors.ErrUnsupported) {
+		// The backend holding this blob cannot sign URLs.
+		return false
+	}
+	if err != nil {
+		h.logger.Warn().
+			Err(err).
+			Str("request_id", logging.RequestID(r.Context())).
+			Str("hash", artifact.Hash).
+			Msg("signing download URL, falling back to proxying")
+		return false
+	}
+
+	w.Header().Set("X-Artifact-Hash", artifact.Hash)
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	return true
+}
+
+// CDNBlob handles GET /cdn/blobs/{hash}, the CDN fetching a blob it was
+// sent to by a signed download redirect. The URL's signature stands in for
+// a token. The CDN may keep the blob until the URL expires, and no longer,
+// so it stops serving a blob once the registry would stop signing for it.
+func (h *Handler) CDNBlob(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+	if !h.cdn.Verify(cdn.BlobPath(hash), r.URL.Query()) {
+		writeError(w, http.StatusForbidden, "invalid or expired signature")
+		return
+	}
+
+	w, release, ok := h.limitDownload(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+	defer h.leaseBlob(hash)()
+
+	size, err := h.blobs.Size(hash)
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "blob not found")
+			return
+		}
+		h.logger.Error().Err(err).Str("hash", hash).Msg("checking blob size")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	reader, err := h.blobs.Open(hash)
+	if err != nil {
+		h.logger.Error().Err(err).Str("hash", hash).Msg("opening blob")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+	w.Header().Set("ETag", artifactETag(hash))
+	// Verify has checked expires parses and has not passed.
+	expires, _ := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	maxAge := max(expires-h.clock.Now().Unix(), 0)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, reader); err != nil {
+		h.logger.Error().
+			Err(err).
+			Str("request_id", logging.RequestID(r.Context())).
+			Str("hash", hash).
+			Msg("streaming blob to CDN")
+	}
+}
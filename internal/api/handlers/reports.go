@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// defaultReportDays is how many days a download report covers when it is
+// not given a start.
+const defaultReportDays = 30
+
+// reportDimensions lists the dimensions a download report can group by.
+var reportDimensions = []string{models.ReportByPackage, models.ReportByVersion, models.ReportByPrincipal, models.ReportByDay}
+
+// recordDownload adds a download of artifact by the caller of r to the
+// download statistics. Failures are logged; the file has been served.
+func (h *Handler) recordDownload(r *http.Request, artifact *models.Artifact, downloads, bytes int64) {
+	principal := ""
+	if p := principalFrom(r.Context()); p != nil {
+		principal = p.Name
+	}
+	if err := h.meta.RecordDownload(artifact.Package, artifact.Version, principal, downloads, bytes); err != nil {
+		h.logger.Warn().Err(err).
+			Str("package", artifact.Package).
+			Str("version", artifact.Version).
+			Msg("recording download")
+	}
+}
+
+// DownloadReport handles GET /api/v1/admin/reports/downloads, totalling
+// downloads and bytes served on the days ?from= to ?to=, inclusive and
+// given as YYYY-MM-DD in UTC. They default to the last 30 days up to
+// today. ?group_by= is a comma-separated list of package (the default),
+// version, principal and day. ?format=csv answers with a CSV file instead
+// of JSON.
+func (h *Handler) DownloadReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	to := h.clock.Now().Truncate(24 * time.Hour)
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "to must be a date as YYYY-MM-DD")
+			return
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, 1-defaultReportDays)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "from must be a date as YYYY-MM-DD")
+			return
+		}
+		from = t
+	}
+	if from.After(to) {
+		writeError(w, http.StatusBadRequest, "from must not be after to")
+		return
+	}
+
+	groupBy := []string{models.ReportByPackage}
+	if v := q.Get("group_by"); v != "" {
+		groupBy = nil
+		for _, dim := range strings.Split(v, ",") {
+			dim = strings.TrimSpace(dim)
+			if !slices.Contains(reportDimensions, dim) {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown group_by %q: expected %s", dim, strings.Join(reportDimensions, ", ")))
+				return
+			}
+			if !slices.Contains(groupBy, dim) {
+				groupBy = append(groupBy, dim)
+			}
+		}
+	}
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+
+	report := models.DownloadReport{
+		From:    from.Format(time.DateOnly),
+		To:      to.Format(time.DateOnly),
+		GroupBy: groupBy,
+	}
+	rows, err := h.meta.DownloadStats(report.From, report.To, groupBy)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("querying download stats")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	report.Rows = rows
+	if report.Rows == nil {
+		report.Rows = []models.DownloadStat{}
+	}
+	for _, row := range rows {
+		report.TotalDownloads += row.Downloads
+		report.TotalBytes += row.Bytes
+	}
+
+	if format == "csv" {
+		writeReportCSV(w, report)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// writeReportCSV writes a download report as CSV: a header row naming the
+// report's dimensions, then downloads and bytes, and a row per group.
+func writeReportCSV(w http.ResponseWriter, report models.DownloadReport) {
+	// Grouping by version names the package too, as in the JSON rows.
+	var cols []string
+	for _, dim := range reportDimensions {
+		if slices.Contains(report.GroupBy, dim) || dim == models.ReportByPackage && slices.Contains(report.GroupBy, models.ReportByVersion) {
+			cols = append(cols, dim)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", contentDisposition(fmt.Sprintf("downloads-%s-%s.csv", report.From, report.To)))
+	w.WriteHeader(http.StatusOK)
+	cw := csv.NewWriter(w)
+	cw.Write(append(slices.Clone(cols), "downloads", "bytes"))
+	for _, row := range report.Rows {
+		record := make([]string, 0, len(cols)+2)
+		for _, col := range cols {
+			switch col {
+			case models.ReportByPackage:
+				record = append(record, row.Package)
+			case models.ReportByVersion:
+				record = append(record, row.Version)
+			case models.ReportByPrincipal:
+				record = append(record, row.Principal)
+			case models.ReportByDay:
+				record = append(record, row.Day)
+			}
+		}
+		cw.Write(append(record, strconv.FormatInt(row.Downloads, 10), strconv.FormatInt(row.Bytes, 10)))
+	}
+	cw.Flush()
+}
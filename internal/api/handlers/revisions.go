@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// Every version carries a revision that advances whenever its metadata
+// changes. Requests that change a version may send the revision they last
+// read as If-Match: "3"; a version that has moved on since is refused with
+// 412, so two clients cannot silently overwrite each other's changes.
+
+// WithRequireIfMatch makes mutating version requests without an If-Match
+// header fail with 428. By default the header is optional.
+func WithRequireIfMatch(required bool) Option {
+	return func(h *Handler) {
+		h.requireIfMatch = required
+	}
+}
+
+// revisionTag returns the entity tag clients send in If-Match to target
+// the given revision of a version.
+func revisionTag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// lookupForUpdate loads the version named in the URL for a change, holding
+// the version's lock so the revision cannot move between the If-Match check
+// and the write. Versions served from a read-only snapshot are refused
+// with 409. The caller must call unlock once it has written.
+func (h *Handler) lookupForUpdate(w http.ResponseWriter, r *http.Request) (*models.Artifact, func(), bool) {
+	unlock := h.lockArtifactUpload(chi.URLParam(r, "package"), chi.URLParam(r, "version"))
+	artifact, ok := h.lookupArtifact(w, r)
+	if !ok || !h.checkIfMatch(w, r, artifact) {
+		unlock()
+		return nil, nil, false
+	}
+	if artifact.Snapshot != "" {
+		unlock()
+		writeError(w, http.StatusConflict, fmt.Sprintf("artifact %s@%s is in read-only snapshot %s", artifact.Package, artifact.Version, artifact.Snapshot))
+		return nil, nil, false
+	}
+	return artifact, unlock, true
+}
+
+// checkIfMatch compares the If-Match header of r with the revision of
+// artifact, which is nil if the version does not exist. It writes a 412 on
+// mismatch, or a 428 if the header is required and missing.
+func (h *Handler) checkIfMatch(w http.ResponseWriter, r *http.Request, artifact *models.Artifact) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if h.requireIfMatch && artifact != nil {
+			writeError(w, http.StatusPreconditionRequired, "If-Match with the version's revision is required")
+			return false
+		}
+		return true
+	}
+	if artifact != nil {
+		tag := revisionTag(artifact.Revision)
+		for _, candidate := range strings.Split(header, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || candidate == tag {
+				return true
+			}
+		}
+		w.Header().Set("X-Artifact-Revision", strconv.FormatInt(artifact.Revision, 10))
+		writeError(w, http.StatusPreconditionFailed, fmt.Sprintf("artifact %s@%s is at revision %d", artifact.Package, artifact.Version, artifact.Revision))
+		return false
+	}
+	writeError(w, http.StatusPreconditionFailed, fmt.Sprintf("artifact %s@%s not found", chi.URLParam(r, "package"), chi.URLParam(r, "version")))
+	return false
+}
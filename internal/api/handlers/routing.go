@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// blobsFor returns the storage new blobs of package pkg are written to:
+// the backend routed to it when blob storage routes packages, or the one
+// store otherwise.
+func (h *Handler) blobsFor(pkg string) services.BlobStorage {
+	if routed, ok := h.blobs.(services.RoutedStorage); ok {
+		return routed.Route(pkg)
+	}
+	return h.blobs
+}
+
+// placeBlob makes sure a stored blob a version of package pkg is about to
+// reference is in the storage routed to pkg, copying it there if another
+// package's upload left it elsewhere.
+func (h *Handler) placeBlob(pkg, hash string) error {
+	if routed, ok := h.blobs.(services.RoutedStorage); ok {
+		return routed.Place(pkg, hash)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// maxSBOMSize bounds SBOM uploads, which are parsed in memory to index
+// their components.
+const maxSBOMSize = 64 << 20
+
+const (
+	sbomFormatCycloneDX = "cyclonedx"
+	sbomFormatSPDX      = "spdx"
+)
+
+// sbomDocument holds the fields of CycloneDX and SPDX JSON documents that
+// identify the format and list components.
+type sbomDocument struct {
+	// CycloneDX
+	BOMFormat   string         `json:"bomFormat"`
+	SpecVersion string         `json:"specVersion"`
+	Components  []cdxComponent `json:"components"`
+	// SPDX
+	SPDXVersion string        `json:"spdxVersion"`
+	Packages    []spdxPackage `json:"packages"`
+}
+
+type cdxComponent struct {
+	Name       string         `json:"name"`
+	Version    string         `json:"version"`
+	PURL       string         `json:"purl"`
+	Components []cdxComponent `json:"components"`
+}
+
+type spdxPackage struct {
+	Name         string `json:"name"`
+	VersionInfo  string `json:"versionInfo"`
+	ExternalRefs []struct {
+		ReferenceType    string `json:"referenceType"`
+		ReferenceLocator string `json:"referenceLocator"`
+	} `json:"externalRefs"`
+}
+
+// parseSBOM identifies a CycloneDX or SPDX JSON document and returns its
+// format, spec version and components.
+func parseSBOM(data []byte) (format, specVersion string, components []models.SBOMComponent, err error) {
+	var doc sbomDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", "", nil, fmt.Errorf("invalid SBOM JSON: %v", err)
+	}
+
+	switch {
+	case doc.BOMFormat == "CycloneDX":
+		components = []models.SBOMComponent{}
+		var walk func([]cdxComponent)
+		walk = func(cs []cdxComponent) {
+			for _, c := range cs {
+				if c.Name != "" {
+					components = append(components, models.SBOMComponent{Name: c.Name, Version: c.Version, PURL: c.PURL})
+				}
+				walk(c.Components)
+			}
+		}
+		walk(doc.Components)
+		return sbomFormatCycloneDX, doc.SpecVersion, components, nil
+
+	case strings.HasPrefix(doc.SPDXVersion, "SPDX-"):
+		components = make([]models.SBOMComponent, 0, len(doc.Packages))
+		for _, p := range doc.Packages {
+			if p.Name == "" {
+				continue
+			}
+			c := models.SBOMComponent{Name: p.Name, Version: p.VersionInfo}
+			for _, ref := range p.ExternalRefs {
+				if ref.ReferenceType == "purl" {
+					c.PURL = ref.ReferenceLocator
+					break
+				}
+			}
+			components = append(components, c)
+		}
+		return sbomFormatSPDX, strings.TrimPrefix(doc.SPDXVersion, "SPDX-"), components, nil
+	}
+	return "", "", nil, errors.New("unsupported SBOM: expected CycloneDX or SPDX JSON")
+}
+
+// sbomContentType returns the media type of an SBOM format.
+func sbomContentType(format string) string {
+	if format == sbomFormatSPDX {
+		return "application/spdx+json"
+	}
+	return "application/vnd.cyclonedx+json"
+}
+
+// SetSBOM handles PUT /api/v1/artifacts/{package}/{version}/sbom, attaching
+// or replacing the version's SBOM.
+func (h *Handler) SetSBOM(w http.ResponseWriter, r *http.Request) {
+	artifact, unlock, ok := h.lookupForUpdate(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionUpload, Package: artifact.Package, Version: artifact.Version}) {
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxSBOMSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "reading SBOM")
+		return
+	}
+	if len(data) > maxSBOMSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("SBOM exceeds %d bytes", maxSBOMSize))
+		return
+	}
+	format, specVersion, components, err := parseSBOM(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hash, size, releaseBlob, err := h.storeBlob(r.Context(), artifact.Package, bytes.NewReader(data))
+	if err != nil {
+		status, msg := h.storeFailure(err)
+		writeError(w, status, msg)
+		return
+	}
+	defer releaseBlob()
+
+	sbom, err := h.meta.SetSBOM(artifact.Package, artifact.Version, models.SBOM{
+		Hash:        hash,
+		Size:        size,
+		Format:      format,
+		SpecVersion: specVersion,
+	}, components)
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", artifact.Package, artifact.Version))
+			return
+		}
+		h.logger.Error().Err(err).Msg("recording SBOM")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	// A replaced document releases its old blob.
+	h.reclaimReleased()
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("package", artifact.Package).
+		Str("version", artifact.Version).
+		Str("hash", hash).
+		Str("format", format).
+		Int("components", len(components)).
+		Msg("SBOM attached")
+
+	writeJSON(w, http.StatusOK, sbom)
+}
+
+// GetSBOM handles GET /api/v1/artifacts/{package}/{version}/sbom, serving
+// the SBOM document as uploaded. ?metadata=true returns its summary instead.
+func (h *Handler) GetSBOM(w http.ResponseWriter, r *http.Request) {
+	artifact, ok := h.lookupArtifact(w, r)
+	if !ok {
+		return
+	}
+
+	sbom, err := h.meta.GetSBOM(artifact.Package, artifact.Version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting SBOM")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if sbom == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s has no SBOM", artifact.Package, artifact.Version))
+		return
+	}
+
+	if r.URL.Query().Get("metadata") == "true" {
+		writeJSON(w, http.StatusOK, sbom)
+		return
+	}
+
+	// Serve the SBOM blob through the regular download path.
+	doc := *artifact
+	doc.Hash = sbom.Hash
+	doc.Size = sbom.Size
+	doc.Filename = fmt.Sprintf("%s-%s.%s.json", artifact.Package, artifact.Version, sbom.Format)
+	doc.ContentType = sbomContentType(sbom.Format)
+	doc.UploadedAt = sbom.UploadedAt
+	doc.Vulnerabilities = nil
+	h.serveArtifact(w, r, &doc)
+}
+
+// filterPackagesByName keeps packages whose names contain query, matching
+// the store's substring search.
+func filterPackagesByName(pkgs []models.Package, query string) []models.Package {
+	var out []models.Package
+	for _, p := range pkgs {
+		if strings.Contains(strings.ToLower(p.Name), strings.ToLower(query)) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// maxScanReportSize bounds scan report uploads, which are parsed in memory
+// to count their findings.
+const maxScanReportSize = 64 << 20
+
+// severityOrder lists the severities a download block can be set at, most
+// severe first.
+var severityOrder = []string{"critical", "high", "medium", "low"}
+
+// WithSeverityBlock refuses downloads of versions whose scan report has
+// findings at or above severity. An empty severity disables blocking, and
+// versions without a scan report are never blocked.
+func WithSeverityBlock(severity string) Option {
+	return func(h *Handler) {
+		h.blockSeverity = severity
+	}
+}
+
+// WithScanners lets the tokens named in names attach scan reports, as
+// admins may. A report decides whether downloads are blocked, so no one
+// else may attach one, and only admins may attach a report that lifts or
+// lowers a block already in place.
+func WithScanners(names []string) Option {
+	return func(h *Handler) {
+		h.scanners = names
+	}
+}
+
+// scanDocument holds the fields of Trivy and Grype JSON reports that
+// identify the scanner and list findings.
+type scanDocument struct {
+	// Trivy
+	SchemaVersion int `json:"SchemaVersion"`
+	Results       []struct {
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			Severity         string `json:"Severity"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+	// Grype
+	Descriptor struct {
+		Name string `json:"name"`
+	} `json:"descriptor"`
+	Matches []struct {
+		Vulnerability struct {
+			ID       string `json:"id"`
+			Severity string `json:"severity"`
+		} `json:"vulnerability"`
+		Artifact struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"artifact"`
+	} `json:"matches"`
+}
+
+// finding is one vulnerability affecting one package version.
+type finding struct {
+	id, pkg, version, severity string
+}
+
+// parseScanReport identifies a Trivy or Grype JSON report and returns the
+// scanner name and its findings counted by severity. A vulnerability
+// reported more than once for the same package version counts once.
+func parseScanReport(data []byte) (string, models.VulnerabilitySummary, error) {
+	var doc scanDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", models.VulnerabilitySummary{}, fmt.Errorf("invalid scan report JSON: %v", err)
+	}
+
+	var scanner string
+	var findings []finding
+	switch {
+	case doc.Descriptor.Name == "grype":
+		scanner = "grype"
+		for _, m := range doc.Matches {
+			findings = append(findings, finding{m.Vulnerability.ID, m.Artifact.Name, m.Artifact.Version, m.Vulnerability.Severity})
+		}
+	case doc.SchemaVersion > 0:
+		scanner = "trivy"
+		for _, res := range doc.Results {
+			for _, v := range res.Vulnerabilities {
+				findings = append(findings, finding{v.VulnerabilityID, v.PkgName, v.InstalledVersion, v.Severity})
+			}
+		}
+	default:
+		return "", models.VulnerabilitySummary{}, errors.New("unsupported scan report: expected Trivy or Grype JSON")
+	}
+
+	var summary models.VulnerabilitySummary
+	seen := make(map[finding]bool, len(findings))
+	for _, f := range findings {
+		f.severity = strings.ToLower(f.severity)
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		switch f.severity {
+		case "critical":
+			summary.Critical++
+		case "high":
+			summary.High++
+		case "medium":
+			summary.Medium++
+		case "low", "negligible":
+			summary.Low++
+		default:
+			summary.Unknown++
+		}
+	}
+	return scanner, summary, nil
+}
+
+// findingsAtOrAbove counts the findings of severity or worse. Unknown
+// severities are never counted.
+func findingsAtOrAbove(v *models.VulnerabilitySummary, severity string) int {
+	counts := []int{v.Critical, v.High, v.Medium, v.Low}
+	n := 0
+	for i, s := range severityOrder {
+		n += counts[i]
+		if s == severity {
+			return n
+		}
+	}
+	return 0
+}
+
+// scanBlock returns why downloads of artifact are refused because its file
+// is infected with malware or under the severity block, or "" if they are
+// allowed.
+func (h *Handler) scanBlock(artifact *models.Artifact) string {
+	if reason := h.malwareBlock(artifact); reason != "" {
+		return reason
+	}
+	if h.blockSeverity == "" || artifact.Vulnerabilities == nil {
+		return ""
+	}
+	n := findingsAtOrAbove(artifact.Vulnerabilities, h.blockSeverity)
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("artifact %s@%s is blocked: its scan report has %d %s or more severe vulnerabilities",
+		artifact.Package, artifact.Version, n, h.blockSeverity)
+}
+
+// lowersBlock reports whether replacing the findings in old, which block
+// downloads, with those in summary would leave fewer findings at or above
+// the block severity.
+func (h *Handler) lowersBlock(old *models.VulnerabilitySummary, summary models.VulnerabilitySummary) bool {
+	if h.blockSeverity == "" || old == nil {
+		return false
+	}
+	before := findingsAtOrAbove(old, h.blockSeverity)
+	return before > 0 && findingsAtOrAbove(&summary, h.blockSeverity) < before
+}
+
+// SetScanReport handles PUT /api/v1/artifacts/{package}/{version}/scan,
+// attaching or replacing the version's vulnerability scan report. Only
+// admins and the configured scanners may, and only admins may lower a
+// block; see WithScanners.
+func (h *Handler) SetScanReport(w http.ResponseWriter, r *http.Request) {
+	artifact, unlock, ok := h.lookupForUpdate(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+	p := principalFrom(r.Context())
+	admin := p != nil && p.Admin
+	if !admin && (p == nil || !slices.Contains(h.scanners, p.Name)) {
+		writeError(w, http.StatusForbidden, "only admins and configured scanners may attach scan reports")
+		return
+	}
+	if !h.checkPolicy(w, r, models.PolicyRequest{Action: models.PolicyActionUpload, Package: artifact.Package, Version: artifact.Version}) {
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxScanReportSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "reading scan report")
+		return
+	}
+	if len(data) > maxScanReportSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("scan report exceeds %d bytes", maxScanReportSize))
+		return
+	}
+	scanner, summary, err := parseScanReport(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !admin && h.lowersBlock(artifact.Vulnerabilities, summary) {
+		h.logger.Warn().
+			Str("request_id", logging.RequestID(r.Context())).
+			Str("client_ip", logging.ClientIP(r.Context())).
+			Str("package", artifact.Package).
+			Str("version", artifact.Version).
+			Str("by", p.Name).
+			Msg("scan report refused: it would lower a download block")
+		writeError(w, http.StatusForbidden, fmt.Sprintf("artifact %s@%s is blocked by its scan report; only admins may attach a report with fewer %s or more severe findings",
+			artifact.Package, artifact.Version, h.blockSeverity))
+		return
+	}
+
+	hash, size, releaseBlob, err := h.storeBlob(r.Context(), artifact.Package, bytes.NewReader(data))
+	if err != nil {
+		status, msg := h.storeFailure(err)
+		writeError(w, status, msg)
+		return
+	}
+	defer releaseBlob()
+
+	report, err := h.meta.SetScanReport(artifact.Package, artifact.Version, models.ScanReport{
+		Hash:    hash,
+		Size:    size,
+		Scanner: scanner,
+		Summary: summary,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", artifact.Package, artifact.Version))
+			return
+		}
+		h.logger.Error().Err(err).Msg("recording scan report")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	// A replaced document releases its old blob.
+	h.reclaimReleased()
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("package", artifact.Package).
+		Str("version", artifact.Version).
+		Str("hash", hash).
+		Str("scanner", scanner).
+		Int("critical", summary.Critical).
+		Int("high", summary.High).
+		Msg("scan report attached")
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// GetScanReport handles GET /api/v1/artifacts/{package}/{version}/scan,
+// serving the report as uploaded. ?metadata=true returns its summary
+// instead. Reports stay available when the version itself is blocked.
+func (h *Handler) GetScanReport(w http.ResponseWriter, r *http.Request) {
+	artifact, ok := h.lookupArtifact(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := h.meta.GetScanReport(artifact.Package, artifact.Version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("getting scan report")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if report == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s has no scan report", artifact.Package, artifact.Version))
+		return
+	}
+
+	if r.URL.Query().Get("metadata") == "true" {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+
+	// Serve the report blob through the regular download path.
+	doc := *artifact
+	doc.Hash = report.Hash
+	doc.Size = report.Size
+	doc.Filename = fmt.Sprintf("%s-%s.%s.json", artifact.Package, artifact.Version, report.Scanner)
+	doc.ContentType = "application/json"
+	doc.UploadedAt = report.ScannedAt
+	doc.Vulnerabilities = nil
+	h.serveArtifact(w, r, &doc)
+}
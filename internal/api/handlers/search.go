@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+const (
+	defaultRegistryName      = "local"
+	defaultFederationTimeout = 3 * time.Second
+)
+
+// federation is the registries GET /api/v1/search covers besides this
+// one.
+type federation struct {
+	name    string
+	peers   []services.PeerRegistry
+	timeout time.Duration
+}
+
+// WithFederation makes GET /api/v1/search cover peers too, annotating each
+// package with the registries holding it. name is this registry's name in
+// the results. Peers that fail or have not answered within timeout are
+// left out and reported, and the search returns what the rest found.
+func WithFederation(name string, peers []services.PeerRegistry, timeout time.Duration) Option {
+	return func(h *Handler) {
+		h.federation = federation{name: name, peers: peers, timeout: timeout}
+	}
+}
+
+// Search handles GET /api/v1/search?q=, searching this registry and its
+// peers for packages whose names contain q, or listing every package
+// without q. Results are sorted by name.
+func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+
+	var local []models.Package
+	var err error
+	if query != "" {
+		local, err = h.meta.SearchPackages(query)
+	} else {
+		local, err = h.meta.ListPackages()
+	}
+	if err != nil {
+		h.logger.Error().Err(err).Msg("searching packages")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	fed := h.federation
+	type peerResult struct {
+		pkgs []models.Package
+		err  error
+	}
+	results := make([]peerResult, len(fed.peers))
+	ctx, cancel := context.WithTimeout(r.Context(), fed.timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i, peer := range fed.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pkgs, err := peer.SearchPackages(ctx, query)
+			results[i] = peerResult{pkgs: pkgs, err: err}
+		}()
+	}
+	wg.Wait()
+
+	resp := models.SearchResponse{Results: []models.SearchResult{}}
+	byName := make(map[string]int)
+	add := func(registry string, pkgs []models.Package) {
+		for _, p := range pkgs {
+			i, ok := byName[p.Name]
+			if !ok {
+				i = len(resp.Results)
+				byName[p.Name] = i
+				resp.Results = append(resp.Results, models.SearchResult{Name: p.Name})
+			}
+			if res := &resp.Results[i]; len(res.Registries) == 0 || res.Registries[len(res.Registries)-1] != registry {
+				res.Registries = append(res.Registries, registry)
+			}
+		}
+	}
+	add(fed.name, local)
+	for i, peer := range fed.peers {
+		res := results[i]
+		if res.err != nil {
+			if errors.Is(res.err, context.DeadlineExceeded) {
+				res.err = fmt.Errorf("no answer within %s", fed.timeout)
+			}
+			h.logger.Warn().Err(res.err).Str("registry", peer.Name()).Msg("searching peer registry")
+			resp.Partial = true
+			resp.Errors = append(resp.Errors, models.PeerError{Registry: peer.Name(), Error: res.err.Error()})
+			continue
+		}
+		add(peer.Name(), res.pkgs)
+	}
+	sort.Slice(resp.Results, func(i, j int) bool {
+		return resp.Results[i].Name < resp.Results[j].Name
+	})
+
+	if resp.Partial {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	h.writeListing(w, r, resp)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"io"
+	"math"
+)
+
+// sendSlice is how much of a response body is handed to the connection at
+// a time, so the bytes written keep up with a long download.
+const sendSlice = 4 << 20
+
+// readFromSlices copies src into dst with dst's ReadFrom, at most slice
+// bytes at a time, calling sent after each. A file reaches dst's ReadFrom
+// directly, even under the io.LimitedReader that io.CopyN wraps it in, so
+// the connection can send it with sendfile.
+func readFromSlices(dst io.ReaderFrom, src io.Reader, slice int64, sent func(n int64) error) (int64, error) {
+	remain := int64(math.MaxInt64)
+	if lr, ok := src.(*io.LimitedReader); ok {
+		src, remain = lr.R, lr.N
+		defer func() { lr.N = remain }()
+	}
+	var total int64
+	for remain > 0 {
+		want := min(remain, slice)
+		n, err := dst.ReadFrom(&io.LimitedReader{R: src, N: want})
+		total += n
+		remain -= n
+		if err == nil && sent != nil {
+			err = sent(n)
+		}
+		if err != nil || n < want {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// writerOnly hides a writer's ReadFrom, so io.Copy falls back to Write.
+type writerOnly struct {
+	io.Writer
+}
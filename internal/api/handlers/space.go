@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// spaceCheckInterval is how many bytes of an upload are read between
+// checks of the free space.
+const spaceCheckInterval = 4 << 20
+
+// errInsufficientStorage marks uploads refused because blob storage is
+// short of space.
+var errInsufficientStorage = errors.New("insufficient storage")
+
+// WithStorageReserve refuses uploads with 507 Insufficient Storage while
+// blob storage has fewer than reserve bytes free. Uploads are checked
+// before they are read, against their Content-Length when sent, and every
+// few megabytes while they are stored. Backends that cannot report their
+// free space are not checked.
+func WithStorageReserve(reserve int64) Option {
+	return func(h *Handler) {
+		h.storageReserve = reserve
+	}
+}
+
+// freeSpace returns the bytes free in blob storage, or false if the
+// backend cannot tell.
+func (h *Handler) freeSpace() (int64, bool) {
+	sr, ok := h.blobs.(services.SpaceReporter)
+	if !ok {
+		return 0, false
+	}
+	free, err := sr.FreeSpace()
+	if err != nil {
+		if !errors.Is(err, errors.ErrUnsupported) {
+			h.logger.Warn().Err(err).Msg("checking free space")
+		}
+		return 0, false
+	}
+	return free, true
+}
+
+// checkSpace returns an error wrapping errInsufficientStorage if storing
+// another size bytes would leave less than the reserve free.
+func (h *Handler) checkSpace(size int64) error {
+	if h.storageReserve <= 0 {
+		return nil
+	}
+	free, ok := h.freeSpace()
+	if ok && free-size < h.storageReserve {
+		return fmt.Errorf("%w: %d bytes free, %d reserved", errInsufficientStorage, free, h.storageReserve)
+	}
+	return nil
+}
+
+// checkUploadSpace answers 507 and returns false if the upload in r would
+// not fit above the reserve.
+func (h *Handler) checkUploadSpace(w http.ResponseWriter, r *http.Request) bool {
+	if err := h.checkSpace(max(r.ContentLength, 0)); err != nil {
+		status, msg := h.storeFailure(err)
+		writeError(w, status, msg)
+		return false
+	}
+	return true
+}
+
+// guardSpace checks there is room above the reserve and returns r wrapped
+// to keep checking as it is read.
+func (h *Handler) guardSpace(r io.Reader) (io.Reader, error) {
+	if h.storageReserve <= 0 {
+		return r, nil
+	}
+	if err := h.checkSpace(0); err != nil {
+		return nil, err
+	}
+	return &spaceGuard{r: r, h: h}, nil
+}
+
+// spaceGuard fails reads once blob storage runs short of space partway
+// through an upload, so the upload stops before it fills the disk.
+type spaceGuard struct {
+	r         io.Reader
+	h         *Handler
+	unchecked int64
+}
+
+func (g *spaceGuard) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+	g.unchecked += int64(n)
+	if g.unchecked >= spaceCheckInterval {
+		g.unchecked = 0
+		if err := g.h.checkSpace(0); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// channelsHelp lists the stability levels for error messages.
+var channelsHelp = strings.Join(models.Stabilities, ", ")
+
+// uploadStability returns the stability declared by the X-Artifact-Stability
+// header of r, or "" to take it from the version, writing a 400 if the
+// header is not a stability level.
+func uploadStability(w http.ResponseWriter, r *http.Request) (string, bool) {
+	stability := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Artifact-Stability")))
+	if stability != "" && !slices.Contains(models.Stabilities, stability) {
+		writeError(w, http.StatusBadRequest, "X-Artifact-Stability must be one of "+channelsHelp)
+		return "", false
+	}
+	return stability, true
+}
+
+// parseChannel reads ?channel= from r: a stability level, offering the
+// versions at that level or more stable. It returns "" without one.
+func parseChannel(r *http.Request) (string, error) {
+	channel := strings.ToLower(r.URL.Query().Get("channel"))
+	if channel != "" && models.ChannelStabilities(channel) == nil {
+		return "", fmt.Errorf("channel must be one of %s", channelsHelp)
+	}
+	return channel, nil
+}
+
+// inChannel keeps the artifacts a channel offers; every artifact for "".
+func inChannel(artifacts []models.Artifact, channel string) []models.Artifact {
+	if channel == "" {
+		return artifacts
+	}
+	levels := models.ChannelStabilities(channel)
+	out := artifacts[:0:0]
+	for _, a := range artifacts {
+		if slices.Contains(levels, a.Stability) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// channelLatest picks the latest of a package's released versions in
+// channel. Without a channel it is the latest stable version, or the
+// latest of any stability if none is stable, so nightly and pre-release
+// builds do not displace the stable release. artifacts must be in upload
+// order, newest first.
+func channelLatest(artifacts []models.Artifact, channel string) string {
+	if channel != "" {
+		return latestVersion(inChannel(artifacts, channel))
+	}
+	if latest := latestVersion(inChannel(artifacts, models.StabilityStable)); latest != "" {
+		return latest
+	}
+	return latestVersion(artifacts)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// WithDefaultStage sets the stage new versions start in. Uploads to the
+// artifact routes may pick another with the X-Artifact-Stage header; PyPI,
+// Maven and Cargo publishes always use the default. Empty means release.
+func WithDefaultStage(stage string) Option {
+	return func(h *Handler) {
+		h.defaultStage = stage
+	}
+}
+
+func validStage(stage string) bool {
+	return stage == models.StageStaging || stage == models.StageRelease
+}
+
+// uploadStage returns the stage requested by the X-Artifact-Stage header of
+// r, or the default stage, writing a 400 if the header is not a stage.
+// When new versions start in staging, promotion is the way into release,
+// so only admins may skip it; anyone else asking for release gets a 403.
+func (h *Handler) uploadStage(w http.ResponseWriter, r *http.Request) (string, bool) {
+	stage := r.Header.Get("X-Artifact-Stage")
+	if stage == "" {
+		return h.defaultStage, true
+	}
+	if !validStage(stage) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("X-Artifact-Stage must be %s or %s", models.StageStaging, models.StageRelease))
+		return "", false
+	}
+	if stage == models.StageRelease && h.defaultStage == models.StageStaging {
+		p := principalFrom(r.Context())
+		if p == nil || !p.Admin {
+			writeError(w, http.StatusForbidden, "new versions start in staging; only admins may upload straight to release, others promote")
+			return "", false
+		}
+		h.logger.Info().
+			Str("request_id", logging.RequestID(r.Context())).
+			Str("client_ip", logging.ClientIP(r.Context())).
+			Str("path", r.URL.Path).
+			Str("by", p.Name).
+			Msg("upload skipped staging")
+	}
+	return stage, true
+}
+
+// released drops the artifacts that have not reached the release stage.
+func released(artifacts []models.Artifact) []models.Artifact {
+	out := artifacts[:0:0]
+	for _, a := range artifacts {
+		if a.Stage == models.StageRelease {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// filterStage keeps the artifacts in stage, or all of them for "all". It
+// returns false for any other stage.
+func filterStage(artifacts []models.Artifact, stage string) ([]models.Artifact, bool) {
+	switch stage {
+	case models.StageRelease:
+		return released(artifacts), true
+	case "all":
+		return artifacts, true
+	case models.StageStaging:
+		staged := artifacts[:0:0]
+		for _, a := range artifacts {
+			if a.Stage == models.StageStaging {
+				staged = append(staged, a)
+			}
+		}
+		return staged, true
+	}
+	return nil, false
+}
+
+// PromoteArtifact handles POST /api/v1/artifacts/{package}/{version}/promote.
+// The body may name the target stage as {"stage": "staging"}; without one
+// the version is promoted to release. The blob is untouched, and the caller
+// and time are recorded on the version.
+func (h *Handler) PromoteArtifact(w http.ResponseWriter, r *http.Request) {
+	artifact, unlock, ok := h.lookupForUpdate(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	var req models.PromoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Stage == "" {
+		req.Stage = models.StageRelease
+	}
+	if !validStage(req.Stage) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("stage must be %s or %s", models.StageStaging, models.StageRelease))
+		return
+	}
+	if artifact.Stage == req.Stage {
+		writeError(w, http.StatusConflict, fmt.Sprintf("artifact %s@%s is already in %s", artifact.Package, artifact.Version, req.Stage))
+		return
+	}
+	if !h.checkPolicy(w, r, models.PolicyRequest{
+		Action: models.PolicyActionPromote, Package: artifact.Package, Version: artifact.Version, Stage: req.Stage,
+	}) {
+		return
+	}
+
+	promoter := ""
+	if p := principalFrom(r.Context()); p != nil {
+		promoter = p.Name
+	}
+	if err := h.meta.SetStage(artifact.Package, artifact.Version, req.Stage, promoter); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("artifact %s@%s not found", artifact.Package, artifact.Version))
+			return
+		}
+		h.logger.Error().Err(err).Msg("promoting artifact")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	promoted, err := h.meta.GetArtifact(artifact.Package, artifact.Version)
+	if err != nil || promoted == nil {
+		h.logger.Error().Err(err).Msg("getting promoted artifact")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("client_ip", logging.ClientIP(r.Context())).
+		Str("package", promoted.Package).
+		Str("version", promoted.Version).
+		Str("from", artifact.Stage).
+		Str("to", promoted.Stage).
+		Str("promoted_by", promoter).
+		Msg("artifact promoted")
+	h.notify(r, models.Notification{
+		Event: models.NotifyPromote, Package: promoted.Package, Version: promoted.Version, Stage: promoted.Stage,
+	})
+
+	writeJSON(w, http.StatusOK, promoted)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// TieringPolicy decides when blobs move between storage tiers.
+type TieringPolicy struct {
+	// IdleAfter is how long a blob goes unread, or unread since its
+	// upload, before it moves to cold storage.
+	IdleAfter time.Duration
+	// Restore moves a cold blob back to hot storage when it is downloaded.
+	// Otherwise downloads read it from cold storage in place.
+	Restore bool
+}
+
+// WithTiering moves idle blobs from the hot tier of tiers to its cold
+// tier as p describes. Downloads then record when each blob was last read.
+func WithTiering(tiers services.TieredStorage, p TieringPolicy) Option {
+	return func(h *Handler) {
+		h.tiers = tiers
+		h.tiering = p
+	}
+}
+
+// OffloadIdleBlobs moves the blobs idle at now to cold storage and
+// returns how many it moved and their total size. Blobs being uploaded are
+// skipped until the next run.
+func (h *Handler) OffloadIdleBlobs(now time.Time) (int, int64, error) {
+	if h.tiers == nil {
+		return 0, 0, nil
+	}
+	var (
+		moved int
+		bytes int64
+		after string
+	)
+	for {
+		idle, err := h.meta.ListIdleBlobs(now.Add(-h.tiering.IdleAfter), after, reclaimBatch)
+		if err != nil {
+			return moved, bytes, err
+		}
+		if len(idle) == 0 {
+			return moved, bytes, nil
+		}
+		for _, hash := range idle {
+			after = hash
+			size, ok, err := h.offloadBlob(hash)
+			if err != nil {
+				return moved, bytes, err
+			}
+			if ok {
+				moved++
+				bytes += size
+			}
+		}
+	}
+}
+
+// offloadBlob moves one blob to cold storage unless an upload or delete
+// holds it, reporting whether it did.
+func (h *Handler) offloadBlob(hash string) (int64, bool, error) {
+	unlock, ok := h.tryLockBlob(hash)
+	if !ok {
+		return 0, false, nil
+	}
+	defer unlock()
+
+	size, _ := h.blobs.Size(hash)
+	if err := h.tiers.Offload(hash); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			// Missing blobs are garbage collection's to report.
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if err := h.meta.SetBlobTier(hash, models.TierCold); err != nil {
+		return 0, false, err
+	}
+	h.logger.Info().Str("hash", hash).Int64("size", size).Msg("moved blob to cold storage")
+	return size, true, nil
+}
+
+// RunTiering calls OffloadIdleBlobs every interval until ctx is canceled.
+func (h *Handler) RunTiering(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, _, err := h.OffloadIdleBlobs(h.clock.Now()); err != nil {
+				h.logger.Error().Err(err).Msg("moving idle blobs to cold storage")
+			}
+		}
+	}
+}
+
+// noteBlobRead records a download of hash for tiering and, if the policy
+// restores on access, brings a cold blob back to hot storage first. A
+// failed restore is logged and the download reads the cold copy.
+func (h *Handler) noteBlobRead(r *http.Request, hash string) {
+	if h.tiers == nil {
+		return
+	}
+	if err := h.meta.TouchBlob(hash); err != nil {
+		h.logger.Warn().Err(err).Str("hash", hash).Msg("recording blob access")
+	}
+	if !h.tiering.Restore {
+		return
+	}
+	if tier, err := h.tiers.Tier(hash); err != nil || tier != models.TierCold {
+		return
+	}
+
+	release := h.holdBlob(hash)
+	defer release()
+	if err := h.tiers.Restore(hash); err != nil {
+		h.logger.Warn().Err(err).
+			Str("request_id", logging.RequestID(r.Context())).
+			Str("hash", hash).
+			Msg("restoring blob from cold storage, reading it in place")
+		return
+	}
+	h.markBlobHot(hash)
+	h.logger.Info().
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("hash", hash).
+		Msg("restored blob from cold storage")
+}
+
+// markBlobHot records that hash is back in the hot tier, as it is after
+// an upload of the same content.
+func (h *Handler) markBlobHot(hash string) {
+	if h.tiers == nil {
+		return
+	}
+	if err := h.meta.SetBlobTier(hash, models.TierHot); err != nil {
+		h.logger.Warn().Err(err).Str("hash", hash).Msg("recording blob tier")
+	}
+}
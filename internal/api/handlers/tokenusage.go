@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+)
+
+// tokenUsage totals the use of issued tokens in memory until it is
+// flushed to the token store, so serving a request does not write to it.
+type tokenUsage struct {
+	store services.TokenUsageStore
+
+	mu      sync.Mutex
+	pending map[int64]*models.TokenUsage
+}
+
+func newTokenUsage(store services.TokenUsageStore) *tokenUsage {
+	return &tokenUsage{store: store, pending: make(map[int64]*models.TokenUsage)}
+}
+
+// add counts a request made with token id at at.
+func (u *tokenUsage) add(id, uploaded, downloaded int64, at time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.merge(models.TokenUsage{TokenID: id, Requests: 1, BytesUploaded: uploaded, BytesDownloaded: downloaded, LastUsedAt: &at})
+}
+
+// merge adds usage to the pending totals. The caller holds mu.
+func (u *tokenUsage) merge(usage models.TokenUsage) {
+	p, ok := u.pending[usage.TokenID]
+	if !ok {
+		p = &models.TokenUsage{TokenID: usage.TokenID}
+		u.pending[usage.TokenID] = p
+	}
+	p.Requests += usage.Requests
+	p.BytesUploaded += usage.BytesUploaded
+	p.BytesDownloaded += usage.BytesDownloaded
+	if usage.LastUsedAt != nil && (p.LastUsedAt == nil || usage.LastUsedAt.After(*p.LastUsedAt)) {
+		p.LastUsedAt = usage.LastUsedAt
+	}
+}
+
+// flush writes the pending totals to the store. If that fails they are
+// kept for the next flush.
+func (u *tokenUsage) flush() error {
+	u.mu.Lock()
+	usage := make([]models.TokenUsage, 0, len(u.pending))
+	for _, p := range u.pending {
+		usage = append(usage, *p)
+	}
+	clear(u.pending)
+	u.mu.Unlock()
+	if len(usage) == 0 {
+		return nil
+	}
+
+	if err := u.store.AddTokenUsage(usage); err != nil {
+		u.mu.Lock()
+		for _, p := range usage {
+			u.merge(p)
+		}
+		u.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// get returns the stored totals of token id with those not yet flushed.
+func (u *tokenUsage) get(id int64) (*models.TokenUsage, error) {
+	stored, err := u.store.TokenUsage(id)
+	if err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if p, ok := u.pending[id]; ok {
+		stored.Requests += p.Requests
+		stored.BytesUploaded += p.BytesUploaded
+		stored.BytesDownloaded += p.BytesDownloaded
+		if p.LastUsedAt != nil && (stored.LastUsedAt == nil || p.LastUsedAt.After(*stored.LastUsedAt)) {
+			at := p.LastUsedAt.UTC()
+			stored.LastUsedAt = &at
+		}
+	}
+	return stored, nil
+}
+
+// recordTokenUsage counts r, which has been served, toward the usage of
+// the issued token it was made with, with the body bytes the logging
+// middleware counted. Config tokens and signed requests are not counted.
+func (h *Handler) recordTokenUsage(r *http.Request, principal *models.Principal) {
+	if h.tokenUsage == nil || principal.TokenID == 0 {
+		return
+	}
+	var uploaded, downloaded int64
+	if req, ok := r.Context().Value(inflightKey{}).(*inflightRequest); ok {
+		uploaded, downloaded = req.read.Load(), req.rw.written.Load()
+	}
+	h.tokenUsage.add(principal.TokenID, uploaded, downloaded, h.clock.Now())
+}
+
+// FlushTokenUsage writes the token usage totalled since the last flush to
+// the token store. Totals that could not be written are kept for the next
+// flush.
+func (h *Handler) FlushTokenUsage() error {
+	if h.tokenUsage == nil {
+		return nil
+	}
+	return h.tokenUsage.flush()
+}
+
+// RunTokenUsageFlush flushes token usage every interval until ctx is
+// done. Shutdown is left to flush what is counted after that.
+func (h *Handler) RunTokenUsageFlush(ctx context.Context, interval time.Duration) {
+	if h.tokenUsage == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.FlushTokenUsage(); err != nil {
+				h.logger.Error().Err(err).Msg("recording token usage")
+			}
+		}
+	}
+}
+
+// GetTokenUsage handles GET /api/v1/admin/tokens/{id}/usage, answering
+// how many requests the issued token has made, the body bytes they sent
+// and received, and when it was last used.
+func (h *Handler) GetTokenUsage(w http.ResponseWriter, r *http.Request) {
+	if !h.tokenStoreEnabled(w) {
+		return
+	}
+	if h.tokenUsage == nil {
+		writeError(w, http.StatusNotImplemented, "the token store does not record usage")
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid token id")
+		return
+	}
+	usage, err := h.tokenUsage.get(id)
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("token %d not found", id))
+			return
+		}
+		h.logger.Error().Err(err).Msg("querying token usage")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/foundry/registry/internal/adapters/transcode"
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/logging"
+)
+
+// serveTranscoded serves the artifact converted to the archive format the
+// client asked for in its Accept header. It returns false without writing
+// anything when no conversion applies, leaving the caller to serve the
+// stored bytes.
+func (h *Handler) serveTranscoded(w http.ResponseWriter, r *http.Request, artifact *models.Artifact) bool {
+	target, ok := transcode.Negotiate(r.Header.Get("Accept"))
+	if !ok {
+		return false
+	}
+
+	reader, err := h.blobs.Open(artifact.Hash)
+	if err != nil {
+		// Let the regular download path report the error.
+		return false
+	}
+	defer reader.Close()
+
+	br := bufio.NewReader(reader)
+	prefix, _ := br.Peek(4)
+	source, ok := transcode.Detect(prefix)
+	if !ok || source == target {
+		return false
+	}
+
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Content-Type", target.ContentType())
+	w.Header().Set("X-Artifact-Hash", artifact.Hash)
+	w.Header().Set("X-Transcoded-From", string(source))
+	w.Header().Set("Content-Disposition", contentDisposition(transcodedFilename(artifact, target)))
+	h.cacheDownload(w)
+
+	cached, err := h.transcodes.Open(artifact.Hash, target)
+	if err == nil {
+		defer cached.Close()
+		if info, err := cached.Stat(); err == nil {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size()))
+		}
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, cached); err != nil {
+			h.logTranscodeError(r, artifact, err, "streaming cached transcoded artifact")
+		}
+		return true
+	}
+	if !errors.Is(err, services.ErrNotFound) {
+		h.logger.Error().Err(err).Str("hash", artifact.Hash).Msg("opening transcode cache entry")
+	}
+
+	// Stream the conversion to the client while filling the cache. The entry
+	// is only committed if the whole conversion succeeds.
+	entry, err := h.transcodes.Create(artifact.Hash, target)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("creating transcode cache entry")
+		writeError(w, http.StatusInternalServerError, "failed to transcode artifact")
+		return true
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := transcode.Convert(io.MultiWriter(w, entry), br, source, target); err != nil {
+		entry.Abort()
+		h.logTranscodeError(r, artifact, err, "transcoding artifact")
+		return true
+	}
+	if err := entry.Commit(); err != nil {
+		h.logger.Error().Err(err).Str("hash", artifact.Hash).Msg("committing transcode cache entry")
+	}
+	return true
+}
+
+func (h *Handler) logTranscodeError(r *http.Request, artifact *models.Artifact, err error, msg string) {
+	h.logger.Error().
+		Err(err).
+		Str("request_id", logging.RequestID(r.Context())).
+		Str("package", artifact.Package).
+		Str("version", artifact.Version).
+		Str("accept", strings.TrimSpace(r.Header.Get("Accept"))).
+		Msg(msg)
+}
+
+// archiveSuffixes are the extensions replaced when naming a transcoded
+// download, longest first.
+var archiveSuffixes = []string{".tar.gz", ".tar.zst", ".tgz", ".tzst", ".tar", ".gz", ".zst"}
+
+// transcodedFilename swaps the archive extension of the uploaded filename for
+// the target format's, e.g. app.tar.zst becomes app.tar.gz.
+func transcodedFilename(artifact *models.Artifact, target transcode.Format) string {
+	if artifact.Filename == "" {
+		return fmt.Sprintf("%s-%s%s", artifact.Package, artifact.Version, target.Extension())
+	}
+	base := artifact.Filename
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(strings.ToLower(base), suffix) {
+			base = base[:len(base)-len(suffix)]
+			break
+		}
+	}
+	return base + target.Extension()
+}
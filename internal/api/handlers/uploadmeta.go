@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// metadataPart is the form name of the JSON metadata part of a multipart
+// upload.
+const metadataPart = "metadata"
+
+// Bounds on upload metadata, which is returned with every listing of the
+// version.
+const (
+	maxMetadataBytes    = 64 << 10
+	maxDescriptionBytes = 4 << 10
+	maxLabels           = 64
+	maxLabelValueBytes  = 256
+)
+
+// labelKey is a label name: up to 128 letters, digits, dots, dashes,
+// underscores and slashes, starting with a letter or digit.
+var labelKey = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,127}$`)
+
+// readMetadata decodes a metadata part into f.meta.
+func (f *uploadFile) readMetadata(part io.Reader) error {
+	if f.meta != nil {
+		return errors.New("multipart upload has more than one metadata part")
+	}
+	data, err := io.ReadAll(io.LimitReader(part, maxMetadataBytes+1))
+	if err != nil {
+		return fmt.Errorf("reading metadata part: %w", err)
+	}
+	if len(data) > maxMetadataBytes {
+		return fmt.Errorf("metadata part exceeds %d bytes", maxMetadataBytes)
+	}
+	var meta models.UploadMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return errors.New("metadata part is not a valid JSON object")
+	}
+	f.meta = &meta
+	return nil
+}
+
+// metadata returns the metadata part of a multipart upload, or nil if it
+// has none. The part may follow the file, so call it once the file has
+// been read.
+func (f *uploadFile) metadata() (*models.UploadMetadata, error) {
+	for f.form != nil {
+		p, err := f.form.NextPart()
+		if errors.Is(err, io.EOF) {
+			f.form = nil
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading multipart body: %w", err)
+		}
+		if p.FormName() == metadataPart {
+			if err := f.readMetadata(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f.meta, nil
+}
+
+// applyMetadata validates the description, labels and dependencies of an
+// upload's metadata and records them in in.
+func applyMetadata(in *models.ArtifactInput, pkgName string, meta *models.UploadMetadata) error {
+	if meta == nil {
+		return nil
+	}
+	description := strings.TrimSpace(meta.Description)
+	if len(description) > maxDescriptionBytes {
+		return fmt.Errorf("description exceeds %d bytes", maxDescriptionBytes)
+	}
+	if len(meta.Labels) > maxLabels {
+		return fmt.Errorf("at most %d labels are allowed", maxLabels)
+	}
+	for _, key := range slices.Sorted(maps.Keys(meta.Labels)) {
+		value := meta.Labels[key]
+		if !labelKey.MatchString(key) {
+			return fmt.Errorf("invalid label name %q", key)
+		}
+		if len(value) > maxLabelValueBytes || strings.ContainsFunc(value, unicode.IsControl) {
+			return fmt.Errorf("invalid value for label %s", key)
+		}
+	}
+	deps, err := validateDependencies(pkgName, meta.Dependencies)
+	if err != nil {
+		return err
+	}
+
+	in.Description = description
+	if len(meta.Labels) > 0 {
+		in.Labels = meta.Labels
+	}
+	if len(deps) > 0 {
+		in.Dependencies = deps
+	}
+	return nil
+}
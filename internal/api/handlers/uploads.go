@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/util/clock"
+)
+
+// Clients name an upload with X-Upload-ID and poll GET /api/v1/uploads/{id}
+// while it runs, to see the bytes the server has received and, once they
+// are all in, what it is doing with them.
+
+// uploadRetention is how long a finished upload can still be polled.
+const uploadRetention = 10 * time.Minute
+
+// uploadID is the form of client-chosen upload IDs, such as UUIDs.
+var uploadID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// trackedUpload is an upload whose progress can be polled.
+type trackedUpload struct {
+	mu       sync.Mutex
+	progress models.UploadProgress
+	// owner is the token that made the upload; only it and admins may
+	// poll it.
+	owner string
+	clock clock.Clock
+}
+
+func (u *trackedUpload) update(fn func(*models.UploadProgress)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	fn(&u.progress)
+	u.progress.UpdatedAt = u.clock.Now()
+}
+
+func (u *trackedUpload) snapshot() models.UploadProgress {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.progress
+}
+
+// uploadTracker holds the uploads being tracked and those finished within
+// uploadRetention.
+type uploadTracker struct {
+	mu      sync.Mutex
+	uploads map[string]*trackedUpload
+}
+
+func newUploadTracker() *uploadTracker {
+	return &uploadTracker{uploads: make(map[string]*trackedUpload)}
+}
+
+// add starts tracking u under id, dropping uploads that finished more than
+// uploadRetention ago. An ID in use by a running upload is refused.
+func (t *uploadTracker) add(id string, u *trackedUpload, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, old := range t.uploads {
+		p := old.snapshot()
+		if p.FinishedAt != nil && now.Sub(*p.FinishedAt) > uploadRetention {
+			delete(t.uploads, key)
+		}
+	}
+	if old, ok := t.uploads[id]; ok && old.snapshot().FinishedAt == nil {
+		return errors.New("upload ID is in use")
+	}
+	t.uploads[id] = u
+	return nil
+}
+
+func (t *uploadTracker) get(id string) *trackedUpload {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.uploads[id]
+}
+
+type uploadKey struct{}
+
+// trackUpload starts tracking the upload r makes to pkg@version, or file
+// of it, if the client named it in X-Upload-ID. It returns the writer and
+// request the handler should use from then on, and a func to call when the
+// handler returns, which records how the upload ended. Without the header
+// it changes nothing.
+func (h *Handler) trackUpload(w http.ResponseWriter, r *http.Request, pkgName, version, file string) (http.ResponseWriter, *http.Request, func(), bool) {
+	id := r.Header.Get("X-Upload-ID")
+	if id == "" {
+		return w, r, func() {}, true
+	}
+	if !uploadID.MatchString(id) {
+		writeError(w, http.StatusBadRequest, "X-Upload-ID must be 1 to 64 letters, digits, dashes or underscores")
+		return w, r, nil, false
+	}
+
+	now := h.clock.Now()
+	u := &trackedUpload{clock: h.clock, progress: models.UploadProgress{
+		ID:         id,
+		Package:    pkgName,
+		Version:    version,
+		File:       file,
+		State:      models.UploadReceiving,
+		TotalBytes: max(r.ContentLength, 0),
+		StartedAt:  now,
+		UpdatedAt:  now,
+	}}
+	if p := principalFrom(r.Context()); p != nil {
+		u.owner = p.Name
+	}
+	if err := h.uploads.add(id, u, now); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return w, r, nil, false
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), uploadKey{}, u))
+	r.Body = &uploadBodyCounter{ReadCloser: r.Body, upload: u}
+	rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+	done := func() {
+		u.update(func(p *models.UploadProgress) {
+			finished := h.clock.Now()
+			p.FinishedAt = &finished
+			p.Status = rw.status
+			p.State = models.UploadDone
+			if rw.status >= http.StatusBadRequest {
+				p.State = models.UploadFailed
+			}
+		})
+	}
+	return rw, r, done, true
+}
+
+// uploadBodyCounter counts the body of a tracked upload, which is storing
+// once the body has been read to the end.
+type uploadBodyCounter struct {
+	io.ReadCloser
+	upload *trackedUpload
+}
+
+func (b *uploadBodyCounter) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.upload.update(func(p *models.UploadProgress) {
+		p.ReceivedBytes += int64(n)
+		if errors.Is(err, io.EOF) && p.State == models.UploadReceiving {
+			p.State = models.UploadStoring
+		}
+	})
+	return n, err
+}
+
+// setUploadState moves the upload r makes on to state, if it is tracked.
+func setUploadState(r *http.Request, state string) {
+	if u, ok := r.Context().Value(uploadKey{}).(*trackedUpload); ok {
+		u.update(func(p *models.UploadProgress) { p.State = state })
+	}
+}
+
+// GetUpload handles GET /api/v1/uploads/{id}, reporting the progress of
+// an upload made with that X-Upload-ID by the same token, or by anyone for
+// admins.
+func (h *Handler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	u := h.uploads.get(id)
+	if u != nil && !isAdmin(r) {
+		if p := principalFrom(r.Context()); p == nil || p.Name != u.owner {
+			u = nil
+		}
+	}
+	if u == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("upload %s not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, u.snapshot())
+}
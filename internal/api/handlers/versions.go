@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"sort"
+
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/util/semver"
+)
+
+// Orders accepted by ?sort= on the package detail endpoint.
+const (
+	sortUploaded = "uploaded"
+	sortSemver   = "semver"
+)
+
+// sortBySemver orders artifacts highest version first using semver
+// precedence, so pre-releases sort below their release. Versions that are
+// not valid semver follow, keeping their existing order.
+func sortBySemver(artifacts []models.Artifact) {
+	type keyed struct {
+		artifact models.Artifact
+		version  semver.Version
+		valid    bool
+	}
+	keys := make([]keyed, len(artifacts))
+	for i, a := range artifacts {
+		v, err := semver.Parse(a.Version)
+		keys[i] = keyed{artifact: a, version: v, valid: err == nil}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		if keys[i].valid != keys[j].valid {
+			return keys[i].valid
+		}
+		return keys[i].valid && keys[i].version.Compare(keys[j].version) > 0
+	})
+	for i := range keys {
+		artifacts[i] = keys[i].artifact
+	}
+}
+
+// latestVersion picks the newest of a package's versions: the highest
+// semver release, else the highest pre-release, else the most recently
+// uploaded when no version is valid semver. artifacts must be in upload
+// order, newest first.
+func latestVersion(artifacts []models.Artifact) string {
+	var best, bestPre string
+	var bestVersion, bestPreVersion semver.Version
+	for _, a := range artifacts {
+		v, err := semver.Parse(a.Version)
+		switch {
+		case err != nil:
+		case len(v.Pre) == 0:
+			if best == "" || v.Compare(bestVersion) > 0 {
+				best, bestVersion = a.Version, v
+			}
+		default:
+			if bestPre == "" || v.Compare(bestPreVersion) > 0 {
+				bestPre, bestPreVersion = a.Version, v
+			}
+		}
+	}
+	switch {
+	case best != "":
+		return best
+	case bestPre != "":
+		return bestPre
+	case len(artifacts) > 0:
+		return artifacts[0].Version
+	}
+	return ""
+}
@@ -2,48 +2,809 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/foundry/registry/internal/util/filetype"
+	"github.com/foundry/registry/internal/util/naming"
+	"github.com/foundry/registry/internal/util/secrets"
 )
 
 type Config struct {
-	Server  ServerConfig  `yaml:"server"`
-	Storage StorageConfig `yaml:"storage"`
-	Auth    AuthConfig    `yaml:"auth"`
+	Server        ServerConfig        `yaml:"server"`
+	Storage       StorageConfig       `yaml:"storage"`
+	Auth          AuthConfig          `yaml:"auth"`
+	Transcoding   TranscodingConfig   `yaml:"transcoding"`
+	Downloads     DownloadsConfig     `yaml:"downloads"`
+	Cache         CacheConfig         `yaml:"cache"`
+	Limits        LimitsConfig        `yaml:"limits"`
+	Policy        PolicyConfig        `yaml:"policy"`
+	Expiry        ExpiryConfig        `yaml:"expiry"`
+	GC            GCConfig            `yaml:"gc"`
+	Hooks         []HookConfig        `yaml:"hooks"`
+	Malware       MalwareConfig       `yaml:"malware"`
+	Attestations  AttestationsConfig  `yaml:"attestations"`
+	Notifications NotificationsConfig `yaml:"notifications"`
+	Federation    FederationConfig    `yaml:"federation"`
+	Approvals     ApprovalsConfig     `yaml:"approvals"`
+	Naming        NamingConfig        `yaml:"naming"`
 }
 
+// ServerConfig sets where the server listens. Listeners replaces the single
+// Port with any number of TCP addresses and Unix sockets. BasePath serves
+// every route under a prefix such as /foundry. TrustedProxies lists the
+// addresses or CIDR ranges of reverse proxies whose X-Forwarded-* and
+// X-Real-IP headers are believed; headers from any other peer are ignored.
 type ServerConfig struct {
-	Port int `yaml:"port"`
+	Port           int              `yaml:"port"`
+	Listeners      []ListenerConfig `yaml:"listeners"`
+	BasePath       string           `yaml:"basePath"`
+	TrustedProxies []string         `yaml:"trustedProxies"`
+}
+
+// ListenerConfig is one address the server accepts connections on. Address
+// is host:port, or unix:/path for a Unix domain socket whose permissions
+// SocketMode sets in octal (default 0660). Routes picks what the listener
+// serves: "all" (the default), "public" for everything but the admin
+// routes, or "admin" for only those. TrustProxy, allowed only on a Unix
+// socket, treats its peers as trusted proxies, for a socket that only a
+// local reverse proxy connects to.
+type ListenerConfig struct {
+	Address    string `yaml:"address"`
+	Routes     string `yaml:"routes"`
+	SocketMode string `yaml:"socketMode"`
+	TrustProxy bool   `yaml:"trustProxy"`
 }
 
+// SocketPath returns the path of a Unix socket listener, or "" for TCP.
+func (l ListenerConfig) SocketPath() string {
+	path, _ := strings.CutPrefix(l.Address, "unix:")
+	if path == l.Address {
+		return ""
+	}
+	return path
+}
+
+// FileMode returns the permissions for a Unix socket listener.
+func (l ListenerConfig) FileMode() (os.FileMode, error) {
+	if l.SocketMode == "" {
+		return 0o660, nil
+	}
+	mode, err := strconv.ParseUint(l.SocketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid socketMode %q", l.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// TrustedSockets returns the paths of the Unix socket listeners whose peers
+// are trusted proxies.
+func (s ServerConfig) TrustedSockets() []string {
+	var paths []string
+	for _, l := range s.Listeners {
+		if path := l.SocketPath(); path != "" && l.TrustProxy {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// TrustedProxyNetworks parses TrustedProxies. A bare address stands for
+// itself alone.
+func (s ServerConfig) TrustedProxyNetworks() ([]netip.Prefix, error) {
+	return parseNetworks("server.trustedProxies", s.TrustedProxies)
+}
+
+// parseNetworks parses a list of addresses and CIDR ranges, the setting
+// named field.
+func parseNetworks(field string, entries []string) ([]netip.Prefix, error) {
+	networks := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			network, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s entry %q", field, entry)
+			}
+			networks = append(networks, network.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q", field, entry)
+		}
+		addr = addr.Unmap()
+		networks = append(networks, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return networks, nil
+}
+
+// StorageConfig selects where blobs live. Backend names a registered blob
+// storage backend, disk unless chunking is enabled; Options are passed to
+// it as they are. Uploads are digested with SHA-256 and SHA-512, and with
+// BLAKE3 too when it is set. Uploads are refused while the backend has less
+// than ReserveBytes free; zero disables the check. Routes send the blobs of
+// some packages to other backends. ImportDirs are the directories admins
+// may import files from on the server's filesystem; none disables imports.
+// Snapshots serve the data directories of other registries read-only
+// alongside this one.
 type StorageConfig struct {
-	DataDir string `yaml:"dataDir"`
+	DataDir      string               `yaml:"dataDir"`
+	Backend      string               `yaml:"backend"`
+	Options      map[string]string    `yaml:"options"`
+	Chunking     ChunkingConfig       `yaml:"chunking"`
+	Cold         ColdStorageConfig    `yaml:"cold"`
+	BLAKE3       bool                 `yaml:"blake3"`
+	ReserveBytes int64                `yaml:"reserveBytes"`
+	TempCleanup  TempCleanupConfig    `yaml:"tempCleanup"`
+	Routes       []StorageRouteConfig `yaml:"routes"`
+	ImportDirs   []string             `yaml:"importDirs"`
+	Snapshots    []SnapshotConfig     `yaml:"snapshots"`
+}
+
+// StorageRouteConfig stores the blobs of packages whose names start with
+// Prefix in their own backend, opened like the main one from Backend, disk
+// by default, DataDir and Options. The longest matching prefix wins;
+// other packages stay in the main backend.
+type StorageRouteConfig struct {
+	Prefix  string            `yaml:"prefix"`
+	Backend string            `yaml:"backend"`
+	DataDir string            `yaml:"dataDir"`
+	Options map[string]string `yaml:"options"`
+}
+
+// SnapshotConfig mounts a copy of another registry's data directory
+// read-only, merging its packages into this registry's namespace. A
+// version this registry has shadows a snapshot's, and earlier snapshots
+// shadow later ones. Name tells clients which snapshot a version comes
+// from. Its blobs are opened like the main backend's from Backend, disk by
+// default, and Options.
+type SnapshotConfig struct {
+	Name    string            `yaml:"name"`
+	DataDir string            `yaml:"dataDir"`
+	Backend string            `yaml:"backend"`
+	Options map[string]string `yaml:"options"`
+}
+
+// TempCleanupConfig removes the temp files of uploads a crash interrupted.
+// Files older than MaxAge are removed at startup and every Interval after.
+// MaxAge defaults to a day and Interval to an hour; a zero MaxAge disables
+// the cleanup and a zero Interval runs it only at startup.
+type TempCleanupConfig struct {
+	MaxAge   time.Duration `yaml:"maxAge"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// resolveBackend picks the backend when none is named and hands the
+// chunking sizes to the chunked backend as options.
+func (s *StorageConfig) resolveBackend() error {
+	switch {
+	case s.Backend == "" && s.Chunking.Enabled:
+		s.Backend = "chunked"
+	case s.Backend == "":
+		s.Backend = "disk"
+	case s.Chunking.Enabled && s.Backend != "chunked":
+		return fmt.Errorf("storage.chunking.enabled conflicts with storage.backend %q", s.Backend)
+	}
+	if s.Backend != "chunked" {
+		return nil
+	}
+	for key, size := range map[string]int{"minSize": s.Chunking.MinSize, "avgSize": s.Chunking.AvgSize, "maxSize": s.Chunking.MaxSize} {
+		if _, set := s.Options[key]; set || size == 0 {
+			continue
+		}
+		if s.Options == nil {
+			s.Options = make(map[string]string)
+		}
+		s.Options[key] = strconv.Itoa(size)
+	}
+	return nil
+}
+
+// ColdStorageConfig enables a second, cheaper blob store. Blobs not
+// downloaded for IdleDays, counted from their upload if never downloaded,
+// are moved there every SweepInterval. Restore moves a cold blob back when
+// it is downloaded; otherwise it is read from cold storage in place.
+// Setting DataDir enables tiering; IdleDays defaults to 30 and
+// SweepInterval to an hour.
+type ColdStorageConfig struct {
+	DataDir       string        `yaml:"dataDir"`
+	IdleDays      int           `yaml:"idleDays"`
+	Restore       bool          `yaml:"restore"`
+	SweepInterval time.Duration `yaml:"sweepInterval"`
+}
+
+// ChunkingConfig enables content-defined chunked blob storage. Sizes are in
+// bytes; zero values fall back to the chunker defaults.
+type ChunkingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	MinSize int  `yaml:"minSize"`
+	AvgSize int  `yaml:"avgSize"`
+	MaxSize int  `yaml:"maxSize"`
+}
+
+// TranscodingConfig controls on-the-fly archive format conversion on
+// download. CacheDir defaults to <dataDir>/cache/transcode.
+type TranscodingConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CacheDir string `yaml:"cacheDir"`
+}
+
+// DownloadsConfig controls how artifact bytes are delivered. When Redirect is
+// set and the storage backend can sign URLs, or a CDN is configured,
+// downloads answer with a 307 to a signed URL valid for RedirectTTL instead
+// of proxying the bytes.
+type DownloadsConfig struct {
+	Redirect    bool          `yaml:"redirect"`
+	RedirectTTL time.Duration `yaml:"redirectTTL"`
+	CDN         CDNConfig     `yaml:"cdn"`
+}
+
+// CDNConfig puts a CDN in front of downloads. Redirects go to BaseURL,
+// signed with SigningKey, which the CDN must share to check them at the
+// edge. An empty BaseURL disables the CDN.
+type CDNConfig struct {
+	BaseURL    string `yaml:"baseURL"`
+	SigningKey string `yaml:"signingKey"`
+}
+
+// CacheConfig sets the Cache-Control of read responses. Downloads of
+// version files may be kept for DownloadMaxAge, then revalidated by ETag,
+// and package listings and indexes for ListingMaxAge; zero makes them
+// uncacheable. Other responses never are. Public lets shared caches store
+// responses, not only clients.
+type CacheConfig struct {
+	Public         bool          `yaml:"public"`
+	DownloadMaxAge time.Duration `yaml:"downloadMaxAge"`
+	ListingMaxAge  time.Duration `yaml:"listingMaxAge"`
+}
+
+// LimitsConfig bounds concurrent transfers and per-connection bandwidth so a
+// single client cannot saturate the disk or network. Zero disables a limit.
+// Requests beyond the concurrency caps get a 503 with Retry-After.
+// MaxExtractBytes bounds files served from inside archive artifacts.
+type LimitsConfig struct {
+	MaxConcurrentUploads   int           `yaml:"maxConcurrentUploads"`
+	MaxConcurrentDownloads int           `yaml:"maxConcurrentDownloads"`
+	UploadBytesPerSecond   int64         `yaml:"uploadBytesPerSecond"`
+	DownloadBytesPerSecond int64         `yaml:"downloadBytesPerSecond"`
+	MaxExtractBytes        int64         `yaml:"maxExtractBytes"`
+	RetryAfter             time.Duration `yaml:"retryAfter"`
+}
+
+// ExpiryConfig controls the removal of versions uploaded with an expiry.
+// Expired versions are deleted every SweepInterval, and garbage collection
+// then reclaims their blobs. Zero disables the sweep.
+type ExpiryConfig struct {
+	SweepInterval time.Duration `yaml:"sweepInterval"`
+}
+
+// GCConfig paces garbage collection. Blobs are examined BatchSize at a
+// time, unreferenced ones are deleted at most BlobsPerSecond, and a run
+// stops after MaxDuration, leaving the rest to the next run. Zero disables
+// a limit.
+//
+// Reclaim deletes blobs as soon as their last reference goes, without a
+// collection: "immediate" deletes them in the request that released them,
+// "lazy" every ReclaimInterval, a minute by default. Empty leaves them to
+// garbage collection.
+//
+// DownloadLease is how long a download keeps the blob it reads from being
+// deleted, an hour by default.
+type GCConfig struct {
+	BatchSize       int           `yaml:"batchSize"`
+	BlobsPerSecond  int           `yaml:"blobsPerSecond"`
+	MaxDuration     time.Duration `yaml:"maxDuration"`
+	Reclaim         string        `yaml:"reclaim"`
+	ReclaimInterval time.Duration `yaml:"reclaimInterval"`
+	DownloadLease   time.Duration `yaml:"downloadLease"`
+}
+
+// HookConfig runs a hook outside the server at the hook points in Hooks:
+// pre-upload, post-upload, pre-download and pre-delete, or all of them if
+// empty. The hook is either Command, a program and its arguments, or URL,
+// a web service the event is posted to. Timeout bounds each run and
+// defaults to 10s.
+type HookConfig struct {
+	Hooks   []string      `yaml:"hooks"`
+	Command []string      `yaml:"command"`
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// MalwareConfig scans uploads for malware with either ClamAV, the address
+// of a clamd daemon as host:port or unix:/path, or Command, a scanner such
+// as clamdscan run with each upload on standard input. Timeout bounds each
+// scan and defaults to 5m.
+type MalwareConfig struct {
+	ClamAV  string        `yaml:"clamav"`
+	Command []string      `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// AttestationsConfig verifies uploaded attestations. Attestations signed
+// by a key listed for the builder their provenance names are marked
+// verified; RequireVerified refuses the rest.
+type AttestationsConfig struct {
+	TrustedBuilders []TrustedBuilderConfig `yaml:"trustedBuilders"`
+	RequireVerified bool                   `yaml:"requireVerified"`
+}
+
+// TrustedBuilderConfig trusts the builder with ID, the builder ID its SLSA
+// provenance names, when it signs with the PEM public key in the file
+// PublicKey. List a builder once per key to rotate keys.
+type TrustedBuilderConfig struct {
+	ID        string `yaml:"id"`
+	PublicKey string `yaml:"publicKey"`
+}
+
+// NotificationsConfig delivers the notifications of the subscriptions
+// created through the API. Timeout bounds each delivery and defaults to
+// 10s. Email subscriptions need SMTP. Webhooks only reach public addresses
+// unless AllowedNetworks lists the internal addresses or CIDR ranges they
+// may also reach.
+type NotificationsConfig struct {
+	Timeout         time.Duration `yaml:"timeout"`
+	SMTP            SMTPConfig    `yaml:"smtp"`
+	AllowedNetworks []string      `yaml:"allowedNetworks"`
+}
+
+// WebhookNetworks parses AllowedNetworks.
+func (n NotificationsConfig) WebhookNetworks() ([]netip.Prefix, error) {
+	return parseNetworks("notifications.allowedNetworks", n.AllowedNetworks)
+}
+
+// SMTPConfig is the mail server email notifications are sent through: Addr
+// as host:port and From, the sender address. Username and Password, if
+// set, authenticate with PLAIN, which requires a server offering STARTTLS
+// unless it runs on localhost.
+type SMTPConfig struct {
+	Addr     string `yaml:"addr"`
+	From     string `yaml:"from"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// FederationConfig extends GET /api/v1/search to peer registries, so
+// clients find a package whichever registry holds it. Name is this
+// registry's name in results, "local" by default. A peer answering later
+// than Timeout, 3s by default, is left out of the results.
+type FederationConfig struct {
+	Name    string        `yaml:"name"`
+	Timeout time.Duration `yaml:"timeout"`
+	Peers   []PeerConfig  `yaml:"peers"`
+}
+
+// PeerConfig is a registry searches fan out to: Name labels its results,
+// URL is its base URL and Token a token it accepts.
+type PeerConfig struct {
+	Name  string `yaml:"name"`
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
 }
 
+// ApprovalsConfig holds destructive operations until a second admin
+// approves them: deleting a version of a package whose name starts with
+// one of Packages, and garbage collections that would free more than
+// GCBytes. Zero GCBytes lets every collection run.
+type ApprovalsConfig struct {
+	Packages []string `yaml:"packages"`
+	GCBytes  int64    `yaml:"gcBytes"`
+}
+
+// NamingConfig sets the rules package names and versions must follow to be
+// uploaded; uploads breaking them get a 400. PackagePattern and
+// VersionPattern are regular expressions a whole name must match, and
+// MaxPackageLength and MaxVersionLength bound names in characters; empty
+// or zero leaves them unchecked. RequireSemver requires semver versions.
+// Only admins may create packages whose names start with one of
+// ReservedPrefixes. Lowercase stores packages uploaded through the
+// artifact routes under lowercase names and refuses other names with upper
+// case letters.
+type NamingConfig struct {
+	PackagePattern   string   `yaml:"packagePattern"`
+	VersionPattern   string   `yaml:"versionPattern"`
+	RequireSemver    bool     `yaml:"requireSemver"`
+	MaxPackageLength int      `yaml:"maxPackageLength"`
+	MaxVersionLength int      `yaml:"maxVersionLength"`
+	ReservedPrefixes []string `yaml:"reservedPrefixes"`
+	Lowercase        bool     `yaml:"lowercase"`
+}
+
+// Rules compiles the naming rules.
+func (n NamingConfig) Rules() (naming.Rules, error) {
+	rules := naming.Rules{
+		RequireSemver:    n.RequireSemver,
+		MaxPackageLength: n.MaxPackageLength,
+		MaxVersionLength: n.MaxVersionLength,
+		ReservedPrefixes: n.ReservedPrefixes,
+		Lowercase:        n.Lowercase,
+	}
+	var err error
+	if n.PackagePattern != "" {
+		if rules.PackagePattern, err = naming.Anchor(n.PackagePattern); err != nil {
+			return naming.Rules{}, fmt.Errorf("invalid naming.packagePattern: %w", err)
+		}
+	}
+	if n.VersionPattern != "" {
+		if rules.VersionPattern, err = naming.Anchor(n.VersionPattern); err != nil {
+			return naming.Rules{}, fmt.Errorf("invalid naming.versionPattern: %w", err)
+		}
+	}
+	return rules, nil
+}
+
+// PolicyConfig gates access to artifacts. BlockSeverity refuses downloads of
+// versions whose scan report has findings at or above that severity
+// (critical, high, medium or low); empty allows every download. The other
+// fields configure the rules consulted before uploads and deletes.
+type PolicyConfig struct {
+	BlockSeverity string `yaml:"blockSeverity"`
+	// Scanners names the tokens that may attach scan reports besides
+	// admins. Only admins may attach a report that lowers a block.
+	Scanners []string `yaml:"scanners"`
+	// RequireSemver refuses uploads of versions that are not semver.
+	RequireSemver bool `yaml:"requireSemver"`
+	// PackagePrefixes maps token names to the package prefixes they may
+	// write; "*" covers tokens not listed.
+	PackagePrefixes map[string][]string `yaml:"packagePrefixes"`
+	// ProtectReleases refuses deletes from semver release versions.
+	ProtectReleases bool `yaml:"protectReleases"`
+	// Formats maps package name prefixes to the formats their uploads may
+	// have; the longest matching prefix applies.
+	Formats map[string][]string `yaml:"formats"`
+	// MatchFilenames refuses uploads whose content is not in the format
+	// their file name's extension claims.
+	MatchFilenames bool      `yaml:"matchFilenames"`
+	OPA            OPAConfig `yaml:"opa"`
+	// Quarantine holds new uploads back from non-admin callers until an
+	// admin approves them.
+	Quarantine bool `yaml:"quarantine"`
+	// DefaultStage is the stage new versions start in, staging or
+	// release. Empty means release.
+	DefaultStage string `yaml:"defaultStage"`
+	// RequireIfMatch refuses changes to a version's metadata that do not
+	// send its current revision in If-Match.
+	RequireIfMatch bool `yaml:"requireIfMatch"`
+	// IdempotentUploads answers an upload to an existing version with 200
+	// and the stored version, instead of 409, when the content is the
+	// same.
+	IdempotentUploads bool `yaml:"idempotentUploads"`
+}
+
+// OPAConfig points at an Open Policy Agent rule consulted after the
+// built-in rules. URL is the rule's data API URL; empty disables OPA.
+type OPAConfig struct {
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// AuthConfig lists the static admin tokens. SigningKeys are shared
+// secrets machine clients sign requests with instead of sending a token;
+// a signed request is accepted within ClockSkew of the server's clock,
+// five minutes by default. The use of issued tokens is totalled in memory
+// and written out every UsageFlushInterval, a minute by default, and at
+// shutdown; zero writes it at shutdown only.
+//
+// Tokens and signing key secrets may refer to secrets kept elsewhere (see
+// ResolveSecrets); a reference in Tokens may hold several tokens, one per
+// line. They are read again every SecretRefresh, so rotated secrets take
+// effect without a restart; zero reads them at startup only.
 type AuthConfig struct {
-	Tokens []string `yaml:"tokens"`
+	Tokens             []string           `yaml:"tokens"`
+	SigningKeys        []SigningKeyConfig `yaml:"signingKeys"`
+	ClockSkew          time.Duration      `yaml:"clockSkew"`
+	UsageFlushInterval time.Duration      `yaml:"usageFlushInterval"`
+	Lockout            LockoutConfig      `yaml:"lockout"`
+	SecretRefresh      time.Duration      `yaml:"secretRefresh"`
+}
+
+// LockoutConfig locks out client addresses and signing keys that fail
+// authentication MaxFailures times within Window, ten minutes by default,
+// for Duration, fifteen minutes by default. Zero MaxFailures disables
+// lockouts.
+type LockoutConfig struct {
+	MaxFailures int           `yaml:"maxFailures"`
+	Window      time.Duration `yaml:"window"`
+	Duration    time.Duration `yaml:"duration"`
+}
+
+// SigningKeyConfig is a key for signed requests. ID names it in requests
+// and as the caller; Secret must be at least 32 characters. Admin grants
+// admin rights.
+type SigningKeyConfig struct {
+	ID     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+	Admin  bool   `yaml:"admin"`
 }
 
-// Load reads and parses a YAML config file.
+// Default returns the configuration used for settings a config file
+// leaves out.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{Port: 8080},
+		Auth:   AuthConfig{UsageFlushInterval: time.Minute},
+		Storage: StorageConfig{
+			DataDir:     "./data",
+			TempCleanup: TempCleanupConfig{MaxAge: 24 * time.Hour, Interval: time.Hour},
+		},
+		Downloads: DownloadsConfig{
+			RedirectTTL: 5 * time.Minute,
+		},
+		Cache: CacheConfig{
+			DownloadMaxAge: time.Minute,
+			ListingMaxAge:  30 * time.Second,
+		},
+		Limits: LimitsConfig{
+			MaxExtractBytes: 100 << 20,
+			RetryAfter:      5 * time.Second,
+		},
+		Policy: PolicyConfig{
+			OPA: OPAConfig{Timeout: 2 * time.Second},
+		},
+		Expiry:        ExpiryConfig{SweepInterval: 5 * time.Minute},
+		Notifications: NotificationsConfig{Timeout: 10 * time.Second},
+		Federation:    FederationConfig{Name: "local", Timeout: 3 * time.Second},
+	}
+}
+
+// Load reads and parses a YAML config file over Default and validates it.
+// A config file must list at least one auth token.
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("reading config: %w", err)
 	}
 
-	cfg := &Config{
-		Server:  ServerConfig{Port: 8080},
-		Storage: StorageConfig{DataDir: "./data"},
-	}
-
+	cfg := Default()
 	if err := yaml.Unmarshal(data, cfg); err != nil {
 		return nil, fmt.Errorf("parsing config: %w", err)
 	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 
-	if len(cfg.Auth.Tokens) == 0 {
+	if len(cfg.Auth.Tokens) == 0 && len(cfg.Auth.SigningKeys) == 0 {
 		return nil, fmt.Errorf("no auth tokens configured")
 	}
 
 	return cfg, nil
 }
+
+// Validate checks the settings and fills in those derived from others,
+// such as the listener list from Port.
+func (cfg *Config) Validate() error {
+	if cfg.Transcoding.CacheDir == "" {
+		cfg.Transcoding.CacheDir = filepath.Join(cfg.Storage.DataDir, "cache", "transcode")
+	}
+
+	if cfg.Server.BasePath != "" && !strings.HasPrefix(cfg.Server.BasePath, "/") {
+		return fmt.Errorf("server.basePath %q must start with /", cfg.Server.BasePath)
+	}
+	if _, err := cfg.Server.TrustedProxyNetworks(); err != nil {
+		return err
+	}
+	if len(cfg.Server.Listeners) == 0 {
+		cfg.Server.Listeners = []ListenerConfig{{Address: fmt.Sprintf(":%d", cfg.Server.Port)}}
+	}
+	seen := make(map[string]bool)
+	for i := range cfg.Server.Listeners {
+		l := &cfg.Server.Listeners[i]
+		switch l.Routes {
+		case "":
+			l.Routes = "all"
+		case "all", "public", "admin":
+		default:
+			return fmt.Errorf("invalid routes %q for listener %q", l.Routes, l.Address)
+		}
+		if l.Address == "" || l.SocketPath() == "" && !strings.Contains(l.Address, ":") {
+			return fmt.Errorf("invalid listener address %q: want host:port or unix:/path", l.Address)
+		}
+		if _, err := l.FileMode(); err != nil {
+			return fmt.Errorf("listener %q: %w", l.Address, err)
+		}
+		if l.TrustProxy && l.SocketPath() == "" {
+			return fmt.Errorf("listener %q: trustProxy is only for Unix sockets; list proxy addresses in server.trustedProxies", l.Address)
+		}
+		if seen[l.Address] {
+			return fmt.Errorf("duplicate listener address %q", l.Address)
+		}
+		seen[l.Address] = true
+	}
+
+	switch cfg.Policy.BlockSeverity {
+	case "", "critical", "high", "medium", "low":
+	default:
+		return fmt.Errorf("invalid policy.blockSeverity %q", cfg.Policy.BlockSeverity)
+	}
+	if err := cfg.Storage.resolveBackend(); err != nil {
+		return err
+	}
+	if cfg.Storage.ReserveBytes < 0 {
+		return fmt.Errorf("storage.reserveBytes %d must not be negative", cfg.Storage.ReserveBytes)
+	}
+	prefixes := make(map[string]bool)
+	for i := range cfg.Storage.Routes {
+		route := &cfg.Storage.Routes[i]
+		if route.Prefix == "" || prefixes[route.Prefix] {
+			return fmt.Errorf("storage.routes[%d]: prefix %q is empty or already routed", i, route.Prefix)
+		}
+		prefixes[route.Prefix] = true
+		if route.Backend == "" {
+			route.Backend = "disk"
+		}
+		if (route.Backend == "disk" || route.Backend == "chunked") && route.DataDir == "" {
+			return fmt.Errorf("storage.routes[%d]: dataDir is required for the %s backend", i, route.Backend)
+		}
+	}
+	keys := make(map[string]bool)
+	for i, key := range cfg.Auth.SigningKeys {
+		if key.ID == "" || strings.ContainsAny(key.ID, ", =") || keys[key.ID] {
+			return fmt.Errorf("auth.signingKeys[%d]: id %q is empty, already taken or contains ',', '=' or spaces", i, key.ID)
+		}
+		keys[key.ID] = true
+		if !secrets.IsRef(key.Secret) && len(key.Secret) < 32 {
+			return fmt.Errorf("auth.signingKeys[%d]: secret must be at least 32 characters", i)
+		}
+	}
+	if cfg.Auth.ClockSkew < 0 {
+		return fmt.Errorf("auth.clockSkew must not be negative")
+	}
+	if cfg.Auth.ClockSkew == 0 {
+		cfg.Auth.ClockSkew = 5 * time.Minute
+	}
+	if cfg.Auth.UsageFlushInterval < 0 || cfg.Auth.SecretRefresh < 0 {
+		return fmt.Errorf("auth.usageFlushInterval and auth.secretRefresh must not be negative")
+	}
+	lockout := &cfg.Auth.Lockout
+	if lockout.MaxFailures < 0 || lockout.Window < 0 || lockout.Duration < 0 {
+		return fmt.Errorf("auth.lockout.maxFailures, window and duration must not be negative")
+	}
+	if lockout.Window == 0 {
+		lockout.Window = 10 * time.Minute
+	}
+	if lockout.Duration == 0 {
+		lockout.Duration = 15 * time.Minute
+	}
+	snapshots := make(map[string]bool)
+	for i := range cfg.Storage.Snapshots {
+		snap := &cfg.Storage.Snapshots[i]
+		if snap.Name == "" || snapshots[snap.Name] {
+			return fmt.Errorf("storage.snapshots[%d]: name %q is empty or already taken", i, snap.Name)
+		}
+		snapshots[snap.Name] = true
+		if snap.DataDir == "" {
+			return fmt.Errorf("storage.snapshots[%d]: dataDir is required", i)
+		}
+		if snap.Backend == "" {
+			snap.Backend = "disk"
+		}
+	}
+	if tc := cfg.Storage.TempCleanup; tc.MaxAge < 0 || tc.Interval < 0 {
+		return fmt.Errorf("storage.tempCleanup maxAge and interval must not be negative")
+	}
+	if cdn := cfg.Downloads.CDN; cdn.BaseURL != "" {
+		u, err := url.Parse(cdn.BaseURL)
+		if err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+			return fmt.Errorf("invalid downloads.cdn.baseURL %q: want an http or https URL", cdn.BaseURL)
+		}
+		if !secrets.IsRef(cdn.SigningKey) && len(cdn.SigningKey) < 32 {
+			return fmt.Errorf("downloads.cdn.signingKey must be at least 32 characters")
+		}
+		if cfg.Downloads.RedirectTTL < time.Second {
+			return fmt.Errorf("downloads.redirectTTL must be at least a second with a CDN")
+		}
+	}
+	if cfg.Cache.DownloadMaxAge < 0 || cfg.Cache.ListingMaxAge < 0 {
+		return fmt.Errorf("cache downloadMaxAge and listingMaxAge must not be negative")
+	}
+	if cold := &cfg.Storage.Cold; cold.DataDir != "" {
+		if cold.IdleDays <= 0 {
+			cold.IdleDays = 30
+		}
+		if cold.SweepInterval <= 0 {
+			cold.SweepInterval = time.Hour
+		}
+	}
+	switch cfg.GC.Reclaim {
+	case "", "immediate":
+	case "lazy":
+		if cfg.GC.ReclaimInterval <= 0 {
+			cfg.GC.ReclaimInterval = time.Minute
+		}
+	default:
+		return fmt.Errorf("invalid gc.reclaim %q", cfg.GC.Reclaim)
+	}
+	if cfg.GC.DownloadLease <= 0 {
+		cfg.GC.DownloadLease = time.Hour
+	}
+	switch cfg.Policy.DefaultStage {
+	case "", "staging", "release":
+	default:
+		return fmt.Errorf("invalid policy.defaultStage %q", cfg.Policy.DefaultStage)
+	}
+	for prefix, formats := range cfg.Policy.Formats {
+		for _, f := range formats {
+			if !slices.Contains(filetype.Formats, f) {
+				return fmt.Errorf("policy.formats[%q]: unknown format %q", prefix, f)
+			}
+		}
+	}
+	for i := range cfg.Hooks {
+		hook := &cfg.Hooks[i]
+		if (len(hook.Command) == 0) == (hook.URL == "") {
+			return fmt.Errorf("hooks[%d]: set exactly one of command and url", i)
+		}
+		for _, name := range hook.Hooks {
+			switch name {
+			case "pre-upload", "post-upload", "pre-download", "pre-delete":
+			default:
+				return fmt.Errorf("hooks[%d]: invalid hook point %q", i, name)
+			}
+		}
+		if hook.Timeout <= 0 {
+			hook.Timeout = 10 * time.Second
+		}
+	}
+	if m := &cfg.Malware; m.ClamAV != "" || len(m.Command) > 0 {
+		if m.ClamAV != "" && len(m.Command) > 0 {
+			return fmt.Errorf("malware: set only one of clamav and command")
+		}
+		if m.Timeout <= 0 {
+			m.Timeout = 5 * time.Minute
+		}
+	}
+	for i, b := range cfg.Attestations.TrustedBuilders {
+		if b.ID == "" || b.PublicKey == "" {
+			return fmt.Errorf("attestations.trustedBuilders[%d]: id and publicKey are required", i)
+		}
+	}
+	if cfg.Attestations.RequireVerified && len(cfg.Attestations.TrustedBuilders) == 0 {
+		return fmt.Errorf("attestations.requireVerified needs at least one trusted builder")
+	}
+	if smtp := cfg.Notifications.SMTP; smtp.Addr != "" && smtp.From == "" {
+		return fmt.Errorf("notifications.smtp.from is required with notifications.smtp.addr")
+	}
+	if cfg.Notifications.Timeout <= 0 {
+		cfg.Notifications.Timeout = 10 * time.Second
+	}
+	if _, err := cfg.Notifications.WebhookNetworks(); err != nil {
+		return err
+	}
+	fed := &cfg.Federation
+	if fed.Name == "" {
+		fed.Name = "local"
+	}
+	if fed.Timeout <= 0 {
+		fed.Timeout = 3 * time.Second
+	}
+	names := map[string]bool{fed.Name: true}
+	for i, peer := range fed.Peers {
+		if peer.Name == "" || names[peer.Name] {
+			return fmt.Errorf("federation.peers[%d]: name %q is empty or already taken", i, peer.Name)
+		}
+		names[peer.Name] = true
+		u, err := url.Parse(peer.URL)
+		if err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+			return fmt.Errorf("federation.peers[%d]: invalid url %q: want an http or https URL", i, peer.URL)
+		}
+	}
+	if cfg.Approvals.GCBytes < 0 {
+		return fmt.Errorf("approvals.gcBytes %d must not be negative", cfg.Approvals.GCBytes)
+	}
+	if cfg.Naming.MaxPackageLength < 0 || cfg.Naming.MaxVersionLength < 0 {
+		return fmt.Errorf("naming maxPackageLength and maxVersionLength must not be negative")
+	}
+	if _, err := cfg.Naming.Rules(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// DefaultDataDir returns the conventional system-wide data directory for the
+// current platform. Relative storage paths resolve against it when the
+// server runs as a managed service rather than from a shell.
+func DefaultDataDir() string {
+	switch runtime.GOOS {
+	case "windows":
+		base := os.Getenv("ProgramData")
+		if base == "" {
+			base = `C:\ProgramData`
+		}
+		return filepath.Join(base, "Foundry")
+	case "darwin":
+		return "/usr/local/var/foundry"
+	default:
+		return "/var/lib/foundry"
+	}
+}
+
+// DefaultConfigPath returns the conventional system-wide config file path
+// for the current platform.
+func DefaultConfigPath() string {
+	switch runtime.GOOS {
+	case "windows":
+		return filepath.Join(DefaultDataDir(), "config.yaml")
+	case "darwin":
+		return "/usr/local/etc/foundry/config.yaml"
+	default:
+		return "/etc/foundry/config.yaml"
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/foundry/registry/internal/util/secrets"
+)
+
+// ResolveSecrets returns a copy of cfg with the secrets it refers to read
+// in, so they need not be written into the config file: auth tokens and
+// signing key secrets, the CDN signing key, the SMTP password and peer
+// tokens may each be a file://, env:// or vault:// reference (see package
+// secrets). The copy is validated again with the secrets in place.
+func (cfg *Config) ResolveSecrets(ctx context.Context, r *secrets.Resolver) (*Config, error) {
+	out := *cfg
+	var err error
+	if out.Auth.Tokens, out.Auth.SigningKeys, err = cfg.Auth.ResolveSecrets(ctx, r); err != nil {
+		return nil, err
+	}
+	if out.Downloads.CDN.SigningKey, err = r.Resolve(ctx, cfg.Downloads.CDN.SigningKey); err != nil {
+		return nil, fmt.Errorf("downloads.cdn.signingKey: %w", err)
+	}
+	if out.Notifications.SMTP.Password, err = r.Resolve(ctx, cfg.Notifications.SMTP.Password); err != nil {
+		return nil, fmt.Errorf("notifications.smtp.password: %w", err)
+	}
+	out.Federation.Peers = slices.Clone(cfg.Federation.Peers)
+	for i := range out.Federation.Peers {
+		peer := &out.Federation.Peers[i]
+		if peer.Token, err = r.Resolve(ctx, peer.Token); err != nil {
+			return nil, fmt.Errorf("federation.peers[%d].token: %w", i, err)
+		}
+	}
+	if err := out.Validate(); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ResolveSecrets reads the auth tokens and signing key secrets a refers to.
+// A reference in Tokens may hold several tokens, one per line.
+func (a AuthConfig) ResolveSecrets(ctx context.Context, r *secrets.Resolver) ([]string, []SigningKeyConfig, error) {
+	tokens, err := r.ResolveList(ctx, a.Tokens)
+	if err != nil {
+		return nil, nil, fmt.Errorf("auth.tokens: %w", err)
+	}
+	keys := slices.Clone(a.SigningKeys)
+	for i := range keys {
+		if keys[i].Secret, err = r.Resolve(ctx, keys[i].Secret); err != nil {
+			return nil, nil, fmt.Errorf("auth.signingKeys[%d].secret: %w", i, err)
+		}
+		if len(keys[i].Secret) < 32 {
+			return nil, nil, fmt.Errorf("auth.signingKeys[%d]: secret must be at least 32 characters", i)
+		}
+	}
+	return tokens, keys, nil
+}
@@ -1,25 +1,225 @@
 package models
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 type Package struct {
 	ID   int64  `json:"id"`
 	Name string `json:"name"`
+	// Deprecated is set when the whole package is deprecated.
+	Deprecated *Deprecation `json:"deprecated,omitempty"`
+	// UpdatedAt is when its latest version was uploaded, unset while it has
+	// none, and TotalSize is the sum of its versions' sizes. Both count
+	// every version, whatever its stage.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	TotalSize int64      `json:"total_size"`
+}
+
+// Deprecation steers users off a package or version without deleting it.
+// InFavorOf optionally points at a replacement, a version or another
+// package.
+type Deprecation struct {
+	Message   string `json:"message"`
+	InFavorOf string `json:"deprecated_in_favor_of,omitempty"`
 }
 
 type Artifact struct {
-	ID         int64     `json:"id"`
-	PackageID  int64     `json:"package_id"`
-	Package    string    `json:"package"`
-	Version    string    `json:"version"`
-	Hash       string    `json:"hash"`
-	Size       int64     `json:"size"`
-	UploadedAt time.Time `json:"uploaded_at"`
+	ID          int64     `json:"id"`
+	PackageID   int64     `json:"package_id"`
+	Package     string    `json:"package"`
+	Version     string    `json:"version"`
+	Hash        string    `json:"hash"`
+	Size        int64     `json:"size"`
+	Filename    string    `json:"filename,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	// Quarantined versions are hidden from non-admin callers until approved.
+	Quarantined bool `json:"quarantined,omitempty"`
+	// Stage is StageStaging or StageRelease. PromotedBy and PromotedAt
+	// record the last promotion, if there was one.
+	Stage      string     `json:"stage"`
+	PromotedBy string     `json:"promoted_by,omitempty"`
+	PromotedAt *time.Time `json:"promoted_at,omitempty"`
+	// Stability is one of Stabilities.
+	Stability string `json:"stability"`
+	// ExpiresAt is when the version is removed, if it was uploaded with an
+	// expiry.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Pinned versions cannot be deleted, by hand or by expiry, until they
+	// are unpinned.
+	Pinned bool `json:"pinned,omitempty"`
+	// Deprecated is set when the version itself is deprecated; the
+	// package may be deprecated as a whole as well.
+	Deprecated *Deprecation `json:"deprecated,omitempty"`
+	// ReleaseNotes is the version's changelog, in Markdown.
+	ReleaseNotes string `json:"release_notes,omitempty"`
+	// Description and Labels are set by the uploader in the metadata part
+	// of a multipart upload.
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	// Revision starts at 1 and advances whenever the version's metadata
+	// changes. Mutating requests may send it in If-Match.
+	Revision int64 `json:"revision"`
+	// Vulnerabilities summarizes the version's scan report, if it has one.
+	Vulnerabilities *VulnerabilitySummary `json:"vulnerabilities,omitempty"`
+	// Tier is the storage tier of the version's file, TierHot or TierCold.
+	Tier string `json:"tier,omitempty"`
+	// Malware is MalwareClean or MalwareInfected once the version's file
+	// has been scanned for malware.
+	Malware string `json:"malware,omitempty"`
+	// Licenses are the SPDX identifiers detected in the version's file,
+	// sorted, when it is an archive declaring a license.
+	Licenses []string `json:"licenses,omitempty"`
+	// SHA512 and BLAKE3 are hex digests of the version's file alongside
+	// Hash, its SHA-256, once computed. BLAKE3 is only computed when
+	// enabled.
+	SHA512 string `json:"sha512,omitempty"`
+	BLAKE3 string `json:"blake3,omitempty"`
+	// Format classifies the version's file by its content, e.g. "tar.gz",
+	// "wheel" or "binary", once it has been detected.
+	Format string `json:"format,omitempty"`
+	// Snapshot names the read-only snapshot the version is served from,
+	// and is empty for versions of the live registry.
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// ArtifactInput holds the fields recorded for a new artifact. Filename and
+// ContentType are optional and describe the file as the client uploaded it.
+// Quarantined creates the version awaiting approval. Stage defaults to
+// StageRelease. A non-nil ExpiresAt schedules the version for removal.
+// Description, Labels and Dependencies are recorded with the version.
+type ArtifactInput struct {
+	Version     string
+	Hash        string
+	Size        int64
+	Filename    string
+	ContentType string
+	Quarantined bool
+	Stage       string
+	// Stability is empty to take it from the version's pre-release tag.
+	Stability    string
+	ExpiresAt    *time.Time
+	Description  string
+	Labels       map[string]string
+	Dependencies []Dependency
+}
+
+// Stages a version moves through. Staging versions can be downloaded by
+// exact version but are left out of package listings, package manager
+// indexes and dependency resolution until they are promoted to release.
+const (
+	StageStaging = "staging"
+	StageRelease = "release"
+)
+
+// Stability levels of a version, least stable first. Uploads may declare
+// one; otherwise a semver pre-release tag starting with beta or rc gives
+// that level, any other pre-release tag alpha, and everything else stable.
+const (
+	StabilityAlpha  = "alpha"
+	StabilityBeta   = "beta"
+	StabilityRC     = "rc"
+	StabilityStable = "stable"
+)
+
+// Stabilities lists the stability levels, least stable first.
+var Stabilities = []string{StabilityAlpha, StabilityBeta, StabilityRC, StabilityStable}
+
+// ChannelStabilities returns the stability levels a channel includes: its
+// own and every more stable one, so the beta channel also offers release
+// candidates and stable versions. It returns nil for an unknown channel.
+func ChannelStabilities(channel string) []string {
+	i := slices.Index(Stabilities, channel)
+	if i < 0 {
+		return nil
+	}
+	return Stabilities[i:]
+}
+
+// Storage tiers a blob can be in. New blobs start hot; tiering moves those
+// not downloaded for a while to cheaper cold storage.
+const (
+	TierHot  = "hot"
+	TierCold = "cold"
+)
+
+// Asset is a named file attached to a version alongside its default file,
+// e.g. one build per platform. Default marks the artifact's own file when
+// assets are listed together.
+type Asset struct {
+	ID          int64     `json:"-"`
+	ArtifactID  int64     `json:"-"`
+	Name        string    `json:"name"`
+	Hash        string    `json:"hash"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	Default     bool      `json:"default,omitempty"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+// AssetInput holds the fields recorded for a new asset.
+type AssetInput struct {
+	Name        string
+	Hash        string
+	Size        int64
+	ContentType string
+}
+
+// ArtifactFilter narrows an artifact listing; zero fields match everything.
+// UploadedAfter is inclusive and UploadedBefore exclusive, so consecutive
+// ranges do not overlap. Hash matches a version's default file or any of its
+// named files. License matches versions whose default file declares that
+// license, ignoring case. Results come newest first; BeforeID and Limit page them.
+type ArtifactFilter struct {
+	Package        string
+	UploadedAfter  time.Time
+	UploadedBefore time.Time
+	MinSize        *int64
+	MaxSize        *int64
+	Hash           string
+	License        string
+	Format         string
+	// Channel keeps the versions a stability channel offers.
+	Channel  string
+	BeforeID int64
+	Limit    int
+}
+
+// ArtifactList is a page of artifacts across packages.
+type ArtifactList struct {
+	Artifacts  []Artifact `json:"artifacts"`
+	NextCursor string     `json:"next_cursor,omitempty"`
 }
 
 type PackageInfo struct {
-	Name     string     `json:"name"`
+	Name string `json:"name"`
+	// Latest is the newest released version by semver precedence.
+	Latest   string     `json:"latest,omitempty"`
 	Versions []Artifact `json:"versions"`
+	// Deprecated is set when the whole package is deprecated.
+	Deprecated *Deprecation `json:"deprecated,omitempty"`
+	// Comments are the notes left on the package and its versions, oldest
+	// first.
+	Comments []Comment `json:"comments,omitempty"`
+}
+
+// Comment is a note left on a package, or on one of its versions when
+// Version is set, such as "known bad, use 1.2.4". Author is the token name
+// of whoever left it.
+type Comment struct {
+	ID        int64     `json:"id"`
+	Package   string    `json:"package"`
+	Version   string    `json:"version,omitempty"`
+	Body      string    `json:"body"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateCommentRequest struct {
+	Version string `json:"version"`
+	Body    string `json:"body"`
 }
 
 type ErrorResponse struct {
@@ -29,14 +229,890 @@ type ErrorResponse struct {
 }
 
 type UploadResponse struct {
-	Package    string `json:"package"`
-	Version    string `json:"version"`
-	Hash       string `json:"hash"`
-	Size       int64  `json:"size"`
-	UploadedAt string `json:"uploaded_at"`
+	Package     string     `json:"package"`
+	Version     string     `json:"version"`
+	Hash        string     `json:"hash"`
+	Size        int64      `json:"size"`
+	Filename    string     `json:"filename,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	UploadedAt  time.Time  `json:"uploaded_at"`
+	Quarantined bool       `json:"quarantined,omitempty"`
+	Stage       string     `json:"stage"`
+	Stability   string     `json:"stability"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Malware     string     `json:"malware,omitempty"`
+	// Description, Labels and Dependencies echo the metadata part of a
+	// multipart upload.
+	Description  string            `json:"description,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Dependencies []Dependency      `json:"dependencies,omitempty"`
+}
+
+// UploadMetadata is the JSON metadata part of a multipart upload. Hash is
+// the SHA-256 the file is expected to have, like X-Artifact-Hash.
+type UploadMetadata struct {
+	Description  string            `json:"description,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Dependencies []Dependency      `json:"dependencies,omitempty"`
+	Hash         string            `json:"hash,omitempty"`
+}
+
+// CopyRequest names the version a copy creates. Package defaults to the
+// package copied from.
+type CopyRequest struct {
+	Package string `json:"package,omitempty"`
+	Version string `json:"version"`
+}
+
+// LinkRequest publishes a version from a blob already on the server.
+type LinkRequest struct {
+	Hash        string `json:"hash"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// Contents lists the files inside a blob that is a tar or zip archive. It is
+// recorded per blob, so every version or file with the same bytes shares
+// it. An empty Format marks a blob that is not an archive. Truncated is set
+// when the archive held more entries than were recorded. Licenses are those
+// declared by the archive's license files and manifests.
+type Contents struct {
+	Hash      string            `json:"hash"`
+	Format    string            `json:"format"`
+	Entries   []ContentEntry    `json:"entries"`
+	Truncated bool              `json:"truncated,omitempty"`
+	Licenses  []DetectedLicense `json:"licenses,omitempty"`
+	IndexedAt time.Time         `json:"indexed_at"`
+}
+
+// DetectedLicense is an SPDX license identifier and the archive member
+// that declared it.
+type DetectedLicense struct {
+	License string `json:"license"`
+	Path    string `json:"path"`
+}
+
+// ContentEntry is one file, directory or link in an archive. Mode is
+// formatted like ls -l, e.g. "-rw-r--r--".
+type ContentEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Mode string `json:"mode"`
+}
+
+// VersionDiff compares two versions of a package. Properties lists the
+// version fields that differ, such as its hash, size, stage or licenses.
+// Files compares the named files by hash, Dependencies the dependency
+// constraints by package, and Contents, when both default files are tar
+// or zip archives, their entries by path.
+type VersionDiff struct {
+	Package      string       `json:"package"`
+	From         string       `json:"from"`
+	To           string       `json:"to"`
+	Properties   []DiffEntry  `json:"properties"`
+	Files        DiffSection  `json:"files"`
+	Dependencies DiffSection  `json:"dependencies"`
+	Contents     *DiffSection `json:"contents,omitempty"`
+}
+
+// DiffSection lists the entries only To has, only From has, and both have
+// with different values, each by name.
+type DiffSection struct {
+	Added   []DiffEntry `json:"added"`
+	Removed []DiffEntry `json:"removed"`
+	Changed []DiffEntry `json:"changed"`
+	// Truncated is set when an archive listing compared was cut short, so
+	// entries past it are missing.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// DiffEntry is one difference. From is empty for an added entry and To for
+// a removed one.
+type DiffEntry struct {
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// History actions.
+const (
+	HistoryCreate    = "create"
+	HistoryOverwrite = "overwrite"
+	HistoryDelete    = "delete"
+	HistoryCopy      = "copy"
+)
+
+// HistoryEvent records a change to a version or one of its files. File is
+// empty for events on the version itself. OldHash is set for deletions and
+// overwrites, NewHash for creations, overwrites and copies. Source names
+// the package@version a copy was made from. Actor names the token that
+// made the change, or "expiry" for versions removed when they expired.
+type HistoryEvent struct {
+	ID      int64     `json:"id"`
+	Package string    `json:"package"`
+	Version string    `json:"version"`
+	File    string    `json:"file,omitempty"`
+	Action  string    `json:"action"`
+	OldHash string    `json:"old_hash,omitempty"`
+	NewHash string    `json:"new_hash,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	Actor   string    `json:"actor,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// HistoryResponse is a page of a package's history, newest first.
+type HistoryResponse struct {
+	Package    string         `json:"package"`
+	Events     []HistoryEvent `json:"events"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// ArchiveRequest lists the artifacts to bundle into one download. Format is
+// "tar" (the default) or "zip".
+type ArchiveRequest struct {
+	Format    string         `json:"format,omitempty"`
+	Artifacts []ArchiveEntry `json:"artifacts"`
+}
+
+// ArchiveEntry names a version to include, or one of its named files when
+// File is set. In an archive's manifest, Path is where the file sits in the
+// archive and Hash and Size describe its contents.
+type ArchiveEntry struct {
+	Package string `json:"package"`
+	Version string `json:"version"`
+	File    string `json:"file,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+}
+
+// ArchiveManifest is written as manifest.json at the start of an archive.
+type ArchiveManifest struct {
+	Artifacts []ArchiveEntry `json:"artifacts"`
+}
+
+// BatchGetRequest lists the versions to look up in one call.
+type BatchGetRequest struct {
+	Artifacts []ArtifactRef `json:"artifacts"`
+}
+
+// ArtifactRef names one version of a package.
+type ArtifactRef struct {
+	Package string `json:"package"`
+	Version string `json:"version"`
+}
+
+// BatchGetResult answers one entry of a BatchGetRequest. Artifact is nil and
+// Found false when the version does not exist or is hidden from the caller.
+type BatchGetResult struct {
+	Package  string    `json:"package"`
+	Version  string    `json:"version"`
+	Found    bool      `json:"found"`
+	Artifact *Artifact `json:"artifact,omitempty"`
+}
+
+// BatchGetResponse holds one result per requested entry, in request order.
+type BatchGetResponse struct {
+	Artifacts []BatchGetResult `json:"artifacts"`
+}
+
+// BatchDeleteRequest selects the versions to delete in one call, either by
+// listing them or by a filter. Without Confirm the call only previews the
+// deletion; passing back the preview's Confirm token carries it out, as
+// long as the selection still matches what was previewed.
+type BatchDeleteRequest struct {
+	Artifacts []ArtifactRef      `json:"artifacts,omitempty"`
+	Filter    *BatchDeleteFilter `json:"filter,omitempty"`
+	Confirm   string             `json:"confirm,omitempty"`
+}
+
+// BatchDeleteFilter matches versions by package name prefix, age and
+// stability; a version must match every field set. OlderThan is a duration
+// such as 720h or 30d. Channel matches versions of exactly that stability,
+// unlike the channel of a listing, which also offers more stable ones.
+type BatchDeleteFilter struct {
+	Prefix    string `json:"prefix,omitempty"`
+	OlderThan string `json:"older_than,omitempty"`
+	Channel   string `json:"channel,omitempty"`
+}
+
+// Outcomes of one version of a batch delete. A preview marks the versions
+// it would delete pending.
+const (
+	BatchDeletePending  = "pending"
+	BatchDeleteDeleted  = "deleted"
+	BatchDeleteNotFound = "not_found"
+	BatchDeletePinned   = "pinned"
+	BatchDeleteRefused  = "refused"
+	BatchDeleteHeld     = "held"
+	BatchDeleteFailed   = "failed"
+)
+
+// BatchDeleteResult reports one selected version. Message gives the reason
+// a version was refused or failed. Held versions belong to protected
+// packages; Approval is the pending approval that will delete them.
+type BatchDeleteResult struct {
+	Package  string `json:"package"`
+	Version  string `json:"version"`
+	Size     int64  `json:"size,omitempty"`
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+	Approval int64  `json:"approval,omitempty"`
+}
+
+// BatchDeleteResponse answers a batch delete. A preview has DryRun set and
+// the Confirm token to carry it out with; Deleted counts the versions a
+// confirmed call removed.
+type BatchDeleteResponse struct {
+	DryRun    bool                `json:"dry_run"`
+	Confirm   string              `json:"confirm,omitempty"`
+	Artifacts []BatchDeleteResult `json:"artifacts"`
+	Deleted   int                 `json:"deleted"`
+}
+
+// PromoteRequest names the stage a version moves to.
+type PromoteRequest struct {
+	Stage string `json:"stage,omitempty"`
 }
 
 type GCResult struct {
 	DeletedBlobs int   `json:"deleted_blobs"`
 	FreedBytes   int64 `json:"freed_bytes"`
+	// DryRun results report what would be deleted and list the blobs.
+	DryRun     bool     `json:"dry_run,omitempty"`
+	Candidates []string `json:"candidates,omitempty"`
+	// MissingFiles counts version files whose blob is gone from storage,
+	// listed in Missing. Tombstoned counts those whose metadata was removed
+	// at the caller's request.
+	MissingFiles int       `json:"missing_files"`
+	Tombstoned   int       `json:"tombstoned,omitempty"`
+	Missing      []FileRef `json:"missing,omitempty"`
+	// Partial is set when the run stopped before examining every blob,
+	// because it reached its time limit or was canceled.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// GCProgress reports how far a garbage collection has got through the
+// blobs in storage.
+type GCProgress struct {
+	TotalBlobs   int   `json:"total_blobs"`
+	ScannedBlobs int   `json:"scanned_blobs"`
+	DeletedBlobs int   `json:"deleted_blobs"`
+	FreedBytes   int64 `json:"freed_bytes"`
+}
+
+// Job statuses.
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// Job is an admin operation running in the background. Kind is "gc", the
+// only kind so far. Result is set once the job has finished.
+type Job struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Progress   GCProgress `json:"progress"`
+	Result     *GCResult  `json:"result,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// FileRef names one file of a version and the blob holding it.
+type FileRef struct {
+	Package string `json:"package"`
+	Version string `json:"version"`
+	// File is empty for the version's default file.
+	File string `json:"file,omitempty"`
+	Hash string `json:"hash"`
+	// Tombstoned is set in GC results once the file's metadata is removed.
+	Tombstoned bool `json:"tombstoned,omitempty"`
+}
+
+// NamingViolation is a stored package, or one of its versions, whose name
+// breaks the naming rules uploads are held to.
+type NamingViolation struct {
+	Package string `json:"package"`
+	// Version is empty when the package name itself breaks the rules.
+	Version  string   `json:"version,omitempty"`
+	Problems []string `json:"problems"`
+}
+
+// Principal identifies the caller behind an authenticated request. TokenID
+// is zero for tokens listed in the server config, which are always admin.
+type Principal struct {
+	TokenID int64  `json:"token_id,omitempty"`
+	Name    string `json:"name"`
+	Admin   bool   `json:"admin"`
+	// User is the account the token was issued to, if any.
+	User string `json:"user,omitempty"`
+	// Groups are the SSO groups an authenticator mapped the caller's
+	// claims to; teams list the group whose members they include.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// APIToken describes an issued API token. The secret itself is only stored
+// hashed and is returned once, at creation.
+type APIToken struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Admin     bool       `json:"admin"`
+	User      string     `json:"user,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// TokenUsage is what an issued token has been used for: the requests
+// made with it, the request and response body bytes they carried, and when
+// it was last used. LastUsedAt is nil for a token never used.
+type TokenUsage struct {
+	TokenID         int64      `json:"token_id"`
+	Requests        int64      `json:"requests"`
+	BytesUploaded   int64      `json:"bytes_uploaded"`
+	BytesDownloaded int64      `json:"bytes_downloaded"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+}
+
+// CreateTokenRequest issues a token. User, if set, names the account the
+// token acts for, whose teams decide which owned packages it may change.
+type CreateTokenRequest struct {
+	Name  string `json:"name"`
+	Admin bool   `json:"admin"`
+	User  string `json:"user,omitempty"`
+}
+
+// User is a local account. Tokens issued to it act for it.
+type User struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Team is a group of users that can own packages. Members lists its users
+// by name; callers whose SSO claims map to SSOGroup count as members too.
+type Team struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	SSOGroup  string    `json:"sso_group,omitempty"`
+	Members   []string  `json:"members"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PackageOwners lists the teams owning a package. Only their members and
+// admins may publish or delete versions of a package with owners.
+type PackageOwners struct {
+	Package string `json:"package"`
+	Owners  []Team `json:"owners"`
+}
+
+type CreateTokenResponse struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+// Operations that can be held for approval.
+const (
+	ApprovalActionDelete = "delete"
+	ApprovalActionGC     = "gc"
+)
+
+// Approval states. Failed requests were approved but the operation did not
+// succeed.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalFailed   = "failed"
+)
+
+// Approval is a destructive operation held until an admin other than its
+// requester approves it: deleting Package@Version, or a garbage collection
+// that would free more than allowed, with Tombstone as requested. Reason
+// says why it was held; Result what came of it once decided. Approvals are
+// kept after they are decided as a record of who did what.
+type Approval struct {
+	ID          int64      `json:"id"`
+	Action      string     `json:"action"`
+	Package     string     `json:"package,omitempty"`
+	Version     string     `json:"version,omitempty"`
+	Tombstone   bool       `json:"tombstone,omitempty"`
+	Reason      string     `json:"reason"`
+	Status      string     `json:"status"`
+	RequestedBy string     `json:"requested_by"`
+	RequestedAt time.Time  `json:"requested_at"`
+	DecidedBy   string     `json:"decided_by,omitempty"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+	Result      string     `json:"result,omitempty"`
+}
+
+// Notification channels.
+const (
+	ChannelWebhook = "webhook"
+	ChannelSlack   = "slack"
+	ChannelEmail   = "email"
+)
+
+// Notification events: the history actions, plus promotions.
+const (
+	NotifyCreate    = HistoryCreate
+	NotifyOverwrite = HistoryOverwrite
+	NotifyDelete    = HistoryDelete
+	NotifyCopy      = HistoryCopy
+	NotifyPromote   = "promote"
+)
+
+// Subscription sends notifications of events on matching packages to a
+// channel. Package is a package name, or a prefix ending in "*". Events
+// lists the events notified, or all of them if empty. Target is the URL of
+// a webhook or Slack incoming webhook, or an email address. Secret signs
+// webhook deliveries; it is only returned when the subscription is
+// created. Admin records that an admin created it, so it is also told of
+// quarantined versions.
+type Subscription struct {
+	ID        int64     `json:"id"`
+	Package   string    `json:"package"`
+	Events    []string  `json:"events,omitempty"`
+	Channel   string    `json:"channel"`
+	Target    string    `json:"target"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	Admin     bool      `json:"admin,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateSubscriptionRequest struct {
+	Package string   `json:"package"`
+	Events  []string `json:"events,omitempty"`
+	Channel string   `json:"channel"`
+	Target  string   `json:"target"`
+	Secret  string   `json:"secret,omitempty"`
+}
+
+// Notification is an event delivered to subscriptions. File is set for
+// events on one of a version's named files, Hash for creations, overwrites
+// and copies, Source for copies and Stage for promotions.
+type Notification struct {
+	Event   string    `json:"event"`
+	Package string    `json:"package"`
+	Version string    `json:"version"`
+	File    string    `json:"file,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+	Stage   string    `json:"stage,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	Actor   string    `json:"actor,omitempty"`
+	At      time.Time `json:"at"`
+	// Quarantined keeps the notification from subscriptions that are not
+	// admins'. It is set for versions already deleted, which can no longer
+	// be looked up, and never sent.
+	Quarantined bool `json:"-"`
+}
+
+// RegistryStats summarizes registry contents. Artifact bytes count every
+// version; unique blob bytes count shared content once; stored bytes are what
+// the blob backend actually holds, including unreferenced blobs.
+type RegistryStats struct {
+	Packages        int64 `json:"packages"`
+	Artifacts       int64 `json:"artifacts"`
+	ArtifactBytes   int64 `json:"artifact_bytes"`
+	UniqueBlobs     int64 `json:"unique_blobs"`
+	UniqueBlobBytes int64 `json:"unique_blob_bytes"`
+	StoredBlobs     int   `json:"stored_blobs"`
+	StoredBytes     int64 `json:"stored_bytes"`
+	ActiveTokens    int64 `json:"active_tokens"`
+	// FreeBytes is the room left in blob storage, when the backend can
+	// tell; uploads are refused while it is below ReserveBytes.
+	FreeBytes    *int64 `json:"free_bytes,omitempty"`
+	ReserveBytes int64  `json:"reserve_bytes,omitempty"`
+}
+
+// Usage is the storage a package or namespace takes up. Files counts its
+// references to blobs: versions' default and named files, SBOMs, scan
+// reports and attestations. LogicalBytes sums their sizes; Blobs and
+// PhysicalBytes count each distinct blob once. A blob shared with other
+// packages counts toward each of them.
+type Usage struct {
+	Name          string `json:"name"`
+	Files         int64  `json:"files"`
+	LogicalBytes  int64  `json:"logical_bytes"`
+	Blobs         int64  `json:"blobs"`
+	PhysicalBytes int64  `json:"physical_bytes"`
+}
+
+// UsageReport breaks storage down by package and by namespace. A
+// package's namespace is its name up to the first ":" (a Maven group),
+// else the first "/", else the first "-", else the whole name. Total counts
+// every blob once across the registry.
+type UsageReport struct {
+	Total      Usage   `json:"total"`
+	Packages   []Usage `json:"packages"`
+	Namespaces []Usage `json:"namespaces"`
+}
+
+// QueryStat is the latency of the metadata queries one store method ran
+// since the registry started. Times are in microseconds.
+type QueryStat struct {
+	Label       string `json:"label"`
+	Calls       int64  `json:"calls"`
+	Errors      int64  `json:"errors"`
+	TotalMicros int64  `json:"total_us"`
+	MeanMicros  int64  `json:"mean_us"`
+	MaxMicros   int64  `json:"max_us"`
+}
+
+// AuthMetrics reports failed authentication since the registry started:
+// credentials refused, lockouts imposed after repeated failures, and
+// requests refused while locked out. Locked lists the keys locked out now,
+// soonest released first.
+type AuthMetrics struct {
+	Failures int64     `json:"failures"`
+	Lockouts int64     `json:"lockouts"`
+	Refused  int64     `json:"refused"`
+	Locked   []Lockout `json:"locked"`
+}
+
+// Lockout is a client address ("ip:<address>") or signing key
+// ("key:<id>") locked out after repeated authentication failures.
+type Lockout struct {
+	Key         string    `json:"key"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// BlobWriteStats reports how fast blob storage has written blobs. The
+// read, hash and write times are how long each stage of the write
+// pipeline was busy; as the stages overlap, the busiest one bounds the
+// throughput. MaxBytesPerSecond is the fastest single blob written.
+type BlobWriteStats struct {
+	Blobs             int64 `json:"blobs"`
+	Errors            int64 `json:"errors"`
+	Bytes             int64 `json:"bytes"`
+	TotalMicros       int64 `json:"total_us"`
+	ReadMicros        int64 `json:"read_us"`
+	HashMicros        int64 `json:"hash_us"`
+	WriteMicros       int64 `json:"write_us"`
+	BytesPerSecond    int64 `json:"bytes_per_second"`
+	MaxBytesPerSecond int64 `json:"max_bytes_per_second"`
+	BufferSize        int   `json:"buffer_size,omitempty"`
+	Buffers           int   `json:"buffers,omitempty"`
+}
+
+// Download report dimensions.
+const (
+	ReportByPackage   = "package"
+	ReportByVersion   = "version"
+	ReportByPrincipal = "principal"
+	ReportByDay       = "day"
+)
+
+// DownloadStat totals the downloads of one group of a download report.
+// Only the fields of the report's dimensions are set; grouping by version
+// also sets Package. Day is formatted YYYY-MM-DD, in UTC.
+type DownloadStat struct {
+	Package   string `json:"package,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Principal string `json:"principal,omitempty"`
+	Day       string `json:"day,omitempty"`
+	Downloads int64  `json:"downloads"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// DownloadReport aggregates the downloads of the days From to To,
+// inclusive, by the dimensions in GroupBy.
+type DownloadReport struct {
+	From           string         `json:"from"`
+	To             string         `json:"to"`
+	GroupBy        []string       `json:"group_by"`
+	Rows           []DownloadStat `json:"rows"`
+	TotalDownloads int64          `json:"total_downloads"`
+	TotalBytes     int64          `json:"total_bytes"`
+}
+
+// CrateVersion is a published Cargo crate version. Entry holds its sparse
+// index line as published, without the yanked flag, which is tracked
+// separately so it can change.
+type CrateVersion struct {
+	Version     string `json:"version"`
+	Entry       string `json:"entry"`
+	Yanked      bool   `json:"yanked"`
+	Quarantined bool   `json:"quarantined,omitempty"`
+	Stage       string `json:"stage"`
+}
+
+// Dependency is a requirement of an artifact version on versions of
+// another package matching Constraint.
+type Dependency struct {
+	Package    string `json:"package"`
+	Constraint string `json:"constraint"`
+}
+
+// DependencyManifest is the body of a dependencies upload.
+type DependencyManifest struct {
+	Dependencies []Dependency `json:"dependencies"`
+}
+
+// DependenciesResponse lists a version's direct dependencies, or with
+// resolution requested, the versions selected for its whole dependency tree.
+type DependenciesResponse struct {
+	Package      string               `json:"package"`
+	Version      string               `json:"version"`
+	Dependencies []Dependency         `json:"dependencies"`
+	Resolved     []ResolvedDependency `json:"resolved,omitempty"`
+}
+
+// ResolvedDependency is a version selected by dependency resolution, with
+// the package versions that required it.
+type ResolvedDependency struct {
+	Package    string   `json:"package"`
+	Version    string   `json:"version"`
+	Hash       string   `json:"hash"`
+	Size       int64    `json:"size"`
+	RequiredBy []string `json:"required_by"`
+}
+
+// Dependent is an artifact version that depends on a package.
+type Dependent struct {
+	ArtifactID int64  `json:"-"`
+	Package    string `json:"package"`
+	Version    string `json:"version"`
+	Constraint string `json:"constraint"`
+}
+
+// DependentsResponse is one page of a package's dependents. NextCursor is
+// set when more remain and is passed back as ?cursor= to fetch them.
+type DependentsResponse struct {
+	Package    string      `json:"package"`
+	Dependents []Dependent `json:"dependents"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// SBOM describes the software bill of materials attached to a version,
+// stored as its own blob.
+type SBOM struct {
+	Hash        string    `json:"hash"`
+	Size        int64     `json:"size"`
+	Format      string    `json:"format"`
+	SpecVersion string    `json:"spec_version"`
+	Components  int       `json:"components"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+// SBOMComponent is a component listed in an SBOM, indexed for search.
+type SBOMComponent struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	PURL    string `json:"purl,omitempty"`
+}
+
+// VulnerabilitySummary counts the findings of a scan report by severity.
+// Findings without a recognized severity count as unknown.
+type VulnerabilitySummary struct {
+	Critical int `json:"critical"`
+	High     int `json:"high"`
+	Medium   int `json:"medium"`
+	Low      int `json:"low"`
+	Unknown  int `json:"unknown"`
+}
+
+// ScanReport describes the vulnerability scan report attached to a version,
+// stored as its own blob.
+type ScanReport struct {
+	Hash      string               `json:"hash"`
+	Size      int64                `json:"size"`
+	Scanner   string               `json:"scanner"`
+	Summary   VulnerabilitySummary `json:"summary"`
+	ScannedAt time.Time            `json:"scanned_at"`
+}
+
+// Attestation is a signed in-toto statement about one of a version's
+// files, such as SLSA provenance, stored as the DSSE envelope it was
+// uploaded in. Subject is the SHA-256 of the file it is about. Builder is
+// the build platform a provenance predicate names; Verified is set when the
+// envelope is signed by a key configured for that builder.
+type Attestation struct {
+	ID            int64     `json:"id"`
+	Hash          string    `json:"hash"`
+	Size          int64     `json:"size"`
+	Subject       string    `json:"subject"`
+	PredicateType string    `json:"predicate_type"`
+	Builder       string    `json:"builder,omitempty"`
+	Verified      bool      `json:"verified"`
+	UploadedAt    time.Time `json:"uploaded_at"`
+}
+
+// Policy actions.
+const (
+	PolicyActionUpload    = "upload"
+	PolicyActionDelete    = "delete"
+	PolicyActionPromote   = "promote"
+	PolicyActionDeprecate = "deprecate"
+	PolicyActionPin       = "pin"
+)
+
+// Malware scan results.
+const (
+	MalwareClean    = "clean"
+	MalwareInfected = "infected"
+)
+
+// MalwareScan records the malware scan of a blob. Signature names what was
+// found in an infected blob.
+type MalwareScan struct {
+	Hash      string    `json:"hash"`
+	Result    string    `json:"result"`
+	Signature string    `json:"signature,omitempty"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+// BlobDigests records a blob's digests besides its SHA-256, which is its
+// Hash. BLAKE3 is empty when the digest was not computed.
+type BlobDigests struct {
+	Hash       string    `json:"hash"`
+	SHA512     string    `json:"sha512"`
+	BLAKE3     string    `json:"blake3,omitempty"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// Hook points, the moments in a request at which hooks run.
+const (
+	HookPreUpload   = "pre-upload"
+	HookPostUpload  = "post-upload"
+	HookPreDownload = "pre-download"
+	HookPreDelete   = "pre-delete"
+)
+
+// HookEvent describes a request to the hooks run at one of its hook points.
+// File names the file uploaded or downloaded, or the file deleted when a
+// delete removes one file rather than the whole version. Hash, Size and
+// Format describe the blob uploaded or downloaded and are empty for deletes.
+type HookEvent struct {
+	Hook      string     `json:"hook"`
+	Package   string     `json:"package"`
+	Version   string     `json:"version"`
+	File      string     `json:"file,omitempty"`
+	Hash      string     `json:"hash,omitempty"`
+	Size      int64      `json:"size,omitempty"`
+	Format    string     `json:"format,omitempty"`
+	Principal *Principal `json:"principal,omitempty"`
+	ClientIP  string     `json:"client_ip,omitempty"`
+}
+
+// PolicyRequest describes an operation submitted to the policy engine. File
+// names the file being uploaded or deleted when it is not the whole version,
+// and Stage the stage a version is promoted to. Format is set on uploads
+// once their content is stored and classified. ClientIP is the caller's
+// address, resolved through any trusted proxies.
+type PolicyRequest struct {
+	Action    string     `json:"action"`
+	Package   string     `json:"package"`
+	Version   string     `json:"version"`
+	File      string     `json:"file,omitempty"`
+	Stage     string     `json:"stage,omitempty"`
+	Format    string     `json:"format,omitempty"`
+	Principal *Principal `json:"principal,omitempty"`
+	ClientIP  string     `json:"client_ip,omitempty"`
+}
+
+// SearchResult is a package found by a federated search, with the
+// registries holding a package of that name: this one first, then peers
+// in the order they are configured.
+type SearchResult struct {
+	Name       string   `json:"name"`
+	Registries []string `json:"registries"`
+}
+
+// SearchResponse is the outcome of a federated search. Partial is set when
+// some peers could not be searched in time; Errors says which and why.
+type SearchResponse struct {
+	Results []SearchResult `json:"results"`
+	Partial bool           `json:"partial"`
+	Errors  []PeerError    `json:"errors,omitempty"`
+}
+
+// PeerError is why a peer registry's results are missing from a search.
+type PeerError struct {
+	Registry string `json:"registry"`
+	Error    string `json:"error"`
+}
+
+// InflightRequest is a request the server is still handling. BytesIn is
+// how much of the request body has been read and BytesOut how much of the
+// response has been written so far.
+type InflightRequest struct {
+	ID             string    `json:"id"`
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	ClientIP       string    `json:"client_ip,omitempty"`
+	StartedAt      time.Time `json:"started_at"`
+	DurationMillis int64     `json:"duration_ms"`
+	BytesIn        int64     `json:"bytes_in"`
+	BytesOut       int64     `json:"bytes_out"`
+}
+
+// States of an upload tracked for progress reporting. After the last byte
+// is received the upload is storing until its blob is finalized, then
+// verifying its digest and pre-upload checks, recording its metadata,
+// committing its blob into place and indexing the archive's contents.
+const (
+	UploadReceiving  = "receiving"
+	UploadStoring    = "storing"
+	UploadVerifying  = "verifying"
+	UploadRecording  = "recording"
+	UploadCommitting = "committing"
+	UploadIndexing   = "indexing"
+	UploadDone       = "done"
+	UploadFailed     = "failed"
+)
+
+// UploadProgress is how far the server has got with an upload the client
+// named in X-Upload-ID. TotalBytes is the request's Content-Length, 0 when
+// unknown; ReceivedBytes counts the request body, so both include any
+// multipart framing. Status is the HTTP status the upload answered with,
+// once it has finished.
+type UploadProgress struct {
+	ID            string     `json:"id"`
+	Package       string     `json:"package"`
+	Version       string     `json:"version"`
+	File          string     `json:"file,omitempty"`
+	State         string     `json:"state"`
+	ReceivedBytes int64      `json:"received_bytes"`
+	TotalBytes    int64      `json:"total_bytes,omitempty"`
+	StartedAt     time.Time  `json:"started_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	Status        int        `json:"status,omitempty"`
+}
+
+// How an imported file was taken into blob storage: cloned, hard-linked
+// or, when neither works, copied.
+const (
+	ImportReflink  = "reflink"
+	ImportHardLink = "hardlink"
+	ImportCopy     = "copy"
+)
+
+// ImportRequest publishes a version from a file on the server's own
+// filesystem. Path must be inside one of the server's import directories.
+type ImportRequest struct {
+	Path         string            `json:"path"`
+	Filename     string            `json:"filename,omitempty"`
+	ContentType  string            `json:"content_type,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Dependencies []Dependency      `json:"dependencies,omitempty"`
+	// Hash, if set, is checked against the file's SHA-256.
+	Hash string `json:"hash,omitempty"`
+	// Link lets a file that cannot be cloned be hard-linked rather than
+	// copied. The blob is then the original file, which must never be
+	// changed afterwards.
+	Link bool `json:"link,omitempty"`
+}
+
+// ImportResponse describes an imported version and how its file was taken
+// in.
+type ImportResponse struct {
+	UploadResponse
+	Import string `json:"import"`
 }
@@ -7,4 +7,11 @@ var (
 	ErrNotFound = errors.New("not found")
 	// ErrConflict indicates a uniqueness or state conflict.
 	ErrConflict = errors.New("conflict")
+	// ErrPolicyDenied indicates the policy engine refused an operation.
+	ErrPolicyDenied = errors.New("denied by policy")
+	// ErrHookRejected indicates a hook refused a request.
+	ErrHookRejected = errors.New("rejected by hook")
+	// ErrReadOnly indicates a change to data served from a read-only
+	// snapshot.
+	ErrReadOnly = errors.New("read-only")
 )
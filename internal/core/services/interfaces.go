@@ -1,7 +1,9 @@
 package services
 
 import (
+	"context"
 	"io"
+	"time"
 
 	"github.com/foundry/registry/internal/core/models"
 )
@@ -12,12 +14,20 @@ type BlobStorage interface {
 	// Returns the hex-encoded hash and total bytes written.
 	Store(r io.Reader) (hash string, size int64, err error)
 
+	// Stage streams data to disk like Store but holds the blob back until
+	// the returned StagedBlob is committed, so callers can record metadata
+	// first and drop the bytes if that fails.
+	Stage(r io.Reader) (StagedBlob, error)
+
 	// Open returns a ReadCloser for the blob with the given hash.
 	Open(hash string) (io.ReadCloser, error)
 
 	// Exists checks if a blob with the given hash exists.
 	Exists(hash string) bool
 
+	// Size returns the blob's length in bytes, or ErrNotFound.
+	Size(hash string) (int64, error)
+
 	// Delete removes a blob by hash.
 	Delete(hash string) error
 
@@ -28,6 +38,100 @@ type BlobStorage interface {
 	ListBlobs() ([]string, error)
 }
 
+// StagedBlob is an upload written by BlobStorage.Stage but not yet visible
+// to Open, Exists or ListBlobs.
+type StagedBlob interface {
+	// Hash returns the hex-encoded SHA256 of the staged data.
+	Hash() string
+
+	// Size returns the staged data's length in bytes.
+	Size() int64
+
+	// Commit publishes the blob. Committing data that is already stored
+	// succeeds without change.
+	Commit() error
+
+	// Discard drops the staged data. It does nothing after Commit, so it
+	// can be deferred.
+	Discard() error
+}
+
+// URLSigner is implemented by blob storage backends that can hand out
+// short-lived direct download URLs (for example S3 presigned URLs), letting
+// clients fetch bytes without proxying them through the registry.
+type URLSigner interface {
+	// SignedURL returns a URL granting read access to the blob for ttl.
+	SignedURL(hash string, ttl time.Duration) (string, error)
+}
+
+// SpaceReporter is implemented by blob storage backends that can tell how
+// much room is left for new blobs, so uploads can be refused before the
+// disk fills up.
+type SpaceReporter interface {
+	// FreeSpace returns the bytes available for new blobs.
+	FreeSpace() (int64, error)
+}
+
+// BlobImporter is implemented by blob storage backends that can take in a
+// local file without copying it, for importing files the server can
+// already see.
+type BlobImporter interface {
+	// StageFile stages the file at path like Stage and reports how it was
+	// taken in: models.ImportReflink, ImportHardLink or ImportCopy. Hard
+	// links, which leave the blob open to changes to the original, are
+	// only made if link is set.
+	StageFile(path string, link bool) (StagedBlob, string, error)
+}
+
+// WriteMetrics is implemented by blob storage backends that time the
+// blobs they write.
+type WriteMetrics interface {
+	// WriteStats reports write throughput since the backend was opened.
+	WriteStats() models.BlobWriteStats
+}
+
+// TempCleaner is implemented by blob storage backends that keep uploads in
+// temporary files while they are staged, which a crash leaves behind.
+type TempCleaner interface {
+	// CleanTemp removes the temporary files of uploads staged before
+	// before, returning how many it removed and the bytes reclaimed.
+	CleanTemp(before time.Time) (files int, bytes int64, err error)
+}
+
+// RoutedStorage is blob storage spread over several backends, each holding
+// the blobs of the packages routed to it by name. Reads find a blob in
+// whichever backend holds it, so callers that know only a hash see one
+// store.
+type RoutedStorage interface {
+	BlobStorage
+
+	// Route returns the backend new blobs of package pkg are written to.
+	Route(pkg string) BlobStorage
+
+	// Place copies a stored blob into the backend routed to pkg, unless
+	// that backend holds it already.
+	Place(pkg, hash string) error
+}
+
+// TieredStorage is blob storage split into a hot tier, where new blobs go,
+// and a cheaper cold tier. Reads fall through to the cold tier, so callers
+// see one store.
+type TieredStorage interface {
+	BlobStorage
+
+	// Offload moves a blob to the cold tier. Blobs already there are left
+	// as they are.
+	Offload(hash string) error
+
+	// Restore moves a blob back to the hot tier. Blobs already there are
+	// left as they are.
+	Restore(hash string) error
+
+	// Tier reports which tier holds the blob, models.TierHot or
+	// models.TierCold, or ErrNotFound.
+	Tier(hash string) (string, error)
+}
+
 // MetadataStore handles artifact metadata in a database.
 type MetadataStore interface {
 	// CreatePackage creates a package if it doesn't exist, returns its ID.
@@ -43,26 +147,426 @@ type MetadataStore interface {
 	SearchPackages(query string) ([]models.Package, error)
 
 	// CreateArtifact stores artifact metadata.
-	CreateArtifact(packageID int64, version, hash string, size int64) (*models.Artifact, error)
+	CreateArtifact(packageID int64, in models.ArtifactInput) (*models.Artifact, error)
+
+	// CreateArtifactForPackage creates the package if it doesn't exist and
+	// stores the artifact in one transaction, so a version that cannot be
+	// recorded leaves no package behind. A taken version returns
+	// ErrConflict.
+	CreateArtifactForPackage(packageName string, in models.ArtifactInput) (*models.Artifact, error)
 
-	// GetArtifact retrieves an artifact by package name and version.
+	// GetArtifact retrieves an artifact by package name and version,
+	// including its scan report's vulnerability summary.
 	GetArtifact(packageName, version string) (*models.Artifact, error)
 
 	// ListArtifacts lists all artifacts for a package.
 	ListArtifacts(packageName string) ([]models.Artifact, error)
 
-	// DeleteArtifact deletes an artifact by package name and version.
+	// FindArtifacts lists the artifacts matching f, in any package unless
+	// f.Package is set, newest first.
+	FindArtifacts(f models.ArtifactFilter) ([]models.Artifact, error)
+
+	// SetQuarantined sets whether a version awaits approval, or returns
+	// ErrNotFound.
+	SetQuarantined(packageName, version string, quarantined bool) error
+
+	// ListQuarantined lists the versions awaiting approval, oldest first.
+	ListQuarantined() ([]models.Artifact, error)
+
+	// SetStage moves a version to stage, recording promotedBy and the
+	// current time as its last promotion, or returns ErrNotFound.
+	SetStage(packageName, version, stage, promotedBy string) error
+
+	// ListExpired lists the unpinned versions whose expiry is at or before
+	// now, oldest expiry first.
+	ListExpired(now time.Time) ([]models.Artifact, error)
+
+	// SetPinned sets whether a version is protected from deletion, or
+	// returns ErrNotFound.
+	SetPinned(packageName, version string, pinned bool) error
+
+	// SetDeprecation deprecates a version or, with d nil, clears its
+	// deprecation, or returns ErrNotFound.
+	SetDeprecation(packageName, version string, d *models.Deprecation) error
+
+	// SetPackageDeprecation deprecates a whole package or, with d nil,
+	// clears its deprecation, or returns ErrNotFound.
+	SetPackageDeprecation(packageName string, d *models.Deprecation) error
+
+	// SetReleaseNotes replaces the release notes of a version, clearing
+	// them when notes is empty, or returns ErrNotFound.
+	SetReleaseNotes(packageName, version, notes string) error
+
+	// DeleteArtifact deletes an artifact by package name and version,
+	// along with its assets. A pinned version returns ErrConflict.
 	DeleteArtifact(packageName, version string) error
 
-	// ReferencedHashes returns all hashes referenced by artifacts.
+	// CreateAsset attaches a named file to an artifact, or returns
+	// ErrConflict if the name is taken.
+	CreateAsset(artifactID int64, in models.AssetInput) (*models.Asset, error)
+
+	// GetAsset retrieves an asset by name, or nil if it does not exist.
+	GetAsset(packageName, version, name string) (*models.Asset, error)
+
+	// ListAssets lists the assets of a version by name. The artifact's
+	// default file is not included.
+	ListAssets(packageName, version string) ([]models.Asset, error)
+
+	// DeleteAsset removes a named asset, or returns ErrNotFound.
+	DeleteAsset(packageName, version, name string) error
+
+	// SetDependencies replaces the dependencies of an artifact version, or
+	// returns ErrNotFound.
+	SetDependencies(packageName, version string, deps []models.Dependency) error
+
+	// ListDependencies returns the dependencies of an artifact version in
+	// the order they were set.
+	ListDependencies(packageName, version string) ([]models.Dependency, error)
+
+	// ListDependents returns up to limit artifact versions depending on a
+	// package, ordered by package name then upload. With after set, the page
+	// starts past that dependent.
+	ListDependents(packageName string, after *models.Dependent, limit int) ([]models.Dependent, error)
+
+	// SetSBOM attaches an SBOM and its components to an artifact version,
+	// replacing any earlier one, or returns ErrNotFound.
+	SetSBOM(packageName, version string, sbom models.SBOM, components []models.SBOMComponent) (*models.SBOM, error)
+
+	// GetSBOM retrieves the SBOM of a version, or nil if it has none.
+	GetSBOM(packageName, version string) (*models.SBOM, error)
+
+	// PackagesWithComponent returns packages with a version whose SBOM lists
+	// the component, matched by name or package URL. A non-empty version
+	// must match too.
+	PackagesWithComponent(component, version string) ([]models.Package, error)
+
+	// SetScanReport attaches a vulnerability scan report to an artifact
+	// version, replacing any earlier one, or returns ErrNotFound.
+	SetScanReport(packageName, version string, report models.ScanReport) (*models.ScanReport, error)
+
+	// GetScanReport retrieves the scan report of a version, or nil if it
+	// has none.
+	GetScanReport(packageName, version string) (*models.ScanReport, error)
+
+	// AddAttestation attaches an attestation to an artifact version,
+	// assigning its ID, or returns ErrNotFound.
+	AddAttestation(packageName, version string, att models.Attestation) (*models.Attestation, error)
+
+	// ListAttestations returns the attestations of a version in the order
+	// they were added.
+	ListAttestations(packageName, version string) ([]models.Attestation, error)
+
+	// SetContents records the archive listing of a blob, replacing any
+	// earlier one.
+	SetContents(contents models.Contents) error
+
+	// GetContents retrieves the archive listing of a blob, or nil if it has
+	// not been indexed.
+	GetContents(hash string) (*models.Contents, error)
+
+	// PruneContents removes the listings of blobs no artifact or asset
+	// references.
+	PruneContents() error
+
+	// SetMalwareScan records the malware scan of a blob, replacing any
+	// earlier one. Scans may be recorded before anything references the
+	// blob.
+	SetMalwareScan(scan models.MalwareScan) error
+
+	// GetMalwareScan retrieves the malware scan of a blob, or nil if it
+	// has not been scanned.
+	GetMalwareScan(hash string) (*models.MalwareScan, error)
+
+	// PruneMalwareScans removes the scans made before before of blobs
+	// nothing references. Later scans are kept for uploads that have not
+	// recorded their metadata yet.
+	PruneMalwareScans(before time.Time) error
+
+	// SetBlobDigests records the digests of a blob, replacing any earlier
+	// ones. Like malware scans, digests may be recorded before anything
+	// references the blob.
+	SetBlobDigests(d models.BlobDigests) error
+
+	// GetBlobDigests retrieves the digests of a blob, or nil if they have
+	// not been computed.
+	GetBlobDigests(hash string) (*models.BlobDigests, error)
+
+	// PruneBlobDigests removes the digests computed before before of blobs
+	// nothing references.
+	PruneBlobDigests(before time.Time) error
+
+	// SetBlobFormat records the format a blob's content was classified as,
+	// replacing any earlier one. Like malware scans, formats may be
+	// recorded before anything references the blob.
+	SetBlobFormat(hash, format string) error
+
+	// GetBlobFormat retrieves the format of a blob, or "" if it has not
+	// been classified.
+	GetBlobFormat(hash string) (string, error)
+
+	// PruneBlobFormats removes the formats recorded before before of blobs
+	// nothing references.
+	PruneBlobFormats(before time.Time) error
+
+	// RecordHistory appends an event to a package's history, stamping it
+	// with the current time. A create that follows the deletion of the same
+	// version or file is recorded as an overwrite when the content differs,
+	// with the deleted hash as OldHash.
+	RecordHistory(e models.HistoryEvent) error
+
+	// ListHistory lists a package's history newest first, limited to one
+	// version when version is set. Only events with IDs below beforeID are
+	// returned when it is positive.
+	ListHistory(packageName, version string, beforeID int64, limit int) ([]models.HistoryEvent, error)
+
+	// ReferencedHashes returns all hashes referenced by artifacts, assets,
+	// SBOMs and scan reports.
 	ReferencedHashes() (map[string]bool, error)
 
+	// ReleasedBlobs returns up to limit hashes after the given one, in
+	// order, whose last reference has been removed but whose blob has not
+	// been forgotten.
+	ReleasedBlobs(after string, limit int) ([]string, error)
+
+	// ForgetBlob drops the reference count of hash if nothing references
+	// it, reporting whether its blob may be deleted. It returns false if
+	// the blob is referenced again, and true for blobs it never counted.
+	ForgetBlob(hash string) (bool, error)
+
+	// TouchBlob records that the blob was just read.
+	TouchBlob(hash string) error
+
+	// RecordDownload adds downloads and bytes to the day's totals for
+	// pkg@version and the principal that downloaded it.
+	RecordDownload(packageName, version, principal string, downloads, bytes int64) error
+
+	// DownloadStats totals downloads on the days from to to, inclusive and
+	// formatted YYYY-MM-DD, grouped by the dimensions in groupBy, which are
+	// models.ReportBy* constants. Rows are ordered by group.
+	DownloadStats(from, to string, groupBy []string) ([]models.DownloadStat, error)
+
+	// ListIdleBlobs returns up to limit referenced hashes after the given
+	// one, in order, that are in the hot tier and were last read, or
+	// uploaded if never read, before idleSince.
+	ListIdleBlobs(idleSince time.Time, after string, limit int) ([]string, error)
+
+	// SetBlobTier records which storage tier holds the blob.
+	SetBlobTier(hash, tier string) error
+
+	// ListFileRefs returns the hash of every version's default file and
+	// named files, ordered by package, version and file name.
+	ListFileRefs() ([]models.FileRef, error)
+
+	// Stats returns package, artifact and referenced blob totals. Stored blob
+	// fields are left for the caller to fill from blob storage.
+	Stats() (*models.RegistryStats, error)
+
+	// Usage returns the storage taken up by each package and namespace
+	// with at least one file, ordered by name, and in total. It reads
+	// per-package blob references kept up to date as files are added and
+	// removed, without scanning every version.
+	Usage() (*models.UsageReport, error)
+
 	// Close closes the metadata store.
 	Close() error
 }
 
+// QueryMetrics is implemented by metadata stores that time their queries.
+type QueryMetrics interface {
+	// QueryStats reports query latency by the store method that ran the
+	// queries.
+	QueryStats() []models.QueryStat
+}
+
 // Authenticator validates request tokens.
 type Authenticator interface {
-	// ValidateToken checks if a token is valid.
-	ValidateToken(token string) bool
+	// Authenticate returns the principal for a valid token, or ok=false.
+	Authenticate(token string) (principal *models.Principal, ok bool)
+}
+
+// TokenStore persists issued API tokens by the SHA256 of their secret.
+type TokenStore interface {
+	// CreateToken records a token and returns its metadata.
+	CreateToken(name, secretHash string, admin bool) (*models.APIToken, error)
+
+	// LookupToken returns the active token with the given secret hash, or
+	// nil if none exists or it was revoked.
+	LookupToken(secretHash string) (*models.APIToken, error)
+
+	// ListTokens returns all tokens, including revoked ones.
+	ListTokens() ([]models.APIToken, error)
+
+	// RevokeToken marks a token revoked, or returns ErrNotFound.
+	RevokeToken(id int64) error
+}
+
+// TokenUsageStore is implemented by token stores that keep usage totals
+// for the tokens they issue.
+type TokenUsageStore interface {
+	// AddTokenUsage adds the requests and bytes of each entry to its
+	// token's totals and moves its last use forward to LastUsedAt. Entries
+	// of tokens that no longer exist are dropped.
+	AddTokenUsage(usage []models.TokenUsage) error
+
+	// TokenUsage returns the totals of token id, or ErrNotFound if there
+	// is no such token.
+	TokenUsage(id int64) (*models.TokenUsage, error)
+}
+
+// AccountStore persists users, teams and the teams owning packages.
+// Names identify users and teams; missing ones give ErrNotFound and
+// duplicates ErrConflict.
+type AccountStore interface {
+	CreateUser(name string) (*models.User, error)
+
+	// GetUser returns the user, or nil if there is none by that name.
+	GetUser(name string) (*models.User, error)
+
+	ListUsers() ([]models.User, error)
+
+	// DeleteUser removes the user from its teams and revokes its tokens.
+	DeleteUser(name string) error
+
+	// SetTokenUser records the user token id acts for.
+	SetTokenUser(tokenID int64, user string) error
+
+	// CreateTeam creates a team without members.
+	CreateTeam(name, ssoGroup string) (*models.Team, error)
+
+	// GetTeam returns the team with its members, or nil if there is none
+	// by that name.
+	GetTeam(name string) (*models.Team, error)
+
+	ListTeams() ([]models.Team, error)
+
+	// DeleteTeam removes the team and the ownerships it held.
+	DeleteTeam(name string) error
+
+	// AddTeamMember adds user to team; adding a member again is a no-op.
+	AddTeamMember(team, user string) error
+
+	RemoveTeamMember(team, user string) error
+
+	// AddPackageOwner makes team an owner of the package name, which need
+	// not exist yet; adding an owner again is a no-op.
+	AddPackageOwner(packageName, team string) error
+
+	RemovePackageOwner(packageName, team string) error
+
+	// PackageOwners returns the teams owning a package, with their
+	// members, by team name.
+	PackageOwners(packageName string) ([]models.Team, error)
+}
+
+// ApprovalStore persists operations awaiting approval, and keeps them once
+// decided.
+type ApprovalStore interface {
+	// CreateApproval records a pending approval and returns it with its ID
+	// and request time.
+	CreateApproval(a models.Approval) (*models.Approval, error)
+
+	// GetApproval returns the approval, or nil if there is none with id.
+	GetApproval(id int64) (*models.Approval, error)
+
+	// ListApprovals returns the approvals in status, or all of them if
+	// status is empty, newest first.
+	ListApprovals(status string) ([]models.Approval, error)
+
+	// DecideApproval records the decision on a pending approval: its new
+	// status, who decided and the result. It returns ErrNotFound for
+	// unknown approvals and ErrConflict for decided ones.
+	DecideApproval(id int64, status, by, result string) error
+}
+
+// CommentStore persists comments on packages and versions. The comments on
+// a version are deleted with it.
+type CommentStore interface {
+	// AddComment records c and returns it with its ID and creation time,
+	// or ErrNotFound if its package or version does not exist.
+	AddComment(c models.Comment) (*models.Comment, error)
+
+	// ListComments returns the comments on a package and its versions,
+	// oldest first.
+	ListComments(packageName string) ([]models.Comment, error)
+
+	// GetComment returns the comment, or nil if there is none with id.
+	GetComment(id int64) (*models.Comment, error)
+
+	// DeleteComment removes a comment, or returns ErrNotFound.
+	DeleteComment(id int64) error
+}
+
+// SubscriptionStore persists notification subscriptions.
+type SubscriptionStore interface {
+	// CreateSubscription records a subscription and returns it with its ID
+	// and creation time.
+	CreateSubscription(sub models.Subscription) (*models.Subscription, error)
+
+	// ListSubscriptions returns every subscription, secrets included, in
+	// creation order.
+	ListSubscriptions() ([]models.Subscription, error)
+
+	// DeleteSubscription removes a subscription, or returns ErrNotFound.
+	DeleteSubscription(id int64) error
+}
+
+// Notifier delivers notifications over the channels of subscriptions.
+type Notifier interface {
+	// Validate returns an error describing why notifications could not be
+	// delivered to sub, such as an unsupported channel or a malformed
+	// target.
+	Validate(sub models.Subscription) error
+
+	// Notify delivers n to sub.
+	Notify(ctx context.Context, sub models.Subscription, n models.Notification) error
+}
+
+// CrateIndex stores the Cargo index entries of crate versions, which are
+// artifacts whose default file is the .crate archive.
+type CrateIndex interface {
+	// CreateCrateVersion records the index entry of an artifact, or returns
+	// ErrConflict if it already has one.
+	CreateCrateVersion(artifactID int64, entry string) error
+
+	// ListCrateVersions returns a package's crate versions in publish order.
+	ListCrateVersions(packageName string) ([]models.CrateVersion, error)
+
+	// SetCrateYanked sets the yanked flag of a crate version, or returns
+	// ErrNotFound.
+	SetCrateYanked(packageName, version string, yanked bool) error
+}
+
+// PolicyEngine decides whether an upload or delete may proceed.
+type PolicyEngine interface {
+	// Evaluate returns nil to allow the request, an error wrapping
+	// ErrPolicyDenied that gives the reason to refuse it, or any other
+	// error when no decision could be made.
+	Evaluate(ctx context.Context, req models.PolicyRequest) error
+}
+
+// Hook is extension code run at the hook points of uploads, downloads and
+// deletes.
+type Hook interface {
+	// Run returns nil to let the request continue, an error wrapping
+	// ErrHookRejected that gives the reason to refuse it, or any other
+	// error when the hook failed. Errors from post-upload hooks are
+	// logged; the upload has already succeeded.
+	Run(ctx context.Context, event models.HookEvent) error
+}
+
+// MalwareScanner checks content for malware.
+type MalwareScanner interface {
+	// Scan reads r and returns the signature of the malware found in it,
+	// or "" if it is clean. An error means there is no verdict.
+	Scan(ctx context.Context, r io.Reader) (signature string, err error)
+}
+
+// PeerRegistry is another registry that searches fan out to.
+type PeerRegistry interface {
+	// Name identifies the registry in search results.
+	Name() string
+
+	// SearchPackages returns the packages whose names contain query, or
+	// every package for an empty query.
+	SearchPackages(ctx context.Context, query string) ([]models.Package, error)
 }
@@ -0,0 +1,221 @@
+// Package archive lists the files inside tar, gzipped tar and zip archives
+// and reads single files out of them without unpacking the rest.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Formats reported by List.
+const (
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+	FormatZip   = "zip"
+)
+
+// Errors returned by List and Extract.
+var (
+	ErrNotArchive = errors.New("not a tar or zip archive")
+	ErrNoEntry    = errors.New("no such file in archive")
+	ErrNotRegular = errors.New("archive entry is not a regular file")
+)
+
+// Entry is one file, directory or link in an archive. Mode is formatted
+// like ls -l, e.g. "-rw-r--r--" or "drwxr-xr-x".
+type Entry struct {
+	Path string
+	Size int64
+	Mode string
+}
+
+// Listing is the table of contents of an archive. Truncated is set when the
+// archive holds more entries than were listed.
+type Listing struct {
+	Format    string
+	Entries   []Entry
+	Truncated bool
+}
+
+// List reads the table of contents of the size bytes at r, stopping after
+// limit entries. The format is detected from the content, not a filename.
+func List(r io.ReaderAt, size int64, limit int) (*Listing, error) {
+	format, err := detect(r)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatZip:
+		return listZip(r, size, limit)
+	case FormatTarGz:
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, ErrNotArchive
+		}
+		defer gz.Close()
+		return listTar(gz, FormatTarGz, limit)
+	default:
+		return listTar(io.NewSectionReader(r, 0, size), FormatTar, limit)
+	}
+}
+
+// Extract opens the regular file called name in the archive of size bytes
+// at r, returning its uncompressed size. Names are compared after Clean, so
+// "./etc/app.yaml" finds "etc/app.yaml". Tar archives are scanned up to the
+// entry; the caller must close the reader.
+func Extract(r io.ReaderAt, size int64, name string) (io.ReadCloser, int64, error) {
+	format, err := detect(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	name = Clean(name)
+	switch format {
+	case FormatZip:
+		return extractZip(r, size, name)
+	case FormatTarGz:
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, 0, ErrNotArchive
+		}
+		rc, n, err := extractTar(gz, name, gz)
+		if err != nil {
+			gz.Close()
+		}
+		return rc, n, err
+	default:
+		return extractTar(io.NewSectionReader(r, 0, size), name, io.NopCloser(nil))
+	}
+}
+
+// Clean normalizes an archive member name: slash separated, relative, with
+// no "." or ".." elements. Directory names lose their trailing slash.
+func Clean(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// detect reports the archive format of the data at r from its first bytes.
+func detect(r io.ReaderAt) (string, error) {
+	head := make([]byte, 512)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return FormatZip, nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return FormatTarGz, nil
+	case isTar(head):
+		return FormatTar, nil
+	}
+	return "", ErrNotArchive
+}
+
+// isTar checks for the ustar magic shared by POSIX and GNU tar headers.
+func isTar(head []byte) bool {
+	return len(head) >= 263 && bytes.Equal(head[257:262], []byte("ustar"))
+}
+
+func listTar(r io.Reader, format string, limit int) (*Listing, error) {
+	listing := &Listing{Format: format, Entries: []Entry{}}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return listing, nil
+		}
+		if err != nil {
+			// Gzipped data that does not hold a tar is not an archive.
+			if len(listing.Entries) == 0 && format == FormatTarGz {
+				return nil, ErrNotArchive
+			}
+			return nil, fmt.Errorf("reading tar: %w", err)
+		}
+		if len(listing.Entries) == limit {
+			listing.Truncated = true
+			return listing, nil
+		}
+		listing.Entries = append(listing.Entries, Entry{
+			Path: hdr.Name,
+			Size: hdr.Size,
+			Mode: hdr.FileInfo().Mode().String(),
+		})
+	}
+}
+
+func listZip(r io.ReaderAt, size int64, limit int) (*Listing, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("reading zip: %w", err)
+	}
+	listing := &Listing{Format: FormatZip, Entries: []Entry{}}
+	for _, f := range zr.File {
+		if len(listing.Entries) == limit {
+			listing.Truncated = true
+			break
+		}
+		listing.Entries = append(listing.Entries, Entry{
+			Path: f.Name,
+			Size: int64(f.UncompressedSize64),
+			Mode: f.Mode().String(),
+		})
+	}
+	return listing, nil
+}
+
+// extractTar scans to the entry called name. closer is closed with the
+// returned reader, releasing any decompressor underneath the tar stream.
+func extractTar(r io.Reader, name string, closer io.Closer) (io.ReadCloser, int64, error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, 0, ErrNoEntry
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading tar: %w", err)
+		}
+		if Clean(hdr.Name) != name {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, 0, ErrNotRegular
+		}
+		return readCloser{tr, closer}, hdr.Size, nil
+	}
+}
+
+func extractZip(r io.ReaderAt, size int64, name string) (io.ReadCloser, int64, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading zip: %w", err)
+	}
+	for _, f := range zr.File {
+		if Clean(f.Name) != name {
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			return nil, 0, ErrNotRegular
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading zip: %w", err)
+		}
+		return rc, int64(f.UncompressedSize64), nil
+	}
+	return nil, 0, ErrNoEntry
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,130 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+)
+
+func tarball(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0o755})
+	tw.WriteHeader(&tar.Header{Name: "bin/tool", Mode: 0o755, Size: 4})
+	tw.Write([]byte("tool"))
+	tw.WriteHeader(&tar.Header{Name: "README", Mode: 0o644, Size: 2})
+	tw.Write([]byte("hi"))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestListTar(t *testing.T) {
+	data := tarball(t)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data)
+	zw.Close()
+
+	for format, archive := range map[string][]byte{FormatTar: data, FormatTarGz: gz.Bytes()} {
+		listing, err := List(bytes.NewReader(archive), int64(len(archive)), 100)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if listing.Format != format || len(listing.Entries) != 3 || listing.Truncated {
+			t.Fatalf("%s: unexpected listing %+v", format, listing)
+		}
+		if e := listing.Entries[1]; e.Path != "bin/tool" || e.Size != 4 || e.Mode != "-rwxr-xr-x" {
+			t.Errorf("%s: entry = %+v", format, e)
+		}
+		if e := listing.Entries[0]; e.Mode != "drwxr-xr-x" {
+			t.Errorf("%s: directory mode = %q", format, e.Mode)
+		}
+	}
+
+	listing, err := List(bytes.NewReader(data), int64(len(data)), 2)
+	if err != nil || len(listing.Entries) != 2 || !listing.Truncated {
+		t.Errorf("limit: %+v %v", listing, err)
+	}
+}
+
+func TestListZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, _ := zw.Create("pkg/__init__.py")
+	fw.Write([]byte("print(1)\n"))
+	zw.Close()
+
+	listing, err := List(bytes.NewReader(buf.Bytes()), int64(buf.Len()), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listing.Format != FormatZip || len(listing.Entries) != 1 {
+		t.Fatalf("unexpected listing %+v", listing)
+	}
+	if e := listing.Entries[0]; e.Path != "pkg/__init__.py" || e.Size != 9 {
+		t.Errorf("entry = %+v", e)
+	}
+}
+
+func TestListRejectsOtherData(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(bytes.Repeat([]byte("not a tar "), 100))
+	zw.Close()
+
+	for name, data := range map[string][]byte{
+		"text":      []byte("just some text"),
+		"empty":     nil,
+		"plain gz":  gz.Bytes(),
+		"short tar": []byte("x"),
+	} {
+		if _, err := List(bytes.NewReader(data), int64(len(data)), 100); !errors.Is(err, ErrNotArchive) {
+			t.Errorf("%s: expected ErrNotArchive, got %v", name, err)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	data := tarball(t)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(data)
+	gw.Close()
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	fw, _ := zw.Create("bin/tool")
+	fw.Write([]byte("tool"))
+	zw.Create("bin/")
+	zw.Close()
+
+	for format, archive := range map[string][]byte{FormatTar: data, FormatTarGz: gz.Bytes(), FormatZip: zbuf.Bytes()} {
+		ra := bytes.NewReader(archive)
+		rc, size, err := Extract(ra, int64(len(archive)), "./bin/tool")
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(got) != "tool" || size != 4 {
+			t.Errorf("%s: extracted %q (size %d)", format, got, size)
+		}
+		if _, _, err := Extract(ra, int64(len(archive)), "missing"); !errors.Is(err, ErrNoEntry) {
+			t.Errorf("%s: missing entry: %v", format, err)
+		}
+		if _, _, err := Extract(ra, int64(len(archive)), "bin"); !errors.Is(err, ErrNotRegular) {
+			t.Errorf("%s: directory: %v", format, err)
+		}
+	}
+
+	text := []byte("just some text")
+	if _, _, err := Extract(bytes.NewReader(text), int64(len(text)), "x"); !errors.Is(err, ErrNotArchive) {
+		t.Errorf("expected ErrNotArchive, got %v", err)
+	}
+}
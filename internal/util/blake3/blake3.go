@@ -0,0 +1,255 @@
+// Package blake3 implements the BLAKE3 hash function with its default
+// 32-byte output. It follows the portable reference implementation: one
+// chunk at a time, without SIMD, which is fast enough to hash uploads as
+// they stream in.
+package blake3
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// Size is the length of a BLAKE3 digest in bytes.
+const Size = 32
+
+// BlockSize is the BLAKE3 block size in bytes.
+const BlockSize = 64
+
+const chunkLen = 1024
+
+// Domain separation flags.
+const (
+	chunkStart = 1 << 0
+	chunkEnd   = 1 << 1
+	parent     = 1 << 2
+	root       = 1 << 3
+)
+
+var iv = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+var msgPermutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func g(s *[16]uint32, a, b, c, d int, mx, my uint32) {
+	s[a] += s[b] + mx
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] += s[b] + my
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+func round(s *[16]uint32, m *[16]uint32) {
+	// Mix the columns.
+	g(s, 0, 4, 8, 12, m[0], m[1])
+	g(s, 1, 5, 9, 13, m[2], m[3])
+	g(s, 2, 6, 10, 14, m[4], m[5])
+	g(s, 3, 7, 11, 15, m[6], m[7])
+	// Mix the diagonals.
+	g(s, 0, 5, 10, 15, m[8], m[9])
+	g(s, 1, 6, 11, 12, m[10], m[11])
+	g(s, 2, 7, 8, 13, m[12], m[13])
+	g(s, 3, 4, 9, 14, m[14], m[15])
+}
+
+func permute(m *[16]uint32) {
+	var p [16]uint32
+	for i, j := range msgPermutation {
+		p[i] = m[j]
+	}
+	*m = p
+}
+
+func compress(cv *[8]uint32, block *[16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		iv[0], iv[1], iv[2], iv[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	m := *block
+	for i := 0; i < 7; i++ {
+		round(&s, &m)
+		if i < 6 {
+			permute(&m)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+func first8(words [16]uint32) [8]uint32 {
+	var cv [8]uint32
+	copy(cv[:], words[:8])
+	return cv
+}
+
+func wordsFromBytes(b *[BlockSize]byte) [16]uint32 {
+	var words [16]uint32
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return words
+}
+
+// output is a compression not yet run, which becomes either a chaining
+// value or, for the root node, the digest.
+type output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *output) chainingValue() [8]uint32 {
+	return first8(compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags))
+}
+
+func (o *output) rootBytes() [Size]byte {
+	words := compress(&o.cv, &o.block, 0, o.blockLen, o.flags|root)
+	var out [Size]byte
+	for i := 0; i < 8; i++ {
+		binary.LittleEndian.PutUint32(out[4*i:], words[i])
+	}
+	return out
+}
+
+func parentOutput(left, right [8]uint32) output {
+	var block [16]uint32
+	copy(block[:8], left[:])
+	copy(block[8:], right[:])
+	return output{cv: iv, block: block, blockLen: BlockSize, flags: parent}
+}
+
+// chunkState hashes one 1024-byte chunk of the input.
+type chunkState struct {
+	cv               [8]uint32
+	counter          uint64
+	block            [BlockSize]byte
+	blockLen         int
+	blocksCompressed int
+}
+
+func newChunkState(counter uint64) chunkState {
+	return chunkState{cv: iv, counter: counter}
+}
+
+func (c *chunkState) len() int {
+	return BlockSize*c.blocksCompressed + c.blockLen
+}
+
+func (c *chunkState) startFlag() uint32 {
+	if c.blocksCompressed == 0 {
+		return chunkStart
+	}
+	return 0
+}
+
+func (c *chunkState) update(p []byte) {
+	for len(p) > 0 {
+		// Compress a full block only once more input arrives: the last
+		// block of a chunk is compressed with the chunk end flag.
+		if c.blockLen == BlockSize {
+			words := wordsFromBytes(&c.block)
+			c.cv = first8(compress(&c.cv, &words, c.counter, BlockSize, c.startFlag()))
+			c.blocksCompressed++
+			c.block = [BlockSize]byte{}
+			c.blockLen = 0
+		}
+		n := copy(c.block[c.blockLen:], p)
+		c.blockLen += n
+		p = p[n:]
+	}
+}
+
+func (c *chunkState) output() output {
+	return output{
+		cv:       c.cv,
+		block:    wordsFromBytes(&c.block),
+		counter:  c.counter,
+		blockLen: uint32(c.blockLen),
+		flags:    c.startFlag() | chunkEnd,
+	}
+}
+
+// digest is an incremental BLAKE3 hash. The stack holds the chaining
+// values of completed subtrees, merged as chunks complete.
+type digest struct {
+	chunk chunkState
+	stack [][8]uint32
+}
+
+// New returns a hash.Hash computing the BLAKE3 digest.
+func New() hash.Hash {
+	d := &digest{}
+	d.Reset()
+	return d
+}
+
+// Sum256 returns the BLAKE3 digest of data.
+func Sum256(data []byte) [Size]byte {
+	d := &digest{}
+	d.Reset()
+	d.Write(data)
+	return d.sum()
+}
+
+func (d *digest) Reset() {
+	d.chunk = newChunkState(0)
+	d.stack = d.stack[:0]
+}
+
+func (d *digest) Size() int { return Size }
+
+func (d *digest) BlockSize() int { return BlockSize }
+
+func (d *digest) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if d.chunk.len() == chunkLen {
+			cv := d.chunk.output()
+			total := d.chunk.counter + 1
+			d.addChunk(cv.chainingValue(), total)
+			d.chunk = newChunkState(total)
+		}
+		take := min(chunkLen-d.chunk.len(), len(p))
+		d.chunk.update(p[:take])
+		p = p[take:]
+	}
+	return n, nil
+}
+
+// addChunk pushes the chaining value of a completed chunk, first merging
+// every subtree it completes: one per trailing zero bit of total, the
+// number of chunks so far.
+func (d *digest) addChunk(cv [8]uint32, total uint64) {
+	for total&1 == 0 {
+		top := d.stack[len(d.stack)-1]
+		d.stack = d.stack[:len(d.stack)-1]
+		out := parentOutput(top, cv)
+		cv = out.chainingValue()
+		total >>= 1
+	}
+	d.stack = append(d.stack, cv)
+}
+
+func (d *digest) sum() [Size]byte {
+	out := d.chunk.output()
+	for i := len(d.stack) - 1; i >= 0; i-- {
+		out = parentOutput(d.stack[i], out.chainingValue())
+	}
+	return out.rootBytes()
+}
+
+func (d *digest) Sum(b []byte) []byte {
+	sum := d.sum()
+	return append(b, sum[:]...)
+}
@@ -0,0 +1,73 @@
+package blake3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSum256(t *testing.T) {
+	// From the official test vectors, whose inputs repeat the bytes
+	// 0, 1, ..., 250.
+	for _, tc := range []struct {
+		n    int
+		want string
+	}{
+		{0, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{1, "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+		{1024, "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+		{1025, "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+		{2048, "e776b6028c7cd22a4d0ba182a8bf62205d2ef576467e838ed6f2529b85fba24a"},
+		{2049, "5f4d72f40d7a5f82b15ca2b2e44b1de3c2ef86c426c95c1af0b6879522563030"},
+	} {
+		input := make([]byte, tc.n)
+		for i := range input {
+			input[i] = byte(i % 251)
+		}
+		sum := Sum256(input)
+		if got := hex.EncodeToString(sum[:]); got != tc.want {
+			t.Errorf("Sum256(%d bytes) = %s, want %s", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestWriteInPieces(t *testing.T) {
+	input := make([]byte, 10000)
+	for i := range input {
+		input[i] = byte(i % 251)
+	}
+	want := Sum256(input)
+	h := New()
+	for i := 0; i < len(input); i += 7 {
+		h.Write(input[i:min(i+7, len(input))])
+	}
+	if got := h.Sum(nil); hex.EncodeToString(got) != hex.EncodeToString(want[:]) {
+		t.Errorf("incremental digest %x, want %x", got, want)
+	}
+	h.Reset()
+	h.Write([]byte("abc"))
+	if got := hex.EncodeToString(h.Sum(nil)); got != "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85" {
+		t.Errorf("digest after Reset = %s", got)
+	}
+}
+
+// BenchmarkSum256 compares this implementation with the SHA-256 blobs are
+// addressed by. It is portable Go without SIMD, so it trails the
+// assembly SHA-256 in the standard library.
+func BenchmarkSum256(b *testing.B) {
+	data := make([]byte, 16<<20)
+	for _, bc := range []struct {
+		name string
+		sum  func([]byte)
+	}{
+		{"blake3", func(p []byte) { Sum256(p) }},
+		{"sha256", func(p []byte) { sha256.Sum256(p) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				bc.sum(data)
+			}
+		})
+	}
+}
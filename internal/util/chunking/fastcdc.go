@@ -0,0 +1,163 @@
+package chunking
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Default chunk size bounds used when a Config leaves them unset.
+const (
+	DefaultMinSize = 64 << 10
+	DefaultAvgSize = 256 << 10
+	DefaultMaxSize = 1 << 20
+)
+
+// Config holds the FastCDC chunk size bounds in bytes.
+type Config struct {
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+// withDefaults fills unset bounds and validates their ordering.
+func (c Config) withDefaults() (Config, error) {
+	if c.MinSize <= 0 {
+		c.MinSize = DefaultMinSize
+	}
+	if c.AvgSize <= 0 {
+		c.AvgSize = DefaultAvgSize
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = DefaultMaxSize
+	}
+	if c.MinSize >= c.AvgSize || c.AvgSize >= c.MaxSize {
+		return c, fmt.Errorf("invalid chunk sizes: need min < avg < max, got %d/%d/%d", c.MinSize, c.AvgSize, c.MaxSize)
+	}
+	return c, nil
+}
+
+// Chunker splits a stream into content-defined chunks using FastCDC with
+// normalized chunking. Boundaries depend only on the surrounding bytes, so an
+// insertion or deletion only changes the chunks around the edit.
+type Chunker struct {
+	r     io.Reader
+	cfg   Config
+	maskS uint64
+	maskL uint64
+
+	buf   []byte
+	start int
+	end   int
+	eof   bool
+}
+
+// NewChunker creates a Chunker reading from r.
+func NewChunker(r io.Reader, cfg Config) (*Chunker, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	n := bits.Len(uint(cfg.AvgSize)) - 1
+	return &Chunker{
+		r:     r,
+		cfg:   cfg,
+		maskS: topMask(n + 1),
+		maskL: topMask(n - 1),
+		buf:   make([]byte, cfg.MaxSize),
+	}, nil
+}
+
+// Next returns the next chunk. The returned slice is only valid until the
+// following call to Next. It returns io.EOF once the stream is exhausted.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill compacts the buffer and reads until it is full or the reader is done.
+func (c *Chunker) fill() error {
+	if c.start > 0 {
+		copy(c.buf, c.buf[c.start:c.end])
+		c.end -= c.start
+		c.start = 0
+	}
+	for !c.eof && c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading chunk data: %w", err)
+		}
+	}
+	return nil
+}
+
+// cut returns the length of the first chunk in data.
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.cfg.MinSize {
+		return n
+	}
+	if n > c.cfg.MaxSize {
+		n = c.cfg.MaxSize
+	}
+	normal := c.cfg.AvgSize
+	if n < normal {
+		normal = n
+	}
+
+	var fp uint64
+	i := c.cfg.MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// topMask returns a mask selecting the n most significant bits. The gear hash
+// shifts left, so the high bits cover the widest window of input bytes.
+func topMask(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+// gear is the FastCDC gear table. It is derived from a fixed seed so chunk
+// boundaries stay stable across releases; changing it would break dedup with
+// previously stored chunks.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x666f756e647279) // "foundry"
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
@@ -0,0 +1,91 @@
+package chunking
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func randomData(t *testing.T, n int, seed int64) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunkAll(t *testing.T, data []byte, cfg Config) [][]byte {
+	t.Helper()
+	c, err := NewChunker(bytes.NewReader(data), cfg)
+	if err != nil {
+		t.Fatalf("NewChunker: %v", err)
+	}
+	var chunks [][]byte
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+}
+
+var testConfig = Config{MinSize: 2 << 10, AvgSize: 8 << 10, MaxSize: 32 << 10}
+
+func TestChunkerReassembles(t *testing.T) {
+	data := randomData(t, 1<<20, 1)
+	chunks := chunkAll(t, data, testConfig)
+
+	if got := bytes.Join(chunks, nil); !bytes.Equal(got, data) {
+		t.Fatal("reassembled chunks differ from input")
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > testConfig.MaxSize {
+			t.Errorf("chunk %d size %d exceeds max %d", i, len(chunk), testConfig.MaxSize)
+		}
+		if i < len(chunks)-1 && len(chunk) < testConfig.MinSize {
+			t.Errorf("chunk %d size %d below min %d", i, len(chunk), testConfig.MinSize)
+		}
+	}
+}
+
+func TestChunkerEmptyInput(t *testing.T) {
+	if chunks := chunkAll(t, nil, testConfig); len(chunks) != 0 {
+		t.Errorf("expected no chunks, got %d", len(chunks))
+	}
+}
+
+func TestChunkerLocalizedEdit(t *testing.T) {
+	original := randomData(t, 1<<20, 2)
+	edited := make([]byte, 0, len(original)+16)
+	edited = append(edited, original[:len(original)/2]...)
+	edited = append(edited, []byte("inserted-bytes!!")...)
+	edited = append(edited, original[len(original)/2:]...)
+
+	seen := make(map[[32]byte]bool)
+	for _, chunk := range chunkAll(t, original, testConfig) {
+		seen[sha256.Sum256(chunk)] = true
+	}
+
+	editedChunks := chunkAll(t, edited, testConfig)
+	var changed int
+	for _, chunk := range editedChunks {
+		if !seen[sha256.Sum256(chunk)] {
+			changed++
+		}
+	}
+	if changed > 3 {
+		t.Errorf("expected an insertion to change at most 3 chunks, changed %d of %d", changed, len(editedChunks))
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	_, err := NewChunker(bytes.NewReader(nil), Config{MinSize: 10, AvgSize: 5, MaxSize: 20})
+	if err == nil {
+		t.Error("expected error for min >= avg")
+	}
+}
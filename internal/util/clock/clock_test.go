@@ -0,0 +1,32 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSystemClockIsUTC(t *testing.T) {
+	if loc := System.Now().Location(); loc != time.UTC {
+		t.Errorf("location = %v, want UTC", loc)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	c := NewFake(start)
+
+	if got := c.Now(); !got.Equal(start) || got.Location() != time.UTC {
+		t.Errorf("Now = %v, want %v in UTC", got, start)
+	}
+
+	c.Advance(90 * time.Minute)
+	if got, want := c.Now(), start.Add(90*time.Minute); !got.Equal(want) {
+		t.Errorf("after Advance, Now = %v, want %v", got, want)
+	}
+
+	later := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.Set(later)
+	if got := c.Now(); !got.Equal(later) {
+		t.Errorf("after Set, Now = %v, want %v", got, later)
+	}
+}
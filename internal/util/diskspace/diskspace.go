@@ -0,0 +1,15 @@
+// Package diskspace reports how much room is left on a filesystem.
+package diskspace
+
+import "fmt"
+
+// Free returns the bytes available to unprivileged users on the filesystem
+// holding path. It returns an error wrapping errors.ErrUnsupported on
+// platforms it cannot ask.
+func Free(path string) (int64, error) {
+	n, err := free(path)
+	if err != nil {
+		return 0, fmt.Errorf("checking free space in %s: %w", path, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,20 @@
+package diskspace
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFree(t *testing.T) {
+	n, err := Free(t.TempDir())
+	if err != nil {
+		t.Fatalf("Free: %v", err)
+	}
+	if n <= 0 {
+		t.Errorf("Free = %d, want a positive size", n)
+	}
+
+	if _, err := Free(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
@@ -0,0 +1,177 @@
+// Package dsse reads Dead Simple Signing Envelopes, the signed wrapper
+// in-toto attestations are distributed in, checks their signatures and
+// signs new ones.
+package dsse
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Envelope is a DSSE envelope. Payload and signatures are base64 encoded.
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     string      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+// Signature is one signature over an envelope's payload.
+type Signature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   string `json:"sig"`
+}
+
+// Parse reads an envelope and decodes its payload.
+func Parse(data []byte) (*Envelope, []byte, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, nil, fmt.Errorf("invalid DSSE envelope JSON: %v", err)
+	}
+	if env.PayloadType == "" || env.Payload == "" {
+		return nil, nil, errors.New("DSSE envelope needs payloadType and payload")
+	}
+	payload, err := decode(env.Payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("DSSE payload is not base64: %v", err)
+	}
+	if len(env.Signatures) == 0 {
+		return nil, nil, errors.New("DSSE envelope is not signed")
+	}
+	return &env, payload, nil
+}
+
+// PAE is the pre-authentication encoding of a payload, the bytes a DSSE
+// signature covers.
+func PAE(payloadType string, payload []byte) []byte {
+	return fmt.Appendf(nil, "DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload)
+}
+
+// Verify reports whether any of the envelope's signatures was made by key,
+// an ECDSA, Ed25519 or RSA public key. ECDSA and RSA signatures are over
+// the SHA-256 of the encoding; RSA may use PKCS #1 v1.5 or PSS.
+func (e *Envelope) Verify(key crypto.PublicKey) bool {
+	payload, err := decode(e.Payload)
+	if err != nil {
+		return false
+	}
+	message := PAE(e.PayloadType, payload)
+	digest := sha256.Sum256(message)
+	for _, s := range e.Signatures {
+		sig, err := decode(s.Sig)
+		if err != nil {
+			continue
+		}
+		switch k := key.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(k, digest[:], sig) {
+				return true
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(k, message, sig) {
+				return true
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil ||
+				rsa.VerifyPSS(k, crypto.SHA256, digest[:], sig, nil) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Sign returns an envelope of payload signed by key, an ECDSA, Ed25519 or
+// RSA private key, signing as Verify checks.
+func Sign(payloadType string, payload []byte, key crypto.Signer) (*Envelope, error) {
+	message := PAE(payloadType, payload)
+	var sig []byte
+	var err error
+	switch key.(type) {
+	case ed25519.PrivateKey:
+		sig, err = key.Sign(rand.Reader, message, crypto.Hash(0))
+	case *ecdsa.PrivateKey, *rsa.PrivateKey:
+		digest := sha256.Sum256(message)
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("signing: %w", err)
+	}
+	return &Envelope{
+		PayloadType: payloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []Signature{{Sig: base64.StdEncoding.EncodeToString(sig)}},
+	}, nil
+}
+
+// ParsePrivateKey reads a PEM encoded PKCS #8, SEC 1 (EC) or PKCS #1 (RSA)
+// private key.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	case *rsa.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// ParsePublicKey reads a PEM encoded PKIX public key.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// decode reads standard base64, padded or not, and the URL-safe variant
+// some signers emit.
+func decode(s string) ([]byte, error) {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, errors.New("illegal base64 data")
+}
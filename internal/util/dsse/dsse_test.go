@@ -0,0 +1,129 @@
+package dsse
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+)
+
+func TestPAE(t *testing.T) {
+	// From the DSSE specification's test vectors.
+	got := string(PAE("http://example.com/HelloWorld", []byte("hello world")))
+	want := "DSSEv1 29 http://example.com/HelloWorld 11 hello world"
+	if got != want {
+		t.Errorf("PAE = %q, want %q", got, want)
+	}
+}
+
+func sign(t *testing.T, payloadType string, payload []byte, signer crypto.Signer) []byte {
+	t.Helper()
+	message := PAE(payloadType, payload)
+	var sig []byte
+	var err error
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		sig, err = signer.Sign(rand.Reader, message, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(message)
+		sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatalf("signing: %v", err)
+	}
+	data, _ := json.Marshal(Envelope{
+		PayloadType: payloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []Signature{{KeyID: "builder", Sig: base64.StdEncoding.EncodeToString(sig)}},
+	})
+	return data
+}
+
+func TestVerify(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	for _, signer := range []crypto.Signer{ecKey, edKey} {
+		data := sign(t, "application/vnd.in-toto+json", []byte(`{"_type":"x"}`), signer)
+		env, payload, err := Parse(data)
+		if err != nil || string(payload) != `{"_type":"x"}` {
+			t.Fatalf("Parse: %q, %v", payload, err)
+		}
+
+		der, _ := x509.MarshalPKIXPublicKey(signer.Public())
+		key, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		if err != nil {
+			t.Fatalf("ParsePublicKey: %v", err)
+		}
+		if !env.Verify(key) {
+			t.Errorf("%T: signature did not verify", signer)
+		}
+		if env.Verify(otherKey.Public()) {
+			t.Errorf("%T: verified with the wrong key", signer)
+		}
+		env.PayloadType = "text/plain"
+		if env.Verify(key) {
+			t.Errorf("%T: verified after the payload type changed", signer)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, data := range []string{
+		`not json`,
+		`{"payload": "e30="}`,
+		`{"payloadType": "application/vnd.in-toto+json", "payload": "!!"}`,
+		`{"payloadType": "application/vnd.in-toto+json", "payload": "e30=", "signatures": []}`,
+	} {
+		if _, _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%s): expected an error", data)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+
+	for _, block := range []*pem.Block{
+		{Type: "EC PRIVATE KEY", Bytes: ecDER},
+		{Type: "PRIVATE KEY", Bytes: edDER},
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+	} {
+		key, err := ParsePrivateKey(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Fatalf("%s: ParsePrivateKey: %v", block.Type, err)
+		}
+		env, err := Sign("application/json", []byte(`{}`), key)
+		if err != nil {
+			t.Fatalf("%s: Sign: %v", block.Type, err)
+		}
+		data, _ := json.Marshal(env)
+		parsed, payload, err := Parse(data)
+		if err != nil || string(payload) != `{}` {
+			t.Fatalf("%s: Parse: %q, %v", block.Type, payload, err)
+		}
+		if !parsed.Verify(key.Public()) {
+			t.Errorf("%s: own signature did not verify", block.Type)
+		}
+		if parsed.Verify(otherKey.Public()) {
+			t.Errorf("%s: verified with the wrong key", block.Type)
+		}
+	}
+
+	if _, err := ParsePrivateKey([]byte("not pem")); err == nil {
+		t.Error("expected an error for a non-PEM key")
+	}
+}
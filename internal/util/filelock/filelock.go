@@ -0,0 +1,48 @@
+package filelock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ErrLocked indicates another process already holds the lock.
+var ErrLocked = errors.New("lock held by another process")
+
+// Lock is an exclusive advisory lock on a file.
+type Lock struct {
+	f *os.File
+}
+
+// Acquire takes an exclusive lock on path without blocking, creating the
+// file if needed, and records the current PID in it. It returns an error
+// wrapping ErrLocked if another process holds the lock.
+func Acquire(path string) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %w", err)
+	}
+	if err := tryLock(f); err != nil {
+		f.Close()
+		if errors.Is(err, ErrLocked) {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, path)
+		}
+		return nil, fmt.Errorf("locking %s: %w", path, err)
+	}
+
+	// The PID is informational only; failing to write it is not fatal.
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &Lock{f: f}, nil
+}
+
+// Release drops the lock.
+func (l *Lock) Release() error {
+	if err := unlock(l.f); err != nil {
+		l.f.Close()
+		return fmt.Errorf("unlocking: %w", err)
+	}
+	return l.f.Close()
+}
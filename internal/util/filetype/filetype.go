@@ -0,0 +1,193 @@
+// Package filetype classifies uploads by their content: the magic bytes at
+// their start and, for zip archives, the members that make a zip a Python
+// wheel or a Java archive. It reads the content as a stream, so uploads can
+// be classified while they are stored.
+package filetype
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"path"
+	"strings"
+)
+
+// Formats a file can be classified as. Binary covers everything that is
+// not one of the others.
+const (
+	Tar    = "tar"
+	TarGz  = "tar.gz"
+	Zip    = "zip"
+	Wheel  = "wheel"
+	Jar    = "jar"
+	Deb    = "deb"
+	Binary = "binary"
+)
+
+// Formats lists every format, for validating configuration.
+var Formats = []string{Tar, TarGz, Zip, Wheel, Jar, Deb, Binary}
+
+// headSize is how much of the start of a file is kept for its magic bytes,
+// enough compressed data to find the tar header inside a gzip stream.
+const headSize = 4096
+
+// maxNameLen bounds the zip member names looked at; longer names are
+// skipped.
+const maxNameLen = 1024
+
+// zipCentralHeader starts each entry of a zip's central directory, which
+// lists every member's name at the end of the archive.
+var zipCentralHeader = []byte("PK\x01\x02")
+
+// centralHeaderLen is the fixed part of a central directory entry, which
+// the member's name follows.
+const centralHeaderLen = 46
+
+// Sniffer classifies the data written to it.
+type Sniffer struct {
+	head  []byte
+	zip   bool
+	carry []byte
+	wheel bool
+	jar   bool
+}
+
+// Write implements io.Writer; it never fails.
+func (s *Sniffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(s.head) < headSize {
+		take := min(headSize-len(s.head), len(p))
+		s.head = append(s.head, p[:take]...)
+		s.zip = isZip(s.head)
+	}
+	if s.zip {
+		s.scanZip(p)
+	}
+	return n, nil
+}
+
+// Format returns the format of the data written so far.
+func (s *Sniffer) Format() string {
+	switch {
+	case s.zip && s.wheel:
+		return Wheel
+	case s.zip && s.jar:
+		return Jar
+	case s.zip:
+		return Zip
+	case bytes.HasPrefix(s.head, []byte("!<arch>\ndebian-binary")):
+		return Deb
+	case isTar(s.head):
+		return Tar
+	case bytes.HasPrefix(s.head, []byte{0x1f, 0x8b}) && gzipHoldsTar(s.head):
+		return TarGz
+	}
+	return Binary
+}
+
+// Detect classifies the content read from r.
+func Detect(r io.Reader) (string, error) {
+	var s Sniffer
+	if _, err := io.Copy(&s, r); err != nil {
+		return "", err
+	}
+	return s.Format(), nil
+}
+
+func isZip(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06"))
+}
+
+// isTar checks for the ustar magic shared by POSIX and GNU tar headers.
+func isTar(head []byte) bool {
+	return len(head) >= 263 && bytes.Equal(head[257:262], []byte("ustar"))
+}
+
+// gzipHoldsTar reports whether the gzip stream starting head decompresses
+// to a tar header.
+func gzipHoldsTar(head []byte) bool {
+	gz, err := gzip.NewReader(bytes.NewReader(head))
+	if err != nil {
+		return false
+	}
+	block := make([]byte, 512)
+	n, _ := io.ReadFull(gz, block)
+	return isTar(block[:n])
+}
+
+// scanZip looks through p, continuing from what the previous write left
+// incomplete, for central directory entries naming a wheel's WHEEL file or
+// a jar's manifest.
+func (s *Sniffer) scanZip(p []byte) {
+	data := p
+	if len(s.carry) > 0 {
+		data = append(s.carry, p...)
+	}
+	s.carry = nil
+	for {
+		i := bytes.Index(data, zipCentralHeader)
+		if i < 0 {
+			// Keep a partial signature at the end.
+			if keep := len(zipCentralHeader) - 1; len(data) > keep {
+				data = data[len(data)-keep:]
+			}
+			s.carry = append([]byte(nil), data...)
+			return
+		}
+		data = data[i:]
+		if len(data) < centralHeaderLen {
+			s.carry = append([]byte(nil), data...)
+			return
+		}
+		nameLen := int(binary.LittleEndian.Uint16(data[28:30]))
+		if nameLen > maxNameLen {
+			data = data[len(zipCentralHeader):]
+			continue
+		}
+		if len(data) < centralHeaderLen+nameLen {
+			s.carry = append([]byte(nil), data...)
+			return
+		}
+		s.member(string(data[centralHeaderLen : centralHeaderLen+nameLen]))
+		data = data[centralHeaderLen+nameLen:]
+	}
+}
+
+// member notes a zip member that marks the archive's kind.
+func (s *Sniffer) member(name string) {
+	switch {
+	case name == "META-INF/MANIFEST.MF":
+		s.jar = true
+	case path.Base(name) == "WHEEL" && strings.Count(name, "/") == 1 && strings.HasSuffix(path.Dir(name), ".dist-info"):
+		s.wheel = true
+	}
+}
+
+// FromFilename returns the format a file's name claims, or "" when its
+// extension does not name one.
+func FromFilename(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".whl"):
+		return Wheel
+	case strings.HasSuffix(lower, ".jar"), strings.HasSuffix(lower, ".war"):
+		return Jar
+	case strings.HasSuffix(lower, ".deb"):
+		return Deb
+	case strings.HasSuffix(lower, ".zip"):
+		return Zip
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"), strings.HasSuffix(lower, ".crate"):
+		return TarGz
+	case strings.HasSuffix(lower, ".tar"):
+		return Tar
+	}
+	return ""
+}
+
+// Satisfies reports whether content of format actual is what a filename
+// claiming format claimed promises. Wheels and jars are zip archives, so
+// they satisfy a claim of zip.
+func Satisfies(claimed, actual string) bool {
+	return claimed == actual || claimed == Zip && (actual == Wheel || actual == Jar)
+}
@@ -0,0 +1,92 @@
+package filetype
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func zipOf(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, _ := zw.Create(name)
+		w.Write(bytes.Repeat([]byte(name), 100))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("writing zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func tarOf(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "app/bin", Mode: 0o755, Size: 3})
+	tw.Write([]byte("bin"))
+	tw.Close()
+	return buf.Bytes()
+}
+
+func gzipOf(data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestDetect(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"zip", zipOf(t, "app/main.py", "app/README"), Zip},
+		{"wheel", zipOf(t, "pkg/__init__.py", "pkg-1.0.dist-info/METADATA", "pkg-1.0.dist-info/WHEEL"), Wheel},
+		{"nested WHEEL", zipOf(t, "build/pkg-1.0.dist-info/WHEEL"), Zip},
+		{"jar", zipOf(t, "META-INF/MANIFEST.MF", "com/acme/App.class"), Jar},
+		{"tar", tarOf(t), Tar},
+		{"tar.gz", gzipOf(tarOf(t)), TarGz},
+		{"gzip", gzipOf([]byte("just some text")), Binary},
+		{"deb", []byte("!<arch>\ndebian-binary   1342943816  0     0     100644  4         `\n2.0\n"), Deb},
+		{"elf", []byte("\x7fELF\x02\x01\x01"), Binary},
+		{"empty", nil, Binary},
+	} {
+		// Write one byte at a time to exercise names split across writes.
+		var s Sniffer
+		for i := range tc.data {
+			s.Write(tc.data[i : i+1])
+		}
+		if got := s.Format(); got != tc.want {
+			t.Errorf("%s: byte by byte, got %s, want %s", tc.name, got, tc.want)
+		}
+		if got, _ := Detect(bytes.NewReader(tc.data)); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFromFilename(t *testing.T) {
+	for name, want := range map[string]string{
+		"pkg-1.0-py3-none-any.whl": Wheel,
+		"app.JAR":                  Jar,
+		"app_1.0_amd64.deb":        Deb,
+		"site.zip":                 Zip,
+		"app-1.0.tar.gz":           TarGz,
+		"lib-0.1.0.crate":          TarGz,
+		"app.tar":                  Tar,
+		"app-linux-amd64":          "",
+	} {
+		if got := FromFilename(name); got != want {
+			t.Errorf("FromFilename(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if !Satisfies(Zip, Wheel) || Satisfies(Wheel, Zip) || !Satisfies(TarGz, TarGz) {
+		t.Error("Satisfies: zip claims accept wheels, wheel claims need a wheel")
+	}
+}
@@ -0,0 +1,579 @@
+// Package graphql runs read-only GraphQL queries against a schema of
+// objects whose fields are resolved by Go functions. It covers what a
+// metadata API needs: operations, variables, aliases, fragments and the
+// @include and @skip directives. Every type is nullable; there are no
+// interfaces, unions, input objects, mutations or introspection beyond
+// __typename.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Kind is the type of an argument.
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Float
+	Boolean
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Int:
+		return "Int"
+	case Float:
+		return "Float"
+	case Boolean:
+		return "Boolean"
+	default:
+		return "String"
+	}
+}
+
+// Schema describes what can be queried.
+type Schema struct {
+	Query *Object
+	// MaxDepth limits how deeply object fields may be nested; zero means
+	// no limit.
+	MaxDepth int
+}
+
+// Object is a named type with fields.
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Field is one field of an object. Type is the object its value, or each
+// element of its value if that is a slice, is queried as; nil means the
+// value is a scalar returned as is. Struct elements of a slice are passed
+// on to the object's resolvers as pointers.
+//
+// Resolve computes the value from the parent's value and the field's
+// arguments, which hold string, int64, float64 or bool values; absent
+// arguments without a default are missing from the map. If Resolve is nil
+// the value is the parent's map entry, or struct field by JSON name, with
+// the field's name.
+type Field struct {
+	Type    *Object
+	Args    map[string]Arg
+	Resolve func(ctx context.Context, source any, args map[string]any) (any, error)
+}
+
+// Arg describes one argument of a field.
+type Arg struct {
+	Type     Kind
+	Required bool
+	Default  any
+}
+
+// Request is a query as sent over HTTP.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Response is the result of a query. Data is nil if the request could not
+// be run at all; otherwise fields that failed are null and have an entry
+// in Errors.
+type Response struct {
+	Data   any      `json:"data,omitempty"`
+	Errors []*Error `json:"errors,omitempty"`
+}
+
+// Error is a problem with a request or with resolving one field.
+type Error struct {
+	Message   string     `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+	Path      []any      `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Locations) > 0 {
+		return fmt.Sprintf("%s (line %d, column %d)", e.Message, e.Locations[0].Line, e.Locations[0].Column)
+	}
+	return e.Message
+}
+
+// Location is a position in a query.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// maxSelections bounds the fields a query may select once its fragments
+// are expanded, so a small query cannot spread into an enormous one.
+const maxSelections = 10000
+
+// Execute parses, checks and runs req.
+func (s *Schema) Execute(ctx context.Context, req Request) Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return Response{Errors: []*Error{asError(err)}}
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return Response{Errors: []*Error{asError(err)}}
+	}
+	if op.kind != "query" {
+		return Response{Errors: []*Error{{Message: fmt.Sprintf("%s operations are not supported", op.kind), Locations: []Location{op.loc}}}}
+	}
+
+	v := &validator{
+		schema:   s,
+		doc:      doc,
+		vars:     make(map[string]any),
+		declared: make(map[string]bool),
+		args:     make(map[*selection]map[string]any),
+		visiting: make(map[string]bool),
+	}
+	for _, d := range op.vars {
+		if v.declared[d.name] {
+			v.fail(fmt.Sprintf("variable $%s is declared twice", d.name), op.loc)
+		}
+		v.declared[d.name] = true
+		if value, ok := req.Variables[d.name]; ok {
+			v.vars[d.name] = value
+		} else if d.def != nil {
+			v.vars[d.name] = d.def
+		}
+	}
+	v.selectionSet(s.Query, op.selections, 1)
+	if len(v.errs) > 0 {
+		return Response{Errors: v.errs}
+	}
+
+	e := &executor{ctx: ctx, doc: doc, args: v.args, vars: v.vars}
+	data := e.selectionSet(s.Query, nil, op.selections, nil)
+	return Response{Data: data, Errors: e.errs}
+}
+
+// operation picks the operation to run.
+func (d *document) operation(name string) (*operation, error) {
+	if name == "" {
+		if len(d.operations) > 1 {
+			return nil, &Error{Message: "operationName is required when the document has several operations"}
+		}
+		return d.operations[0], nil
+	}
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, &Error{Message: fmt.Sprintf("unknown operation %s", name)}
+}
+
+func asError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Message: err.Error()}
+}
+
+// validator checks a query against the schema before anything runs,
+// coercing each field's arguments on the way.
+type validator struct {
+	schema   *Schema
+	doc      *document
+	vars     map[string]any
+	declared map[string]bool
+	args     map[*selection]map[string]any
+	visiting map[string]bool
+	count    int
+	errs     []*Error
+}
+
+func (v *validator) fail(msg string, loc Location) {
+	v.errs = append(v.errs, &Error{Message: msg, Locations: []Location{loc}})
+}
+
+func (v *validator) selectionSet(obj *Object, sels []*selection, depth int) {
+	if v.schema.MaxDepth > 0 && depth > v.schema.MaxDepth {
+		v.fail(fmt.Sprintf("the query is nested more than %d levels deep", v.schema.MaxDepth), sels[0].loc)
+		return
+	}
+	fields := make(map[string]*selection)
+	for _, sel := range sels {
+		if v.count++; v.count > maxSelections {
+			if v.count == maxSelections+1 {
+				v.fail(fmt.Sprintf("the query selects more than %d fields", maxSelections), sel.loc)
+			}
+			return
+		}
+		v.directives(sel)
+
+		switch {
+		case sel.spread != "":
+			f := v.doc.fragments[sel.spread]
+			switch {
+			case f == nil:
+				v.fail(fmt.Sprintf("unknown fragment %s", sel.spread), sel.loc)
+			case f.typeCond != obj.Name:
+				v.fail(fmt.Sprintf("fragment %s on %s cannot be spread on %s", f.name, f.typeCond, obj.Name), sel.loc)
+			case v.visiting[f.name]:
+				v.fail(fmt.Sprintf("fragment %s spreads itself", f.name), sel.loc)
+			default:
+				v.visiting[f.name] = true
+				v.selectionSet(obj, f.selections, depth)
+				delete(v.visiting, f.name)
+			}
+			continue
+		case sel.inline:
+			if sel.typeCond != "" && sel.typeCond != obj.Name {
+				v.fail(fmt.Sprintf("a fragment on %s cannot be spread on %s", sel.typeCond, obj.Name), sel.loc)
+				continue
+			}
+			v.selectionSet(obj, sel.selections, depth)
+			continue
+		}
+
+		if prev, ok := fields[sel.key()]; ok && prev.name != sel.name {
+			v.fail(fmt.Sprintf("%s selects both %s and %s", sel.key(), prev.name, sel.name), sel.loc)
+			continue
+		}
+		fields[sel.key()] = sel
+
+		if sel.name == "__typename" {
+			if len(sel.args) > 0 || sel.selections != nil {
+				v.fail("__typename takes no arguments or subfields", sel.loc)
+			}
+			continue
+		}
+		field := obj.Fields[sel.name]
+		if field == nil {
+			v.fail(fmt.Sprintf("cannot query field %s on %s", sel.name, obj.Name), sel.loc)
+			continue
+		}
+		v.args[sel] = v.arguments(obj, sel, field.Args)
+		switch {
+		case field.Type == nil && sel.selections != nil:
+			v.fail(fmt.Sprintf("field %s on %s has no subfields", sel.name, obj.Name), sel.loc)
+		case field.Type != nil && sel.selections == nil:
+			v.fail(fmt.Sprintf("field %s on %s must select subfields", sel.name, obj.Name), sel.loc)
+		case field.Type != nil:
+			v.selectionSet(field.Type, sel.selections, depth+1)
+		}
+	}
+}
+
+// arguments coerces the arguments given to sel, adding defaults.
+func (v *validator) arguments(obj *Object, sel *selection, specs map[string]Arg) map[string]any {
+	args := make(map[string]any)
+	for _, a := range sel.args {
+		spec, ok := specs[a.name]
+		if !ok {
+			v.fail(fmt.Sprintf("unknown argument %s on %s.%s", a.name, obj.Name, sel.name), sel.loc)
+			continue
+		}
+		value, present, err := v.value(spec.Type, a.value)
+		if err != "" {
+			v.fail(fmt.Sprintf("argument %s on %s.%s: %s", a.name, obj.Name, sel.name, err), sel.loc)
+			continue
+		}
+		if present && value != nil {
+			args[a.name] = value
+		}
+	}
+	for name, spec := range specs {
+		if _, ok := args[name]; ok {
+			continue
+		}
+		if spec.Default != nil {
+			args[name] = spec.Default
+		} else if spec.Required {
+			v.fail(fmt.Sprintf("argument %s on %s.%s is required", name, obj.Name, sel.name), sel.loc)
+		}
+	}
+	return args
+}
+
+// directives checks the directives on sel.
+func (v *validator) directives(sel *selection) {
+	for _, d := range sel.directives {
+		if d.name != "include" && d.name != "skip" {
+			v.fail(fmt.Sprintf("unknown directive @%s", d.name), sel.loc)
+			continue
+		}
+		if len(d.args) != 1 || d.args[0].name != "if" {
+			v.fail(fmt.Sprintf("@%s takes one argument, if", d.name), sel.loc)
+			continue
+		}
+		if value, _, err := v.value(Boolean, d.args[0].value); err != "" || value == nil {
+			v.fail(fmt.Sprintf("@%s needs a Boolean", d.name), sel.loc)
+		}
+	}
+}
+
+// value resolves variables in value and coerces it to kind. present is
+// false for a variable that was declared but not given.
+func (v *validator) value(kind Kind, value any) (result any, present bool, err string) {
+	if ref, ok := value.(varRef); ok {
+		if !v.declared[string(ref)] {
+			return nil, false, fmt.Sprintf("variable $%s is not declared", ref)
+		}
+		if value, ok = v.vars[string(ref)]; !ok {
+			return nil, false, ""
+		}
+	}
+	result, ok := coerce(kind, value)
+	if !ok {
+		return nil, false, fmt.Sprintf("expected %s, found %s", kind, literal(value))
+	}
+	return result, true, ""
+}
+
+// coerce converts a literal or decoded JSON value to kind.
+func coerce(kind Kind, value any) (any, bool) {
+	if value == nil {
+		return nil, true
+	}
+	if n, ok := value.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			value = i
+		} else if f, err := n.Float64(); err == nil {
+			value = f
+		}
+	}
+	switch kind {
+	case String:
+		s, ok := value.(string)
+		return s, ok
+	case Boolean:
+		b, ok := value.(bool)
+		return b, ok
+	case Int:
+		switch n := value.(type) {
+		case int:
+			return int64(n), true
+		case int64:
+			return n, true
+		case float64:
+			if n == float64(int64(n)) && n >= -1<<53 && n <= 1<<53 {
+				return int64(n), true
+			}
+		}
+	case Float:
+		switch n := value.(type) {
+		case int:
+			return float64(n), true
+		case int64:
+			return float64(n), true
+		case float64:
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+func literal(value any) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case enumValue:
+		return string(v)
+	case []any:
+		return "a list"
+	case map[string]any:
+		return "an object"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// executor runs a validated query.
+type executor struct {
+	ctx  context.Context
+	doc  *document
+	args map[*selection]map[string]any
+	vars map[string]any
+	errs []*Error
+}
+
+// group is the selections of one response key, merged across fragments.
+type group struct {
+	key  string
+	sels []*selection
+}
+
+// collect flattens fragments and drops skipped selections, grouping what
+// remains by response key in query order.
+func (e *executor) collect(sels []*selection, groups []*group) []*group {
+	for _, sel := range sels {
+		if !e.included(sel) {
+			continue
+		}
+		switch {
+		case sel.spread != "":
+			groups = e.collect(e.doc.fragments[sel.spread].selections, groups)
+		case sel.inline:
+			groups = e.collect(sel.selections, groups)
+		default:
+			found := false
+			for _, g := range groups {
+				if g.key == sel.key() {
+					g.sels = append(g.sels, sel)
+					found = true
+					break
+				}
+			}
+			if !found {
+				groups = append(groups, &group{key: sel.key(), sels: []*selection{sel}})
+			}
+		}
+	}
+	return groups
+}
+
+func (e *executor) included(sel *selection) bool {
+	for _, d := range sel.directives {
+		value := d.args[0].value
+		if ref, ok := value.(varRef); ok {
+			value = e.vars[string(ref)]
+		}
+		if b, _ := value.(bool); b == (d.name == "skip") {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *executor) selectionSet(obj *Object, source any, sels []*selection, path []any) *result {
+	out := &result{}
+	for _, g := range e.collect(sels, nil) {
+		sel := g.sels[0]
+		if sel.name == "__typename" {
+			out.set(g.key, obj.Name)
+			continue
+		}
+		field := obj.Fields[sel.name]
+		fieldPath := append(path[:len(path):len(path)], g.key)
+
+		var value any
+		var err error
+		if field.Resolve != nil {
+			value, err = field.Resolve(e.ctx, source, e.args[sel])
+		} else {
+			value = lookup(source, sel.name)
+		}
+		if err != nil {
+			e.errs = append(e.errs, &Error{Message: err.Error(), Locations: []Location{sel.loc}, Path: fieldPath})
+			out.set(g.key, nil)
+			continue
+		}
+
+		var sub []*selection
+		for _, s := range g.sels {
+			sub = append(sub, s.selections...)
+		}
+		out.set(g.key, e.complete(field.Type, value, sub, fieldPath))
+	}
+	return out
+}
+
+// complete queries value as obj, element by element if it is a slice.
+func (e *executor) complete(obj *Object, value any, sels []*selection, path []any) any {
+	if isNull(value) {
+		return nil
+	}
+	if obj == nil {
+		return value
+	}
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Slice {
+		list := make([]any, rv.Len())
+		for i := range list {
+			elem := rv.Index(i)
+			if elem.Kind() == reflect.Struct {
+				elem = elem.Addr()
+			}
+			list[i] = e.complete(obj, elem.Interface(), sels, append(path[:len(path):len(path)], i))
+		}
+		return list
+	}
+	return e.selectionSet(obj, value, sels, path)
+}
+
+// isNull reports whether value is nil or a nil pointer, slice or map.
+func isNull(value any) bool {
+	if value == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(value); rv.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// lookup is the default resolver: the map entry or struct field of source
+// called name, matching struct fields by their JSON name.
+func lookup(source any, name string) any {
+	rv := reflect.ValueOf(source)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			if v := rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key())); v.IsValid() {
+				return v.Interface()
+			}
+		}
+	case reflect.Struct:
+		t := rv.Type()
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if tag == name || tag == "" && f.Name == name {
+				return rv.Field(i).Interface()
+			}
+		}
+	}
+	return nil
+}
+
+// result is an object in the response, which keeps its fields in the order
+// they were selected.
+type result struct {
+	keys   []string
+	values []any
+}
+
+func (r *result) set(key string, value any) {
+	r.keys = append(r.keys, key)
+	r.values = append(r.values, value)
+}
+
+func (r *result) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, key := range r.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		b.Write(k)
+		b.WriteByte(':')
+		v, err := json.Marshal(r.values[i])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return []byte(b.String()), nil
+}
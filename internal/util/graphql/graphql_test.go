@@ -0,0 +1,177 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type book struct {
+	Title  string   `json:"title"`
+	Year   int      `json:"year"`
+	Tags   []string `json:"tags,omitempty"`
+	Author *author  `json:"author,omitempty"`
+}
+
+type author struct {
+	Name string
+}
+
+func testSchema() *Schema {
+	authorType := &Object{Name: "Author", Fields: map[string]*Field{"Name": {}}}
+	bookType := &Object{Name: "Book", Fields: map[string]*Field{
+		"title":  {},
+		"year":   {},
+		"tags":   {},
+		"author": {Type: authorType},
+		"shout": {
+			Args: map[string]Arg{"times": {Type: Int, Default: int64(1)}},
+			Resolve: func(_ context.Context, source any, args map[string]any) (any, error) {
+				return strings.Repeat(strings.ToUpper(source.(*book).Title), int(args["times"].(int64))), nil
+			},
+		},
+		"broken": {Resolve: func(context.Context, any, map[string]any) (any, error) {
+			return nil, errors.New("no luck")
+		}},
+	}}
+	books := []*book{
+		{Title: "dune", Year: 1965, Tags: []string{"sf"}, Author: &author{Name: "Herbert"}},
+		{Title: "emma", Year: 1815},
+	}
+	query := &Object{Name: "Query", Fields: map[string]*Field{
+		"book": {
+			Type: bookType,
+			Args: map[string]Arg{"title": {Type: String, Required: true}},
+			Resolve: func(_ context.Context, _ any, args map[string]any) (any, error) {
+				for _, b := range books {
+					if b.Title == args["title"] {
+						return b, nil
+					}
+				}
+				return (*book)(nil), nil
+			},
+		},
+		"books": {
+			Type: bookType,
+			Args: map[string]Arg{"since": {Type: Int}},
+			Resolve: func(_ context.Context, _ any, args map[string]any) (any, error) {
+				since, _ := args["since"].(int64)
+				var out []*book
+				for _, b := range books {
+					if int64(b.Year) >= since {
+						out = append(out, b)
+					}
+				}
+				return out, nil
+			},
+		},
+	}}
+	return &Schema{Query: query, MaxDepth: 3}
+}
+
+func run(t *testing.T, s *Schema, req Request) string {
+	t.Helper()
+	out, err := json.Marshal(s.Execute(context.Background(), req))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return string(out)
+}
+
+func TestExecute(t *testing.T) {
+	s := testSchema()
+	for _, tc := range []struct {
+		query string
+		vars  map[string]any
+		want  string
+	}{
+		{
+			`{ book(title: "dune") { title year tags author { Name } } }`, nil,
+			`{"data":{"book":{"title":"dune","year":1965,"tags":["sf"],"author":{"Name":"Herbert"}}}}`,
+		},
+		{
+			`{ books { title author { Name } } }`, nil,
+			`{"data":{"books":[{"title":"dune","author":{"Name":"Herbert"}},{"title":"emma","author":null}]}}`,
+		},
+		{
+			`query Since($y: Int = 1900) { books(since: $y) { t: title } }`, nil,
+			`{"data":{"books":[{"t":"dune"}]}}`,
+		},
+		{
+			`query Since($y: Int) { books(since: $y) { title } }`, map[string]any{"y": float64(1800)},
+			`{"data":{"books":[{"title":"dune"},{"title":"emma"}]}}`,
+		},
+		{
+			`{ a: book(title: "dune") { ...F shout(times: 2) } b: book(title: "nope") { title } }
+			 fragment F on Book { __typename title }`, nil,
+			`{"data":{"a":{"__typename":"Book","title":"dune","shout":"DUNEDUNE"},"b":null}}`,
+		},
+		{
+			`query($all: Boolean!) { book(title: "dune") { title year @include(if: $all) ... on Book @skip(if: true) { tags } } }`,
+			map[string]any{"all": false},
+			`{"data":{"book":{"title":"dune"}}}`,
+		},
+		{
+			`{ book(title: "dune") { author { Name } ... { author { n: Name } } } }`, nil,
+			`{"data":{"book":{"author":{"Name":"Herbert","n":"Herbert"}}}}`,
+		},
+		{
+			`{ book(title: "emma") { title broken } }`, nil,
+			`{"data":{"book":{"title":"emma","broken":null}},"errors":[{"message":"no luck","locations":[{"line":1,"column":31}],"path":["book","broken"]}]}`,
+		},
+	} {
+		if got := run(t, s, Request{Query: tc.query, Variables: tc.vars}); got != tc.want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestExecuteRejects(t *testing.T) {
+	s := testSchema()
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{`{ book(title: "dune") { title `, "expected"},
+		{`{ book(title: "dune") { pages } }`, "cannot query field pages on Book"},
+		{`{ book { title } }`, "argument title on Query.book is required"},
+		{`{ book(title: 7) { title } }`, "expected String, found 7"},
+		{`{ book(title: "dune", by: "x") { title } }`, "unknown argument by"},
+		{`{ book(title: "dune") }`, "must select subfields"},
+		{`{ book(title: "dune") { title { x } } }`, "has no subfields"},
+		{`{ book(title: "dune") { author { Name { x } } } }`, "has no subfields"},
+		{`{ books(since: $y) { title } }`, "variable $y is not declared"},
+		{`{ book(title: "dune") { ...F } } fragment F on Book { ...F }`, "fragment F spreads itself"},
+		{`{ book(title: "dune") { ...F } } fragment F on Author { Name }`, "cannot be spread on Book"},
+		{`{ book(title: "dune") { title @upper } }`, "unknown directive @upper"},
+		{`{ book(title: "dune") { x: title x: year } }`, "x selects both title and year"},
+		{`mutation { book(title: "dune") { title } }`, "mutation operations are not supported"},
+		{`query A { books { title } } query B { books { year } }`, "operationName is required"},
+		{`{ book(title: "a\q") { title } }`, `invalid escape`},
+	} {
+		got := run(t, s, Request{Query: tc.query})
+		if !strings.HasPrefix(got, `{"errors":`) || !strings.Contains(got, tc.want) {
+			t.Errorf("%s: got %s, want an error containing %q", tc.query, got, tc.want)
+		}
+	}
+
+	deep := &Object{Name: "Deep", Fields: map[string]*Field{"x": {}}}
+	deep.Fields["next"] = &Field{Type: deep}
+	ds := &Schema{Query: deep, MaxDepth: 3}
+	if got := run(t, ds, Request{Query: `{ next { next { x } } }`}); strings.Contains(got, "errors") {
+		t.Errorf("3 levels: got %s", got)
+	}
+	if got := run(t, ds, Request{Query: `{ next { next { next { x } } } }`}); !strings.Contains(got, "nested more than 3 levels") {
+		t.Errorf("4 levels: got %s, want a depth error", got)
+	}
+}
+
+func TestOperationName(t *testing.T) {
+	s := testSchema()
+	req := Request{Query: `query A { books { title } } query B { book(title: "emma") { year } }`, OperationName: "B"}
+	if got, want := run(t, s, req), `{"data":{"book":{"year":1815}}}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
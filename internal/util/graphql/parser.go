@@ -0,0 +1,652 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is a parsed request: its operations and the fragments they may
+// spread.
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string
+	name       string
+	vars       []varDef
+	selections []*selection
+	loc        Location
+}
+
+type varDef struct {
+	name string
+	// def is the default value, or nil if there is none.
+	def any
+}
+
+type fragment struct {
+	name       string
+	typeCond   string
+	selections []*selection
+	loc        Location
+}
+
+// selection is a field, a fragment spread (spread set) or an inline
+// fragment (inline set).
+type selection struct {
+	alias      string
+	name       string
+	args       []argument
+	directives []directive
+	selections []*selection
+
+	spread   string
+	inline   bool
+	typeCond string
+
+	loc Location
+}
+
+// key is the name the selection's value is returned under.
+func (s *selection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+type argument struct {
+	name  string
+	value any
+}
+
+type directive struct {
+	name string
+	args []argument
+}
+
+// Values in a document are string, int64, float64, bool, nil, []any,
+// map[string]any, enumValue or varRef.
+type (
+	enumValue string
+	varRef    string
+)
+
+// token kinds.
+const (
+	tokEOF = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind  int
+	value string
+	loc   Location
+}
+
+// parser reads a document with one token of lookahead.
+type parser struct {
+	src  string
+	pos  int
+	line int
+	col  int
+	tok  token
+}
+
+// parse parses a request document.
+func parse(src string) (*document, error) {
+	p := &parser{src: src, line: 1, col: 1}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	doc := &document{fragments: make(map[string]*fragment)}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek(tokPunct, "{"):
+			op := &operation{kind: "query", loc: p.tok.loc}
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			op.selections = sels
+			doc.operations = append(doc.operations, op)
+		case p.peek(tokName, "query"), p.peek(tokName, "mutation"), p.peek(tokName, "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.peek(tokName, "fragment"):
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := doc.fragments[f.name]; dup {
+				return nil, &Error{Message: fmt.Sprintf("fragment %s is defined twice", f.name), Locations: []Location{f.loc}}
+			}
+			doc.fragments[f.name] = f
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, &Error{Message: "the document has no operation"}
+	}
+	return doc, nil
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{kind: p.tok.value, loc: p.tok.loc}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokName {
+		op.name = p.tok.value
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek(tokPunct, "(") {
+		vars, err := p.varDefs()
+		if err != nil {
+			return nil, err
+		}
+		op.vars = vars
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selections = sels
+	return op, nil
+}
+
+func (p *parser) varDefs() ([]varDef, error) {
+	if err := p.expect(tokPunct, "("); err != nil {
+		return nil, err
+	}
+	var vars []varDef
+	for !p.peek(tokPunct, ")") {
+		if err := p.expect(tokPunct, "$"); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokPunct, ":"); err != nil {
+			return nil, err
+		}
+		if err := p.typeRef(); err != nil {
+			return nil, err
+		}
+		v := varDef{name: name}
+		if p.peek(tokPunct, "=") {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if v.def, err = p.value(true); err != nil {
+				return nil, err
+			}
+		}
+		vars = append(vars, v)
+	}
+	return vars, p.next()
+}
+
+// typeRef skips a variable's type; arguments check the values they get.
+func (p *parser) typeRef() error {
+	if p.peek(tokPunct, "[") {
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.typeRef(); err != nil {
+			return err
+		}
+		if err := p.expect(tokPunct, "]"); err != nil {
+			return err
+		}
+	} else if _, err := p.name(); err != nil {
+		return err
+	}
+	if p.peek(tokPunct, "!") {
+		return p.next()
+	}
+	return nil
+}
+
+func (p *parser) fragment() (*fragment, error) {
+	f := &fragment{loc: p.tok.loc}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, &Error{Message: "a fragment cannot be named on", Locations: []Location{f.loc}}
+	}
+	f.name = name
+	if err := p.expect(tokName, "on"); err != nil {
+		return nil, err
+	}
+	if f.typeCond, err = p.name(); err != nil {
+		return nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	if f.selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *parser) selectionSet() ([]*selection, error) {
+	if err := p.expect(tokPunct, "{"); err != nil {
+		return nil, err
+	}
+	var sels []*selection
+	for !p.peek(tokPunct, "}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, &Error{Message: "a selection set cannot be empty", Locations: []Location{p.tok.loc}}
+	}
+	return sels, p.next()
+}
+
+func (p *parser) selection() (*selection, error) {
+	sel := &selection{loc: p.tok.loc}
+	var err error
+	if p.peek(tokPunct, "...") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		switch {
+		case p.peek(tokName, "on"):
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if sel.typeCond, err = p.name(); err != nil {
+				return nil, err
+			}
+			sel.inline = true
+		case p.tok.kind == tokName:
+			sel.spread = p.tok.value
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		default:
+			sel.inline = true
+		}
+		if sel.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		if sel.inline {
+			if sel.selections, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+		}
+		return sel, nil
+	}
+
+	if sel.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.peek(tokPunct, ":") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		sel.alias = sel.name
+		if sel.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek(tokPunct, "(") {
+		if sel.args, err = p.arguments(); err != nil {
+			return nil, err
+		}
+	}
+	if sel.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek(tokPunct, "{") {
+		if sel.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+func (p *parser) arguments() ([]argument, error) {
+	if err := p.expect(tokPunct, "("); err != nil {
+		return nil, err
+	}
+	var args []argument
+	for !p.peek(tokPunct, ")") {
+		loc := p.tok.loc
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range args {
+			if a.name == name {
+				return nil, &Error{Message: fmt.Sprintf("argument %s is given twice", name), Locations: []Location{loc}}
+			}
+		}
+		if err := p.expect(tokPunct, ":"); err != nil {
+			return nil, err
+		}
+		value, err := p.value(false)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, argument{name: name, value: value})
+	}
+	if len(args) == 0 {
+		return nil, &Error{Message: "an argument list cannot be empty", Locations: []Location{p.tok.loc}}
+	}
+	return args, p.next()
+}
+
+func (p *parser) directives() ([]directive, error) {
+	var dirs []directive
+	for p.peek(tokPunct, "@") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		d := directive{name: name}
+		if p.peek(tokPunct, "(") {
+			if d.args, err = p.arguments(); err != nil {
+				return nil, err
+			}
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
+
+// value parses a value; constant values, such as variable defaults, may
+// not refer to variables.
+func (p *parser) value(constant bool) (any, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokInt:
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, &Error{Message: fmt.Sprintf("integer %s is out of range", tok.value), Locations: []Location{tok.loc}}
+		}
+		return n, p.next()
+	case tokFloat:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, &Error{Message: fmt.Sprintf("invalid number %s", tok.value), Locations: []Location{tok.loc}}
+		}
+		return f, p.next()
+	case tokString:
+		return tok.value, p.next()
+	case tokName:
+		var v any
+		switch tok.value {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			v = enumValue(tok.value)
+		}
+		return v, p.next()
+	}
+
+	switch {
+	case p.peek(tokPunct, "$") && !constant:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return varRef(name), err
+	case p.peek(tokPunct, "["):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		list := []any{}
+		for !p.peek(tokPunct, "]") {
+			v, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, p.next()
+	case p.peek(tokPunct, "{"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		obj := map[string]any{}
+		for !p.peek(tokPunct, "}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokPunct, ":"); err != nil {
+				return nil, err
+			}
+			if obj[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return obj, p.next()
+	}
+	return nil, p.unexpected()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.next()
+}
+
+func (p *parser) peek(kind int, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+func (p *parser) expect(kind int, value string) error {
+	if !p.peek(kind, value) {
+		return &Error{Message: fmt.Sprintf("expected %q, found %s", value, describe(p.tok)), Locations: []Location{p.tok.loc}}
+	}
+	return p.next()
+}
+
+func (p *parser) unexpected() error {
+	return &Error{Message: "unexpected " + describe(p.tok), Locations: []Location{p.tok.loc}}
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokEOF:
+		return "end of document"
+	case tokString:
+		return "string " + strconv.Quote(t.value)
+	default:
+		return strconv.Quote(t.value)
+	}
+}
+
+// advance moves past n bytes of the source on one line.
+func (p *parser) advance(n int) {
+	p.pos += n
+	p.col += n
+}
+
+// next reads the following token into p.tok.
+func (p *parser) next() error {
+	// Skip whitespace, commas, comments and byte order marks.
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			p.pos++
+			p.line++
+			p.col = 1
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			p.advance(1)
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.advance(1)
+			}
+		case strings.HasPrefix(p.src[p.pos:], "\uFEFF"):
+			p.advance(len("\uFEFF"))
+		default:
+			goto scan
+		}
+	}
+scan:
+	loc := Location{Line: p.line, Column: p.col}
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, loc: loc}
+		return nil
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.advance(3)
+		p.tok = token{kind: tokPunct, value: "...", loc: loc}
+	case strings.IndexByte("!$&()*:=@[]{|}", c) >= 0:
+		p.advance(1)
+		p.tok = token{kind: tokPunct, value: string(c), loc: loc}
+	case c == '_' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z':
+		start := p.pos
+		for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+			p.advance(1)
+		}
+		p.tok = token{kind: tokName, value: p.src[start:p.pos], loc: loc}
+	case c == '-' || '0' <= c && c <= '9':
+		return p.number(loc)
+	case c == '"':
+		return p.string(loc)
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		return &Error{Message: fmt.Sprintf("unexpected character %q", r), Locations: []Location{loc}}
+	}
+	return nil
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9'
+}
+
+func (p *parser) number(loc Location) error {
+	start := p.pos
+	kind := tokInt
+	digits := func() int {
+		n := 0
+		for p.pos < len(p.src) && '0' <= p.src[p.pos] && p.src[p.pos] <= '9' {
+			p.advance(1)
+			n++
+		}
+		return n
+	}
+	if p.src[p.pos] == '-' {
+		p.advance(1)
+	}
+	if digits() == 0 {
+		return &Error{Message: "invalid number", Locations: []Location{loc}}
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		kind = tokFloat
+		p.advance(1)
+		if digits() == 0 {
+			return &Error{Message: "invalid number", Locations: []Location{loc}}
+		}
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		kind = tokFloat
+		p.advance(1)
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.advance(1)
+		}
+		if digits() == 0 {
+			return &Error{Message: "invalid number", Locations: []Location{loc}}
+		}
+	}
+	if p.pos < len(p.src) && (isNameChar(p.src[p.pos]) || p.src[p.pos] == '.') {
+		return &Error{Message: "invalid number", Locations: []Location{loc}}
+	}
+	p.tok = token{kind: kind, value: p.src[start:p.pos], loc: loc}
+	return nil
+}
+
+// string reads a quoted string. Block strings are not supported.
+func (p *parser) string(loc Location) error {
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		return &Error{Message: "block strings are not supported", Locations: []Location{loc}}
+	}
+	p.advance(1)
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			return &Error{Message: "unterminated string", Locations: []Location{loc}}
+		}
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.advance(1)
+			p.tok = token{kind: tokString, value: b.String(), loc: loc}
+			return nil
+		case c == '\\':
+			if p.pos+1 >= len(p.src) {
+				return &Error{Message: "unterminated string", Locations: []Location{loc}}
+			}
+			esc := p.src[p.pos+1]
+			if esc == 'u' {
+				if p.pos+6 > len(p.src) {
+					return &Error{Message: "invalid unicode escape", Locations: []Location{loc}}
+				}
+				n, err := strconv.ParseUint(p.src[p.pos+2:p.pos+6], 16, 16)
+				if err != nil {
+					return &Error{Message: "invalid unicode escape", Locations: []Location{loc}}
+				}
+				b.WriteRune(rune(n))
+				p.advance(6)
+				continue
+			}
+			i := strings.IndexByte(`"\/bfnrt`, esc)
+			if i < 0 {
+				return &Error{Message: fmt.Sprintf("invalid escape \\%c", esc), Locations: []Location{loc}}
+			}
+			b.WriteByte("\"\\/\b\f\n\r\t"[i])
+			p.advance(2)
+		default:
+			_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			b.WriteString(p.src[p.pos : p.pos+size])
+			p.pos += size
+			p.col++
+		}
+	}
+}
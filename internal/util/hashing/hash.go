@@ -24,3 +24,16 @@ func BlobDir(hash string) string {
 	}
 	return hash[:2]
 }
+
+// IsSHA256 reports whether s is a lowercase hex-encoded SHA256 digest.
+func IsSHA256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -59,3 +59,21 @@ func TestBlobDir(t *testing.T) {
 		}
 	}
 }
+
+func TestIsSHA256(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", true},
+		{"E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855", false},
+		{"e3b0c442", false},
+		{"../../../../etc/passwd0000000000000000000000000000000000000000000", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsSHA256(tt.in); got != tt.want {
+			t.Errorf("IsSHA256(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
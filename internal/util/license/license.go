@@ -0,0 +1,221 @@
+// Package license identifies the licenses a package declares, from its
+// license files and the license fields of its manifest, as SPDX
+// identifiers.
+package license
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MaxFileSize is how much of a candidate file Detect needs; license texts
+// and manifests are identified from their start.
+const MaxFileSize = 64 << 10
+
+// maxDepth is how deep in an archive a file may sit and still describe the
+// package, which covers a top-level directory such as "app-1.0/". Deeper
+// files belong to vendored dependencies.
+const maxDepth = 2
+
+// IsCandidate reports whether the archive member at name can declare the
+// package's license: a LICENSE, LICENCE or COPYING file, or a package.json,
+// Cargo.toml, PKG-INFO or METADATA manifest, near the top of the archive.
+func IsCandidate(name string) bool {
+	if strings.Count(name, "/") >= maxDepth {
+		return false
+	}
+	base := path.Base(name)
+	switch base {
+	case "package.json", "Cargo.toml", "PKG-INFO", "METADATA":
+		return true
+	}
+	upper := strings.ToUpper(base)
+	for _, prefix := range []string{"LICENSE", "LICENCE", "COPYING"} {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect returns the SPDX identifiers declared by the candidate file name,
+// given its first MaxFileSize bytes, in order and without repeats. An SPDX
+// expression such as "MIT OR Apache-2.0" yields each license it names.
+func Detect(name string, content []byte) []string {
+	var ids []string
+	switch path.Base(name) {
+	case "package.json":
+		ids = fromPackageJSON(content)
+	case "Cargo.toml":
+		ids = fromHeader(content, "license", " = ")
+	case "PKG-INFO", "METADATA":
+		ids = fromHeader(content, "License-Expression", ": ")
+	default:
+		ids = fromText(content)
+	}
+	return unique(ids)
+}
+
+// spdxTag matches SPDX-License-Identifier lines.
+var spdxTag = regexp.MustCompile(`SPDX-License-Identifier:\s*([^\r\n*]+)`)
+
+// fromText identifies a license file by its SPDX tag or, failing that, by
+// phrases from the license's text.
+func fromText(content []byte) []string {
+	if m := spdxTag.FindSubmatch(content); m != nil {
+		return parseExpression(string(m[1]))
+	}
+	text := strings.Join(strings.Fields(string(content)), " ")
+	for _, t := range texts {
+		if t.matches(text) {
+			return []string{t.id}
+		}
+	}
+	return nil
+}
+
+// licenseText recognizes a license's text: every phrase must appear, and
+// none of the excluded ones, which tell apart licenses that share wording.
+type licenseText struct {
+	id       string
+	phrases  []string
+	excludes []string
+}
+
+func (t licenseText) matches(text string) bool {
+	for _, p := range t.phrases {
+		if !strings.Contains(text, p) {
+			return false
+		}
+	}
+	for _, p := range t.excludes {
+		if strings.Contains(text, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// texts are checked in order, more specific licenses first.
+var texts = []licenseText{
+	{id: "AGPL-3.0", phrases: []string{"GNU AFFERO GENERAL PUBLIC LICENSE", "Version 3"}},
+	{id: "LGPL-3.0", phrases: []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 3"}},
+	{id: "LGPL-2.1", phrases: []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 2.1"}},
+	{id: "GPL-3.0", phrases: []string{"GNU GENERAL PUBLIC LICENSE", "Version 3"}},
+	{id: "GPL-2.0", phrases: []string{"GNU GENERAL PUBLIC LICENSE", "Version 2"}},
+	{id: "Apache-2.0", phrases: []string{"Apache License", "Version 2.0"}},
+	{id: "MPL-2.0", phrases: []string{"Mozilla Public License", "2.0"}},
+	{id: "EPL-2.0", phrases: []string{"Eclipse Public License - v 2.0"}},
+	{id: "Unlicense", phrases: []string{"This is free and unencumbered software released into the public domain"}},
+	{id: "MIT", phrases: []string{"Permission is hereby granted, free of charge"}},
+	{id: "ISC", phrases: []string{"Permission to use, copy, modify, and", "distribute this software for any purpose with or without fee is hereby granted"}},
+	{id: "BSD-3-Clause", phrases: []string{"Redistribution and use in source and binary forms", "Neither the name"}},
+	{id: "BSD-2-Clause", phrases: []string{"Redistribution and use in source and binary forms"}, excludes: []string{"Neither the name", "advertising materials"}},
+}
+
+// fromPackageJSON reads the license field of an npm manifest, or the
+// older licenses array.
+func fromPackageJSON(content []byte) []string {
+	var manifest struct {
+		License  json.RawMessage `json:"license"`
+		Licenses []struct {
+			Type string `json:"type"`
+		} `json:"licenses"`
+	}
+	if json.Unmarshal(content, &manifest) != nil {
+		return nil
+	}
+	var expr string
+	if json.Unmarshal(manifest.License, &expr) == nil {
+		return parseExpression(expr)
+	}
+	var legacy struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(manifest.License, &legacy) == nil && legacy.Type != "" {
+		return parseExpression(legacy.Type)
+	}
+	var ids []string
+	for _, l := range manifest.Licenses {
+		ids = append(ids, parseExpression(l.Type)...)
+	}
+	return ids
+}
+
+// fromHeader reads the first line of content starting with key and sep,
+// such as Cargo.toml's `license = "MIT"` or PKG-INFO's
+// "License-Expression: MIT".
+func fromHeader(content []byte, key, sep string) []string {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		value, ok := strings.CutPrefix(line, key)
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		value, ok = strings.CutPrefix(value, strings.TrimSpace(sep))
+		if !ok {
+			continue
+		}
+		return parseExpression(strings.Trim(strings.TrimSpace(value), `"'`))
+	}
+	return nil
+}
+
+// parseExpression splits an SPDX license expression into the identifiers
+// it names, dropping operators, parentheses and exceptions. npm's
+// "SEE LICENSE IN <file>" names none.
+func parseExpression(expr string) []string {
+	if strings.HasPrefix(strings.ToUpper(expr), "SEE LICENSE") {
+		return nil
+	}
+	fields := strings.Fields(strings.NewReplacer("(", " ", ")", " ", "/", " OR ").Replace(expr))
+	var ids []string
+	for i := 0; i < len(fields); i++ {
+		switch strings.ToUpper(fields[i]) {
+		case "AND", "OR":
+			continue
+		case "WITH":
+			i++ // skip the exception
+			continue
+		}
+		if validID(fields[i]) {
+			ids = append(ids, fields[i])
+		}
+	}
+	return ids
+}
+
+// validID reports whether id looks like an SPDX identifier.
+func validID(id string) bool {
+	if id == "" || strings.EqualFold(id, "UNLICENSED") {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' || r == '+' || r == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// unique drops repeated identifiers, keeping the first of each.
+func unique(ids []string) []string {
+	seen := make(map[string]bool)
+	out := ids[:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package license
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestIsCandidate(t *testing.T) {
+	for name, want := range map[string]bool{
+		"LICENSE":                    true,
+		"app-1.0/LICENSE.md":         true,
+		"app-1.0/Licence.txt":        true,
+		"app-1.0/COPYING.LESSER":     true,
+		"package/package.json":       true,
+		"lib-0.1.0/Cargo.toml":       true,
+		"pkg-1.0.dist-info/METADATA": true,
+		"app-1.0/PKG-INFO":           true,
+		"app-1.0/vendor/dep/LICENSE": false,
+		"app-1.0/README.md":          false,
+		"app-1.0/src/licenses.go":    false,
+		"package/lib/x/package.json": false,
+	} {
+		if got := IsCandidate(name); got != want {
+			t.Errorf("IsCandidate(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for _, tc := range []struct {
+		name, content string
+		want          []string
+	}{
+		{"LICENSE", "MIT License\n\nCopyright (c) 2024 Acme\n\nPermission is hereby granted, free of charge, to any person", []string{"MIT"}},
+		{"LICENSE", "\n                                 Apache License\n                           Version 2.0, January 2004\n", []string{"Apache-2.0"}},
+		{"COPYING", "GNU GENERAL PUBLIC LICENSE\n   Version 2, June 1991", []string{"GPL-2.0"}},
+		{"COPYING.LESSER", "GNU LESSER GENERAL PUBLIC LICENSE\n Version 3, 29 June 2007", []string{"LGPL-3.0"}},
+		{"LICENSE", "GNU AFFERO GENERAL PUBLIC LICENSE\nVersion 3, 19 November 2007", []string{"AGPL-3.0"}},
+		{"LICENSE", "Redistribution and use in source and binary forms, with or without modification...\n3. Neither the name of the copyright holder", []string{"BSD-3-Clause"}},
+		{"LICENSE", "Redistribution and use in source and binary forms, with or without modification", []string{"BSD-2-Clause"}},
+		{"LICENSE", "// SPDX-License-Identifier: GPL-2.0-only WITH Linux-syscall-note\n", []string{"GPL-2.0-only"}},
+		{"LICENSE", "All rights reserved.", nil},
+		{"package.json", `{"name": "app", "license": "(MIT OR Apache-2.0)"}`, []string{"MIT", "Apache-2.0"}},
+		{"package.json", `{"license": {"type": "ISC"}}`, []string{"ISC"}},
+		{"package.json", `{"licenses": [{"type": "MIT"}, {"type": "GPL-2.0"}]}`, []string{"MIT", "GPL-2.0"}},
+		{"package.json", `{"license": "UNLICENSED"}`, nil},
+		{"package.json", `{"license": "SEE LICENSE IN LICENSE.txt"}`, nil},
+		{"Cargo.toml", "[package]\nname = \"lib\"\nlicense-file = \"LICENSE\"\nlicense = \"MIT/Apache-2.0\"\n", []string{"MIT", "Apache-2.0"}},
+		{"METADATA", "Metadata-Version: 2.4\nName: pkg\nLicense-Expression: BSD-3-Clause AND MIT\n", []string{"BSD-3-Clause", "MIT"}},
+		{"PKG-INFO", "Metadata-Version: 2.1\nName: pkg\nLicense: MIT License\n", nil},
+	} {
+		if got := Detect(tc.name, []byte(tc.content)); !slices.Equal(got, tc.want) {
+			t.Errorf("Detect(%s, %.40q) = %v, want %v", tc.name, tc.content, got, tc.want)
+		}
+	}
+}
@@ -11,7 +11,18 @@ import (
 
 type ctxKey string
 
-const requestIDKey ctxKey = "request_id"
+const (
+	requestIDKey ctxKey = "request_id"
+	clientIPKey  ctxKey = "client_ip"
+)
+
+func init() {
+	// Log timestamps in UTC so they line up with stored metadata regardless
+	// of the host's timezone.
+	zerolog.TimestampFunc = func() time.Time {
+		return time.Now().UTC()
+	}
+}
 
 // New creates a new zerolog.Logger writing JSON to the given writer.
 func New(w io.Writer) zerolog.Logger {
@@ -32,10 +43,22 @@ func RequestID(ctx context.Context) string {
 	return v
 }
 
+// WithClientIP adds the client's IP address to the context.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// ClientIP extracts the client's IP address from context.
+func ClientIP(ctx context.Context) string {
+	v, _ := ctx.Value(clientIPKey).(string)
+	return v
+}
+
 // LogRequest logs an HTTP request with standard fields.
 func LogRequest(logger zerolog.Logger, ctx context.Context, method, path string, status int, size int64, latency time.Duration) {
 	logger.Info().
 		Str("request_id", RequestID(ctx)).
+		Str("client_ip", ClientIP(ctx)).
 		Str("method", method).
 		Str("path", path).
 		Int("status", status).
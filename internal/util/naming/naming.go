@@ -0,0 +1,110 @@
+// Package naming checks package names and versions against a registry's
+// naming rules, so names that break downstream tooling, such as ones with
+// spaces or emoji, are refused at upload and found among stored packages.
+package naming
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/foundry/registry/internal/util/semver"
+)
+
+// Rules are the naming rules. The zero value allows every name.
+type Rules struct {
+	// PackagePattern and VersionPattern, if set, must match the whole
+	// package name and version.
+	PackagePattern *regexp.Regexp
+	VersionPattern *regexp.Regexp
+	// RequireSemver requires versions to be valid semver.
+	RequireSemver bool
+	// MaxPackageLength and MaxVersionLength bound names in characters;
+	// zero leaves them unbounded.
+	MaxPackageLength int
+	MaxVersionLength int
+	// ReservedPrefixes are package name prefixes only admins may create
+	// packages under.
+	ReservedPrefixes []string
+	// Lowercase makes package names lowercase: Normalize folds them and
+	// names with upper case letters break the rules.
+	Lowercase bool
+}
+
+// Normalize returns the name a package uploaded as name is stored under.
+func (rl *Rules) Normalize(name string) string {
+	if rl.Lowercase {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// Check returns the first rule pkg or version breaks, or nil.
+func (rl *Rules) Check(pkg, version string) error {
+	if problems := rl.PackageProblems(pkg); len(problems) > 0 {
+		return fmt.Errorf("invalid package name %q: %s", pkg, problems[0])
+	}
+	if problems := rl.VersionProblems(version); len(problems) > 0 {
+		return fmt.Errorf("invalid version %q: %s", version, problems[0])
+	}
+	return nil
+}
+
+// PackageProblems describes each rule the package name breaks.
+func (rl *Rules) PackageProblems(name string) []string {
+	var problems []string
+	if name == "" {
+		return []string{"empty"}
+	}
+	if n := utf8.RuneCountInString(name); rl.MaxPackageLength > 0 && n > rl.MaxPackageLength {
+		problems = append(problems, fmt.Sprintf("%d characters, longer than %d", n, rl.MaxPackageLength))
+	}
+	if rl.PackagePattern != nil && !rl.PackagePattern.MatchString(name) {
+		problems = append(problems, "does not match "+pattern(rl.PackagePattern))
+	}
+	if rl.Lowercase && strings.ToLower(name) != name {
+		problems = append(problems, "not lowercase")
+	}
+	return problems
+}
+
+// VersionProblems describes each rule the version breaks.
+func (rl *Rules) VersionProblems(version string) []string {
+	var problems []string
+	if version == "" {
+		return []string{"empty"}
+	}
+	if n := utf8.RuneCountInString(version); rl.MaxVersionLength > 0 && n > rl.MaxVersionLength {
+		problems = append(problems, fmt.Sprintf("%d characters, longer than %d", n, rl.MaxVersionLength))
+	}
+	if rl.VersionPattern != nil && !rl.VersionPattern.MatchString(version) {
+		problems = append(problems, "does not match "+pattern(rl.VersionPattern))
+	}
+	if rl.RequireSemver {
+		if _, err := semver.Parse(version); err != nil {
+			problems = append(problems, "not valid semver")
+		}
+	}
+	return problems
+}
+
+// Reserved returns the reserved prefix name starts with, or "".
+func (rl *Rules) Reserved(name string) string {
+	for _, prefix := range rl.ReservedPrefixes {
+		if strings.HasPrefix(rl.Normalize(name), rl.Normalize(prefix)) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// Anchor compiles expr so that it must match a whole name.
+func Anchor(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + expr + `)$`)
+}
+
+// pattern is the pattern re was compiled from by Anchor.
+func pattern(re *regexp.Regexp) string {
+	return strings.TrimSuffix(strings.TrimPrefix(re.String(), `^(?:`), `)$`)
+}
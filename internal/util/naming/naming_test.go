@@ -0,0 +1,65 @@
+package naming
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	pkgPattern, err := Anchor(`[a-z0-9][a-z0-9._-]*`)
+	if err != nil {
+		t.Fatalf("Anchor: %v", err)
+	}
+	rules := &Rules{
+		PackagePattern:   pkgPattern,
+		RequireSemver:    true,
+		MaxPackageLength: 10,
+		MaxVersionLength: 12,
+		ReservedPrefixes: []string{"Foundry-"},
+		Lowercase:        true,
+	}
+
+	for _, tc := range []struct {
+		pkg, version string
+		problem      string
+	}{
+		{"lib", "1.0.0", ""},
+		{"lib-extra", "1.0.0-rc.1", ""},
+		{"my lib", "1.0.0", `invalid package name "my lib": does not match [a-z0-9][a-z0-9._-]*`},
+		{"lib🚀", "1.0.0", `invalid package name "lib🚀": does not match`},
+		{"Lib", "1.0.0", `invalid package name "Lib": does not match`},
+		{"library-extra", "1.0.0", `invalid package name "library-extra": 13 characters, longer than 10`},
+		{"", "1.0.0", `invalid package name "": empty`},
+		{"lib", "nightly", `invalid version "nightly": not valid semver`},
+		{"lib", "1.0.0-alpha.1.2", `invalid version "1.0.0-alpha.1.2": 15 characters, longer than 12`},
+	} {
+		err := rules.Check(tc.pkg, tc.version)
+		switch {
+		case tc.problem == "" && err != nil:
+			t.Errorf("Check(%q, %q) = %v, want nil", tc.pkg, tc.version, err)
+		case tc.problem != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.problem)):
+			t.Errorf("Check(%q, %q) = %v, want %s...", tc.pkg, tc.version, err, tc.problem)
+		}
+	}
+
+	if got := rules.PackageProblems("My Lib"); len(got) != 2 || got[1] != "not lowercase" {
+		t.Errorf("PackageProblems(My Lib) = %q, want a pattern and a case problem", got)
+	}
+	if got := rules.Normalize("MyLib"); got != "mylib" {
+		t.Errorf("Normalize(MyLib) = %q", got)
+	}
+	if got := rules.Reserved("foundry-core"); got != "Foundry-" {
+		t.Errorf("Reserved(foundry-core) = %q, want Foundry-", got)
+	}
+	if got := rules.Reserved("foundryx"); got != "" {
+		t.Errorf("Reserved(foundryx) = %q, want none", got)
+	}
+
+	var zero Rules
+	if err := zero.Check("any name 🚀", "whatever"); err != nil {
+		t.Errorf("zero Rules should allow every name, got %v", err)
+	}
+	if got := zero.Normalize("MyLib"); got != "MyLib" {
+		t.Errorf("zero Rules Normalize(MyLib) = %q", got)
+	}
+}
@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestNilLimiterIsUnlimited(t *testing.T) {
+	if l := NewLimiter(0); l != nil {
+		t.Fatal("expected nil limiter for zero rate")
+	}
+	var l *Limiter
+	if err := l.WaitN(context.Background(), 1<<30); err != nil {
+		t.Fatalf("WaitN on nil limiter: %v", err)
+	}
+	r := bytes.NewReader([]byte("x"))
+	if NewReader(context.Background(), r, nil) != io.Reader(r) {
+		t.Error("expected reader to be returned unchanged")
+	}
+}
+
+func TestReaderIsPaced(t *testing.T) {
+	const rate = 64 << 10
+	data := make([]byte, 3*rate)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, NewReader(context.Background(), bytes.NewReader(data), NewLimiter(rate)))
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if n != int64(len(data)) {
+		t.Fatalf("copied %d bytes, want %d", n, len(data))
+	}
+	// One second of burst is available up front, the rest is metered.
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Errorf("3s worth of data at the limit took only %v", elapsed)
+	}
+}
+
+func TestWriterHonorsContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := NewWriter(ctx, io.Discard, NewLimiter(1024))
+
+	if _, err := w.Write(make([]byte, 1024)); err != nil {
+		t.Fatalf("first write within burst: %v", err)
+	}
+	cancel()
+	if _, err := w.Write(make([]byte, 4096)); err == nil {
+		t.Error("expected context error once the bucket is drained")
+	}
+}
@@ -0,0 +1,149 @@
+// Package secrets resolves references to secrets kept outside the config
+// file: in files, in environment variables or in HashiCorp Vault.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// The schemes of secret references. Values of other forms are secrets in
+// their own right.
+//
+//	file:///run/secrets/token      the file's contents, without a trailing newline
+//	env://FOUNDRY_TOKEN            the environment variable
+//	vault://secret/data/foundry#token
+//	                               the field of a Vault secret, "value" if none
+//	                               is given, read from VAULT_ADDR with VAULT_TOKEN
+const (
+	FileScheme  = "file://"
+	EnvScheme   = "env://"
+	VaultScheme = "vault://"
+)
+
+// IsRef reports whether s refers to a secret rather than being one.
+func IsRef(s string) bool {
+	return strings.HasPrefix(s, FileScheme) || strings.HasPrefix(s, EnvScheme) || strings.HasPrefix(s, VaultScheme)
+}
+
+// Resolver reads referenced secrets. The zero value reads the process
+// environment and reaches Vault with a client that gives up after 10s.
+type Resolver struct {
+	// LookupEnv reads environment variables, including VAULT_ADDR and
+	// VAULT_TOKEN; nil uses os.LookupEnv.
+	LookupEnv func(string) (string, bool)
+	// Client reaches Vault; nil uses a client with a 10s timeout.
+	Client *http.Client
+}
+
+// Resolve returns the secret ref refers to, or ref itself if it is not a
+// reference.
+func (r *Resolver) Resolve(ctx context.Context, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, FileScheme):
+		path := strings.TrimPrefix(ref, FileScheme)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading secret: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(ref, EnvScheme):
+		name := strings.TrimPrefix(ref, EnvScheme)
+		value, ok := r.lookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret %s: environment variable %s is not set", ref, name)
+		}
+		return value, nil
+	case strings.HasPrefix(ref, VaultScheme):
+		return r.vault(ctx, strings.TrimPrefix(ref, VaultScheme))
+	}
+	return ref, nil
+}
+
+// ResolveList resolves each of refs and splits what it refers to into
+// lines, so one file can hold a list of secrets. Blank lines are skipped;
+// values that are not references are kept whole.
+func (r *Resolver) ResolveList(ctx context.Context, refs []string) ([]string, error) {
+	var values []string
+	for _, ref := range refs {
+		if !IsRef(ref) {
+			values = append(values, ref)
+			continue
+		}
+		value, err := r.Resolve(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(value, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				values = append(values, line)
+			}
+		}
+	}
+	return values, nil
+}
+
+func (r *Resolver) lookupEnv(name string) (string, bool) {
+	if r.LookupEnv != nil {
+		return r.LookupEnv(name)
+	}
+	return os.LookupEnv(name)
+}
+
+// vault reads a field of the secret at path from Vault's HTTP API. Secrets
+// of both KV version 1 and 2 engines are understood.
+func (r *Resolver) vault(ctx context.Context, ref string) (string, error) {
+	path, field, _ := strings.Cut(ref, "#")
+	if field == "" {
+		field = "value"
+	}
+	addr, _ := r.lookupEnv("VAULT_ADDR")
+	token, _ := r.lookupEnv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", fmt.Errorf("secret %s%s: VAULT_ADDR and VAULT_TOKEN must be set", VaultScheme, ref)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", fmt.Errorf("secret %s%s: %w", VaultScheme, ref, err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	client := r.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("reading secret %s%s: %w", VaultScheme, ref, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("reading secret %s%s: vault answered %s: %s", VaultScheme, ref, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("reading secret %s%s: %w", VaultScheme, ref, err)
+	}
+	data := body.Data
+	// KV version 2 nests the secret's fields under data.data.
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nested
+		}
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s%s: no string field %q", VaultScheme, ref, field)
+	}
+	return value, nil
+}
@@ -0,0 +1,84 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/foundry":
+			w.Write([]byte(`{"data":{"data":{"token":"from-kv2"},"metadata":{"version":3}}}`))
+		case "/v1/kv/foundry":
+			w.Write([]byte(`{"data":{"value":"from-kv1","data":"not nested"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer vault.Close()
+
+	env := map[string]string{"FOUNDRY_TOKEN": "from-env", "VAULT_ADDR": vault.URL + "/", "VAULT_TOKEN": "root"}
+	r := &Resolver{LookupEnv: func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}}
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for ref, want := range map[string]string{
+		"inline":                            "inline",
+		FileScheme + path:                   "from-file",
+		"env://FOUNDRY_TOKEN":               "from-env",
+		"vault://secret/data/foundry#token": "from-kv2",
+		"vault://kv/foundry":                "from-kv1",
+	} {
+		if got, err := r.Resolve(ctx, ref); err != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", ref, got, err, want)
+		}
+	}
+	for _, ref := range []string{
+		FileScheme + filepath.Join(t.TempDir(), "missing"),
+		"env://UNSET",
+		"vault://secret/data/foundry#password",
+		"vault://secret/data/other",
+	} {
+		if _, err := r.Resolve(ctx, ref); err == nil {
+			t.Errorf("Resolve(%q) should fail", ref)
+		}
+	}
+	env["VAULT_TOKEN"] = "wrong"
+	if _, err := r.Resolve(ctx, "vault://kv/foundry"); err == nil {
+		t.Error("a refused Vault token should fail")
+	}
+}
+
+func TestResolveList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("one\n\n  two  \nthree\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r := &Resolver{LookupEnv: func(string) (string, bool) { return "", false }}
+	got, err := r.ResolveList(context.Background(), []string{"inline token", FileScheme + path})
+	if err != nil {
+		t.Fatalf("ResolveList: %v", err)
+	}
+	if want := []string{"inline token", "one", "two", "three"}; !slices.Equal(got, want) {
+		t.Errorf("ResolveList = %q, want %q", got, want)
+	}
+	if _, err := r.ResolveList(context.Background(), []string{"env://UNSET"}); err == nil {
+		t.Error("an unresolvable reference should fail the list")
+	}
+}
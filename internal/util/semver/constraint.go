@@ -0,0 +1,181 @@
+package semver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constraint is a version requirement such as "^1.2", ">=1.0, <2" or
+// "1.x || 2.x". Comparators within a range are ANDed; ranges separated by
+// "||" are ORed.
+type Constraint struct {
+	raw    string
+	ranges [][]comparator
+}
+
+type comparator struct {
+	op string // one of =, !=, <, <=, >, >=
+	v  Version
+}
+
+// ParseConstraint parses a constraint. Comparators are separated by commas
+// or spaces and take the operators =, !=, <, <=, >, >=, ^ and ~. A bare
+// version means =, and missing or wildcard components match any value, so
+// "1.2", "1.2.x" and "=1.2" all mean >=1.2.0, <1.3.0. An empty constraint
+// or "*" matches every release.
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{raw: strings.TrimSpace(s)}
+	for _, group := range strings.Split(s, "||") {
+		fields := strings.FieldsFunc(group, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		var set []comparator
+		for i := 0; i < len(fields); i++ {
+			term := fields[i]
+			// Allow a space between an operator and its version.
+			if strings.Trim(term, "=!<>^~") == "" && i+1 < len(fields) {
+				i++
+				term += fields[i]
+			}
+			comps, err := parseComparator(term)
+			if err != nil {
+				return Constraint{}, fmt.Errorf("invalid constraint %q: %w", s, err)
+			}
+			set = append(set, comps...)
+		}
+		c.ranges = append(c.ranges, set)
+	}
+	return c, nil
+}
+
+// parseComparator expands one term into primitive comparators.
+func parseComparator(term string) ([]comparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, prefix) {
+			op = prefix
+			break
+		}
+	}
+	v, parts, err := parsePartial(term[len(op):])
+	if err != nil {
+		return nil, err
+	}
+
+	// next returns the lowest version above every match of the partial
+	// version, or of its first n components.
+	next := func(n int) Version {
+		switch n {
+		case 0:
+			return Version{Major: ^uint64(0)}
+		case 1:
+			return Version{Major: v.Major + 1}
+		case 2:
+			return Version{Major: v.Major, Minor: v.Minor + 1}
+		}
+		return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+	}
+	floor := Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch, Pre: v.Pre}
+
+	switch op {
+	case "", "=":
+		if parts == 0 {
+			return nil, nil
+		}
+		if parts == 3 {
+			return []comparator{{"=", v}}, nil
+		}
+		return []comparator{{">=", floor}, {"<", next(parts)}}, nil
+	case "!=":
+		if parts < 3 {
+			return nil, fmt.Errorf("!= needs a full version")
+		}
+		return []comparator{{"!=", v}}, nil
+	case ">":
+		if parts == 3 {
+			return []comparator{{">", v}}, nil
+		}
+		return []comparator{{">=", next(parts)}}, nil
+	case ">=":
+		return []comparator{{">=", floor}}, nil
+	case "<":
+		return []comparator{{"<", floor}}, nil
+	case "<=":
+		if parts == 3 {
+			return []comparator{{"<=", v}}, nil
+		}
+		return []comparator{{"<", next(parts)}}, nil
+	case "~":
+		// ~1.2.3 and ~1.2 allow patch changes; ~1 allows minor changes.
+		n := 2
+		if parts < 2 {
+			n = parts
+		}
+		return []comparator{{">=", floor}, {"<", next(n)}}, nil
+	case "^":
+		// ^ allows changes that keep the leftmost non-zero component.
+		n := 1
+		switch {
+		case v.Major == 0 && parts >= 2 && v.Minor == 0 && parts == 3:
+			n = 3
+		case v.Major == 0 && parts >= 2:
+			n = 2
+		}
+		if parts < n {
+			n = parts
+		}
+		return []comparator{{">=", floor}, {"<", next(n)}}, nil
+	}
+	return nil, fmt.Errorf("unknown operator in %q", term)
+}
+
+// String returns the constraint as written.
+func (c Constraint) String() string {
+	return c.raw
+}
+
+// Check reports whether v satisfies the constraint. A pre-release only
+// satisfies a range that names a pre-release of the same MAJOR.MINOR.PATCH,
+// so ranges do not pick up pre-releases by accident.
+func (c Constraint) Check(v Version) bool {
+	for _, set := range c.ranges {
+		if matchesSet(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesSet(set []comparator, v Version) bool {
+	for _, comp := range set {
+		if !comp.matches(v) {
+			return false
+		}
+	}
+	if len(v.Pre) == 0 {
+		return true
+	}
+	for _, comp := range set {
+		if len(comp.v.Pre) > 0 && comp.v.Major == v.Major && comp.v.Minor == v.Minor && comp.v.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+func (c comparator) matches(v Version) bool {
+	cmp := v.Compare(c.v)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+// Package foundrytest runs a Foundry registry in process for integration
+// tests. The registry keeps blobs and metadata in memory and serves the
+// full API, package manager routes included, on a local httptest server
+// that is closed when the test ends.
+package foundrytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/foundry/registry/internal/adapters/auth"
+	"github.com/foundry/registry/internal/adapters/metadata"
+	"github.com/foundry/registry/internal/adapters/storage"
+	"github.com/foundry/registry/internal/api/handlers"
+)
+
+// Token is the admin token a Server accepts unless WithToken replaces it.
+const Token = "foundrytest-token"
+
+// Server is an in-process registry. Its embedded httptest.Server provides
+// URL and Client.
+type Server struct {
+	*httptest.Server
+	// Token is the admin token the server accepts.
+	Token string
+
+	t testing.TB
+}
+
+// Option configures a Server.
+type Option func(*options)
+
+type options struct {
+	token      string
+	quarantine bool
+}
+
+// WithToken sets the admin token the server accepts.
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// WithQuarantine makes new uploads wait for approval, as the server's
+// policy.quarantine setting does.
+func WithQuarantine() Option {
+	return func(o *options) {
+		o.quarantine = true
+	}
+}
+
+// New starts a registry and closes it when t ends. Tokens issued through
+// its admin API are accepted alongside Token.
+func New(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	o := options{token: Token}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	meta := metadata.NewMemoryStore()
+	authenticator := auth.Chain{auth.NewTokenAuth([]string{o.token}), auth.NewStoreAuth(meta)}
+	h := handlers.New(storage.NewMemoryBlobStorage(), meta, authenticator, zerolog.Nop(),
+		handlers.WithTokenStore(meta),
+		handlers.WithCrateIndex(meta),
+		handlers.WithQuarantine(o.quarantine),
+	)
+
+	s := &Server{Server: httptest.NewServer(h.Router()), Token: o.token, t: t}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Seed uploads content as version of pkg and returns its hash. The test
+// fails if the upload is refused.
+func (s *Server) Seed(pkg, version string, content []byte) string {
+	s.t.Helper()
+	return s.upload("/api/v1/artifacts/"+url.PathEscape(pkg)+"/"+url.PathEscape(version), content)
+}
+
+// SeedFile attaches content to an existing version as the file name and
+// returns its hash. The test fails if the upload is refused.
+func (s *Server) SeedFile(pkg, version, name string, content []byte) string {
+	s.t.Helper()
+	return s.upload("/api/v1/artifacts/"+url.PathEscape(pkg)+"/"+url.PathEscape(version)+"/files/"+url.PathEscape(name), content)
+}
+
+func (s *Server) upload(path string, content []byte) string {
+	s.t.Helper()
+	resp := s.Do(http.MethodPost, path, bytes.NewReader(content))
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		s.t.Fatalf("foundrytest: POST %s: %s: %s", path, resp.Status, body)
+	}
+	var created struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		s.t.Fatalf("foundrytest: decoding upload response: %v", err)
+	}
+	return created.Hash
+}
+
+// Do sends a request for path, relative to URL, with the admin token. The
+// test fails if the request cannot be sent; the caller closes the body.
+func (s *Server) Do(method, path string, body io.Reader) *http.Response {
+	s.t.Helper()
+	req, err := http.NewRequest(method, s.URL+path, body)
+	if err != nil {
+		s.t.Fatalf("foundrytest: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		s.t.Fatalf("foundrytest: %s %s: %v", method, path, err)
+	}
+	return resp
+}
@@ -0,0 +1,60 @@
+package foundrytest_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/foundry/registry/pkg/foundrytest"
+)
+
+func TestSeedAndDownload(t *testing.T) {
+	srv := foundrytest.New(t)
+
+	hash := srv.Seed("app", "1.0.0", []byte("binary"))
+	srv.SeedFile("app", "1.0.0", "notes.txt", []byte("notes"))
+
+	resp := srv.Do(http.MethodGet, "/api/v1/artifacts/app/1.0.0", nil)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "binary" {
+		t.Fatalf("download: %s %q", resp.Status, body)
+	}
+	if got := resp.Header.Get("ETag"); got != `"`+hash+`"` {
+		t.Errorf("ETag = %s, want the hash Seed returned, %s", got, hash)
+	}
+
+	files := srv.Do(http.MethodGet, "/api/v1/artifacts/app/1.0.0/files", nil)
+	defer files.Body.Close()
+	var listed []struct {
+		Name string `json:"name"`
+	}
+	json.NewDecoder(files.Body).Decode(&listed)
+	if len(listed) != 2 {
+		t.Errorf("expected the default file and notes.txt, got %+v", listed)
+	}
+}
+
+func TestTokensAndQuarantine(t *testing.T) {
+	srv := foundrytest.New(t, foundrytest.WithToken("secret"), foundrytest.WithQuarantine())
+	if srv.Token != "secret" {
+		t.Fatalf("Token = %q", srv.Token)
+	}
+	srv.Seed("app", "1.0.0", []byte("binary"))
+
+	// Anonymous callers do not see quarantined versions.
+	resp, err := srv.Client().Get(srv.URL + "/api/v1/artifacts/app/1.0.0")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Error("quarantined version should not be served without the admin token")
+	}
+	approve := srv.Do(http.MethodPost, "/api/v1/artifacts/app/1.0.0/approve", nil)
+	approve.Body.Close()
+	if approve.StatusCode != http.StatusOK {
+		t.Fatalf("approve: %s", approve.Status)
+	}
+}
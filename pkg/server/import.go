@@ -0,0 +1,25 @@
+package server
+
+import (
+	"context"
+
+	"github.com/foundry/registry/internal/core/models"
+)
+
+// ImportRequest names the file Import publishes a version from, and
+// ImportResponse describes the version and how its file was taken in.
+type (
+	ImportRequest  = models.ImportRequest
+	ImportResponse = models.ImportResponse
+)
+
+// importPrincipal is who Import acts as in the history and audit log.
+var importPrincipal = &models.Principal{Name: "registry-server import", Admin: true}
+
+// Import publishes pkg@version from the file req names, which must be
+// inside one of Storage.ImportDirs, as an admin's import would be: with the
+// naming rules, policies, hooks and history of an upload. It needs no
+// listener, so a file share can be taken in before the server is started.
+func (s *Server) Import(ctx context.Context, pkg, version string, req ImportRequest) (*ImportResponse, error) {
+	return s.handler.Import(ctx, importPrincipal, pkg, version, req)
+}
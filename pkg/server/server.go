@@ -0,0 +1,734 @@
+// Package server runs a Foundry registry inside another Go program. New
+// wires the storage, metadata, auth and policy adapters from a Config, the
+// same settings the registry-server binary reads from config.yaml, and
+// options replace or extend any of them. Start opens the configured
+// listeners; Shutdown stops them.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/foundry/registry/internal/adapters/auth"
+	"github.com/foundry/registry/internal/adapters/cdn"
+	"github.com/foundry/registry/internal/adapters/federation"
+	"github.com/foundry/registry/internal/adapters/hooks"
+	"github.com/foundry/registry/internal/adapters/malware"
+	"github.com/foundry/registry/internal/adapters/metadata"
+	"github.com/foundry/registry/internal/adapters/notify"
+	"github.com/foundry/registry/internal/adapters/policy"
+	"github.com/foundry/registry/internal/adapters/storage"
+	"github.com/foundry/registry/internal/adapters/transcode"
+	"github.com/foundry/registry/internal/api/handlers"
+	"github.com/foundry/registry/internal/config"
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/internal/core/services"
+	"github.com/foundry/registry/internal/util/clock"
+	"github.com/foundry/registry/internal/util/dsse"
+	"github.com/foundry/registry/internal/util/filelock"
+	"github.com/foundry/registry/internal/util/secrets"
+)
+
+// Config holds the server settings. Its sections are documented with the
+// YAML keys they are read from.
+type Config = config.Config
+
+// The sections of a Config, so it can be built in code.
+type (
+	ServerConfig         = config.ServerConfig
+	ListenerConfig       = config.ListenerConfig
+	StorageConfig        = config.StorageConfig
+	StorageRouteConfig   = config.StorageRouteConfig
+	ChunkingConfig       = config.ChunkingConfig
+	ColdStorageConfig    = config.ColdStorageConfig
+	AuthConfig           = config.AuthConfig
+	TranscodingConfig    = config.TranscodingConfig
+	DownloadsConfig      = config.DownloadsConfig
+	LimitsConfig         = config.LimitsConfig
+	PolicyConfig         = config.PolicyConfig
+	OPAConfig            = config.OPAConfig
+	ExpiryConfig         = config.ExpiryConfig
+	GCConfig             = config.GCConfig
+	HookConfig           = config.HookConfig
+	MalwareConfig        = config.MalwareConfig
+	AttestationsConfig   = config.AttestationsConfig
+	TrustedBuilderConfig = config.TrustedBuilderConfig
+	FederationConfig     = config.FederationConfig
+	PeerConfig           = config.PeerConfig
+	ApprovalsConfig      = config.ApprovalsConfig
+	NamingConfig         = config.NamingConfig
+)
+
+// DefaultConfig returns the settings used for anything a config file
+// leaves out.
+func DefaultConfig() *Config {
+	return config.Default()
+}
+
+// LoadConfig reads a YAML config file over DefaultConfig and validates it.
+func LoadConfig(path string) (*Config, error) {
+	return config.Load(path)
+}
+
+// The adapters a Server is built from, for use with the options below.
+type (
+	BlobStorage    = services.BlobStorage
+	StagedBlob     = services.StagedBlob
+	MetadataStore  = services.MetadataStore
+	Authenticator  = services.Authenticator
+	PolicyEngine   = services.PolicyEngine
+	Hook           = services.Hook
+	MalwareScanner = services.MalwareScanner
+	PeerRegistry   = services.PeerRegistry
+)
+
+// HookEvent describes a request to a Hook. Its Hook field is one of the
+// hook points below.
+type HookEvent = models.HookEvent
+
+// The hook points a Hook runs at.
+const (
+	HookPreUpload   = models.HookPreUpload
+	HookPostUpload  = models.HookPostUpload
+	HookPreDownload = models.HookPreDownload
+	HookPreDelete   = models.HookPreDelete
+)
+
+// ErrHookRejected is wrapped by the error a Hook returns to refuse a
+// request.
+var ErrHookRejected = services.ErrHookRejected
+
+// StorageFactory opens a blob storage backend registered with
+// RegisterStorage. StorageBackendConfig carries the storage.dataDir and
+// storage.options settings.
+type (
+	StorageFactory       = storage.Factory
+	StorageBackendConfig = storage.BackendConfig
+)
+
+// RegisterStorage makes a blob storage backend available under name for
+// the storage.backend setting, alongside the built-in disk, chunked and
+// memory backends. Call it from an init function; it panics if name is
+// already taken.
+func RegisterStorage(name string, factory StorageFactory) {
+	storage.Register(name, factory)
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithLogger sets the logger for requests and background jobs. The default
+// discards everything.
+func WithLogger(logger zerolog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithBlobStorage stores blobs in blobs instead of under
+// Storage.DataDir. Cold storage, if configured, still applies on top.
+func WithBlobStorage(blobs BlobStorage) Option {
+	return func(s *Server) {
+		s.blobs = blobs
+	}
+}
+
+// WithMetadataStore keeps metadata in meta instead of the SQLite database
+// under Storage.DataDir. Token management, accounts, approvals, comments
+// and the Cargo routes are served if meta also implements their stores, as
+// the built-in stores do. The caller closes meta after Shutdown.
+func WithMetadataStore(meta MetadataStore) Option {
+	return func(s *Server) {
+		s.meta = meta
+	}
+}
+
+// WithAuthenticator accepts the tokens a accepts, after the config tokens
+// and the tokens issued through the admin API. An authenticator for SSO
+// credentials sets the principal's User, and its Groups from the claims,
+// so that teams mapped to those groups count the caller as a member.
+func WithAuthenticator(a Authenticator) Option {
+	return func(s *Server) {
+		s.authenticators = append(s.authenticators, a)
+	}
+}
+
+// WithPolicy consults p on uploads, deletes and promotions after the
+// built-in rules and OPA.
+func WithPolicy(p PolicyEngine) Option {
+	return func(s *Server) {
+		s.policies = append(s.policies, p)
+	}
+}
+
+// WithHook runs hook at every hook point, after the hooks in the config.
+// Hooks that only care about some hook points return nil for the others.
+func WithHook(hook Hook) Option {
+	return func(s *Server) {
+		s.hooks = append(s.hooks, hook)
+	}
+}
+
+// WithMalwareScanner scans uploads with scanner instead of the scanner in
+// the config.
+func WithMalwareScanner(scanner MalwareScanner) Option {
+	return func(s *Server) {
+		s.malware = scanner
+	}
+}
+
+// WithPeerRegistry makes searches cover peer too, after the peers in the
+// config.
+func WithPeerRegistry(peer PeerRegistry) Option {
+	return func(s *Server) {
+		s.peers = append(s.peers, peer)
+	}
+}
+
+// Server is a registry built from a Config.
+type Server struct {
+	cfg            Config
+	logger         zerolog.Logger
+	blobs          BlobStorage
+	meta           MetadataStore
+	authenticators []Authenticator
+	policies       []PolicyEngine
+	hooks          []Hook
+	malware        MalwareScanner
+	peers          []PeerRegistry
+	handler        *handlers.Handler
+	tiering        bool
+
+	// authSecrets is the auth config as written, before its secret
+	// references were resolved, for refreshing tokenAuth and signed.
+	authSecrets config.AuthConfig
+	tokenAuth   *auth.TokenAuth
+	signed      *auth.SignedRequests
+
+	// lock, ownMeta and snapshots are released by Shutdown when New
+	// created them.
+	lock      *filelock.Lock
+	ownMeta   *metadata.SQLiteStore
+	snapshots []*metadata.SQLiteStore
+
+	mu        sync.Mutex
+	started   bool
+	stopped   bool
+	servers   []*http.Server
+	listeners []net.Listener
+	stopJobs  context.CancelFunc
+	// jobs counts the background jobs Start began, which Shutdown waits
+	// for before closing the stores they use.
+	jobs  sync.WaitGroup
+	errCh chan error
+	done  chan struct{}
+}
+
+// New validates cfg, reads the secrets it refers to and builds a server
+// from it without opening any listener. The built-in blob storage and
+// metadata store live in Storage.DataDir, which one server at a time may
+// own; it is not touched when options replace both. Without config tokens
+// or signing keys, WithAuthenticator must supply a way in.
+func New(cfg Config, opts ...Option) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	resolved, err := cfg.ResolveSecrets(context.Background(), &secrets.Resolver{})
+	if err != nil {
+		return nil, err
+	}
+	authSecrets := cfg.Auth
+	cfg = *resolved
+	s := &Server{cfg: cfg, authSecrets: authSecrets, logger: zerolog.Nop(), done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if len(cfg.Auth.Tokens) == 0 && len(cfg.Auth.SigningKeys) == 0 && len(s.authenticators) == 0 {
+		return nil, fmt.Errorf("no auth tokens configured")
+	}
+	if err := s.build(); err != nil {
+		s.release()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Server) build() error {
+	cfg := &s.cfg
+	if s.blobs == nil || s.meta == nil {
+		// Only one server may own a data directory at a time.
+		if err := os.MkdirAll(cfg.Storage.DataDir, 0o755); err != nil {
+			return fmt.Errorf("creating data directory: %w", err)
+		}
+		lock, err := filelock.Acquire(filepath.Join(cfg.Storage.DataDir, ".lock"))
+		if err != nil {
+			return fmt.Errorf("locking data directory: %w", err)
+		}
+		s.lock = lock
+	}
+
+	// Initialize blob storage.
+	blobs := s.blobs
+	if blobs == nil {
+		var err error
+		blobs, err = storage.Open(cfg.Storage.Backend, storage.BackendConfig{
+			DataDir: cfg.Storage.DataDir,
+			Options: cfg.Storage.Options,
+		})
+		if err != nil {
+			return fmt.Errorf("initializing blob storage: %w", err)
+		}
+	}
+	// Snapshots are consulted beneath the main backend, the one place
+	// every read falls back to.
+	var snapshots []metadata.Snapshot
+	if len(cfg.Storage.Snapshots) > 0 {
+		snapshotBlobs := make([]BlobStorage, 0, len(cfg.Storage.Snapshots))
+		for _, sc := range cfg.Storage.Snapshots {
+			meta, err := metadata.OpenSnapshot(sc.DataDir)
+			if err != nil {
+				return fmt.Errorf("opening snapshot %s: %w", sc.Name, err)
+			}
+			s.snapshots = append(s.snapshots, meta)
+			snapBlobs, err := storage.Open(sc.Backend, storage.BackendConfig{DataDir: sc.DataDir, Options: sc.Options})
+			if err != nil {
+				return fmt.Errorf("initializing blob storage of snapshot %s: %w", sc.Name, err)
+			}
+			snapshots = append(snapshots, metadata.Snapshot{Name: sc.Name, Store: meta})
+			snapshotBlobs = append(snapshotBlobs, snapBlobs)
+		}
+		blobs = storage.NewLayeredBlobStorage(blobs, snapshotBlobs...)
+	}
+	var tiers *storage.TieredBlobStorage
+	if cfg.Storage.Cold.DataDir != "" {
+		cold, err := storage.NewDiskBlobStorage(cfg.Storage.Cold.DataDir)
+		if err != nil {
+			return fmt.Errorf("initializing cold blob storage: %w", err)
+		}
+		tiers = storage.NewTieredBlobStorage(blobs, cold)
+		blobs = tiers
+	}
+	if len(cfg.Storage.Routes) > 0 {
+		routes := make(map[string]BlobStorage, len(cfg.Storage.Routes))
+		for _, rc := range cfg.Storage.Routes {
+			routed, err := storage.Open(rc.Backend, storage.BackendConfig{DataDir: rc.DataDir, Options: rc.Options})
+			if err != nil {
+				return fmt.Errorf("initializing blob storage for %s packages: %w", rc.Prefix, err)
+			}
+			routes[rc.Prefix] = routed
+		}
+		blobs = storage.NewRoutedBlobStorage(blobs, routes)
+	}
+	s.blobs = blobs
+
+	// Initialize metadata store.
+	if s.meta == nil {
+		meta, err := metadata.NewSQLiteStore(cfg.Storage.DataDir)
+		if err != nil {
+			return fmt.Errorf("initializing metadata store: %w", err)
+		}
+		s.ownMeta = meta
+		s.meta = meta
+	}
+
+	// Initialize authenticator. Static config tokens are admins; tokens
+	// issued through the admin API live in the metadata store.
+	s.tokenAuth = auth.NewTokenAuth(cfg.Auth.Tokens)
+	authenticator := auth.Chain{s.tokenAuth}
+	tokens, _ := s.meta.(services.TokenStore)
+	if tokens != nil {
+		authenticator = append(authenticator, auth.NewStoreAuth(tokens))
+	}
+	authenticator = append(authenticator, s.authenticators...)
+
+	// Initialize the policy engine: built-in rules first, then OPA if
+	// configured, then any the embedder added.
+	policies := policy.Chain{&policy.Rules{
+		RequireSemver:   cfg.Policy.RequireSemver,
+		PackagePrefixes: cfg.Policy.PackagePrefixes,
+		ProtectReleases: cfg.Policy.ProtectReleases,
+		Formats:         cfg.Policy.Formats,
+		MatchFilenames:  cfg.Policy.MatchFilenames,
+	}}
+	if cfg.Policy.OPA.URL != "" {
+		policies = append(policies, policy.NewOPA(cfg.Policy.OPA.URL, cfg.Policy.OPA.Timeout))
+	}
+	policies = append(policies, s.policies...)
+
+	// Config hooks run before those the embedder added.
+	extensions := make([]services.Hook, 0, len(cfg.Hooks)+len(s.hooks))
+	for _, hc := range cfg.Hooks {
+		if len(hc.Command) > 0 {
+			extensions = append(extensions, hooks.NewCommand(hc.Command, hc.Hooks, hc.Timeout))
+		} else {
+			extensions = append(extensions, hooks.NewWebhook(hc.URL, hc.Hooks, hc.Timeout))
+		}
+	}
+	extensions = append(extensions, s.hooks...)
+
+	// Initialize the malware scanner, if any.
+	scanner := s.malware
+	switch {
+	case scanner != nil:
+	case cfg.Malware.ClamAV != "":
+		scanner = malware.NewClamAV(cfg.Malware.ClamAV, cfg.Malware.Timeout)
+	case len(cfg.Malware.Command) > 0:
+		scanner = malware.NewCommand(cfg.Malware.Command, cfg.Malware.Timeout)
+	}
+
+	// Validate already checked the trusted proxy list and the naming
+	// rules.
+	trustedProxies, _ := cfg.Server.TrustedProxyNetworks()
+	namingRules, _ := cfg.Naming.Rules()
+
+	// Initialize HTTP handlers.
+	opts := []handlers.Option{
+		handlers.WithDownloadRedirects(cfg.Downloads.Redirect, cfg.Downloads.RedirectTTL),
+		handlers.WithCaching(cfg.Cache.Public, cfg.Cache.DownloadMaxAge, cfg.Cache.ListingMaxAge),
+		handlers.WithTransferLimits(handlers.TransferLimits{
+			MaxConcurrentUploads:   cfg.Limits.MaxConcurrentUploads,
+			MaxConcurrentDownloads: cfg.Limits.MaxConcurrentDownloads,
+			UploadBytesPerSecond:   cfg.Limits.UploadBytesPerSecond,
+			DownloadBytesPerSecond: cfg.Limits.DownloadBytesPerSecond,
+			MaxExtractBytes:        cfg.Limits.MaxExtractBytes,
+			RetryAfter:             cfg.Limits.RetryAfter,
+		}),
+		handlers.WithGCLimits(handlers.GCLimits{
+			BatchSize:      cfg.GC.BatchSize,
+			BlobsPerSecond: cfg.GC.BlobsPerSecond,
+			MaxDuration:    cfg.GC.MaxDuration,
+		}),
+		handlers.WithBlobReclaim(cfg.GC.Reclaim),
+		handlers.WithDownloadLease(cfg.GC.DownloadLease),
+		handlers.WithSeverityBlock(cfg.Policy.BlockSeverity),
+		handlers.WithScanners(cfg.Policy.Scanners),
+		handlers.WithPolicy(policies),
+		handlers.WithHooks(extensions...),
+		handlers.WithQuarantine(cfg.Policy.Quarantine),
+		handlers.WithDefaultStage(cfg.Policy.DefaultStage),
+		handlers.WithRequireIfMatch(cfg.Policy.RequireIfMatch),
+		handlers.WithIdempotentUploads(cfg.Policy.IdempotentUploads),
+		handlers.WithBasePath(cfg.Server.BasePath),
+		handlers.WithTrustedProxies(trustedProxies),
+		handlers.WithTrustedSockets(cfg.Server.TrustedSockets()),
+		handlers.WithBLAKE3(cfg.Storage.BLAKE3),
+		handlers.WithStorageReserve(cfg.Storage.ReserveBytes),
+		handlers.WithImportDirs(cfg.Storage.ImportDirs),
+		handlers.WithNaming(namingRules),
+	}
+	if tokens != nil {
+		opts = append(opts, handlers.WithTokenStore(tokens))
+	}
+	if len(cfg.Auth.SigningKeys) > 0 {
+		s.signed = auth.NewSignedRequests(signingKeys(cfg.Auth.SigningKeys), cfg.Auth.ClockSkew, clock.System)
+		opts = append(opts, handlers.WithSignedRequests(s.signed))
+	}
+	if lo := cfg.Auth.Lockout; lo.MaxFailures > 0 {
+		opts = append(opts, handlers.WithLockouts(auth.NewLockouts(lo.MaxFailures, lo.Window, lo.Duration, clock.System)))
+	}
+	if c := cfg.Downloads.CDN; c.BaseURL != "" {
+		opts = append(opts, handlers.WithCDN(cdn.NewSigner(c.BaseURL, []byte(c.SigningKey), clock.System)))
+	}
+	if scanner != nil {
+		opts = append(opts, handlers.WithMalwareScanner(scanner))
+	}
+	peers := make([]services.PeerRegistry, 0, len(cfg.Federation.Peers)+len(s.peers))
+	for _, p := range cfg.Federation.Peers {
+		peers = append(peers, federation.NewPeer(p.Name, p.URL, p.Token))
+	}
+	peers = append(peers, s.peers...)
+	opts = append(opts, handlers.WithFederation(cfg.Federation.Name, peers, cfg.Federation.Timeout))
+	if accounts, ok := s.meta.(services.AccountStore); ok {
+		opts = append(opts, handlers.WithAccounts(accounts))
+	}
+	if comments, ok := s.meta.(services.CommentStore); ok {
+		opts = append(opts, handlers.WithComments(comments))
+	}
+	if ac := cfg.Approvals; len(ac.Packages) > 0 || ac.GCBytes > 0 {
+		store, ok := s.meta.(services.ApprovalStore)
+		if !ok {
+			return errors.New("approvals need a metadata store that keeps approvals")
+		}
+		opts = append(opts, handlers.WithApprovals(store, handlers.ApprovalRules{Packages: ac.Packages, GCBytes: ac.GCBytes}))
+	}
+	if crates, ok := s.meta.(services.CrateIndex); ok {
+		opts = append(opts, handlers.WithCrateIndex(crates))
+	}
+	if subs, ok := s.meta.(services.SubscriptionStore); ok {
+		nc := cfg.Notifications
+		allowed, _ := nc.WebhookNetworks() // checked by Config.Validate
+		opts = append(opts, handlers.WithNotifications(subs, notify.New(nc.Timeout, notify.SMTP{
+			Addr: nc.SMTP.Addr, From: nc.SMTP.From, Username: nc.SMTP.Username, Password: nc.SMTP.Password,
+		}, allowed)))
+	}
+	if cfg.Transcoding.Enabled {
+		cache, err := transcode.NewCache(cfg.Transcoding.CacheDir)
+		if err != nil {
+			return fmt.Errorf("initializing transcode cache: %w", err)
+		}
+		opts = append(opts, handlers.WithTranscodeCache(cache))
+	}
+	if ac := cfg.Attestations; len(ac.TrustedBuilders) > 0 {
+		builders := make([]handlers.TrustedBuilder, 0, len(ac.TrustedBuilders))
+		for _, b := range ac.TrustedBuilders {
+			data, err := os.ReadFile(b.PublicKey)
+			if err != nil {
+				return fmt.Errorf("reading key of trusted builder %s: %w", b.ID, err)
+			}
+			key, err := dsse.ParsePublicKey(data)
+			if err != nil {
+				return fmt.Errorf("key of trusted builder %s: %w", b.ID, err)
+			}
+			builders = append(builders, handlers.TrustedBuilder{ID: b.ID, Key: key})
+		}
+		opts = append(opts, handlers.WithTrustedBuilders(builders, ac.RequireVerified))
+	}
+	if tiers != nil {
+		opts = append(opts, handlers.WithTiering(tiers, handlers.TieringPolicy{
+			IdleAfter: time.Duration(cfg.Storage.Cold.IdleDays) * 24 * time.Hour,
+			Restore:   cfg.Storage.Cold.Restore,
+		}))
+		s.tiering = true
+	}
+	meta := s.meta
+	if len(snapshots) > 0 {
+		meta = metadata.NewLayeredStore(s.meta, snapshots...)
+	}
+	s.handler = handlers.New(s.blobs, meta, authenticator, s.logger, opts...)
+	return nil
+}
+
+// Handler returns the registry's routes, all of them, for serving from the
+// embedder's own HTTP server instead of Start.
+func (s *Server) Handler() http.Handler {
+	return s.handler.Router()
+}
+
+// Start opens every configured listener, serves each in the background and
+// starts the background jobs: expiry, tiering, lazy reclaim, temp file
+// cleanup, token usage flushes and auth secret refreshes. If any listener
+// fails to open, none is served. A server starts once.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started || s.stopped {
+		return errors.New("server already started")
+	}
+
+	// Open every listener before serving any, so a bad address fails
+	// startup instead of leaving the server half up.
+	listeners := make([]net.Listener, 0, len(s.cfg.Server.Listeners))
+	for _, l := range s.cfg.Server.Listeners {
+		ln, err := listen(l)
+		if err != nil {
+			for _, open := range listeners {
+				open.Close()
+			}
+			return fmt.Errorf("listening on %s: %w", l.Address, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	s.started = true
+	s.listeners = listeners
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopJobs = cancel
+	if s.cfg.Expiry.SweepInterval > 0 {
+		s.goJob(func() { s.handler.RunExpiry(ctx, s.cfg.Expiry.SweepInterval) })
+	}
+	if s.tiering {
+		s.goJob(func() { s.handler.RunTiering(ctx, s.cfg.Storage.Cold.SweepInterval) })
+	}
+	if s.cfg.GC.Reclaim == handlers.ReclaimLazy {
+		s.goJob(func() { s.handler.RunReclaim(ctx, s.cfg.GC.ReclaimInterval) })
+	}
+	if tc := s.cfg.Storage.TempCleanup; tc.MaxAge > 0 {
+		s.goJob(func() { s.handler.RunTempCleanup(ctx, tc.Interval, tc.MaxAge) })
+	}
+	s.goJob(func() { s.handler.RunTokenUsageFlush(ctx, s.cfg.Auth.UsageFlushInterval) })
+	if s.cfg.Auth.SecretRefresh > 0 {
+		s.goJob(func() { s.runSecretRefresh(ctx, s.cfg.Auth.SecretRefresh) })
+	}
+
+	s.errCh = make(chan error, len(listeners))
+	for i, ln := range listeners {
+		l := s.cfg.Server.Listeners[i]
+		srv := &http.Server{Handler: s.handler.RouterFor(l.Routes)}
+		s.servers = append(s.servers, srv)
+		s.logger.Info().Str("addr", l.Address).Str("routes", l.Routes).Msg("starting Foundry Registry server")
+		go func(ln net.Listener) {
+			if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+				s.errCh <- err
+			}
+		}(ln)
+	}
+	return nil
+}
+
+// Addrs returns the addresses the server listens on once started, which
+// tells the port chosen for a listener configured with port 0.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, ln := range s.listeners {
+		addrs = append(addrs, ln.Addr())
+	}
+	return addrs
+}
+
+// Wait blocks until a listener stops with an error, which it returns, or
+// until Shutdown is called, when it returns nil.
+func (s *Server) Wait() error {
+	s.mu.Lock()
+	errCh := s.errCh
+	s.mu.Unlock()
+	select {
+	case err := <-errCh:
+		return err
+	case <-s.done:
+		return nil
+	}
+}
+
+// goJob runs fn in the background, counted in s.jobs.
+func (s *Server) goJob(fn func()) {
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		fn()
+	}()
+}
+
+// Shutdown stops the background jobs, lets in-flight requests finish until
+// ctx ends, cancels running garbage collection jobs and waits for them and
+// the background jobs to stop, records the token usage not yet written,
+// then closes the listeners and the stores New opened. If ctx ends before
+// the jobs stop, the stores are left open for them and ctx's error is
+// returned. It can be called without Start to release a server that never
+// ran.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return nil
+	}
+	s.stopped = true
+	close(s.done)
+	s.logger.Info().Msg("shutting down server")
+
+	if s.stopJobs != nil {
+		s.stopJobs()
+	}
+	var shutdownErr error
+	for _, srv := range s.servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+			if shutdownErr == nil {
+				shutdownErr = fmt.Errorf("shutting down: %w", err)
+			}
+		}
+	}
+	if err := s.waitJobs(ctx); err != nil {
+		s.logger.Error().Err(err).Msg("background jobs still running; leaving stores open")
+		if shutdownErr == nil {
+			shutdownErr = err
+		}
+		return shutdownErr
+	}
+	if err := s.handler.FlushTokenUsage(); err != nil {
+		s.logger.Error().Err(err).Msg("recording token usage")
+	}
+	if err := s.release(); err != nil && shutdownErr == nil {
+		shutdownErr = err
+	}
+	return shutdownErr
+}
+
+// waitJobs cancels the running garbage collection jobs and waits until
+// they and the background jobs Start began have stopped, or ctx ends.
+func (s *Server) waitJobs(ctx context.Context) error {
+	if err := s.handler.StopJobs(ctx); err != nil {
+		return fmt.Errorf("stopping jobs: %w", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.jobs.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("stopping background jobs: %w", ctx.Err())
+	}
+}
+
+// release closes what New opened.
+func (s *Server) release() error {
+	var err error
+	if s.ownMeta != nil {
+		if closeErr := s.ownMeta.Close(); closeErr != nil {
+			err = fmt.Errorf("closing metadata store: %w", closeErr)
+		}
+		s.ownMeta = nil
+	}
+	for _, snap := range s.snapshots {
+		if closeErr := snap.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("closing snapshot: %w", closeErr)
+		}
+	}
+	s.snapshots = nil
+	if s.lock != nil {
+		s.lock.Release()
+		s.lock = nil
+	}
+	return err
+}
+
+// runSecretRefresh reads the auth secrets again every interval until ctx
+// is done, so rotated tokens and signing keys take effect without a
+// restart. A refresh that fails, or that finds no tokens where there
+// were some, keeps the secrets last read.
+func (s *Server) runSecretRefresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	resolver := &secrets.Resolver{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tokens, keys, err := s.authSecrets.ResolveSecrets(ctx, resolver)
+			if err == nil && len(tokens) == 0 && len(s.authSecrets.Tokens) > 0 {
+				err = errors.New("auth.tokens: no tokens read")
+			}
+			if err != nil {
+				s.logger.Error().Err(err).Msg("refreshing auth secrets")
+				continue
+			}
+			s.tokenAuth.SetTokens(tokens)
+			if s.signed != nil {
+				s.signed.SetKeys(signingKeys(keys))
+			}
+		}
+	}
+}
+
+// signingKeys converts configured signing keys for auth.
+func signingKeys(cfg []config.SigningKeyConfig) []auth.SigningKey {
+	keys := make([]auth.SigningKey, 0, len(cfg))
+	for _, k := range cfg {
+		keys = append(keys, auth.SigningKey{ID: k.ID, Secret: []byte(k.Secret), Admin: k.Admin})
+	}
+	return keys
+}
@@ -0,0 +1,318 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foundry/registry/internal/adapters/metadata"
+	"github.com/foundry/registry/internal/adapters/storage"
+	"github.com/foundry/registry/internal/core/models"
+	"github.com/foundry/registry/pkg/server"
+)
+
+func testConfig(t *testing.T) server.Config {
+	cfg := server.DefaultConfig()
+	cfg.Storage.DataDir = t.TempDir()
+	cfg.Auth.Tokens = []string{"secret"}
+	cfg.Server.Listeners = []server.ListenerConfig{{Address: "127.0.0.1:0"}}
+	return *cfg
+}
+
+func do(t *testing.T, method, url, token string, body io.Reader) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, body)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	return resp
+}
+
+func TestStartServeShutdown(t *testing.T) {
+	cfg := testConfig(t)
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := server.New(cfg); err == nil {
+		t.Error("a second server should not open the same data directory")
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	base := "http://" + srv.Addrs()[0].String() + "/api/v1/artifacts/app/1.0.0"
+
+	resp := do(t, http.MethodPost, base, "secret", strings.NewReader("binary"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload: %s", resp.Status)
+	}
+	resp = do(t, http.MethodGet, base, "secret", nil)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "binary" {
+		t.Errorf("download = %q", body)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Wait after Shutdown = %v", err)
+	}
+	again, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("New after Shutdown should reopen the data directory: %v", err)
+	}
+	again.Shutdown(context.Background())
+}
+
+type allowAll struct{}
+
+func (allowAll) Authenticate(token string) (*models.Principal, bool) {
+	return &models.Principal{Name: token, Admin: true}, true
+}
+
+func TestCustomAdapters(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Auth.Tokens = nil
+	if _, err := server.New(cfg); err == nil {
+		t.Fatal("expected an error without tokens or an authenticator")
+	}
+
+	blobs := storage.NewMemoryBlobStorage()
+	srv, err := server.New(cfg,
+		server.WithBlobStorage(blobs),
+		server.WithMetadataStore(metadata.NewMemoryStore()),
+		server.WithAuthenticator(allowAll{}),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer srv.Shutdown(context.Background())
+
+	// Handler serves the routes without Start.
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/artifacts/app/1.0.0", strings.NewReader("binary"))
+	req.Header.Set("Authorization", "Bearer anyone")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rec.Code, rec.Body)
+	}
+	if listed, _ := blobs.ListBlobs(); len(listed) != 1 {
+		t.Errorf("blob should be in the supplied storage, got %v", listed)
+	}
+}
+
+func TestRegisteredStorageBackend(t *testing.T) {
+	blobs := storage.NewMemoryBlobStorage()
+	var options map[string]string
+	server.RegisterStorage("test-backend", func(cfg server.StorageBackendConfig) (server.BlobStorage, error) {
+		options = cfg.Options
+		return blobs, nil
+	})
+
+	cfg := testConfig(t)
+	cfg.Storage.Backend = "test-backend"
+	cfg.Storage.Options = map[string]string{"pool": "artifacts"}
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer srv.Shutdown(context.Background())
+	if options["pool"] != "artifacts" {
+		t.Errorf("backend options = %v", options)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/artifacts/app/1.0.0", strings.NewReader("binary"))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if listed, _ := blobs.ListBlobs(); rec.Code != http.StatusCreated || len(listed) != 1 {
+		t.Errorf("upload: %d, blobs %v; want the blob in the registered backend", rec.Code, listed)
+	}
+
+	cfg = testConfig(t)
+	cfg.Storage.Backend = "disk"
+	cfg.Storage.Chunking.Enabled = true
+	if _, err := server.New(cfg); err == nil {
+		t.Error("expected an error enabling chunking on the disk backend")
+	}
+}
+
+type denyDeletes struct{}
+
+func (denyDeletes) Run(_ context.Context, event server.HookEvent) error {
+	if event.Hook == server.HookPreDelete {
+		return fmt.Errorf("%w: deletes are frozen", server.ErrHookRejected)
+	}
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Hooks = []server.HookConfig{{
+		Hooks:   []string{server.HookPreUpload},
+		Command: []string{"sh", "-c", `grep -q '"package":"blocked"' && echo "not approved" >&2 && exit 1; exit 0`},
+	}}
+	srv, err := server.New(cfg, server.WithHook(denyDeletes{}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer srv.Shutdown(context.Background())
+
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPost, "/api/v1/artifacts/blocked/1.0.0", http.StatusForbidden},
+		{http.MethodPost, "/api/v1/artifacts/app/1.0.0", http.StatusCreated},
+		{http.MethodDelete, "/api/v1/artifacts/app/1.0.0", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader("binary"))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s %s: %d %s, want %d", tc.method, tc.path, rec.Code, rec.Body, tc.want)
+		}
+	}
+}
+
+func TestSecretReferences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("first\nsecond\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig(t)
+	cfg.Auth.Tokens = []string{"file://" + path}
+	cfg.Auth.SecretRefresh = 10 * time.Millisecond
+
+	bad := cfg
+	bad.Auth.Tokens = []string{"env://FOUNDRY_TEST_UNSET_TOKEN"}
+	if _, err := server.New(bad); err == nil {
+		t.Error("an unresolvable secret should fail New")
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer srv.Shutdown(context.Background())
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	url := "http://" + srv.Addrs()[0].String() + "/api/v1/artifacts"
+	status := func(token string) int {
+		resp := do(t, http.MethodGet, url, token, nil)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, token := range []string{"first", "second"} {
+		if got := status(token); got != http.StatusOK {
+			t.Errorf("token %q from the file: %d", token, got)
+		}
+	}
+
+	// Rotating the file swaps the tokens on the next refresh.
+	if err := os.WriteFile(path, []byte("third\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for status("third") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("rotated token was never accepted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := status("first"); got != http.StatusUnauthorized {
+		t.Errorf("rotated out token: %d", got)
+	}
+}
+
+func TestImport(t *testing.T) {
+	share := t.TempDir()
+	os.WriteFile(filepath.Join(share, "app.tar.gz"), []byte("from the share"), 0o644)
+	cfg := testConfig(t)
+	cfg.Storage.ImportDirs = []string{share}
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// No listener is needed.
+	res, err := srv.Import(context.Background(), "app", "1.0.0", server.ImportRequest{Path: filepath.Join(share, "app.tar.gz")})
+	if err != nil || res.Import == models.ImportHardLink || res.Size != 14 {
+		t.Fatalf("Import: %+v, %v", res, err)
+	}
+	if _, err := srv.Import(context.Background(), "app", "1.0.1", server.ImportRequest{Path: filepath.Join(share, "missing")}); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+	srv.Shutdown(context.Background())
+
+	// The version is there once the server runs.
+	srv, err = server.New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer srv.Shutdown(context.Background())
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	resp := do(t, http.MethodGet, "http://"+srv.Addrs()[0].String()+"/api/v1/artifacts/app/1.0.0", "secret", nil)
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != "from the share" {
+		t.Errorf("download after import: %s %q", resp.Status, data)
+	}
+}
+
+func TestShutdownStopsJobs(t *testing.T) {
+	cfg := testConfig(t)
+	// Paced at one blob a second, collecting three blobs takes a while.
+	cfg.GC.BatchSize = 1
+	cfg.GC.BlobsPerSecond = 1
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	base := "http://" + srv.Addrs()[0].String() + "/api/v1"
+	for _, v := range []string{"1", "2", "3"} {
+		do(t, http.MethodPost, base+"/artifacts/app/"+v, "secret", strings.NewReader("content "+v)).Body.Close()
+		do(t, http.MethodDelete, base+"/artifacts/app/"+v, "secret", nil).Body.Close()
+	}
+	resp := do(t, http.MethodPost, base+"/gc?async=true", "secret", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("async gc: %s", resp.Status)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Shutdown took %v; the job should have been canceled", elapsed)
+	}
+	again, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("New after Shutdown: %v", err)
+	}
+	again.Shutdown(context.Background())
+}